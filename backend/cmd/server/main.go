@@ -2,19 +2,45 @@ package main
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/cstanislawski/qualifyd/internal/ws"
+	"github.com/cstanislawski/qualifyd/pkg/apikey"
+	"github.com/cstanislawski/qualifyd/pkg/audit"
 	"github.com/cstanislawski/qualifyd/pkg/auth"
+	"github.com/cstanislawski/qualifyd/pkg/billing"
+	"github.com/cstanislawski/qualifyd/pkg/blob"
 	"github.com/cstanislawski/qualifyd/pkg/config"
 	"github.com/cstanislawski/qualifyd/pkg/database"
+	"github.com/cstanislawski/qualifyd/pkg/events"
 	"github.com/cstanislawski/qualifyd/pkg/handler"
+	"github.com/cstanislawski/qualifyd/pkg/invoice"
+	"github.com/cstanislawski/qualifyd/pkg/jobs"
 	"github.com/cstanislawski/qualifyd/pkg/k8s"
 	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/metering"
+	"github.com/cstanislawski/qualifyd/pkg/metrics"
+	"github.com/cstanislawski/qualifyd/pkg/mfa"
 	localmiddleware "github.com/cstanislawski/qualifyd/pkg/middleware"
 	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/cstanislawski/qualifyd/pkg/notification"
+	"github.com/cstanislawski/qualifyd/pkg/oidc"
+	"github.com/cstanislawski/qualifyd/pkg/quota"
+	"github.com/cstanislawski/qualifyd/pkg/rabbitmq"
+	"github.com/cstanislawski/qualifyd/pkg/ratelimit"
+	"github.com/cstanislawski/qualifyd/pkg/rbac"
 	"github.com/cstanislawski/qualifyd/pkg/repository"
+	"github.com/cstanislawski/qualifyd/pkg/scheduler"
+	"github.com/cstanislawski/qualifyd/pkg/session"
+	"github.com/cstanislawski/qualifyd/pkg/subscription"
+	"github.com/cstanislawski/qualifyd/pkg/tracing"
+	"github.com/cstanislawski/qualifyd/pkg/webhook"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/rs/zerolog"
@@ -45,6 +71,14 @@ func main() {
 	// Load configuration
 	cfg := config.Load()
 
+	// Initialize tracing
+	shutdownTracing, err := tracing.Init(context.Background(), &cfg.Tracing)
+	if err != nil {
+		log.Fatal("Failed to initialize tracing", err, map[string]interface{}{
+			"exporter": cfg.Tracing.Exporter,
+		})
+	}
+
 	// Initialize database
 	db, err := database.New(context.Background(), &cfg.Database)
 	if err != nil {
@@ -82,33 +116,218 @@ func main() {
 	// Initialize repositories
 	userRepo := repository.NewUserRepository(db)
 	orgRepo := repository.NewOrganizationRepository(db)
+	quotaRepo := repository.NewQuotaRepository(db)
 	taskRepo := repository.NewTaskRepository(db.Pool())
 	envRepo := repository.NewEnvironmentRepository(db.Pool())
 	assessmentRepo := repository.NewAssessmentRepository(db.Pool())
+	usageRepo := repository.NewUsageRepository(db)
+	billingRepo := repository.NewBillingRepository(db)
+	webhookRepo := repository.NewWebhookRepository(db)
+	outboxRepo := repository.NewOutboxRepository(db)
+	jobRepo := repository.NewJobRepository(db)
+	emailRepo := repository.NewEmailRepository(db)
+	magicLinkRepo := repository.NewMagicLinkRepository(db)
+	passwordResetRepo := repository.NewPasswordResetRepository(db)
+	sessionRepo := repository.NewSessionRepository(db)
+	mfaRepo := repository.NewMFARepository(db)
+	apiKeyRepo := repository.NewAPIKeyRepository(db)
+	roleRepo := repository.NewRoleRepository(db)
+	auditRepo := repository.NewAuditRepository(db)
+	ssoRepo := repository.NewSSORepository(db)
+
+	// Initialize quota enforcement
+	quotaService := quota.NewService(db, orgRepo, quotaRepo, assessmentRepo, log)
+
+	// Initialize environment-minute metering; it also bills pods deleted through k8sClient
+	meter := metering.NewMeter(db, k8sClient, usageRepo, quotaRepo, assessmentRepo, &cfg.Metering, log)
+
+	// Initialize billing
+	paymentProvider, err := billing.NewProvider(&cfg.Billing)
+	if err != nil {
+		log.Fatal("Failed to initialize payment provider", err, map[string]interface{}{
+			"provider": cfg.Billing.Provider,
+		})
+	}
+	if cfg.Billing.Provider == "fake" {
+		log.Warn("Using the fake payment provider; no real charges are made", nil)
+	}
+	billingService := billing.NewService(orgRepo, billingRepo, quotaRepo, paymentProvider, &cfg.Billing, log)
+
+	planService := subscription.NewPlanService(db, orgRepo, quotaRepo, billingService, log)
+
+	// Initialize statement generation
+	blobStore, err := blob.NewFileStore(cfg.Blob.Dir)
+	if err != nil {
+		log.Fatal("Failed to initialize blob store", err, map[string]interface{}{
+			"dir": cfg.Blob.Dir,
+		})
+	}
+	statements := invoice.NewGenerator(orgRepo, billingRepo, usageRepo, quotaRepo, blobStore, &cfg.Billing, log)
+
+	// Initialize scheduled jobs
+	scheduled := scheduler.New(db, log)
+	scheduled.Register("statements", cfg.Billing.StatementInterval, statements.GenerateDue)
+
+	// Initialize RabbitMQ
+	mq := rabbitmq.New(&cfg.RabbitMQ)
+	if err := mq.Connect(); err != nil {
+		log.Fatal("Failed to connect to RabbitMQ", err, map[string]interface{}{
+			"host": cfg.RabbitMQ.Host,
+		})
+	}
+	defer mq.Close()
+
+	// Initialize the domain event bus. Repositories store events in the
+	// outbox, and the relay publishes them to the events exchange.
+	eventChannel, err := mq.NewChannel("events")
+	if err != nil {
+		log.Fatal("Failed to open event channel", err, nil)
+	}
+	if err := events.DeclareExchange(eventChannel); err != nil {
+		log.Fatal("Failed to declare event exchange", err, nil)
+	}
+	relay := events.NewRelay(outboxRepo, events.NewChannelPublisher(eventChannel), cfg.Events.RelayBatchSize, cfg.Events.Retention, log)
+	scheduled.Register("outbox-cleanup", cfg.Events.CleanupInterval, relay.Cleanup)
+
+	// Initialize webhook delivery. Publishing and consuming use separate
+	// channels so that publisher confirms are not held up by deliveries.
+	webhookChannel, err := mq.NewChannel("webhooks")
+	if err != nil {
+		log.Fatal("Failed to open webhook channel", err, nil)
+	}
+	webhookQueues, err := webhook.Setup(webhookChannel, cfg.Webhook.MaxAttempts, cfg.Webhook.RetryBaseDelay)
+	if err != nil {
+		log.Fatal("Failed to declare webhook queues", err, nil)
+	}
+	if err := events.DeclareQueue(webhookChannel, webhook.EventsQueue, "assessment.*", "task.*", "review.*"); err != nil {
+		log.Fatal("Failed to declare webhook event queue", err, nil)
+	}
+	webhookDispatcher := webhook.NewDispatcher(webhookRepo, webhookQueues, log)
+	webhookDeliverer := webhook.NewDeliverer(webhookRepo, webhookQueues, &cfg.Webhook, log)
+	webhookSubscriber := events.NewSubscriber("webhooks", outboxRepo, webhookDispatcher.HandleEvent, log)
+
+	// Initialize the job queue. Jobs are run by the worker (cmd/worker); the
+	// API server only enqueues them and republishes lost ones.
+	jobChannel, err := mq.NewChannel("jobs")
+	if err != nil {
+		log.Fatal("Failed to open job channel", err, nil)
+	}
+	jobRegistry := jobs.NewRegistry(append(jobs.Definitions(), notification.EmailJob)...)
+	jobQueues, err := jobs.Setup(jobChannel, jobRegistry)
+	if err != nil {
+		log.Fatal("Failed to declare job queues", err, nil)
+	}
+	jobClient := jobs.NewClient(jobRepo, jobRegistry, jobQueues, cfg.Jobs.StaleAfter, log)
+	scheduled.Register("job-requeue", cfg.Jobs.StaleAfter, jobClient.RequeueStale)
 
 	// Initialize authentication service
 	authService := auth.New(&cfg.JWT)
+	sessionService := session.NewService(sessionRepo, userRepo, authService, log)
+	// Ended sessions are kept a while so users and admins can tell what
+	// happened to them
+	scheduled.Register("session-cleanup", cfg.Session.CleanupInterval, func(ctx context.Context) error {
+		return sessionRepo.DeleteExpired(ctx, time.Now().AddDate(0, 0, -30))
+	})
+	scheduled.Register("sso-state-cleanup", cfg.SSO.StateCleanupInterval, func(ctx context.Context) error {
+		return ssoRepo.DeleteExpiredLoginStates(ctx, time.Now())
+	})
+	apiKeyService := apikey.NewService(apiKeyRepo, log)
+	rbacService := rbac.NewService(roleRepo, &cfg.RBAC, log)
+	auditService := audit.NewService(auditRepo, log)
+
+	// Initialize rate limiting. Replicas only share limits kept in Postgres.
+	var rateLimitStore ratelimit.Store
+	switch cfg.RateLimit.Store {
+	case "memory":
+		rateLimitStore = ratelimit.NewMemoryStore()
+	case "postgres":
+		rateLimitStore = repository.NewRateLimitRepository(db)
+	default:
+		log.Fatal("Unknown rate limit store", nil, map[string]interface{}{
+			"store": cfg.RateLimit.Store,
+		})
+	}
+	limiter := ratelimit.NewLimiter(rateLimitStore, &cfg.RateLimit, log)
+	scheduled.Register("rate-limit-cleanup", cfg.RateLimit.CleanupInterval, limiter.Cleanup)
+
+	mfaService := mfa.NewService(mfaRepo, authService, &cfg.MFA, log)
+	scheduled.Register("mfa-challenge-cleanup", cfg.MFA.ChallengeCleanupInterval, func(ctx context.Context) error {
+		return mfaService.DeleteExpiredChallenges(ctx, time.Now())
+	})
+
+	// Initialize email notifications. Emails are rendered here and sent by
+	// the worker's email job.
+	notifications := notification.NewService(orgRepo, userRepo, assessmentRepo, emailRepo, magicLinkRepo, authService, jobClient, &cfg.Email, log)
+	if err := events.DeclareQueue(eventChannel, notification.EventsQueue, notification.EventTypes...); err != nil {
+		log.Fatal("Failed to declare notification event queue", err, nil)
+	}
+	notificationSubscriber := events.NewSubscriber("notifications", outboxRepo, notifications.HandleEvent, log)
+	scheduled.Register("assessment-reminders", cfg.Email.ReminderInterval, notifications.SendReminders)
+	scheduled.Register("password-reset-cleanup", cfg.PasswordReset.CleanupInterval, func(ctx context.Context) error {
+		return passwordResetRepo.DeleteBefore(ctx, time.Now().Add(-cfg.PasswordReset.Window))
+	})
+
+	lifecycle := subscription.NewLifecycle(orgRepo, quotaRepo, notifications, billingService, &cfg.Subscription, log)
+	scheduled.Register("quota-reset", cfg.Subscription.CheckInterval, lifecycle.ResetQuotas)
+	scheduled.Register("subscription-lifecycle", cfg.Subscription.CheckInterval, lifecycle.AdvanceSubscriptions)
+
+	consumerChannel, err := mq.NewChannel("consumers")
+	if err != nil {
+		log.Fatal("Failed to open consumer channel", err, nil)
+	}
+	webhookEventConsumer, err := consumerChannel.NewConsumer(webhook.EventsQueue, "webhook-dispatcher", false)
+	if err != nil {
+		log.Fatal("Failed to create webhook event consumer", err, nil)
+	}
+	webhookConsumer, err := consumerChannel.NewConsumer(webhook.DeliveriesQueue, "webhook-deliverer", false)
+	if err != nil {
+		log.Fatal("Failed to create webhook consumer", err, nil)
+	}
+	notificationConsumer, err := consumerChannel.NewConsumer(notification.EventsQueue, "notifications", false)
+	if err != nil {
+		log.Fatal("Failed to create notification event consumer", err, nil)
+	}
 
 	// Initialize handlers
-	authHandler := handler.NewAuthHandler(userRepo, orgRepo, authService, log)
-	setupHandler := handler.NewSetupHandler(userRepo, orgRepo, authService, log)
-	userHandler := handler.NewUserHandler(userRepo, orgRepo, authService, log)
-	taskHandler := handler.NewTaskHandler(taskRepo, log)
-	envHandler := handler.NewEnvironmentHandler(envRepo, log)
-	assessmentHandler := handler.NewAssessmentHandler(assessmentRepo, taskRepo, envRepo, log)
-	assessmentTemplateHandler := handler.NewAssessmentTemplateHandler(assessmentRepo, envRepo, taskRepo, log)
+	authHandler := handler.NewAuthHandler(userRepo, orgRepo, magicLinkRepo, assessmentRepo, passwordResetRepo, ssoRepo, authService, sessionService, mfaService, notifications, auditService, limiter, &cfg.PasswordReset, log)
+	setupHandler := handler.NewSetupHandler(userRepo, orgRepo, sessionService, log)
+	sessionHandler := handler.NewSessionHandler(sessionService, userRepo, auditService, log)
+	mfaHandler := handler.NewMFAHandler(mfaService, userRepo, orgRepo, sessionService, auditService, limiter, log)
+	apiKeyHandler := handler.NewAPIKeyHandler(orgRepo, apiKeyService, auditService, log)
+	roleHandler := handler.NewRoleHandler(orgRepo, userRepo, rbacService, auditService, log)
+	auditHandler := handler.NewAuditHandler(orgRepo, auditService, log)
+	ssoHandler := handler.NewSSOHandler(ssoRepo, orgRepo, userRepo, quotaService, sessionService, mfaService, auditService, oidc.NewClient(cfg.SSO.HTTPTimeout), &cfg.SSO, log)
+	userHandler := handler.NewUserHandler(userRepo, orgRepo, quotaService, authService, notifications, sessionService, auditService, log)
+	organizationHandler := handler.NewOrganizationHandler(orgRepo, userRepo, quotaRepo, auditService, log)
+	planHandler := handler.NewPlanHandler(orgRepo, quotaRepo, planService, log)
+	billingHandler := handler.NewBillingHandler(orgRepo, billingRepo, billingService, statements, log)
+	taskHandler := handler.NewTaskHandler(taskRepo, quotaService, auditService, log)
+	envHandler := handler.NewEnvironmentHandler(envRepo, quotaService, auditService, log)
+	assessmentHandler := handler.NewAssessmentHandler(assessmentRepo, taskRepo, envRepo, userRepo, quotaService, notifications, auditService, log)
+	webhookHandler := handler.NewWebhookHandler(orgRepo, webhookRepo, webhookDispatcher, auditService, log)
+	jobHandler := handler.NewJobHandler(orgRepo, jobRepo, log)
+	emailTemplateHandler := handler.NewEmailTemplateHandler(orgRepo, emailRepo, notifications, log)
+	assessmentTemplateHandler := handler.NewAssessmentTemplateHandler(assessmentRepo, envRepo, taskRepo, quotaService, auditService, log)
 
 	// Initialize websocket hub
 	terminalHub := ws.NewTerminalHub()
 	terminalHub.K8sClient = k8sClient // Pass the K8s client to the hub
+	terminalHub.Quotas = quotaService // Refuse new terminal pods beyond the organization's quota
+	if cfg.Jobs.ProvisionTerminals {
+		terminalHub.Provisioner = jobs.NewTerminalProvisioner(jobClient, assessmentRepo)
+	}
 	go terminalHub.Run()
+	terminalHandler := handler.NewTerminalHandler(assessmentRepo, terminalHub, log)
 
 	// Initialize middleware
 	setupMiddleware := localmiddleware.NewSetupMiddleware(userRepo, log)
+	authz := localmiddleware.NewAuthorizer(rbacService)
+	rateLimits := localmiddleware.NewRateLimits(limiter, auditService, &cfg.RateLimit)
 
 	r := chi.NewRouter()
 
 	// Middleware
+	r.Use(localmiddleware.TracingMiddleware)
 	r.Use(localmiddleware.HTTPMiddleware)
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.AllowContentType("application/json", "text/plain"))
@@ -122,115 +341,341 @@ func main() {
 	// Health check endpoint
 	r.Get("/health", healthCheckHandler)
 
+	// Prometheus metrics endpoint
+	r.Handle("/metrics", metrics.Handler())
+
 	// API routes
 	r.Route("/api", func(r chi.Router) {
 		// Initial setup endpoint (only accessible when no users exist)
-		r.Post("/setup/initialize", setupMiddleware.CheckInitialSetup(http.HandlerFunc(setupHandler.HandleInitialSetup)).ServeHTTP)
+		r.With(rateLimits.Limit("setup", localmiddleware.JSONField("admin_email"))).
+			Post("/setup/initialize", setupMiddleware.CheckInitialSetup(http.HandlerFunc(setupHandler.HandleInitialSetup)).ServeHTTP)
 
 		// Authentication API routes
-		r.Post("/login", authHandler.Login)
-		r.Post("/register", authHandler.Register)
-		r.Post("/refresh-token", authHandler.RefreshToken)
-		r.Post("/accept-invitation", authHandler.HandleAcceptInvitation)
+		r.With(rateLimits.Limit("login", localmiddleware.JSONField("email"))).Post("/login", authHandler.Login)
+		r.With(rateLimits.Limit("register", localmiddleware.JSONField("email"))).Post("/register", authHandler.Register)
+		r.With(rateLimits.Limit("refresh-token", localmiddleware.JSONField("refresh_token"))).Post("/refresh-token", authHandler.RefreshToken)
+		r.With(rateLimits.Limit("accept-invitation", localmiddleware.JSONField("invitation_token"))).
+			Post("/accept-invitation", authHandler.HandleAcceptInvitation)
+		r.With(rateLimits.Limit("magic-link", localmiddleware.JSONField("token"))).Post("/magic-link", authHandler.HandleMagicLink)
+		r.With(rateLimits.Limit("forgot-password", localmiddleware.JSONField("email"))).Post("/forgot-password", authHandler.HandleForgotPassword)
+		r.With(rateLimits.Limit("reset-password", localmiddleware.JSONField("token"))).Post("/reset-password", authHandler.HandleResetPassword)
+		// Second step of signing in, authenticated by the MFA challenge token
+		r.With(rateLimits.Limit("mfa-challenge-verify", localmiddleware.JSONField("mfa_token"))).
+			Post("/mfa/challenge/verify", mfaHandler.HandleVerifyChallenge)
+		r.With(rateLimits.Limit("mfa-challenge-enroll", localmiddleware.JSONField("mfa_token"))).
+			Post("/mfa/challenge/enroll", mfaHandler.HandleChallengeEnroll)
+		r.With(rateLimits.Limit("mfa-challenge-confirm", localmiddleware.JSONField("mfa_token"))).
+			Post("/mfa/challenge/enroll/confirm", mfaHandler.HandleChallengeConfirmEnrollment)
+		r.With(rateLimits.Limit("sso-oidc-start", localmiddleware.JSONField("email"))).Post("/sso/oidc/start", ssoHandler.HandleStartOIDCLogin)
+		r.With(rateLimits.Limit("sso-oidc-callback", localmiddleware.JSONField("state"))).Post("/sso/oidc/callback", ssoHandler.HandleOIDCCallback)
+		r.With(rateLimits.Limit("sso-saml-start", localmiddleware.JSONField("email"))).Post("/sso/saml/start", ssoHandler.HandleStartSAMLLogin)
+		r.With(rateLimits.Limit("sso-saml-exchange", localmiddleware.JSONField("code"))).Post("/sso/saml/exchange", ssoHandler.HandleSAMLExchange)
+		r.Get("/sso/saml/{org_id}/metadata", ssoHandler.HandleSAMLMetadata)
+		// Posted by the user's browser from the identity provider, verified by
+		// the response signature
+		r.With(rateLimits.Limit("sso-saml-acs", nil)).Post("/sso/saml/{org_id}/acs", ssoHandler.HandleSAMLACS)
+
+		// Payment provider callbacks, authenticated by their signature
+		r.Post("/billing/webhook", billingHandler.HandleWebhook)
 
 		// Protected routes
 		r.Group(func(r chi.Router) {
-			r.Use(localmiddleware.AuthMiddleware(authService))
+			r.Use(localmiddleware.AuthMiddleware(authService, sessionService, apiKeyService))
 			r.Use(setupMiddleware.RequireSetupCompleted)
 
-			// User Management routes (Admin only)
+			// Sessions of the caller
+			r.Post("/logout", sessionHandler.HandleLogout)
+			r.Route("/sessions", func(r chi.Router) {
+				r.Get("/", sessionHandler.HandleListSessions)
+				r.Delete("/", sessionHandler.HandleRevokeOtherSessions)
+				r.Delete("/{session_id}", sessionHandler.HandleRevokeSession)
+			})
+
+			// Multi-factor authentication of the caller
+			r.Route("/mfa", func(r chi.Router) {
+				r.Get("/", mfaHandler.HandleGetStatus)
+				r.Delete("/", mfaHandler.HandleDisable)
+				r.Post("/enroll", mfaHandler.HandleEnroll)
+				r.Post("/enroll/confirm", mfaHandler.HandleConfirmEnrollment)
+				r.Post("/recovery-codes", mfaHandler.HandleRegenerateRecoveryCodes)
+			})
+
+			// User Management routes
 			r.Route("/admin/users", func(r chi.Router) {
-				r.Use(localmiddleware.RequireRole(model.RoleAdmin))
-				r.Post("/", userHandler.HandleCreateUser)
-				r.Get("/", userHandler.HandleListUsers)
-				r.Get("/{user_id}", userHandler.HandleGetUser)
-				r.Put("/{user_id}", userHandler.HandleUpdateUser)
-				r.Delete("/{user_id}", userHandler.HandleDeleteUser)
+				r.With(authz.Require(model.PermissionUsersRead)).Get("/", userHandler.HandleListUsers)
+				r.With(authz.Require(model.PermissionUsersRead)).Get("/{user_id}", userHandler.HandleGetUser)
+				r.Group(func(r chi.Router) {
+					r.Use(authz.Require(model.PermissionUsersManage))
+					r.Post("/", userHandler.HandleCreateUser)
+					r.Put("/{user_id}", userHandler.HandleUpdateUser)
+					r.Delete("/{user_id}", userHandler.HandleDeleteUser)
+					r.Post("/{user_id}/logout", sessionHandler.HandleRevokeUserSessions)
+					r.Post("/{user_id}/mfa/reset", mfaHandler.HandleResetUserMFA)
+				})
+				r.Group(func(r chi.Router) {
+					r.Use(authz.Require(model.PermissionRolesManage))
+					r.Get("/{user_id}/roles", roleHandler.HandleListUserRoles)
+					r.Post("/{user_id}/roles", roleHandler.HandleAssignUserRole)
+					r.Delete("/{user_id}/roles/{assignment_id}", roleHandler.HandleUnassignUserRole)
+				})
 			})
 
+			// Background job status
+			r.Get("/jobs/{job_id}", jobHandler.HandleGetJob)
+
 			// User Self-Service routes
 			r.Route("/users", func(r chi.Router) {
 				r.Get("/me", userHandler.HandleGetMyProfile)
 				r.Put("/me", userHandler.HandleUpdateMyProfile)
+				r.Get("/me/permissions", roleHandler.HandleGetMyPermissions)
 			})
 
-			// Organization Management routes (Admin only)
+			// Organization Management routes. Platform-wide ones are for super
+			// admins only.
 			r.Route("/admin/organizations", func(r chi.Router) {
-				r.Use(localmiddleware.RequireRole(model.RoleAdmin))
-				// TODO: Add endpoints for managing organizations, users, roles, billing
+				r.With(localmiddleware.RequireRole(model.RoleSuperAdmin)).Get("/", organizationHandler.HandleListOrganizations)
+				r.With(localmiddleware.RequireRole(model.RoleSuperAdmin)).Delete("/{org_id}", organizationHandler.HandleDeleteOrganization)
+				r.With(localmiddleware.RequireRole(model.RoleSuperAdmin)).Put("/{org_id}/quota/overrides", planHandler.HandleSetQuotaOverrides)
+				r.With(localmiddleware.RequireRole(model.RoleSuperAdmin)).Delete("/{org_id}/quota/overrides", planHandler.HandleDeleteQuotaOverrides)
+				r.With(authz.Require(model.PermissionOrganizationRead)).Get("/{org_id}", organizationHandler.HandleGetOrganization)
+				r.With(authz.Require(model.PermissionUsersRead)).Get("/{org_id}/members", organizationHandler.HandleListMembers)
+				r.Group(func(r chi.Router) {
+					r.Use(authz.Require(model.PermissionAuditRead))
+					r.Get("/{org_id}/audit-events", auditHandler.HandleListAuditEvents)
+					r.Get("/{org_id}/audit-events/export", auditHandler.HandleExportAuditEvents)
+					r.Get("/{org_id}/audit-events/verify", auditHandler.HandleVerifyAuditChain)
+				})
+				r.Group(func(r chi.Router) {
+					r.Use(authz.Require(model.PermissionRolesManage))
+					r.Get("/{org_id}/roles", roleHandler.HandleListRoles)
+					r.Post("/{org_id}/roles", roleHandler.HandleCreateRole)
+					r.Put("/{org_id}/roles/{role_id}", roleHandler.HandleUpdateRole)
+					r.Delete("/{org_id}/roles/{role_id}", roleHandler.HandleDeleteRole)
+				})
+
+				r.Group(func(r chi.Router) {
+					r.Use(authz.Require(model.PermissionOrganizationManage))
+					r.Put("/{org_id}", organizationHandler.HandleUpdateOrganization)
+					r.Post("/{org_id}/transfer-ownership", organizationHandler.HandleTransferOwnership)
+					r.Get("/{org_id}/quota", organizationHandler.HandleGetQuota)
+					r.Get("/{org_id}/quota/overrides", planHandler.HandleGetQuotaOverrides)
+					r.Post("/{org_id}/plan", planHandler.HandleChangePlan)
+					r.Get("/{org_id}/plan/changes", planHandler.HandleListQuotaChanges)
+					r.Put("/{org_id}/billing/payment-method", billingHandler.HandleUpdatePaymentMethod)
+					r.Get("/{org_id}/billing/records", billingHandler.HandleListBillingRecords)
+					r.Get("/{org_id}/billing/statements", billingHandler.HandleListStatements)
+					r.Get("/{org_id}/billing/statements/{period}", billingHandler.HandleGetStatement)
+					r.Get("/{org_id}/webhooks", webhookHandler.HandleListWebhooks)
+					r.Post("/{org_id}/webhooks", webhookHandler.HandleCreateWebhook)
+					r.Get("/{org_id}/webhooks/{webhook_id}", webhookHandler.HandleGetWebhook)
+					r.Put("/{org_id}/webhooks/{webhook_id}", webhookHandler.HandleUpdateWebhook)
+					r.Delete("/{org_id}/webhooks/{webhook_id}", webhookHandler.HandleDeleteWebhook)
+					r.Get("/{org_id}/webhook-deliveries", webhookHandler.HandleListDeliveries)
+					r.Get("/{org_id}/webhook-deliveries/{delivery_id}", webhookHandler.HandleGetDelivery)
+					r.Post("/{org_id}/webhook-deliveries/{delivery_id}/redeliver", webhookHandler.HandleRedeliver)
+					r.Get("/{org_id}/jobs", jobHandler.HandleListJobs)
+					r.Get("/{org_id}/email-templates", emailTemplateHandler.HandleListEmailTemplates)
+					r.Get("/{org_id}/email-templates/{kind}", emailTemplateHandler.HandleGetEmailTemplate)
+					r.Put("/{org_id}/email-templates/{kind}", emailTemplateHandler.HandleUpdateEmailTemplate)
+					r.Delete("/{org_id}/email-templates/{kind}", emailTemplateHandler.HandleDeleteEmailTemplate)
+					r.Post("/{org_id}/email-templates/{kind}/preview", emailTemplateHandler.HandlePreviewEmailTemplate)
+					r.Get("/{org_id}/sso/oidc", ssoHandler.HandleGetOIDCProvider)
+					r.Put("/{org_id}/sso/oidc", ssoHandler.HandleSaveOIDCProvider)
+					r.Delete("/{org_id}/sso/oidc", ssoHandler.HandleDeleteOIDCProvider)
+					r.Get("/{org_id}/sso/saml", ssoHandler.HandleGetSAMLProvider)
+					r.Put("/{org_id}/sso/saml", ssoHandler.HandleSaveSAMLProvider)
+					r.Delete("/{org_id}/sso/saml", ssoHandler.HandleDeleteSAMLProvider)
+					r.Get("/{org_id}/mfa-policy", mfaHandler.HandleGetPolicy)
+					r.Put("/{org_id}/mfa-policy", mfaHandler.HandleSavePolicy)
+					r.Get("/{org_id}/api-keys", apiKeyHandler.HandleListAPIKeys)
+					r.Post("/{org_id}/api-keys", apiKeyHandler.HandleCreateAPIKey)
+					r.Delete("/{org_id}/api-keys/{key_id}", apiKeyHandler.HandleRevokeAPIKey)
+				})
+			})
+
+			// Audit log of every organization and of the platform
+			r.Route("/admin/audit-events", func(r chi.Router) {
+				r.Use(localmiddleware.RequireRole(model.RoleSuperAdmin))
+				r.Get("/", auditHandler.HandleListPlatformAuditEvents)
+				r.Get("/export", auditHandler.HandleExportPlatformAuditEvents)
+				r.Get("/verify", auditHandler.HandleVerifyPlatformAuditChain)
 			})
 
-			// Template Management routes (Template Editor & Admin)
+			// Template Management routes. API keys are let through with the
+			// templates scopes.
 			r.Route("/templates", func(r chi.Router) {
-				r.Use(localmiddleware.RequireRole(model.RoleAdmin, model.RoleTemplateEditor))
+				read := authz.Require(model.PermissionTemplatesRead)
+				write := authz.Require(model.PermissionTemplatesWrite)
 
 				// Environment Template endpoints
 				r.Route("/environment", func(r chi.Router) {
-					r.Post("/", envHandler.CreateEnvironmentTemplate)
-					r.Get("/{id}", envHandler.GetEnvironmentTemplate)
-					r.Put("/{id}", envHandler.UpdateEnvironmentTemplate)
-					r.Delete("/{id}", envHandler.DeleteEnvironmentTemplate)
+					r.With(write).Post("/", envHandler.CreateEnvironmentTemplate)
+					r.With(read).Get("/{id}", envHandler.GetEnvironmentTemplate)
+					r.With(write).Put("/{id}", envHandler.UpdateEnvironmentTemplate)
+					r.With(write).Delete("/{id}", envHandler.DeleteEnvironmentTemplate)
 				})
 
 				// Task Template endpoints
 				r.Route("/task", func(r chi.Router) {
-					r.Post("/", taskHandler.CreateTaskTemplate)
-					r.Get("/{id}", taskHandler.GetTaskTemplate)
-					r.Put("/{id}", taskHandler.UpdateTaskTemplate)
-					r.Delete("/{id}", taskHandler.DeleteTaskTemplate)
+					r.With(write).Post("/", taskHandler.CreateTaskTemplate)
+					r.With(read).Get("/{id}", taskHandler.GetTaskTemplate)
+					r.With(write).Put("/{id}", taskHandler.UpdateTaskTemplate)
+					r.With(write).Delete("/{id}", taskHandler.DeleteTaskTemplate)
 				})
 
-				// Assessment Template endpoints
+				// Assessment Template endpoints. Roles can be granted on single
+				// assessment templates.
 				r.Route("/assessment", func(r chi.Router) {
-					r.Post("/", assessmentTemplateHandler.CreateAssessmentTemplate)
-					r.Get("/{id}", assessmentTemplateHandler.GetAssessmentTemplate)
-					r.Put("/{id}", assessmentTemplateHandler.UpdateAssessmentTemplate)
-					r.Delete("/{id}", assessmentTemplateHandler.DeleteAssessmentTemplate)
+					readOne := authz.RequireOn(model.PermissionTemplatesRead, model.ResourceAssessmentTemplate, "id")
+					writeOne := authz.RequireOn(model.PermissionTemplatesWrite, model.ResourceAssessmentTemplate, "id")
+					r.With(write).Post("/", assessmentTemplateHandler.CreateAssessmentTemplate)
+					r.With(readOne).Get("/{id}", assessmentTemplateHandler.GetAssessmentTemplate)
+					r.With(writeOne).Put("/{id}", assessmentTemplateHandler.UpdateAssessmentTemplate)
+					r.With(writeOne).Delete("/{id}", assessmentTemplateHandler.DeleteAssessmentTemplate)
 				})
 			})
 
-			// Assessment Lifecycle routes (Recruiter & Admin)
+			// Assessment Lifecycle routes. API keys are let through with the
+			// assessments scopes, and roles can be granted on single
+			// assessments.
 			r.Route("/assessments", func(r chi.Router) {
-				r.Use(localmiddleware.RequireRole(model.RoleAdmin, model.RoleRecruiter))
-				r.Get("/organization/{orgId}", assessmentHandler.GetActiveOrganizationAssessments)
-				r.Post("/", assessmentHandler.CreateAssessment)
-				r.Get("/{id}", assessmentHandler.GetAssessment)
+				read := authz.Require(model.PermissionAssessmentsRead)
+				write := authz.Require(model.PermissionAssessmentsWrite)
+				readOne := authz.RequireOn(model.PermissionAssessmentsRead, model.ResourceAssessment, "id")
+				writeOne := authz.RequireOn(model.PermissionAssessmentsWrite, model.ResourceAssessment, "id")
+				r.With(read).Get("/organization/{orgId}", assessmentHandler.GetActiveOrganizationAssessments)
+				r.With(write).Post("/", assessmentHandler.CreateAssessment)
+				r.With(write).Post("/invitations", assessmentHandler.InviteCandidate)
+				r.With(readOne).Get("/{id}", assessmentHandler.GetAssessment)
+				r.With(writeOne).Post("/{id}/invitation", assessmentHandler.ResendInvitation)
+				r.With(writeOne).Post("/{id}/reviewers", assessmentHandler.AssignReviewer)
 			})
 
 			// Assessment Taking routes (Candidate)
 			r.Route("/candidate/assessments", func(r chi.Router) {
-				r.Use(localmiddleware.RequireRole(model.RoleCandidate))
+				r.Use(authz.Require(model.PermissionAssessmentsTake))
 				r.Get("/", assessmentHandler.GetCandidateAssessments)
 				r.Get("/{id}", assessmentHandler.GetAssessment)
 				r.Post("/{id}/start", assessmentHandler.StartAssessment)
 				r.Post("/{id}/complete", assessmentHandler.CompleteAssessment)
 			})
 
-			// Assessment Review routes (Reviewer & Admin)
+			// Assessment Review routes
 			r.Route("/review/assessments", func(r chi.Router) {
-				r.Use(localmiddleware.RequireRole(model.RoleAdmin, model.RoleReviewer))
-				r.Get("/{id}", assessmentHandler.GetAssessment)
+				r.With(authz.RequireOn(model.PermissionReviewsRead, model.ResourceAssessment, "id")).Get("/{id}", assessmentHandler.GetAssessment)
 			})
 		})
 	})
 
-	// WebSocket routes
-	r.Get("/ws/terminal/{id}", func(w http.ResponseWriter, r *http.Request) {
-		id := chi.URLParam(r, "id")
-		ws.ServeTerminalWs(terminalHub, w, r, id)
-	})
+	// WebSocket routes. Browsers cannot set headers on WebSockets, so the
+	// access token may come in the access_token query parameter.
+	r.With(
+		rateLimits.LimitTerminal("terminal", "id"),
+		localmiddleware.TokenFromQuery("access_token"),
+		localmiddleware.AuthMiddleware(authService, sessionService, apiKeyService),
+	).Get("/ws/terminal/{id}", terminalHandler.HandleTerminal)
 
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
 	}
 
-	log.Info("API Server starting", map[string]interface{}{
-		"port": port,
-	})
-	if err := http.ListenAndServe(":"+port, r); err != nil {
+	srv := &http.Server{
+		Addr:    ":" + port,
+		Handler: r,
+	}
+
+	// Stop on SIGTERM (Kubernetes pod termination) or SIGINT
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Delete terminal pods that have been idle past their TTL, if enabled
+	if cfg.PodReaper.Enabled {
+		go k8sClient.RunPodReaper(ctx, cfg.PodReaper.Interval)
+	}
+
+	// Bill running terminal pods and stop those past their maximum runtime
+	go meter.Run(ctx, cfg.Metering.Interval)
+
+	// Reset monthly quotas and move subscriptions through their lifecycle
+	go scheduled.Run(ctx)
+
+	// Publish domain events stored in the outbox
+	go relay.Run(ctx, cfg.Events.RelayInterval)
+
+	// Turn domain events into webhook deliveries and send them
+	if err := webhookEventConsumer.Consume(); err != nil {
+		log.Fatal("Failed to consume webhook events", err, nil)
+	}
+	webhookEventConsumer.HandleWithContext(webhookSubscriber.Handle)
+	if err := webhookConsumer.Consume(); err != nil {
+		log.Fatal("Failed to consume webhook deliveries", err, nil)
+	}
+	webhookConsumer.HandleWithContext(webhookDeliverer.Handle)
+
+	// Send the emails triggered by domain events
+	if err := notificationConsumer.Consume(); err != nil {
+		log.Fatal("Failed to consume notification events", err, nil)
+	}
+	notificationConsumer.HandleWithContext(notificationSubscriber.Handle)
+
+	serverErr := make(chan error, 1)
+	go func() {
+		log.Info("API Server starting", map[string]interface{}{
+			"port": port,
+		})
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serverErr <- err
+		}
+	}()
+
+	select {
+	case err := <-serverErr:
 		log.Fatal("Failed to start server", err, map[string]interface{}{"port": port})
+	case <-ctx.Done():
+	}
+	stop()
+
+	log.Info("Shutting down API server", map[string]interface{}{
+		"timeout": cfg.Server.ShutdownTimeout.String(),
+	})
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+
+	// Drain HTTP requests and terminal sessions in parallel so that neither
+	// eats the whole shutdown budget. WebSocket connections are hijacked and
+	// therefore not tracked by srv.Shutdown.
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Error("Failed to drain HTTP connections", err, nil)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		if err := terminalHub.Shutdown(shutdownCtx); err != nil {
+			log.Error("Failed to drain terminal sessions", err, nil)
+		}
+	}()
+	wg.Wait()
+
+	// Stop taking webhook and notification events and webhook deliveries;
+	// unacknowledged ones are redelivered
+	for _, consumer := range []*rabbitmq.Consumer{webhookEventConsumer, webhookConsumer, notificationConsumer} {
+		if err := consumer.StopConsuming(); err != nil {
+			log.Error("Failed to stop consumer", err, nil)
+		}
 	}
+
+	// Flush buffered spans last so the shutdown itself is traced
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		log.Error("Failed to flush traces", err, nil)
+	}
+
+	log.Info("API Server stopped")
 }
 
 // Health check handler
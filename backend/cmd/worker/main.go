@@ -0,0 +1,177 @@
+// Command worker runs background jobs from the job queue: it consumes the
+// jobs queue with JOBS_CONCURRENCY consumers and runs every job type the API
+// server may enqueue.
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/cstanislawski/qualifyd/pkg/config"
+	"github.com/cstanislawski/qualifyd/pkg/database"
+	"github.com/cstanislawski/qualifyd/pkg/jobs"
+	"github.com/cstanislawski/qualifyd/pkg/k8s"
+	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/notification"
+	"github.com/cstanislawski/qualifyd/pkg/rabbitmq"
+	"github.com/cstanislawski/qualifyd/pkg/repository"
+	"github.com/cstanislawski/qualifyd/pkg/tracing"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/rs/zerolog"
+)
+
+func main() {
+	// Initialize logger
+	level := zerolog.InfoLevel
+	switch os.Getenv("LOG_LEVEL") {
+	case "debug":
+		level = zerolog.DebugLevel
+	case "warn":
+		level = zerolog.WarnLevel
+	case "error":
+		level = zerolog.ErrorLevel
+	}
+	logger.Init(
+		logger.WithLevel(level),
+	)
+	log := logger.GlobalLogger
+
+	// Load configuration
+	cfg := config.Load()
+
+	// Initialize tracing
+	shutdownTracing, err := tracing.Init(context.Background(), &cfg.Tracing)
+	if err != nil {
+		log.Fatal("Failed to initialize tracing", err, map[string]interface{}{
+			"exporter": cfg.Tracing.Exporter,
+		})
+	}
+
+	// Initialize database. Migrations are run by the API server.
+	db, err := database.New(context.Background(), &cfg.Database)
+	if err != nil {
+		log.Fatal("Failed to connect to database", err, nil)
+	}
+	defer db.Close()
+
+	// Initialize Kubernetes client
+	namespace := os.Getenv("K8S_NAMESPACE")
+	if namespace == "" {
+		namespace = "default"
+	}
+	k8sClient, err := k8s.NewClient(log, namespace)
+	if err != nil {
+		log.Fatal("Failed to create Kubernetes client", err, map[string]interface{}{
+			"namespace": namespace,
+		})
+	}
+
+	// Initialize the email transport
+	transport, err := notification.NewTransport(&cfg.Email)
+	if err != nil {
+		log.Fatal("Failed to initialize email transport", err, map[string]interface{}{
+			"transport": cfg.Email.Transport,
+		})
+	}
+
+	// Register the job handlers
+	registry := jobs.NewRegistry(append(jobs.Definitions(), notification.EmailJob)...)
+	jobs.HandleTerminalProvision(registry, k8sClient)
+	notification.HandleEmailJobs(registry, transport)
+
+	// Initialize RabbitMQ
+	mq := rabbitmq.New(&cfg.RabbitMQ)
+	if err := mq.Connect(); err != nil {
+		log.Fatal("Failed to connect to RabbitMQ", err, map[string]interface{}{
+			"host": cfg.RabbitMQ.Host,
+		})
+	}
+	defer mq.Close()
+
+	// Publishing retries and consuming use separate channels so that
+	// publisher confirms are not held up by deliveries
+	jobChannel, err := mq.NewChannel("jobs")
+	if err != nil {
+		log.Fatal("Failed to open job channel", err, nil)
+	}
+	queues, err := jobs.Setup(jobChannel, registry)
+	if err != nil {
+		log.Fatal("Failed to declare job queues", err, nil)
+	}
+	worker := jobs.NewWorker(repository.NewJobRepository(db), registry, queues, cfg.Jobs.StaleAfter, log)
+
+	consumerChannel, err := mq.NewChannel("consumers")
+	if err != nil {
+		log.Fatal("Failed to open consumer channel", err, nil)
+	}
+
+	// Track running jobs so that shutdown lets them finish
+	var running sync.WaitGroup
+	handle := func(ctx context.Context, msg amqp.Delivery) error {
+		running.Add(1)
+		defer running.Done()
+		return worker.Handle(ctx, msg)
+	}
+
+	// Every consumer runs one job at a time
+	consumers := make([]*rabbitmq.Consumer, 0, cfg.Jobs.Concurrency)
+	for i := 0; i < cfg.Jobs.Concurrency; i++ {
+		consumer, err := consumerChannel.NewConsumer(jobs.Queue, fmt.Sprintf("job-worker-%d", i), false)
+		if err != nil {
+			log.Fatal("Failed to create job consumer", err, nil)
+		}
+		if err := consumer.Consume(); err != nil {
+			log.Fatal("Failed to consume jobs", err, nil)
+		}
+		consumer.HandleWithContext(handle)
+		consumers = append(consumers, consumer)
+	}
+
+	log.Info("Worker started", map[string]interface{}{
+		"concurrency": cfg.Jobs.Concurrency,
+	})
+
+	// Stop on SIGTERM (Kubernetes pod termination) or SIGINT
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	stop()
+
+	log.Info("Shutting down worker", map[string]interface{}{
+		"timeout": cfg.Server.ShutdownTimeout.String(),
+	})
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+
+	// Stop taking jobs; unacknowledged ones are redelivered to other workers
+	for _, consumer := range consumers {
+		if err := consumer.StopConsuming(); err != nil {
+			log.Error("Failed to stop consumer", err, nil)
+		}
+	}
+
+	// Let running jobs finish. Jobs cut off by the timeout are claimed again
+	// once their timeout and JOBS_STALE_AFTER have passed.
+	done := make(chan struct{})
+	go func() {
+		running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-shutdownCtx.Done():
+		log.Warn("Shutdown timed out with jobs still running", nil)
+	}
+
+	// Flush buffered spans last so the shutdown itself is traced
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		log.Error("Failed to flush traces", err, nil)
+	}
+
+	log.Info("Worker stopped")
+}
@@ -13,8 +13,13 @@ import (
 
 	"github.com/cstanislawski/qualifyd/pkg/k8s"
 	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/metrics"
+	"github.com/cstanislawski/qualifyd/pkg/quota"
+	"github.com/cstanislawski/qualifyd/pkg/tracing"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/crypto/ssh"
 	corev1 "k8s.io/api/core/v1"
 )
@@ -90,6 +95,33 @@ type Terminal struct {
 
 	// Activity update ticker
 	activityTicker *time.Ticker
+
+	// Closed when the terminal should stop writing and close the WebSocket
+	quit     chan struct{}
+	quitOnce sync.Once
+
+	// Close frame sent to the client once quit is closed
+	closeCode int
+	closeText string
+
+	// Closed when the writer has finished and the WebSocket is closed
+	finished chan struct{}
+
+	// Why the WebSocket was closed, first reason wins
+	disconnectReason string
+	reasonOnce       sync.Once
+}
+
+// EnvironmentQuota decides whether a terminal pod may be provisioned for an
+// assessment and takes its environment slot if so
+type EnvironmentQuota interface {
+	ReserveEnvironment(ctx context.Context, assessmentID string) error
+}
+
+// PodProvisioner creates terminal pods outside the API server, for instance
+// through the job queue. onProgress receives messages to show the user.
+type PodProvisioner interface {
+	ProvisionTerminalPod(ctx context.Context, config *k8s.TerminalPodConfig, onProgress func(message string)) (podName string, err error)
 }
 
 // TerminalHub maintains the set of active terminal connections
@@ -109,6 +141,12 @@ type TerminalHub struct {
 	// Kubernetes client for managing terminal pods
 	K8sClient *k8s.Client
 
+	// Quotas is consulted before a new terminal pod is created; nil disables the check
+	Quotas EnvironmentQuota
+
+	// Provisioner creates new terminal pods; nil creates them in-process with K8sClient
+	Provisioner PodProvisioner
+
 	// Mutex for terminals map
 	mu sync.Mutex
 
@@ -117,21 +155,29 @@ type TerminalHub struct {
 	assessmentMutexes sync.Map
 
 	// Done channel for clean shutdown
-	done chan struct{}
+	done     chan struct{}
+	doneOnce sync.Once
+
+	// Context cancelled on shutdown to abort in-flight provisioning
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 // NewTerminalHub creates a new terminal hub
 func NewTerminalHub() *TerminalHub {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &TerminalHub{
 		broadcast:  make(chan []byte),
 		register:   make(chan *Terminal),
 		unregister: make(chan *Terminal),
 		terminals:  make(map[*Terminal]bool),
 		done:       make(chan struct{}),
+		ctx:        ctx,
+		cancel:     cancel,
 	}
 }
 
-// Run starts the terminal hub
+// Run starts the terminal hub and returns once the hub is shut down
 func (h *TerminalHub) Run() {
 	for {
 		select {
@@ -141,12 +187,7 @@ func (h *TerminalHub) Run() {
 			h.mu.Unlock()
 
 		case terminal := <-h.unregister:
-			h.mu.Lock()
-			if _, ok := h.terminals[terminal]; ok {
-				delete(h.terminals, terminal)
-				close(terminal.send)
-			}
-			h.mu.Unlock()
+			h.removeTerminal(terminal)
 
 		case message := <-h.broadcast:
 			h.mu.Lock()
@@ -154,19 +195,113 @@ func (h *TerminalHub) Run() {
 				select {
 				case terminal.send <- message:
 				default:
-					close(terminal.send)
+					terminal.setDisconnectReason(metrics.DisconnectBufferFull)
+					terminal.stop(websocket.CloseTryAgainLater, "terminal buffer full")
 					delete(h.terminals, terminal)
 					logger.Info("Terminal disconnected (buffer full)", map[string]interface{}{"assessmentID": terminal.assessmentID})
 				}
 			}
 			h.mu.Unlock()
+
+		case <-h.done:
+			return
 		}
 	}
 }
 
+// Shutdown stops accepting terminal connections, tells every connected client
+// to reconnect to another instance and closes their SSH sessions. Terminal pods
+// are left running so the session can be resumed. It blocks until all
+// terminals have closed their WebSocket or ctx expires.
+func (h *TerminalHub) Shutdown(ctx context.Context) error {
+	h.doneOnce.Do(func() {
+		close(h.done)
+		h.cancel()
+	})
+
+	h.mu.Lock()
+	terminals := make([]*Terminal, 0, len(h.terminals))
+	for terminal := range h.terminals {
+		terminals = append(terminals, terminal)
+	}
+	h.mu.Unlock()
+
+	logger.Info("Shutting down terminal hub", map[string]interface{}{
+		"terminals": len(terminals),
+	})
+
+	reconnectMsg := map[string]interface{}{
+		"type":    "status",
+		"status":  "reconnect",
+		"message": "Server is restarting, reconnecting to your session...",
+	}
+	reconnectJSON, _ := json.Marshal(reconnectMsg)
+
+	for _, terminal := range terminals {
+		sessionMsg := map[string]interface{}{
+			"type":      "session",
+			"sessionId": terminal.config.SessionID,
+		}
+		sessionJSON, _ := json.Marshal(sessionMsg)
+		// A client whose buffer stays full is closed without the messages
+		// once ctx expires, rather than holding up the shutdown
+		if terminal.queueContext(ctx, sessionJSON) {
+			terminal.queueContext(ctx, reconnectJSON)
+		}
+		terminal.setDisconnectReason(metrics.DisconnectServerShutdown)
+		terminal.stop(websocket.CloseServiceRestart, "server shutting down")
+		terminal.closeSSH()
+	}
+
+	for _, terminal := range terminals {
+		select {
+		case <-terminal.finished:
+		case <-ctx.Done():
+			return fmt.Errorf("terminals did not drain before deadline: %w", ctx.Err())
+		}
+	}
+
+	logger.Info("Terminal hub stopped", map[string]interface{}{
+		"terminals": len(terminals),
+	})
+	return nil
+}
+
+// registerTerminal adds a terminal to the hub, returning false if the hub is shutting down
+func (h *TerminalHub) registerTerminal(t *Terminal) bool {
+	select {
+	case h.register <- t:
+		return true
+	case <-h.done:
+		return false
+	}
+}
+
+// unregisterTerminal removes a terminal from the hub. Once the hub loop has
+// stopped the terminal is removed directly.
+func (h *TerminalHub) unregisterTerminal(t *Terminal) {
+	select {
+	case h.unregister <- t:
+	case <-h.done:
+		h.removeTerminal(t)
+	}
+}
+
+// removeTerminal deletes a terminal from the hub and stops its writer
+func (h *TerminalHub) removeTerminal(t *Terminal) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.terminals[t]; ok {
+		delete(h.terminals, t)
+		t.stop(websocket.CloseNormalClosure, "")
+	}
+}
+
 // ServeTerminalWs handles WebSocket connections for terminals
 func ServeTerminalWs(hub *TerminalHub, w http.ResponseWriter, r *http.Request, assessmentID string) {
 	clientIP := r.RemoteAddr
+	connectStart := time.Now()
 	logger.Info("Frontend: Initiating a connection to environment", map[string]interface{}{
 		"assessmentID": assessmentID,
 		"clientIP":     clientIP,
@@ -198,6 +333,8 @@ func ServeTerminalWs(hub *TerminalHub, w http.ResponseWriter, r *http.Request, a
 	terminal := &Terminal{
 		conn:         conn,
 		send:         make(chan []byte, 256),
+		quit:         make(chan struct{}),
+		finished:     make(chan struct{}),
 		assessmentID: assessmentID,
 		hub:          hub,
 		config: TerminalConfig{
@@ -211,33 +348,53 @@ func ServeTerminalWs(hub *TerminalHub, w http.ResponseWriter, r *http.Request, a
 		},
 	}
 
-	hub.register <- terminal
+	if !hub.registerTerminal(terminal) {
+		logger.Info("Rejecting terminal connection during shutdown", map[string]interface{}{
+			"assessmentID": assessmentID,
+			"clientIP":     clientIP,
+		})
+		metrics.TerminalDisconnects.WithLabelValues(metrics.DisconnectServerShutdown).Inc()
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseServiceRestart, "server shutting down"),
+			time.Now().Add(writeWait))
+		conn.Close()
+		return
+	}
+
+	// Start the writer straight away so status updates reach the client while provisioning
+	go terminal.writePump()
+
+	// Abort provisioning if either the client goes away or the hub shuts down
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	stopOnShutdown := context.AfterFunc(hub.ctx, cancel)
+	defer stopOnShutdown()
+
+	// Span covering everything from upgrade to a usable shell
+	ctx, connectSpan := tracing.Tracer("terminal").Start(ctx, "terminal.connect",
+		trace.WithAttributes(
+			attribute.String("assessment.id", assessmentID),
+			attribute.String("session.id", sessionID),
+			attribute.Bool("session.new", newSession),
+			attribute.String("template.type", templateType),
+		),
+	)
 
 	// Use mutex to protect the pod check and creation process
 	// This prevents race conditions where multiple clients might create multiple pods
 	var pod *corev1.Pod
 
-	// Get the mutex for this assessment ID
+	// Get the mutex for this assessment ID and hold it only while looking up or creating the pod
 	assessmentMutex := hub.getAssessmentMutex(assessmentID)
+	assessmentMutex.Lock()
 
 	// Track whether we need pod creation
 	needPodCreation := false
 
 	// If not explicitly requesting a new session, try to find existing pod
 	if !newSession {
-		// Lock the mutex for this assessment ID to prevent concurrent pod creation
-		assessmentMutex.Lock()
-
-		// Use defer with a boolean flag to safely control unlocking
-		mutexUnlocked := false
-		defer func() {
-			if !mutexUnlocked {
-				assessmentMutex.Unlock()
-			}
-		}()
-
 		// Try to find terminal pod with the provided session ID first
-		pod, err = hub.K8sClient.GetTerminalPod(r.Context(), assessmentID, sessionID)
+		pod, err = hub.K8sClient.GetTerminalPod(ctx, assessmentID, sessionID)
 		if err != nil {
 			// If no pod found with the session ID, try to find any pod for this assessment
 			// This handles cases where the session ID might be different or not provided
@@ -248,7 +405,7 @@ func ServeTerminalWs(hub *TerminalHub, w http.ResponseWriter, r *http.Request, a
 			})
 
 			// List all terminal pods for this assessment ID
-			pods, listErr := hub.K8sClient.ListTerminalPods(r.Context(), assessmentID)
+			pods, listErr := hub.K8sClient.ListTerminalPods(ctx, assessmentID)
 			if listErr == nil && len(pods) > 0 {
 				// Use the first pod we find for this assessment
 				pod = &pods[0]
@@ -279,52 +436,59 @@ func ServeTerminalWs(hub *TerminalHub, w http.ResponseWriter, r *http.Request, a
 			// Always store the full pod name with suffix
 			terminal.podName = pod.Name
 		}
-
-		// If we don't need to create a pod, unlock the mutex now
-		if !needPodCreation && pod != nil {
-			assessmentMutex.Unlock()
-			mutexUnlocked = true
-		}
 	} else {
 		// Explicitly requested new session
 		needPodCreation = true
 	}
 
 	// Create new pod if needed
+	err = nil
 	if needPodCreation || pod == nil {
-		// For newSession=true, we need to lock here
-		if newSession {
-			assessmentMutex.Lock()
-			defer assessmentMutex.Unlock()
-		}
-
-		err = terminal.provisionPod(r.Context())
+		err = terminal.provisionPod(ctx)
+	}
+	assessmentMutex.Unlock()
 
-		// If not a new session, we already have a defer to unlock
-		// No need to unlock here
+	if err != nil {
+		logger.Error("Failed to provision terminal pod", err, logger.WithTraceContext(ctx, map[string]interface{}{
+			"assessmentID": assessmentID,
+			"sessionID":    sessionID,
+			"templateType": templateType,
+		}))
 
-		if err != nil {
-			logger.Error("Failed to provision terminal pod", err, map[string]interface{}{
-				"assessmentID": assessmentID,
-				"sessionID":    sessionID,
-				"templateType": templateType,
-			})
+		observeConnect(connectSpan, connectStart, err)
 
-			// Send error message to client
-			errorMsg := map[string]interface{}{
+		// Quota refusals carry the same structured body as the HTTP API
+		if status, refusal, ok := quota.NewRefusal(err); ok {
+			errorJSON, _ := json.Marshal(map[string]interface{}{
 				"type":    "error",
-				"message": fmt.Sprintf("Failed to provision terminal: %v", err),
-			}
-			errorJSON, _ := json.Marshal(errorMsg)
-			terminal.send <- errorJSON
+				"status":  status,
+				"refusal": refusal,
+				"message": refusal.Message,
+			})
+			terminal.queue(errorJSON)
+			terminal.setDisconnectReason(metrics.DisconnectQuotaExceeded)
+			terminal.stop(websocket.ClosePolicyViolation, refusal.Error)
+			hub.unregisterTerminal(terminal)
+			<-terminal.finished
+			return
+		}
 
-			// Wait a moment before unregistering to allow the error to be sent
-			time.Sleep(1 * time.Second)
+		// Send error message to client; the writer flushes it before closing
+		errorMsg := map[string]interface{}{
+			"type":    "error",
+			"message": fmt.Sprintf("Failed to provision terminal: %v", err),
+		}
+		errorJSON, _ := json.Marshal(errorMsg)
+		terminal.queue(errorJSON)
 
-			hub.unregister <- terminal
-			conn.Close()
-			return
+		if hub.ctx.Err() != nil {
+			terminal.setDisconnectReason(metrics.DisconnectServerShutdown)
+			terminal.stop(websocket.CloseServiceRestart, "server shutting down")
 		}
+		terminal.setDisconnectReason(metrics.DisconnectProvisionFailed)
+		hub.unregisterTerminal(terminal)
+		<-terminal.finished
+		return
 	}
 
 	// Wait for pod to be ready before proceeding
@@ -337,10 +501,10 @@ func ServeTerminalWs(hub *TerminalHub, w http.ResponseWriter, r *http.Request, a
 			"message": "Provisioning terminal environment...",
 		}
 		statusJSON, _ := json.Marshal(statusMsg)
-		terminal.send <- statusJSON
+		terminal.queue(statusJSON)
 
 		// Create a timeout context for the pod readiness check
-		ctx, cancel := context.WithTimeout(r.Context(), 120*time.Second)
+		ctx, cancel := context.WithTimeout(ctx, 120*time.Second)
 		defer cancel()
 
 		// Poll for pod readiness
@@ -351,13 +515,22 @@ func ServeTerminalWs(hub *TerminalHub, w http.ResponseWriter, r *http.Request, a
 		for {
 			select {
 			case <-ctx.Done():
+				// The hub is shutting down, so ask the client to reconnect elsewhere
+				if hub.ctx.Err() != nil {
+					observeConnect(connectSpan, connectStart, ctx.Err())
+					terminal.setDisconnectReason(metrics.DisconnectServerShutdown)
+					terminal.stop(websocket.CloseServiceRestart, "server shutting down")
+					hub.unregisterTerminal(terminal)
+					return
+				}
+
 				// If we time out, send an error message
 				errorMsg := map[string]interface{}{
 					"type":    "error",
 					"message": "Timeout waiting for terminal environment to be ready",
 				}
 				errorJSON, _ := json.Marshal(errorMsg)
-				terminal.send <- errorJSON
+				terminal.queue(errorJSON)
 
 				logger.Error("Pod not ready - timeout", fmt.Errorf("timeout waiting for pod"), map[string]interface{}{
 					"assessmentID": assessmentID,
@@ -366,10 +539,9 @@ func ServeTerminalWs(hub *TerminalHub, w http.ResponseWriter, r *http.Request, a
 					"duration":     time.Since(startTime).String(),
 				})
 
-				// Wait a moment before unregistering to allow the error to be sent
-				time.Sleep(1 * time.Second)
-				hub.unregister <- terminal
-				conn.Close()
+				observeConnect(connectSpan, connectStart, ctx.Err())
+				terminal.setDisconnectReason(metrics.DisconnectReadyTimeout)
+				hub.unregisterTerminal(terminal)
 				return
 			case <-ticker.C:
 				// Check if pod is ready
@@ -392,23 +564,27 @@ func ServeTerminalWs(hub *TerminalHub, w http.ResponseWriter, r *http.Request, a
 						"message": "Terminal environment is ready, connecting...",
 					}
 					readyJSON, _ := json.Marshal(readyMsg)
-					terminal.send <- readyJSON
+					terminal.queue(readyJSON)
 
 					// Start activity update ticker
 					terminal.activityTicker = time.NewTicker(5 * time.Minute)
 					go terminal.updateActivity()
 
-					logger.Info("Pod is ready, connecting to SSH", map[string]interface{}{
+					logger.Info("Pod is ready, connecting to SSH", logger.WithTraceContext(ctx, map[string]interface{}{
 						"assessmentID": assessmentID,
 						"podName":      terminal.podName,
 						"duration":     time.Since(startTime).String(),
-					})
+					}))
 
 					// Connect to SSH
-					if err := terminal.connectSSH(); err != nil {
-						logger.Error("Failed to connect to SSH server in pod", err, map[string]interface{}{
+					sshStart := time.Now()
+					err := terminal.connectSSH(ctx)
+					metrics.TerminalProvisioningDuration.WithLabelValues(metrics.StageSSHConnect, metrics.Result(err)).
+						Observe(time.Since(sshStart).Seconds())
+					if err != nil {
+						logger.Error("Failed to connect to SSH server in pod", err, logger.WithTraceContext(ctx, map[string]interface{}{
 							"assessmentID": assessmentID,
-						})
+						}))
 
 						// Send error message to client
 						errorMsg := map[string]interface{}{
@@ -416,13 +592,11 @@ func ServeTerminalWs(hub *TerminalHub, w http.ResponseWriter, r *http.Request, a
 							"message": fmt.Sprintf("Failed to connect to terminal: %v", err),
 						}
 						errorJSON, _ := json.Marshal(errorMsg)
-						terminal.send <- errorJSON
+						terminal.queue(errorJSON)
 
-						// Wait a moment before unregistering to allow the error to be sent
-						time.Sleep(1 * time.Second)
-
-						hub.unregister <- terminal
-						conn.Close()
+						observeConnect(connectSpan, connectStart, err)
+						terminal.setDisconnectReason(metrics.DisconnectSSHFailed)
+						hub.unregisterTerminal(terminal)
 						return
 					}
 
@@ -436,10 +610,10 @@ func ServeTerminalWs(hub *TerminalHub, w http.ResponseWriter, r *http.Request, a
 						"sessionId": sessionID,
 					}
 					sessionJSON, _ := json.Marshal(sessionMsg)
-					terminal.send <- sessionJSON
+					terminal.queue(sessionJSON)
 
+					observeConnect(connectSpan, connectStart, nil)
 					go terminal.readPump(hub)
-					go terminal.writePump()
 
 					logger.Info("Terminal connected", map[string]interface{}{
 						"assessmentID": assessmentID,
@@ -454,7 +628,7 @@ func ServeTerminalWs(hub *TerminalHub, w http.ResponseWriter, r *http.Request, a
 						"message": fmt.Sprintf("Waiting for terminal environment to be ready (%.0fs)...", elapsedTime),
 					}
 					progressJSON, _ := json.Marshal(progressMsg)
-					terminal.send <- progressJSON
+					terminal.queue(progressJSON)
 
 					logger.Info("Waiting for pod to be ready", map[string]interface{}{
 						"assessmentID": assessmentID,
@@ -467,8 +641,8 @@ func ServeTerminalWs(hub *TerminalHub, w http.ResponseWriter, r *http.Request, a
 		}
 	}()
 
-	// We'll handle the actual connection in the goroutine above
-	select {}
+	// Keep the request (and its context) alive until the WebSocket is closed
+	<-terminal.finished
 }
 
 // waitForPodReady waits for the terminal pod to be ready
@@ -512,9 +686,10 @@ func (t *Terminal) waitForPodReady(ctx context.Context) error {
 
 // provisionPod provisions a terminal pod for the assessment
 func (t *Terminal) provisionPod(ctx context.Context) error {
-	k8sClient, err := t.getK8sClient()
-	if err != nil {
-		return fmt.Errorf("failed to get k8s client: %w", err)
+	if t.hub.Quotas != nil {
+		if err := t.hub.Quotas.ReserveEnvironment(ctx, t.assessmentID); err != nil {
+			return err
+		}
 	}
 
 	// Get namespace from environment variable
@@ -552,6 +727,35 @@ func (t *Terminal) provisionPod(ctx context.Context) error {
 		config.Memory = t.config.CustomMemory
 	}
 
+	if t.hub.Provisioner != nil {
+		podName, err := t.hub.Provisioner.ProvisionTerminalPod(ctx, config, func(message string) {
+			statusJSON, _ := json.Marshal(map[string]interface{}{
+				"type":    "status",
+				"status":  "provisioning",
+				"message": message,
+			})
+			t.queue(statusJSON)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to provision terminal pod: %w", err)
+		}
+
+		t.podName = podName
+		logger.Info("Terminal pod provisioned", map[string]interface{}{
+			"assessmentID": t.assessmentID,
+			"namespace":    namespace,
+			"podName":      podName,
+			"sessionID":    t.config.SessionID,
+			"templateType": t.config.TemplateType,
+		})
+		return nil
+	}
+
+	k8sClient, err := t.getK8sClient()
+	if err != nil {
+		return fmt.Errorf("failed to get k8s client: %w", err)
+	}
+
 	// Create the pod
 	pod, err := k8sClient.CreateTerminalPod(ctx, config)
 	if err != nil {
@@ -573,7 +777,7 @@ func (t *Terminal) provisionPod(ctx context.Context) error {
 }
 
 // connectSSH establishes an SSH connection to the terminal pod or container
-func (t *Terminal) connectSSH() error {
+func (t *Terminal) connectSSH(ctx context.Context) error {
 	var host string
 	var err error
 
@@ -596,7 +800,7 @@ func (t *Terminal) connectSSH() error {
 		})
 	} else {
 		// Get host from Kubernetes pod
-		host, err = t.getTerminalPodIP()
+		host, err = t.getTerminalPodIP(ctx)
 		if err != nil {
 			return fmt.Errorf("failed to get terminal pod IP: %w", err)
 		}
@@ -626,7 +830,7 @@ func (t *Terminal) connectSSH() error {
 
 	// Connect to SSH server with retries and proper cleanup
 	addr := fmt.Sprintf("%s:%s", host, port)
-	sshClient, err := t.connectWithRetries(addr, config)
+	sshClient, err := t.connectWithRetries(ctx, addr, config)
 	if err != nil {
 		return fmt.Errorf("failed to establish SSH connection: %w", err)
 	}
@@ -647,7 +851,7 @@ func (t *Terminal) connectSSH() error {
 }
 
 // getTerminalPodIP gets the IP address of the terminal pod with retries
-func (t *Terminal) getTerminalPodIP() (string, error) {
+func (t *Terminal) getTerminalPodIP(ctx context.Context) (string, error) {
 	// Create or get Kubernetes client
 	k8sClient, err := t.getK8sClient()
 	if err != nil {
@@ -669,7 +873,7 @@ func (t *Terminal) getTerminalPodIP() (string, error) {
 	retryDelay := 2 * time.Second
 
 	for i := 0; i < retries; i++ {
-		pod, err = k8sClient.GetTerminalPod(context.Background(), t.assessmentID, t.config.SessionID)
+		pod, err = k8sClient.GetTerminalPod(ctx, t.assessmentID, t.config.SessionID)
 		if err == nil && pod.Status.PodIP != "" {
 			return pod.Status.PodIP, nil
 		}
@@ -690,19 +894,31 @@ func (t *Terminal) getTerminalPodIP() (string, error) {
 }
 
 // connectWithRetries attempts to establish an SSH connection with retries
-func (t *Terminal) connectWithRetries(addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+func (t *Terminal) connectWithRetries(ctx context.Context, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
 	var sshClient *ssh.Client
 	var err error
 	retries := 5
 	retryDelay := 2 * time.Second
 
 	for i := 0; i < retries; i++ {
+		if i > 0 {
+			metrics.TerminalSSHRetries.Inc()
+		}
+		_, span := tracing.Tracer("terminal").Start(ctx, "ssh.dial",
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				attribute.String("server.address", addr),
+				attribute.Int("ssh.attempt", i+1),
+			),
+		)
 		logger.Info(fmt.Sprintf("SSH connection attempt %d/%d", i+1, retries), map[string]interface{}{
 			"assessmentID": t.assessmentID,
 			"address":      addr,
 		})
 
 		sshClient, err = ssh.Dial("tcp", addr, config)
+		tracing.RecordError(span, err)
+		span.End()
 		if err == nil {
 			return sshClient, nil
 		}
@@ -808,12 +1024,14 @@ func (t *Terminal) getK8sClient() (*k8s.Client, error) {
 
 // readPump pumps messages from the WebSocket connection to the hub.
 func (t *Terminal) readPump(hub *TerminalHub) {
+	metrics.TerminalSessionsActive.Inc()
 	defer func() {
+		metrics.TerminalSessionsActive.Dec()
 		logger.Info("Closing SSH connection", map[string]interface{}{
 			"assessmentID": t.assessmentID,
 		})
 		t.closeSSH()
-		hub.unregister <- t
+		hub.unregisterTerminal(t)
 		t.conn.Close()
 		logger.Info("Terminal disconnected", map[string]interface{}{
 			"assessmentID": t.assessmentID,
@@ -832,10 +1050,12 @@ func (t *Terminal) readPump(hub *TerminalHub) {
 		_, message, err := t.conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				t.setDisconnectReason(metrics.DisconnectReadError)
 				logger.Error("WebSocket error", err, map[string]interface{}{
 					"assessmentID": t.assessmentID,
 				})
 			} else {
+				t.setDisconnectReason(metrics.DisconnectClientClosed)
 				logger.Info("WebSocket closed", map[string]interface{}{
 					"assessmentID": t.assessmentID,
 				})
@@ -869,6 +1089,7 @@ func (t *Terminal) readPump(hub *TerminalHub) {
 					for i, code := range cmd.Data {
 						bytes[i] = byte(code)
 					}
+					metrics.TerminalBytes.WithLabelValues(metrics.DirectionIn).Add(float64(len(bytes)))
 					if _, err := t.stdin.Write(bytes); err != nil {
 						logger.Error("Error writing data to terminal", err, map[string]interface{}{
 							"assessmentID": t.assessmentID,
@@ -909,6 +1130,7 @@ func (t *Terminal) readPump(hub *TerminalHub) {
 					command = strings.TrimSuffix(command, "\r")
 					command = strings.TrimSuffix(command, "\n")
 					finalCommand := command + "\n"
+					metrics.TerminalBytes.WithLabelValues(metrics.DirectionIn).Add(float64(len(finalCommand)))
 					if _, err := t.stdin.Write([]byte(finalCommand)); err != nil {
 						logger.Error("Error executing command in terminal", err, map[string]interface{}{
 							"assessmentID": t.assessmentID,
@@ -943,6 +1165,7 @@ func (t *Terminal) readPump(hub *TerminalHub) {
 				if !strings.HasSuffix(command, "\n") {
 					command += "\n"
 				}
+				metrics.TerminalBytes.WithLabelValues(metrics.DirectionIn).Add(float64(len(command)))
 				if _, err := t.stdin.Write([]byte(command)); err != nil {
 					logger.Error("Error sending text command to terminal", err, map[string]interface{}{
 						"assessmentID": t.assessmentID,
@@ -959,6 +1182,9 @@ func (t *Terminal) writePump() {
 	defer func() {
 		ticker.Stop()
 		t.conn.Close()
+		t.setDisconnectReason(metrics.DisconnectUnknown)
+		metrics.TerminalDisconnects.WithLabelValues(t.disconnectReason).Inc()
+		close(t.finished)
 		logger.Info("Closed WebSocket writer", map[string]interface{}{
 			"assessmentID": t.assessmentID,
 		})
@@ -966,19 +1192,28 @@ func (t *Terminal) writePump() {
 
 	for {
 		select {
-		case message, ok := <-t.send:
+		case <-t.quit:
+			// Flush whatever is still queued before sending the close frame
 			t.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if !ok {
-				// The hub closed the channel.
-				logger.Info("Hub closed channel", map[string]interface{}{
-					"assessmentID": t.assessmentID,
-				})
-				t.conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
+			for n := len(t.send); n > 0; n-- {
+				if err := t.conn.WriteMessage(websocket.TextMessage, <-t.send); err != nil {
+					t.setDisconnectReason(metrics.DisconnectWriteError)
+					return
+				}
 			}
+			logger.Info("Closing WebSocket", map[string]interface{}{
+				"assessmentID": t.assessmentID,
+				"closeCode":    t.closeCode,
+				"reason":       t.closeText,
+			})
+			t.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(t.closeCode, t.closeText))
+			return
 
+		case message := <-t.send:
+			t.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			w, err := t.conn.NextWriter(websocket.TextMessage)
 			if err != nil {
+				t.setDisconnectReason(metrics.DisconnectWriteError)
 				logger.Error("Error getting next writer", err, map[string]interface{}{
 					"assessmentID": t.assessmentID,
 				})
@@ -993,6 +1228,7 @@ func (t *Terminal) writePump() {
 			}
 
 			if err := w.Close(); err != nil {
+				t.setDisconnectReason(metrics.DisconnectWriteError)
 				logger.Error("Error closing writer", err, map[string]interface{}{
 					"assessmentID": t.assessmentID,
 				})
@@ -1001,6 +1237,7 @@ func (t *Terminal) writePump() {
 		case <-ticker.C:
 			t.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if err := t.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				t.setDisconnectReason(metrics.DisconnectWriteError)
 				logger.Error("Error sending ping", err, map[string]interface{}{
 					"assessmentID": t.assessmentID,
 				})
@@ -1010,6 +1247,50 @@ func (t *Terminal) writePump() {
 	}
 }
 
+// queue adds a message to the outbound buffer without blocking once the terminal is stopping
+func (t *Terminal) queue(message []byte) {
+	t.queueContext(context.Background(), message)
+}
+
+// queueContext is queue that also gives up once ctx is done. It returns
+// whether the message was queued.
+func (t *Terminal) queueContext(ctx context.Context, message []byte) bool {
+	select {
+	case t.send <- message:
+		return true
+	case <-t.quit:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// setDisconnectReason records why the terminal disconnected; only the first reason is kept
+func (t *Terminal) setDisconnectReason(reason string) {
+	t.reasonOnce.Do(func() {
+		t.disconnectReason = reason
+	})
+}
+
+// observeConnect records the total time from WebSocket upgrade request to a usable (or failed) terminal
+// and ends the connect span
+func observeConnect(span trace.Span, start time.Time, err error) {
+	tracing.RecordError(span, err)
+	span.End()
+
+	metrics.TerminalProvisioningDuration.WithLabelValues(metrics.StageTotal, metrics.Result(err)).
+		Observe(time.Since(start).Seconds())
+}
+
+// stop asks the writer to flush pending messages and close the WebSocket with the given code
+func (t *Terminal) stop(code int, text string) {
+	t.quitOnce.Do(func() {
+		t.closeCode = code
+		t.closeText = text
+		close(t.quit)
+	})
+}
+
 // closeSSH closes the SSH session and client
 func (t *Terminal) closeSSH() {
 	t.mu.Lock()
@@ -1102,7 +1383,11 @@ func (t *Terminal) handleOutput(pipe io.Reader) {
 			break
 		}
 		if n > 0 {
-			t.send <- buf[:n]
+			// Copy the chunk since buf is reused by the next read
+			data := make([]byte, n)
+			copy(data, buf[:n])
+			metrics.TerminalBytes.WithLabelValues(metrics.DirectionOut).Add(float64(n))
+			t.queue(data)
 		}
 	}
 }
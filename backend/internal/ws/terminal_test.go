@@ -0,0 +1,45 @@
+package ws
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// A client whose send buffer is full and whose writer is stuck must not hold
+// up the shutdown past its deadline
+func TestShutdownWithFullSendBuffer(t *testing.T) {
+	hub := NewTerminalHub()
+	terminal := &Terminal{
+		send:         make(chan []byte, 1),
+		quit:         make(chan struct{}),
+		finished:     make(chan struct{}),
+		assessmentID: "assessment-1",
+		hub:          hub,
+	}
+	terminal.send <- []byte("pending")
+	hub.terminals[terminal] = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	result := make(chan error, 1)
+	go func() {
+		result <- hub.Shutdown(ctx)
+	}()
+
+	select {
+	case err := <-result:
+		if err == nil {
+			t.Error("Shutdown() = nil, want the deadline error since the terminal never finished")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown() did not return after its deadline")
+	}
+
+	select {
+	case <-terminal.quit:
+	default:
+		t.Error("terminal was not stopped")
+	}
+}
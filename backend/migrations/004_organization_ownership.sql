@@ -0,0 +1,41 @@
+-- Track the user who owns each organization
+ALTER TABLE organizations ADD COLUMN IF NOT EXISTS owner_id UUID REFERENCES users(id) ON DELETE SET NULL;
+
+-- Existing organizations are owned by their earliest admin
+UPDATE organizations o
+SET owner_id = (
+    SELECT u.id
+    FROM users u
+    WHERE u.organization_id = o.id AND u.role IN ('admin', 'super_admin')
+    ORDER BY u.created_at ASC
+    LIMIT 1
+)
+WHERE o.owner_id IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_organizations_owner_id ON organizations(owner_id);
+
+-- Organizations created before quotas existed have no quota row, so the
+-- counter triggers from 003 silently skip them. Backfill with current usage.
+INSERT INTO organization_quotas (
+    organization_id,
+    current_users,
+    current_task_templates,
+    current_environment_templates,
+    current_assessment_templates,
+    assessments_this_month
+)
+SELECT
+    o.id,
+    (SELECT COUNT(*) FROM users u WHERE u.organization_id = o.id),
+    (SELECT COUNT(*) FROM task_templates t WHERE t.organization_id = o.id),
+    (SELECT COUNT(*) FROM environment_templates e WHERE e.organization_id = o.id),
+    (SELECT COUNT(*) FROM assessment_templates at WHERE at.organization_id = o.id),
+    (SELECT COUNT(*)
+     FROM assessments a
+     JOIN assessment_templates at ON at.id = a.assessment_template_id
+     WHERE at.organization_id = o.id
+       AND a.created_at >= date_trunc('month', CURRENT_TIMESTAMP))
+FROM organizations o
+WHERE NOT EXISTS (
+    SELECT 1 FROM organization_quotas q WHERE q.organization_id = o.id
+);
@@ -0,0 +1,11 @@
+-- Organizations created by setup or registration before quota enforcement had
+-- no subscription state. Grandfather them in as active starter plans so they
+-- are not refused by the quota service.
+UPDATE organizations
+SET subscription_plan = 'starter'
+WHERE subscription_plan IS NULL OR subscription_plan = '';
+
+UPDATE organizations
+SET subscription_status = 'active',
+    auto_renew = TRUE
+WHERE subscription_status IS NULL OR subscription_status = '';
@@ -0,0 +1,21 @@
+-- One row per terminal pod, used to meter environment minutes from the pod's
+-- real lifetime. metered_until is the point up to which the pod's time has
+-- been written to usage_records and organization_quotas; it only moves forward.
+CREATE TABLE IF NOT EXISTS environment_sessions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    pod_name VARCHAR(255) NOT NULL,
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    assessment_id UUID REFERENCES assessments(id) ON DELETE SET NULL,
+    session_id VARCHAR(255),
+    cpu VARCHAR(50),
+    memory VARCHAR(50),
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    metered_until TIMESTAMP WITH TIME ZONE NOT NULL,
+    last_seen_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    stopped_at TIMESTAMP WITH TIME ZONE,
+    stop_reason VARCHAR(50),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_environment_sessions_pod_name ON environment_sessions(pod_name);
+CREATE INDEX IF NOT EXISTS idx_environment_sessions_open ON environment_sessions(organization_id) WHERE stopped_at IS NULL;
@@ -0,0 +1,37 @@
+-- Monthly quota counters reset on each organization's billing anniversary.
+-- period_start and period_end bound the current billing period.
+ALTER TABLE organization_quotas ADD COLUMN IF NOT EXISTS period_start TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT date_trunc('month', CURRENT_TIMESTAMP);
+ALTER TABLE organization_quotas ADD COLUMN IF NOT EXISTS period_end TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT date_trunc('month', CURRENT_TIMESTAMP) + INTERVAL '1 month';
+
+-- Align existing quotas with the anniversary of the organization's subscription
+UPDATE organization_quotas q
+SET period_start = p.period_start,
+    period_end = p.period_start + INTERVAL '1 month'
+FROM (
+    SELECT o.id,
+           a.anchor + make_interval(months => (
+               EXTRACT(YEAR FROM age(CURRENT_TIMESTAMP, a.anchor)) * 12 +
+               EXTRACT(MONTH FROM age(CURRENT_TIMESTAMP, a.anchor)))::int) AS period_start
+    FROM organizations o
+    CROSS JOIN LATERAL (
+        SELECT CASE
+                   WHEN o.subscription_start_date IS NULL OR o.subscription_start_date < '2000-01-01' THEN o.created_at
+                   ELSE o.subscription_start_date
+               END AS anchor
+    ) a
+    WHERE a.anchor <= CURRENT_TIMESTAMP
+) p
+WHERE q.organization_id = p.id;
+
+-- Subscription reminders and notices already sent, so that each is sent once
+-- per billing period
+CREATE TABLE IF NOT EXISTS subscription_notifications (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    kind VARCHAR(50) NOT NULL,
+    reference_date TIMESTAMP WITH TIME ZONE NOT NULL,
+    sent_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_subscription_notifications_unique
+    ON subscription_notifications(organization_id, kind, reference_date);
@@ -0,0 +1,22 @@
+-- The organization's customer at the payment provider
+ALTER TABLE organizations ADD COLUMN IF NOT EXISTS billing_customer_id VARCHAR(255);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_organizations_billing_customer_id
+    ON organizations(billing_customer_id) WHERE billing_customer_id IS NOT NULL;
+
+-- Charges are created with an idempotency key so that a retried renewal or
+-- plan change never charges twice; failure_reason explains failed charges
+ALTER TABLE billing_records ADD COLUMN IF NOT EXISTS idempotency_key VARCHAR(255);
+ALTER TABLE billing_records ADD COLUMN IF NOT EXISTS failure_reason TEXT;
+ALTER TABLE billing_records ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_billing_records_idempotency_key
+    ON billing_records(idempotency_key) WHERE idempotency_key IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_billing_records_transaction_id ON billing_records(transaction_id);
+
+-- Payment provider webhook events already processed; providers retry
+-- deliveries, so each event must only be applied once
+CREATE TABLE IF NOT EXISTS billing_webhook_events (
+    id VARCHAR(255) PRIMARY KEY,
+    provider VARCHAR(50) NOT NULL,
+    type VARCHAR(100) NOT NULL,
+    received_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,36 @@
+-- Custom quota limits negotiated in enterprise contracts. A NULL limit keeps
+-- the plan default.
+CREATE TABLE IF NOT EXISTS organization_quota_overrides (
+    organization_id UUID PRIMARY KEY REFERENCES organizations(id) ON DELETE CASCADE,
+    max_users INTEGER CHECK (max_users >= 0),
+    max_task_templates INTEGER CHECK (max_task_templates >= 0),
+    max_environment_templates INTEGER CHECK (max_environment_templates >= 0),
+    max_assessment_templates INTEGER CHECK (max_assessment_templates >= 0),
+    max_assessments_per_month INTEGER CHECK (max_assessments_per_month >= 0),
+    max_concurrent_environments INTEGER CHECK (max_concurrent_environments >= 0),
+    included_environment_minutes INTEGER CHECK (included_environment_minutes >= 0),
+    max_environment_runtime_minutes INTEGER CHECK (max_environment_runtime_minutes >= 0),
+    max_snapshot_retention_days INTEGER CHECK (max_snapshot_retention_days >= 0),
+    note TEXT,
+    updated_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Audit trail of plan changes and quota override changes, with the limits
+-- before and after and the resources that were already over the new limits
+CREATE TABLE IF NOT EXISTS quota_changes (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    type VARCHAR(50) NOT NULL,
+    from_plan VARCHAR(50) NOT NULL,
+    to_plan VARCHAR(50) NOT NULL,
+    previous_limits JSONB NOT NULL,
+    new_limits JSONB NOT NULL,
+    overages JSONB NOT NULL DEFAULT '[]',
+    billing_record_id UUID REFERENCES billing_records(id) ON DELETE SET NULL,
+    changed_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    note TEXT,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_quota_changes_organization ON quota_changes(organization_id, created_at DESC);
@@ -0,0 +1,28 @@
+-- Domain events written in the same transaction as the change they describe,
+-- waiting to be published by the relay
+CREATE TABLE IF NOT EXISTS outbox_events (
+    id UUID PRIMARY KEY,
+    idempotency_key VARCHAR(255) NOT NULL UNIQUE,
+    event_type VARCHAR(100) NOT NULL,
+    aggregate_type VARCHAR(100) NOT NULL,
+    aggregate_id VARCHAR(255) NOT NULL,
+    organization_id UUID,
+    payload JSONB NOT NULL,
+    occurred_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    published_at TIMESTAMP WITH TIME ZONE,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_outbox_events_pending ON outbox_events(occurred_at) WHERE published_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_outbox_events_published ON outbox_events(published_at) WHERE published_at IS NOT NULL;
+
+-- Idempotency keys of the events each consumer has processed
+CREATE TABLE IF NOT EXISTS processed_events (
+    consumer VARCHAR(100) NOT NULL,
+    idempotency_key VARCHAR(255) NOT NULL,
+    processed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (consumer, idempotency_key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_processed_events_processed_at ON processed_events(processed_at);
@@ -0,0 +1,28 @@
+-- Background jobs run by the worker, with their progress and outcome
+CREATE TABLE IF NOT EXISTS jobs (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    type VARCHAR(100) NOT NULL,
+    organization_id UUID REFERENCES organizations(id) ON DELETE CASCADE,
+    idempotency_key VARCHAR(255),
+    status VARCHAR(50) NOT NULL DEFAULT 'queued',
+    payload JSONB NOT NULL,
+    result JSONB,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    max_attempts INTEGER NOT NULL,
+    progress INTEGER NOT NULL DEFAULT 0,
+    progress_message TEXT,
+    last_error TEXT,
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    next_attempt_at TIMESTAMP WITH TIME ZONE,
+    started_at TIMESTAMP WITH TIME ZONE,
+    finished_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_jobs_organization ON jobs(organization_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_jobs_queued ON jobs(updated_at) WHERE status = 'queued';
+
+-- Only one unfinished job per idempotency key
+CREATE UNIQUE INDEX IF NOT EXISTS idx_jobs_idempotency_key ON jobs(idempotency_key)
+    WHERE idempotency_key IS NOT NULL AND status IN ('queued', 'running', 'retrying');
@@ -0,0 +1,40 @@
+-- Language of the emails sent on behalf of an organization
+ALTER TABLE organizations ADD COLUMN IF NOT EXISTS email_locale VARCHAR(10) NOT NULL DEFAULT 'en';
+
+-- Organization overrides of the built-in email templates
+CREATE TABLE IF NOT EXISTS email_templates (
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    kind VARCHAR(50) NOT NULL,
+    locale VARCHAR(10) NOT NULL,
+    subject TEXT NOT NULL,
+    text_body TEXT NOT NULL,
+    html_body TEXT NOT NULL,
+    updated_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (organization_id, kind, locale)
+);
+
+-- Emails queued for sending. The dedupe key makes every notification, such as
+-- the reminder of one assessment, go out once.
+CREATE TABLE IF NOT EXISTS email_notifications (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    organization_id UUID REFERENCES organizations(id) ON DELETE CASCADE,
+    kind VARCHAR(50) NOT NULL,
+    recipient VARCHAR(255) NOT NULL,
+    dedupe_key VARCHAR(255) NOT NULL UNIQUE,
+    job_id UUID REFERENCES jobs(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_email_notifications_organization ON email_notifications(organization_id, created_at DESC);
+
+-- Reviewers assigned to assess a candidate's work
+CREATE TABLE IF NOT EXISTS assessment_reviewers (
+    assessment_id UUID NOT NULL REFERENCES assessments(id) ON DELETE CASCADE,
+    reviewer_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    assigned_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    assigned_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (assessment_id, reviewer_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_assessment_reviewers_reviewer ON assessment_reviewers(reviewer_id);
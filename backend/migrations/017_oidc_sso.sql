@@ -0,0 +1,30 @@
+-- Organizations' OpenID Connect identity providers, one per organization.
+-- Users from the allowed email domains are provisioned on first sign-in, with
+-- the role their provider groups map to.
+CREATE TABLE IF NOT EXISTS oidc_providers (
+    organization_id UUID PRIMARY KEY REFERENCES organizations(id) ON DELETE CASCADE,
+    issuer TEXT NOT NULL,
+    client_id VARCHAR(255) NOT NULL,
+    client_secret TEXT NOT NULL,
+    allowed_domains TEXT[] NOT NULL,
+    groups_claim VARCHAR(100) NOT NULL DEFAULT 'groups',
+    role_mappings JSONB NOT NULL DEFAULT '{}',
+    default_role VARCHAR(50),
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    password_login_disabled BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_oidc_providers_domains ON oidc_providers USING GIN (allowed_domains);
+
+-- Sign-ins waiting for the identity provider to redirect back. The PKCE code
+-- verifier and nonce never leave the server; each state is used once.
+CREATE TABLE IF NOT EXISTS sso_login_states (
+    state_hash CHAR(64) PRIMARY KEY,
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    code_verifier VARCHAR(128) NOT NULL,
+    nonce VARCHAR(128) NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
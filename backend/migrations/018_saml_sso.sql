@@ -0,0 +1,36 @@
+-- Organizations' SAML 2.0 identity providers, one per organization, described
+-- by the metadata their admins upload. The policy columns match
+-- oidc_providers.
+CREATE TABLE IF NOT EXISTS saml_providers (
+    organization_id UUID PRIMARY KEY REFERENCES organizations(id) ON DELETE CASCADE,
+    metadata_xml TEXT NOT NULL,
+    idp_entity_id TEXT NOT NULL,
+    idp_sso_url TEXT NOT NULL,
+    email_attribute VARCHAR(255) NOT NULL DEFAULT '',
+    first_name_attribute VARCHAR(255) NOT NULL DEFAULT '',
+    last_name_attribute VARCHAR(255) NOT NULL DEFAULT '',
+    groups_attribute VARCHAR(255) NOT NULL DEFAULT '',
+    allowed_domains TEXT[] NOT NULL,
+    role_mappings JSONB NOT NULL DEFAULT '{}',
+    default_role VARCHAR(50),
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    password_login_disabled BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_saml_providers_domains ON saml_providers USING GIN (allowed_domains);
+
+-- SAML sign-ins keep their authentication request ID in nonce and have no
+-- code verifier
+ALTER TABLE sso_login_states ADD COLUMN IF NOT EXISTS protocol VARCHAR(10) NOT NULL DEFAULT 'oidc';
+
+-- One-time codes the assertion consumer service redirects the browser to the
+-- frontend with, exchanged for tokens so they never appear in a URL. Only
+-- hashes are stored.
+CREATE TABLE IF NOT EXISTS sso_sign_in_codes (
+    code_hash CHAR(64) PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,37 @@
+-- Roles organizations define with their own sets of permissions, next to the
+-- built-in roles defined in code
+CREATE TABLE IF NOT EXISTS custom_roles (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    description VARCHAR(255),
+    permissions TEXT[] NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (organization_id, name)
+);
+
+-- Roles users hold on top of the one in users.role: a built-in role by name
+-- or a custom role, across the organization or on one resource
+CREATE TABLE IF NOT EXISTS role_assignments (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(50),
+    custom_role_id UUID REFERENCES custom_roles(id) ON DELETE CASCADE,
+    resource_type VARCHAR(50),
+    resource_id UUID,
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CHECK ((role IS NULL) <> (custom_role_id IS NULL)),
+    CHECK ((resource_type IS NULL) = (resource_id IS NULL))
+);
+
+-- NULLs are distinct in unique constraints, so the identity of an assignment
+-- is spelled out with COALESCE
+CREATE UNIQUE INDEX IF NOT EXISTS idx_role_assignments_unique ON role_assignments (
+    user_id,
+    COALESCE(role, ''),
+    COALESCE(custom_role_id, '00000000-0000-0000-0000-000000000000'),
+    COALESCE(resource_type, ''),
+    COALESCE(resource_id, '00000000-0000-0000-0000-000000000000')
+);
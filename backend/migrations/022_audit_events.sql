@@ -0,0 +1,43 @@
+-- Append-only log of security and data-access events. Each organization's
+-- events, and the platform's events without an organization, form a hash
+-- chain: an event's hash covers its contents and the previous event's hash,
+-- so changing or removing an event breaks the chain from there on. Events
+-- have no foreign keys since they outlive the users and organizations they
+-- mention.
+CREATE TABLE IF NOT EXISTS audit_events (
+    sequence BIGSERIAL PRIMARY KEY,
+    id UUID NOT NULL UNIQUE,
+    organization_id UUID,
+    actor_type VARCHAR(20) NOT NULL,
+    actor_id VARCHAR(255),
+    action VARCHAR(100) NOT NULL,
+    target_type VARCHAR(100),
+    target_id VARCHAR(255),
+    ip_address VARCHAR(45),
+    user_agent TEXT,
+    -- JSON rather than JSONB keeps the diff byte for byte as it was hashed
+    diff JSON,
+    occurred_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    prev_hash CHAR(64) NOT NULL,
+    hash CHAR(64) NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_events_organization ON audit_events(organization_id, sequence);
+CREATE INDEX IF NOT EXISTS idx_audit_events_occurred_at ON audit_events(organization_id, occurred_at);
+CREATE INDEX IF NOT EXISTS idx_audit_events_actor ON audit_events(actor_id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_target ON audit_events(target_type, target_id);
+
+-- Refuse changes to recorded events
+CREATE OR REPLACE FUNCTION prevent_audit_event_changes() RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_events_append_only
+BEFORE UPDATE OR DELETE ON audit_events
+FOR EACH ROW EXECUTE FUNCTION prevent_audit_event_changes();
+
+CREATE TRIGGER audit_events_no_truncate
+BEFORE TRUNCATE ON audit_events
+FOR EACH STATEMENT EXECUTE FUNCTION prevent_audit_event_changes();
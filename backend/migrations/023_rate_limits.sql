@@ -0,0 +1,19 @@
+-- Token buckets of rate-limited routes, shared by every replica. Buckets left
+-- alone for their limit's period are full again and are deleted.
+CREATE TABLE IF NOT EXISTS rate_limit_buckets (
+    key VARCHAR(255) PRIMARY KEY,
+    tokens DOUBLE PRECISION NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_rate_limit_buckets_updated_at ON rate_limit_buckets(updated_at);
+
+-- Failed sign-ins per account, keyed by a hash of the lower-cased email
+-- whether or not a user has it, to lock accounts out after repeated failures
+CREATE TABLE IF NOT EXISTS login_failures (
+    account CHAR(64) PRIMARY KEY,
+    failures INTEGER NOT NULL,
+    last_failure_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_failures_last_failure_at ON login_failures(last_failure_at);
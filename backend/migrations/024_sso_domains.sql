@@ -0,0 +1,35 @@
+-- The email domains organizations' single sign-on providers allow, each
+-- claimed by one organization: the first to configure a provider allowing it.
+-- Sign-ins are only routed to the provider of the organization that claimed
+-- the user's domain.
+CREATE TABLE IF NOT EXISTS sso_domains (
+    domain VARCHAR(255) PRIMARY KEY,
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_sso_domains_organization_id ON sso_domains(organization_id);
+
+-- Domains several organizations already allow go to the one that allowed
+-- them first, and are removed from the others' providers
+INSERT INTO sso_domains (domain, organization_id, created_at)
+SELECT DISTINCT ON (domain) domain, organization_id, created_at
+FROM (
+    SELECT unnest(allowed_domains) AS domain, organization_id, created_at FROM oidc_providers
+    UNION ALL
+    SELECT unnest(allowed_domains), organization_id, created_at FROM saml_providers
+) claims
+ORDER BY domain, created_at
+ON CONFLICT (domain) DO NOTHING;
+
+UPDATE oidc_providers p
+SET allowed_domains = ARRAY(
+    SELECT d FROM unnest(p.allowed_domains) d
+    WHERE d IN (SELECT domain FROM sso_domains WHERE organization_id = p.organization_id)
+);
+
+UPDATE saml_providers p
+SET allowed_domains = ARRAY(
+    SELECT d FROM unnest(p.allowed_domains) d
+    WHERE d IN (SELECT domain FROM sso_domains WHERE organization_id = p.organization_id)
+);
@@ -0,0 +1,110 @@
+// Package apikey issues and authenticates the API keys organizations call
+// the API with from scripts and integrations.
+package apikey
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/auth"
+	"github.com/cstanislawski/qualifyd/pkg/database"
+	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/cstanislawski/qualifyd/pkg/repository"
+)
+
+// ErrInvalidKey is returned when authenticating with a key that is unknown,
+// revoked or expired
+var ErrInvalidKey = errors.New("API key is invalid, revoked or expired")
+
+// lastUsedInterval is how often a key's last use is recorded at most
+const lastUsedInterval = time.Minute
+
+// Service issues, lists, revokes and authenticates API keys
+type Service struct {
+	repo *repository.APIKeyRepository
+	log  logger.Logger
+}
+
+// NewService creates a new Service
+func NewService(repo *repository.APIKeyRepository, log logger.Logger) *Service {
+	return &Service{
+		repo: repo,
+		log:  log,
+	}
+}
+
+// Create issues key and returns it in full. Only its hash is stored, so it
+// cannot be shown again.
+func (s *Service) Create(ctx context.Context, key *model.APIKey) (string, error) {
+	secret, prefix, err := auth.GenerateAPIKey()
+	if err != nil {
+		return "", err
+	}
+	key.Prefix = prefix
+	key.KeyHash = auth.HashToken(secret)
+
+	if err := s.repo.Create(ctx, key); err != nil {
+		return "", err
+	}
+
+	s.log.Info("API key created", map[string]interface{}{
+		"api_key_id":      key.ID,
+		"organization_id": key.OrganizationID,
+		"scopes":          key.Scopes,
+		"created_by":      key.CreatedBy,
+	})
+	return secret, nil
+}
+
+// List returns an organization's API keys, revoked and expired ones included
+func (s *Service) List(ctx context.Context, organizationID string) ([]*model.APIKey, error) {
+	return s.repo.ListByOrganization(ctx, organizationID)
+}
+
+// Revoke revokes one of an organization's API keys. Requests made with it
+// are refused right away.
+func (s *Service) Revoke(ctx context.Context, organizationID, id, revokedBy string) error {
+	if err := s.repo.Revoke(ctx, organizationID, id); err != nil {
+		return err
+	}
+
+	s.log.Info("API key revoked", map[string]interface{}{
+		"api_key_id":      id,
+		"organization_id": organizationID,
+		"revoked_by":      revokedBy,
+	})
+	return nil
+}
+
+// AuthenticateAPIKey returns the active key secret is, and records its use
+func (s *Service) AuthenticateAPIKey(ctx context.Context, secret string) (*model.APIKey, error) {
+	prefix, ok := auth.ParseAPIKey(secret)
+	if !ok {
+		return nil, ErrInvalidKey
+	}
+
+	key, err := s.repo.GetByPrefix(ctx, prefix)
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			return nil, ErrInvalidKey
+		}
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	if subtle.ConstantTimeCompare([]byte(key.KeyHash), []byte(auth.HashToken(secret))) != 1 || !key.IsActive(now) {
+		return nil, ErrInvalidKey
+	}
+
+	// Failing to record the use does not fail the request
+	if err := s.repo.TouchLastUsed(ctx, key.ID, now, lastUsedInterval); err != nil {
+		s.log.Error("Failed to record API key use", err, map[string]interface{}{
+			"api_key_id": key.ID,
+		})
+	}
+
+	return key, nil
+}
@@ -0,0 +1,229 @@
+// Package audit records security and data-access events in the append-only
+// audit log, exports them, and checks the log's hash chains for tampering.
+package audit
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/database"
+	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/cstanislawski/qualifyd/pkg/repository"
+	"github.com/jackc/pgx/v5"
+)
+
+// Export formats
+const (
+	FormatCSV  = "csv"
+	FormatJSON = "json"
+)
+
+// ErrUnknownFormat is returned when exporting in an unknown format
+var ErrUnknownFormat = errors.New("unknown export format")
+
+// csvHeader is the header row of CSV exports
+var csvHeader = []string{
+	"sequence", "id", "occurred_at", "organization_id", "actor_type", "actor_id", "action",
+	"target_type", "target_id", "ip_address", "user_agent", "diff", "prev_hash", "hash",
+}
+
+// Verification is the result of checking a hash chain
+type Verification struct {
+	Valid  bool `json:"valid"`
+	Events int  `json:"events"`
+	// BrokenAt is the sequence of the first event that does not match the
+	// chain: it was changed, or an event before it was removed
+	BrokenAt  *int64    `json:"broken_at,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	LastHash  string    `json:"last_hash,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// Service records and reads the audit log
+type Service struct {
+	repo *repository.AuditRepository
+	log  logger.Logger
+}
+
+// NewService creates a new Service
+func NewService(repo *repository.AuditRepository, log logger.Logger) *Service {
+	return &Service{
+		repo: repo,
+		log:  log,
+	}
+}
+
+// Record appends an event to the audit log. A failure is logged rather than
+// returned so that it does not fail the action being audited.
+func (s *Service) Record(ctx context.Context, e *model.AuditEvent) {
+	if err := s.repo.Append(ctx, e); err != nil {
+		s.log.Error("Failed to record audit event", err, map[string]interface{}{
+			"action":          e.Action,
+			"organization_id": e.OrganizationID,
+			"actor_id":        e.ActorID,
+			"target_type":     e.TargetType,
+			"target_id":       e.TargetID,
+		})
+	}
+}
+
+// RecordChange appends an event with the diff of its target before and
+// after the change, see model.NewAuditDiff
+func (s *Service) RecordChange(ctx context.Context, e *model.AuditEvent, before, after interface{}) {
+	diff, err := model.NewAuditDiff(before, after)
+	if err != nil {
+		// The event is still worth having without its diff
+		s.log.Error("Failed to compute audit diff", err, map[string]interface{}{
+			"action":    e.Action,
+			"target_id": e.TargetID,
+		})
+	}
+	e.Diff = diff
+	s.Record(ctx, e)
+}
+
+// RecordChangeTx appends an event with its target's diff inside tx, so that
+// the change is only made if it is recorded
+func (s *Service) RecordChangeTx(ctx context.Context, tx pgx.Tx, e *model.AuditEvent, before, after interface{}) error {
+	diff, err := model.NewAuditDiff(before, after)
+	if err != nil {
+		return fmt.Errorf("failed to compute audit diff: %w", err)
+	}
+	e.Diff = diff
+	return s.repo.WithTx(tx).Append(ctx, e)
+}
+
+// List returns a page of the events matching filter, newest first
+func (s *Service) List(ctx context.Context, filter model.AuditFilter, params database.PaginationParams) (database.PaginatedResponse, error) {
+	return s.repo.List(ctx, filter, params)
+}
+
+// Export writes the events matching filter to w in format, oldest first
+func (s *Service) Export(ctx context.Context, filter model.AuditFilter, format string, w io.Writer) error {
+	switch format {
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(csvHeader); err != nil {
+			return err
+		}
+		err := s.repo.Each(ctx, filter, func(e *model.AuditEvent) error {
+			return cw.Write(csvRecord(e))
+		})
+		if err != nil {
+			return err
+		}
+		cw.Flush()
+		return cw.Error()
+
+	case FormatJSON:
+		// Events are written one by one as a JSON array so that large
+		// exports are not held in memory
+		if _, err := io.WriteString(w, "["); err != nil {
+			return err
+		}
+		first := true
+		err := s.repo.Each(ctx, filter, func(e *model.AuditEvent) error {
+			content, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			if !first {
+				if _, err := io.WriteString(w, ","); err != nil {
+					return err
+				}
+			}
+			first = false
+			_, err = w.Write(content)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, "]\n")
+		return err
+
+	default:
+		return ErrUnknownFormat
+	}
+}
+
+// Verify checks the hash chain of an organization's events, or of the
+// platform's events if organizationID is empty. Removing the newest events
+// of a chain cannot be told from the chain itself; compare LastHash with a
+// previous verification for that.
+func (s *Service) Verify(ctx context.Context, organizationID string) (*Verification, error) {
+	v := &Verification{Valid: true, CheckedAt: time.Now().UTC()}
+	prevHash := model.AuditGenesisHash
+
+	err := s.repo.EachInChain(ctx, organizationID, func(e *model.AuditEvent) error {
+		if !v.Valid {
+			return nil
+		}
+		v.Events++
+		switch {
+		case e.PrevHash != prevHash:
+			v.Reason = "Previous hash does not match the previous event"
+		case e.Hash != e.ComputeHash(e.PrevHash):
+			v.Reason = "Hash does not match the event"
+		default:
+			prevHash = e.Hash
+			return nil
+		}
+		v.Valid = false
+		sequence := e.Sequence
+		v.BrokenAt = &sequence
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if v.Valid && v.Events > 0 {
+		v.LastHash = prevHash
+	}
+	if !v.Valid {
+		s.log.Warn("Audit log hash chain is broken", map[string]interface{}{
+			"organization_id": organizationID,
+			"broken_at":       *v.BrokenAt,
+			"reason":          v.Reason,
+		})
+	}
+	return v, nil
+}
+
+// csvRecord returns the CSV row of an event
+func csvRecord(e *model.AuditEvent) []string {
+	return []string{
+		strconv.FormatInt(e.Sequence, 10),
+		e.ID,
+		e.OccurredAt.UTC().Format(time.RFC3339Nano),
+		e.OrganizationID,
+		e.ActorType,
+		csvSafe(e.ActorID),
+		e.Action,
+		e.TargetType,
+		csvSafe(e.TargetID),
+		csvSafe(e.IPAddress),
+		csvSafe(e.UserAgent),
+		csvSafe(string(e.Diff)),
+		e.PrevHash,
+		e.Hash,
+	}
+}
+
+// csvSafe keeps spreadsheets from evaluating a caller-supplied value as a
+// formula
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
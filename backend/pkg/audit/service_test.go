@@ -0,0 +1,35 @@
+package audit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/model"
+)
+
+func TestCSVRecord(t *testing.T) {
+	e := &model.AuditEvent{
+		Sequence:   42,
+		ID:         "event-1",
+		ActorType:  model.AuditActorAnonymous,
+		Action:     model.AuditLoginFailed,
+		TargetType: model.AuditTargetUser,
+		TargetID:   "=HYPERLINK(\"http://example.com\")",
+		UserAgent:  "curl/8.0",
+		OccurredAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+
+	record := csvRecord(e)
+	if len(record) != len(csvHeader) {
+		t.Fatalf("csvRecord() has %d columns, header has %d", len(record), len(csvHeader))
+	}
+	if record[0] != "42" || record[2] != "2026-01-02T03:04:05Z" {
+		t.Errorf("csvRecord() = %q", record)
+	}
+	if record[8] != "'=HYPERLINK(\"http://example.com\")" {
+		t.Errorf("csvRecord() target = %q, want it escaped", record[8])
+	}
+	if record[10] != "curl/8.0" {
+		t.Errorf("csvRecord() user agent = %q", record[10])
+	}
+}
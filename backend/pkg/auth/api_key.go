@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// apiKeyPrefix starts every API key, so that leaked keys are easy to spot
+const apiKeyPrefix = "qfd_"
+
+// apiKeyIDLength is the length of the part of a key that identifies it, in
+// hex characters
+const apiKeyIDLength = 12
+
+// GenerateAPIKey returns a new API key and its prefix, which identifies the
+// key and is stored in the clear. The key is only stored hashed with
+// HashToken.
+func GenerateAPIKey() (key, prefix string, err error) {
+	id := make([]byte, apiKeyIDLength/2)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return "", "", fmt.Errorf("failed to generate API key: %w", err)
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", fmt.Errorf("failed to generate API key: %w", err)
+	}
+
+	prefix = apiKeyPrefix + hex.EncodeToString(id)
+	return prefix + "_" + hex.EncodeToString(secret), prefix, nil
+}
+
+// ParseAPIKey returns the prefix of an API key, or false if key is not
+// shaped like one
+func ParseAPIKey(key string) (string, bool) {
+	rest, ok := strings.CutPrefix(key, apiKeyPrefix)
+	if !ok || len(rest) <= apiKeyIDLength+1 || rest[apiKeyIDLength] != '_' {
+		return "", false
+	}
+	return key[:len(apiKeyPrefix)+apiKeyIDLength], true
+}
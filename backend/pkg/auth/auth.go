@@ -1,6 +1,8 @@
 package auth
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"time"
@@ -8,6 +10,7 @@ import (
 	"github.com/cstanislawski/qualifyd/pkg/config"
 	"github.com/cstanislawski/qualifyd/pkg/model"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -26,6 +29,18 @@ type Claims struct {
 	Email          string `json:"email"`
 	Role           string `json:"role"`
 	OrganizationID string `json:"organization_id,omitempty"`
+	// AssessmentID limits a candidate's token, opened with a sign-in link, to
+	// one assessment
+	AssessmentID string `json:"assessment_id,omitempty"`
+	// SessionID is the server-side session the token belongs to; revoking
+	// the session revokes the token
+	SessionID string `json:"sid"`
+	jwt.RegisteredClaims
+}
+
+// RefreshClaims represents the claims of a refresh token
+type RefreshClaims struct {
+	SessionID string `json:"sid"`
 	jwt.RegisteredClaims
 }
 
@@ -55,25 +70,8 @@ func CheckPassword(password, hashedPassword string) error {
 	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
 }
 
-// GenerateTokens generates both access and refresh tokens for a user
-func (a *Auth) GenerateTokens(user *model.User) (accessToken, refreshToken string, err error) {
-	// Generate access token
-	accessToken, err = a.GenerateAccessToken(user)
-	if err != nil {
-		return "", "", err
-	}
-
-	// Generate refresh token
-	refreshToken, err = a.GenerateRefreshToken(user.ID)
-	if err != nil {
-		return "", "", err
-	}
-
-	return accessToken, refreshToken, nil
-}
-
-// GenerateAccessToken generates a JWT access token for a user
-func (a *Auth) GenerateAccessToken(user *model.User) (string, error) {
+// GenerateAccessToken generates a JWT access token for a user's session
+func (a *Auth) GenerateAccessToken(user *model.User, sessionID string) (string, error) {
 	expirationTime := time.Now().Add(time.Duration(a.config.ExpirationHours) * time.Hour)
 
 	claims := &Claims{
@@ -81,6 +79,7 @@ func (a *Auth) GenerateAccessToken(user *model.User) (string, error) {
 		Email:          user.Email,
 		Role:           user.Role,
 		OrganizationID: user.OrganizationID,
+		SessionID:      sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -99,25 +98,31 @@ func (a *Auth) GenerateAccessToken(user *model.User) (string, error) {
 	return tokenString, nil
 }
 
-// GenerateRefreshToken generates a JWT refresh token for a user
-func (a *Auth) GenerateRefreshToken(userID string) (string, error) {
+// GenerateRefreshToken generates a JWT refresh token for a user's session and
+// returns when it expires. Every token is unique; the session store keeps
+// the hash of the one currently valid.
+func (a *Auth) GenerateRefreshToken(userID, sessionID string) (string, time.Time, error) {
 	expirationTime := time.Now().Add(time.Duration(a.config.RefreshExpirationHours) * time.Hour)
 
-	claims := &jwt.RegisteredClaims{
-		ExpiresAt: jwt.NewNumericDate(expirationTime),
-		IssuedAt:  jwt.NewNumericDate(time.Now()),
-		NotBefore: jwt.NewNumericDate(time.Now()),
-		Issuer:    "qualifyd",
-		Subject:   userID,
+	claims := &RefreshClaims{
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			ExpiresAt: jwt.NewNumericDate(expirationTime),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+			Issuer:    "qualifyd",
+			Subject:   userID,
+		},
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	tokenString, err := token.SignedString([]byte(a.config.RefreshSecret))
 	if err != nil {
-		return "", fmt.Errorf("failed to generate refresh token: %w", err)
+		return "", time.Time{}, fmt.Errorf("failed to generate refresh token: %w", err)
 	}
 
-	return tokenString, nil
+	return tokenString, expirationTime, nil
 }
 
 // ValidateAccessToken validates a JWT access token and returns the claims
@@ -141,7 +146,9 @@ func (a *Auth) ValidateAccessToken(tokenString string) (*Claims, error) {
 		return nil, fmt.Errorf("failed to parse token: %w", err)
 	}
 
-	if !token.Valid {
+	// Other tokens signed with the same secret, such as sign-in links, carry
+	// no user or session
+	if !token.Valid || claims.UserID == "" || claims.SessionID == "" {
 		return nil, ErrInvalidToken
 	}
 
@@ -149,12 +156,13 @@ func (a *Auth) ValidateAccessToken(tokenString string) (*Claims, error) {
 }
 
 // ValidateRefreshToken validates a JWT refresh token and returns the user ID
-func (a *Auth) ValidateRefreshToken(tokenString string) (string, error) {
+// and session ID
+func (a *Auth) ValidateRefreshToken(tokenString string) (string, string, error) {
 	if tokenString == "" {
-		return "", ErrNoToken
+		return "", "", ErrNoToken
 	}
 
-	claims := &jwt.RegisteredClaims{}
+	claims := &RefreshClaims{}
 	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
@@ -164,25 +172,30 @@ func (a *Auth) ValidateRefreshToken(tokenString string) (string, error) {
 
 	if err != nil {
 		if errors.Is(err, jwt.ErrTokenExpired) {
-			return "", ErrTokenExpired
+			return "", "", ErrTokenExpired
 		}
-		return "", fmt.Errorf("failed to parse refresh token: %w", err)
+		return "", "", fmt.Errorf("failed to parse refresh token: %w", err)
 	}
 
-	if !token.Valid {
-		return "", ErrInvalidToken
+	if !token.Valid || claims.Subject == "" || claims.SessionID == "" {
+		return "", "", ErrInvalidToken
 	}
 
-	return claims.Subject, nil
+	return claims.Subject, claims.SessionID, nil
 }
 
-// GenerateResetToken generates a password reset token
-func (a *Auth) GenerateResetToken(userID string) (string, error) {
-	// Reset tokens expire in 1 hour
-	expirationTime := time.Now().Add(1 * time.Hour)
+// resetAudience sets password reset tokens apart from the other tokens
+// signed with the access token secret
+const resetAudience = "password-reset"
 
+// GenerateResetToken generates a password reset token for a user, valid until
+// expiresAt. Every token is unique; that it is used once is up to the
+// caller, who stores its HashToken.
+func (a *Auth) GenerateResetToken(userID string, expiresAt time.Time) (string, error) {
 	claims := &jwt.RegisteredClaims{
-		ExpiresAt: jwt.NewNumericDate(expirationTime),
+		ID:        uuid.New().String(),
+		Audience:  jwt.ClaimStrings{resetAudience},
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
 		IssuedAt:  jwt.NewNumericDate(time.Now()),
 		NotBefore: jwt.NewNumericDate(time.Now()),
 		Issuer:    "qualifyd",
@@ -198,7 +211,7 @@ func (a *Auth) GenerateResetToken(userID string) (string, error) {
 	return tokenString, nil
 }
 
-// ValidateResetToken validates a password reset token
+// ValidateResetToken validates a password reset token and returns the user ID
 func (a *Auth) ValidateResetToken(tokenString string) (string, error) {
 	if tokenString == "" {
 		return "", ErrNoToken
@@ -210,7 +223,7 @@ func (a *Auth) ValidateResetToken(tokenString string) (string, error) {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
 		return []byte(a.config.Secret), nil
-	})
+	}, jwt.WithAudience(resetAudience))
 
 	if err != nil {
 		if errors.Is(err, jwt.ErrTokenExpired) {
@@ -219,9 +232,16 @@ func (a *Auth) ValidateResetToken(tokenString string) (string, error) {
 		return "", fmt.Errorf("failed to parse reset token: %w", err)
 	}
 
-	if !token.Valid {
+	if !token.Valid || claims.Subject == "" {
 		return "", ErrInvalidToken
 	}
 
 	return claims.Subject, nil
 }
+
+// HashToken returns the hash under which a single-use token is stored, so
+// that the stored tokens cannot be used if the database leaks
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
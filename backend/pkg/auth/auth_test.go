@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/model"
+)
+
+func TestResetToken(t *testing.T) {
+	a := newTestAuth()
+
+	token, err := a.GenerateResetToken("user-1", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GenerateResetToken() error = %v", err)
+	}
+	userID, err := a.ValidateResetToken(token)
+	if err != nil || userID != "user-1" {
+		t.Errorf("ValidateResetToken() = %q, %v, want user-1", userID, err)
+	}
+
+	// Tokens are unique, so that each is stored under its own hash
+	other, err := a.GenerateResetToken("user-1", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GenerateResetToken() error = %v", err)
+	}
+	if HashToken(token) == HashToken(other) {
+		t.Error("GenerateResetToken() returned the same token twice")
+	}
+
+	// Neither access tokens nor sign-in links reset passwords, and reset
+	// tokens do not sign in
+	access, err := a.GenerateAccessToken(&model.User{ID: "user-1", Role: model.RoleAdmin}, "session-1")
+	if err != nil {
+		t.Fatalf("GenerateAccessToken() error = %v", err)
+	}
+	if _, err := a.ValidateResetToken(access); err == nil {
+		t.Error("ValidateResetToken() accepted an access token")
+	}
+	link, err := a.GenerateMagicLinkToken("link-1", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GenerateMagicLinkToken() error = %v", err)
+	}
+	if _, err := a.ValidateResetToken(link); err == nil {
+		t.Error("ValidateResetToken() accepted a magic link token")
+	}
+	if _, err := a.ValidateAccessToken(token); err == nil {
+		t.Error("ValidateAccessToken() accepted a reset token")
+	}
+
+	expired, err := a.GenerateResetToken("user-1", time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("GenerateResetToken() error = %v", err)
+	}
+	if _, err := a.ValidateResetToken(expired); err != ErrTokenExpired {
+		t.Errorf("ValidateResetToken() of an expired token error = %v, want ErrTokenExpired", err)
+	}
+}
+
+func TestRefreshToken(t *testing.T) {
+	a := newTestAuth()
+
+	token, expiresAt, err := a.GenerateRefreshToken("user-1", "session-1")
+	if err != nil {
+		t.Fatalf("GenerateRefreshToken() error = %v", err)
+	}
+	if d := time.Until(expiresAt); d < 23*time.Hour || d > 24*time.Hour {
+		t.Errorf("GenerateRefreshToken() expires in %v, want about 24h", d)
+	}
+	userID, sessionID, err := a.ValidateRefreshToken(token)
+	if err != nil || userID != "user-1" || sessionID != "session-1" {
+		t.Errorf("ValidateRefreshToken() = %q, %q, %v, want user-1, session-1", userID, sessionID, err)
+	}
+
+	// Rotation hands out a new token every time
+	other, _, err := a.GenerateRefreshToken("user-1", "session-1")
+	if err != nil {
+		t.Fatalf("GenerateRefreshToken() error = %v", err)
+	}
+	if HashToken(token) == HashToken(other) {
+		t.Error("GenerateRefreshToken() returned the same token twice")
+	}
+
+	// Access tokens without a session are not accepted
+	access, err := a.GenerateAccessToken(&model.User{ID: "user-1", Role: model.RoleAdmin}, "")
+	if err != nil {
+		t.Fatalf("GenerateAccessToken() error = %v", err)
+	}
+	if _, err := a.ValidateAccessToken(access); err == nil {
+		t.Error("ValidateAccessToken() accepted a token without a session")
+	}
+}
+
+func TestAPIKey(t *testing.T) {
+	key, prefix, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatalf("GenerateAPIKey() error = %v", err)
+	}
+	if !strings.HasPrefix(key, prefix+"_") {
+		t.Errorf("GenerateAPIKey() key %q does not start with prefix %q", key, prefix)
+	}
+
+	got, ok := ParseAPIKey(key)
+	if !ok || got != prefix {
+		t.Errorf("ParseAPIKey() = %q, %v, want %q, true", got, ok, prefix)
+	}
+	for _, bad := range []string{"", prefix, "qfd_short_secret", "Bearer " + key} {
+		if _, ok := ParseAPIKey(bad); ok {
+			t.Errorf("ParseAPIKey(%q) accepted a malformed key", bad)
+		}
+	}
+}
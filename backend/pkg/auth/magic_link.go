@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// magicLinkAudience sets sign-in link tokens apart from the other tokens
+// signed with the access token secret
+const magicLinkAudience = "magic-link"
+
+// MagicLinkExpiresAt returns when a sign-in link for an assessment scheduled
+// to start at scheduledStart, issued now, expires
+func (a *Auth) MagicLinkExpiresAt(scheduledStart time.Time) time.Time {
+	from := time.Now().UTC()
+	if scheduledStart.After(from) {
+		from = scheduledStart
+	}
+	return from.Add(time.Duration(a.config.MagicLinkExpirationHours) * time.Hour)
+}
+
+// GenerateMagicLinkToken signs the token of the sign-in link with the given
+// ID. The token only identifies the link; that it is used once is up to the
+// caller.
+func (a *Auth) GenerateMagicLinkToken(linkID string, expiresAt time.Time) (string, error) {
+	claims := &jwt.RegisteredClaims{
+		ID:        linkID,
+		Audience:  jwt.ClaimStrings{magicLinkAudience},
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+		Issuer:    "qualifyd",
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	tokenString, err := token.SignedString([]byte(a.config.Secret))
+	if err != nil {
+		return "", fmt.Errorf("failed to generate magic link token: %w", err)
+	}
+
+	return tokenString, nil
+}
+
+// ValidateMagicLinkToken validates the token of a sign-in link and returns
+// the link's ID
+func (a *Auth) ValidateMagicLinkToken(tokenString string) (string, error) {
+	if tokenString == "" {
+		return "", ErrNoToken
+	}
+
+	claims := &jwt.RegisteredClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(a.config.Secret), nil
+	}, jwt.WithAudience(magicLinkAudience))
+
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return "", ErrTokenExpired
+		}
+		return "", fmt.Errorf("failed to parse magic link token: %w", err)
+	}
+
+	if !token.Valid || claims.ID == "" {
+		return "", ErrInvalidToken
+	}
+
+	return claims.ID, nil
+}
+
+// GenerateAssessmentToken generates an access token for a candidate's session
+// that limits them to one assessment. It lasts the configured candidate
+// session and cannot be refreshed.
+func (a *Auth) GenerateAssessmentToken(user *model.User, assessmentID, sessionID string) (string, time.Time, error) {
+	now := time.Now()
+	expirationTime := now.Add(time.Duration(a.config.CandidateSessionHours) * time.Hour)
+
+	claims := &Claims{
+		UserID:         user.ID,
+		Email:          user.Email,
+		Role:           user.Role,
+		OrganizationID: user.OrganizationID,
+		AssessmentID:   assessmentID,
+		SessionID:      sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expirationTime),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			Issuer:    "qualifyd",
+			Subject:   user.ID,
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	tokenString, err := token.SignedString([]byte(a.config.Secret))
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to generate assessment token: %w", err)
+	}
+
+	return tokenString, expirationTime, nil
+}
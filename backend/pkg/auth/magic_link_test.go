@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/config"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+)
+
+func newTestAuth() *Auth {
+	return New(&config.JWTConfig{
+		Secret:                   "secret",
+		ExpirationHours:          1,
+		RefreshSecret:            "refresh-secret",
+		RefreshExpirationHours:   24,
+		MagicLinkExpirationHours: 72,
+		CandidateSessionHours:    8,
+	})
+}
+
+func TestMagicLinkToken(t *testing.T) {
+	a := newTestAuth()
+
+	token, err := a.GenerateMagicLinkToken("link-1", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GenerateMagicLinkToken() error = %v", err)
+	}
+	linkID, err := a.ValidateMagicLinkToken(token)
+	if err != nil || linkID != "link-1" {
+		t.Errorf("ValidateMagicLinkToken() = %q, %v, want link-1", linkID, err)
+	}
+
+	// A sign-in link is not an access token
+	if _, err := a.ValidateAccessToken(token); err == nil {
+		t.Error("ValidateAccessToken() accepted a magic link token")
+	}
+
+	// and an access token is not a sign-in link
+	access, err := a.GenerateAccessToken(&model.User{ID: "user-1", Role: model.RoleCandidate}, "session-1")
+	if err != nil {
+		t.Fatalf("GenerateAccessToken() error = %v", err)
+	}
+	if _, err := a.ValidateMagicLinkToken(access); err == nil {
+		t.Error("ValidateMagicLinkToken() accepted an access token")
+	}
+
+	expired, err := a.GenerateMagicLinkToken("link-1", time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("GenerateMagicLinkToken() error = %v", err)
+	}
+	if _, err := a.ValidateMagicLinkToken(expired); err != ErrTokenExpired {
+		t.Errorf("ValidateMagicLinkToken() of an expired link error = %v, want ErrTokenExpired", err)
+	}
+}
+
+func TestMagicLinkExpiresAt(t *testing.T) {
+	a := newTestAuth()
+
+	start := time.Now().UTC().Add(7 * 24 * time.Hour)
+	if got := a.MagicLinkExpiresAt(start); !got.Equal(start.Add(72 * time.Hour)) {
+		t.Errorf("MagicLinkExpiresAt(future) = %v, want %v", got, start.Add(72*time.Hour))
+	}
+
+	got := a.MagicLinkExpiresAt(time.Now().Add(-24 * time.Hour))
+	if d := time.Until(got); d < 71*time.Hour || d > 72*time.Hour {
+		t.Errorf("MagicLinkExpiresAt(past) expires in %v, want about 72h", d)
+	}
+}
+
+func TestAssessmentToken(t *testing.T) {
+	a := newTestAuth()
+	user := &model.User{ID: "user-1", Email: "c@example.com", Role: model.RoleCandidate, OrganizationID: "org-1"}
+
+	token, expiresAt, err := a.GenerateAssessmentToken(user, "assessment-1", "session-1")
+	if err != nil {
+		t.Fatalf("GenerateAssessmentToken() error = %v", err)
+	}
+	if d := time.Until(expiresAt); d < 7*time.Hour || d > 8*time.Hour {
+		t.Errorf("GenerateAssessmentToken() expires in %v, want about 8h", d)
+	}
+
+	claims, err := a.ValidateAccessToken(token)
+	if err != nil {
+		t.Fatalf("ValidateAccessToken() error = %v", err)
+	}
+	if claims.UserID != "user-1" || claims.AssessmentID != "assessment-1" || claims.SessionID != "session-1" || claims.OrganizationID != "org-1" {
+		t.Errorf("ValidateAccessToken() claims = %+v", claims)
+	}
+
+	// Sessions opened by a sign-in link cannot be refreshed
+	if _, _, err := a.ValidateRefreshToken(token); err == nil {
+		t.Error("ValidateRefreshToken() accepted an assessment token")
+	}
+}
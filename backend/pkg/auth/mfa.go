@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mfaChallengeAudience sets MFA challenge tokens apart from the other tokens
+// signed with the access token secret
+const mfaChallengeAudience = "mfa-challenge"
+
+// GenerateMFAChallengeToken signs the token of the MFA challenge with the
+// given ID, which a user who passed the password check presents with their
+// second factor. The token only identifies the challenge; that it is
+// completed once is up to the caller.
+func (a *Auth) GenerateMFAChallengeToken(challengeID string, expiresAt time.Time) (string, error) {
+	claims := &jwt.RegisteredClaims{
+		ID:        challengeID,
+		Audience:  jwt.ClaimStrings{mfaChallengeAudience},
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+		Issuer:    "qualifyd",
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	tokenString, err := token.SignedString([]byte(a.config.Secret))
+	if err != nil {
+		return "", fmt.Errorf("failed to generate MFA challenge token: %w", err)
+	}
+
+	return tokenString, nil
+}
+
+// ValidateMFAChallengeToken validates an MFA challenge token and returns the
+// challenge's ID
+func (a *Auth) ValidateMFAChallengeToken(tokenString string) (string, error) {
+	if tokenString == "" {
+		return "", ErrNoToken
+	}
+
+	claims := &jwt.RegisteredClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(a.config.Secret), nil
+	}, jwt.WithAudience(mfaChallengeAudience))
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return "", ErrTokenExpired
+		}
+		return "", fmt.Errorf("failed to parse MFA challenge token: %w", err)
+	}
+
+	if !token.Valid || claims.ID == "" {
+		return "", ErrInvalidToken
+	}
+
+	return claims.ID, nil
+}
@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters, the ones authenticator apps assume: HMAC-SHA1, six digits
+// and 30-second steps
+const (
+	totpDigits = 6
+	totpPeriod = 30
+	// totpSkew is how many steps either side of the current one are
+	// accepted, for clocks that are off
+	totpSkew = 1
+)
+
+// recoveryCodeAlphabet leaves out characters that are easily confused. It has
+// 32 characters, so that every random byte maps to one without bias.
+const recoveryCodeAlphabet = "abcdefghjkmnpqrstuvwxyz023456789"
+
+// base32NoPadding is the encoding of TOTP secrets
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret returns a new base32-encoded TOTP secret
+func GenerateTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate TOTP secret: %w", err)
+	}
+	return base32NoPadding.EncodeToString(b), nil
+}
+
+// TOTPProvisioningURI returns the otpauth URI authenticator apps read from a
+// QR code to add account at issuer
+func TOTPProvisioningURI(issuer, account, secret string) string {
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", issuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(totpDigits))
+	q.Set("period", fmt.Sprint(totpPeriod))
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: q.Encode(),
+	}
+	return u.String()
+}
+
+// TOTPStep returns the time step t falls in
+func TOTPStep(t time.Time) int64 {
+	return t.Unix() / totpPeriod
+}
+
+// TOTPCode returns the code of secret at the given time step
+func TOTPCode(secret string, step int64) (string, error) {
+	key, err := base32NoPadding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", fmt.Errorf("invalid TOTP secret: %w", err)
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%mod), nil
+}
+
+// ValidateTOTP checks code against secret at now, allowing for clock skew,
+// and returns the time step it is the code of. Steps up to lastStep were
+// already used and are refused, so that each code signs in once.
+func ValidateTOTP(secret, code string, now time.Time, lastStep int64) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := TOTPStep(now)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		want, err := TOTPCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(want), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// GenerateRecoveryCodes returns n new single-use recovery codes, formatted
+// as two groups of five characters
+func GenerateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, n)
+	b := make([]byte, 10)
+	for i := range codes {
+		if _, err := rand.Read(b); err != nil {
+			return nil, fmt.Errorf("failed to generate recovery codes: %w", err)
+		}
+		var sb strings.Builder
+		for j, c := range b {
+			if j == 5 {
+				sb.WriteByte('-')
+			}
+			sb.WriteByte(recoveryCodeAlphabet[c&31])
+		}
+		codes[i] = sb.String()
+	}
+	return codes, nil
+}
+
+// NormalizeRecoveryCode returns a recovery code as it is hashed, so that
+// codes typed in another case or without the dash are accepted
+func NormalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
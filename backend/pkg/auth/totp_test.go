@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 test vectors for SHA-1, truncated to six digits
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, tt := range tests {
+		got, err := TOTPCode(secret, TOTPStep(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("TOTPCode() error = %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("TOTPCode() at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("GenerateTOTPSecret() error = %v", err)
+	}
+	now := time.Now()
+	step := TOTPStep(now)
+	code := func(step int64) string {
+		c, err := TOTPCode(secret, step)
+		if err != nil {
+			t.Fatalf("TOTPCode() error = %v", err)
+		}
+		return c
+	}
+
+	if got, ok := ValidateTOTP(secret, code(step), now, 0); !ok || got != step {
+		t.Errorf("ValidateTOTP() of the current code = %d, %v, want %d, true", got, ok, step)
+	}
+	// A step either side is accepted for clock skew, two are not
+	if _, ok := ValidateTOTP(secret, code(step-1), now, 0); !ok {
+		t.Error("ValidateTOTP() refused the previous code")
+	}
+	if _, ok := ValidateTOTP(secret, code(step-2), now, 0); ok {
+		t.Error("ValidateTOTP() accepted a code two steps old")
+	}
+	// Used codes are refused
+	if _, ok := ValidateTOTP(secret, code(step), now, step); ok {
+		t.Error("ValidateTOTP() accepted a used code")
+	}
+	if _, ok := ValidateTOTP(secret, "12345", now, 0); ok {
+		t.Error("ValidateTOTP() accepted a short code")
+	}
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	uri := TOTPProvisioningURI("Qualifyd", "jane@example.com", "SECRET")
+	if !strings.HasPrefix(uri, "otpauth://totp/Qualifyd:jane@example.com?") ||
+		!strings.Contains(uri, "secret=SECRET") || !strings.Contains(uri, "issuer=Qualifyd") {
+		t.Errorf("TOTPProvisioningURI() = %s", uri)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatalf("GenerateRecoveryCodes() error = %v", err)
+	}
+	seen := make(map[string]bool)
+	for _, c := range codes {
+		if len(c) != 11 || c[5] != '-' {
+			t.Errorf("GenerateRecoveryCodes() code = %q, want xxxxx-xxxxx", c)
+		}
+		if seen[c] {
+			t.Errorf("GenerateRecoveryCodes() returned %q twice", c)
+		}
+		seen[c] = true
+	}
+
+	if NormalizeRecoveryCode(" ABCDE-fghjk ") != NormalizeRecoveryCode("abcdefghjk") {
+		t.Error("NormalizeRecoveryCode() depends on case or the dash")
+	}
+}
+
+func TestMFAChallengeToken(t *testing.T) {
+	a := newTestAuth()
+
+	token, err := a.GenerateMFAChallengeToken("challenge-1", time.Now().Add(5*time.Minute))
+	if err != nil {
+		t.Fatalf("GenerateMFAChallengeToken() error = %v", err)
+	}
+	id, err := a.ValidateMFAChallengeToken(token)
+	if err != nil || id != "challenge-1" {
+		t.Errorf("ValidateMFAChallengeToken() = %q, %v, want challenge-1", id, err)
+	}
+
+	// Challenge tokens do not sign in, and other tokens are not challenges
+	if _, err := a.ValidateAccessToken(token); err == nil {
+		t.Error("ValidateAccessToken() accepted an MFA challenge token")
+	}
+	link, err := a.GenerateMagicLinkToken("link-1", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GenerateMagicLinkToken() error = %v", err)
+	}
+	if _, err := a.ValidateMFAChallengeToken(link); err == nil {
+		t.Error("ValidateMFAChallengeToken() accepted a magic link token")
+	}
+
+	expired, err := a.GenerateMFAChallengeToken("challenge-1", time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("GenerateMFAChallengeToken() error = %v", err)
+	}
+	if _, err := a.ValidateMFAChallengeToken(expired); err != ErrTokenExpired {
+		t.Errorf("ValidateMFAChallengeToken() of an expired token error = %v, want ErrTokenExpired", err)
+	}
+}
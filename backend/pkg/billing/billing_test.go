@@ -0,0 +1,182 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestProrate(t *testing.T) {
+	start := time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		old  float64
+		new  float64
+		now  time.Time
+		want float64
+	}{
+		{"upgrade at period start", 49, 199, start, 150},
+		{"upgrade halfway", 49, 199, start.Add(15 * 24 * time.Hour), 75},
+		{"downgrade halfway", 199, 49, start.Add(15 * 24 * time.Hour), -75},
+		{"upgrade with a third left", 0, 100, start.Add(20 * 24 * time.Hour), 33.33},
+		{"period over", 49, 199, end, 0},
+		{"before period start", 49, 199, start.Add(-time.Hour), 150},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Prorate(tt.old, tt.new, start, end, tt.now); got != tt.want {
+				t.Errorf("Expected %.2f, got %.2f", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	now := time.Unix(1735689600, 0)
+	payload := []byte(`{"id":"evt_1"}`)
+	header := Sign("secret", payload, now)
+
+	if err := VerifySignature("secret", payload, header, now.Add(time.Minute)); err != nil {
+		t.Errorf("Expected valid signature, got %v", err)
+	}
+	if err := VerifySignature("secret", payload, "t=1,v1=00,"+header, now); err != nil {
+		t.Errorf("Expected any matching v1 signature to be accepted, got %v", err)
+	}
+
+	invalid := []struct {
+		name    string
+		secret  string
+		payload []byte
+		header  string
+		now     time.Time
+	}{
+		{"wrong secret", "other", payload, header, now},
+		{"tampered payload", "secret", []byte(`{"id":"evt_2"}`), header, now},
+		{"replayed", "secret", payload, header, now.Add(SignatureTolerance + time.Second)},
+		{"missing header", "secret", payload, "", now},
+		{"malformed timestamp", "secret", payload, "t=abc,v1=00", now},
+	}
+	for _, tt := range invalid {
+		if err := VerifySignature(tt.secret, tt.payload, tt.header, tt.now); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("%s: expected ErrInvalidSignature, got %v", tt.name, err)
+		}
+	}
+}
+
+func TestFakeProviderCharge(t *testing.T) {
+	ctx := context.Background()
+	provider := NewFakeProvider("secret")
+
+	customerID, err := provider.CreateCustomer(ctx, Customer{OrganizationID: "org-1", Name: "Acme"})
+	if err != nil {
+		t.Fatalf("Failed to create customer: %v", err)
+	}
+
+	req := ChargeRequest{CustomerID: customerID, Amount: 4900, Currency: "USD", IdempotencyKey: "renewal:org-1:1"}
+	first, err := provider.Charge(ctx, req)
+	if err != nil {
+		t.Fatalf("Failed to charge: %v", err)
+	}
+	if first.Status != ChargeSucceeded {
+		t.Errorf("Expected succeeded charge, got %q", first.Status)
+	}
+
+	provider.FailCharges = true
+	retried, err := provider.Charge(ctx, req)
+	if err != nil {
+		t.Fatalf("Failed to retry charge: %v", err)
+	}
+	if retried.ID != first.ID || retried.Status != ChargeSucceeded {
+		t.Errorf("Expected retry to return the original charge %s, got %s (%s)", first.ID, retried.ID, retried.Status)
+	}
+	if n := len(provider.Charges()); n != 1 {
+		t.Errorf("Expected 1 charge to reach the provider, got %d", n)
+	}
+
+	req.IdempotencyKey = "renewal:org-1:2"
+	declined, err := provider.Charge(ctx, req)
+	if err != nil {
+		t.Fatalf("Failed to charge: %v", err)
+	}
+	if declined.Status != ChargeFailed || declined.FailureReason == "" {
+		t.Errorf("Expected failed charge with a reason, got %+v", declined)
+	}
+}
+
+func TestFakeProviderWebhook(t *testing.T) {
+	provider := NewFakeProvider("secret")
+
+	payload, header := provider.Webhook(WebhookEvent{Type: EventChargeRefunded, ChargeID: "ch_1"})
+	event, err := provider.ParseWebhook(payload, header)
+	if err != nil {
+		t.Fatalf("Failed to parse webhook: %v", err)
+	}
+	if event.Type != EventChargeRefunded || event.ChargeID != "ch_1" || event.ID == "" {
+		t.Errorf("Unexpected event %+v", event)
+	}
+
+	if _, err := NewFakeProvider("other").ParseWebhook(payload, header); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Expected ErrInvalidSignature, got %v", err)
+	}
+}
+
+func TestStripeProviderCharge(t *testing.T) {
+	var idempotencyKey string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Fatalf("Failed to parse form: %v", err)
+		}
+		idempotencyKey = r.Header.Get("Idempotency-Key")
+
+		if r.Form.Get("customer") == "cus_declined" {
+			w.WriteHeader(http.StatusPaymentRequired)
+			w.Write([]byte(`{"error":{"type":"card_error","message":"Your card was declined.","payment_intent":{"id":"pi_2"}}}`))
+			return
+		}
+		w.Write([]byte(`{"id":"pi_1","status":"succeeded","latest_charge":{"receipt_url":"https://pay.example.com/r/1"}}`))
+	}))
+	defer server.Close()
+
+	provider := NewStripeProvider(server.URL, "sk_test", "whsec")
+
+	result, err := provider.Charge(context.Background(), ChargeRequest{CustomerID: "cus_1", Amount: 100, Currency: "USD", IdempotencyKey: "key-1"})
+	if err != nil {
+		t.Fatalf("Failed to charge: %v", err)
+	}
+	if result.ID != "pi_1" || result.Status != ChargeSucceeded || result.ReceiptURL == "" {
+		t.Errorf("Unexpected charge result %+v", result)
+	}
+	if idempotencyKey != "key-1" {
+		t.Errorf("Expected Idempotency-Key header key-1, got %q", idempotencyKey)
+	}
+
+	result, err = provider.Charge(context.Background(), ChargeRequest{CustomerID: "cus_declined", Amount: 100, Currency: "USD"})
+	if err != nil {
+		t.Fatalf("Expected a declined card to be a failed charge, got error %v", err)
+	}
+	if result.ID != "pi_2" || result.Status != ChargeFailed || result.FailureReason != "Your card was declined." {
+		t.Errorf("Unexpected charge result %+v", result)
+	}
+}
+
+func TestStripeProviderParseWebhook(t *testing.T) {
+	provider := NewStripeProvider("", "sk_test", "whsec")
+	payload := []byte(`{"id":"evt_1","type":"charge.succeeded","data":{"object":{"id":"ch_1","payment_intent":"pi_1","receipt_url":"https://pay.example.com/r/1"}}}`)
+
+	header := http.Header{}
+	header.Set(StripeSignatureHeader, Sign("whsec", payload, time.Now()))
+
+	event, err := provider.ParseWebhook(payload, header)
+	if err != nil {
+		t.Fatalf("Failed to parse webhook: %v", err)
+	}
+	if event.Type != EventChargeSucceeded || event.ChargeID != "pi_1" || event.ReceiptURL == "" {
+		t.Errorf("Unexpected event %+v", event)
+	}
+}
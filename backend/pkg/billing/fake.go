@@ -0,0 +1,140 @@
+package billing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// FakeSignatureHeader carries the signature of FakeProvider webhook deliveries
+const FakeSignatureHeader = "X-Fake-Signature"
+
+// FakeProvider is an in-memory PaymentProvider for development and tests.
+// Charges succeed unless FailCharges or PendingCharges is set, and charges
+// with the same idempotency key return the first result.
+type FakeProvider struct {
+	// FailCharges makes every charge fail as declined
+	FailCharges bool
+	// PendingCharges leaves every charge pending until a webhook settles it
+	PendingCharges bool
+
+	webhookSecret string
+
+	mu        sync.Mutex
+	customers map[string]Customer
+	methods   map[string]string
+	charges   map[string]*ChargeResult
+	requests  []ChargeRequest
+}
+
+// NewFakeProvider creates a new FakeProvider that verifies webhooks with webhookSecret
+func NewFakeProvider(webhookSecret string) *FakeProvider {
+	return &FakeProvider{
+		webhookSecret: webhookSecret,
+		customers:     make(map[string]Customer),
+		methods:       make(map[string]string),
+		charges:       make(map[string]*ChargeResult),
+	}
+}
+
+// Name implements PaymentProvider
+func (p *FakeProvider) Name() string {
+	return "fake"
+}
+
+// CreateCustomer implements PaymentProvider
+func (p *FakeProvider) CreateCustomer(ctx context.Context, customer Customer) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	id := "cus_" + uuid.New().String()
+	p.customers[id] = customer
+	return id, nil
+}
+
+// AttachPaymentMethod implements PaymentProvider. Any non-empty token is a card.
+func (p *FakeProvider) AttachPaymentMethod(ctx context.Context, customerID, token string) (*PaymentMethod, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.customers[customerID]; !ok {
+		return nil, fmt.Errorf("unknown customer %q", customerID)
+	}
+	if token == "" {
+		return nil, fmt.Errorf("payment method token is required")
+	}
+
+	p.methods[customerID] = token
+	return &PaymentMethod{ID: token, Type: "card", Brand: "visa", Last4: "4242"}, nil
+}
+
+// Charge implements PaymentProvider
+func (p *FakeProvider) Charge(ctx context.Context, req ChargeRequest) (*ChargeResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if result, ok := p.charges[req.IdempotencyKey]; ok && req.IdempotencyKey != "" {
+		copied := *result
+		return &copied, nil
+	}
+	if _, ok := p.customers[req.CustomerID]; !ok {
+		return nil, fmt.Errorf("unknown customer %q", req.CustomerID)
+	}
+
+	id := "ch_" + uuid.New().String()
+	result := &ChargeResult{ID: id, Status: ChargeSucceeded, ReceiptURL: "https://payments.example.com/receipts/" + id}
+	switch {
+	case p.FailCharges:
+		result = &ChargeResult{ID: id, Status: ChargeFailed, FailureReason: "card declined"}
+	case p.PendingCharges:
+		result = &ChargeResult{ID: id, Status: ChargePending}
+	}
+
+	p.requests = append(p.requests, req)
+	if req.IdempotencyKey != "" {
+		p.charges[req.IdempotencyKey] = result
+	}
+	copied := *result
+	return &copied, nil
+}
+
+// Charges returns every charge request that reached the provider
+func (p *FakeProvider) Charges() []ChargeRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]ChargeRequest(nil), p.requests...)
+}
+
+// ParseWebhook implements PaymentProvider. Payloads are JSON-encoded WebhookEvents.
+func (p *FakeProvider) ParseWebhook(payload []byte, header http.Header) (*WebhookEvent, error) {
+	if err := VerifySignature(p.webhookSecret, payload, header.Get(FakeSignatureHeader), time.Now()); err != nil {
+		return nil, err
+	}
+
+	var event WebhookEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, fmt.Errorf("failed to decode fake event: %w", err)
+	}
+	if event.Type == "" {
+		event.Type = EventIgnored
+	}
+	return &event, nil
+}
+
+// Webhook returns a signed delivery of event, as ParseWebhook expects it
+func (p *FakeProvider) Webhook(event WebhookEvent) ([]byte, http.Header) {
+	if event.ID == "" {
+		event.ID = "evt_" + uuid.New().String()
+	}
+	payload, _ := json.Marshal(event)
+
+	header := http.Header{}
+	header.Set(FakeSignatureHeader, Sign(p.webhookSecret, payload, time.Now()))
+	return payload, header
+}
@@ -0,0 +1,111 @@
+// Package billing charges organizations for their subscription through a
+// payment provider and keeps billing_records in step with the provider.
+//
+// A charge is written as a pending billing record before the provider is
+// called, keyed by an idempotency key derived from what is being paid for, so
+// a retried renewal or plan change finds the existing record and the provider
+// deduplicates the request. The record then settles from the provider's
+// response or, for charges that complete asynchronously, from its
+// signature-verified webhook callbacks:
+//
+//	pending    -> successful  charge succeeded
+//	pending    -> failed      charge was declined
+//	failed     -> successful  the provider's retry succeeded
+//	successful -> refunded    charge was refunded
+package billing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// Charge statuses reported by a PaymentProvider
+const (
+	ChargeSucceeded = "succeeded"
+	ChargePending   = "pending"
+	ChargeFailed    = "failed"
+)
+
+// Webhook event types reported by a PaymentProvider
+const (
+	EventChargeSucceeded = "charge.succeeded"
+	EventChargeFailed    = "charge.failed"
+	EventChargeRefunded  = "charge.refunded"
+	// EventIgnored is any provider event billing does not act on
+	EventIgnored = "ignored"
+)
+
+var (
+	// ErrInvalidSignature is returned when a webhook payload is not signed
+	// with the configured secret or its timestamp is out of tolerance
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	// ErrNoPaymentMethod is returned when charging an organization that has
+	// no payment method on file
+	ErrNoPaymentMethod = errors.New("organization has no payment method")
+	// ErrChargeFailed is returned when the provider declines a charge
+	ErrChargeFailed = errors.New("charge failed")
+)
+
+// Customer is an organization as registered with the payment provider
+type Customer struct {
+	OrganizationID string
+	Name           string
+	Email          string
+}
+
+// PaymentMethod is a payment method attached to a customer
+type PaymentMethod struct {
+	ID    string `json:"id"`
+	Type  string `json:"type"`
+	Brand string `json:"brand,omitempty"`
+	Last4 string `json:"last4,omitempty"`
+}
+
+// ChargeRequest is a single charge against a customer's payment method
+type ChargeRequest struct {
+	CustomerID      string
+	PaymentMethodID string
+	// Amount is in the currency's smallest unit, e.g. cents
+	Amount      int64
+	Currency    string
+	Description string
+	// IdempotencyKey makes retries of the same charge return the original
+	// charge instead of charging again
+	IdempotencyKey string
+	Metadata       map[string]string
+}
+
+// ChargeResult is the provider's answer to a ChargeRequest
+type ChargeResult struct {
+	ID            string
+	Status        string
+	ReceiptURL    string
+	FailureReason string
+}
+
+// WebhookEvent is a verified provider callback about a charge
+type WebhookEvent struct {
+	ID            string
+	Type          string
+	ChargeID      string
+	ReceiptURL    string
+	FailureReason string
+}
+
+// PaymentProvider is a payment gateway that billing charges through
+type PaymentProvider interface {
+	// Name identifies the provider, e.g. in webhook event IDs
+	Name() string
+	// CreateCustomer registers an organization and returns its customer ID
+	CreateCustomer(ctx context.Context, customer Customer) (string, error)
+	// AttachPaymentMethod attaches the payment method identified by a
+	// client-side token to the customer and makes it the default
+	AttachPaymentMethod(ctx context.Context, customerID, token string) (*PaymentMethod, error)
+	// Charge charges a payment method. A declined charge is reported as a
+	// ChargeFailed result, not an error.
+	Charge(ctx context.Context, req ChargeRequest) (*ChargeResult, error)
+	// ParseWebhook verifies and decodes a webhook delivery, returning
+	// ErrInvalidSignature if it was not sent by the provider
+	ParseWebhook(payload []byte, header http.Header) (*WebhookEvent, error)
+}
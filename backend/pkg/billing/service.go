@@ -0,0 +1,417 @@
+package billing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/config"
+	"github.com/cstanislawski/qualifyd/pkg/database"
+	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/metrics"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/cstanislawski/qualifyd/pkg/repository"
+)
+
+// Idempotency key prefixes of the charges billing makes
+const (
+	renewalKeyPrefix    = "renewal:"
+	planChangeKeyPrefix = "plan-change:"
+)
+
+// NewProvider creates the payment provider selected by cfg.Provider
+func NewProvider(cfg *config.BillingConfig) (PaymentProvider, error) {
+	switch cfg.Provider {
+	case "stripe":
+		if cfg.StripeSecretKey == "" {
+			return nil, fmt.Errorf("STRIPE_SECRET_KEY is required for the stripe billing provider")
+		}
+		return NewStripeProvider(cfg.StripeAPIURL, cfg.StripeSecretKey, cfg.WebhookSecret), nil
+	case "fake":
+		return NewFakeProvider(cfg.WebhookSecret), nil
+	default:
+		return nil, fmt.Errorf("unknown billing provider %q", cfg.Provider)
+	}
+}
+
+// Service charges organizations and records the charges as billing records
+type Service struct {
+	organizationRepo *repository.OrganizationRepository
+	billingRepo      *repository.BillingRepository
+	quotaRepo        *repository.QuotaRepository
+	provider         PaymentProvider
+	cfg              *config.BillingConfig
+	log              logger.Logger
+}
+
+// NewService creates a new billing Service
+func NewService(
+	organizationRepo *repository.OrganizationRepository,
+	billingRepo *repository.BillingRepository,
+	quotaRepo *repository.QuotaRepository,
+	provider PaymentProvider,
+	cfg *config.BillingConfig,
+	log logger.Logger,
+) *Service {
+	return &Service{
+		organizationRepo: organizationRepo,
+		billingRepo:      billingRepo,
+		quotaRepo:        quotaRepo,
+		provider:         provider,
+		cfg:              cfg,
+		log:              log,
+	}
+}
+
+// SetupPaymentMethod registers the organization with the payment provider if
+// needed and makes the payment method identified by token its default
+func (s *Service) SetupPaymentMethod(ctx context.Context, org *model.Organization, token string) (*PaymentMethod, error) {
+	if org.BillingCustomerID == "" {
+		email := org.BillingEmail
+		if email == "" {
+			email = org.ContactEmail
+		}
+		customerID, err := s.provider.CreateCustomer(ctx, Customer{OrganizationID: org.ID, Name: org.Name, Email: email})
+		if err != nil {
+			return nil, err
+		}
+		if err := s.organizationRepo.SetBillingCustomer(ctx, org.ID, customerID); err != nil {
+			return nil, err
+		}
+		org.BillingCustomerID = customerID
+	}
+
+	method, err := s.provider.AttachPaymentMethod(ctx, org.BillingCustomerID, token)
+	if err != nil {
+		return nil, err
+	}
+
+	details, err := json.Marshal(method)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode payment method: %w", err)
+	}
+	if err := s.organizationRepo.UpdatePaymentMethod(ctx, org.ID, method.Type, details); err != nil {
+		return nil, err
+	}
+	org.PaymentMethod = method.Type
+	org.PaymentMethodDetails = details
+
+	s.log.Info("Organization payment method updated", map[string]interface{}{
+		"organizationID": org.ID,
+		"type":           method.Type,
+	})
+	return method, nil
+}
+
+// ChargeRenewal charges the organization's plan price for the billing period
+// starting at periodStart. It returns false if the charge was declined or the
+// organization has no payment method. Retrying the renewal of the same period
+// never charges twice. It implements subscription.Charger.
+func (s *Service) ChargeRenewal(ctx context.Context, org *model.Organization, periodStart, periodEnd time.Time) (bool, error) {
+	price := s.cfg.PlanPrice(org.SubscriptionPlan)
+	if price <= 0 {
+		return true, nil
+	}
+
+	record := s.newRecord(org, price, fmt.Sprintf("%s plan, %s to %s",
+		planName(org.SubscriptionPlan), periodStart.Format("2006-01-02"), periodEnd.Format("2006-01-02")))
+	record.IdempotencyKey = fmt.Sprintf("%s%s:%d", renewalKeyPrefix, org.ID, periodStart.Unix())
+	record.BillingPeriodStart = periodStart
+	record.BillingPeriodEnd = periodEnd
+
+	_, err := s.charge(ctx, org, record)
+	if errors.Is(err, ErrChargeFailed) || errors.Is(err, ErrNoPaymentMethod) {
+		s.log.Warn("Subscription renewal charge declined", map[string]interface{}{
+			"organizationID": org.ID,
+			"periodStart":    periodStart,
+			"error":          err.Error(),
+		})
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// ChargePlanChange bills the organization for moving to plan. For paying
+// organizations the price difference for the rest of the current billing
+// period is prorated: an upgrade is charged immediately and an error wrapping
+// ErrChargeFailed is returned if the charge is declined, a downgrade is
+// recorded as a credit. The returned billing record is nil if nothing was
+// charged. It implements subscription.PlanCharger.
+func (s *Service) ChargePlanChange(ctx context.Context, org *model.Organization, plan string) (*model.BillingRecord, error) {
+	if plan == org.SubscriptionPlan || !org.HasActiveSubscription() || org.BillingCustomerID == "" {
+		return nil, nil
+	}
+
+	quota, err := s.quotaRepo.GetByOrganization(ctx, org.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	amount := Prorate(s.cfg.PlanPrice(org.SubscriptionPlan), s.cfg.PlanPrice(plan), quota.PeriodStart, quota.PeriodEnd, now)
+	description := fmt.Sprintf("Change from %s to %s plan, prorated to %s",
+		planName(org.SubscriptionPlan), planName(plan), quota.PeriodEnd.Format("2006-01-02"))
+	// The organization's last update identifies this change, so a retried
+	// request reuses the charge while a later change gets a new one
+	key := fmt.Sprintf("%s%s:%s:%s:%d", planChangeKeyPrefix, org.ID, org.SubscriptionPlan, plan, org.UpdatedAt.UnixNano())
+
+	var record *model.BillingRecord
+	switch {
+	case amount > 0:
+		record = s.newRecord(org, amount, description)
+		record.IdempotencyKey = key
+		record.BillingPeriodStart = now
+		record.BillingPeriodEnd = quota.PeriodEnd
+		if record, err = s.charge(ctx, org, record); err != nil {
+			return record, err
+		}
+	case amount < 0:
+		record = s.newRecord(org, amount, "Credit: "+description)
+		record.Status = model.BillingStatusSuccessful
+		record.PaymentDate = now
+		record.IdempotencyKey = key
+		record.BillingPeriodStart = now
+		record.BillingPeriodEnd = quota.PeriodEnd
+		if record, _, err = s.billingRepo.Create(ctx, record); err != nil {
+			return nil, err
+		}
+	}
+
+	return record, nil
+}
+
+// HandleWebhook verifies a payment provider webhook delivery and applies it
+// to the billing record of the charge it is about. It returns an error
+// wrapping ErrInvalidSignature if the delivery is not from the provider.
+func (s *Service) HandleWebhook(ctx context.Context, payload []byte, header http.Header) error {
+	event, err := s.provider.ParseWebhook(payload, header)
+	if err != nil {
+		metrics.BillingWebhookEvents.WithLabelValues("unknown", metrics.ResultError).Inc()
+		return err
+	}
+	if event.Type == EventIgnored {
+		metrics.BillingWebhookEvents.WithLabelValues(event.Type, metrics.ResultSkipped).Inc()
+		return nil
+	}
+
+	claimed, err := s.billingRepo.ClaimWebhookEvent(ctx, s.provider.Name(), event.ID, event.Type)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		metrics.BillingWebhookEvents.WithLabelValues(event.Type, metrics.ResultSkipped).Inc()
+		return nil
+	}
+
+	err = s.applyEvent(ctx, event)
+	metrics.BillingWebhookEvents.WithLabelValues(event.Type, metrics.Result(err)).Inc()
+	if err != nil {
+		if releaseErr := s.billingRepo.ReleaseWebhookEvent(ctx, s.provider.Name(), event.ID); releaseErr != nil {
+			s.log.Error("Failed to release webhook event", releaseErr, map[string]interface{}{
+				"eventID": event.ID,
+			})
+		}
+		return fmt.Errorf("failed to apply %s event %s: %w", event.Type, event.ID, err)
+	}
+
+	return nil
+}
+
+// applyEvent settles the billing record a webhook event is about
+func (s *Service) applyEvent(ctx context.Context, event *WebhookEvent) error {
+	record, err := s.billingRepo.GetByTransactionID(ctx, event.ChargeID)
+	if err != nil {
+		// The provider may call back before the charge's ID was saved;
+		// failing makes it retry the delivery
+		return err
+	}
+
+	var status string
+	switch event.Type {
+	case EventChargeSucceeded:
+		status = model.BillingStatusSuccessful
+	case EventChargeFailed:
+		status = model.BillingStatusFailed
+	case EventChargeRefunded:
+		status = model.BillingStatusRefunded
+	default:
+		return nil
+	}
+
+	if err := s.settle(ctx, record, status, event.ReceiptURL, event.FailureReason); err != nil {
+		return err
+	}
+
+	// A renewal that fails after the subscription was renewed leaves the
+	// payment for the period overdue
+	if record.Status == model.BillingStatusFailed && strings.HasPrefix(record.IdempotencyKey, renewalKeyPrefix) {
+		marked, err := s.organizationRepo.MarkPastDue(ctx, record.OrganizationID, record.BillingPeriodStart)
+		if err != nil {
+			return err
+		}
+		if marked {
+			s.log.Warn("Organization subscription past due after failed renewal", map[string]interface{}{
+				"organizationID":  record.OrganizationID,
+				"billingRecordID": record.ID,
+				"reason":          record.FailureReason,
+			})
+		}
+	}
+
+	return nil
+}
+
+// charge creates a pending billing record and charges it. If a record with
+// the same idempotency key exists, it is charged again only while pending, so
+// an interrupted charge is completed and a settled one is never repeated.
+func (s *Service) charge(ctx context.Context, org *model.Organization, record *model.BillingRecord) (*model.BillingRecord, error) {
+	if org.BillingCustomerID == "" || org.PaymentMethod == "" {
+		return nil, ErrNoPaymentMethod
+	}
+
+	record, _, err := s.billingRepo.Create(ctx, record)
+	if err != nil {
+		return nil, err
+	}
+
+	if record.Status == model.BillingStatusPending {
+		result, err := s.provider.Charge(ctx, ChargeRequest{
+			CustomerID:     org.BillingCustomerID,
+			Amount:         toMinorUnits(record.Amount),
+			Currency:       record.Currency,
+			Description:    record.Description,
+			IdempotencyKey: record.IdempotencyKey,
+			Metadata: map[string]string{
+				"organization_id":   org.ID,
+				"billing_record_id": record.ID,
+			},
+		})
+		if err != nil {
+			return record, err
+		}
+		metrics.BillingCharges.WithLabelValues(s.provider.Name(), result.Status).Inc()
+
+		if result.ID != "" && result.ID != record.TransactionID {
+			if err := s.billingRepo.SetTransactionID(ctx, record.ID, result.ID); err != nil {
+				return record, err
+			}
+			record.TransactionID = result.ID
+		}
+
+		switch result.Status {
+		case ChargeSucceeded:
+			err = s.settle(ctx, record, model.BillingStatusSuccessful, result.ReceiptURL, "")
+		case ChargeFailed:
+			err = s.settle(ctx, record, model.BillingStatusFailed, "", result.FailureReason)
+		}
+		if err != nil {
+			return record, err
+		}
+	}
+
+	if record.Status == model.BillingStatusFailed {
+		return record, fmt.Errorf("%w: %s", ErrChargeFailed, record.FailureReason)
+	}
+	return record, nil
+}
+
+// settle moves a billing record to status and records a successful payment
+// on the organization. Settling a record that already has status is a no-op
+// apart from filling in a missing receipt, so repeated callbacks are harmless.
+func (s *Service) settle(ctx context.Context, record *model.BillingRecord, status, receiptURL, failureReason string) error {
+	if record.Status == status {
+		if receiptURL != "" && record.ReceiptURL == "" {
+			record.ReceiptURL = receiptURL
+			return s.billingRepo.SetReceiptURL(ctx, record.ID, receiptURL)
+		}
+		return nil
+	}
+	if !record.CanTransitionTo(status) {
+		// Providers do not guarantee event order, e.g. a failure reported
+		// after the retry that succeeded
+		s.log.Warn("Ignoring billing record status change", map[string]interface{}{
+			"billingRecordID": record.ID,
+			"from":            record.Status,
+			"to":              status,
+		})
+		return nil
+	}
+
+	update := &model.BillingRecord{Status: status, ReceiptURL: receiptURL, FailureReason: failureReason}
+	if status == model.BillingStatusSuccessful {
+		update.PaymentDate = time.Now().UTC()
+	}
+
+	updated, err := s.billingRepo.UpdateStatus(ctx, record, update)
+	if err != nil {
+		return err
+	}
+	if !updated {
+		return fmt.Errorf("billing record %s changed concurrently", record.ID)
+	}
+
+	s.log.Info("Billing record settled", map[string]interface{}{
+		"billingRecordID": record.ID,
+		"organizationID":  record.OrganizationID,
+		"from":            record.Status,
+		"to":              status,
+	})
+	record.Status = status
+	record.FailureReason = failureReason
+	if receiptURL != "" {
+		record.ReceiptURL = receiptURL
+	}
+
+	if status == model.BillingStatusSuccessful {
+		record.PaymentDate = update.PaymentDate
+		if err := s.organizationRepo.RecordPayment(ctx, record.OrganizationID, record.PaymentDate); err != nil && !errors.Is(err, database.ErrRecordNotFound) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// newRecord creates a pending billing record in the configured currency
+func (s *Service) newRecord(org *model.Organization, amount float64, description string) *model.BillingRecord {
+	record := model.NewBillingRecord(org.ID, amount, model.BillingStatusPending, description)
+	record.Currency = s.cfg.Currency
+	record.PaymentMethod = org.PaymentMethod
+	return record
+}
+
+// Prorate returns the price difference between two monthly plans for the part
+// of the billing period [periodStart, periodEnd) remaining at now, rounded to
+// cents. It is negative when moving to a cheaper plan.
+func Prorate(oldPrice, newPrice float64, periodStart, periodEnd, now time.Time) float64 {
+	period := periodEnd.Sub(periodStart)
+	if period <= 0 || !now.Before(periodEnd) {
+		return 0
+	}
+
+	remaining := periodEnd.Sub(now)
+	if remaining > period {
+		remaining = period
+	}
+
+	amount := (newPrice - oldPrice) * float64(remaining) / float64(period)
+	return math.Round(amount*100) / 100
+}
+
+// toMinorUnits converts an amount to the currency's smallest unit
+func toMinorUnits(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+// planName returns the display name of a plan
+func planName(plan string) string {
+	if plan == "" {
+		return plan
+	}
+	return strings.ToUpper(plan[:1]) + plan[1:]
+}
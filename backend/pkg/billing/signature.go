@@ -0,0 +1,69 @@
+package billing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignatureTolerance is how far a webhook's signed timestamp may be from now.
+// Older deliveries are rejected so a captured payload cannot be replayed.
+const SignatureTolerance = 5 * time.Minute
+
+// Sign returns the signature header value for payload sent at t, in the
+// format "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<payload>">"
+func Sign(secret string, payload []byte, t time.Time) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	return fmt.Sprintf("t=%s,v1=%s", timestamp, computeSignature(secret, timestamp, payload))
+}
+
+// VerifySignature checks a signature header produced by Sign. Any of several
+// v1 signatures may match, which lets the secret be rotated.
+func VerifySignature(secret string, payload []byte, header string, now time.Time) error {
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	if timestamp == "" || len(signatures) == 0 {
+		return ErrInvalidSignature
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if age := now.Sub(time.Unix(seconds, 0)); age > SignatureTolerance || age < -SignatureTolerance {
+		return fmt.Errorf("%w: timestamp outside tolerance", ErrInvalidSignature)
+	}
+
+	expected := []byte(computeSignature(secret, timestamp, payload))
+	for _, signature := range signatures {
+		if hmac.Equal(expected, []byte(signature)) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+// computeSignature returns the hex HMAC-SHA256 of "<timestamp>.<payload>"
+func computeSignature(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
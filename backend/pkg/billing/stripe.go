@@ -0,0 +1,264 @@
+package billing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StripeSignatureHeader carries the signature of Stripe webhook deliveries
+const StripeSignatureHeader = "Stripe-Signature"
+
+// StripeProvider is a PaymentProvider backed by the Stripe API. Charges are
+// off-session PaymentIntents confirmed immediately against the customer's
+// default payment method.
+type StripeProvider struct {
+	apiURL        string
+	secretKey     string
+	webhookSecret string
+	client        *http.Client
+	now           func() time.Time
+}
+
+// NewStripeProvider creates a new StripeProvider
+func NewStripeProvider(apiURL, secretKey, webhookSecret string) *StripeProvider {
+	return &StripeProvider{
+		apiURL:        strings.TrimRight(apiURL, "/"),
+		secretKey:     secretKey,
+		webhookSecret: webhookSecret,
+		client:        &http.Client{Timeout: 30 * time.Second},
+		now:           time.Now,
+	}
+}
+
+// Name implements PaymentProvider
+func (p *StripeProvider) Name() string {
+	return "stripe"
+}
+
+// stripeError is the error body of a failed Stripe API call
+type stripeError struct {
+	Error struct {
+		Type          string        `json:"type"`
+		Code          string        `json:"code"`
+		Message       string        `json:"message"`
+		PaymentIntent *stripeIntent `json:"payment_intent"`
+	} `json:"error"`
+}
+
+// stripeIntent is the subset of a Stripe PaymentIntent billing reads
+type stripeIntent struct {
+	ID               string `json:"id"`
+	Status           string `json:"status"`
+	LastPaymentError *struct {
+		Message string `json:"message"`
+	} `json:"last_payment_error"`
+	LatestCharge *struct {
+		ReceiptURL string `json:"receipt_url"`
+	} `json:"latest_charge"`
+}
+
+// CreateCustomer implements PaymentProvider
+func (p *StripeProvider) CreateCustomer(ctx context.Context, customer Customer) (string, error) {
+	form := url.Values{}
+	form.Set("name", customer.Name)
+	form.Set("email", customer.Email)
+	form.Set("metadata[organization_id]", customer.OrganizationID)
+
+	var created struct {
+		ID string `json:"id"`
+	}
+	if err := p.post(ctx, "/v1/customers", form, "customer-"+customer.OrganizationID, &created); err != nil {
+		return "", fmt.Errorf("failed to create stripe customer: %w", err)
+	}
+
+	return created.ID, nil
+}
+
+// AttachPaymentMethod implements PaymentProvider
+func (p *StripeProvider) AttachPaymentMethod(ctx context.Context, customerID, token string) (*PaymentMethod, error) {
+	form := url.Values{}
+	form.Set("customer", customerID)
+
+	var method struct {
+		ID   string `json:"id"`
+		Type string `json:"type"`
+		Card *struct {
+			Brand string `json:"brand"`
+			Last4 string `json:"last4"`
+		} `json:"card"`
+	}
+	if err := p.post(ctx, "/v1/payment_methods/"+url.PathEscape(token)+"/attach", form, "", &method); err != nil {
+		return nil, fmt.Errorf("failed to attach stripe payment method: %w", err)
+	}
+
+	form = url.Values{}
+	form.Set("invoice_settings[default_payment_method]", method.ID)
+	if err := p.post(ctx, "/v1/customers/"+url.PathEscape(customerID), form, "", nil); err != nil {
+		return nil, fmt.Errorf("failed to set default stripe payment method: %w", err)
+	}
+
+	result := &PaymentMethod{ID: method.ID, Type: method.Type}
+	if method.Card != nil {
+		result.Brand = method.Card.Brand
+		result.Last4 = method.Card.Last4
+	}
+	return result, nil
+}
+
+// Charge implements PaymentProvider
+func (p *StripeProvider) Charge(ctx context.Context, req ChargeRequest) (*ChargeResult, error) {
+	form := url.Values{}
+	form.Set("amount", strconv.FormatInt(req.Amount, 10))
+	form.Set("currency", strings.ToLower(req.Currency))
+	form.Set("customer", req.CustomerID)
+	form.Set("description", req.Description)
+	form.Set("confirm", "true")
+	form.Set("off_session", "true")
+	form.Set("expand[]", "latest_charge")
+	if req.PaymentMethodID != "" {
+		form.Set("payment_method", req.PaymentMethodID)
+	}
+	for key, value := range req.Metadata {
+		form.Set("metadata["+key+"]", value)
+	}
+
+	var intent stripeIntent
+	err := p.post(ctx, "/v1/payment_intents", form, req.IdempotencyKey, &intent)
+	var apiErr *stripeAPIError
+	if errors.As(err, &apiErr) && apiErr.body.Error.Type == "card_error" {
+		// A declined off-session charge is a failed charge, not a failed call
+		result := &ChargeResult{Status: ChargeFailed, FailureReason: apiErr.body.Error.Message}
+		if apiErr.body.Error.PaymentIntent != nil {
+			result.ID = apiErr.body.Error.PaymentIntent.ID
+		}
+		return result, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stripe payment intent: %w", err)
+	}
+
+	return intentResult(&intent), nil
+}
+
+// intentResult maps a PaymentIntent to a ChargeResult. Off-session intents
+// cannot be completed by the customer, so any status that still needs their
+// action counts as failed.
+func intentResult(intent *stripeIntent) *ChargeResult {
+	result := &ChargeResult{ID: intent.ID}
+	if intent.LatestCharge != nil {
+		result.ReceiptURL = intent.LatestCharge.ReceiptURL
+	}
+
+	switch intent.Status {
+	case "succeeded":
+		result.Status = ChargeSucceeded
+	case "processing":
+		result.Status = ChargePending
+	default:
+		result.Status = ChargeFailed
+		result.FailureReason = "payment requires customer action: " + intent.Status
+		if intent.LastPaymentError != nil {
+			result.FailureReason = intent.LastPaymentError.Message
+		}
+	}
+	return result
+}
+
+// ParseWebhook implements PaymentProvider. Charges are identified by their
+// PaymentIntent, so charge events are mapped to the intent they belong to.
+func (p *StripeProvider) ParseWebhook(payload []byte, header http.Header) (*WebhookEvent, error) {
+	if err := VerifySignature(p.webhookSecret, payload, header.Get(StripeSignatureHeader), p.now()); err != nil {
+		return nil, err
+	}
+
+	var event struct {
+		ID   string `json:"id"`
+		Type string `json:"type"`
+		Data struct {
+			Object struct {
+				ID               string `json:"id"`
+				PaymentIntent    string `json:"payment_intent"`
+				ReceiptURL       string `json:"receipt_url"`
+				LastPaymentError *struct {
+					Message string `json:"message"`
+				} `json:"last_payment_error"`
+			} `json:"object"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, fmt.Errorf("failed to decode stripe event: %w", err)
+	}
+
+	object := event.Data.Object
+	result := &WebhookEvent{ID: event.ID, Type: EventIgnored}
+	switch event.Type {
+	case "charge.succeeded":
+		result.Type = EventChargeSucceeded
+		result.ChargeID = object.PaymentIntent
+		result.ReceiptURL = object.ReceiptURL
+	case "payment_intent.payment_failed":
+		result.Type = EventChargeFailed
+		result.ChargeID = object.ID
+		if object.LastPaymentError != nil {
+			result.FailureReason = object.LastPaymentError.Message
+		}
+	case "charge.refunded":
+		result.Type = EventChargeRefunded
+		result.ChargeID = object.PaymentIntent
+	}
+
+	return result, nil
+}
+
+// stripeAPIError is a non-2xx response from the Stripe API
+type stripeAPIError struct {
+	status int
+	body   stripeError
+}
+
+func (e *stripeAPIError) Error() string {
+	return fmt.Sprintf("stripe API returned %d: %s", e.status, e.body.Error.Message)
+}
+
+// post sends a form-encoded request to the Stripe API and decodes the response into out
+func (p *StripeProvider) post(ctx context.Context, path string, form url.Values, idempotencyKey string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.apiURL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.secretKey)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		apiErr := &stripeAPIError{status: resp.StatusCode}
+		_ = json.Unmarshal(body, &apiErr.body)
+		return apiErr
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(body, out)
+}
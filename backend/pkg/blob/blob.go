@@ -0,0 +1,118 @@
+// Package blob stores generated files, such as invoices, by key.
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNotFound is returned when no blob is stored under a key
+var ErrNotFound = errors.New("blob not found")
+
+// ErrInvalidKey is returned for keys that are empty or escape the store
+var ErrInvalidKey = errors.New("invalid blob key")
+
+// Store stores blobs by key. Keys are slash-separated paths such as
+// "statements/<organization>/2025-01-31.pdf".
+type Store interface {
+	// Put stores data under key, replacing any existing blob
+	Put(ctx context.Context, key string, data []byte) error
+	// Get returns the blob stored under key, or ErrNotFound
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Exists reports whether a blob is stored under key
+	Exists(ctx context.Context, key string) (bool, error)
+}
+
+// FileStore is a Store that keeps blobs as files below a directory. Pointing
+// the directory at a shared volume makes blobs available to every replica.
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore creates a FileStore rooted at dir, creating dir if needed
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create blob directory: %w", err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// Put implements Store. The blob is written to a temporary file and renamed
+// into place, so readers never see a partially written blob.
+func (s *FileStore) Put(ctx context.Context, key string, data []byte) error {
+	name, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0o750); err != nil {
+		return fmt.Errorf("failed to create blob directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create blob: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write blob: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write blob: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return fmt.Errorf("failed to store blob: %w", err)
+	}
+
+	return nil
+}
+
+// Get implements Store
+func (s *FileStore) Get(ctx context.Context, key string) ([]byte, error) {
+	name, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+		}
+		return nil, fmt.Errorf("failed to read blob: %w", err)
+	}
+
+	return data, nil
+}
+
+// Exists implements Store
+func (s *FileStore) Exists(ctx context.Context, key string) (bool, error) {
+	name, err := s.path(key)
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := os.Stat(name); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to stat blob: %w", err)
+	}
+
+	return true, nil
+}
+
+// path maps a key to a file below the store's directory
+func (s *FileStore) path(key string) (string, error) {
+	cleaned := path.Clean("/" + key)
+	if key == "" || cleaned == "/" || strings.Contains(key, "\\") || cleaned != "/"+key {
+		return "", fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(cleaned[1:])), nil
+}
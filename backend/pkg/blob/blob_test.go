@@ -0,0 +1,36 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestFileStore(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+
+	if _, err := store.Get(ctx, "statements/org/2025-01-01.pdf"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	if err := store.Put(ctx, "statements/org/2025-01-01.pdf", []byte("%PDF")); err != nil {
+		t.Fatalf("Failed to put blob: %v", err)
+	}
+	data, err := store.Get(ctx, "statements/org/2025-01-01.pdf")
+	if err != nil || string(data) != "%PDF" {
+		t.Errorf("Expected stored blob, got %q (%v)", data, err)
+	}
+	if exists, err := store.Exists(ctx, "statements/org/2025-01-01.pdf"); err != nil || !exists {
+		t.Errorf("Expected blob to exist, got %v (%v)", exists, err)
+	}
+
+	for _, key := range []string{"", "../escape", "a/../../escape", "/absolute", "a//b", `a\b`} {
+		if err := store.Put(ctx, key, nil); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Key %q: expected ErrInvalidKey, got %v", key, err)
+		}
+	}
+}
@@ -8,11 +8,27 @@ import (
 
 // Config represents the application configuration
 type Config struct {
-	Server   ServerConfig
-	Database DatabaseConfig
-	RabbitMQ RabbitMQConfig
-	Log      LogConfig
-	JWT      JWTConfig
+	Server        ServerConfig
+	Database      DatabaseConfig
+	RabbitMQ      RabbitMQConfig
+	Log           LogConfig
+	JWT           JWTConfig
+	Session       SessionConfig
+	Tracing       TracingConfig
+	Metering      MeteringConfig
+	PodReaper     PodReaperConfig
+	Subscription  SubscriptionConfig
+	Billing       BillingConfig
+	Blob          BlobConfig
+	Webhook       WebhookConfig
+	Events        EventsConfig
+	Jobs          JobsConfig
+	Email         EmailConfig
+	PasswordReset PasswordResetConfig
+	SSO           SSOConfig
+	MFA           MFAConfig
+	RBAC          RBACConfig
+	RateLimit     RateLimitConfig
 }
 
 // ServerConfig holds server-related configuration
@@ -20,6 +36,9 @@ type ServerConfig struct {
 	Host string
 	Port int
 	CORS CORSConfig
+	// ShutdownTimeout bounds how long in-flight requests and terminal
+	// sessions are given to drain after a termination signal
+	ShutdownTimeout time.Duration
 }
 
 // CORSConfig holds CORS-related configuration
@@ -59,12 +78,249 @@ type LogConfig struct {
 	Format string
 }
 
+// TracingConfig holds OpenTelemetry tracing configuration
+type TracingConfig struct {
+	// Exporter selects where spans are sent: "none", "stdout" or "otlp"
+	Exporter    string
+	ServiceName string
+	// OTLPEndpoint is the host:port of an OTLP/HTTP collector
+	OTLPEndpoint string
+	OTLPInsecure bool
+	// SampleRatio is the fraction of new traces that are sampled (0.0 - 1.0)
+	SampleRatio float64
+}
+
+// MeteringConfig holds environment-minute metering configuration
+type MeteringConfig struct {
+	// Interval is how often running terminal pods are metered
+	Interval time.Duration
+	// BillWarmPool bills the time a pod spent pre-started in a warm pool
+	// before it was claimed by an assessment
+	BillWarmPool bool
+}
+
+// SessionConfig holds configuration of server-side sessions
+type SessionConfig struct {
+	// CleanupInterval is how often long-ended sessions are deleted
+	CleanupInterval time.Duration
+}
+
+// PodReaperConfig holds configuration of the deletion of idle terminal pods
+type PodReaperConfig struct {
+	// Enabled turns on deleting terminal pods idle past their TTL. It is off
+	// by default since it deletes pods candidates may come back to.
+	Enabled bool
+	// Interval is how often idle terminal pods are looked for
+	Interval time.Duration
+}
+
+// SubscriptionConfig holds subscription lifecycle configuration
+type SubscriptionConfig struct {
+	// CheckInterval is how often subscriptions and quota periods are evaluated
+	CheckInterval time.Duration
+	// PastDueGracePeriod is how long a past due organization keeps its
+	// subscription before it is suspended
+	PastDueGracePeriod time.Duration
+}
+
+// BillingConfig holds payment provider configuration
+type BillingConfig struct {
+	// Provider is the payment provider: "stripe", or "fake" for an in-memory
+	// gateway that accepts every charge
+	Provider string
+	// Currency is the ISO 4217 code plans are charged in
+	Currency string
+	// WebhookSecret verifies the signature of payment provider webhooks
+	WebhookSecret string
+	// Stripe API credentials
+	StripeSecretKey string
+	StripeAPIURL    string
+	// Monthly price of each plan, in Currency
+	StarterPrice    float64
+	TeamPrice       float64
+	EnterprisePrice float64
+	// OveragePricePerMinute is the price of environment minutes used beyond
+	// those included in the plan, shown on statements
+	OveragePricePerMinute float64
+	// StatementIssuer and StatementIssuerAddress head every statement
+	StatementIssuer        string
+	StatementIssuerAddress string
+	// StatementInterval is how often organizations whose billing period
+	// ended are checked for a missing statement
+	StatementInterval time.Duration
+}
+
+// BlobConfig holds configuration of the store for generated files
+type BlobConfig struct {
+	// Dir is the directory blobs are kept in; share it between replicas
+	Dir string
+}
+
+// WebhookConfig holds configuration of outbound webhook delivery
+type WebhookConfig struct {
+	// MaxAttempts is how many times a delivery is attempted before it is
+	// moved to the dead-letter queue
+	MaxAttempts int
+	// RetryBaseDelay is the delay before the first retry; it doubles with
+	// every further attempt
+	RetryBaseDelay time.Duration
+	// Timeout bounds each request to a webhook endpoint
+	Timeout time.Duration
+}
+
+// EventsConfig holds configuration of the domain event outbox relay
+type EventsConfig struct {
+	// RelayInterval is how often the outbox is polled for unpublished events
+	RelayInterval time.Duration
+	// RelayBatchSize is the number of events published per outbox query
+	RelayBatchSize int
+	// Retention is how long published events, and the consumers' record of
+	// processed events, are kept
+	Retention time.Duration
+	// CleanupInterval is how often events older than Retention are deleted
+	CleanupInterval time.Duration
+}
+
+// JobsConfig holds configuration of the background job queue
+type JobsConfig struct {
+	// Concurrency is the number of jobs a worker runs at the same time
+	Concurrency int
+	// StaleAfter is how long a queued job may wait before its message is
+	// considered lost and republished. It is also the grace period after a
+	// running job's timeout before another worker may take it over.
+	StaleAfter time.Duration
+	// ProvisionTerminals makes the API server create terminal pods through
+	// the worker instead of in-process
+	ProvisionTerminals bool
+}
+
+// EmailConfig holds configuration of outgoing email
+type EmailConfig struct {
+	// Transport is "smtp", or "file" to write messages to FileDir instead
+	Transport string
+	// From is the sender address, e.g. "Qualifyd <no-reply@qualifyd.io>"
+	From string
+	// AppURL is the frontend's base URL, used for links in emails
+	AppURL       string
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	FileDir      string
+	// ReminderLead is how long before its scheduled start a candidate is
+	// reminded of an assessment
+	ReminderLead time.Duration
+	// ReminderInterval is how often assessments starting within
+	// ReminderLead are looked for
+	ReminderInterval time.Duration
+}
+
+// PasswordResetConfig holds configuration of the forgot-password flow
+type PasswordResetConfig struct {
+	// TokenTTL is how long a reset link stays valid
+	TokenTTL time.Duration
+	// Window is the period over which reset requests are limited to
+	// MaxPerEmail for one email address and MaxPerIP for one client
+	Window      time.Duration
+	MaxPerEmail int
+	MaxPerIP    int
+	// CleanupInterval is how often reset requests older than Window are
+	// deleted
+	CleanupInterval time.Duration
+}
+
+// SSOConfig holds configuration of single sign-on through organizations'
+// identity providers
+type SSOConfig struct {
+	// RedirectURL is the frontend page identity providers send users back
+	// to; it passes the code and state on to the API
+	RedirectURL string
+	// StateTTL is how long a user has to sign in at the identity provider
+	StateTTL time.Duration
+	// StateCleanupInterval is how often expired sign-in states are deleted
+	StateCleanupInterval time.Duration
+	// HTTPTimeout bounds requests to identity providers
+	HTTPTimeout time.Duration
+	// APIURL is the public URL of this API. SAML identity providers know
+	// Qualifyd by entity IDs and assertion consumer service URLs under it.
+	APIURL string
+}
+
+// MFAConfig holds configuration of multi-factor authentication
+type MFAConfig struct {
+	// Issuer is the name authenticator apps show the account under
+	Issuer string
+	// ChallengeTTL is how long a user who passed the password check has to
+	// give their second factor
+	ChallengeTTL time.Duration
+	// ChallengeCleanupInterval is how often expired challenges are deleted
+	ChallengeCleanupInterval time.Duration
+}
+
+// RBACConfig holds configuration of permission checks
+type RBACConfig struct {
+	// CacheTTL is how long a user's resolved permissions are cached. Role
+	// changes made through another instance take up to this long to apply.
+	CacheTTL time.Duration
+}
+
+// RateLimit allows Requests requests at once, refilled evenly over Period.
+// A limit of zero requests is no limit.
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// RateLimitConfig holds configuration of request rate limiting and of the
+// lockout of accounts after failed sign-ins
+type RateLimitConfig struct {
+	// Store keeps the limits' state: "memory" for a single instance, or
+	// "postgres" to share it between replicas
+	Store string
+	// PerIP, PerAccount and PerRoute limit the requests to each limited
+	// route from one client, for one account and from all clients
+	PerIP      RateLimit
+	PerAccount RateLimit
+	PerRoute   RateLimit
+	// Terminal limits the connection attempts to terminals from one client
+	// and to one assessment's terminal
+	Terminal RateLimit
+	// LockoutThreshold failed sign-ins to an account lock it for
+	// LockoutDuration, doubled by every further failure up to
+	// MaxLockoutDuration. Failures are forgotten FailureWindow after the
+	// last one, or on a successful sign-in.
+	LockoutThreshold   int
+	LockoutDuration    time.Duration
+	MaxLockoutDuration time.Duration
+	FailureWindow      time.Duration
+	// CleanupInterval is how often buckets and failures that no longer limit
+	// anything are deleted from the store
+	CleanupInterval time.Duration
+}
+
+// PlanPrice returns the monthly price of plan
+func (c *BillingConfig) PlanPrice(plan string) float64 {
+	switch plan {
+	case "team":
+		return c.TeamPrice
+	case "enterprise":
+		return c.EnterprisePrice
+	default:
+		return c.StarterPrice
+	}
+}
+
 // JWTConfig holds JWT-related configuration
 type JWTConfig struct {
 	Secret                 string
 	ExpirationHours        int
 	RefreshSecret          string
 	RefreshExpirationHours int
+	// MagicLinkExpirationHours is how long a candidate's sign-in link stays
+	// valid, counted from the assessment's scheduled start if that is later
+	MagicLinkExpirationHours int
+	// CandidateSessionHours is how long the session opened by a sign-in link lasts
+	CandidateSessionHours int
 }
 
 // Load loads the configuration from environment variables
@@ -77,6 +333,7 @@ func Load() *Config {
 				AllowedOrigins:   getEnvStringSlice("CORS_ALLOWED_ORIGINS", []string{"http://localhost:3000"}),
 				AllowCredentials: getEnvBool("CORS_ALLOW_CREDENTIALS", true),
 			},
+			ShutdownTimeout: getEnvDuration("SERVER_SHUTDOWN_TIMEOUT", 25*time.Second),
 		},
 		Database: DatabaseConfig{
 			Host:        getEnvString("DATABASE_HOST", "postgresql.qualifyd-dev.svc.cluster.local"),
@@ -103,10 +360,125 @@ func Load() *Config {
 			Format: getEnvString("LOG_FORMAT", "json"),
 		},
 		JWT: JWTConfig{
-			Secret:                 getEnvString("JWT_SECRET", "default-jwt-secret-change-me-in-production"),
-			ExpirationHours:        getEnvInt("JWT_EXPIRATION_HOURS", 24),
-			RefreshSecret:          getEnvString("JWT_REFRESH_SECRET", "default-jwt-refresh-secret-change-me-in-production"),
-			RefreshExpirationHours: getEnvInt("JWT_REFRESH_EXPIRATION_HOURS", 168), // 7 days
+			Secret:                   getEnvString("JWT_SECRET", "default-jwt-secret-change-me-in-production"),
+			ExpirationHours:          getEnvInt("JWT_EXPIRATION_HOURS", 24),
+			RefreshSecret:            getEnvString("JWT_REFRESH_SECRET", "default-jwt-refresh-secret-change-me-in-production"),
+			RefreshExpirationHours:   getEnvInt("JWT_REFRESH_EXPIRATION_HOURS", 168), // 7 days
+			MagicLinkExpirationHours: getEnvInt("MAGIC_LINK_EXPIRATION_HOURS", 72),
+			CandidateSessionHours:    getEnvInt("CANDIDATE_SESSION_HOURS", 8),
+		},
+		Session: SessionConfig{
+			CleanupInterval: getEnvDuration("SESSION_CLEANUP_INTERVAL", 1*time.Hour),
+		},
+		Tracing: TracingConfig{
+			Exporter:     getEnvString("TRACING_EXPORTER", "none"),
+			ServiceName:  getEnvString("TRACING_SERVICE_NAME", "qualifyd-backend"),
+			OTLPEndpoint: getEnvString("TRACING_OTLP_ENDPOINT", "localhost:4318"),
+			OTLPInsecure: getEnvBool("TRACING_OTLP_INSECURE", true),
+			SampleRatio:  getEnvFloat("TRACING_SAMPLE_RATIO", 1.0),
+		},
+		Metering: MeteringConfig{
+			Interval:     getEnvDuration("METERING_INTERVAL", 1*time.Minute),
+			BillWarmPool: getEnvBool("METERING_BILL_WARM_POOL", false),
+		},
+		PodReaper: PodReaperConfig{
+			Enabled:  getEnvBool("POD_REAPER_ENABLED", false),
+			Interval: getEnvDuration("POD_REAPER_INTERVAL", 5*time.Minute),
+		},
+		Subscription: SubscriptionConfig{
+			CheckInterval:      getEnvDuration("SUBSCRIPTION_CHECK_INTERVAL", 15*time.Minute),
+			PastDueGracePeriod: getEnvDuration("SUBSCRIPTION_PAST_DUE_GRACE_PERIOD", 14*24*time.Hour),
+		},
+		Billing: BillingConfig{
+			Provider:               getEnvString("BILLING_PROVIDER", "fake"),
+			Currency:               getEnvString("BILLING_CURRENCY", "USD"),
+			WebhookSecret:          getEnvString("BILLING_WEBHOOK_SECRET", "default-billing-webhook-secret-change-me-in-production"),
+			StripeSecretKey:        getEnvString("STRIPE_SECRET_KEY", ""),
+			StripeAPIURL:           getEnvString("STRIPE_API_URL", "https://api.stripe.com"),
+			StarterPrice:           getEnvFloat("BILLING_PRICE_STARTER", 49),
+			TeamPrice:              getEnvFloat("BILLING_PRICE_TEAM", 199),
+			EnterprisePrice:        getEnvFloat("BILLING_PRICE_ENTERPRISE", 999),
+			OveragePricePerMinute:  getEnvFloat("BILLING_OVERAGE_PRICE_PER_MINUTE", 0.02),
+			StatementIssuer:        getEnvString("BILLING_STATEMENT_ISSUER", "Qualifyd"),
+			StatementIssuerAddress: getEnvString("BILLING_STATEMENT_ISSUER_ADDRESS", ""),
+			StatementInterval:      getEnvDuration("BILLING_STATEMENT_INTERVAL", 1*time.Hour),
+		},
+		Blob: BlobConfig{
+			Dir: getEnvString("BLOB_STORE_DIR", "./data/blobs"),
+		},
+		Webhook: WebhookConfig{
+			MaxAttempts:    getEnvInt("WEBHOOK_MAX_ATTEMPTS", 8),
+			RetryBaseDelay: getEnvDuration("WEBHOOK_RETRY_BASE_DELAY", 1*time.Minute),
+			Timeout:        getEnvDuration("WEBHOOK_TIMEOUT", 10*time.Second),
+		},
+		Events: EventsConfig{
+			RelayInterval:   getEnvDuration("EVENTS_RELAY_INTERVAL", 1*time.Second),
+			RelayBatchSize:  getEnvInt("EVENTS_RELAY_BATCH_SIZE", 100),
+			Retention:       getEnvDuration("EVENTS_RETENTION", 7*24*time.Hour),
+			CleanupInterval: getEnvDuration("EVENTS_CLEANUP_INTERVAL", 1*time.Hour),
+		},
+		Jobs: JobsConfig{
+			Concurrency:        getEnvInt("JOBS_CONCURRENCY", 4),
+			StaleAfter:         getEnvDuration("JOBS_STALE_AFTER", 5*time.Minute),
+			ProvisionTerminals: getEnvBool("JOBS_PROVISION_TERMINALS", true),
+		},
+		Email: EmailConfig{
+			Transport:        getEnvString("EMAIL_TRANSPORT", "file"),
+			From:             getEnvString("EMAIL_FROM", "Qualifyd <no-reply@qualifyd.io>"),
+			AppURL:           getEnvString("APP_URL", "http://localhost:3000"),
+			SMTPHost:         getEnvString("SMTP_HOST", "localhost"),
+			SMTPPort:         getEnvInt("SMTP_PORT", 587),
+			SMTPUsername:     getEnvString("SMTP_USERNAME", ""),
+			SMTPPassword:     getEnvString("SMTP_PASSWORD", ""),
+			FileDir:          getEnvString("EMAIL_FILE_DIR", "./data/mail"),
+			ReminderLead:     getEnvDuration("EMAIL_REMINDER_LEAD", 24*time.Hour),
+			ReminderInterval: getEnvDuration("EMAIL_REMINDER_INTERVAL", 15*time.Minute),
+		},
+		PasswordReset: PasswordResetConfig{
+			TokenTTL:        getEnvDuration("PASSWORD_RESET_TOKEN_TTL", 1*time.Hour),
+			Window:          getEnvDuration("PASSWORD_RESET_WINDOW", 1*time.Hour),
+			MaxPerEmail:     getEnvInt("PASSWORD_RESET_MAX_PER_EMAIL", 3),
+			MaxPerIP:        getEnvInt("PASSWORD_RESET_MAX_PER_IP", 20),
+			CleanupInterval: getEnvDuration("PASSWORD_RESET_CLEANUP_INTERVAL", 1*time.Hour),
+		},
+		SSO: SSOConfig{
+			RedirectURL:          getEnvString("SSO_REDIRECT_URL", "http://localhost:3000/sso/callback"),
+			StateTTL:             getEnvDuration("SSO_STATE_TTL", 10*time.Minute),
+			StateCleanupInterval: getEnvDuration("SSO_STATE_CLEANUP_INTERVAL", 15*time.Minute),
+			HTTPTimeout:          getEnvDuration("SSO_HTTP_TIMEOUT", 10*time.Second),
+			APIURL:               getEnvString("SSO_API_URL", "http://localhost:8080"),
+		},
+		MFA: MFAConfig{
+			Issuer:                   getEnvString("MFA_ISSUER", "Qualifyd"),
+			ChallengeTTL:             getEnvDuration("MFA_CHALLENGE_TTL", 5*time.Minute),
+			ChallengeCleanupInterval: getEnvDuration("MFA_CHALLENGE_CLEANUP_INTERVAL", 15*time.Minute),
+		},
+		RBAC: RBACConfig{
+			CacheTTL: getEnvDuration("RBAC_CACHE_TTL", time.Minute),
+		},
+		RateLimit: RateLimitConfig{
+			Store: getEnvString("RATE_LIMIT_STORE", "memory"),
+			PerIP: RateLimit{
+				Requests: getEnvInt("RATE_LIMIT_IP_REQUESTS", 20),
+				Period:   getEnvDuration("RATE_LIMIT_IP_PERIOD", time.Minute),
+			},
+			PerAccount: RateLimit{
+				Requests: getEnvInt("RATE_LIMIT_ACCOUNT_REQUESTS", 10),
+				Period:   getEnvDuration("RATE_LIMIT_ACCOUNT_PERIOD", time.Minute),
+			},
+			PerRoute: RateLimit{
+				Requests: getEnvInt("RATE_LIMIT_ROUTE_REQUESTS", 600),
+				Period:   getEnvDuration("RATE_LIMIT_ROUTE_PERIOD", time.Minute),
+			},
+			Terminal: RateLimit{
+				Requests: getEnvInt("RATE_LIMIT_TERMINAL_REQUESTS", 30),
+				Period:   getEnvDuration("RATE_LIMIT_TERMINAL_PERIOD", time.Minute),
+			},
+			LockoutThreshold:   getEnvInt("LOGIN_LOCKOUT_THRESHOLD", 5),
+			LockoutDuration:    getEnvDuration("LOGIN_LOCKOUT_DURATION", time.Minute),
+			MaxLockoutDuration: getEnvDuration("LOGIN_MAX_LOCKOUT_DURATION", time.Hour),
+			FailureWindow:      getEnvDuration("LOGIN_FAILURE_WINDOW", 24*time.Hour),
+			CleanupInterval:    getEnvDuration("RATE_LIMIT_CLEANUP_INTERVAL", 15*time.Minute),
 		},
 	}
 }
@@ -138,6 +510,15 @@ func getEnvBool(key string, defaultValue bool) bool {
 	return defaultValue
 }
 
+func getEnvFloat(key string, defaultValue float64) float64 {
+	if value, exists := os.LookupEnv(key); exists {
+		if floatValue, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatValue
+		}
+	}
+	return defaultValue
+}
+
 func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
 	if value, exists := os.LookupEnv(key); exists {
 		if durationValue, err := time.ParseDuration(value); err == nil {
@@ -30,6 +30,8 @@ func New(ctx context.Context, cfg *config.DatabaseConfig) (*Database, error) {
 	poolConfig.MaxConnIdleTime = cfg.MaxIdleTime
 	poolConfig.MaxConnLifetime = cfg.MaxLifetime
 	poolConfig.HealthCheckPeriod = cfg.HealthCheck
+	// Emit a span for every query issued through the pool
+	poolConfig.ConnConfig.Tracer = newQueryTracer(cfg.Name)
 	// Note: LazyConnect is not directly available in pgxpool.Config
 	// If lazy connection is needed, implement connection retries or use a connection manager
 
@@ -100,6 +102,11 @@ func (db *Database) WithTx(ctx context.Context, fn func(pgx.Tx) error) error {
 	return nil
 }
 
+// Begin starts a transaction that the caller must commit or roll back
+func (db *Database) Begin(ctx context.Context) (pgx.Tx, error) {
+	return db.pool.Begin(ctx)
+}
+
 // Pool returns the underlying connection pool
 func (db *Database) Pool() *pgxpool.Pool {
 	return db.pool
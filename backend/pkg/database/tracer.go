@@ -0,0 +1,55 @@
+package database
+
+import (
+	"context"
+	"strings"
+
+	"github.com/cstanislawski/qualifyd/pkg/tracing"
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// queryTracer implements pgx.QueryTracer and creates a client span per query
+type queryTracer struct {
+	tracer trace.Tracer
+	dbName string
+}
+
+// newQueryTracer creates a pgx query tracer for the given database
+func newQueryTracer(dbName string) *queryTracer {
+	return &queryTracer{
+		tracer: tracing.Tracer("database"),
+		dbName: dbName,
+	}
+}
+
+// TraceQueryStart starts a span for the query
+func (t *queryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	ctx, _ = t.tracer.Start(ctx, "db "+queryOperation(data.SQL),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.namespace", t.dbName),
+			attribute.String("db.query.text", data.SQL),
+		),
+	)
+	return ctx
+}
+
+// TraceQueryEnd ends the span started by TraceQueryStart
+func (t *queryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.Int64("db.rows_affected", data.CommandTag.RowsAffected()))
+	tracing.RecordError(span, data.Err)
+	span.End()
+}
+
+// queryOperation returns the leading SQL keyword (SELECT, INSERT, ...) used as the span name
+func queryOperation(sql string) string {
+	fields := strings.Fields(sql)
+	if len(fields) == 0 {
+		return "query"
+	}
+	return strings.ToUpper(fields[0])
+}
@@ -0,0 +1,96 @@
+// Package events is the domain event layer. Repositories store events in the
+// outbox table in the same transaction as the state change they describe. The
+// Relay publishes stored events to the events topic exchange, routed by event
+// type, and Subscribers hand them to consumers as typed structs.
+//
+// Delivery is at least once: an event can be published again if the relay
+// stops between publishing it and marking it published. Every event carries
+// an idempotency key, and Subscribers skip keys they already processed.
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrUnknownEventType is returned when decoding an event of an unregistered type
+var ErrUnknownEventType = errors.New("unknown event type")
+
+// Event is a typed domain event
+type Event interface {
+	// EventType is the event's type and routing key, e.g. "assessment.started"
+	EventType() string
+	// AggregateType and AggregateID identify the entity the event is about
+	AggregateType() string
+	AggregateID() string
+}
+
+// keyedEvent is implemented by events that can happen more than once for the
+// same aggregate and therefore need their own idempotency key
+type keyedEvent interface {
+	IdempotencyKey() string
+}
+
+// Envelope is a stored or published event together with its metadata
+type Envelope struct {
+	ID             string          `json:"id"`
+	Type           string          `json:"type"`
+	IdempotencyKey string          `json:"idempotency_key"`
+	OrganizationID string          `json:"organization_id,omitempty"`
+	AggregateType  string          `json:"aggregate_type"`
+	AggregateID    string          `json:"aggregate_id"`
+	OccurredAt     time.Time       `json:"occurred_at"`
+	Payload        json.RawMessage `json:"payload"`
+}
+
+// NewEnvelope wraps an event of organizationID for storing in the outbox.
+// Its idempotency key is "<type>:<aggregate ID>" unless the event provides
+// its own.
+func NewEnvelope(organizationID string, e Event) (*Envelope, error) {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s event: %w", e.EventType(), err)
+	}
+
+	key := e.EventType() + ":" + e.AggregateID()
+	if k, ok := e.(keyedEvent); ok {
+		key = k.IdempotencyKey()
+	}
+
+	return &Envelope{
+		ID:             uuid.New().String(),
+		Type:           e.EventType(),
+		IdempotencyKey: key,
+		OrganizationID: organizationID,
+		AggregateType:  e.AggregateType(),
+		AggregateID:    e.AggregateID(),
+		OccurredAt:     time.Now().UTC(),
+		Payload:        payload,
+	}, nil
+}
+
+// registry maps event types to constructors of their typed structs
+var registry = map[string]func() Event{}
+
+// register adds an event type to the registry
+func register(eventType string, newEvent func() Event) {
+	registry[eventType] = newEvent
+}
+
+// Decode returns the typed event carried by the envelope
+func (e *Envelope) Decode() (Event, error) {
+	newEvent, ok := registry[e.Type]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownEventType, e.Type)
+	}
+
+	event := newEvent()
+	if err := json.Unmarshal(e.Payload, event); err != nil {
+		return nil, fmt.Errorf("failed to decode %s event: %w", e.Type, err)
+	}
+	return event, nil
+}
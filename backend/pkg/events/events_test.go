@@ -0,0 +1,166 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/rs/zerolog"
+)
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	score := 80
+	envelope, err := NewEnvelope("org-1", &AssessmentCompleted{AssessmentID: "a-1", CandidateID: "c-1", Score: &score})
+	if err != nil {
+		t.Fatalf("NewEnvelope() error = %v", err)
+	}
+	if envelope.Type != TypeAssessmentCompleted || envelope.IdempotencyKey != "assessment.completed:a-1" {
+		t.Errorf("envelope = %+v", envelope)
+	}
+
+	event, err := envelope.Decode()
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	completed, ok := event.(*AssessmentCompleted)
+	if !ok || completed.AssessmentID != "a-1" || completed.Score == nil || *completed.Score != 80 {
+		t.Errorf("Decode() = %#v", event)
+	}
+
+	plan, _ := NewEnvelope("org-1", &PlanChanged{OrganizationID: "org-1", ChangeID: "change-1"})
+	if plan.IdempotencyKey != "plan.changed:change-1" {
+		t.Errorf("PlanChanged idempotency key = %q", plan.IdempotencyKey)
+	}
+
+	unknown := &Envelope{Type: "unknown.event", Payload: json.RawMessage(`{}`)}
+	if _, err := unknown.Decode(); !errors.Is(err, ErrUnknownEventType) {
+		t.Errorf("Decode() of unknown type error = %v", err)
+	}
+}
+
+func TestForAssessmentStatus(t *testing.T) {
+	a := &model.Assessment{ID: "a-1", Status: model.AssessmentStatusScheduled}
+	if e := ForAssessmentStatus(model.AssessmentStatusScheduled, a); e != nil {
+		t.Errorf("unchanged status event = %#v, want nil", e)
+	}
+
+	a.Start()
+	if e, ok := ForAssessmentStatus(model.AssessmentStatusScheduled, a).(*AssessmentStarted); !ok || e.AssessmentID != "a-1" {
+		t.Errorf("start event = %#v", e)
+	}
+
+	a.Expire()
+	if _, ok := ForAssessmentStatus(model.AssessmentStatusInProgress, a).(*AssessmentExpired); !ok {
+		t.Error("expected an AssessmentExpired event")
+	}
+}
+
+type fakeOutbox struct {
+	pending   []*Envelope
+	published []*Envelope
+}
+
+func (f *fakeOutbox) PublishPending(ctx context.Context, limit int, publish func(ctx context.Context, e *Envelope) error) (int, error) {
+	n := 0
+	for len(f.pending) > 0 && n < limit {
+		if err := publish(ctx, f.pending[0]); err != nil {
+			return n, err
+		}
+		f.published = append(f.published, f.pending[0])
+		f.pending = f.pending[1:]
+		n++
+	}
+	return n, nil
+}
+
+func (f *fakeOutbox) DeletePublished(ctx context.Context, before time.Time) (int64, error) {
+	return 0, nil
+}
+
+type fakePublisher struct {
+	fail       bool
+	routingKey []string
+}
+
+func (f *fakePublisher) Publish(ctx context.Context, routingKey string, body []byte, headers amqp.Table) error {
+	if f.fail {
+		return errors.New("broker unavailable")
+	}
+	f.routingKey = append(f.routingKey, routingKey)
+	return nil
+}
+
+func TestRelayPublishPending(t *testing.T) {
+	created, _ := NewEnvelope("org-1", &AssessmentCreated{AssessmentID: "a-1"})
+	started, _ := NewEnvelope("org-1", &AssessmentStarted{AssessmentID: "a-1"})
+	outbox := &fakeOutbox{pending: []*Envelope{created, started}}
+	publisher := &fakePublisher{fail: true}
+	relay := NewRelay(outbox, publisher, 10, time.Hour, logger.NewLogger(zerolog.Nop()))
+
+	if _, err := relay.PublishPending(context.Background()); err == nil {
+		t.Fatal("PublishPending() error = nil, want publish failure")
+	}
+	if len(outbox.pending) != 2 {
+		t.Errorf("pending = %d after failure, want 2", len(outbox.pending))
+	}
+
+	publisher.fail = false
+	n, err := relay.PublishPending(context.Background())
+	if err != nil || n != 2 {
+		t.Fatalf("PublishPending() = %d, %v", n, err)
+	}
+	if publisher.routingKey[0] != TypeAssessmentCreated || publisher.routingKey[1] != TypeAssessmentStarted {
+		t.Errorf("routing keys = %v", publisher.routingKey)
+	}
+}
+
+type fakeProcessed map[string]bool
+
+func (f fakeProcessed) IsProcessed(ctx context.Context, consumer, key string) (bool, error) {
+	return f[consumer+"/"+key], nil
+}
+
+func (f fakeProcessed) MarkProcessed(ctx context.Context, consumer, key string) error {
+	f[consumer+"/"+key] = true
+	return nil
+}
+
+func TestSubscriberSkipsProcessedEvents(t *testing.T) {
+	envelope, _ := NewEnvelope("org-1", &AssessmentStarted{AssessmentID: "a-1"})
+	body, _ := json.Marshal(envelope)
+
+	calls := 0
+	failing := true
+	subscriber := NewSubscriber("test", fakeProcessed{}, func(ctx context.Context, e *Envelope, event Event) error {
+		calls++
+		if _, ok := event.(*AssessmentStarted); !ok {
+			t.Errorf("handler got %T, want *AssessmentStarted", event)
+		}
+		if failing {
+			return errors.New("handler failed")
+		}
+		return nil
+	}, logger.NewLogger(zerolog.Nop()))
+
+	if err := subscriber.Handle(context.Background(), amqp.Delivery{Body: body}); err == nil {
+		t.Error("Handle() error = nil, want the handler's error so the event is requeued")
+	}
+	failing = false
+	for i := 0; i < 2; i++ {
+		if err := subscriber.Handle(context.Background(), amqp.Delivery{Body: body}); err != nil {
+			t.Fatalf("Handle() error = %v", err)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("handler called %d times, want 2 (one failure, one success, duplicate skipped)", calls)
+	}
+
+	if err := subscriber.Handle(context.Background(), amqp.Delivery{Body: []byte("not json")}); err != nil {
+		t.Errorf("Handle() of malformed message error = %v, want nil", err)
+	}
+}
@@ -0,0 +1,130 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/rabbitmq"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Headers set on every published event
+const (
+	HeaderEventID        = "x-event-id"
+	HeaderEventType      = "x-event-type"
+	HeaderIdempotencyKey = "x-idempotency-key"
+)
+
+// OutboxStore holds the events waiting to be published;
+// *repository.OutboxRepository implements it
+type OutboxStore interface {
+	// PublishPending calls publish for up to limit unpublished events, oldest
+	// first, and marks those it succeeded for as published. Events are locked
+	// while being published, so concurrent relays skip them. It stops at the
+	// first failure and returns the number of events published.
+	PublishPending(ctx context.Context, limit int, publish func(ctx context.Context, e *Envelope) error) (int, error)
+	// DeletePublished deletes events published before the given time
+	DeletePublished(ctx context.Context, before time.Time) (int64, error)
+}
+
+// Publisher publishes a message with a routing key to the events exchange
+type Publisher interface {
+	Publish(ctx context.Context, routingKey string, body []byte, headers amqp.Table) error
+}
+
+// channelPublisher publishes to the events exchange on a RabbitMQ channel
+type channelPublisher struct {
+	ch *rabbitmq.Channel
+}
+
+// NewChannelPublisher returns a Publisher that publishes to the events
+// exchange on ch
+func NewChannelPublisher(ch *rabbitmq.Channel) Publisher {
+	return &channelPublisher{ch: ch}
+}
+
+// Publish implements Publisher
+func (p *channelPublisher) Publish(ctx context.Context, routingKey string, body []byte, headers amqp.Table) error {
+	return p.ch.NewPublisher(Exchange, routingKey).Publish(ctx, body, "application/json", headers)
+}
+
+// Relay publishes outbox events to the events exchange
+type Relay struct {
+	outbox    OutboxStore
+	publisher Publisher
+	batchSize int
+	retention time.Duration
+	log       logger.Logger
+}
+
+// NewRelay creates a new Relay. Published events are kept for retention.
+func NewRelay(outbox OutboxStore, publisher Publisher, batchSize int, retention time.Duration, log logger.Logger) *Relay {
+	return &Relay{
+		outbox:    outbox,
+		publisher: publisher,
+		batchSize: batchSize,
+		retention: retention,
+		log:       log,
+	}
+}
+
+// Run publishes pending events every interval until ctx is cancelled. A full
+// batch is followed immediately by the next one.
+func (r *Relay) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			n, err := r.PublishPending(ctx)
+			if err != nil {
+				r.log.Error("Failed to relay outbox events", err, map[string]interface{}{
+					"published": n,
+				})
+			}
+			if err != nil || n < r.batchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PublishPending publishes one batch of pending events
+func (r *Relay) PublishPending(ctx context.Context) (int, error) {
+	return r.outbox.PublishPending(ctx, r.batchSize, r.publish)
+}
+
+// Cleanup deletes events published longer than the retention ago
+func (r *Relay) Cleanup(ctx context.Context) error {
+	deleted, err := r.outbox.DeletePublished(ctx, time.Now().Add(-r.retention))
+	if err != nil {
+		return err
+	}
+	if deleted > 0 {
+		r.log.Info("Deleted published outbox events", map[string]interface{}{
+			"deleted": deleted,
+		})
+	}
+	return nil
+}
+
+// publish publishes one event, routed by its type
+func (r *Relay) publish(ctx context.Context, e *Envelope) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return r.publisher.Publish(ctx, e.Type, body, amqp.Table{
+		HeaderEventID:        e.ID,
+		HeaderEventType:      e.Type,
+		HeaderIdempotencyKey: e.IdempotencyKey,
+	})
+}
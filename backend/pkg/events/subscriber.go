@@ -0,0 +1,104 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/rabbitmq"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Exchange is the topic exchange events are published to, routed by type
+const Exchange = "events"
+
+// ProcessedStore remembers which events a consumer has processed;
+// *repository.OutboxRepository implements it
+type ProcessedStore interface {
+	IsProcessed(ctx context.Context, consumer, idempotencyKey string) (bool, error)
+	MarkProcessed(ctx context.Context, consumer, idempotencyKey string) error
+}
+
+// HandlerFunc processes one event. Returning an error requeues the event.
+type HandlerFunc func(ctx context.Context, envelope *Envelope, event Event) error
+
+// DeclareExchange declares the events exchange on ch
+func DeclareExchange(ch *rabbitmq.Channel) error {
+	return ch.DeclareExchange(Exchange, amqp.ExchangeTopic, true, false, false, false, nil)
+}
+
+// DeclareQueue declares a durable queue on ch bound to the events exchange
+// with each of the binding keys, e.g. "assessment.*"
+func DeclareQueue(ch *rabbitmq.Channel, queue string, bindingKeys ...string) error {
+	if _, err := ch.DeclareQueue(queue, true, false, false, false, nil); err != nil {
+		return err
+	}
+	for _, key := range bindingKeys {
+		if err := ch.BindQueue(queue, key, Exchange, false, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Subscriber decodes events consumed from a queue into their typed structs
+// and hands each to its handler once
+type Subscriber struct {
+	name      string
+	processed ProcessedStore
+	handle    HandlerFunc
+	log       logger.Logger
+}
+
+// NewSubscriber creates a new Subscriber. name identifies the consumer in the
+// processed event log, so it must be unique and stable.
+func NewSubscriber(name string, processed ProcessedStore, handle HandlerFunc, log logger.Logger) *Subscriber {
+	return &Subscriber{
+		name:      name,
+		processed: processed,
+		handle:    handle,
+		log:       log,
+	}
+}
+
+// Handle processes a consumed message; pass it to
+// rabbitmq.Consumer.HandleWithContext. Events already processed by this
+// subscriber, malformed messages and unknown event types are acknowledged
+// without calling the handler.
+func (s *Subscriber) Handle(ctx context.Context, msg amqp.Delivery) error {
+	var envelope Envelope
+	if err := json.Unmarshal(msg.Body, &envelope); err != nil || envelope.IdempotencyKey == "" {
+		s.log.Error("Dropping malformed event", err, map[string]interface{}{
+			"subscriber": s.name,
+			"routingKey": msg.RoutingKey,
+		})
+		return nil
+	}
+
+	event, err := envelope.Decode()
+	if err != nil {
+		if errors.Is(err, ErrUnknownEventType) {
+			return nil
+		}
+		s.log.Error("Dropping undecodable event", err, map[string]interface{}{
+			"subscriber": s.name,
+			"eventID":    envelope.ID,
+			"eventType":  envelope.Type,
+		})
+		return nil
+	}
+
+	done, err := s.processed.IsProcessed(ctx, s.name, envelope.IdempotencyKey)
+	if err != nil {
+		return err
+	}
+	if done {
+		return nil
+	}
+
+	if err := s.handle(ctx, &envelope, event); err != nil {
+		return err
+	}
+	return s.processed.MarkProcessed(ctx, s.name, envelope.IdempotencyKey)
+}
@@ -0,0 +1,126 @@
+package events
+
+import (
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/model"
+)
+
+// Event types. Assessment event types double as webhook event types.
+const (
+	TypeAssessmentCreated   = model.WebhookEventAssessmentCreated
+	TypeAssessmentStarted   = model.WebhookEventAssessmentStarted
+	TypeAssessmentCompleted = model.WebhookEventAssessmentCompleted
+	TypeAssessmentExpired   = model.WebhookEventAssessmentExpired
+	TypePlanChanged         = "plan.changed"
+	TypeReviewerAssigned    = "review.assigned"
+)
+
+// Aggregate types
+const (
+	AggregateAssessment   = "assessment"
+	AggregateOrganization = "organization"
+)
+
+func init() {
+	register(TypeAssessmentCreated, func() Event { return &AssessmentCreated{} })
+	register(TypeAssessmentStarted, func() Event { return &AssessmentStarted{} })
+	register(TypeAssessmentCompleted, func() Event { return &AssessmentCompleted{} })
+	register(TypeAssessmentExpired, func() Event { return &AssessmentExpired{} })
+	register(TypePlanChanged, func() Event { return &PlanChanged{} })
+	register(TypeReviewerAssigned, func() Event { return &ReviewerAssigned{} })
+}
+
+// AssessmentCreated is emitted when an assessment is scheduled for a candidate
+type AssessmentCreated struct {
+	AssessmentID         string    `json:"assessment_id"`
+	AssessmentTemplateID string    `json:"assessment_template_id"`
+	CandidateID          string    `json:"candidate_id"`
+	CreatedBy            string    `json:"created_by"`
+	ScheduledStartTime   time.Time `json:"scheduled_start_time"`
+}
+
+func (e *AssessmentCreated) EventType() string     { return TypeAssessmentCreated }
+func (e *AssessmentCreated) AggregateType() string { return AggregateAssessment }
+func (e *AssessmentCreated) AggregateID() string   { return e.AssessmentID }
+
+// AssessmentStarted is emitted when the candidate starts an assessment
+type AssessmentStarted struct {
+	AssessmentID string    `json:"assessment_id"`
+	CandidateID  string    `json:"candidate_id"`
+	StartedAt    time.Time `json:"started_at"`
+}
+
+func (e *AssessmentStarted) EventType() string     { return TypeAssessmentStarted }
+func (e *AssessmentStarted) AggregateType() string { return AggregateAssessment }
+func (e *AssessmentStarted) AggregateID() string   { return e.AssessmentID }
+
+// AssessmentCompleted is emitted when an assessment is completed
+type AssessmentCompleted struct {
+	AssessmentID string    `json:"assessment_id"`
+	CandidateID  string    `json:"candidate_id"`
+	Score        *int      `json:"score,omitempty"`
+	CompletedAt  time.Time `json:"completed_at"`
+}
+
+func (e *AssessmentCompleted) EventType() string     { return TypeAssessmentCompleted }
+func (e *AssessmentCompleted) AggregateType() string { return AggregateAssessment }
+func (e *AssessmentCompleted) AggregateID() string   { return e.AssessmentID }
+
+// AssessmentExpired is emitted when an assessment expires before it is completed
+type AssessmentExpired struct {
+	AssessmentID string    `json:"assessment_id"`
+	CandidateID  string    `json:"candidate_id"`
+	ExpiredAt    time.Time `json:"expired_at"`
+}
+
+func (e *AssessmentExpired) EventType() string     { return TypeAssessmentExpired }
+func (e *AssessmentExpired) AggregateType() string { return AggregateAssessment }
+func (e *AssessmentExpired) AggregateID() string   { return e.AssessmentID }
+
+// PlanChanged is emitted when an organization moves to another plan
+type PlanChanged struct {
+	OrganizationID string `json:"organization_id"`
+	ChangeID       string `json:"change_id"`
+	FromPlan       string `json:"from_plan"`
+	ToPlan         string `json:"to_plan"`
+	ChangedBy      string `json:"changed_by,omitempty"`
+}
+
+func (e *PlanChanged) EventType() string      { return TypePlanChanged }
+func (e *PlanChanged) AggregateType() string  { return AggregateOrganization }
+func (e *PlanChanged) AggregateID() string    { return e.OrganizationID }
+func (e *PlanChanged) IdempotencyKey() string { return TypePlanChanged + ":" + e.ChangeID }
+
+// ReviewerAssigned is emitted when a reviewer is assigned to an assessment
+type ReviewerAssigned struct {
+	AssessmentID string `json:"assessment_id"`
+	ReviewerID   string `json:"reviewer_id"`
+	AssignedBy   string `json:"assigned_by,omitempty"`
+}
+
+func (e *ReviewerAssigned) EventType() string     { return TypeReviewerAssigned }
+func (e *ReviewerAssigned) AggregateType() string { return AggregateAssessment }
+func (e *ReviewerAssigned) AggregateID() string   { return e.AssessmentID }
+func (e *ReviewerAssigned) IdempotencyKey() string {
+	return TypeReviewerAssigned + ":" + e.AssessmentID + ":" + e.ReviewerID
+}
+
+// ForAssessmentStatus returns the event describing an assessment's move from
+// previousStatus to its current status, or nil if there is none
+func ForAssessmentStatus(previousStatus string, a *model.Assessment) Event {
+	if previousStatus == a.Status {
+		return nil
+	}
+
+	switch a.Status {
+	case model.AssessmentStatusInProgress:
+		return &AssessmentStarted{AssessmentID: a.ID, CandidateID: a.CandidateID, StartedAt: a.ActualStartTime}
+	case model.AssessmentStatusCompleted:
+		return &AssessmentCompleted{AssessmentID: a.ID, CandidateID: a.CandidateID, Score: a.TotalScore, CompletedAt: a.CompletionTime}
+	case model.AssessmentStatusExpired:
+		return &AssessmentExpired{AssessmentID: a.ID, CandidateID: a.CandidateID, ExpiredAt: a.CompletionTime}
+	default:
+		return nil
+	}
+}
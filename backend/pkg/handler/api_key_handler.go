@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/apikey"
+	"github.com/cstanislawski/qualifyd/pkg/audit"
+	"github.com/cstanislawski/qualifyd/pkg/database"
+	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/middleware"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/cstanislawski/qualifyd/pkg/repository"
+	"github.com/go-chi/chi/v5"
+)
+
+// CreateAPIKeyRequest represents the request payload for creating an API key
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// APIKeySecretResponse is an API key together with the key itself, which is
+// only returned when it is created
+type APIKeySecretResponse struct {
+	*model.APIKey
+	Key string `json:"key"`
+}
+
+// APIKeyHandler handles an organization's API keys
+type APIKeyHandler struct {
+	organizationRepo *repository.OrganizationRepository
+	apiKeys          *apikey.Service
+	audit            *audit.Service
+	logger           logger.Logger
+}
+
+// NewAPIKeyHandler creates a new APIKeyHandler instance
+func NewAPIKeyHandler(
+	organizationRepo *repository.OrganizationRepository,
+	apiKeys *apikey.Service,
+	audit *audit.Service,
+	logger logger.Logger,
+) *APIKeyHandler {
+	return &APIKeyHandler{
+		organizationRepo: organizationRepo,
+		apiKeys:          apiKeys,
+		audit:            audit,
+		logger:           logger,
+	}
+}
+
+// HandleListAPIKeys handles listing an organization's API keys, revoked and
+// expired ones included
+func (h *APIKeyHandler) HandleListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	org, ok := loadManagedOrganization(w, r, h.organizationRepo, h.logger)
+	if !ok {
+		return
+	}
+
+	keys, err := h.apiKeys.List(r.Context(), org.ID)
+	if err != nil {
+		h.logger.Error("Failed to list API keys", err, map[string]interface{}{
+			"organization_id": org.ID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to list API keys", "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, keys)
+}
+
+// HandleCreateAPIKey handles creating an API key. The response contains the
+// key, which is not shown again.
+func (h *APIKeyHandler) HandleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	org, ok := loadManagedOrganization(w, r, h.organizationRepo, h.logger)
+	if !ok {
+		return
+	}
+
+	var req CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.logger.Error("Failed to decode create API key request", err, nil)
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload", err.Error())
+		return
+	}
+
+	key := &model.APIKey{
+		OrganizationID: org.ID,
+		Name:           strings.TrimSpace(req.Name),
+		Scopes:         req.Scopes,
+		CreatedBy:      middleware.GetUserID(r),
+		ExpiresAt:      req.ExpiresAt,
+	}
+	if validationErrors := key.Validate(); len(validationErrors) > 0 {
+		respondWithJSON(w, http.StatusBadRequest, map[string]interface{}{
+			"error":  "Invalid API key",
+			"fields": validationErrors,
+		})
+		return
+	}
+
+	secret, err := h.apiKeys.Create(r.Context(), key)
+	if err != nil {
+		h.logger.Error("Failed to create API key", err, map[string]interface{}{
+			"organization_id": org.ID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to create API key", "")
+		return
+	}
+
+	e := auditEvent(r, model.AuditAPIKeyCreated, model.AuditTargetAPIKey, key.ID)
+	e.OrganizationID = org.ID
+	h.audit.RecordChange(r.Context(), e, nil, key)
+
+	respondWithJSON(w, http.StatusCreated, APIKeySecretResponse{
+		APIKey: key,
+		Key:    secret,
+	})
+}
+
+// HandleRevokeAPIKey handles revoking one of an organization's API keys
+func (h *APIKeyHandler) HandleRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	org, ok := loadManagedOrganization(w, r, h.organizationRepo, h.logger)
+	if !ok {
+		return
+	}
+
+	keyID := chi.URLParam(r, "key_id")
+	if err := h.apiKeys.Revoke(r.Context(), org.ID, keyID, middleware.GetUserID(r)); err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			respondWithError(w, http.StatusNotFound, "API key not found", "")
+			return
+		}
+		h.logger.Error("Failed to revoke API key", err, map[string]interface{}{
+			"organization_id": org.ID,
+			"api_key_id":      keyID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to revoke API key", "")
+		return
+	}
+
+	e := auditEvent(r, model.AuditAPIKeyRevoked, model.AuditTargetAPIKey, keyID)
+	e.OrganizationID = org.ID
+	h.audit.Record(r.Context(), e)
+
+	w.WriteHeader(http.StatusNoContent)
+}
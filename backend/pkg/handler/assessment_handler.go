@@ -2,13 +2,22 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/cstanislawski/qualifyd/pkg/audit"
+	"github.com/cstanislawski/qualifyd/pkg/database"
 	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/middleware"
 	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/cstanislawski/qualifyd/pkg/notification"
+	"github.com/cstanislawski/qualifyd/pkg/quota"
 	"github.com/cstanislawski/qualifyd/pkg/repository"
 	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 )
 
 // AssessmentHandler handles HTTP requests for assessments
@@ -16,6 +25,10 @@ type AssessmentHandler struct {
 	assessmentRepo  *repository.AssessmentRepository
 	taskRepo        *repository.TaskRepository
 	environmentRepo *repository.EnvironmentRepository
+	userRepo        *repository.UserRepository
+	quotas          *quota.Service
+	notifications   *notification.Service
+	audit           *audit.Service
 	logger          logger.Logger
 }
 
@@ -24,18 +37,33 @@ func NewAssessmentHandler(
 	assessmentRepo *repository.AssessmentRepository,
 	taskRepo *repository.TaskRepository,
 	environmentRepo *repository.EnvironmentRepository,
+	userRepo *repository.UserRepository,
+	quotas *quota.Service,
+	notifications *notification.Service,
+	audit *audit.Service,
 	logger logger.Logger,
 ) *AssessmentHandler {
 	return &AssessmentHandler{
 		assessmentRepo:  assessmentRepo,
 		taskRepo:        taskRepo,
 		environmentRepo: environmentRepo,
+		userRepo:        userRepo,
+		quotas:          quotas,
+		notifications:   notifications,
+		audit:           audit,
 		logger:          logger,
 	}
 }
 
-// CreateAssessment creates a new assessment
+// CreateAssessment creates a new assessment. The template and the candidate
+// must both belong to the caller's organization.
 func (h *AssessmentHandler) CreateAssessment(w http.ResponseWriter, r *http.Request) {
+	assessmentRepo, err := h.assessmentRepo.ForTenant(r.Context())
+	if err != nil {
+		http.Error(w, "Organization membership required", http.StatusForbidden)
+		return
+	}
+
 	var request struct {
 		TemplateID         string    `json:"template_id"`
 		CandidateID        string    `json:"candidate_id"`
@@ -55,7 +83,7 @@ func (h *AssessmentHandler) CreateAssessment(w http.ResponseWriter, r *http.Requ
 	}
 
 	// Get user context
-	userID := r.Context().Value("userID").(string)
+	userID := middleware.GetUserID(r)
 
 	// Create assessment
 	assessment := model.NewAssessment(
@@ -65,24 +93,189 @@ func (h *AssessmentHandler) CreateAssessment(w http.ResponseWriter, r *http.Requ
 		request.ScheduledStartTime,
 	)
 
-	if err := h.assessmentRepo.Create(r.Context(), assessment); err != nil {
+	// Create the assessment and its tasks against the monthly assessment allowance
+	err = h.quotas.Reserve(r.Context(), middleware.GetOrganizationID(r), quota.ResourceAssessments, func(tx pgx.Tx) error {
+		txRepo := assessmentRepo.WithTx(tx)
+		if err := txRepo.Create(r.Context(), assessment); err != nil {
+			return err
+		}
+		return txRepo.CreateAssessmentTasks(r.Context(), assessment.ID, request.TemplateID)
+	})
+	if err != nil {
+		if respondWithQuotaError(w, err) {
+			return
+		}
 		h.logger.Error("Error creating assessment", err, nil)
-		http.Error(w, "Error creating assessment", http.StatusInternalServerError)
+		switch {
+		case errors.Is(err, repository.ErrAssessmentTemplateNotFound):
+			http.Error(w, "Assessment template not found", http.StatusBadRequest)
+		case errors.Is(err, repository.ErrCandidateNotFound):
+			http.Error(w, "Candidate not found", http.StatusBadRequest)
+		default:
+			http.Error(w, "Error creating assessment", repositoryErrorStatus(err))
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":      assessment.ID,
+		"message": "Assessment created successfully",
+	})
+}
+
+// InviteCandidate schedules an assessment for a candidate identified by
+// email, creating the candidate if they are new to the organization.
+// Candidates have no password; the invitation email carries a one-time link
+// that signs them into the assessment.
+func (h *AssessmentHandler) InviteCandidate(w http.ResponseWriter, r *http.Request) {
+	assessmentRepo, err := h.assessmentRepo.ForTenant(r.Context())
+	if err != nil {
+		http.Error(w, "Organization membership required", http.StatusForbidden)
+		return
+	}
+
+	var request struct {
+		TemplateID         string    `json:"template_id"`
+		Email              string    `json:"email"`
+		FirstName          string    `json:"first_name"`
+		LastName           string    `json:"last_name"`
+		ScheduledStartTime time.Time `json:"scheduled_start_time"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		h.logger.Error("Error decoding request", err, nil)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	request.Email = strings.ToLower(strings.TrimSpace(request.Email))
+	if request.TemplateID == "" || request.Email == "" {
+		http.Error(w, "Template ID and email are required", http.StatusBadRequest)
+		return
+	}
+
+	orgID := middleware.GetOrganizationID(r)
+	userID := middleware.GetUserID(r)
+
+	// Candidates already known to the organization are invited again; an
+	// email belonging to anyone else cannot be used
+	candidate, err := h.userRepo.GetByEmail(r.Context(), request.Email)
+	switch {
+	case err == nil:
+		if candidate.Role != model.RoleCandidate || candidate.OrganizationID != orgID {
+			http.Error(w, "Email belongs to a user who is not a candidate of the organization", http.StatusConflict)
+			return
+		}
+		if candidate.Status != model.StatusActive {
+			http.Error(w, "Candidate is not active", http.StatusConflict)
+			return
+		}
+	case errors.Is(err, database.ErrRecordNotFound):
+		candidate = nil
+	default:
+		h.logger.Error("Error getting user by email", err, nil)
+		http.Error(w, "Error inviting candidate", http.StatusInternalServerError)
 		return
 	}
 
-	// Create tasks for the assessment
-	if err := h.assessmentRepo.CreateAssessmentTasks(r.Context(), assessment.ID, request.TemplateID); err != nil {
-		h.logger.Error("Error creating assessment tasks", err, nil)
-		http.Error(w, "Error creating assessment tasks", http.StatusInternalServerError)
+	created := candidate == nil
+	if created {
+		candidate = &model.User{
+			ID:             uuid.New().String(),
+			Email:          request.Email,
+			FirstName:      strings.TrimSpace(request.FirstName),
+			LastName:       strings.TrimSpace(request.LastName),
+			Role:           model.RoleCandidate,
+			Status:         model.StatusActive,
+			OrganizationID: orgID,
+		}
+	}
+
+	assessment := model.NewAssessment(
+		request.TemplateID,
+		candidate.ID,
+		userID,
+		request.ScheduledStartTime,
+	)
+
+	// Create the candidate, the assessment and its tasks against the monthly
+	// assessment allowance. The invitation is sent when the assessment's
+	// creation event is handled.
+	err = h.quotas.Reserve(r.Context(), orgID, quota.ResourceAssessments, func(tx pgx.Tx) error {
+		if created {
+			if err := h.userRepo.WithTx(tx).Create(r.Context(), candidate); err != nil {
+				return err
+			}
+		}
+		txRepo := assessmentRepo.WithTx(tx)
+		if err := txRepo.Create(r.Context(), assessment); err != nil {
+			return err
+		}
+		return txRepo.CreateAssessmentTasks(r.Context(), assessment.ID, request.TemplateID)
+	})
+	if err != nil {
+		if respondWithQuotaError(w, err) {
+			return
+		}
+		h.logger.Error("Error inviting candidate", err, nil)
+		switch {
+		case errors.Is(err, repository.ErrAssessmentTemplateNotFound):
+			http.Error(w, "Assessment template not found", http.StatusBadRequest)
+		default:
+			http.Error(w, "Error inviting candidate", repositoryErrorStatus(err))
+		}
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":           assessment.ID,
+		"candidate_id": candidate.ID,
+		"message":      "Candidate invited successfully",
+	})
+}
+
+// ResendInvitation sends the candidate of an assessment that is still open a
+// new invitation with a new sign-in link, e.g. after their session expired
+func (h *AssessmentHandler) ResendInvitation(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		http.Error(w, "Assessment ID is required", http.StatusBadRequest)
+		return
+	}
+
+	assessmentRepo, err := h.assessmentRepo.ForTenant(r.Context())
+	if err != nil {
+		http.Error(w, "Organization membership required", http.StatusForbidden)
+		return
+	}
+
+	assessment, err := assessmentRepo.GetByID(r.Context(), id)
+	if err != nil {
+		h.logger.Error("Error getting assessment", err, map[string]interface{}{"id": id})
+		http.Error(w, "Assessment not found", repositoryErrorStatus(err))
+		return
+	}
+	if !assessment.IsScheduled() && !assessment.IsInProgress() {
+		http.Error(w, "Assessment is no longer open", http.StatusConflict)
+		return
+	}
+
+	err = h.notifications.ResendAssessmentInvitation(r.Context(), middleware.GetOrganizationID(r), assessment, middleware.GetUserID(r))
+	if err != nil {
+		h.logger.Error("Error resending invitation", err, map[string]interface{}{"id": id})
+		http.Error(w, "Error resending invitation", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"id":      assessment.ID,
-		"message": "Assessment created successfully",
+		"message": "Invitation sent",
 	})
 }
 
@@ -94,13 +287,28 @@ func (h *AssessmentHandler) GetAssessment(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	assessment, err := h.assessmentRepo.GetAssessmentWithTasksAndTemplate(r.Context(), id)
+	assessmentRepo, err := h.assessmentRepo.ForTenant(r.Context())
+	if err != nil {
+		http.Error(w, "Organization membership required", http.StatusForbidden)
+		return
+	}
+
+	assessment, err := assessmentRepo.GetAssessmentWithTasksAndTemplate(r.Context(), id)
 	if err != nil {
 		h.logger.Error("Error getting assessment", err, map[string]interface{}{"id": id})
+		http.Error(w, "Assessment not found", repositoryErrorStatus(err))
+		return
+	}
+	if !canAccessAssessment(r, assessment) {
 		http.Error(w, "Assessment not found", http.StatusNotFound)
 		return
 	}
 
+	// Staff looking at a candidate's assessment and results is audited
+	if assessment.CandidateID != middleware.GetUserID(r) {
+		h.audit.Record(r.Context(), auditEvent(r, model.AuditAssessmentViewed, model.ResourceAssessment, assessment.ID))
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(assessment)
 }
@@ -113,9 +321,19 @@ func (h *AssessmentHandler) StartAssessment(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	assessment, err := h.assessmentRepo.GetByID(r.Context(), id)
+	assessmentRepo, err := h.assessmentRepo.ForTenant(r.Context())
+	if err != nil {
+		http.Error(w, "Organization membership required", http.StatusForbidden)
+		return
+	}
+
+	assessment, err := assessmentRepo.GetByID(r.Context(), id)
 	if err != nil {
 		h.logger.Error("Error getting assessment", err, map[string]interface{}{"id": id})
+		http.Error(w, "Assessment not found", repositoryErrorStatus(err))
+		return
+	}
+	if !canAccessAssessment(r, assessment) {
 		http.Error(w, "Assessment not found", http.StatusNotFound)
 		return
 	}
@@ -126,11 +344,18 @@ func (h *AssessmentHandler) StartAssessment(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	// Start assessment
+	// Start assessment; moving to in progress takes one of the organization's
+	// concurrent environment slots
 	assessment.Start()
-	if err := h.assessmentRepo.Update(r.Context(), assessment); err != nil {
+	err = h.quotas.Reserve(r.Context(), middleware.GetOrganizationID(r), quota.ResourceEnvironments, func(tx pgx.Tx) error {
+		return assessmentRepo.WithTx(tx).Update(r.Context(), assessment)
+	})
+	if err != nil {
+		if respondWithQuotaError(w, err) {
+			return
+		}
 		h.logger.Error("Error updating assessment", err, map[string]interface{}{"id": id})
-		http.Error(w, "Error updating assessment", http.StatusInternalServerError)
+		http.Error(w, "Error updating assessment", repositoryErrorStatus(err))
 		return
 	}
 
@@ -150,6 +375,12 @@ func (h *AssessmentHandler) CompleteAssessment(w http.ResponseWriter, r *http.Re
 		return
 	}
 
+	assessmentRepo, err := h.assessmentRepo.ForTenant(r.Context())
+	if err != nil {
+		http.Error(w, "Organization membership required", http.StatusForbidden)
+		return
+	}
+
 	var request struct {
 		Score int `json:"score"`
 	}
@@ -160,9 +391,13 @@ func (h *AssessmentHandler) CompleteAssessment(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	assessment, err := h.assessmentRepo.GetByID(r.Context(), id)
+	assessment, err := assessmentRepo.GetByID(r.Context(), id)
 	if err != nil {
 		h.logger.Error("Error getting assessment", err, map[string]interface{}{"id": id})
+		http.Error(w, "Assessment not found", repositoryErrorStatus(err))
+		return
+	}
+	if !canAccessAssessment(r, assessment) {
 		http.Error(w, "Assessment not found", http.StatusNotFound)
 		return
 	}
@@ -175,9 +410,9 @@ func (h *AssessmentHandler) CompleteAssessment(w http.ResponseWriter, r *http.Re
 
 	// Complete assessment
 	assessment.Complete(request.Score)
-	if err := h.assessmentRepo.Update(r.Context(), assessment); err != nil {
+	if err := assessmentRepo.Update(r.Context(), assessment); err != nil {
 		h.logger.Error("Error updating assessment", err, map[string]interface{}{"id": id})
-		http.Error(w, "Error updating assessment", http.StatusInternalServerError)
+		http.Error(w, "Error updating assessment", repositoryErrorStatus(err))
 		return
 	}
 
@@ -189,37 +424,114 @@ func (h *AssessmentHandler) CompleteAssessment(w http.ResponseWriter, r *http.Re
 	})
 }
 
+// AssignReviewer assigns a reviewer, an admin or reviewer of the caller's
+// organization, to an assessment. The reviewer is notified by email.
+func (h *AssessmentHandler) AssignReviewer(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		http.Error(w, "Assessment ID is required", http.StatusBadRequest)
+		return
+	}
+
+	assessmentRepo, err := h.assessmentRepo.ForTenant(r.Context())
+	if err != nil {
+		http.Error(w, "Organization membership required", http.StatusForbidden)
+		return
+	}
+
+	var request struct {
+		ReviewerID string `json:"reviewer_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		h.logger.Error("Error decoding request", err, nil)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if request.ReviewerID == "" {
+		http.Error(w, "Reviewer ID is required", http.StatusBadRequest)
+		return
+	}
+
+	assigned, err := assessmentRepo.AssignReviewer(r.Context(), id, request.ReviewerID, middleware.GetUserID(r))
+	if err != nil {
+		if errors.Is(err, repository.ErrReviewerNotFound) {
+			http.Error(w, "Reviewer not found", http.StatusBadRequest)
+			return
+		}
+		h.logger.Error("Error assigning reviewer", err, map[string]interface{}{"id": id, "reviewer_id": request.ReviewerID})
+		http.Error(w, "Error assigning reviewer", repositoryErrorStatus(err))
+		return
+	}
+
+	status := http.StatusCreated
+	message := "Reviewer assigned successfully"
+	if !assigned {
+		status = http.StatusOK
+		message = "Reviewer already assigned"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":          id,
+		"reviewer_id": request.ReviewerID,
+		"message":     message,
+	})
+}
+
 // GetCandidateAssessments gets all assessments for a candidate
 func (h *AssessmentHandler) GetCandidateAssessments(w http.ResponseWriter, r *http.Request) {
+	assessmentRepo, err := h.assessmentRepo.ForTenant(r.Context())
+	if err != nil {
+		http.Error(w, "Organization membership required", http.StatusForbidden)
+		return
+	}
+
 	// Get user context
-	userID := r.Context().Value("userID").(string)
+	userID := middleware.GetUserID(r)
 
-	assessments, err := h.assessmentRepo.ListByCandidate(r.Context(), userID)
+	assessments, err := assessmentRepo.ListByCandidate(r.Context(), userID)
 	if err != nil {
 		h.logger.Error("Error getting candidate assessments", err, map[string]interface{}{"candidate_id": userID})
-		http.Error(w, "Error getting assessments", http.StatusInternalServerError)
+		http.Error(w, "Error getting assessments", repositoryErrorStatus(err))
 		return
 	}
 
+	// A session opened with a sign-in link sees only its assessment
+	if scope := middleware.GetAssessmentScope(r); scope != "" {
+		scoped := assessments[:0]
+		for _, assessment := range assessments {
+			if assessment.ID == scope {
+				scoped = append(scoped, assessment)
+			}
+		}
+		assessments = scoped
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"assessments": assessments,
 	})
 }
 
-// GetActiveOrganizationAssessments gets all active assessments for an organization
+// GetActiveOrganizationAssessments gets all active assessments of the caller's
+// organization; any other organization in the URL is reported as not found
 func (h *AssessmentHandler) GetActiveOrganizationAssessments(w http.ResponseWriter, r *http.Request) {
-	// Get organization context
-	orgID := chi.URLParam(r, "orgId")
-	if orgID == "" {
-		http.Error(w, "Organization ID is required", http.StatusBadRequest)
+	if !requireOrganizationParam(w, r) {
 		return
 	}
 
-	assessments, err := h.assessmentRepo.ListActiveByOrganization(r.Context(), orgID)
+	assessmentRepo, err := h.assessmentRepo.ForTenant(r.Context())
 	if err != nil {
-		h.logger.Error("Error getting active organization assessments", err, map[string]interface{}{"org_id": orgID})
-		http.Error(w, "Error getting assessments", http.StatusInternalServerError)
+		http.Error(w, "Organization membership required", http.StatusForbidden)
+		return
+	}
+
+	assessments, err := assessmentRepo.ListActive(r.Context())
+	if err != nil {
+		h.logger.Error("Error getting active organization assessments", err, map[string]interface{}{"org_id": middleware.GetOrganizationID(r)})
+		http.Error(w, "Error getting assessments", repositoryErrorStatus(err))
 		return
 	}
 
@@ -228,3 +540,17 @@ func (h *AssessmentHandler) GetActiveOrganizationAssessments(w http.ResponseWrit
 		"assessments": assessments,
 	})
 }
+
+// canAccessAssessment reports whether the caller may see the assessment.
+// Candidates only ever see their own assessments, and only the one their
+// sign-in link was for if they used one; staff see every assessment of
+// their organization, which the tenant-scoped repository already ensures.
+func canAccessAssessment(r *http.Request, assessment *model.Assessment) bool {
+	if scope := middleware.GetAssessmentScope(r); scope != "" && scope != assessment.ID {
+		return false
+	}
+	if middleware.GetUserRole(r) == model.RoleCandidate {
+		return assessment.CandidateID == middleware.GetUserID(r)
+	}
+	return true
+}
@@ -2,12 +2,17 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 
+	"github.com/cstanislawski/qualifyd/pkg/audit"
 	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/middleware"
 	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/cstanislawski/qualifyd/pkg/quota"
 	"github.com/cstanislawski/qualifyd/pkg/repository"
 	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
 )
 
 // AssessmentTemplateHandler handles HTTP requests for assessment templates
@@ -15,6 +20,8 @@ type AssessmentTemplateHandler struct {
 	assessmentRepo  *repository.AssessmentRepository
 	environmentRepo *repository.EnvironmentRepository
 	taskRepo        *repository.TaskRepository
+	quotas          *quota.Service
+	audit           *audit.Service
 	logger          logger.Logger
 }
 
@@ -23,20 +30,29 @@ func NewAssessmentTemplateHandler(
 	assessmentRepo *repository.AssessmentRepository,
 	environmentRepo *repository.EnvironmentRepository,
 	taskRepo *repository.TaskRepository,
+	quotas *quota.Service,
+	audit *audit.Service,
 	logger logger.Logger,
 ) *AssessmentTemplateHandler {
 	return &AssessmentTemplateHandler{
 		assessmentRepo:  assessmentRepo,
 		environmentRepo: environmentRepo,
 		taskRepo:        taskRepo,
+		quotas:          quotas,
+		audit:           audit,
 		logger:          logger,
 	}
 }
 
-// CreateAssessmentTemplate creates a new assessment template
+// CreateAssessmentTemplate creates a new assessment template in the caller's organization
 func (h *AssessmentTemplateHandler) CreateAssessmentTemplate(w http.ResponseWriter, r *http.Request) {
+	assessmentRepo, err := h.assessmentRepo.ForTenant(r.Context())
+	if err != nil {
+		http.Error(w, "Organization membership required", http.StatusForbidden)
+		return
+	}
+
 	var request struct {
-		OrganizationID        string             `json:"organization_id"`
 		Name                  string             `json:"name"`
 		Description           string             `json:"description"`
 		EnvironmentTemplateID string             `json:"environment_template_id"`
@@ -54,31 +70,17 @@ func (h *AssessmentTemplateHandler) CreateAssessmentTemplate(w http.ResponseWrit
 	}
 
 	// Validate request
-	if request.OrganizationID == "" || request.Name == "" || request.EnvironmentTemplateID == "" {
-		http.Error(w, "Organization ID, name, and environment template ID are required", http.StatusBadRequest)
-		return
-	}
-
-	// Verify environment template exists
-	envTemplate, err := h.environmentRepo.GetByID(r.Context(), request.EnvironmentTemplateID)
-	if err != nil {
-		h.logger.Error("Error getting environment template", err, map[string]interface{}{"id": request.EnvironmentTemplateID})
-		http.Error(w, "Environment template not found", http.StatusBadRequest)
-		return
-	}
-
-	// Verify environment template belongs to organization
-	if envTemplate.OrganizationID != request.OrganizationID {
-		http.Error(w, "Environment template does not belong to the organization", http.StatusBadRequest)
+	if request.Name == "" || request.EnvironmentTemplateID == "" {
+		http.Error(w, "Name and environment template ID are required", http.StatusBadRequest)
 		return
 	}
 
 	// Get user context
-	userID := r.Context().Value("userID").(string)
+	userID := middleware.GetUserID(r)
 
 	// Create assessment template
 	assessmentTemplate := model.NewAssessmentTemplate(
-		request.OrganizationID,
+		middleware.GetOrganizationID(r),
 		request.Name,
 		request.EnvironmentTemplateID,
 		request.TotalTimeLimit,
@@ -92,37 +94,42 @@ func (h *AssessmentTemplateHandler) CreateAssessmentTemplate(w http.ResponseWrit
 		assessmentTemplate.TaskWeights = request.TaskWeights
 	}
 
-	// Create the assessment template
-	if err := h.assessmentRepo.CreateTemplate(r.Context(), assessmentTemplate); err != nil {
+	// Create the assessment template; the environment template must belong to the caller's organization
+	err = h.quotas.Reserve(r.Context(), assessmentTemplate.OrganizationID, quota.ResourceAssessmentTemplates, func(tx pgx.Tx) error {
+		if err := assessmentRepo.WithTx(tx).CreateTemplate(r.Context(), assessmentTemplate); err != nil {
+			return err
+		}
+		e := auditEvent(r, model.AuditTemplateCreated, model.ResourceAssessmentTemplate, assessmentTemplate.ID)
+		e.OrganizationID = assessmentTemplate.OrganizationID
+		return h.audit.RecordChangeTx(r.Context(), tx, e, nil, assessmentTemplate)
+	})
+	if err != nil {
+		if respondWithQuotaError(w, err) {
+			return
+		}
 		h.logger.Error("Error creating assessment template", err, nil)
-		http.Error(w, "Error creating assessment template", http.StatusInternalServerError)
+		if errors.Is(err, repository.ErrEnvironmentTemplateNotFound) {
+			http.Error(w, "Environment template not found", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Error creating assessment template", repositoryErrorStatus(err))
 		return
 	}
 
-	// Add tasks to the assessment template if provided
+	// Add tasks to the assessment template if provided; tasks from other organizations are rejected
 	if len(request.Tasks) > 0 {
 		for i, taskID := range request.Tasks {
-			// Verify task exists
-			task, err := h.taskRepo.GetByID(r.Context(), taskID)
-			if err != nil {
-				h.logger.Error("Error getting task", err, map[string]interface{}{"id": taskID})
-				http.Error(w, "Task not found: "+taskID, http.StatusBadRequest)
-				return
-			}
-
-			// Verify task belongs to organization
-			if task.OrganizationID != request.OrganizationID {
-				http.Error(w, "Task does not belong to the organization: "+taskID, http.StatusBadRequest)
-				return
-			}
-
 			weight := 1.0
 			if w, ok := request.TaskWeights[taskID]; ok {
 				weight = w
 			}
 
-			if err := h.assessmentRepo.AddTaskToTemplate(r.Context(), assessmentTemplate.ID, taskID, i, weight); err != nil {
+			if err := assessmentRepo.AddTaskToTemplate(r.Context(), assessmentTemplate.ID, taskID, i, weight); err != nil {
 				h.logger.Error("Error adding task to template", err, map[string]interface{}{"template_id": assessmentTemplate.ID, "task_id": taskID})
+				if errors.Is(err, repository.ErrTaskTemplateNotFound) {
+					http.Error(w, "Task not found: "+taskID, http.StatusBadRequest)
+					return
+				}
 				http.Error(w, "Error adding task to template", http.StatusInternalServerError)
 				return
 			}
@@ -142,16 +149,23 @@ func (h *AssessmentTemplateHandler) GetAssessmentTemplate(w http.ResponseWriter,
 		return
 	}
 
-	assessmentTemplate, err := h.assessmentRepo.GetTemplateByID(r.Context(), id)
+	assessmentRepo, err := h.assessmentRepo.ForTenant(r.Context())
+	if err != nil {
+		http.Error(w, "Organization membership required", http.StatusForbidden)
+		return
+	}
+	environmentRepo := h.environmentRepo.ForOrganization(middleware.GetOrganizationID(r))
+
+	assessmentTemplate, err := assessmentRepo.GetTemplateByID(r.Context(), id)
 	if err != nil {
 		h.logger.Error("Error getting assessment template", err, map[string]interface{}{"id": id})
-		http.Error(w, "Assessment template not found", http.StatusNotFound)
+		http.Error(w, "Assessment template not found", repositoryErrorStatus(err))
 		return
 	}
 
 	// Load environment template
 	if assessmentTemplate.EnvironmentTemplateID != "" {
-		envTemplate, err := h.environmentRepo.GetByID(r.Context(), assessmentTemplate.EnvironmentTemplateID)
+		envTemplate, err := environmentRepo.GetByID(r.Context(), assessmentTemplate.EnvironmentTemplateID)
 		if err == nil {
 			assessmentTemplate.EnvironmentTemplate = envTemplate
 		} else {
@@ -160,7 +174,7 @@ func (h *AssessmentTemplateHandler) GetAssessmentTemplate(w http.ResponseWriter,
 	}
 
 	// Load tasks
-	tasks, err := h.assessmentRepo.GetTemplateTasks(r.Context(), id)
+	tasks, err := assessmentRepo.GetTemplateTasks(r.Context(), id)
 	if err == nil {
 		assessmentTemplate.Tasks = tasks
 	} else {
@@ -179,6 +193,12 @@ func (h *AssessmentTemplateHandler) UpdateAssessmentTemplate(w http.ResponseWrit
 		return
 	}
 
+	assessmentRepo, err := h.assessmentRepo.ForTenant(r.Context())
+	if err != nil {
+		http.Error(w, "Organization membership required", http.StatusForbidden)
+		return
+	}
+
 	var request struct {
 		Name                  string `json:"name"`
 		Description           string `json:"description"`
@@ -195,28 +215,16 @@ func (h *AssessmentTemplateHandler) UpdateAssessmentTemplate(w http.ResponseWrit
 	}
 
 	// Get existing assessment template
-	assessmentTemplate, err := h.assessmentRepo.GetTemplateByID(r.Context(), id)
+	assessmentTemplate, err := assessmentRepo.GetTemplateByID(r.Context(), id)
 	if err != nil {
 		h.logger.Error("Error getting assessment template", err, map[string]interface{}{"id": id})
-		http.Error(w, "Assessment template not found", http.StatusNotFound)
+		http.Error(w, "Assessment template not found", repositoryErrorStatus(err))
 		return
 	}
+	before := *assessmentTemplate
 
-	// Verify environment template exists if provided
-	if request.EnvironmentTemplateID != "" && request.EnvironmentTemplateID != assessmentTemplate.EnvironmentTemplateID {
-		envTemplate, err := h.environmentRepo.GetByID(r.Context(), request.EnvironmentTemplateID)
-		if err != nil {
-			h.logger.Error("Error getting environment template", err, map[string]interface{}{"id": request.EnvironmentTemplateID})
-			http.Error(w, "Environment template not found", http.StatusBadRequest)
-			return
-		}
-
-		// Verify environment template belongs to organization
-		if envTemplate.OrganizationID != assessmentTemplate.OrganizationID {
-			http.Error(w, "Environment template does not belong to the organization", http.StatusBadRequest)
-			return
-		}
-
+	// The repository verifies that the environment template belongs to the organization
+	if request.EnvironmentTemplateID != "" {
 		assessmentTemplate.EnvironmentTemplateID = request.EnvironmentTemplateID
 	}
 
@@ -235,12 +243,20 @@ func (h *AssessmentTemplateHandler) UpdateAssessmentTemplate(w http.ResponseWrit
 	}
 	assessmentTemplate.InternetAccess = request.InternetAccess
 
-	if err := h.assessmentRepo.UpdateTemplate(r.Context(), assessmentTemplate); err != nil {
+	if err := assessmentRepo.UpdateTemplate(r.Context(), assessmentTemplate); err != nil {
 		h.logger.Error("Error updating assessment template", err, map[string]interface{}{"id": id})
-		http.Error(w, "Error updating assessment template", http.StatusInternalServerError)
+		if errors.Is(err, repository.ErrEnvironmentTemplateNotFound) {
+			http.Error(w, "Environment template not found", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Error updating assessment template", repositoryErrorStatus(err))
 		return
 	}
 
+	e := auditEvent(r, model.AuditTemplateUpdated, model.ResourceAssessmentTemplate, assessmentTemplate.ID)
+	e.OrganizationID = assessmentTemplate.OrganizationID
+	h.audit.RecordChange(r.Context(), e, &before, assessmentTemplate)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(assessmentTemplate)
 }
@@ -253,11 +269,18 @@ func (h *AssessmentTemplateHandler) DeleteAssessmentTemplate(w http.ResponseWrit
 		return
 	}
 
-	if err := h.assessmentRepo.DeleteTemplate(r.Context(), id); err != nil {
+	assessmentRepo, err := h.assessmentRepo.ForTenant(r.Context())
+	if err != nil {
+		http.Error(w, "Organization membership required", http.StatusForbidden)
+		return
+	}
+
+	if err := assessmentRepo.DeleteTemplate(r.Context(), id); err != nil {
 		h.logger.Error("Error deleting assessment template", err, map[string]interface{}{"id": id})
-		http.Error(w, "Error deleting assessment template", http.StatusInternalServerError)
+		http.Error(w, "Error deleting assessment template", repositoryErrorStatus(err))
 		return
 	}
+	h.audit.Record(r.Context(), auditEvent(r, model.AuditTemplateDeleted, model.ResourceAssessmentTemplate, id))
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -266,18 +289,22 @@ func (h *AssessmentTemplateHandler) DeleteAssessmentTemplate(w http.ResponseWrit
 	})
 }
 
-// ListOrganizationAssessmentTemplates lists all assessment templates for an organization
+// ListOrganizationAssessmentTemplates lists all assessment templates of the caller's organization
 func (h *AssessmentTemplateHandler) ListOrganizationAssessmentTemplates(w http.ResponseWriter, r *http.Request) {
-	orgID := chi.URLParam(r, "orgId")
-	if orgID == "" {
-		http.Error(w, "Organization ID is required", http.StatusBadRequest)
+	if !requireOrganizationParam(w, r) {
+		return
+	}
+
+	assessmentRepo, err := h.assessmentRepo.ForTenant(r.Context())
+	if err != nil {
+		http.Error(w, "Organization membership required", http.StatusForbidden)
 		return
 	}
 
-	assessmentTemplates, err := h.assessmentRepo.ListTemplatesByOrganization(r.Context(), orgID)
+	assessmentTemplates, err := assessmentRepo.ListTemplates(r.Context())
 	if err != nil {
-		h.logger.Error("Error listing assessment templates", err, map[string]interface{}{"organization_id": orgID})
-		http.Error(w, "Error listing assessment templates", http.StatusInternalServerError)
+		h.logger.Error("Error listing assessment templates", err, map[string]interface{}{"organization_id": middleware.GetOrganizationID(r)})
+		http.Error(w, "Error listing assessment templates", repositoryErrorStatus(err))
 		return
 	}
 
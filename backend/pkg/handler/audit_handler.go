@@ -0,0 +1,263 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/audit"
+	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/middleware"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/cstanislawski/qualifyd/pkg/repository"
+	"github.com/google/uuid"
+)
+
+// AuditHandler handles reading, exporting and verifying the audit log
+type AuditHandler struct {
+	organizationRepo *repository.OrganizationRepository
+	audit            *audit.Service
+	logger           logger.Logger
+}
+
+// NewAuditHandler creates a new AuditHandler instance
+func NewAuditHandler(
+	organizationRepo *repository.OrganizationRepository,
+	audit *audit.Service,
+	logger logger.Logger,
+) *AuditHandler {
+	return &AuditHandler{
+		organizationRepo: organizationRepo,
+		audit:            audit,
+		logger:           logger,
+	}
+}
+
+// HandleListAuditEvents handles listing an organization's audit events,
+// newest first, filtered by the actor_id, action, target_type, target_id,
+// from and to query parameters
+func (h *AuditHandler) HandleListAuditEvents(w http.ResponseWriter, r *http.Request) {
+	org, ok := loadManagedOrganization(w, r, h.organizationRepo, h.logger)
+	if !ok {
+		return
+	}
+
+	filter, ok := auditFilterOf(w, r)
+	if !ok {
+		return
+	}
+	filter.OrganizationID = org.ID
+
+	h.list(w, r, filter)
+}
+
+// HandleExportAuditEvents handles exporting an organization's audit events
+// matching the same filters as HandleListAuditEvents, as CSV (the default)
+// or JSON
+func (h *AuditHandler) HandleExportAuditEvents(w http.ResponseWriter, r *http.Request) {
+	org, ok := loadManagedOrganization(w, r, h.organizationRepo, h.logger)
+	if !ok {
+		return
+	}
+
+	filter, ok := auditFilterOf(w, r)
+	if !ok {
+		return
+	}
+	filter.OrganizationID = org.ID
+
+	h.export(w, r, filter, "audit-"+org.ID)
+}
+
+// HandleVerifyAuditChain handles checking the hash chain of an
+// organization's audit events
+func (h *AuditHandler) HandleVerifyAuditChain(w http.ResponseWriter, r *http.Request) {
+	org, ok := loadManagedOrganization(w, r, h.organizationRepo, h.logger)
+	if !ok {
+		return
+	}
+
+	h.verify(w, r, org.ID)
+}
+
+// HandleListPlatformAuditEvents handles listing the audit events of every
+// organization and of the platform, optionally filtered by the
+// organization_id query parameter on top of HandleListAuditEvents' filters
+func (h *AuditHandler) HandleListPlatformAuditEvents(w http.ResponseWriter, r *http.Request) {
+	filter, ok := platformAuditFilterOf(w, r)
+	if !ok {
+		return
+	}
+
+	h.list(w, r, filter)
+}
+
+// HandleExportPlatformAuditEvents handles exporting the audit events
+// matching the same filters as HandleListPlatformAuditEvents
+func (h *AuditHandler) HandleExportPlatformAuditEvents(w http.ResponseWriter, r *http.Request) {
+	filter, ok := platformAuditFilterOf(w, r)
+	if !ok {
+		return
+	}
+
+	h.export(w, r, filter, "audit")
+}
+
+// HandleVerifyPlatformAuditChain handles checking the hash chain of the
+// platform's audit events, those of no organization
+func (h *AuditHandler) HandleVerifyPlatformAuditChain(w http.ResponseWriter, r *http.Request) {
+	h.verify(w, r, "")
+}
+
+// list responds with a page of the events matching filter
+func (h *AuditHandler) list(w http.ResponseWriter, r *http.Request, filter model.AuditFilter) {
+	response, err := h.audit.List(r.Context(), filter, getPaginationParams(r, 50, 100))
+	if err != nil {
+		h.logger.Error("Failed to list audit events", err, map[string]interface{}{
+			"organization_id": filter.OrganizationID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to list audit events", "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// export responds with the events matching filter as an attachment named
+// after filename. Exports are audited themselves.
+func (h *AuditHandler) export(w http.ResponseWriter, r *http.Request, filter model.AuditFilter, filename string) {
+	format := r.URL.Query().Get("format")
+	contentType := ""
+	switch format {
+	case "", audit.FormatCSV:
+		format = audit.FormatCSV
+		contentType = "text/csv; charset=utf-8"
+	case audit.FormatJSON:
+		contentType = "application/json"
+	default:
+		respondWithError(w, http.StatusBadRequest, "Invalid format", "format must be csv or json")
+		return
+	}
+
+	e := auditEvent(r, model.AuditEventsExported, "", "")
+	if filter.OrganizationID != "" {
+		e.TargetType = model.AuditTargetOrganization
+		e.TargetID = filter.OrganizationID
+	}
+	e.OrganizationID = filter.OrganizationID
+	h.audit.Record(r.Context(), e)
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.%s", filename, format))
+	w.WriteHeader(http.StatusOK)
+
+	if err := h.audit.Export(r.Context(), filter, format, w); err != nil {
+		// The response has started, so the export ends cut short
+		h.logger.Error("Failed to export audit events", err, map[string]interface{}{
+			"organization_id": filter.OrganizationID,
+		})
+	}
+}
+
+// verify responds with the result of checking an organization's hash chain
+func (h *AuditHandler) verify(w http.ResponseWriter, r *http.Request, organizationID string) {
+	result, err := h.audit.Verify(r.Context(), organizationID)
+	if err != nil {
+		h.logger.Error("Failed to verify audit events", err, map[string]interface{}{
+			"organization_id": organizationID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to verify audit events", "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, result)
+}
+
+// auditFilterOf reads the audit event filters of the request's query,
+// writing a 400 if one is invalid
+func auditFilterOf(w http.ResponseWriter, r *http.Request) (model.AuditFilter, bool) {
+	query := r.URL.Query()
+	filter := model.AuditFilter{
+		ActorID:    query.Get("actor_id"),
+		Action:     query.Get("action"),
+		TargetType: query.Get("target_type"),
+		TargetID:   query.Get("target_id"),
+	}
+
+	for name, bound := range map[string]**time.Time{"from": &filter.From, "to": &filter.To} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid "+name+" time", name+" must be an RFC 3339 time")
+			return model.AuditFilter{}, false
+		}
+		*bound = &t
+	}
+
+	return filter, true
+}
+
+// platformAuditFilterOf reads the audit event filters of the request's
+// query together with the organization_id filter
+func platformAuditFilterOf(w http.ResponseWriter, r *http.Request) (model.AuditFilter, bool) {
+	filter, ok := auditFilterOf(w, r)
+	if !ok {
+		return filter, false
+	}
+
+	filter.OrganizationID = r.URL.Query().Get("organization_id")
+	if filter.OrganizationID != "" {
+		if _, err := uuid.Parse(filter.OrganizationID); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid organization ID", "")
+			return filter, false
+		}
+	}
+
+	return filter, true
+}
+
+// auditEvent starts an audit event of the caller doing action on a target,
+// in the caller's organization
+func auditEvent(r *http.Request, action, targetType, targetID string) *model.AuditEvent {
+	e := &model.AuditEvent{
+		OrganizationID: middleware.GetOrganizationID(r),
+		ActorType:      model.AuditActorAnonymous,
+		Action:         action,
+		TargetType:     targetType,
+		TargetID:       targetID,
+		IPAddress:      clientIP(r),
+		UserAgent:      r.UserAgent(),
+	}
+
+	if key := middleware.GetAPIKey(r); key != nil {
+		e.ActorType = model.AuditActorAPIKey
+		e.ActorID = key.ID
+	} else if middleware.IsAuthenticated(r) {
+		e.ActorType = model.AuditActorUser
+		e.ActorID = middleware.GetUserID(r)
+	}
+
+	return e
+}
+
+// signInEvent starts an audit event of a sign-in attempt on user's account,
+// or on email's if no account has it. Attempts that succeed are made by the
+// user; failures and the lockouts they cause are not.
+func signInEvent(r *http.Request, action string, user *model.User, email string) *model.AuditEvent {
+	e := auditEvent(r, action, model.AuditTargetUser, strings.ToLower(strings.TrimSpace(email)))
+	if user == nil {
+		return e
+	}
+
+	e.OrganizationID = user.OrganizationID
+	e.TargetID = user.ID
+	if action != model.AuditLoginFailed && action != model.AuditAccountLocked {
+		e.ActorType = model.AuditActorUser
+		e.ActorID = user.ID
+	}
+	return e
+}
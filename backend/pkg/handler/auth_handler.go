@@ -1,34 +1,47 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/cstanislawski/qualifyd/pkg/audit"
 	"github.com/cstanislawski/qualifyd/pkg/auth"
+	"github.com/cstanislawski/qualifyd/pkg/config"
 	"github.com/cstanislawski/qualifyd/pkg/database"
 	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/mfa"
 	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/cstanislawski/qualifyd/pkg/notification"
+	"github.com/cstanislawski/qualifyd/pkg/ratelimit"
 	"github.com/cstanislawski/qualifyd/pkg/repository"
+	"github.com/cstanislawski/qualifyd/pkg/session"
 	"github.com/google/uuid"
 )
 
+// minPasswordLength is the length a new password must have at least
+const minPasswordLength = 8
+
 // LoginRequest represents the login request payload
 type LoginRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
 }
 
-// RegisterRequest represents the registration request payload
+// RegisterRequest represents the registration request payload. Registering
+// creates an organization, which the user administers; everyone else joins
+// by invitation.
 type RegisterRequest struct {
-	Email           string `json:"email"`
-	Password        string `json:"password"`
-	FirstName       string `json:"first_name"`
-	LastName        string `json:"last_name"`
-	Organization    string `json:"organization,omitempty"`
-	Role            string `json:"role,omitempty"`             // Optional role specification
-	InvitationToken string `json:"invitation_token,omitempty"` // For invited users
+	Email        string `json:"email"`
+	Password     string `json:"password"`
+	FirstName    string `json:"first_name"`
+	LastName     string `json:"last_name"`
+	Organization string `json:"organization"`
 }
 
 // AuthResponse represents the authentication response
@@ -50,26 +63,108 @@ type AcceptInvitationRequest struct {
 	Password        string `json:"password"`
 }
 
+// MagicLinkRequest represents the payload for signing in with a candidate's
+// sign-in link
+type MagicLinkRequest struct {
+	Token string `json:"token"`
+}
+
+// MagicLinkResponse represents the session a sign-in link opens. The access
+// token is limited to the assessment and cannot be refreshed.
+type MagicLinkResponse struct {
+	AccessToken  string     `json:"access_token"`
+	AssessmentID string     `json:"assessment_id"`
+	ExpiresAt    time.Time  `json:"expires_at"`
+	User         model.User `json:"user"`
+}
+
+// ForgotPasswordRequest represents the payload for requesting a password
+// reset link
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+// ResetPasswordRequest represents the payload for setting a new password
+// with a reset token
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// authUsers reads and writes the users signing in;
+// *repository.UserRepository implements it
+type authUsers interface {
+	GetByID(ctx context.Context, id string) (*model.User, error)
+	GetByEmail(ctx context.Context, email string) (*model.User, error)
+	GetByInvitationToken(ctx context.Context, token string) (*model.User, error)
+	Create(ctx context.Context, user *model.User) error
+	ActivateUser(ctx context.Context, userID string) error
+	SetPassword(ctx context.Context, userID string, passwordHash string) error
+	UpdateLastLogin(ctx context.Context, userID string) error
+}
+
+// auditRecorder records audit events; *audit.Service implements it
+type auditRecorder interface {
+	Record(ctx context.Context, e *model.AuditEvent)
+}
+
+// mfaChallenges decides whether users signing in must give a second factor
+// and starts their challenges; *mfa.Service implements it
+type mfaChallenges interface {
+	Check(ctx context.Context, user *model.User) (required, enrolled bool, err error)
+	StartChallenge(ctx context.Context, user *model.User, enrollmentRequired bool) (*mfa.Challenge, error)
+}
+
 // AuthHandler handles authentication requests
 type AuthHandler struct {
-	userRepo         *repository.UserRepository
-	organizationRepo *repository.OrganizationRepository
-	auth             *auth.Auth
-	logger           logger.Logger
+	userRepo          authUsers
+	organizationRepo  *repository.OrganizationRepository
+	magicLinkRepo     *repository.MagicLinkRepository
+	assessmentRepo    *repository.AssessmentRepository
+	passwordResetRepo *repository.PasswordResetRepository
+	ssoRepo           *repository.SSORepository
+	auth              *auth.Auth
+	sessions          *session.Service
+	mfa               mfaChallenges
+	notifications     *notification.Service
+	audit             auditRecorder
+	limiter           *ratelimit.Limiter
+	resetConfig       *config.PasswordResetConfig
+	logger            logger.Logger
 }
 
 // NewAuthHandler creates a new AuthHandler
 func NewAuthHandler(
 	userRepo *repository.UserRepository,
 	organizationRepo *repository.OrganizationRepository,
+	magicLinkRepo *repository.MagicLinkRepository,
+	assessmentRepo *repository.AssessmentRepository,
+	passwordResetRepo *repository.PasswordResetRepository,
+	ssoRepo *repository.SSORepository,
 	auth *auth.Auth,
+	sessions *session.Service,
+	mfa *mfa.Service,
+	notifications *notification.Service,
+	audit *audit.Service,
+	limiter *ratelimit.Limiter,
+	resetConfig *config.PasswordResetConfig,
 	logger logger.Logger,
 ) *AuthHandler {
 	return &AuthHandler{
-		userRepo:         userRepo,
-		organizationRepo: organizationRepo,
-		auth:             auth,
-		logger:           logger,
+		userRepo:          userRepo,
+		organizationRepo:  organizationRepo,
+		magicLinkRepo:     magicLinkRepo,
+		assessmentRepo:    assessmentRepo,
+		passwordResetRepo: passwordResetRepo,
+		ssoRepo:           ssoRepo,
+		auth:              auth,
+		sessions:          sessions,
+		mfa:               mfa,
+		notifications:     notifications,
+		audit:             audit,
+		limiter:           limiter,
+		resetConfig:       resetConfig,
+		logger:            logger,
 	}
 }
 
@@ -88,10 +183,21 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Accounts are locked out for a while after repeated failed sign-ins,
+	// whether or not a user has the email, so that a lockout tells nothing
+	// about which emails do
+	if lockedFor := h.limiter.LockedFor(r.Context(), req.Email); lockedFor > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(ratelimit.RetryAfterSeconds(lockedFor)))
+		respondWithError(w, http.StatusTooManyRequests, "Too many failed sign-in attempts", "Try again later")
+		return
+	}
+
 	// Get user by email
 	user, err := h.userRepo.GetByEmail(r.Context(), req.Email)
 	if err != nil {
-		if errors.Is(err, repository.ErrUserNotFound) {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			h.audit.Record(r.Context(), signInEvent(r, model.AuditLoginFailed, nil, req.Email))
+			h.loginFailed(r, nil, req.Email)
 			respondWithError(w, http.StatusUnauthorized, "Invalid credentials", "")
 			return
 		}
@@ -102,40 +208,77 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 
 	// Check if user is active
 	if user.Status != model.StatusActive {
+		h.audit.Record(r.Context(), signInEvent(r, model.AuditLoginFailed, user, req.Email))
 		respondWithError(w, http.StatusUnauthorized, "Account is not active", "")
 		return
 	}
 
 	// Check password
 	if err := auth.CheckPassword(req.Password, user.PasswordHash); err != nil {
+		h.audit.Record(r.Context(), signInEvent(r, model.AuditLoginFailed, user, req.Email))
+		h.loginFailed(r, user, req.Email)
 		respondWithError(w, http.StatusUnauthorized, "Invalid credentials", "")
 		return
 	}
 
+	// Organizations may require their users to sign in with single sign-on
+	disabled, err := h.ssoRepo.PasswordLoginDisabled(r.Context(), user.OrganizationID)
+	if err != nil {
+		h.logger.Error("Failed to check password login", err, map[string]interface{}{"userID": user.ID})
+		respondWithError(w, http.StatusInternalServerError, "Failed to login", "")
+		return
+	}
+	if disabled {
+		h.audit.Record(r.Context(), signInEvent(r, model.AuditLoginFailed, user, req.Email))
+		respondWithError(w, http.StatusForbidden, "Password login is disabled for your organization", "Sign in with single sign-on")
+		return
+	}
+
+	// Users with MFA give their second factor before tokens are issued, and
+	// their failed sign-ins are only forgotten once they have
+	if challengeMFA(w, r, h.mfa, user, h.logger) {
+		return
+	}
+
 	// Update last login time
 	if err := h.userRepo.UpdateLastLogin(r.Context(), user.ID); err != nil {
 		h.logger.Error("Failed to update last login time", err, map[string]interface{}{"userID": user.ID})
 	}
 
-	// Generate JWT tokens
-	accessToken, refreshToken, err := h.auth.GenerateTokens(user)
+	// Start a session and issue its tokens
+	tokens, err := h.sessions.Start(r.Context(), user, clientOf(r))
 	if err != nil {
-		h.logger.Error("Failed to generate tokens", err, map[string]interface{}{"userID": user.ID})
+		h.logger.Error("Failed to start session", err, map[string]interface{}{"userID": user.ID})
 		respondWithError(w, http.StatusInternalServerError, "Failed to generate authentication tokens", "")
 		return
 	}
+	h.audit.Record(r.Context(), signInEvent(r, model.AuditLogin, user, req.Email))
+	h.limiter.LoginSucceeded(r.Context(), req.Email)
 
 	// Sanitize user data before sending response
 	user.PasswordHash = ""
 
 	// Send response
 	respondWithJSON(w, http.StatusOK, AuthResponse{
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
 		User:         *user,
 	})
 }
 
+// loginFailed counts a failed sign-in to email's account, recording an
+// audit event when it locks the account out
+func (h *AuthHandler) loginFailed(r *http.Request, user *model.User, email string) {
+	lockout := h.limiter.LoginFailed(r.Context(), email)
+	if lockout == 0 {
+		return
+	}
+
+	e := signInEvent(r, model.AuditAccountLocked, user, email)
+	e.Diff, _ = json.Marshal(map[string]string{"locked_for": lockout.String()})
+	h.audit.Record(r.Context(), e)
+}
+
 // Register handles user registration
 func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 	var req RegisterRequest
@@ -146,8 +289,8 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Validate required fields
-	if req.Email == "" || req.Password == "" || req.FirstName == "" || req.LastName == "" {
-		respondWithError(w, http.StatusBadRequest, "Email, password, first name, and last name are required", "")
+	if req.Email == "" || req.Password == "" || req.FirstName == "" || req.LastName == "" || req.Organization == "" {
+		respondWithError(w, http.StatusBadRequest, "Email, password, first name, last name, and organization are required", "")
 		return
 	}
 
@@ -156,7 +299,7 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 	if err == nil && existingUser != nil {
 		respondWithError(w, http.StatusConflict, "Email already in use", "")
 		return
-	} else if err != nil && !errors.Is(err, repository.ErrUserNotFound) {
+	} else if err != nil && !errors.Is(err, database.ErrRecordNotFound) {
 		h.logger.Error("Failed to check existing user", err, map[string]interface{}{"email": req.Email})
 		respondWithError(w, http.StatusInternalServerError, "Failed to register user", "")
 		return
@@ -170,71 +313,26 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Determine user role based on registration scenario
-	userRole := model.RoleCandidate // Default role is candidate if not specified
-
-	// If role is specified, validate and use it
-	if req.Role != "" {
-		switch req.Role {
-		case model.RoleAdmin, model.RoleRecruiter, model.RoleCandidate, model.RoleReviewer:
-			userRole = req.Role
-		default:
-			respondWithError(w, http.StatusBadRequest, "Invalid role specified", "")
-			return
-		}
-	}
-
-	// Create organization if specified and set user as admin
-	var organizationID string
-	if req.Organization != "" {
-		org := &model.Organization{
-			ID:               uuid.New().String(),
-			Name:             req.Organization,
-			SubscriptionPlan: model.OrgPlanStarter,
-			ContactEmail:     req.Email,
-		}
-
-		if err := h.organizationRepo.Create(r.Context(), org); err != nil {
-			h.logger.Error("Failed to create organization", err, map[string]interface{}{"organization": req.Organization})
-			respondWithError(w, http.StatusInternalServerError, "Failed to create organization", "")
-			return
-		}
-		organizationID = org.ID
-		userRole = model.RoleAdmin // If creating an organization, user is automatically an admin
-	}
-
-	// Handle invitation token if provided
-	if req.InvitationToken != "" {
-		// Validate invitation token and get organization and role
-		// This would normally call a service to validate the token and get details
-		// For now, we'll assume it's valid and use the specified role
-		// TODO: Implement invitation token validation
+	// New organizations start on a starter trial
+	org := model.NewOrganization(uuid.New().String(), req.Organization, req.Email)
+	if err := h.organizationRepo.Create(r.Context(), org); err != nil {
+		h.logger.Error("Failed to create organization", err, map[string]interface{}{"organization": req.Organization})
+		respondWithError(w, http.StatusInternalServerError, "Failed to create organization", "")
+		return
 	}
 
-	// Create user
+	// The user who registers an organization administers it. Recruiters,
+	// reviewers and other staff are invited by its admins, and candidates
+	// sign in with the links their assessment invitations carry.
 	user := &model.User{
-		ID:           uuid.New().String(),
-		Email:        req.Email,
-		PasswordHash: hashedPassword,
-		FirstName:    req.FirstName,
-		LastName:     req.LastName,
-		Role:         userRole,
-		Status:       model.StatusActive,
-	}
-
-	if organizationID != "" {
-		user.OrganizationID = organizationID
-	}
-
-	// Special case for local development
-	if req.Email == "candidate@example.com" {
-		user.Role = model.RoleCandidate
-	} else if req.Email == "recruiter@example.com" {
-		user.Role = model.RoleRecruiter
-	} else if req.Email == "admin@example.com" {
-		user.Role = model.RoleAdmin
-	} else if req.Email == "reviewer@example.com" {
-		user.Role = model.RoleReviewer
+		ID:             uuid.New().String(),
+		Email:          req.Email,
+		PasswordHash:   hashedPassword,
+		FirstName:      req.FirstName,
+		LastName:       req.LastName,
+		Role:           model.RoleAdmin,
+		Status:         model.StatusActive,
+		OrganizationID: org.ID,
 	}
 
 	if err := h.userRepo.Create(r.Context(), user); err != nil {
@@ -243,21 +341,32 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Generate JWT tokens
-	accessToken, refreshToken, err := h.auth.GenerateTokens(user)
+	// The user who registers an organization owns it
+	if err := h.organizationRepo.SetOwner(r.Context(), org.ID, user.ID); err != nil {
+		h.logger.Error("Failed to set organization owner", err, map[string]interface{}{
+			"organization_id": org.ID,
+			"user_id":         user.ID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to register user", "")
+		return
+	}
+
+	// Start a session and issue its tokens
+	tokens, err := h.sessions.Start(r.Context(), user, clientOf(r))
 	if err != nil {
-		h.logger.Error("Failed to generate tokens", err, map[string]interface{}{"userID": user.ID})
+		h.logger.Error("Failed to start session", err, map[string]interface{}{"userID": user.ID})
 		respondWithError(w, http.StatusInternalServerError, "Failed to generate authentication tokens", "")
 		return
 	}
+	h.audit.Record(r.Context(), signInEvent(r, model.AuditRegistered, user, req.Email))
 
 	// Sanitize user data before sending response
 	user.PasswordHash = ""
 
 	// Send response
 	respondWithJSON(w, http.StatusCreated, AuthResponse{
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
 		User:         *user,
 	})
 }
@@ -276,36 +385,33 @@ func (h *AuthHandler) RefreshToken(w http.ResponseWriter, r *http.Request) {
 		refreshToken = refreshToken[7:]
 	}
 
-	// Validate refresh token
-	userID, err := h.auth.ValidateRefreshToken(refreshToken)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Invalid refresh token", "")
-		return
-	}
-
-	// Get user by ID
-	user, err := h.userRepo.GetByID(r.Context(), userID)
+	// Exchange the refresh token for new ones; each can be used once
+	tokens, user, err := h.sessions.Refresh(r.Context(), refreshToken)
 	if err != nil {
-		h.logger.Error("Failed to get user by ID", err, map[string]interface{}{"userID": userID})
+		if errors.Is(err, repository.ErrRefreshTokenReused) {
+			h.logger.Warn("Refresh token reused, session revoked", map[string]interface{}{
+				"ip_address": clientIP(r),
+			})
+			respondWithError(w, http.StatusUnauthorized, "Invalid refresh token", "")
+			return
+		}
+		if errors.Is(err, repository.ErrRefreshTokenInvalid) {
+			respondWithError(w, http.StatusUnauthorized, "Invalid refresh token", "")
+			return
+		}
+		h.logger.Error("Failed to refresh session", err, nil)
 		respondWithError(w, http.StatusInternalServerError, "Failed to refresh token", "")
 		return
 	}
 
-	// Generate new access token
-	accessToken, err := h.auth.GenerateAccessToken(user)
-	if err != nil {
-		h.logger.Error("Failed to generate access token", err, map[string]interface{}{"userID": userID})
-		respondWithError(w, http.StatusInternalServerError, "Failed to generate access token", "")
-		return
-	}
-
 	// Sanitize user data before sending response
 	user.PasswordHash = ""
 
 	// Send response
-	respondWithJSON(w, http.StatusOK, map[string]interface{}{
-		"access_token": accessToken,
-		"user":         user,
+	respondWithJSON(w, http.StatusOK, AuthResponse{
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		User:         *user,
 	})
 }
 
@@ -386,10 +492,17 @@ func (h *AuthHandler) HandleAcceptInvitation(w http.ResponseWriter, r *http.Requ
 		activeUser.Status = model.StatusActive
 	}
 
-	// Generate JWT tokens for immediate login
-	accessToken, refreshToken, err := h.auth.GenerateTokens(activeUser)
+	h.audit.Record(r.Context(), signInEvent(r, model.AuditInvitationAccepted, activeUser, activeUser.Email))
+
+	// Invited users whose role requires MFA enroll before signing in
+	if challengeMFA(w, r, h.mfa, activeUser, h.logger) {
+		return
+	}
+
+	// Start a session for immediate login
+	tokens, err := h.sessions.Start(r.Context(), activeUser, clientOf(r))
 	if err != nil {
-		h.logger.Error("Failed to generate tokens after invitation acceptance", err, map[string]interface{}{
+		h.logger.Error("Failed to start session after invitation acceptance", err, map[string]interface{}{
 			"user_id": activeUser.ID,
 		})
 		respondWithError(w, http.StatusInternalServerError, "Failed to generate authentication tokens", "")
@@ -403,12 +516,288 @@ func (h *AuthHandler) HandleAcceptInvitation(w http.ResponseWriter, r *http.Requ
 
 	// Send response
 	respondWithJSON(w, http.StatusOK, AuthResponse{
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
 		User:         *activeUser,
 	})
 }
 
+// challengeMFA responds with an MFA challenge instead of tokens if user must
+// give a second factor, or enroll one, to sign in, however they proved who
+// they are. It returns true if it responded.
+func challengeMFA(w http.ResponseWriter, r *http.Request, challenges mfaChallenges, user *model.User, log logger.Logger) bool {
+	required, enrolled, err := challenges.Check(r.Context(), user)
+	if err == nil && !required {
+		return false
+	}
+
+	var challenge *mfa.Challenge
+	if err == nil {
+		challenge, err = challenges.StartChallenge(r.Context(), user, !enrolled)
+	}
+	if err != nil {
+		log.Error("Failed to start MFA challenge", err, map[string]interface{}{"userID": user.ID})
+		respondWithError(w, http.StatusInternalServerError, "Failed to login", "")
+		return true
+	}
+
+	respondWithJSON(w, http.StatusOK, MFAChallengeResponse{
+		MFARequired:        true,
+		MFAToken:           challenge.Token,
+		ExpiresAt:          challenge.ExpiresAt,
+		EnrollmentRequired: challenge.EnrollmentRequired,
+	})
+	return true
+}
+
+// HandleForgotPassword handles a request for a password reset link. The
+// response is the same whether or not the email belongs to a user, so that
+// it cannot be used to find out who has an account. Requests are limited
+// per email address and per client.
+func (h *AuthHandler) HandleForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req ForgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.logger.Error("Failed to decode forgot password request", err, nil)
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload", err.Error())
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	if email == "" {
+		respondWithError(w, http.StatusBadRequest, "Email is required", "")
+		return
+	}
+	ip := clientIP(r)
+
+	byEmail, byIP, err := h.passwordResetRepo.CountRequests(r.Context(), email, ip, time.Now().Add(-h.resetConfig.Window))
+	if err != nil {
+		h.logger.Error("Failed to count password reset requests", err, nil)
+		respondWithError(w, http.StatusInternalServerError, "Failed to request password reset", "")
+		return
+	}
+	if byEmail >= h.resetConfig.MaxPerEmail || byIP >= h.resetConfig.MaxPerIP {
+		h.logger.Warn("Password reset requests limited", map[string]interface{}{
+			"email": email,
+			"ip":    ip,
+		})
+		w.Header().Set("Retry-After", strconv.Itoa(int(h.resetConfig.Window.Seconds())))
+		respondWithError(w, http.StatusTooManyRequests, "Too many password reset requests", "Try again later")
+		return
+	}
+	if err := h.passwordResetRepo.RecordRequest(r.Context(), email, ip); err != nil {
+		h.logger.Error("Failed to record password reset request", err, nil)
+		respondWithError(w, http.StatusInternalServerError, "Failed to request password reset", "")
+		return
+	}
+
+	if err := h.sendPasswordReset(r, email); err != nil {
+		h.logger.Error("Failed to send password reset", err, map[string]interface{}{"email": email})
+		respondWithError(w, http.StatusInternalServerError, "Failed to request password reset", "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusAccepted, map[string]string{
+		"message": "If the email belongs to an account, a link to reset its password is on its way",
+	})
+}
+
+// sendPasswordReset issues a reset token for the user with email and emails
+// it to them. Nothing is sent if there is no such active user, if the user
+// is a candidate, who signs in with links and has no password, or if their
+// organization disabled password login.
+func (h *AuthHandler) sendPasswordReset(r *http.Request, email string) error {
+	user, err := h.userRepo.GetByEmail(r.Context(), email)
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	if user.Status != model.StatusActive || user.Role == model.RoleCandidate {
+		return nil
+	}
+	disabled, err := h.ssoRepo.PasswordLoginDisabled(r.Context(), user.OrganizationID)
+	if err != nil || disabled {
+		return err
+	}
+
+	expiresAt := time.Now().UTC().Add(h.resetConfig.TokenTTL)
+	token, err := h.auth.GenerateResetToken(user.ID, expiresAt)
+	if err != nil {
+		return err
+	}
+	resetToken := &model.PasswordResetToken{
+		UserID:    user.ID,
+		TokenHash: auth.HashToken(token),
+		ExpiresAt: expiresAt,
+	}
+	if err := h.passwordResetRepo.CreateToken(r.Context(), resetToken); err != nil {
+		return err
+	}
+
+	return h.notifications.SendPasswordReset(r.Context(), user, resetToken.ID, token, expiresAt)
+}
+
+// HandleResetPassword handles setting a new password with a reset token.
+// The token works once, and the user is signed out of every session.
+func (h *AuthHandler) HandleResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.logger.Error("Failed to decode reset password request", err, nil)
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload", err.Error())
+		return
+	}
+	if req.Token == "" || req.Password == "" {
+		respondWithError(w, http.StatusBadRequest, "Token and password are required", "")
+		return
+	}
+	if len(req.Password) < minPasswordLength {
+		respondWithError(w, http.StatusBadRequest, "Password is too short", "Passwords must have at least "+strconv.Itoa(minPasswordLength)+" characters")
+		return
+	}
+
+	userID, err := h.auth.ValidateResetToken(req.Token)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired reset token", "")
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(req.Password)
+	if err != nil {
+		h.logger.Error("Failed to hash password", err, nil)
+		respondWithError(w, http.StatusInternalServerError, "Failed to reset password", "")
+		return
+	}
+
+	if err := h.passwordResetRepo.ResetPassword(r.Context(), userID, auth.HashToken(req.Token), hashedPassword); err != nil {
+		if errors.Is(err, repository.ErrResetTokenInvalid) {
+			respondWithError(w, http.StatusBadRequest, "Invalid or expired reset token", "")
+			return
+		}
+		h.logger.Error("Failed to reset password", err, map[string]interface{}{"userID": userID})
+		respondWithError(w, http.StatusInternalServerError, "Failed to reset password", "")
+		return
+	}
+
+	user, err := h.userRepo.GetByID(r.Context(), userID)
+	if err != nil {
+		h.logger.Error("Failed to get user by ID", err, map[string]interface{}{"userID": userID})
+	} else {
+		h.audit.Record(r.Context(), signInEvent(r, model.AuditPasswordReset, user, user.Email))
+	}
+
+	h.logger.Info("Password reset", map[string]interface{}{"userID": userID})
+	respondWithJSON(w, http.StatusOK, map[string]string{
+		"message": "Password reset successfully",
+	})
+}
+
+// clientIP returns the address of the client that sent r
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// clientOf describes the client that sent r, for the sessions it starts
+func clientOf(r *http.Request) session.Client {
+	return session.Client{
+		UserAgent: r.UserAgent(),
+		IPAddress: clientIP(r),
+	}
+}
+
+// HandleMagicLink handles a candidate signing in with the link their
+// assessment invitation carries. Each link signs in once, and the session
+// it opens is limited to the link's assessment.
+func (h *AuthHandler) HandleMagicLink(w http.ResponseWriter, r *http.Request) {
+	var req MagicLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.logger.Error("Failed to decode magic link request", err, nil)
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload", err.Error())
+		return
+	}
+	if req.Token == "" {
+		respondWithError(w, http.StatusBadRequest, "Token is required", "")
+		return
+	}
+
+	linkID, err := h.auth.ValidateMagicLinkToken(req.Token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired sign-in link", "")
+		return
+	}
+
+	link, err := h.magicLinkRepo.Consume(r.Context(), linkID)
+	if err != nil {
+		if errors.Is(err, repository.ErrMagicLinkInvalid) {
+			respondWithError(w, http.StatusUnauthorized, "Invalid or expired sign-in link", "")
+			return
+		}
+		h.logger.Error("Failed to consume magic link", err, map[string]interface{}{"link_id": linkID})
+		respondWithError(w, http.StatusInternalServerError, "Failed to sign in", "")
+		return
+	}
+
+	user, err := h.userRepo.GetByID(r.Context(), link.CandidateID)
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			respondWithError(w, http.StatusUnauthorized, "Invalid or expired sign-in link", "")
+			return
+		}
+		h.logger.Error("Failed to get user by ID", err, map[string]interface{}{"userID": link.CandidateID})
+		respondWithError(w, http.StatusInternalServerError, "Failed to sign in", "")
+		return
+	}
+	if user.Role != model.RoleCandidate || user.OrganizationID != link.OrganizationID {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired sign-in link", "")
+		return
+	}
+	if user.Status != model.StatusActive {
+		respondWithError(w, http.StatusUnauthorized, "Account is not active", "")
+		return
+	}
+
+	assessment, err := h.assessmentRepo.ForOrganization(link.OrganizationID).GetByID(r.Context(), link.AssessmentID)
+	if err != nil {
+		if errors.Is(err, repository.ErrAssessmentNotFound) {
+			respondWithError(w, http.StatusGone, "The assessment no longer exists", "")
+			return
+		}
+		h.logger.Error("Failed to get assessment", err, map[string]interface{}{"assessment_id": link.AssessmentID})
+		respondWithError(w, http.StatusInternalServerError, "Failed to sign in", "")
+		return
+	}
+	if assessment.Status != model.AssessmentStatusScheduled && assessment.Status != model.AssessmentStatusInProgress {
+		respondWithError(w, http.StatusGone, "The assessment is no longer open", "")
+		return
+	}
+
+	tokens, session, err := h.sessions.StartAssessment(r.Context(), user, assessment.ID, clientOf(r))
+	if err != nil {
+		h.logger.Error("Failed to start assessment session", err, map[string]interface{}{"userID": user.ID})
+		respondWithError(w, http.StatusInternalServerError, "Failed to generate authentication tokens", "")
+		return
+	}
+	h.audit.Record(r.Context(), signInEvent(r, model.AuditLogin, user, user.Email))
+
+	if err := h.userRepo.UpdateLastLogin(r.Context(), user.ID); err != nil {
+		h.logger.Error("Failed to update last login time", err, map[string]interface{}{"userID": user.ID})
+	}
+
+	// Sanitize user data before sending response
+	user.PasswordHash = ""
+
+	respondWithJSON(w, http.StatusOK, MagicLinkResponse{
+		AccessToken:  tokens.AccessToken,
+		AssessmentID: assessment.ID,
+		ExpiresAt:    session.ExpiresAt,
+		User:         *user,
+	})
+}
+
 // Helper functions for JSON responses
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	response, err := json.Marshal(payload)
@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/config"
+	"github.com/cstanislawski/qualifyd/pkg/database"
+	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/cstanislawski/qualifyd/pkg/ratelimit"
+	"github.com/rs/zerolog"
+)
+
+// fakeUsers maps emails to users; it only supports looking them up
+type fakeUsers struct {
+	authUsers
+	byEmail map[string]*model.User
+}
+
+func (f *fakeUsers) GetByEmail(ctx context.Context, email string) (*model.User, error) {
+	if u, ok := f.byEmail[email]; ok {
+		return u, nil
+	}
+	return nil, database.ErrRecordNotFound
+}
+
+// fakeAudit keeps the events recorded
+type fakeAudit struct {
+	events []*model.AuditEvent
+}
+
+func (f *fakeAudit) Record(ctx context.Context, e *model.AuditEvent) {
+	f.events = append(f.events, e)
+}
+
+func newTestAuthHandler(cfg *config.RateLimitConfig) (*AuthHandler, *fakeAudit) {
+	log := logger.NewLogger(zerolog.Nop())
+	audit := &fakeAudit{}
+	return &AuthHandler{
+		userRepo: &fakeUsers{byEmail: map[string]*model.User{}},
+		audit:    audit,
+		limiter:  ratelimit.NewLimiter(ratelimit.NewMemoryStore(), cfg, log),
+		logger:   log,
+	}, audit
+}
+
+func login(h *AuthHandler, email, password string) *httptest.ResponseRecorder {
+	body := `{"email":"` + email + `","password":"` + password + `"}`
+	rec := httptest.NewRecorder()
+	h.Login(rec, httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(body)))
+	return rec
+}
+
+func TestLoginUnknownEmailIsAudited(t *testing.T) {
+	h, audit := newTestAuthHandler(&config.RateLimitConfig{
+		LockoutThreshold:   5,
+		LockoutDuration:    time.Minute,
+		MaxLockoutDuration: time.Hour,
+		FailureWindow:      time.Hour,
+	})
+
+	rec := login(h, "Nobody@example.com", "secret")
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	if len(audit.events) != 1 {
+		t.Fatalf("recorded %d events, want 1", len(audit.events))
+	}
+	e := audit.events[0]
+	if e.Action != model.AuditLoginFailed || e.TargetID != "nobody@example.com" || e.OrganizationID != "" {
+		t.Errorf("recorded %s of %q in organization %q, want %s of %q in none",
+			e.Action, e.TargetID, e.OrganizationID, model.AuditLoginFailed, "nobody@example.com")
+	}
+}
+
+func TestLoginUnknownEmailIsLockedOut(t *testing.T) {
+	cfg := &config.RateLimitConfig{
+		LockoutThreshold:   3,
+		LockoutDuration:    time.Minute,
+		MaxLockoutDuration: time.Hour,
+		FailureWindow:      time.Hour,
+	}
+	h, audit := newTestAuthHandler(cfg)
+
+	for i := 0; i < cfg.LockoutThreshold; i++ {
+		if rec := login(h, "nobody@example.com", "secret"); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: status = %d, want %d", i+1, rec.Code, http.StatusUnauthorized)
+		}
+	}
+
+	rec := login(h, "NOBODY@example.com", "secret")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status after %d failures = %d, want %d", cfg.LockoutThreshold, rec.Code, http.StatusTooManyRequests)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("locked out response has no Retry-After header")
+	}
+
+	last := audit.events[len(audit.events)-1]
+	if last.Action != model.AuditAccountLocked {
+		t.Errorf("last recorded event is %s, want %s", last.Action, model.AuditAccountLocked)
+	}
+}
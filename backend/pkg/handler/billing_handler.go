@@ -0,0 +1,226 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/billing"
+	"github.com/cstanislawski/qualifyd/pkg/database"
+	"github.com/cstanislawski/qualifyd/pkg/invoice"
+	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/repository"
+	"github.com/go-chi/chi/v5"
+)
+
+// maxWebhookBodySize bounds the payment provider webhook payloads accepted
+const maxWebhookBodySize = 1 << 20
+
+// maxStatementPeriods bounds the number of past billing periods listed
+const maxStatementPeriods = 24
+
+// StatementPeriodResponse represents a billing period with a downloadable statement
+type StatementPeriodResponse struct {
+	Number  string    `json:"number"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	PDFURL  string    `json:"pdf_url"`
+	HTMLURL string    `json:"html_url"`
+}
+
+// UpdatePaymentMethodRequest represents the request payload for setting an
+// organization's payment method. Token identifies a payment method collected
+// client-side by the payment provider, so card details never reach the API.
+type UpdatePaymentMethodRequest struct {
+	Token string `json:"token"`
+}
+
+// BillingHandler handles payment methods, billing records and payment provider callbacks
+type BillingHandler struct {
+	organizationRepo *repository.OrganizationRepository
+	billingRepo      *repository.BillingRepository
+	billingService   *billing.Service
+	statements       *invoice.Generator
+	logger           logger.Logger
+}
+
+// NewBillingHandler creates a new BillingHandler instance
+func NewBillingHandler(
+	organizationRepo *repository.OrganizationRepository,
+	billingRepo *repository.BillingRepository,
+	billingService *billing.Service,
+	statements *invoice.Generator,
+	logger logger.Logger,
+) *BillingHandler {
+	return &BillingHandler{
+		organizationRepo: organizationRepo,
+		billingRepo:      billingRepo,
+		billingService:   billingService,
+		statements:       statements,
+		logger:           logger,
+	}
+}
+
+// HandleUpdatePaymentMethod handles setting an organization's default payment method
+func (h *BillingHandler) HandleUpdatePaymentMethod(w http.ResponseWriter, r *http.Request) {
+	org, ok := loadManagedOrganization(w, r, h.organizationRepo, h.logger)
+	if !ok {
+		return
+	}
+
+	var req UpdatePaymentMethodRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.logger.Error("Failed to decode payment method request", err, nil)
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload", err.Error())
+		return
+	}
+	if req.Token == "" {
+		respondWithError(w, http.StatusBadRequest, "token is required", "")
+		return
+	}
+
+	method, err := h.billingService.SetupPaymentMethod(r.Context(), org, req.Token)
+	if err != nil {
+		h.logger.Error("Failed to set up payment method", err, map[string]interface{}{
+			"organization_id": org.ID,
+		})
+		respondWithError(w, http.StatusBadGateway, "Failed to set up payment method", "The payment provider rejected the payment method")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, method)
+}
+
+// HandleListBillingRecords handles listing an organization's billing records, newest first
+func (h *BillingHandler) HandleListBillingRecords(w http.ResponseWriter, r *http.Request) {
+	org, ok := loadManagedOrganization(w, r, h.organizationRepo, h.logger)
+	if !ok {
+		return
+	}
+
+	response, err := h.billingRepo.ListByOrganization(r.Context(), org.ID, getPaginationParams(r, 20, 100))
+	if err != nil {
+		h.logger.Error("Failed to list billing records", err, map[string]interface{}{
+			"organization_id": org.ID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to list billing records", "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// HandleListStatements handles listing an organization's past billing periods
+// and where their statements are downloaded from, newest first
+func (h *BillingHandler) HandleListStatements(w http.ResponseWriter, r *http.Request) {
+	org, ok := loadManagedOrganization(w, r, h.organizationRepo, h.logger)
+	if !ok {
+		return
+	}
+
+	periods := invoice.Periods(org, time.Now().UTC(), maxStatementPeriods)
+	response := make([]StatementPeriodResponse, 0, len(periods))
+	for _, period := range periods {
+		response = append(response, StatementPeriodResponse{
+			Number:  invoice.Number(org.ID, period.Start),
+			Start:   period.Start,
+			End:     period.End,
+			PDFURL:  invoice.URL(org.ID, period.Start, invoice.FormatPDF),
+			HTMLURL: invoice.URL(org.ID, period.Start, invoice.FormatHTML),
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// HandleGetStatement handles downloading the statement of the billing period
+// starting on the date in the URL, as PDF (the default) or HTML
+func (h *BillingHandler) HandleGetStatement(w http.ResponseWriter, r *http.Request) {
+	org, ok := loadManagedOrganization(w, r, h.organizationRepo, h.logger)
+	if !ok {
+		return
+	}
+
+	day, err := time.Parse("2006-01-02", chi.URLParam(r, "period"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid billing period", "period must be a date in YYYY-MM-DD format")
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	contentType := ""
+	switch format {
+	case "", invoice.FormatPDF:
+		format = invoice.FormatPDF
+		contentType = "application/pdf"
+	case invoice.FormatHTML:
+		contentType = "text/html; charset=utf-8"
+	default:
+		respondWithError(w, http.StatusBadRequest, "Invalid format", "format must be pdf or html")
+		return
+	}
+
+	period := invoice.PeriodOn(org, day)
+	if !period.End.After(org.BillingAnchor()) {
+		respondWithError(w, http.StatusNotFound, "Statement not found", "The organization was not billed for this period")
+		return
+	}
+
+	data, err := h.statements.Get(r.Context(), org, period, format)
+	if err != nil {
+		if errors.Is(err, invoice.ErrPeriodNotEnded) {
+			respondWithError(w, http.StatusBadRequest, "Billing period has not ended", "")
+			return
+		}
+		h.logger.Error("Failed to get statement", err, map[string]interface{}{
+			"organization_id": org.ID,
+			"period_start":    period.Start,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to get statement", "")
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.%s", invoice.Number(org.ID, period.Start), format))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
+
+// HandleWebhook handles payment provider callbacks. Deliveries with an invalid
+// signature are rejected; deliveries that fail to apply return a 5xx so the
+// provider retries them.
+func (h *BillingHandler) HandleWebhook(w http.ResponseWriter, r *http.Request) {
+	payload, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBodySize+1))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Failed to read request body", "")
+		return
+	}
+	if len(payload) > maxWebhookBodySize {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Request body too large", "")
+		return
+	}
+
+	if err := h.billingService.HandleWebhook(r.Context(), payload, r.Header); err != nil {
+		if errors.Is(err, billing.ErrInvalidSignature) {
+			h.logger.Warn("Rejected payment provider webhook", map[string]interface{}{
+				"error": err.Error(),
+			})
+			respondWithError(w, http.StatusBadRequest, "Invalid signature", "")
+			return
+		}
+		if errors.Is(err, database.ErrRecordNotFound) {
+			h.logger.Warn("Payment provider webhook for unknown charge", map[string]interface{}{
+				"error": err.Error(),
+			})
+		} else {
+			h.logger.Error("Failed to process payment provider webhook", err, nil)
+		}
+		respondWithError(w, http.StatusInternalServerError, "Failed to process webhook", "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]bool{"received": true})
+}
@@ -0,0 +1,259 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/database"
+	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/middleware"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/cstanislawski/qualifyd/pkg/notification"
+	"github.com/cstanislawski/qualifyd/pkg/repository"
+	"github.com/go-chi/chi/v5"
+)
+
+// EmailTemplateSummary describes one template of an organization
+type EmailTemplateSummary struct {
+	Kind       string     `json:"kind"`
+	Locale     string     `json:"locale"`
+	Customized bool       `json:"customized"`
+	UpdatedAt  *time.Time `json:"updated_at,omitempty"`
+}
+
+// EmailTemplateResponse is the template an organization uses for a kind of
+// email and locale
+type EmailTemplateResponse struct {
+	Kind       string `json:"kind"`
+	Locale     string `json:"locale"`
+	Customized bool   `json:"customized"`
+	notification.Source
+}
+
+// EmailTemplateHandler handles previewing and customizing an organization's
+// email templates
+type EmailTemplateHandler struct {
+	organizationRepo *repository.OrganizationRepository
+	emailRepo        *repository.EmailRepository
+	notifications    *notification.Service
+	logger           logger.Logger
+}
+
+// NewEmailTemplateHandler creates a new EmailTemplateHandler instance
+func NewEmailTemplateHandler(
+	organizationRepo *repository.OrganizationRepository,
+	emailRepo *repository.EmailRepository,
+	notifications *notification.Service,
+	logger logger.Logger,
+) *EmailTemplateHandler {
+	return &EmailTemplateHandler{
+		organizationRepo: organizationRepo,
+		emailRepo:        emailRepo,
+		notifications:    notifications,
+		logger:           logger,
+	}
+}
+
+// HandleListEmailTemplates handles listing every kind of email in every
+// locale, and whether the organization customized it
+func (h *EmailTemplateHandler) HandleListEmailTemplates(w http.ResponseWriter, r *http.Request) {
+	org, ok := loadManagedOrganization(w, r, h.organizationRepo, h.logger)
+	if !ok {
+		return
+	}
+
+	custom, err := h.emailRepo.ListTemplates(r.Context(), org.ID)
+	if err != nil {
+		h.logger.Error("Failed to list email templates", err, map[string]interface{}{
+			"organization_id": org.ID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to list email templates", "")
+		return
+	}
+	updated := make(map[string]time.Time, len(custom))
+	for _, t := range custom {
+		updated[t.Kind+"/"+t.Locale] = t.UpdatedAt
+	}
+
+	summaries := make([]EmailTemplateSummary, 0, len(notification.Kinds)*len(notification.Locales))
+	for _, kind := range notification.Kinds {
+		for _, locale := range notification.Locales {
+			summary := EmailTemplateSummary{Kind: kind, Locale: locale}
+			if updatedAt, ok := updated[kind+"/"+locale]; ok {
+				summary.Customized = true
+				summary.UpdatedAt = &updatedAt
+			}
+			summaries = append(summaries, summary)
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"locale":    org.EmailLocale,
+		"templates": summaries,
+	})
+}
+
+// HandleGetEmailTemplate handles getting the template the organization uses
+// for a kind of email, in the locale query parameter or else its own locale
+func (h *EmailTemplateHandler) HandleGetEmailTemplate(w http.ResponseWriter, r *http.Request) {
+	org, kind, locale, ok := h.loadTemplateParams(w, r)
+	if !ok {
+		return
+	}
+
+	src, customized, err := h.notifications.Source(r.Context(), org.ID, kind, locale)
+	if err != nil {
+		h.logger.Error("Failed to get email template", err, map[string]interface{}{
+			"organization_id": org.ID,
+			"kind":            kind,
+			"locale":          locale,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to get email template", "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, EmailTemplateResponse{
+		Kind:       kind,
+		Locale:     locale,
+		Customized: customized,
+		Source:     *src,
+	})
+}
+
+// HandleUpdateEmailTemplate handles customizing the organization's template
+// for a kind of email and locale. The template must render the kind's
+// sample data.
+func (h *EmailTemplateHandler) HandleUpdateEmailTemplate(w http.ResponseWriter, r *http.Request) {
+	org, kind, locale, ok := h.loadTemplateParams(w, r)
+	if !ok {
+		return
+	}
+
+	var src notification.Source
+	if err := json.NewDecoder(r.Body).Decode(&src); err != nil {
+		h.logger.Error("Failed to decode email template request", err, nil)
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload", err.Error())
+		return
+	}
+	if _, err := notification.Preview(org, kind, locale, &src); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid email template", err.Error())
+		return
+	}
+
+	template := &model.EmailTemplate{
+		OrganizationID: org.ID,
+		Kind:           kind,
+		Locale:         locale,
+		Subject:        src.Subject,
+		Text:           src.Text,
+		HTML:           src.HTML,
+		UpdatedBy:      middleware.GetUserID(r),
+	}
+	if err := h.emailRepo.SaveTemplate(r.Context(), template); err != nil {
+		h.logger.Error("Failed to save email template", err, map[string]interface{}{
+			"organization_id": org.ID,
+			"kind":            kind,
+			"locale":          locale,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to save email template", "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, template)
+}
+
+// HandleDeleteEmailTemplate handles reverting the organization's template for
+// a kind of email and locale to the built-in one
+func (h *EmailTemplateHandler) HandleDeleteEmailTemplate(w http.ResponseWriter, r *http.Request) {
+	org, kind, locale, ok := h.loadTemplateParams(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.emailRepo.DeleteTemplate(r.Context(), org.ID, kind, locale); err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			respondWithError(w, http.StatusNotFound, "Email template is not customized", "")
+			return
+		}
+		h.logger.Error("Failed to delete email template", err, map[string]interface{}{
+			"organization_id": org.ID,
+			"kind":            kind,
+			"locale":          locale,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to delete email template", "")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandlePreviewEmailTemplate handles rendering a kind of email with sample
+// data and the organization's branding. The request body may hold an unsaved
+// template to preview; without one the template in use is rendered.
+func (h *EmailTemplateHandler) HandlePreviewEmailTemplate(w http.ResponseWriter, r *http.Request) {
+	org, kind, locale, ok := h.loadTemplateParams(w, r)
+	if !ok {
+		return
+	}
+
+	var src notification.Source
+	if err := json.NewDecoder(r.Body).Decode(&src); err != nil && !errors.Is(err, io.EOF) {
+		h.logger.Error("Failed to decode email template request", err, nil)
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload", err.Error())
+		return
+	}
+
+	preview := &src
+	if src == (notification.Source{}) {
+		current, _, err := h.notifications.Source(r.Context(), org.ID, kind, locale)
+		if err != nil {
+			h.logger.Error("Failed to get email template", err, map[string]interface{}{
+				"organization_id": org.ID,
+				"kind":            kind,
+				"locale":          locale,
+			})
+			respondWithError(w, http.StatusInternalServerError, "Failed to preview email template", "")
+			return
+		}
+		preview = current
+	}
+
+	msg, err := notification.Preview(org, kind, locale, preview)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid email template", err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, msg)
+}
+
+// loadTemplateParams loads the managed organization and validates the
+// {kind} URL parameter and the locale query parameter, which defaults to the
+// organization's locale. It writes an error response and returns false if
+// either is invalid.
+func (h *EmailTemplateHandler) loadTemplateParams(w http.ResponseWriter, r *http.Request) (*model.Organization, string, string, bool) {
+	org, ok := loadManagedOrganization(w, r, h.organizationRepo, h.logger)
+	if !ok {
+		return nil, "", "", false
+	}
+
+	kind := chi.URLParam(r, "kind")
+	if !notification.IsKind(kind) {
+		respondWithError(w, http.StatusNotFound, "Unknown email kind", kind)
+		return nil, "", "", false
+	}
+
+	locale := r.URL.Query().Get("locale")
+	if locale == "" {
+		locale = org.EmailLocale
+	}
+	if !notification.IsLocale(locale) {
+		respondWithError(w, http.StatusBadRequest, "Unsupported locale", locale)
+		return nil, "", "", false
+	}
+
+	return org, kind, locale, true
+}
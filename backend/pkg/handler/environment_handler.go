@@ -4,38 +4,53 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"github.com/cstanislawski/qualifyd/pkg/audit"
 	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/middleware"
 	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/cstanislawski/qualifyd/pkg/quota"
 	"github.com/cstanislawski/qualifyd/pkg/repository"
 	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
 )
 
 // EnvironmentHandler handles HTTP requests for environment templates
 type EnvironmentHandler struct {
 	environmentRepo *repository.EnvironmentRepository
+	quotas          *quota.Service
+	audit           *audit.Service
 	logger          logger.Logger
 }
 
 // NewEnvironmentHandler creates a new environment handler
 func NewEnvironmentHandler(
 	environmentRepo *repository.EnvironmentRepository,
+	quotas *quota.Service,
+	audit *audit.Service,
 	logger logger.Logger,
 ) *EnvironmentHandler {
 	return &EnvironmentHandler{
 		environmentRepo: environmentRepo,
+		quotas:          quotas,
+		audit:           audit,
 		logger:          logger,
 	}
 }
 
-// CreateEnvironmentTemplate creates a new environment template
+// CreateEnvironmentTemplate creates a new environment template in the caller's organization
 func (h *EnvironmentHandler) CreateEnvironmentTemplate(w http.ResponseWriter, r *http.Request) {
+	environmentRepo, err := h.environmentRepo.ForTenant(r.Context())
+	if err != nil {
+		http.Error(w, "Organization membership required", http.StatusForbidden)
+		return
+	}
+
 	var request struct {
-		OrganizationID string                 `json:"organization_id"`
-		Name           string                 `json:"name"`
-		Description    string                 `json:"description"`
-		Type           string                 `json:"type"`
-		Specs          model.EnvironmentSpecs `json:"specs"`
-		Configuration  json.RawMessage        `json:"configuration"`
+		Name          string                 `json:"name"`
+		Description   string                 `json:"description"`
+		Type          string                 `json:"type"`
+		Specs         model.EnvironmentSpecs `json:"specs"`
+		Configuration json.RawMessage        `json:"configuration"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
@@ -45,8 +60,8 @@ func (h *EnvironmentHandler) CreateEnvironmentTemplate(w http.ResponseWriter, r
 	}
 
 	// Validate request
-	if request.OrganizationID == "" || request.Name == "" || request.Type == "" {
-		http.Error(w, "Organization ID, name, and type are required", http.StatusBadRequest)
+	if request.Name == "" || request.Type == "" {
+		http.Error(w, "Name and type are required", http.StatusBadRequest)
 		return
 	}
 
@@ -65,11 +80,11 @@ func (h *EnvironmentHandler) CreateEnvironmentTemplate(w http.ResponseWriter, r
 	}
 
 	// Get user context
-	userID := r.Context().Value("userID").(string)
+	userID := middleware.GetUserID(r)
 
 	// Create environment template
 	envTemplate := model.NewEnvironmentTemplate(
-		request.OrganizationID,
+		middleware.GetOrganizationID(r),
 		request.Name,
 		request.Type,
 		request.Specs,
@@ -81,9 +96,20 @@ func (h *EnvironmentHandler) CreateEnvironmentTemplate(w http.ResponseWriter, r
 		envTemplate.Configuration = request.Configuration
 	}
 
-	if err := h.environmentRepo.Create(r.Context(), envTemplate); err != nil {
+	err = h.quotas.Reserve(r.Context(), envTemplate.OrganizationID, quota.ResourceEnvironmentTemplates, func(tx pgx.Tx) error {
+		if err := environmentRepo.WithTx(tx).Create(r.Context(), envTemplate); err != nil {
+			return err
+		}
+		e := auditEvent(r, model.AuditEnvironmentTemplateCreated, model.AuditTargetEnvironmentTemplate, envTemplate.ID)
+		e.OrganizationID = envTemplate.OrganizationID
+		return h.audit.RecordChangeTx(r.Context(), tx, e, nil, envTemplate)
+	})
+	if err != nil {
+		if respondWithQuotaError(w, err) {
+			return
+		}
 		h.logger.Error("Error creating environment template", err, nil)
-		http.Error(w, "Error creating environment template", http.StatusInternalServerError)
+		http.Error(w, "Error creating environment template", repositoryErrorStatus(err))
 		return
 	}
 
@@ -100,10 +126,16 @@ func (h *EnvironmentHandler) GetEnvironmentTemplate(w http.ResponseWriter, r *ht
 		return
 	}
 
-	envTemplate, err := h.environmentRepo.GetByID(r.Context(), id)
+	environmentRepo, err := h.environmentRepo.ForTenant(r.Context())
+	if err != nil {
+		http.Error(w, "Organization membership required", http.StatusForbidden)
+		return
+	}
+
+	envTemplate, err := environmentRepo.GetByID(r.Context(), id)
 	if err != nil {
 		h.logger.Error("Error getting environment template", err, map[string]interface{}{"id": id})
-		http.Error(w, "Environment template not found", http.StatusNotFound)
+		http.Error(w, "Environment template not found", repositoryErrorStatus(err))
 		return
 	}
 
@@ -119,6 +151,12 @@ func (h *EnvironmentHandler) UpdateEnvironmentTemplate(w http.ResponseWriter, r
 		return
 	}
 
+	environmentRepo, err := h.environmentRepo.ForTenant(r.Context())
+	if err != nil {
+		http.Error(w, "Organization membership required", http.StatusForbidden)
+		return
+	}
+
 	var request struct {
 		Name          string                 `json:"name"`
 		Description   string                 `json:"description"`
@@ -133,13 +171,15 @@ func (h *EnvironmentHandler) UpdateEnvironmentTemplate(w http.ResponseWriter, r
 	}
 
 	// Get existing environment template
-	envTemplate, err := h.environmentRepo.GetByID(r.Context(), id)
+	envTemplate, err := environmentRepo.GetByID(r.Context(), id)
 	if err != nil {
 		h.logger.Error("Error getting environment template", err, map[string]interface{}{"id": id})
-		http.Error(w, "Environment template not found", http.StatusNotFound)
+		http.Error(w, "Environment template not found", repositoryErrorStatus(err))
 		return
 	}
 
+	before := *envTemplate
+
 	// Update fields
 	if request.Name != "" {
 		envTemplate.Name = request.Name
@@ -162,12 +202,16 @@ func (h *EnvironmentHandler) UpdateEnvironmentTemplate(w http.ResponseWriter, r
 		envTemplate.Configuration = request.Configuration
 	}
 
-	if err := h.environmentRepo.Update(r.Context(), envTemplate); err != nil {
+	if err := environmentRepo.Update(r.Context(), envTemplate); err != nil {
 		h.logger.Error("Error updating environment template", err, map[string]interface{}{"id": id})
-		http.Error(w, "Error updating environment template", http.StatusInternalServerError)
+		http.Error(w, "Error updating environment template", repositoryErrorStatus(err))
 		return
 	}
 
+	e := auditEvent(r, model.AuditEnvironmentTemplateUpdated, model.AuditTargetEnvironmentTemplate, envTemplate.ID)
+	e.OrganizationID = envTemplate.OrganizationID
+	h.audit.RecordChange(r.Context(), e, &before, envTemplate)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(envTemplate)
 }
@@ -180,11 +224,18 @@ func (h *EnvironmentHandler) DeleteEnvironmentTemplate(w http.ResponseWriter, r
 		return
 	}
 
-	if err := h.environmentRepo.Delete(r.Context(), id); err != nil {
+	environmentRepo, err := h.environmentRepo.ForTenant(r.Context())
+	if err != nil {
+		http.Error(w, "Organization membership required", http.StatusForbidden)
+		return
+	}
+
+	if err := environmentRepo.Delete(r.Context(), id); err != nil {
 		h.logger.Error("Error deleting environment template", err, map[string]interface{}{"id": id})
-		http.Error(w, "Error deleting environment template", http.StatusInternalServerError)
+		http.Error(w, "Error deleting environment template", repositoryErrorStatus(err))
 		return
 	}
+	h.audit.Record(r.Context(), auditEvent(r, model.AuditEnvironmentTemplateDeleted, model.AuditTargetEnvironmentTemplate, id))
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -193,18 +244,22 @@ func (h *EnvironmentHandler) DeleteEnvironmentTemplate(w http.ResponseWriter, r
 	})
 }
 
-// ListOrganizationEnvironmentTemplates lists all environment templates for an organization
+// ListOrganizationEnvironmentTemplates lists all environment templates of the caller's organization
 func (h *EnvironmentHandler) ListOrganizationEnvironmentTemplates(w http.ResponseWriter, r *http.Request) {
-	orgID := chi.URLParam(r, "orgId")
-	if orgID == "" {
-		http.Error(w, "Organization ID is required", http.StatusBadRequest)
+	if !requireOrganizationParam(w, r) {
+		return
+	}
+
+	environmentRepo, err := h.environmentRepo.ForTenant(r.Context())
+	if err != nil {
+		http.Error(w, "Organization membership required", http.StatusForbidden)
 		return
 	}
 
-	envTemplates, err := h.environmentRepo.ListByOrganization(r.Context(), orgID)
+	envTemplates, err := environmentRepo.List(r.Context())
 	if err != nil {
-		h.logger.Error("Error listing environment templates", err, map[string]interface{}{"organization_id": orgID})
-		http.Error(w, "Error listing environment templates", http.StatusInternalServerError)
+		h.logger.Error("Error listing environment templates", err, map[string]interface{}{"organization_id": middleware.GetOrganizationID(r)})
+		http.Error(w, "Error listing environment templates", repositoryErrorStatus(err))
 		return
 	}
 
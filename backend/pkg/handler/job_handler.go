@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/cstanislawski/qualifyd/pkg/database"
+	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/middleware"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/cstanislawski/qualifyd/pkg/repository"
+	"github.com/go-chi/chi/v5"
+)
+
+// JobHandler exposes the status and progress of background jobs
+type JobHandler struct {
+	organizationRepo *repository.OrganizationRepository
+	jobRepo          *repository.JobRepository
+	logger           logger.Logger
+}
+
+// NewJobHandler creates a new JobHandler instance
+func NewJobHandler(organizationRepo *repository.OrganizationRepository, jobRepo *repository.JobRepository, logger logger.Logger) *JobHandler {
+	return &JobHandler{
+		organizationRepo: organizationRepo,
+		jobRepo:          jobRepo,
+		logger:           logger,
+	}
+}
+
+// HandleGetJob handles getting a job's status and progress. Callers see the
+// jobs they created and those of their organization; any other job is
+// reported as missing.
+func (h *JobHandler) HandleGetJob(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "job_id")
+	job, err := h.jobRepo.GetByID(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			respondWithError(w, http.StatusNotFound, "Job not found", "")
+			return
+		}
+		h.logger.Error("Failed to get job", err, map[string]interface{}{
+			"job_id": id,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to get job", "")
+		return
+	}
+
+	if !canViewJob(r, job) {
+		respondWithError(w, http.StatusNotFound, "Job not found", "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, job)
+}
+
+// HandleListJobs handles listing an organization's jobs, optionally filtered
+// by the type and status query parameters
+func (h *JobHandler) HandleListJobs(w http.ResponseWriter, r *http.Request) {
+	org, ok := loadManagedOrganization(w, r, h.organizationRepo, h.logger)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	status := query.Get("status")
+	switch status {
+	case "", model.JobStatusQueued, model.JobStatusRunning, model.JobStatusRetrying, model.JobStatusSucceeded, model.JobStatusFailed:
+	default:
+		respondWithError(w, http.StatusBadRequest, "Invalid job status", status)
+		return
+	}
+
+	response, err := h.jobRepo.ListByOrganization(r.Context(), org.ID, query.Get("type"), status, getPaginationParams(r, 20, 100))
+	if err != nil {
+		h.logger.Error("Failed to list jobs", err, map[string]interface{}{
+			"organization_id": org.ID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to list jobs", "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// canViewJob reports whether the caller may see a job
+func canViewJob(r *http.Request, job *model.Job) bool {
+	if middleware.IsSuperAdmin(r) {
+		return true
+	}
+	if job.CreatedBy != "" && job.CreatedBy == middleware.GetUserID(r) {
+		return true
+	}
+	return job.OrganizationID != "" && job.OrganizationID == middleware.GetOrganizationID(r)
+}
@@ -0,0 +1,509 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/audit"
+	"github.com/cstanislawski/qualifyd/pkg/database"
+	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/mfa"
+	"github.com/cstanislawski/qualifyd/pkg/middleware"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/cstanislawski/qualifyd/pkg/ratelimit"
+	"github.com/cstanislawski/qualifyd/pkg/repository"
+	"github.com/cstanislawski/qualifyd/pkg/session"
+	"github.com/go-chi/chi/v5"
+)
+
+// MFAChallengeResponse is the response to a sign-in that waits for the
+// user's second factor. No tokens are issued until the challenge is
+// completed.
+type MFAChallengeResponse struct {
+	MFARequired bool      `json:"mfa_required"`
+	MFAToken    string    `json:"mfa_token"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	// EnrollmentRequired is set when the user must set up an authenticator
+	// before signing in
+	EnrollmentRequired bool `json:"enrollment_required"`
+}
+
+// MFAChallengeRequest represents the payload for completing an MFA challenge
+// with a code from the user's authenticator or one of their recovery codes
+type MFAChallengeRequest struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// MFACodeRequest represents a payload carrying a code from the caller's
+// authenticator
+type MFACodeRequest struct {
+	Code string `json:"code"`
+}
+
+// MFAEnrollmentResponse represents a new authenticator to add to an app
+type MFAEnrollmentResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+	QRCode          string `json:"qr_code"`
+}
+
+// MFAEnrollmentAuthResponse represents the response to enrolling during
+// sign-in: the session's tokens and the user's recovery codes
+type MFAEnrollmentAuthResponse struct {
+	AuthResponse
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// MFAStatusResponse represents the caller's MFA setup
+type MFAStatusResponse struct {
+	Enabled                bool `json:"enabled"`
+	Required               bool `json:"required"`
+	RecoveryCodesRemaining int  `json:"recovery_codes_remaining"`
+}
+
+// MFAPolicyRequest represents the payload for setting an organization's MFA
+// policy
+type MFAPolicyRequest struct {
+	RequiredRoles []string `json:"required_roles"`
+}
+
+// MFAHandler handles MFA challenges, enrollment and policies
+type MFAHandler struct {
+	mfa              *mfa.Service
+	userRepo         *repository.UserRepository
+	organizationRepo *repository.OrganizationRepository
+	sessions         *session.Service
+	audit            *audit.Service
+	limiter          *ratelimit.Limiter
+	logger           logger.Logger
+}
+
+// NewMFAHandler creates a new MFAHandler instance
+func NewMFAHandler(
+	mfa *mfa.Service,
+	userRepo *repository.UserRepository,
+	organizationRepo *repository.OrganizationRepository,
+	sessions *session.Service,
+	audit *audit.Service,
+	limiter *ratelimit.Limiter,
+	logger logger.Logger,
+) *MFAHandler {
+	return &MFAHandler{
+		mfa:              mfa,
+		userRepo:         userRepo,
+		organizationRepo: organizationRepo,
+		sessions:         sessions,
+		audit:            audit,
+		limiter:          limiter,
+		logger:           logger,
+	}
+}
+
+// HandleVerifyChallenge handles completing a sign-in with the user's second
+// factor. A challenge allows model.MaxMFAChallengeAttempts codes.
+func (h *MFAHandler) HandleVerifyChallenge(w http.ResponseWriter, r *http.Request) {
+	var req MFAChallengeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.logger.Error("Failed to decode MFA challenge request", err, nil)
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload", err.Error())
+		return
+	}
+	if req.MFAToken == "" || (req.Code == "" && req.RecoveryCode == "") {
+		respondWithError(w, http.StatusBadRequest, "MFA token and code or recovery code are required", "")
+		return
+	}
+
+	userID, err := h.mfa.VerifyChallenge(r.Context(), req.MFAToken, req.Code, req.RecoveryCode)
+	if err != nil {
+		if errors.Is(err, mfa.ErrInvalidCode) {
+			if user, err := h.userRepo.GetByID(r.Context(), userID); err == nil {
+				h.audit.Record(r.Context(), signInEvent(r, model.AuditLoginFailed, user, user.Email))
+			}
+		}
+		h.respondWithMFAError(w, err, userID)
+		return
+	}
+
+	user, ok := h.getUser(w, r, userID)
+	if !ok {
+		return
+	}
+	tokens, ok := h.signIn(w, r, user)
+	if !ok {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, AuthResponse{
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		User:         *user,
+	})
+}
+
+// HandleChallengeEnroll handles a user whose role requires MFA setting up an
+// authenticator while signing in
+func (h *MFAHandler) HandleChallengeEnroll(w http.ResponseWriter, r *http.Request) {
+	var req MFAChallengeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.logger.Error("Failed to decode MFA enrollment request", err, nil)
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload", err.Error())
+		return
+	}
+	if req.MFAToken == "" {
+		respondWithError(w, http.StatusBadRequest, "MFA token is required", "")
+		return
+	}
+
+	userID, err := h.mfa.ChallengeUser(r.Context(), req.MFAToken)
+	if err != nil {
+		h.respondWithMFAError(w, err, "")
+		return
+	}
+	user, ok := h.getUser(w, r, userID)
+	if !ok {
+		return
+	}
+
+	h.beginEnrollment(w, r, user)
+}
+
+// HandleChallengeConfirmEnrollment handles a user confirming the
+// authenticator they set up while signing in. The response carries the
+// session's tokens and the user's recovery codes.
+func (h *MFAHandler) HandleChallengeConfirmEnrollment(w http.ResponseWriter, r *http.Request) {
+	var req MFAChallengeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.logger.Error("Failed to decode MFA enrollment request", err, nil)
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload", err.Error())
+		return
+	}
+	if req.MFAToken == "" || req.Code == "" {
+		respondWithError(w, http.StatusBadRequest, "MFA token and code are required", "")
+		return
+	}
+
+	userID, codes, err := h.mfa.ConfirmChallengeEnrollment(r.Context(), req.MFAToken, req.Code)
+	if err != nil {
+		h.respondWithMFAError(w, err, userID)
+		return
+	}
+
+	user, ok := h.getUser(w, r, userID)
+	if !ok {
+		return
+	}
+	tokens, ok := h.signIn(w, r, user)
+	if !ok {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, MFAEnrollmentAuthResponse{
+		AuthResponse: AuthResponse{
+			AccessToken:  tokens.AccessToken,
+			RefreshToken: tokens.RefreshToken,
+			User:         *user,
+		},
+		RecoveryCodes: codes,
+	})
+}
+
+// HandleGetStatus handles getting the caller's MFA setup
+func (h *MFAHandler) HandleGetStatus(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.getUser(w, r, middleware.GetUserID(r))
+	if !ok {
+		return
+	}
+
+	status, err := h.mfa.Status(r.Context(), user)
+	if err != nil {
+		h.logger.Error("Failed to get MFA status", err, map[string]interface{}{
+			"user_id": user.ID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to get MFA status", "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, MFAStatusResponse{
+		Enabled:                status.Enabled,
+		Required:               status.Required,
+		RecoveryCodesRemaining: status.RecoveryCodesRemaining,
+	})
+}
+
+// HandleEnroll handles the caller setting up an authenticator. It is not
+// used until confirmed with a code.
+func (h *MFAHandler) HandleEnroll(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.getUser(w, r, middleware.GetUserID(r))
+	if !ok {
+		return
+	}
+
+	h.beginEnrollment(w, r, user)
+}
+
+// HandleConfirmEnrollment handles the caller confirming their new
+// authenticator with a code from it. The response carries their recovery
+// codes, which are not shown again.
+func (h *MFAHandler) HandleConfirmEnrollment(w http.ResponseWriter, r *http.Request) {
+	var req MFACodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.logger.Error("Failed to decode MFA enrollment request", err, nil)
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload", err.Error())
+		return
+	}
+
+	userID := middleware.GetUserID(r)
+	codes, err := h.mfa.ConfirmEnrollment(r.Context(), userID, req.Code)
+	if err != nil {
+		h.respondWithMFAError(w, err, userID)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string][]string{
+		"recovery_codes": codes,
+	})
+}
+
+// HandleRegenerateRecoveryCodes handles the caller replacing their recovery
+// codes, confirmed with a code from their authenticator
+func (h *MFAHandler) HandleRegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	var req MFACodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.logger.Error("Failed to decode recovery code request", err, nil)
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload", err.Error())
+		return
+	}
+
+	userID := middleware.GetUserID(r)
+	if err := h.mfa.Verify(r.Context(), userID, req.Code, ""); err != nil {
+		h.respondWithMFAError(w, err, userID)
+		return
+	}
+
+	codes, err := h.mfa.RegenerateRecoveryCodes(r.Context(), userID)
+	if err != nil {
+		h.respondWithMFAError(w, err, userID)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string][]string{
+		"recovery_codes": codes,
+	})
+}
+
+// HandleDisable handles the caller removing their authenticator, confirmed
+// with a code from it. Users whose organization requires MFA for their role
+// cannot.
+func (h *MFAHandler) HandleDisable(w http.ResponseWriter, r *http.Request) {
+	var req MFACodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.logger.Error("Failed to decode MFA disable request", err, nil)
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload", err.Error())
+		return
+	}
+
+	user, ok := h.getUser(w, r, middleware.GetUserID(r))
+	if !ok {
+		return
+	}
+	if err := h.mfa.Verify(r.Context(), user.ID, req.Code, ""); err != nil {
+		h.respondWithMFAError(w, err, user.ID)
+		return
+	}
+	if err := h.mfa.Disable(r.Context(), user); err != nil {
+		h.respondWithMFAError(w, err, user.ID)
+		return
+	}
+
+	e := auditEvent(r, model.AuditMFADisabled, model.AuditTargetUser, user.ID)
+	e.OrganizationID = user.OrganizationID
+	h.audit.Record(r.Context(), e)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleResetUserMFA handles an admin removing the authenticator of a user
+// of their organization who lost it and their recovery codes (admin only)
+func (h *MFAHandler) HandleResetUserMFA(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "user_id")
+
+	user, err := h.userRepo.GetByID(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			respondWithError(w, http.StatusNotFound, "User not found", "")
+			return
+		}
+		h.logger.Error("Failed to get user", err, map[string]interface{}{
+			"user_id": userID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to get user", "")
+		return
+	}
+
+	if !canManageOrganization(r, user.OrganizationID) {
+		respondWithError(w, http.StatusNotFound, "User not found", "")
+		return
+	}
+
+	if err := h.mfa.Reset(r.Context(), user.ID); err != nil {
+		h.respondWithMFAError(w, err, user.ID)
+		return
+	}
+
+	h.logger.Info("User MFA reset by admin", map[string]interface{}{
+		"user_id":  user.ID,
+		"reset_by": middleware.GetUserID(r),
+	})
+	e := auditEvent(r, model.AuditMFAReset, model.AuditTargetUser, user.ID)
+	e.OrganizationID = user.OrganizationID
+	h.audit.Record(r.Context(), e)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleGetPolicy handles getting an organization's MFA policy
+func (h *MFAHandler) HandleGetPolicy(w http.ResponseWriter, r *http.Request) {
+	org, ok := loadManagedOrganization(w, r, h.organizationRepo, h.logger)
+	if !ok {
+		return
+	}
+
+	policy, err := h.mfa.Policy(r.Context(), org.ID)
+	if err != nil {
+		h.logger.Error("Failed to get MFA policy", err, map[string]interface{}{
+			"organization_id": org.ID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to get MFA policy", "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, policy)
+}
+
+// HandleSavePolicy handles setting which of an organization's roles must
+// sign in with MFA. Users with those roles and no authenticator set one up
+// at their next sign-in.
+func (h *MFAHandler) HandleSavePolicy(w http.ResponseWriter, r *http.Request) {
+	org, ok := loadManagedOrganization(w, r, h.organizationRepo, h.logger)
+	if !ok {
+		return
+	}
+
+	var req MFAPolicyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.logger.Error("Failed to decode MFA policy request", err, nil)
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload", err.Error())
+		return
+	}
+
+	policy := &model.MFAPolicy{
+		OrganizationID: org.ID,
+		RequiredRoles:  req.RequiredRoles,
+	}
+	if validationErrors := policy.Validate(); len(validationErrors) > 0 {
+		respondWithJSON(w, http.StatusBadRequest, map[string]interface{}{
+			"error":  "Invalid MFA policy",
+			"fields": validationErrors,
+		})
+		return
+	}
+
+	if err := h.mfa.SavePolicy(r.Context(), policy); err != nil {
+		h.logger.Error("Failed to save MFA policy", err, map[string]interface{}{
+			"organization_id": org.ID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to save MFA policy", "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, policy)
+}
+
+// beginEnrollment sets up a new authenticator for user. Candidates sign in
+// with links and do not use MFA.
+func (h *MFAHandler) beginEnrollment(w http.ResponseWriter, r *http.Request, user *model.User) {
+	if user.Role == model.RoleCandidate {
+		respondWithError(w, http.StatusForbidden, "Multi-factor authentication is not available to candidates", "")
+		return
+	}
+
+	enrollment, err := h.mfa.BeginEnrollment(r.Context(), user)
+	if err != nil {
+		h.respondWithMFAError(w, err, user.ID)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, MFAEnrollmentResponse{
+		Secret:          enrollment.Secret,
+		ProvisioningURI: enrollment.ProvisioningURI,
+		QRCode:          enrollment.QRCode,
+	})
+}
+
+// getUser loads the user a request acts for, responding with an error if it
+// fails
+func (h *MFAHandler) getUser(w http.ResponseWriter, r *http.Request, userID string) (*model.User, bool) {
+	user, err := h.userRepo.GetByID(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			respondWithError(w, http.StatusNotFound, "User not found", "")
+			return nil, false
+		}
+		h.logger.Error("Failed to get user", err, map[string]interface{}{
+			"user_id": userID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to get user", "")
+		return nil, false
+	}
+	return user, true
+}
+
+// signIn starts a session for a user who completed their MFA challenge,
+// which ends the sign-in, so their failed sign-ins are forgotten. The user's
+// password hash is cleared for the response.
+func (h *MFAHandler) signIn(w http.ResponseWriter, r *http.Request, user *model.User) (*session.Tokens, bool) {
+	if user.Status != model.StatusActive {
+		respondWithError(w, http.StatusUnauthorized, "Account is not active", "")
+		return nil, false
+	}
+
+	if err := h.userRepo.UpdateLastLogin(r.Context(), user.ID); err != nil {
+		h.logger.Error("Failed to update last login time", err, map[string]interface{}{"userID": user.ID})
+	}
+
+	tokens, err := h.sessions.Start(r.Context(), user, clientOf(r))
+	if err != nil {
+		h.logger.Error("Failed to start session", err, map[string]interface{}{"userID": user.ID})
+		respondWithError(w, http.StatusInternalServerError, "Failed to generate authentication tokens", "")
+		return nil, false
+	}
+	h.audit.Record(r.Context(), signInEvent(r, model.AuditLogin, user, user.Email))
+	h.limiter.LoginSucceeded(r.Context(), user.Email)
+
+	user.PasswordHash = ""
+	return tokens, true
+}
+
+// respondWithMFAError responds with the error of an MFA operation
+func (h *MFAHandler) respondWithMFAError(w http.ResponseWriter, err error, userID string) {
+	switch {
+	case errors.Is(err, repository.ErrMFAChallengeInvalid):
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired MFA challenge", "Sign in again")
+	case errors.Is(err, mfa.ErrInvalidCode):
+		respondWithError(w, http.StatusUnauthorized, "Invalid code", "")
+	case errors.Is(err, mfa.ErrNotEnrolled):
+		respondWithError(w, http.StatusBadRequest, "Multi-factor authentication is not set up", "")
+	case errors.Is(err, repository.ErrMFAAlreadyEnabled):
+		respondWithError(w, http.StatusConflict, "Multi-factor authentication is already enabled", "")
+	case errors.Is(err, mfa.ErrRequired):
+		respondWithError(w, http.StatusForbidden, "Your organization requires multi-factor authentication", "")
+	default:
+		h.logger.Error("MFA operation failed", err, map[string]interface{}{
+			"user_id": userID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Multi-factor authentication failed", "")
+	}
+}
@@ -0,0 +1,386 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/cstanislawski/qualifyd/pkg/audit"
+	"github.com/cstanislawski/qualifyd/pkg/database"
+	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/middleware"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/cstanislawski/qualifyd/pkg/notification"
+	"github.com/cstanislawski/qualifyd/pkg/repository"
+	"github.com/go-chi/chi/v5"
+)
+
+// UpdateOrganizationRequest represents the request payload for updating an organization profile.
+// Omitted fields are left unchanged; an empty string clears an optional field.
+type UpdateOrganizationRequest struct {
+	Name           *string `json:"name,omitempty"`
+	ContactEmail   *string `json:"contact_email,omitempty"`
+	ContactPhone   *string `json:"contact_phone,omitempty"`
+	BillingEmail   *string `json:"billing_email,omitempty"`
+	BillingAddress *string `json:"billing_address,omitempty"`
+	LogoURL        *string `json:"logo_url,omitempty"`
+	WebsiteURL     *string `json:"website_url,omitempty"`
+	AutoRenew      *bool   `json:"auto_renew,omitempty"`
+	EmailReminders *bool   `json:"email_reminders,omitempty"`
+	EmailLocale    *string `json:"email_locale,omitempty"`
+}
+
+// TransferOwnershipRequest represents the request payload for transferring organization ownership
+type TransferOwnershipRequest struct {
+	UserID string `json:"user_id"`
+}
+
+// OrganizationResponse is an organization together with its member count
+type OrganizationResponse struct {
+	*model.Organization
+	MemberCount int `json:"member_count"`
+}
+
+// OrganizationMember is a user listed as a member of an organization
+type OrganizationMember struct {
+	*model.User
+	IsOwner bool `json:"is_owner"`
+}
+
+// QuotaResponse is an organization quota with the usage percentage of each resource
+type QuotaResponse struct {
+	Quota                       *model.OrganizationQuota `json:"quota"`
+	Usage                       map[string]float64       `json:"usage"`
+	RemainingEnvironmentMinutes int                      `json:"remaining_environment_minutes"`
+}
+
+// OrganizationHandler handles organization management operations
+type OrganizationHandler struct {
+	organizationRepo *repository.OrganizationRepository
+	userRepo         *repository.UserRepository
+	quotaRepo        *repository.QuotaRepository
+	audit            *audit.Service
+	logger           logger.Logger
+}
+
+// NewOrganizationHandler creates a new OrganizationHandler instance
+func NewOrganizationHandler(
+	organizationRepo *repository.OrganizationRepository,
+	userRepo *repository.UserRepository,
+	quotaRepo *repository.QuotaRepository,
+	audit *audit.Service,
+	logger logger.Logger,
+) *OrganizationHandler {
+	return &OrganizationHandler{
+		organizationRepo: organizationRepo,
+		userRepo:         userRepo,
+		quotaRepo:        quotaRepo,
+		audit:            audit,
+		logger:           logger,
+	}
+}
+
+// HandleListOrganizations handles listing every organization (super admin only)
+func (h *OrganizationHandler) HandleListOrganizations(w http.ResponseWriter, r *http.Request) {
+	params := getPaginationParams(r, 10, 100)
+
+	response, err := h.organizationRepo.GetPaginatedOrganizations(r.Context(), params)
+	if err != nil {
+		h.logger.Error("Failed to list organizations", err, nil)
+		respondWithError(w, http.StatusInternalServerError, "Failed to list organizations", "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// HandleGetOrganization handles getting an organization profile
+func (h *OrganizationHandler) HandleGetOrganization(w http.ResponseWriter, r *http.Request) {
+	org, ok := h.getManagedOrganization(w, r)
+	if !ok {
+		return
+	}
+
+	memberCount, err := h.organizationRepo.GetUserCount(r.Context(), org.ID)
+	if err != nil {
+		h.logger.Error("Failed to count organization members", err, map[string]interface{}{
+			"organization_id": org.ID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to get organization", "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, OrganizationResponse{
+		Organization: org,
+		MemberCount:  memberCount,
+	})
+}
+
+// HandleUpdateOrganization handles updating an organization's profile, contact and billing details
+func (h *OrganizationHandler) HandleUpdateOrganization(w http.ResponseWriter, r *http.Request) {
+	org, ok := h.getManagedOrganization(w, r)
+	if !ok {
+		return
+	}
+
+	var req UpdateOrganizationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.logger.Error("Failed to decode update organization request", err, nil)
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload", err.Error())
+		return
+	}
+	before := *org
+
+	if req.Name != nil {
+		org.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.ContactEmail != nil {
+		org.ContactEmail = strings.ToLower(strings.TrimSpace(*req.ContactEmail))
+	}
+	if req.ContactPhone != nil {
+		org.ContactPhone = strings.TrimSpace(*req.ContactPhone)
+	}
+	if req.BillingEmail != nil {
+		org.BillingEmail = strings.ToLower(strings.TrimSpace(*req.BillingEmail))
+	}
+	if req.BillingAddress != nil {
+		org.BillingAddress = strings.TrimSpace(*req.BillingAddress)
+	}
+	if req.LogoURL != nil {
+		org.LogoURL = strings.TrimSpace(*req.LogoURL)
+	}
+	if req.WebsiteURL != nil {
+		org.WebsiteURL = strings.TrimSpace(*req.WebsiteURL)
+	}
+	if req.AutoRenew != nil {
+		org.AutoRenew = *req.AutoRenew
+	}
+	if req.EmailReminders != nil {
+		org.EmailReminders = *req.EmailReminders
+	}
+	if req.EmailLocale != nil {
+		org.EmailLocale = strings.TrimSpace(*req.EmailLocale)
+	}
+
+	if validationErrors := validateOrganizationProfile(org); len(validationErrors) > 0 {
+		respondWithJSON(w, http.StatusBadRequest, map[string]interface{}{
+			"error":  "Invalid organization profile",
+			"fields": validationErrors,
+		})
+		return
+	}
+
+	if err := h.organizationRepo.Update(r.Context(), org); err != nil {
+		if errors.Is(err, database.ErrDuplicateKey) {
+			respondWithError(w, http.StatusConflict, "Organization name already exists", "")
+			return
+		}
+		h.logger.Error("Failed to update organization", err, map[string]interface{}{
+			"organization_id": org.ID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to update organization", "")
+		return
+	}
+
+	e := auditEvent(r, model.AuditOrganizationUpdated, model.AuditTargetOrganization, org.ID)
+	e.OrganizationID = org.ID
+	h.audit.RecordChange(r.Context(), e, &before, org)
+
+	respondWithJSON(w, http.StatusOK, org)
+}
+
+// HandleDeleteOrganization handles deleting an organization without members (super admin only)
+func (h *OrganizationHandler) HandleDeleteOrganization(w http.ResponseWriter, r *http.Request) {
+	orgID := chi.URLParam(r, "org_id")
+
+	if err := h.organizationRepo.Delete(r.Context(), orgID); err != nil {
+		switch {
+		case errors.Is(err, database.ErrRecordNotFound):
+			respondWithError(w, http.StatusNotFound, "Organization not found", "")
+		case errors.Is(err, database.ErrForeignKeyViolation):
+			respondWithError(w, http.StatusConflict, "Organization still has members", "Remove all members before deleting the organization")
+		default:
+			h.logger.Error("Failed to delete organization", err, map[string]interface{}{
+				"organization_id": orgID,
+			})
+			respondWithError(w, http.StatusInternalServerError, "Failed to delete organization", "")
+		}
+		return
+	}
+
+	// The event ends the organization's chain of events, which outlives it
+	e := auditEvent(r, model.AuditOrganizationDeleted, model.AuditTargetOrganization, orgID)
+	e.OrganizationID = orgID
+	h.audit.Record(r.Context(), e)
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
+
+// HandleListMembers handles listing an organization's members with their roles
+func (h *OrganizationHandler) HandleListMembers(w http.ResponseWriter, r *http.Request) {
+	org, ok := h.getManagedOrganization(w, r)
+	if !ok {
+		return
+	}
+
+	params := getPaginationParams(r, 10, 100)
+	response, err := h.userRepo.GetPaginatedUsers(r.Context(), params, &org.ID)
+	if err != nil {
+		h.logger.Error("Failed to list organization members", err, map[string]interface{}{
+			"organization_id": org.ID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to list members", "")
+		return
+	}
+
+	if users, ok := response.Data.([]*model.User); ok {
+		members := make([]OrganizationMember, 0, len(users))
+		for _, u := range users {
+			u.PasswordHash = ""
+			members = append(members, OrganizationMember{
+				User:    u,
+				IsOwner: org.IsOwnedBy(u.ID),
+			})
+		}
+		response.Data = members
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// HandleTransferOwnership handles transferring ownership to another member.
+// Only the current owner or a super admin may transfer ownership.
+func (h *OrganizationHandler) HandleTransferOwnership(w http.ResponseWriter, r *http.Request) {
+	org, ok := h.getManagedOrganization(w, r)
+	if !ok {
+		return
+	}
+
+	if !middleware.IsSuperAdmin(r) && !org.IsOwnedBy(middleware.GetUserID(r)) {
+		respondWithError(w, http.StatusForbidden, "Forbidden", "Only the organization owner can transfer ownership")
+		return
+	}
+
+	var req TransferOwnershipRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.logger.Error("Failed to decode transfer ownership request", err, nil)
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload", err.Error())
+		return
+	}
+	if req.UserID == "" {
+		respondWithError(w, http.StatusBadRequest, "user_id is required", "")
+		return
+	}
+
+	if err := h.organizationRepo.TransferOwnership(r.Context(), org.ID, req.UserID); err != nil {
+		switch {
+		case errors.Is(err, database.ErrRecordNotFound):
+			respondWithError(w, http.StatusNotFound, "Organization not found", "")
+		case errors.Is(err, repository.ErrInvalidOwner):
+			respondWithError(w, http.StatusBadRequest, "Invalid new owner", "The new owner must be an active, non-candidate member of the organization")
+		default:
+			h.logger.Error("Failed to transfer organization ownership", err, map[string]interface{}{
+				"organization_id": org.ID,
+				"new_owner_id":    req.UserID,
+			})
+			respondWithError(w, http.StatusInternalServerError, "Failed to transfer ownership", "")
+		}
+		return
+	}
+
+	h.logger.Info("Organization ownership transferred", map[string]interface{}{
+		"organization_id":   org.ID,
+		"previous_owner_id": org.OwnerID,
+		"new_owner_id":      req.UserID,
+		"transferred_by":    middleware.GetUserID(r),
+	})
+	e := auditEvent(r, model.AuditOwnershipTransferred, model.AuditTargetOrganization, org.ID)
+	e.OrganizationID = org.ID
+	e.Diff, _ = json.Marshal(map[string]model.AuditFieldChange{
+		"owner_id": {Before: org.OwnerID, After: req.UserID},
+	})
+	h.audit.Record(r.Context(), e)
+
+	updated, err := h.organizationRepo.GetByID(r.Context(), org.ID)
+	if err != nil {
+		h.logger.Error("Failed to get organization", err, map[string]interface{}{
+			"organization_id": org.ID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to get organization", "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, updated)
+}
+
+// HandleGetQuota handles getting an organization's quota and usage
+func (h *OrganizationHandler) HandleGetQuota(w http.ResponseWriter, r *http.Request) {
+	org, ok := h.getManagedOrganization(w, r)
+	if !ok {
+		return
+	}
+
+	quota, err := h.quotaRepo.GetByOrganization(r.Context(), org.ID)
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			respondWithError(w, http.StatusNotFound, "Quota not found", "")
+			return
+		}
+		h.logger.Error("Failed to get organization quota", err, map[string]interface{}{
+			"organization_id": org.ID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to get quota", "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, QuotaResponse{
+		Quota:                       quota,
+		Usage:                       quota.UsageSummary(),
+		RemainingEnvironmentMinutes: quota.RemainingEnvironmentMinutes(),
+	})
+}
+
+// getManagedOrganization loads the {org_id} organization if the caller may manage it
+func (h *OrganizationHandler) getManagedOrganization(w http.ResponseWriter, r *http.Request) (*model.Organization, bool) {
+	return loadManagedOrganization(w, r, h.organizationRepo, h.logger)
+}
+
+// validateOrganizationProfile validates the fields editable through the profile endpoint
+func validateOrganizationProfile(org *model.Organization) map[string]string {
+	errors := make(map[string]string)
+
+	// Reuse the model validation for name and contact email only; subscription
+	// fields are managed by billing and are not editable here
+	modelErrors := org.Validate()
+	for _, field := range []string{"name", "contact_email"} {
+		if msg, ok := modelErrors[field]; ok {
+			errors[field] = msg
+		}
+	}
+
+	if org.BillingEmail != "" && (!strings.Contains(org.BillingEmail, "@") || !strings.Contains(org.BillingEmail, ".")) {
+		errors["billing_email"] = "Invalid billing email format"
+	}
+	if org.LogoURL != "" && !isHTTPURL(org.LogoURL) {
+		errors["logo_url"] = "Logo URL must be an http or https URL"
+	}
+	if org.WebsiteURL != "" && !isHTTPURL(org.WebsiteURL) {
+		errors["website_url"] = "Website URL must be an http or https URL"
+	}
+	if !notification.IsLocale(org.EmailLocale) {
+		errors["email_locale"] = "Email locale must be one of: " + strings.Join(notification.Locales, ", ")
+	}
+
+	return errors
+}
+
+// isHTTPURL reports whether raw is an absolute http or https URL
+func isHTTPURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
@@ -0,0 +1,226 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/billing"
+	"github.com/cstanislawski/qualifyd/pkg/database"
+	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/middleware"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/cstanislawski/qualifyd/pkg/repository"
+	"github.com/cstanislawski/qualifyd/pkg/subscription"
+)
+
+// ChangePlanRequest represents the request payload for changing an organization's plan
+type ChangePlanRequest struct {
+	Plan string `json:"plan"`
+	// AllowOverages confirms a downgrade even though current usage is above
+	// the new plan's limits
+	AllowOverages bool   `json:"allow_overages"`
+	Note          string `json:"note,omitempty"`
+}
+
+// ChangePlanResponse is the result of a plan change
+type ChangePlanResponse struct {
+	Change        *model.QuotaChange   `json:"change"`
+	BillingRecord *model.BillingRecord `json:"billing_record,omitempty"`
+}
+
+// QuotaOverridesResponse is an organization's custom quota limits together
+// with the limits in effect
+type QuotaOverridesResponse struct {
+	Overrides *model.QuotaOverrides `json:"overrides"`
+	Limits    model.QuotaLimits     `json:"limits"`
+}
+
+// PlanHandler handles plan changes and custom quota limits
+type PlanHandler struct {
+	organizationRepo *repository.OrganizationRepository
+	quotaRepo        *repository.QuotaRepository
+	planService      *subscription.PlanService
+	logger           logger.Logger
+}
+
+// NewPlanHandler creates a new PlanHandler instance
+func NewPlanHandler(
+	organizationRepo *repository.OrganizationRepository,
+	quotaRepo *repository.QuotaRepository,
+	planService *subscription.PlanService,
+	logger logger.Logger,
+) *PlanHandler {
+	return &PlanHandler{
+		organizationRepo: organizationRepo,
+		quotaRepo:        quotaRepo,
+		planService:      planService,
+		logger:           logger,
+	}
+}
+
+// HandleChangePlan handles upgrading or downgrading an organization's plan.
+// A downgrade that leaves usage above the new limits is refused with the list
+// of overages unless allow_overages is set.
+func (h *PlanHandler) HandleChangePlan(w http.ResponseWriter, r *http.Request) {
+	org, ok := loadManagedOrganization(w, r, h.organizationRepo, h.logger)
+	if !ok {
+		return
+	}
+
+	var req ChangePlanRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.logger.Error("Failed to decode change plan request", err, nil)
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload", err.Error())
+		return
+	}
+
+	change, record, err := h.planService.ChangePlan(r.Context(), org, subscription.PlanChangeRequest{
+		Plan:          strings.ToLower(strings.TrimSpace(req.Plan)),
+		AllowOverages: req.AllowOverages,
+		ChangedBy:     middleware.GetUserID(r),
+		Note:          strings.TrimSpace(req.Note),
+	})
+	if err != nil {
+		var overageErr *subscription.OverageError
+		switch {
+		case errors.Is(err, subscription.ErrInvalidPlan):
+			respondWithError(w, http.StatusBadRequest, "Invalid subscription plan", "")
+		case errors.Is(err, subscription.ErrPlanUnchanged):
+			respondWithError(w, http.StatusConflict, "Organization is already on this plan", "")
+		case errors.As(err, &overageErr):
+			respondWithJSON(w, http.StatusConflict, map[string]interface{}{
+				"error":    "Current usage is above the new plan's limits",
+				"details":  "Existing resources are kept but new ones are refused until usage is within the limits; set allow_overages to confirm",
+				"overages": overageErr.Overages,
+			})
+		case errors.Is(err, billing.ErrChargeFailed), errors.Is(err, billing.ErrNoPaymentMethod):
+			respondWithError(w, http.StatusPaymentRequired, "Plan change payment failed", err.Error())
+		default:
+			h.logger.Error("Failed to change organization plan", err, map[string]interface{}{
+				"organization_id": org.ID,
+				"plan":            req.Plan,
+			})
+			respondWithError(w, http.StatusInternalServerError, "Failed to change plan", "")
+		}
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, ChangePlanResponse{
+		Change:        change,
+		BillingRecord: record,
+	})
+}
+
+// HandleListQuotaChanges handles listing the audit trail of an organization's
+// plan and quota limit changes, newest first
+func (h *PlanHandler) HandleListQuotaChanges(w http.ResponseWriter, r *http.Request) {
+	org, ok := loadManagedOrganization(w, r, h.organizationRepo, h.logger)
+	if !ok {
+		return
+	}
+
+	response, err := h.quotaRepo.ListChanges(r.Context(), org.ID, getPaginationParams(r, 20, 100))
+	if err != nil {
+		h.logger.Error("Failed to list quota changes", err, map[string]interface{}{
+			"organization_id": org.ID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to list plan changes", "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// HandleGetQuotaOverrides handles getting an organization's custom quota limits
+func (h *PlanHandler) HandleGetQuotaOverrides(w http.ResponseWriter, r *http.Request) {
+	org, ok := loadManagedOrganization(w, r, h.organizationRepo, h.logger)
+	if !ok {
+		return
+	}
+
+	overrides, err := h.quotaRepo.GetOverrides(r.Context(), org.ID)
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			respondWithError(w, http.StatusNotFound, "Organization has no quota overrides", "")
+			return
+		}
+		h.logger.Error("Failed to get quota overrides", err, map[string]interface{}{
+			"organization_id": org.ID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to get quota overrides", "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, QuotaOverridesResponse{
+		Overrides: overrides,
+		Limits:    model.EffectiveLimits(org.SubscriptionPlan, overrides),
+	})
+}
+
+// HandleSetQuotaOverrides handles replacing an enterprise organization's
+// custom quota limits (super admin only). Omitted limits keep the plan default.
+func (h *PlanHandler) HandleSetQuotaOverrides(w http.ResponseWriter, r *http.Request) {
+	org, ok := loadManagedOrganization(w, r, h.organizationRepo, h.logger)
+	if !ok {
+		return
+	}
+
+	var overrides model.QuotaOverrides
+	if err := json.NewDecoder(r.Body).Decode(&overrides); err != nil {
+		h.logger.Error("Failed to decode quota overrides request", err, nil)
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload", err.Error())
+		return
+	}
+	if overrides.IsEmpty() {
+		respondWithError(w, http.StatusBadRequest, "At least one limit must be overridden", "Use DELETE to remove the overrides")
+		return
+	}
+	if validationErrors := overrides.Validate(); len(validationErrors) > 0 {
+		respondWithJSON(w, http.StatusBadRequest, map[string]interface{}{
+			"error":  "Invalid quota overrides",
+			"fields": validationErrors,
+		})
+		return
+	}
+	overrides.Note = strings.TrimSpace(overrides.Note)
+	overrides.UpdatedBy = middleware.GetUserID(r)
+	overrides.UpdatedAt = time.Now().UTC()
+
+	change, err := h.planService.SetOverrides(r.Context(), org, &overrides)
+	if err != nil {
+		if errors.Is(err, subscription.ErrOverridesRequireEnterprise) {
+			respondWithError(w, http.StatusConflict, "Quota overrides require the enterprise plan", "")
+			return
+		}
+		h.logger.Error("Failed to set quota overrides", err, map[string]interface{}{
+			"organization_id": org.ID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to set quota overrides", "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, change)
+}
+
+// HandleDeleteQuotaOverrides handles removing an organization's custom quota
+// limits, restoring its plan's defaults (super admin only)
+func (h *PlanHandler) HandleDeleteQuotaOverrides(w http.ResponseWriter, r *http.Request) {
+	org, ok := loadManagedOrganization(w, r, h.organizationRepo, h.logger)
+	if !ok {
+		return
+	}
+
+	change, err := h.planService.ClearOverrides(r.Context(), org, middleware.GetUserID(r))
+	if err != nil {
+		h.logger.Error("Failed to clear quota overrides", err, map[string]interface{}{
+			"organization_id": org.ID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to clear quota overrides", "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, change)
+}
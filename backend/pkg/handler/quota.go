@@ -0,0 +1,23 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/cstanislawski/qualifyd/pkg/quota"
+)
+
+// respondWithQuotaError writes a structured 402 or 429 response and returns
+// true if err is a quota or subscription refusal
+func respondWithQuotaError(w http.ResponseWriter, err error) bool {
+	status, refusal, ok := quota.NewRefusal(err)
+	if !ok {
+		return false
+	}
+
+	if refusal.RetryAfterSeconds > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(refusal.RetryAfterSeconds))
+	}
+	respondWithJSON(w, status, refusal)
+	return true
+}
@@ -0,0 +1,402 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/cstanislawski/qualifyd/pkg/audit"
+	"github.com/cstanislawski/qualifyd/pkg/database"
+	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/middleware"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/cstanislawski/qualifyd/pkg/rbac"
+	"github.com/cstanislawski/qualifyd/pkg/repository"
+	"github.com/go-chi/chi/v5"
+)
+
+// CustomRoleRequest represents the request payload for creating or updating
+// a custom role
+type CustomRoleRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Permissions []string `json:"permissions"`
+}
+
+// RoleAssignmentRequest represents the request payload for assigning a user
+// a built-in or custom role, optionally on one resource
+type RoleAssignmentRequest struct {
+	Role         string `json:"role,omitempty"`
+	CustomRoleID string `json:"custom_role_id,omitempty"`
+	ResourceType string `json:"resource_type,omitempty"`
+	ResourceID   string `json:"resource_id,omitempty"`
+}
+
+// BuiltInRole represents a built-in role and its permissions
+type BuiltInRole struct {
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+}
+
+// RolesResponse represents the roles an organization's users can hold and
+// the permissions they are made of
+type RolesResponse struct {
+	Permissions []string            `json:"permissions"`
+	BuiltIn     []BuiltInRole       `json:"built_in"`
+	Custom      []*model.CustomRole `json:"custom"`
+}
+
+// PermissionsResponse represents the permissions the caller holds across
+// their organization and on single resources
+type PermissionsResponse struct {
+	Role        string              `json:"role"`
+	Permissions []string            `json:"permissions"`
+	Resources   map[string][]string `json:"resources,omitempty"`
+}
+
+// RoleHandler handles custom roles, role assignments and callers'
+// permissions
+type RoleHandler struct {
+	organizationRepo *repository.OrganizationRepository
+	userRepo         *repository.UserRepository
+	rbac             *rbac.Service
+	audit            *audit.Service
+	logger           logger.Logger
+}
+
+// NewRoleHandler creates a new RoleHandler instance
+func NewRoleHandler(
+	organizationRepo *repository.OrganizationRepository,
+	userRepo *repository.UserRepository,
+	rbac *rbac.Service,
+	audit *audit.Service,
+	logger logger.Logger,
+) *RoleHandler {
+	return &RoleHandler{
+		organizationRepo: organizationRepo,
+		userRepo:         userRepo,
+		rbac:             rbac,
+		audit:            audit,
+		logger:           logger,
+	}
+}
+
+// HandleListRoles handles listing the permissions, the built-in roles and an
+// organization's custom roles
+func (h *RoleHandler) HandleListRoles(w http.ResponseWriter, r *http.Request) {
+	org, ok := loadManagedOrganization(w, r, h.organizationRepo, h.logger)
+	if !ok {
+		return
+	}
+
+	custom, err := h.rbac.ListCustomRoles(r.Context(), org.ID)
+	if err != nil {
+		h.logger.Error("Failed to list custom roles", err, map[string]interface{}{
+			"organization_id": org.ID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to list roles", "")
+		return
+	}
+
+	builtIn := make([]BuiltInRole, 0, len(model.BuiltInRolePermissions))
+	for name, permissions := range model.BuiltInRolePermissions {
+		builtIn = append(builtIn, BuiltInRole{Name: name, Permissions: permissions})
+	}
+	sort.Slice(builtIn, func(i, j int) bool { return builtIn[i].Name < builtIn[j].Name })
+
+	respondWithJSON(w, http.StatusOK, RolesResponse{
+		Permissions: model.Permissions,
+		BuiltIn:     builtIn,
+		Custom:      custom,
+	})
+}
+
+// HandleCreateRole handles creating a custom role
+func (h *RoleHandler) HandleCreateRole(w http.ResponseWriter, r *http.Request) {
+	org, ok := loadManagedOrganization(w, r, h.organizationRepo, h.logger)
+	if !ok {
+		return
+	}
+
+	role, ok := h.decodeRole(w, r, org.ID)
+	if !ok {
+		return
+	}
+
+	if err := h.rbac.CreateCustomRole(r.Context(), role); err != nil {
+		h.respondWithRoleError(w, err, org.ID)
+		return
+	}
+
+	e := auditEvent(r, model.AuditRoleCreated, model.AuditTargetCustomRole, role.ID)
+	e.OrganizationID = org.ID
+	h.audit.RecordChange(r.Context(), e, nil, role)
+
+	respondWithJSON(w, http.StatusCreated, role)
+}
+
+// HandleUpdateRole handles replacing a custom role's name, description and
+// permissions. Users holding the role get the new permissions.
+func (h *RoleHandler) HandleUpdateRole(w http.ResponseWriter, r *http.Request) {
+	org, ok := loadManagedOrganization(w, r, h.organizationRepo, h.logger)
+	if !ok {
+		return
+	}
+
+	role, ok := h.decodeRole(w, r, org.ID)
+	if !ok {
+		return
+	}
+	role.ID = chi.URLParam(r, "role_id")
+	before, err := h.rbac.GetCustomRole(r.Context(), org.ID, role.ID)
+	if err != nil {
+		h.respondWithRoleError(w, err, org.ID)
+		return
+	}
+
+	if err := h.rbac.UpdateCustomRole(r.Context(), role); err != nil {
+		h.respondWithRoleError(w, err, org.ID)
+		return
+	}
+
+	e := auditEvent(r, model.AuditRoleUpdated, model.AuditTargetCustomRole, role.ID)
+	e.OrganizationID = org.ID
+	h.audit.RecordChange(r.Context(), e, before, role)
+
+	respondWithJSON(w, http.StatusOK, role)
+}
+
+// HandleDeleteRole handles deleting a custom role. It is taken from every
+// user who holds it.
+func (h *RoleHandler) HandleDeleteRole(w http.ResponseWriter, r *http.Request) {
+	org, ok := loadManagedOrganization(w, r, h.organizationRepo, h.logger)
+	if !ok {
+		return
+	}
+
+	roleID := chi.URLParam(r, "role_id")
+	if err := h.rbac.DeleteCustomRole(r.Context(), org.ID, roleID); err != nil {
+		h.respondWithRoleError(w, err, org.ID)
+		return
+	}
+
+	e := auditEvent(r, model.AuditRoleDeleted, model.AuditTargetCustomRole, roleID)
+	e.OrganizationID = org.ID
+	h.audit.Record(r.Context(), e)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleListUserRoles handles listing the roles assigned to a user of the
+// caller's organization, on top of their own role
+func (h *RoleHandler) HandleListUserRoles(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.loadManagedUser(w, r)
+	if !ok {
+		return
+	}
+
+	assignments, err := h.rbac.ListAssignments(r.Context(), user.ID)
+	if err != nil {
+		h.logger.Error("Failed to list role assignments", err, map[string]interface{}{
+			"user_id": user.ID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to list roles", "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, assignments)
+}
+
+// HandleAssignUserRole handles assigning a user of the caller's organization
+// a built-in or custom role, across the organization or on one resource.
+// Candidates are not assigned roles.
+func (h *RoleHandler) HandleAssignUserRole(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.loadManagedUser(w, r)
+	if !ok {
+		return
+	}
+	if user.Role == model.RoleCandidate || user.Role == model.RoleSuperAdmin {
+		respondWithError(w, http.StatusBadRequest, "Roles cannot be assigned to this user", "")
+		return
+	}
+
+	var req RoleAssignmentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.logger.Error("Failed to decode role assignment request", err, nil)
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload", err.Error())
+		return
+	}
+
+	assignment := &model.RoleAssignment{
+		UserID:       user.ID,
+		Role:         strings.TrimSpace(req.Role),
+		CustomRoleID: strings.TrimSpace(req.CustomRoleID),
+		ResourceType: strings.TrimSpace(req.ResourceType),
+		ResourceID:   strings.TrimSpace(req.ResourceID),
+		CreatedBy:    middleware.GetUserID(r),
+	}
+	if validationErrors := assignment.Validate(); len(validationErrors) > 0 {
+		respondWithJSON(w, http.StatusBadRequest, map[string]interface{}{
+			"error":  "Invalid role assignment",
+			"fields": validationErrors,
+		})
+		return
+	}
+
+	// Custom roles can only be assigned within their organization
+	if assignment.CustomRoleID != "" {
+		if _, err := h.rbac.GetCustomRole(r.Context(), user.OrganizationID, assignment.CustomRoleID); err != nil {
+			if errors.Is(err, database.ErrRecordNotFound) {
+				respondWithJSON(w, http.StatusBadRequest, map[string]interface{}{
+					"error":  "Invalid role assignment",
+					"fields": map[string]string{"custom_role_id": "Custom role not found"},
+				})
+				return
+			}
+			h.respondWithRoleError(w, err, user.OrganizationID)
+			return
+		}
+	}
+
+	if err := h.rbac.Assign(r.Context(), assignment); err != nil {
+		h.respondWithRoleError(w, err, user.OrganizationID)
+		return
+	}
+
+	e := auditEvent(r, model.AuditRoleAssigned, model.AuditTargetRoleAssignment, assignment.ID)
+	e.OrganizationID = user.OrganizationID
+	h.audit.RecordChange(r.Context(), e, nil, assignment)
+
+	respondWithJSON(w, http.StatusCreated, assignment)
+}
+
+// HandleUnassignUserRole handles removing one of the roles assigned to a
+// user of the caller's organization
+func (h *RoleHandler) HandleUnassignUserRole(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.loadManagedUser(w, r)
+	if !ok {
+		return
+	}
+
+	assignmentID := chi.URLParam(r, "assignment_id")
+	err := h.rbac.Unassign(r.Context(), user.ID, assignmentID, middleware.GetUserID(r))
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			respondWithError(w, http.StatusNotFound, "Role assignment not found", "")
+			return
+		}
+		h.respondWithRoleError(w, err, user.OrganizationID)
+		return
+	}
+
+	e := auditEvent(r, model.AuditRoleUnassigned, model.AuditTargetRoleAssignment, assignmentID)
+	e.OrganizationID = user.OrganizationID
+	h.audit.Record(r.Context(), e)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleGetMyPermissions handles getting the permissions the caller holds,
+// for clients to show what the caller can do
+func (h *RoleHandler) HandleGetMyPermissions(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+	role := middleware.GetUserRole(r)
+
+	grants, err := h.rbac.Grants(r.Context(), userID, role)
+	if err != nil {
+		h.logger.Error("Failed to resolve permissions", err, map[string]interface{}{
+			"user_id": userID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to get permissions", "")
+		return
+	}
+
+	resp := PermissionsResponse{
+		Role:        role,
+		Permissions: grants.List(),
+	}
+	if len(grants.Resources) > 0 {
+		resp.Resources = make(map[string][]string, len(grants.Resources))
+		for resource, permissions := range grants.Resources {
+			for _, p := range model.Permissions {
+				if permissions[p] {
+					resp.Resources[resource] = append(resp.Resources[resource], p)
+				}
+			}
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// decodeRole decodes and validates a custom role of an organization from the
+// request body, responding with an error if it fails
+func (h *RoleHandler) decodeRole(w http.ResponseWriter, r *http.Request, organizationID string) (*model.CustomRole, bool) {
+	var req CustomRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.logger.Error("Failed to decode custom role request", err, nil)
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload", err.Error())
+		return nil, false
+	}
+
+	role := &model.CustomRole{
+		OrganizationID: organizationID,
+		Name:           strings.TrimSpace(req.Name),
+		Description:    strings.TrimSpace(req.Description),
+		Permissions:    req.Permissions,
+	}
+	if validationErrors := role.Validate(); len(validationErrors) > 0 {
+		respondWithJSON(w, http.StatusBadRequest, map[string]interface{}{
+			"error":  "Invalid role",
+			"fields": validationErrors,
+		})
+		return nil, false
+	}
+
+	return role, true
+}
+
+// loadManagedUser loads the {user_id} user if they belong to an organization
+// the caller manages and writes a 404 otherwise
+func (h *RoleHandler) loadManagedUser(w http.ResponseWriter, r *http.Request) (*model.User, bool) {
+	userID := chi.URLParam(r, "user_id")
+
+	user, err := h.userRepo.GetByID(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			respondWithError(w, http.StatusNotFound, "User not found", "")
+			return nil, false
+		}
+		h.logger.Error("Failed to get user", err, map[string]interface{}{
+			"user_id": userID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to get user", "")
+		return nil, false
+	}
+
+	if !canManageOrganization(r, user.OrganizationID) {
+		respondWithError(w, http.StatusNotFound, "User not found", "")
+		return nil, false
+	}
+
+	return user, true
+}
+
+// respondWithRoleError responds with the error of a role operation
+func (h *RoleHandler) respondWithRoleError(w http.ResponseWriter, err error, organizationID string) {
+	switch {
+	case errors.Is(err, database.ErrRecordNotFound):
+		respondWithError(w, http.StatusNotFound, "Role not found", "")
+	case errors.Is(err, repository.ErrDuplicateRole):
+		respondWithError(w, http.StatusConflict, "A role with this name already exists", "")
+	case errors.Is(err, repository.ErrRoleAlreadyAssigned):
+		respondWithError(w, http.StatusConflict, "Role is already assigned", "")
+	default:
+		h.logger.Error("Role operation failed", err, map[string]interface{}{
+			"organization_id": organizationID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to update roles", "")
+	}
+}
@@ -0,0 +1,522 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/auth"
+	"github.com/cstanislawski/qualifyd/pkg/database"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/cstanislawski/qualifyd/pkg/oidc"
+	"github.com/cstanislawski/qualifyd/pkg/quota"
+	"github.com/cstanislawski/qualifyd/pkg/repository"
+	"github.com/cstanislawski/qualifyd/pkg/saml"
+	"github.com/go-chi/chi/v5"
+)
+
+// samlSignInCodeTTL is how long the frontend has to exchange the code the
+// assertion consumer service redirects it with
+const samlSignInCodeTTL = time.Minute
+
+// SAMLProviderRequest represents the payload for configuring an
+// organization's SAML identity provider. Metadata is the provider's metadata
+// document; it may be omitted to keep the current one.
+type SAMLProviderRequest struct {
+	Metadata              string            `json:"metadata,omitempty"`
+	EmailAttribute        string            `json:"email_attribute,omitempty"`
+	FirstNameAttribute    string            `json:"first_name_attribute,omitempty"`
+	LastNameAttribute     string            `json:"last_name_attribute,omitempty"`
+	GroupsAttribute       string            `json:"groups_attribute,omitempty"`
+	AllowedDomains        []string          `json:"allowed_domains"`
+	RoleMappings          map[string]string `json:"role_mappings"`
+	DefaultRole           string            `json:"default_role,omitempty"`
+	Enabled               *bool             `json:"enabled,omitempty"`
+	PasswordLoginDisabled bool              `json:"password_login_disabled"`
+}
+
+// SAMLProviderResponse is an organization's SAML identity provider, with the
+// service provider details its admins configure the identity provider with
+type SAMLProviderResponse struct {
+	*model.SAMLProvider
+	SPEntityID    string `json:"sp_entity_id"`
+	SPACSURL      string `json:"sp_acs_url"`
+	SPMetadataURL string `json:"sp_metadata_url"`
+}
+
+// SAMLExchangeRequest represents the payload for exchanging the code the
+// assertion consumer service redirected the browser with
+type SAMLExchangeRequest struct {
+	Code string `json:"code"`
+}
+
+// HandleSAMLMetadata handles serving the service provider metadata an
+// organization gives its SAML identity provider
+func (h *SSOHandler) HandleSAMLMetadata(w http.ResponseWriter, r *http.Request) {
+	sp := h.serviceProvider(chi.URLParam(r, "org_id"))
+
+	metadata, err := sp.Metadata()
+	if err != nil {
+		h.logger.Error("Failed to generate SAML metadata", err, nil)
+		respondWithError(w, http.StatusInternalServerError, "Failed to generate metadata", "")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	w.WriteHeader(http.StatusOK)
+	w.Write(metadata)
+}
+
+// HandleStartSAMLLogin handles starting a sign-in at an organization's SAML
+// identity provider. The authentication request ID stays on the server, and
+// the response must answer it.
+func (h *SSOHandler) HandleStartSAMLLogin(w http.ResponseWriter, r *http.Request) {
+	var req StartOIDCLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.logger.Error("Failed to decode start SAML login request", err, nil)
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload", err.Error())
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	if email == "" && req.OrganizationID == "" {
+		respondWithError(w, http.StatusBadRequest, "Email or organization is required", "")
+		return
+	}
+
+	provider, ok := h.findSAMLProvider(w, r, email, req.OrganizationID)
+	if !ok {
+		return
+	}
+	idp, err := saml.ParseMetadata([]byte(provider.Metadata))
+	if err != nil {
+		h.logger.Error("Stored SAML metadata is invalid", err, map[string]interface{}{
+			"organization_id": provider.OrganizationID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to start sign-in", "")
+		return
+	}
+
+	state, err := oidc.GenerateNonce()
+	if err != nil {
+		h.logger.Error("Failed to generate SSO state", err, nil)
+		respondWithError(w, http.StatusInternalServerError, "Failed to start sign-in", "")
+		return
+	}
+	requestID, err := saml.NewRequestID()
+	if err != nil {
+		h.logger.Error("Failed to generate SAML request ID", err, nil)
+		respondWithError(w, http.StatusInternalServerError, "Failed to start sign-in", "")
+		return
+	}
+
+	sp := h.serviceProvider(provider.OrganizationID)
+	authURL, err := sp.AuthnRequestURL(idp, requestID, state)
+	if err != nil {
+		h.logger.Error("Failed to create SAML authentication request", err, map[string]interface{}{
+			"organization_id": provider.OrganizationID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to start sign-in", "")
+		return
+	}
+
+	err = h.ssoRepo.CreateLoginState(r.Context(), &model.SSOLoginState{
+		StateHash:      auth.HashToken(state),
+		OrganizationID: provider.OrganizationID,
+		Protocol:       model.SSOProtocolSAML,
+		Nonce:          requestID,
+		ExpiresAt:      time.Now().UTC().Add(h.cfg.StateTTL),
+	})
+	if err != nil {
+		h.logger.Error("Failed to create SSO login state", err, map[string]interface{}{
+			"organization_id": provider.OrganizationID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to start sign-in", "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, StartOIDCLoginResponse{AuthorizationURL: authURL})
+}
+
+// HandleSAMLACS handles the assertion consumer service, which the identity
+// provider posts its response to through the user's browser. The verified
+// user is provisioned on their first sign-in, and the browser is sent to the
+// frontend with a one-time code it exchanges for tokens. Only sign-ins
+// started with HandleStartSAMLLogin are accepted.
+func (h *SSOHandler) HandleSAMLACS(w http.ResponseWriter, r *http.Request) {
+	organizationID := chi.URLParam(r, "org_id")
+	if err := r.ParseForm(); err != nil {
+		h.redirectWithSSOError(w, r, "Invalid sign-in response")
+		return
+	}
+	relayState := r.PostForm.Get("RelayState")
+	if relayState == "" || r.PostForm.Get("SAMLResponse") == "" {
+		h.redirectWithSSOError(w, r, "Sign-in must be started from Qualifyd")
+		return
+	}
+
+	state, err := h.ssoRepo.ConsumeLoginState(r.Context(), auth.HashToken(relayState), model.SSOProtocolSAML)
+	if err != nil {
+		if !errors.Is(err, repository.ErrSSOStateInvalid) {
+			h.logger.Error("Failed to consume SSO login state", err, nil)
+		}
+		h.redirectWithSSOError(w, r, "Sign-in expired, please try again")
+		return
+	}
+	if state.OrganizationID != organizationID {
+		h.redirectWithSSOError(w, r, "Sign-in expired, please try again")
+		return
+	}
+
+	provider, err := h.ssoRepo.GetSAMLProvider(r.Context(), organizationID)
+	if err != nil && !errors.Is(err, database.ErrRecordNotFound) {
+		h.logger.Error("Failed to get SAML provider", err, map[string]interface{}{
+			"organization_id": organizationID,
+		})
+		h.redirectWithSSOError(w, r, "Failed to sign in")
+		return
+	}
+	if provider == nil || !provider.Enabled {
+		h.redirectWithSSOError(w, r, "Single sign-on is not enabled for this organization")
+		return
+	}
+	idp, err := saml.ParseMetadata([]byte(provider.Metadata))
+	if err != nil {
+		h.logger.Error("Stored SAML metadata is invalid", err, map[string]interface{}{
+			"organization_id": organizationID,
+		})
+		h.redirectWithSSOError(w, r, "Failed to sign in")
+		return
+	}
+
+	sp := h.serviceProvider(organizationID)
+	assertion, err := sp.ParseResponse(idp, r.PostForm.Get("SAMLResponse"), state.Nonce, time.Now())
+	if err != nil {
+		h.logger.Warn("SAML sign-in failed", map[string]interface{}{
+			"organization_id": organizationID,
+			"error":           err.Error(),
+		})
+		if errors.Is(err, saml.ErrAuthnFailed) {
+			h.redirectWithSSOError(w, r, "Sign-in was not completed at the identity provider")
+			return
+		}
+		h.redirectWithSSOError(w, r, "Identity provider returned an invalid identity")
+		return
+	}
+
+	identity := samlIdentity(provider, assertion)
+	if identity.Email == "" {
+		h.redirectWithSSOError(w, r, "Identity provider did not send an email address")
+		return
+	}
+	if !provider.AllowsEmail(identity.Email) {
+		h.redirectWithSSOError(w, r, "Email domain is not allowed for this organization")
+		return
+	}
+
+	var groups []string
+	if provider.GroupsAttribute != "" {
+		groups = assertion.Attributes[provider.GroupsAttribute]
+	}
+	user, err := h.provision(r.Context(), organizationID, identity, provider.RoleFor(groups), provider.DefaultRole)
+	if err != nil {
+		if _, refusal, ok := quota.NewRefusal(err); ok {
+			h.redirectWithSSOError(w, r, refusal.Message)
+			return
+		}
+		if _, message, _, ok := provisionRefusal(err); ok {
+			h.redirectWithSSOError(w, r, message)
+			return
+		}
+		h.logger.Error("Failed to provision single sign-on user", err, map[string]interface{}{
+			"organization_id": organizationID,
+			"email":           identity.Email,
+		})
+		h.redirectWithSSOError(w, r, "Failed to sign in")
+		return
+	}
+
+	code, err := oidc.GenerateNonce()
+	if err != nil {
+		h.logger.Error("Failed to generate SSO sign-in code", err, nil)
+		h.redirectWithSSOError(w, r, "Failed to sign in")
+		return
+	}
+	err = h.ssoRepo.CreateSignInCode(r.Context(), auth.HashToken(code), user.ID, time.Now().UTC().Add(samlSignInCodeTTL))
+	if err != nil {
+		h.logger.Error("Failed to create SSO sign-in code", err, map[string]interface{}{"userID": user.ID})
+		h.redirectWithSSOError(w, r, "Failed to sign in")
+		return
+	}
+
+	h.redirectToFrontend(w, r, url.Values{"sign_in_code": {code}})
+}
+
+// HandleSAMLExchange handles exchanging the one-time code the assertion
+// consumer service redirected the browser with for tokens
+func (h *SSOHandler) HandleSAMLExchange(w http.ResponseWriter, r *http.Request) {
+	var req SAMLExchangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.logger.Error("Failed to decode SAML exchange request", err, nil)
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload", err.Error())
+		return
+	}
+	if req.Code == "" {
+		respondWithError(w, http.StatusBadRequest, "Code is required", "")
+		return
+	}
+
+	userID, err := h.ssoRepo.ConsumeSignInCode(r.Context(), auth.HashToken(req.Code))
+	if err != nil {
+		if errors.Is(err, repository.ErrSSOCodeInvalid) {
+			respondWithError(w, http.StatusBadRequest, "Sign-in expired, please try again", "")
+			return
+		}
+		h.logger.Error("Failed to consume SSO sign-in code", err, nil)
+		respondWithError(w, http.StatusInternalServerError, "Failed to sign in", "")
+		return
+	}
+
+	user, err := h.userRepo.GetByID(r.Context(), userID)
+	if err != nil {
+		h.logger.Error("Failed to get single sign-on user", err, map[string]interface{}{"userID": userID})
+		respondWithError(w, http.StatusInternalServerError, "Failed to sign in", "")
+		return
+	}
+	if user.Status != model.StatusActive {
+		respondWithError(w, http.StatusUnauthorized, "Account is not active", "")
+		return
+	}
+
+	h.signIn(w, r, user)
+}
+
+// HandleGetSAMLProvider handles getting an organization's SAML identity
+// provider, with the service provider details to configure it with
+func (h *SSOHandler) HandleGetSAMLProvider(w http.ResponseWriter, r *http.Request) {
+	org, ok := loadManagedOrganization(w, r, h.organizationRepo, h.logger)
+	if !ok {
+		return
+	}
+
+	provider, err := h.ssoRepo.GetSAMLProvider(r.Context(), org.ID)
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			respondWithError(w, http.StatusNotFound, "Single sign-on is not configured", "")
+			return
+		}
+		h.logger.Error("Failed to get SAML provider", err, map[string]interface{}{
+			"organization_id": org.ID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to get single sign-on configuration", "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, h.samlProviderResponse(provider))
+}
+
+// HandleSaveSAMLProvider handles configuring an organization's SAML identity
+// provider from its uploaded metadata
+func (h *SSOHandler) HandleSaveSAMLProvider(w http.ResponseWriter, r *http.Request) {
+	org, ok := loadManagedOrganization(w, r, h.organizationRepo, h.logger)
+	if !ok {
+		return
+	}
+
+	var req SAMLProviderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.logger.Error("Failed to decode SAML provider request", err, nil)
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload", err.Error())
+		return
+	}
+
+	provider := &model.SAMLProvider{
+		OrganizationID:     org.ID,
+		Metadata:           strings.TrimSpace(req.Metadata),
+		EmailAttribute:     strings.TrimSpace(req.EmailAttribute),
+		FirstNameAttribute: strings.TrimSpace(req.FirstNameAttribute),
+		LastNameAttribute:  strings.TrimSpace(req.LastNameAttribute),
+		GroupsAttribute:    strings.TrimSpace(req.GroupsAttribute),
+		SSOPolicy: model.SSOPolicy{
+			AllowedDomains:        req.AllowedDomains,
+			RoleMappings:          req.RoleMappings,
+			DefaultRole:           req.DefaultRole,
+			Enabled:               req.Enabled == nil || *req.Enabled,
+			PasswordLoginDisabled: req.PasswordLoginDisabled,
+		},
+	}
+
+	current, err := h.ssoRepo.GetSAMLProvider(r.Context(), org.ID)
+	if err != nil && !errors.Is(err, database.ErrRecordNotFound) {
+		h.logger.Error("Failed to get SAML provider", err, map[string]interface{}{
+			"organization_id": org.ID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to save single sign-on configuration", "")
+		return
+	}
+	if provider.Metadata == "" && current != nil {
+		provider.Metadata = current.Metadata
+	}
+
+	if validationErrors := provider.Validate(); len(validationErrors) > 0 {
+		respondWithJSON(w, http.StatusBadRequest, map[string]interface{}{
+			"error":  "Invalid single sign-on configuration",
+			"fields": validationErrors,
+		})
+		return
+	}
+
+	idp, err := saml.ParseMetadata([]byte(provider.Metadata))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid identity provider metadata", err.Error())
+		return
+	}
+	provider.IdPEntityID = idp.EntityID
+	provider.IdPSSOURL = idp.SSOURL
+
+	if err := h.ssoRepo.SaveSAMLProvider(r.Context(), provider); err != nil {
+		if errors.Is(err, repository.ErrSSODomainClaimed) {
+			respondWithError(w, http.StatusConflict, "Email domain is used by another organization", err.Error())
+			return
+		}
+		h.logger.Error("Failed to save SAML provider", err, map[string]interface{}{
+			"organization_id": org.ID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to save single sign-on configuration", "")
+		return
+	}
+	if current != nil {
+		h.recordProviderChange(r, org.ID, model.AuditTargetSAMLProvider, current, provider, &current.SSOPolicy, &provider.SSOPolicy)
+	} else {
+		h.recordProviderChange(r, org.ID, model.AuditTargetSAMLProvider, nil, provider, nil, &provider.SSOPolicy)
+	}
+
+	respondWithJSON(w, http.StatusOK, h.samlProviderResponse(provider))
+}
+
+// HandleDeleteSAMLProvider handles removing an organization's SAML identity
+// provider
+func (h *SSOHandler) HandleDeleteSAMLProvider(w http.ResponseWriter, r *http.Request) {
+	org, ok := loadManagedOrganization(w, r, h.organizationRepo, h.logger)
+	if !ok {
+		return
+	}
+
+	current, err := h.ssoRepo.GetSAMLProvider(r.Context(), org.ID)
+	if err == nil {
+		err = h.ssoRepo.DeleteSAMLProvider(r.Context(), org.ID)
+	}
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			respondWithError(w, http.StatusNotFound, "Single sign-on is not configured", "")
+			return
+		}
+		h.logger.Error("Failed to delete SAML provider", err, map[string]interface{}{
+			"organization_id": org.ID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to delete single sign-on configuration", "")
+		return
+	}
+	h.recordProviderChange(r, org.ID, model.AuditTargetSAMLProvider, current, nil, &current.SSOPolicy, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// findSAMLProvider finds the enabled provider a sign-in starts at, by
+// organization or else by email domain, and writes an error response if
+// there is none
+func (h *SSOHandler) findSAMLProvider(w http.ResponseWriter, r *http.Request, email, organizationID string) (*model.SAMLProvider, bool) {
+	if organizationID != "" {
+		provider, err := h.ssoRepo.GetSAMLProvider(r.Context(), organizationID)
+		if err != nil && !errors.Is(err, database.ErrRecordNotFound) {
+			h.logger.Error("Failed to get SAML provider", err, map[string]interface{}{
+				"organization_id": organizationID,
+			})
+			respondWithError(w, http.StatusInternalServerError, "Failed to start sign-in", "")
+			return nil, false
+		}
+		if provider == nil || !provider.Enabled || (email != "" && !provider.AllowsEmail(email)) {
+			respondWithError(w, http.StatusNotFound, "Single sign-on is not available", "")
+			return nil, false
+		}
+		return provider, true
+	}
+
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		respondWithError(w, http.StatusBadRequest, "Invalid email", "")
+		return nil, false
+	}
+	providers, err := h.ssoRepo.ListSAMLProvidersByDomain(r.Context(), email[at+1:])
+	if err != nil {
+		h.logger.Error("Failed to find SAML provider", err, nil)
+		respondWithError(w, http.StatusInternalServerError, "Failed to start sign-in", "")
+		return nil, false
+	}
+	// Only the organization that claimed the domain has a provider for it
+	if len(providers) == 0 {
+		respondWithError(w, http.StatusNotFound, "Single sign-on is not available", "")
+		return nil, false
+	}
+	return providers[0], true
+}
+
+// serviceProvider returns Qualifyd as a service provider to an
+// organization's identity provider. Each organization has its own entity ID,
+// the URL of its metadata.
+func (h *SSOHandler) serviceProvider(organizationID string) *saml.ServiceProvider {
+	base := strings.TrimRight(h.cfg.APIURL, "/") + "/api/sso/saml/" + url.PathEscape(organizationID)
+	return &saml.ServiceProvider{
+		EntityID: base + "/metadata",
+		ACSURL:   base + "/acs",
+	}
+}
+
+// samlProviderResponse adds the service provider details to a provider
+func (h *SSOHandler) samlProviderResponse(provider *model.SAMLProvider) SAMLProviderResponse {
+	sp := h.serviceProvider(provider.OrganizationID)
+	return SAMLProviderResponse{
+		SAMLProvider:  provider,
+		SPEntityID:    sp.EntityID,
+		SPACSURL:      sp.ACSURL,
+		SPMetadataURL: sp.EntityID,
+	}
+}
+
+// samlIdentity reads the user an assertion is about with the provider's
+// attribute names. The email falls back to the name ID if it is an address.
+func samlIdentity(provider *model.SAMLProvider, assertion *saml.Assertion) ssoIdentity {
+	identity := ssoIdentity{
+		FirstName: assertion.Attribute(provider.FirstNameAttribute),
+		LastName:  assertion.Attribute(provider.LastNameAttribute),
+	}
+	if provider.EmailAttribute != "" {
+		identity.Email = assertion.Attribute(provider.EmailAttribute)
+	}
+	if identity.Email == "" && strings.Contains(assertion.NameID, "@") {
+		identity.Email = assertion.NameID
+	}
+	identity.Email = strings.ToLower(strings.TrimSpace(identity.Email))
+	return identity
+}
+
+// redirectWithSSOError sends the browser back to the frontend with the
+// reason its sign-in failed
+func (h *SSOHandler) redirectWithSSOError(w http.ResponseWriter, r *http.Request, message string) {
+	h.redirectToFrontend(w, r, url.Values{"error": {message}})
+}
+
+// redirectToFrontend sends the browser to the frontend's single sign-on page
+// with query
+func (h *SSOHandler) redirectToFrontend(w http.ResponseWriter, r *http.Request, query url.Values) {
+	target := h.cfg.RedirectURL
+	if strings.Contains(target, "?") {
+		target += "&" + query.Encode()
+	} else {
+		target += "?" + query.Encode()
+	}
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
@@ -0,0 +1,167 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/cstanislawski/qualifyd/pkg/audit"
+	"github.com/cstanislawski/qualifyd/pkg/database"
+	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/middleware"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/cstanislawski/qualifyd/pkg/repository"
+	"github.com/cstanislawski/qualifyd/pkg/session"
+	"github.com/go-chi/chi/v5"
+)
+
+// SessionHandler handles listing and revoking sessions
+type SessionHandler struct {
+	sessions *session.Service
+	userRepo *repository.UserRepository
+	audit    *audit.Service
+	logger   logger.Logger
+}
+
+// NewSessionHandler creates a new SessionHandler instance
+func NewSessionHandler(sessions *session.Service, userRepo *repository.UserRepository, audit *audit.Service, logger logger.Logger) *SessionHandler {
+	return &SessionHandler{
+		sessions: sessions,
+		userRepo: userRepo,
+		audit:    audit,
+		logger:   logger,
+	}
+}
+
+// HandleListSessions handles listing the caller's active sessions. The
+// session of the request is marked as current.
+func (h *SessionHandler) HandleListSessions(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+	sessions, err := h.sessions.List(r.Context(), userID)
+	if err != nil {
+		h.logger.Error("Failed to list sessions", err, map[string]interface{}{
+			"user_id": userID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to list sessions", "")
+		return
+	}
+
+	currentID := middleware.GetSessionID(r)
+	for _, s := range sessions {
+		s.Current = s.ID == currentID
+	}
+
+	respondWithJSON(w, http.StatusOK, sessions)
+}
+
+// HandleRevokeSession handles the caller signing out one of their sessions
+func (h *SessionHandler) HandleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+	sessionID := chi.URLParam(r, "session_id")
+	if err := h.sessions.Revoke(r.Context(), userID, sessionID, model.SessionRevokedByUser); err != nil {
+		if errors.Is(err, repository.ErrSessionNotFound) {
+			respondWithError(w, http.StatusNotFound, "Session not found", "")
+			return
+		}
+		h.logger.Error("Failed to revoke session", err, map[string]interface{}{
+			"user_id":    userID,
+			"session_id": sessionID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to revoke session", "")
+		return
+	}
+	h.audit.Record(r.Context(), auditEvent(r, model.AuditSessionRevoked, model.AuditTargetSession, sessionID))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleRevokeOtherSessions handles the caller signing out all their
+// sessions but the one of the request
+func (h *SessionHandler) HandleRevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+	revoked, err := h.sessions.RevokeAll(r.Context(), userID, model.SessionRevokedByUser, middleware.GetSessionID(r))
+	if err != nil {
+		h.logger.Error("Failed to revoke sessions", err, map[string]interface{}{
+			"user_id": userID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to revoke sessions", "")
+		return
+	}
+	h.audit.Record(r.Context(), revokedSessionsEvent(r, userID, revoked))
+
+	respondWithJSON(w, http.StatusOK, map[string]int64{
+		"revoked": revoked,
+	})
+}
+
+// HandleLogout handles the caller signing out of the session of the request.
+// Its access and refresh tokens stop working right away.
+func (h *SessionHandler) HandleLogout(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+	sessionID := middleware.GetSessionID(r)
+	err := h.sessions.Revoke(r.Context(), userID, sessionID, model.SessionRevokedLogout)
+	if err != nil && !errors.Is(err, repository.ErrSessionNotFound) {
+		h.logger.Error("Failed to revoke session", err, map[string]interface{}{
+			"user_id":    userID,
+			"session_id": sessionID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to log out", "")
+		return
+	}
+	h.audit.Record(r.Context(), auditEvent(r, model.AuditLogout, model.AuditTargetSession, sessionID))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleRevokeUserSessions handles an admin signing a user of their
+// organization out of every session (admin only)
+func (h *SessionHandler) HandleRevokeUserSessions(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "user_id")
+
+	user, err := h.userRepo.GetByID(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			respondWithError(w, http.StatusNotFound, "User not found", "")
+			return
+		}
+		h.logger.Error("Failed to get user", err, map[string]interface{}{
+			"user_id": userID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to get user", "")
+		return
+	}
+
+	if !canManageOrganization(r, user.OrganizationID) {
+		respondWithError(w, http.StatusNotFound, "User not found", "")
+		return
+	}
+
+	revoked, err := h.sessions.RevokeAll(r.Context(), user.ID, model.SessionRevokedByAdmin, "")
+	if err != nil {
+		h.logger.Error("Failed to revoke sessions", err, map[string]interface{}{
+			"user_id": user.ID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to revoke sessions", "")
+		return
+	}
+
+	h.logger.Info("User signed out by admin", map[string]interface{}{
+		"user_id":    user.ID,
+		"revoked":    revoked,
+		"revoked_by": middleware.GetUserID(r),
+	})
+	e := revokedSessionsEvent(r, user.ID, revoked)
+	e.OrganizationID = user.OrganizationID
+	h.audit.Record(r.Context(), e)
+	respondWithJSON(w, http.StatusOK, map[string]int64{
+		"revoked": revoked,
+	})
+}
+
+// revokedSessionsEvent starts an audit event of signing a user out of their
+// sessions, counting how many were ended
+func revokedSessionsEvent(r *http.Request, userID string, revoked int64) *model.AuditEvent {
+	e := auditEvent(r, model.AuditSessionsRevoked, model.AuditTargetUser, userID)
+	e.Diff, _ = json.Marshal(map[string]int64{"revoked": revoked})
+	return e
+}
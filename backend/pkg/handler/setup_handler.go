@@ -8,6 +8,7 @@ import (
 	"github.com/cstanislawski/qualifyd/pkg/logger"
 	"github.com/cstanislawski/qualifyd/pkg/model"
 	"github.com/cstanislawski/qualifyd/pkg/repository"
+	"github.com/cstanislawski/qualifyd/pkg/session"
 	"github.com/google/uuid"
 )
 
@@ -23,7 +24,7 @@ type InitialSetupRequest struct {
 type SetupHandler struct {
 	userRepo         *repository.UserRepository
 	organizationRepo *repository.OrganizationRepository
-	auth             *auth.Auth
+	sessions         *session.Service
 	logger           logger.Logger
 }
 
@@ -31,13 +32,13 @@ type SetupHandler struct {
 func NewSetupHandler(
 	userRepo *repository.UserRepository,
 	organizationRepo *repository.OrganizationRepository,
-	auth *auth.Auth,
+	sessions *session.Service,
 	logger logger.Logger,
 ) *SetupHandler {
 	return &SetupHandler{
 		userRepo:         userRepo,
 		organizationRepo: organizationRepo,
-		auth:             auth,
+		sessions:         sessions,
 		logger:           logger,
 	}
 }
@@ -72,12 +73,9 @@ func (h *SetupHandler) HandleInitialSetup(w http.ResponseWriter, r *http.Request
 	}
 
 	// Create organization
-	org := &model.Organization{
-		ID:               uuid.New().String(),
-		Name:             req.OrganizationName,
-		SubscriptionPlan: model.OrgPlanStarter, // Default to starter plan
-		ContactEmail:     req.AdminEmail,
-	}
+	// The operator's own organization is not on a trial
+	org := model.NewOrganization(uuid.New().String(), req.OrganizationName, req.AdminEmail)
+	org.SubscriptionStatus = model.SubscriptionStatusActive
 
 	if err := h.organizationRepo.Create(r.Context(), org); err != nil {
 		h.logger.Error("Failed to create organization", err, map[string]interface{}{
@@ -95,14 +93,15 @@ func (h *SetupHandler) HandleInitialSetup(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	// Create admin user
+	// Create admin user. The first user operates the platform and becomes a
+	// super admin who can manage every organization.
 	adminUser := &model.User{
 		ID:             uuid.New().String(),
 		Email:          req.AdminEmail,
 		FirstName:      req.AdminName,
 		LastName:       "", // Could be split from AdminName if needed
 		PasswordHash:   hashedPassword,
-		Role:           model.RoleAdmin,
+		Role:           model.RoleSuperAdmin,
 		Status:         model.StatusActive,
 		OrganizationID: org.ID,
 	}
@@ -115,10 +114,18 @@ func (h *SetupHandler) HandleInitialSetup(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	// Generate tokens for immediate login
-	accessToken, refreshToken, err := h.auth.GenerateTokens(adminUser)
+	if err := h.organizationRepo.SetOwner(r.Context(), org.ID, adminUser.ID); err != nil {
+		h.logger.Error("Failed to set organization owner", err, map[string]interface{}{
+			"organization_id": org.ID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to create admin user", "")
+		return
+	}
+
+	// Start a session for immediate login
+	tokens, err := h.sessions.Start(r.Context(), adminUser, clientOf(r))
 	if err != nil {
-		h.logger.Error("Failed to generate tokens", err, map[string]interface{}{
+		h.logger.Error("Failed to start session", err, map[string]interface{}{
 			"user_id": adminUser.ID,
 		})
 		respondWithError(w, http.StatusInternalServerError, "Failed to generate authentication tokens", "")
@@ -127,8 +134,8 @@ func (h *SetupHandler) HandleInitialSetup(w http.ResponseWriter, r *http.Request
 
 	// Return success response with tokens
 	respondWithJSON(w, http.StatusCreated, AuthResponse{
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
 		User:         *adminUser,
 	})
 }
@@ -0,0 +1,611 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/audit"
+	"github.com/cstanislawski/qualifyd/pkg/auth"
+	"github.com/cstanislawski/qualifyd/pkg/config"
+	"github.com/cstanislawski/qualifyd/pkg/database"
+	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/mfa"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/cstanislawski/qualifyd/pkg/oidc"
+	"github.com/cstanislawski/qualifyd/pkg/quota"
+	"github.com/cstanislawski/qualifyd/pkg/repository"
+	"github.com/cstanislawski/qualifyd/pkg/session"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Reasons a single sign-on user is refused
+var (
+	errSSOOtherOrganization = errors.New("account belongs to another organization")
+	errSSORoleNotAllowed    = errors.New("account cannot sign in with single sign-on")
+	errSSONoRole            = errors.New("no role is mapped to the user's groups")
+	errSSOInactive          = errors.New("account is not active")
+)
+
+// StartOIDCLoginRequest represents the payload for starting a sign-in at an
+// organization's identity provider, with OpenID Connect or SAML. The
+// organization is found by the email domain unless it is given.
+type StartOIDCLoginRequest struct {
+	Email          string `json:"email"`
+	OrganizationID string `json:"organization_id"`
+}
+
+// StartOIDCLoginResponse carries the URL the user is sent to to sign in
+type StartOIDCLoginResponse struct {
+	AuthorizationURL string `json:"authorization_url"`
+}
+
+// OIDCCallbackRequest represents the code and state the identity provider
+// sent the user back with
+type OIDCCallbackRequest struct {
+	Code  string `json:"code"`
+	State string `json:"state"`
+}
+
+// OIDCProviderRequest represents the payload for configuring an
+// organization's OpenID Connect provider. The client secret may be omitted
+// to keep the current one.
+type OIDCProviderRequest struct {
+	Issuer                string            `json:"issuer"`
+	ClientID              string            `json:"client_id"`
+	ClientSecret          string            `json:"client_secret,omitempty"`
+	AllowedDomains        []string          `json:"allowed_domains"`
+	GroupsClaim           string            `json:"groups_claim,omitempty"`
+	RoleMappings          map[string]string `json:"role_mappings"`
+	DefaultRole           string            `json:"default_role,omitempty"`
+	Enabled               *bool             `json:"enabled,omitempty"`
+	PasswordLoginDisabled bool              `json:"password_login_disabled"`
+}
+
+// ssoIdentity is a user as asserted by an identity provider
+type ssoIdentity struct {
+	Email     string
+	FirstName string
+	LastName  string
+}
+
+// SSOHandler handles single sign-on through organizations' identity
+// providers, and their configuration
+type SSOHandler struct {
+	ssoRepo          *repository.SSORepository
+	organizationRepo *repository.OrganizationRepository
+	userRepo         *repository.UserRepository
+	quotas           *quota.Service
+	sessions         *session.Service
+	mfa              mfaChallenges
+	audit            *audit.Service
+	oidc             *oidc.Client
+	cfg              *config.SSOConfig
+	logger           logger.Logger
+}
+
+// NewSSOHandler creates a new SSOHandler instance
+func NewSSOHandler(
+	ssoRepo *repository.SSORepository,
+	organizationRepo *repository.OrganizationRepository,
+	userRepo *repository.UserRepository,
+	quotas *quota.Service,
+	sessions *session.Service,
+	mfa *mfa.Service,
+	audit *audit.Service,
+	oidcClient *oidc.Client,
+	cfg *config.SSOConfig,
+	logger logger.Logger,
+) *SSOHandler {
+	return &SSOHandler{
+		ssoRepo:          ssoRepo,
+		organizationRepo: organizationRepo,
+		userRepo:         userRepo,
+		quotas:           quotas,
+		sessions:         sessions,
+		mfa:              mfa,
+		audit:            audit,
+		oidc:             oidcClient,
+		cfg:              cfg,
+		logger:           logger,
+	}
+}
+
+// HandleStartOIDCLogin handles starting a sign-in at an organization's
+// OpenID Connect provider with the authorization code flow and PKCE. The
+// code verifier and nonce stay on the server until the provider sends the
+// user back.
+func (h *SSOHandler) HandleStartOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	var req StartOIDCLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.logger.Error("Failed to decode start OIDC login request", err, nil)
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload", err.Error())
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	if email == "" && req.OrganizationID == "" {
+		respondWithError(w, http.StatusBadRequest, "Email or organization is required", "")
+		return
+	}
+
+	provider, ok := h.findOIDCProvider(w, r, email, req.OrganizationID)
+	if !ok {
+		return
+	}
+
+	state, err := oidc.GenerateNonce()
+	if err != nil {
+		h.logger.Error("Failed to generate SSO state", err, nil)
+		respondWithError(w, http.StatusInternalServerError, "Failed to start sign-in", "")
+		return
+	}
+	nonce, err := oidc.GenerateNonce()
+	if err != nil {
+		h.logger.Error("Failed to generate SSO nonce", err, nil)
+		respondWithError(w, http.StatusInternalServerError, "Failed to start sign-in", "")
+		return
+	}
+	verifier := oidc.GenerateVerifier()
+
+	authURL, err := h.oidc.AuthCodeURL(r.Context(), h.oidcConfig(provider), state, nonce, verifier)
+	if err != nil {
+		h.logger.Error("Failed to reach OIDC provider", err, map[string]interface{}{
+			"organization_id": provider.OrganizationID,
+			"issuer":          provider.Issuer,
+		})
+		respondWithError(w, http.StatusBadGateway, "Identity provider is unavailable", "")
+		return
+	}
+
+	err = h.ssoRepo.CreateLoginState(r.Context(), &model.SSOLoginState{
+		StateHash:      auth.HashToken(state),
+		OrganizationID: provider.OrganizationID,
+		Protocol:       model.SSOProtocolOIDC,
+		CodeVerifier:   verifier,
+		Nonce:          nonce,
+		ExpiresAt:      time.Now().UTC().Add(h.cfg.StateTTL),
+	})
+	if err != nil {
+		h.logger.Error("Failed to create SSO login state", err, map[string]interface{}{
+			"organization_id": provider.OrganizationID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to start sign-in", "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, StartOIDCLoginResponse{AuthorizationURL: authURL})
+}
+
+// HandleOIDCCallback handles the user coming back from an organization's
+// OpenID Connect provider. The authorization code is exchanged for an ID
+// token, and its user is signed in, and provisioned on their first sign-in.
+func (h *SSOHandler) HandleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	var req OIDCCallbackRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.logger.Error("Failed to decode OIDC callback request", err, nil)
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload", err.Error())
+		return
+	}
+	if req.Code == "" || req.State == "" {
+		respondWithError(w, http.StatusBadRequest, "Code and state are required", "")
+		return
+	}
+
+	state, err := h.ssoRepo.ConsumeLoginState(r.Context(), auth.HashToken(req.State), model.SSOProtocolOIDC)
+	if err != nil {
+		if errors.Is(err, repository.ErrSSOStateInvalid) {
+			respondWithError(w, http.StatusBadRequest, "Sign-in expired, please try again", "")
+			return
+		}
+		h.logger.Error("Failed to consume SSO login state", err, nil)
+		respondWithError(w, http.StatusInternalServerError, "Failed to sign in", "")
+		return
+	}
+
+	provider, err := h.ssoRepo.GetOIDCProvider(r.Context(), state.OrganizationID)
+	if err != nil && !errors.Is(err, database.ErrRecordNotFound) {
+		h.logger.Error("Failed to get OIDC provider", err, map[string]interface{}{
+			"organization_id": state.OrganizationID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to sign in", "")
+		return
+	}
+	if provider == nil || !provider.Enabled {
+		respondWithError(w, http.StatusNotFound, "Single sign-on is not enabled for this organization", "")
+		return
+	}
+
+	identity, err := h.oidc.Exchange(r.Context(), h.oidcConfig(provider), req.Code, state.CodeVerifier, state.Nonce)
+	if err != nil {
+		h.logger.Warn("OIDC sign-in failed", map[string]interface{}{
+			"organization_id": provider.OrganizationID,
+			"error":           err.Error(),
+		})
+		if errors.Is(err, oidc.ErrInvalidIDToken) || errors.Is(err, oidc.ErrNonceMismatch) {
+			respondWithError(w, http.StatusUnauthorized, "Identity provider returned an invalid identity", "")
+			return
+		}
+		respondWithError(w, http.StatusBadGateway, "Failed to complete sign-in with the identity provider", "")
+		return
+	}
+
+	if !provider.AllowsEmail(identity.Email) {
+		respondWithError(w, http.StatusForbidden, "Email domain is not allowed for this organization", "")
+		return
+	}
+
+	user, err := h.provision(r.Context(), provider.OrganizationID, ssoIdentity{
+		Email:     identity.Email,
+		FirstName: identity.FirstName,
+		LastName:  identity.LastName,
+	}, provider.RoleFor(identity.Groups), provider.DefaultRole)
+	if err != nil {
+		h.respondWithProvisionError(w, err, provider.OrganizationID, identity.Email)
+		return
+	}
+
+	h.signIn(w, r, user)
+}
+
+// HandleGetOIDCProvider handles getting an organization's OpenID Connect
+// provider. The client secret is not returned.
+func (h *SSOHandler) HandleGetOIDCProvider(w http.ResponseWriter, r *http.Request) {
+	org, ok := loadManagedOrganization(w, r, h.organizationRepo, h.logger)
+	if !ok {
+		return
+	}
+
+	provider, err := h.ssoRepo.GetOIDCProvider(r.Context(), org.ID)
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			respondWithError(w, http.StatusNotFound, "Single sign-on is not configured", "")
+			return
+		}
+		h.logger.Error("Failed to get OIDC provider", err, map[string]interface{}{
+			"organization_id": org.ID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to get single sign-on configuration", "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, provider)
+}
+
+// HandleSaveOIDCProvider handles configuring an organization's OpenID
+// Connect provider. The issuer must be reachable, so that disabling password
+// login cannot lock the organization out with a mistyped issuer.
+func (h *SSOHandler) HandleSaveOIDCProvider(w http.ResponseWriter, r *http.Request) {
+	org, ok := loadManagedOrganization(w, r, h.organizationRepo, h.logger)
+	if !ok {
+		return
+	}
+
+	var req OIDCProviderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.logger.Error("Failed to decode OIDC provider request", err, nil)
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload", err.Error())
+		return
+	}
+
+	provider := &model.OIDCProvider{
+		OrganizationID: org.ID,
+		Issuer:         strings.TrimRight(strings.TrimSpace(req.Issuer), "/"),
+		ClientID:       strings.TrimSpace(req.ClientID),
+		ClientSecret:   req.ClientSecret,
+		GroupsClaim:    strings.TrimSpace(req.GroupsClaim),
+		SSOPolicy: model.SSOPolicy{
+			AllowedDomains:        req.AllowedDomains,
+			RoleMappings:          req.RoleMappings,
+			DefaultRole:           req.DefaultRole,
+			Enabled:               req.Enabled == nil || *req.Enabled,
+			PasswordLoginDisabled: req.PasswordLoginDisabled,
+		},
+	}
+	if provider.GroupsClaim == "" {
+		provider.GroupsClaim = "groups"
+	}
+
+	current, err := h.ssoRepo.GetOIDCProvider(r.Context(), org.ID)
+	if err != nil && !errors.Is(err, database.ErrRecordNotFound) {
+		h.logger.Error("Failed to get OIDC provider", err, map[string]interface{}{
+			"organization_id": org.ID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to save single sign-on configuration", "")
+		return
+	}
+	if provider.ClientSecret == "" && current != nil {
+		provider.ClientSecret = current.ClientSecret
+	}
+
+	if validationErrors := provider.Validate(); len(validationErrors) > 0 {
+		respondWithJSON(w, http.StatusBadRequest, map[string]interface{}{
+			"error":  "Invalid single sign-on configuration",
+			"fields": validationErrors,
+		})
+		return
+	}
+
+	if err := h.oidc.Discover(r.Context(), provider.Issuer); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Issuer is not a reachable OpenID provider", err.Error())
+		return
+	}
+
+	if err := h.ssoRepo.SaveOIDCProvider(r.Context(), provider); err != nil {
+		if errors.Is(err, repository.ErrSSODomainClaimed) {
+			respondWithError(w, http.StatusConflict, "Email domain is used by another organization", err.Error())
+			return
+		}
+		h.logger.Error("Failed to save OIDC provider", err, map[string]interface{}{
+			"organization_id": org.ID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to save single sign-on configuration", "")
+		return
+	}
+	if current != nil {
+		h.recordProviderChange(r, org.ID, model.AuditTargetOIDCProvider, current, provider, &current.SSOPolicy, &provider.SSOPolicy)
+	} else {
+		h.recordProviderChange(r, org.ID, model.AuditTargetOIDCProvider, nil, provider, nil, &provider.SSOPolicy)
+	}
+
+	respondWithJSON(w, http.StatusOK, provider)
+}
+
+// HandleDeleteOIDCProvider handles removing an organization's OpenID Connect
+// provider. Its users sign in with passwords again.
+func (h *SSOHandler) HandleDeleteOIDCProvider(w http.ResponseWriter, r *http.Request) {
+	org, ok := loadManagedOrganization(w, r, h.organizationRepo, h.logger)
+	if !ok {
+		return
+	}
+
+	current, err := h.ssoRepo.GetOIDCProvider(r.Context(), org.ID)
+	if err == nil {
+		err = h.ssoRepo.DeleteOIDCProvider(r.Context(), org.ID)
+	}
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			respondWithError(w, http.StatusNotFound, "Single sign-on is not configured", "")
+			return
+		}
+		h.logger.Error("Failed to delete OIDC provider", err, map[string]interface{}{
+			"organization_id": org.ID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to delete single sign-on configuration", "")
+		return
+	}
+	h.recordProviderChange(r, org.ID, model.AuditTargetOIDCProvider, current, nil, &current.SSOPolicy, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// findOIDCProvider finds the enabled provider a sign-in starts at, by
+// organization or else by email domain, and writes an error response if
+// there is none
+func (h *SSOHandler) findOIDCProvider(w http.ResponseWriter, r *http.Request, email, organizationID string) (*model.OIDCProvider, bool) {
+	if organizationID != "" {
+		provider, err := h.ssoRepo.GetOIDCProvider(r.Context(), organizationID)
+		if err != nil && !errors.Is(err, database.ErrRecordNotFound) {
+			h.logger.Error("Failed to get OIDC provider", err, map[string]interface{}{
+				"organization_id": organizationID,
+			})
+			respondWithError(w, http.StatusInternalServerError, "Failed to start sign-in", "")
+			return nil, false
+		}
+		if provider == nil || !provider.Enabled || (email != "" && !provider.AllowsEmail(email)) {
+			respondWithError(w, http.StatusNotFound, "Single sign-on is not available", "")
+			return nil, false
+		}
+		return provider, true
+	}
+
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		respondWithError(w, http.StatusBadRequest, "Invalid email", "")
+		return nil, false
+	}
+	providers, err := h.ssoRepo.ListOIDCProvidersByDomain(r.Context(), email[at+1:])
+	if err != nil {
+		h.logger.Error("Failed to find OIDC provider", err, nil)
+		respondWithError(w, http.StatusInternalServerError, "Failed to start sign-in", "")
+		return nil, false
+	}
+	// Only the organization that claimed the domain has a provider for it
+	if len(providers) == 0 {
+		respondWithError(w, http.StatusNotFound, "Single sign-on is not available", "")
+		return nil, false
+	}
+	return providers[0], true
+}
+
+// oidcConfig returns the relying party configuration of a provider
+func (h *SSOHandler) oidcConfig(provider *model.OIDCProvider) oidc.Config {
+	return oidc.Config{
+		Issuer:       provider.Issuer,
+		ClientID:     provider.ClientID,
+		ClientSecret: provider.ClientSecret,
+		RedirectURL:  h.cfg.RedirectURL,
+		GroupsClaim:  provider.GroupsClaim,
+	}
+}
+
+// provision returns the user of organizationID an identity provider signed
+// in, creating them on their first sign-in. Users are given mappedRole, the
+// role their provider groups map to, and new users without one defaultRole;
+// the organization's owner keeps their role. Invited users are activated.
+func (h *SSOHandler) provision(ctx context.Context, organizationID string, identity ssoIdentity, mappedRole, defaultRole string) (*model.User, error) {
+	user, err := h.userRepo.GetByEmail(ctx, identity.Email)
+	if err != nil && !errors.Is(err, database.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if user == nil {
+		role := mappedRole
+		if role == "" {
+			role = defaultRole
+		}
+		if role == "" {
+			return nil, errSSONoRole
+		}
+
+		firstName := identity.FirstName
+		if firstName == "" {
+			firstName = identity.Email[:strings.LastIndex(identity.Email, "@")]
+		}
+		user = &model.User{
+			ID:             uuid.New().String(),
+			Email:          identity.Email,
+			FirstName:      firstName,
+			LastName:       identity.LastName,
+			Role:           role,
+			Status:         model.StatusActive,
+			OrganizationID: organizationID,
+		}
+		err := h.quotas.Reserve(ctx, organizationID, quota.ResourceUsers, func(tx pgx.Tx) error {
+			return h.userRepo.WithTx(tx).Create(ctx, user)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		h.logger.Info("User provisioned by single sign-on", map[string]interface{}{
+			"user_id":         user.ID,
+			"organization_id": organizationID,
+			"role":            role,
+		})
+		return user, nil
+	}
+
+	if user.OrganizationID != organizationID {
+		return nil, errSSOOtherOrganization
+	}
+	if !model.IsSSORole(user.Role) {
+		return nil, errSSORoleNotAllowed
+	}
+	if user.Status == model.StatusInactive {
+		return nil, errSSOInactive
+	}
+
+	if user.Status == model.StatusPending {
+		if err := h.userRepo.ActivateUser(ctx, user.ID); err != nil {
+			return nil, err
+		}
+		user.Status = model.StatusActive
+		user.InvitationToken = nil
+		user.InvitationExpiresAt = nil
+	}
+
+	if mappedRole != "" && mappedRole != user.Role {
+		org, err := h.organizationRepo.GetByID(ctx, organizationID)
+		if err != nil {
+			return nil, err
+		}
+		if !org.IsOwnedBy(user.ID) {
+			user.Role = mappedRole
+			if err := h.userRepo.Update(ctx, user); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return user, nil
+}
+
+// respondWithProvisionError writes the response for a user who could not be
+// provisioned
+func (h *SSOHandler) respondWithProvisionError(w http.ResponseWriter, err error, organizationID, email string) {
+	if respondWithQuotaError(w, err) {
+		return
+	}
+	if status, message, details, ok := provisionRefusal(err); ok {
+		respondWithError(w, status, message, details)
+		return
+	}
+
+	h.logger.Error("Failed to provision single sign-on user", err, map[string]interface{}{
+		"organization_id": organizationID,
+		"email":           email,
+	})
+	respondWithError(w, http.StatusInternalServerError, "Failed to sign in", "")
+}
+
+// provisionRefusal returns the response for a user who was refused by
+// provision. It returns false for other errors.
+func provisionRefusal(err error) (status int, message, details string, ok bool) {
+	switch {
+	case errors.Is(err, errSSOOtherOrganization), errors.Is(err, errSSORoleNotAllowed):
+		return http.StatusForbidden, "This account cannot sign in with your organization's identity provider", "", true
+	case errors.Is(err, errSSONoRole):
+		return http.StatusForbidden, "You have not been given access to Qualifyd", "Ask your administrator to add you to a mapped group", true
+	case errors.Is(err, errSSOInactive):
+		return http.StatusUnauthorized, "Account is not active", "", true
+	default:
+		return 0, "", "", false
+	}
+}
+
+// recordProviderChange records a change of an organization's identity
+// provider of targetType, with the diff of before and after; either is nil
+// for providers created or deleted. A change of whether the provider's
+// policy lets the organization's users sign in with passwords is recorded as
+// an event of its own.
+func (h *SSOHandler) recordProviderChange(r *http.Request, organizationID, targetType string, before, after interface{}, beforePolicy, afterPolicy *model.SSOPolicy) {
+	action := model.AuditSSOProviderUpdated
+	switch {
+	case beforePolicy == nil:
+		action = model.AuditSSOProviderCreated
+	case afterPolicy == nil:
+		action = model.AuditSSOProviderDeleted
+	}
+	e := auditEvent(r, action, targetType, organizationID)
+	e.OrganizationID = organizationID
+	h.audit.RecordChange(r.Context(), e, before, after)
+
+	wasDisabled := beforePolicy != nil && beforePolicy.PasswordLoginDisabled
+	isDisabled := afterPolicy != nil && afterPolicy.PasswordLoginDisabled
+	if wasDisabled == isDisabled {
+		return
+	}
+	action = model.AuditPasswordLoginEnabled
+	if isDisabled {
+		action = model.AuditPasswordLoginDisabled
+	}
+	e = auditEvent(r, action, model.AuditTargetOrganization, organizationID)
+	e.OrganizationID = organizationID
+	h.audit.Record(r.Context(), e)
+}
+
+// signIn starts a session for a user an identity provider signed in. The
+// identity provider's sign-in counts as the password check: users who must
+// give a second factor are challenged for it as when signing in with a
+// password, and complete the sign-in with the MFA challenge routes.
+func (h *SSOHandler) signIn(w http.ResponseWriter, r *http.Request, user *model.User) {
+	if challengeMFA(w, r, h.mfa, user, h.logger) {
+		return
+	}
+
+	if err := h.userRepo.UpdateLastLogin(r.Context(), user.ID); err != nil {
+		h.logger.Error("Failed to update last login time", err, map[string]interface{}{"userID": user.ID})
+	}
+
+	tokens, err := h.sessions.Start(r.Context(), user, clientOf(r))
+	if err != nil {
+		h.logger.Error("Failed to start session", err, map[string]interface{}{"userID": user.ID})
+		respondWithError(w, http.StatusInternalServerError, "Failed to generate authentication tokens", "")
+		return
+	}
+	h.audit.Record(r.Context(), signInEvent(r, model.AuditLogin, user, user.Email))
+
+	// Sanitize user data before sending response
+	user.PasswordHash = ""
+
+	respondWithJSON(w, http.StatusOK, AuthResponse{
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		User:         *user,
+	})
+}
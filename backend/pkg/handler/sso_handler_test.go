@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/mfa"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/rs/zerolog"
+)
+
+// fakeMFA requires a second factor of every user, enrolled or not
+type fakeMFA struct {
+	enrolled bool
+	started  []string
+}
+
+func (f *fakeMFA) Check(ctx context.Context, user *model.User) (bool, bool, error) {
+	return true, f.enrolled, nil
+}
+
+func (f *fakeMFA) StartChallenge(ctx context.Context, user *model.User, enrollmentRequired bool) (*mfa.Challenge, error) {
+	f.started = append(f.started, user.ID)
+	return &mfa.Challenge{
+		Token:              "mfa-token",
+		ExpiresAt:          time.Now().Add(time.Minute),
+		EnrollmentRequired: enrollmentRequired,
+	}, nil
+}
+
+func TestSSOSignInChallengesMFA(t *testing.T) {
+	for _, enrolled := range []bool{true, false} {
+		challenges := &fakeMFA{enrolled: enrolled}
+		// No session can be started without the user and session services
+		h := &SSOHandler{mfa: challenges, logger: logger.NewLogger(zerolog.Nop())}
+		user := &model.User{ID: "user-1", Email: "ada@example.com", Role: model.RoleAdmin, Status: model.StatusActive}
+
+		rec := httptest.NewRecorder()
+		h.signIn(rec, httptest.NewRequest(http.MethodPost, "/api/sso/oidc/callback", nil), user)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("enrolled %v: status = %d, want %d", enrolled, rec.Code, http.StatusOK)
+		}
+
+		var resp MFAChallengeResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("enrolled %v: decoding response: %v", enrolled, err)
+		}
+		if !resp.MFARequired || resp.MFAToken != "mfa-token" || resp.EnrollmentRequired == enrolled {
+			t.Errorf("enrolled %v: response = %+v, want a challenge", enrolled, resp)
+		}
+		if len(challenges.started) != 1 || challenges.started[0] != user.ID {
+			t.Errorf("enrolled %v: started challenges for %v, want [%s]", enrolled, challenges.started, user.ID)
+		}
+	}
+}
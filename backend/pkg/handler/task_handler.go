@@ -4,30 +4,43 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"github.com/cstanislawski/qualifyd/pkg/audit"
 	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/middleware"
 	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/cstanislawski/qualifyd/pkg/quota"
 	"github.com/cstanislawski/qualifyd/pkg/repository"
 	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
 )
 
 // TaskHandler handles HTTP requests for task templates
 type TaskHandler struct {
 	taskRepo *repository.TaskRepository
+	quotas   *quota.Service
+	audit    *audit.Service
 	logger   logger.Logger
 }
 
 // NewTaskHandler creates a new task template handler
-func NewTaskHandler(taskRepo *repository.TaskRepository, logger logger.Logger) *TaskHandler {
+func NewTaskHandler(taskRepo *repository.TaskRepository, quotas *quota.Service, audit *audit.Service, logger logger.Logger) *TaskHandler {
 	return &TaskHandler{
 		taskRepo: taskRepo,
+		quotas:   quotas,
+		audit:    audit,
 		logger:   logger,
 	}
 }
 
-// CreateTaskTemplate creates a new task template
+// CreateTaskTemplate creates a new task template in the caller's organization
 func (h *TaskHandler) CreateTaskTemplate(w http.ResponseWriter, r *http.Request) {
+	taskRepo, err := h.taskRepo.ForTenant(r.Context())
+	if err != nil {
+		http.Error(w, "Organization membership required", http.StatusForbidden)
+		return
+	}
+
 	var request struct {
-		OrganizationID         string `json:"organization_id"`
 		Name                   string `json:"name"`
 		Description            string `json:"description"`
 		Instructions           string `json:"instructions"`
@@ -45,17 +58,17 @@ func (h *TaskHandler) CreateTaskTemplate(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Validate request
-	if request.OrganizationID == "" || request.Name == "" || request.Instructions == "" {
-		http.Error(w, "Organization ID, name, and instructions are required", http.StatusBadRequest)
+	if request.Name == "" || request.Instructions == "" {
+		http.Error(w, "Name and instructions are required", http.StatusBadRequest)
 		return
 	}
 
 	// Get user context
-	userID := r.Context().Value("userID").(string)
+	userID := middleware.GetUserID(r)
 
 	// Create task template
 	taskTemplate := model.NewTaskTemplate(
-		request.OrganizationID,
+		middleware.GetOrganizationID(r),
 		request.Name,
 		request.Instructions,
 		request.TimeLimit,
@@ -67,9 +80,20 @@ func (h *TaskHandler) CreateTaskTemplate(w http.ResponseWriter, r *http.Request)
 	taskTemplate.EnvironmentSetupScript = request.EnvironmentSetupScript
 	taskTemplate.CreatedBy = userID
 
-	if err := h.taskRepo.Create(r.Context(), taskTemplate); err != nil {
+	err = h.quotas.Reserve(r.Context(), taskTemplate.OrganizationID, quota.ResourceTaskTemplates, func(tx pgx.Tx) error {
+		if err := taskRepo.WithTx(tx).Create(r.Context(), taskTemplate); err != nil {
+			return err
+		}
+		e := auditEvent(r, model.AuditTaskTemplateCreated, model.AuditTargetTaskTemplate, taskTemplate.ID)
+		e.OrganizationID = taskTemplate.OrganizationID
+		return h.audit.RecordChangeTx(r.Context(), tx, e, nil, taskTemplate)
+	})
+	if err != nil {
+		if respondWithQuotaError(w, err) {
+			return
+		}
 		h.logger.Error("Error creating task template", err, nil)
-		http.Error(w, "Error creating task template", http.StatusInternalServerError)
+		http.Error(w, "Error creating task template", repositoryErrorStatus(err))
 		return
 	}
 
@@ -86,10 +110,16 @@ func (h *TaskHandler) GetTaskTemplate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	taskTemplate, err := h.taskRepo.GetByID(r.Context(), id)
+	taskRepo, err := h.taskRepo.ForTenant(r.Context())
+	if err != nil {
+		http.Error(w, "Organization membership required", http.StatusForbidden)
+		return
+	}
+
+	taskTemplate, err := taskRepo.GetByID(r.Context(), id)
 	if err != nil {
 		h.logger.Error("Error getting task template", err, map[string]interface{}{"id": id})
-		http.Error(w, "Task template not found", http.StatusNotFound)
+		http.Error(w, "Task template not found", repositoryErrorStatus(err))
 		return
 	}
 
@@ -105,6 +135,12 @@ func (h *TaskHandler) UpdateTaskTemplate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	taskRepo, err := h.taskRepo.ForTenant(r.Context())
+	if err != nil {
+		http.Error(w, "Organization membership required", http.StatusForbidden)
+		return
+	}
+
 	var request struct {
 		Name                   string `json:"name"`
 		Description            string `json:"description"`
@@ -123,13 +159,15 @@ func (h *TaskHandler) UpdateTaskTemplate(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Get existing task template
-	taskTemplate, err := h.taskRepo.GetByID(r.Context(), id)
+	taskTemplate, err := taskRepo.GetByID(r.Context(), id)
 	if err != nil {
 		h.logger.Error("Error getting task template", err, map[string]interface{}{"id": id})
-		http.Error(w, "Task template not found", http.StatusNotFound)
+		http.Error(w, "Task template not found", repositoryErrorStatus(err))
 		return
 	}
 
+	before := *taskTemplate
+
 	// Update fields
 	if request.Name != "" {
 		taskTemplate.Name = request.Name
@@ -156,12 +194,16 @@ func (h *TaskHandler) UpdateTaskTemplate(w http.ResponseWriter, r *http.Request)
 		taskTemplate.EnvironmentSetupScript = request.EnvironmentSetupScript
 	}
 
-	if err := h.taskRepo.Update(r.Context(), taskTemplate); err != nil {
+	if err := taskRepo.Update(r.Context(), taskTemplate); err != nil {
 		h.logger.Error("Error updating task template", err, map[string]interface{}{"id": id})
-		http.Error(w, "Error updating task template", http.StatusInternalServerError)
+		http.Error(w, "Error updating task template", repositoryErrorStatus(err))
 		return
 	}
 
+	e := auditEvent(r, model.AuditTaskTemplateUpdated, model.AuditTargetTaskTemplate, taskTemplate.ID)
+	e.OrganizationID = taskTemplate.OrganizationID
+	h.audit.RecordChange(r.Context(), e, &before, taskTemplate)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(taskTemplate)
 }
@@ -174,11 +216,18 @@ func (h *TaskHandler) DeleteTaskTemplate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if err := h.taskRepo.Delete(r.Context(), id); err != nil {
+	taskRepo, err := h.taskRepo.ForTenant(r.Context())
+	if err != nil {
+		http.Error(w, "Organization membership required", http.StatusForbidden)
+		return
+	}
+
+	if err := taskRepo.Delete(r.Context(), id); err != nil {
 		h.logger.Error("Error deleting task template", err, map[string]interface{}{"id": id})
-		http.Error(w, "Error deleting task template", http.StatusInternalServerError)
+		http.Error(w, "Error deleting task template", repositoryErrorStatus(err))
 		return
 	}
+	h.audit.Record(r.Context(), auditEvent(r, model.AuditTaskTemplateDeleted, model.AuditTargetTaskTemplate, id))
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -187,18 +236,22 @@ func (h *TaskHandler) DeleteTaskTemplate(w http.ResponseWriter, r *http.Request)
 	})
 }
 
-// ListOrganizationTaskTemplates lists all task templates for an organization
+// ListOrganizationTaskTemplates lists all task templates of the caller's organization
 func (h *TaskHandler) ListOrganizationTaskTemplates(w http.ResponseWriter, r *http.Request) {
-	orgID := chi.URLParam(r, "orgId")
-	if orgID == "" {
-		http.Error(w, "Organization ID is required", http.StatusBadRequest)
+	if !requireOrganizationParam(w, r) {
+		return
+	}
+
+	taskRepo, err := h.taskRepo.ForTenant(r.Context())
+	if err != nil {
+		http.Error(w, "Organization membership required", http.StatusForbidden)
 		return
 	}
 
-	taskTemplates, err := h.taskRepo.ListByOrganization(r.Context(), orgID)
+	taskTemplates, err := taskRepo.List(r.Context())
 	if err != nil {
-		h.logger.Error("Error listing task templates", err, map[string]interface{}{"organization_id": orgID})
-		http.Error(w, "Error listing task templates", http.StatusInternalServerError)
+		h.logger.Error("Error listing task templates", err, map[string]interface{}{"organization_id": middleware.GetOrganizationID(r)})
+		http.Error(w, "Error listing task templates", repositoryErrorStatus(err))
 		return
 	}
 
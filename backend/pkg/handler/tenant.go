@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/cstanislawski/qualifyd/pkg/database"
+	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/middleware"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/cstanislawski/qualifyd/pkg/repository"
+	"github.com/go-chi/chi/v5"
+)
+
+// repositoryErrorStatus maps a tenant-scoped repository error to an HTTP status.
+// Rows owned by another organization are reported exactly like missing rows so
+// that IDs cannot be probed across tenants.
+func repositoryErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, repository.ErrTenantRequired):
+		return http.StatusForbidden
+	case errors.Is(err, repository.ErrTaskTemplateNotFound),
+		errors.Is(err, repository.ErrEnvironmentTemplateNotFound),
+		errors.Is(err, repository.ErrAssessmentTemplateNotFound),
+		errors.Is(err, repository.ErrAssessmentNotFound),
+		errors.Is(err, repository.ErrCandidateNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// requireOrganizationParam checks that the {orgId} URL parameter, when present,
+// names the caller's own organization and writes a 404 otherwise
+func requireOrganizationParam(w http.ResponseWriter, r *http.Request) bool {
+	orgID := chi.URLParam(r, "orgId")
+	if orgID != "" && orgID != middleware.GetOrganizationID(r) {
+		http.Error(w, "Organization not found", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// canManageOrganization reports whether the caller may manage the given
+// organization: admins manage their own, super admins manage every one
+func canManageOrganization(r *http.Request, organizationID string) bool {
+	if middleware.IsSuperAdmin(r) {
+		return true
+	}
+	return organizationID != "" && organizationID == middleware.GetOrganizationID(r)
+}
+
+// loadManagedOrganization loads the {org_id} organization if the caller may manage it and
+// writes a 404 otherwise, so organization IDs cannot be probed across tenants
+func loadManagedOrganization(w http.ResponseWriter, r *http.Request, organizationRepo *repository.OrganizationRepository, log logger.Logger) (*model.Organization, bool) {
+	orgID := chi.URLParam(r, "org_id")
+	if !canManageOrganization(r, orgID) {
+		respondWithError(w, http.StatusNotFound, "Organization not found", "")
+		return nil, false
+	}
+
+	org, err := organizationRepo.GetByID(r.Context(), orgID)
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			respondWithError(w, http.StatusNotFound, "Organization not found", "")
+			return nil, false
+		}
+		log.Error("Failed to get organization", err, map[string]interface{}{
+			"organization_id": orgID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to get organization", "")
+		return nil, false
+	}
+
+	return org, true
+}
@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/cstanislawski/qualifyd/internal/ws"
+	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/middleware"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/cstanislawski/qualifyd/pkg/repository"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// tenantAssessments looks up assessments of the organization bound to a
+// context; *repository.AssessmentRepository implements it
+type tenantAssessments interface {
+	GetForTenant(ctx context.Context, id string) (*model.Assessment, error)
+}
+
+// TerminalHandler handles connecting to assessment terminals
+type TerminalHandler struct {
+	assessments tenantAssessments
+	// serve connects the WebSocket to the assessment's terminal
+	serve  func(w http.ResponseWriter, r *http.Request, assessmentID string)
+	logger logger.Logger
+}
+
+// NewTerminalHandler creates a new TerminalHandler instance
+func NewTerminalHandler(assessmentRepo *repository.AssessmentRepository, hub *ws.TerminalHub, logger logger.Logger) *TerminalHandler {
+	return &TerminalHandler{
+		assessments: assessmentRepo,
+		serve: func(w http.ResponseWriter, r *http.Request, assessmentID string) {
+			ws.ServeTerminalWs(hub, w, r, assessmentID)
+		},
+		logger: logger,
+	}
+}
+
+// HandleTerminal handles opening the terminal of an assessment over a
+// WebSocket. Only the assessment's candidate and staff of its organization
+// may connect; assessments of other organizations are reported as missing.
+func (h *TerminalHandler) HandleTerminal(w http.ResponseWriter, r *http.Request) {
+	if !middleware.IsAuthenticated(r) {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized", "")
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	if _, err := uuid.Parse(id); err != nil {
+		respondWithError(w, http.StatusNotFound, "Assessment not found", "")
+		return
+	}
+
+	assessment, err := h.assessments.GetForTenant(r.Context(), id)
+	if err != nil {
+		status := repositoryErrorStatus(err)
+		if status == http.StatusInternalServerError {
+			h.logger.Error("Failed to get assessment for terminal", err, map[string]interface{}{
+				"assessment_id": id,
+			})
+		}
+		respondWithError(w, status, "Assessment not found", "")
+		return
+	}
+	if !canAccessAssessment(r, assessment) {
+		respondWithError(w, http.StatusNotFound, "Assessment not found", "")
+		return
+	}
+
+	h.serve(w, r, assessment.ID)
+}
@@ -0,0 +1,151 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/middleware"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/cstanislawski/qualifyd/pkg/repository"
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog"
+)
+
+// fakeAssessments maps organization IDs to their assessments by ID
+type fakeAssessments map[string]map[string]*model.Assessment
+
+func (f fakeAssessments) GetForTenant(ctx context.Context, id string) (*model.Assessment, error) {
+	organizationID, err := repository.TenantFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if a, ok := f[organizationID][id]; ok {
+		return a, nil
+	}
+	return nil, fmt.Errorf("%w: %s", repository.ErrAssessmentNotFound, id)
+}
+
+// withCaller authenticates r as a user of an organization, limited to one
+// assessment if scope is set
+func withCaller(r *http.Request, userID, role, organizationID, scope string) *http.Request {
+	ctx := context.WithValue(r.Context(), middleware.IsAuthenticatedKey, true)
+	ctx = context.WithValue(ctx, middleware.UserIDKey, userID)
+	ctx = context.WithValue(ctx, middleware.UserRoleKey, role)
+	ctx = context.WithValue(ctx, middleware.OrganizationIDKey, organizationID)
+	if scope != "" {
+		ctx = context.WithValue(ctx, middleware.AssessmentScopeKey, scope)
+	}
+	return r.WithContext(repository.WithTenant(ctx, organizationID))
+}
+
+func TestHandleTerminal(t *testing.T) {
+	const (
+		assessment1 = "11111111-1111-1111-1111-111111111111"
+		assessment2 = "22222222-2222-2222-2222-222222222222"
+		otherOrg    = "33333333-3333-3333-3333-333333333333"
+	)
+	assessments := fakeAssessments{
+		"org-1": {
+			assessment1: {ID: assessment1, CandidateID: "candidate-1"},
+			assessment2: {ID: assessment2, CandidateID: "candidate-2"},
+		},
+		"org-2": {
+			otherOrg: {ID: otherOrg, CandidateID: "candidate-3"},
+		},
+	}
+
+	var served []string
+	h := &TerminalHandler{
+		assessments: assessments,
+		serve: func(w http.ResponseWriter, r *http.Request, assessmentID string) {
+			served = append(served, assessmentID)
+			w.WriteHeader(http.StatusSwitchingProtocols)
+		},
+		logger: logger.NewLogger(zerolog.Nop()),
+	}
+	router := chi.NewRouter()
+	router.Get("/ws/terminal/{id}", h.HandleTerminal)
+
+	tests := []struct {
+		name         string
+		assessmentID string
+		caller       func(r *http.Request) *http.Request
+		wantStatus   int
+	}{
+		{
+			name:         "unauthenticated",
+			assessmentID: assessment1,
+			caller:       func(r *http.Request) *http.Request { return r },
+			wantStatus:   http.StatusUnauthorized,
+		},
+		{
+			name:         "candidate of the assessment",
+			assessmentID: assessment1,
+			caller: func(r *http.Request) *http.Request {
+				return withCaller(r, "candidate-1", model.RoleCandidate, "org-1", "")
+			},
+			wantStatus: http.StatusSwitchingProtocols,
+		},
+		{
+			name:         "candidate of another assessment",
+			assessmentID: assessment2,
+			caller: func(r *http.Request) *http.Request {
+				return withCaller(r, "candidate-1", model.RoleCandidate, "org-1", "")
+			},
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:         "token limited to another assessment",
+			assessmentID: assessment2,
+			caller: func(r *http.Request) *http.Request {
+				return withCaller(r, "recruiter-1", model.RoleRecruiter, "org-1", assessment1)
+			},
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:         "staff of the organization",
+			assessmentID: assessment2,
+			caller: func(r *http.Request) *http.Request {
+				return withCaller(r, "recruiter-1", model.RoleRecruiter, "org-1", "")
+			},
+			wantStatus: http.StatusSwitchingProtocols,
+		},
+		{
+			name:         "staff of another organization",
+			assessmentID: otherOrg,
+			caller: func(r *http.Request) *http.Request {
+				return withCaller(r, "recruiter-1", model.RoleRecruiter, "org-1", "")
+			},
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:         "invalid assessment ID",
+			assessmentID: "not-a-uuid",
+			caller: func(r *http.Request) *http.Request {
+				return withCaller(r, "recruiter-1", model.RoleRecruiter, "org-1", "")
+			},
+			wantStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			served = nil
+			req := tt.caller(httptest.NewRequest(http.MethodGet, "/ws/terminal/"+tt.assessmentID, nil))
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			connected := len(served) == 1 && served[0] == tt.assessmentID
+			if connected != (tt.wantStatus == http.StatusSwitchingProtocols) {
+				t.Errorf("connected to %v with status %d", served, rec.Code)
+			}
+		})
+	}
+}
@@ -8,14 +8,19 @@ import (
 	"strings"
 	"time"
 
+	"github.com/cstanislawski/qualifyd/pkg/audit"
 	"github.com/cstanislawski/qualifyd/pkg/auth"
 	"github.com/cstanislawski/qualifyd/pkg/database"
 	"github.com/cstanislawski/qualifyd/pkg/logger"
 	"github.com/cstanislawski/qualifyd/pkg/middleware"
 	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/cstanislawski/qualifyd/pkg/notification"
+	"github.com/cstanislawski/qualifyd/pkg/quota"
 	"github.com/cstanislawski/qualifyd/pkg/repository"
+	"github.com/cstanislawski/qualifyd/pkg/session"
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 )
 
 // CreateUserRequest represents the request payload for creating a user
@@ -47,7 +52,11 @@ type UpdateProfileRequest struct {
 type UserHandler struct {
 	userRepo         *repository.UserRepository
 	organizationRepo *repository.OrganizationRepository
+	quotas           *quota.Service
 	auth             *auth.Auth
+	notifications    *notification.Service
+	sessions         *session.Service
+	audit            *audit.Service
 	logger           logger.Logger
 }
 
@@ -55,13 +64,21 @@ type UserHandler struct {
 func NewUserHandler(
 	userRepo *repository.UserRepository,
 	organizationRepo *repository.OrganizationRepository,
+	quotas *quota.Service,
 	auth *auth.Auth,
+	notifications *notification.Service,
+	sessions *session.Service,
+	audit *audit.Service,
 	logger logger.Logger,
 ) *UserHandler {
 	return &UserHandler{
 		userRepo:         userRepo,
 		organizationRepo: organizationRepo,
+		quotas:           quotas,
 		auth:             auth,
+		notifications:    notifications,
+		sessions:         sessions,
+		audit:            audit,
 		logger:           logger,
 	}
 }
@@ -76,6 +93,11 @@ func (h *UserHandler) HandleCreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Users are invited into the caller's organization unless a super admin picks another
+	if req.OrganizationID == "" {
+		req.OrganizationID = middleware.GetOrganizationID(r)
+	}
+
 	// Validate request
 	if req.Email == "" || req.FirstName == "" || req.LastName == "" || req.Role == "" || req.OrganizationID == "" {
 		respondWithError(w, http.StatusBadRequest, "All fields are required", "")
@@ -83,11 +105,16 @@ func (h *UserHandler) HandleCreateUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Validate role
-	if !isValidRole(req.Role) {
+	if !isValidRole(req.Role) || !canAssignRole(r, req.Role) {
 		respondWithError(w, http.StatusBadRequest, "Invalid role", "")
 		return
 	}
 
+	if !canManageOrganization(r, req.OrganizationID) {
+		respondWithError(w, http.StatusNotFound, "Organization not found", "")
+		return
+	}
+
 	// Check if organization exists
 	org, err := h.organizationRepo.GetByID(r.Context(), req.OrganizationID)
 	if err != nil {
@@ -121,7 +148,20 @@ func (h *UserHandler) HandleCreateUser(w http.ResponseWriter, r *http.Request) {
 		InvitationExpiresAt: &invitationExpiry,
 	}
 
-	if err := h.userRepo.Create(r.Context(), user); err != nil {
+	// Pending invitations count towards the organization's user limit. The
+	// user is only created together with its audit event.
+	err = h.quotas.Reserve(r.Context(), org.ID, quota.ResourceUsers, func(tx pgx.Tx) error {
+		if err := h.userRepo.WithTx(tx).Create(r.Context(), user); err != nil {
+			return err
+		}
+		e := auditEvent(r, model.AuditUserCreated, model.AuditTargetUser, user.ID)
+		e.OrganizationID = org.ID
+		return h.audit.RecordChangeTx(r.Context(), tx, e, nil, user)
+	})
+	if err != nil {
+		if respondWithQuotaError(w, err) {
+			return
+		}
 		if errors.Is(err, database.ErrDuplicateKey) {
 			respondWithError(w, http.StatusConflict, "Email already exists", "")
 			return
@@ -133,12 +173,16 @@ func (h *UserHandler) HandleCreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// TODO: Send invitation email with token (e.g., queue a task)
-	h.logger.Info("User invited, needs to accept invitation", map[string]interface{}{
-		"user_id": user.ID,
-		"email":   user.Email,
-		"token":   invitationToken, // Log token for now (remove in prod)
-	})
+	// The user exists either way; a failed invitation email is only logged
+	var inviter *model.User
+	if inviterID := middleware.GetUserID(r); inviterID != "" {
+		inviter, _ = h.userRepo.GetByID(r.Context(), inviterID)
+	}
+	if err := h.notifications.InviteUser(r.Context(), org, user, inviter); err != nil {
+		h.logger.Error("Failed to send invitation email", err, map[string]interface{}{
+			"user_id": user.ID,
+		})
+	}
 
 	// Return success response
 	user.PasswordHash = ""     // Ensure sensitive data is not returned
@@ -152,8 +196,16 @@ func (h *UserHandler) HandleListUsers(w http.ResponseWriter, r *http.Request) {
 	// Get pagination parameters using the new helper
 	params := getPaginationParams(r, 10, 100) // Default limit 10, max 100
 
-	// Get organization filter from query parameters
+	// Get organization filter from query parameters; only super admins may
+	// list other organizations or every user on the platform
 	orgID := r.URL.Query().Get("organization_id")
+	if !middleware.IsSuperAdmin(r) {
+		if orgID != "" && orgID != middleware.GetOrganizationID(r) {
+			respondWithError(w, http.StatusNotFound, "Organization not found", "")
+			return
+		}
+		orgID = middleware.GetOrganizationID(r)
+	}
 	var orgIDPtr *string
 	if orgID != "" {
 		orgIDPtr = &orgID
@@ -195,6 +247,11 @@ func (h *UserHandler) HandleGetUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !canManageOrganization(r, user.OrganizationID) {
+		respondWithError(w, http.StatusNotFound, "User not found", "")
+		return
+	}
+
 	user.PasswordHash = "" // Remove sensitive data
 	user.InvitationToken = nil
 	user.InvitationExpiresAt = nil
@@ -219,6 +276,19 @@ func (h *UserHandler) HandleUpdateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !canManageOrganization(r, user.OrganizationID) {
+		respondWithError(w, http.StatusNotFound, "User not found", "")
+		return
+	}
+
+	// Only super admins may modify other super admins
+	if user.IsSuperAdmin() && !middleware.IsSuperAdmin(r) {
+		respondWithError(w, http.StatusForbidden, "Forbidden", "Super admins can only be modified by other super admins")
+		return
+	}
+
+	before := *user
+
 	// Parse request
 	var req UpdateUserRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -238,7 +308,7 @@ func (h *UserHandler) HandleUpdateUser(w http.ResponseWriter, r *http.Request) {
 		updated = true
 	}
 	if req.Role != "" && user.Role != req.Role {
-		if !isValidRole(req.Role) {
+		if !isValidRole(req.Role) || !canAssignRole(r, req.Role) {
 			respondWithError(w, http.StatusBadRequest, "Invalid role", "")
 			return
 		}
@@ -259,6 +329,17 @@ func (h *UserHandler) HandleUpdateUser(w http.ResponseWriter, r *http.Request) {
 		updated = true
 	}
 
+	// The owner must stay an active admin; ownership has to be transferred first
+	if updated && ((user.Role != model.RoleAdmin && user.Role != model.RoleSuperAdmin) || user.Status != model.StatusActive) {
+		if owned, err := h.ownsOrganization(r, user); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to update user", "")
+			return
+		} else if owned {
+			respondWithError(w, http.StatusConflict, "Cannot demote or deactivate the organization owner", "Transfer ownership first")
+			return
+		}
+	}
+
 	// Update user in DB only if something changed
 	if updated {
 		if err := h.userRepo.Update(r.Context(), user); err != nil {
@@ -268,6 +349,10 @@ func (h *UserHandler) HandleUpdateUser(w http.ResponseWriter, r *http.Request) {
 			respondWithError(w, http.StatusInternalServerError, "Failed to update user", "")
 			return
 		}
+
+		e := auditEvent(r, model.AuditUserUpdated, model.AuditTargetUser, user.ID)
+		e.OrganizationID = user.OrganizationID
+		h.audit.RecordChange(r.Context(), e, &before, user)
 	}
 
 	user.PasswordHash = "" // Remove sensitive data
@@ -280,6 +365,37 @@ func (h *UserHandler) HandleUpdateUser(w http.ResponseWriter, r *http.Request) {
 func (h *UserHandler) HandleDeleteUser(w http.ResponseWriter, r *http.Request) {
 	userID := chi.URLParam(r, "user_id") // Use chi.URLParam
 
+	user, err := h.userRepo.GetByID(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			respondWithError(w, http.StatusNotFound, "User not found", "")
+			return
+		}
+		h.logger.Error("Failed to get user", err, map[string]interface{}{
+			"user_id": userID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to delete user", "")
+		return
+	}
+
+	if !canManageOrganization(r, user.OrganizationID) {
+		respondWithError(w, http.StatusNotFound, "User not found", "")
+		return
+	}
+
+	if user.IsSuperAdmin() && !middleware.IsSuperAdmin(r) {
+		respondWithError(w, http.StatusForbidden, "Forbidden", "Super admins can only be deleted by other super admins")
+		return
+	}
+
+	if owned, err := h.ownsOrganization(r, user); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to delete user", "")
+		return
+	} else if owned {
+		respondWithError(w, http.StatusConflict, "Cannot delete the organization owner", "Transfer ownership first")
+		return
+	}
+
 	// We should implement soft delete (e.g., setting status to 'deleted') instead of hard delete
 	// For now, assuming repository.Delete performs the desired action (hard or soft)
 	if err := h.userRepo.Delete(r.Context(), userID); err != nil {
@@ -294,6 +410,10 @@ func (h *UserHandler) HandleDeleteUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	e := auditEvent(r, model.AuditUserDeleted, model.AuditTargetUser, user.ID)
+	e.OrganizationID = user.OrganizationID
+	h.audit.RecordChange(r.Context(), e, user, nil)
+
 	respondWithJSON(w, http.StatusNoContent, nil)
 }
 
@@ -325,7 +445,8 @@ func (h *UserHandler) HandleGetMyProfile(w http.ResponseWriter, r *http.Request)
 	respondWithJSON(w, http.StatusOK, user)
 }
 
-// HandleUpdateMyProfile handles updating the current user's profile
+// HandleUpdateMyProfile handles updating the current user's profile. Changing
+// the password signs the user out of every other session.
 func (h *UserHandler) HandleUpdateMyProfile(w http.ResponseWriter, r *http.Request) {
 	// Get user ID from context
 	userID := middleware.GetUserID(r) // Use middleware helper
@@ -373,6 +494,10 @@ func (h *UserHandler) HandleUpdateMyProfile(w http.ResponseWriter, r *http.Reque
 			respondWithError(w, http.StatusBadRequest, "Current password is required to set a new password", "")
 			return
 		}
+		if len(req.NewPassword) < minPasswordLength {
+			respondWithError(w, http.StatusBadRequest, "Password is too short", "Passwords must have at least "+strconv.Itoa(minPasswordLength)+" characters")
+			return
+		}
 
 		// Verify current password
 		if err := auth.CheckPassword(req.CurrentPassword, user.PasswordHash); err != nil {
@@ -397,6 +522,15 @@ func (h *UserHandler) HandleUpdateMyProfile(w http.ResponseWriter, r *http.Reque
 			return
 		}
 		passwordChanged = true
+
+		// Sign out every other session, which may have been opened with the old password
+		if _, err := h.sessions.RevokeAll(r.Context(), user.ID, model.SessionRevokedPasswordChange, middleware.GetSessionID(r)); err != nil {
+			h.logger.Error("Failed to revoke sessions after password change", err, map[string]interface{}{
+				"user_id": user.ID,
+			})
+			respondWithError(w, http.StatusInternalServerError, "Failed to sign out other sessions", "")
+			return
+		}
 	}
 
 	// Update user profile only if non-password fields changed
@@ -453,15 +587,39 @@ func getPaginationParams(r *http.Request, defaultLimit, maxLimit int) database.P
 	return database.NewPaginationParams(page, limit)
 }
 
+// ownsOrganization reports whether the user owns their organization
+func (h *UserHandler) ownsOrganization(r *http.Request, user *model.User) (bool, error) {
+	if user.OrganizationID == "" {
+		return false, nil
+	}
+	org, err := h.organizationRepo.GetByID(r.Context(), user.OrganizationID)
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			return false, nil
+		}
+		h.logger.Error("Failed to get organization", err, map[string]interface{}{
+			"organization_id": user.OrganizationID,
+		})
+		return false, err
+	}
+	return org.IsOwnedBy(user.ID), nil
+}
+
 func isValidRole(role string) bool {
 	switch role {
-	case model.RoleAdmin, model.RoleRecruiter, model.RoleCandidate, model.RoleReviewer:
+	case model.RoleSuperAdmin, model.RoleAdmin, model.RoleTemplateEditor, model.RoleRecruiter, model.RoleCandidate, model.RoleReviewer:
 		return true
 	default:
 		return false
 	}
 }
 
+// canAssignRole reports whether the caller may grant the role; only super
+// admins can create other super admins
+func canAssignRole(r *http.Request, role string) bool {
+	return role != model.RoleSuperAdmin || middleware.IsSuperAdmin(r)
+}
+
 func isValidStatus(status string) bool {
 	switch status {
 	case model.StatusActive, model.StatusInactive, model.StatusPending:
@@ -0,0 +1,387 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/cstanislawski/qualifyd/pkg/audit"
+	"github.com/cstanislawski/qualifyd/pkg/database"
+	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/middleware"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/cstanislawski/qualifyd/pkg/repository"
+	"github.com/cstanislawski/qualifyd/pkg/webhook"
+	"github.com/go-chi/chi/v5"
+)
+
+// CreateWebhookRequest represents the request payload for registering a webhook
+type CreateWebhookRequest struct {
+	URL         string   `json:"url"`
+	Events      []string `json:"events"`
+	Description string   `json:"description,omitempty"`
+	Active      *bool    `json:"active,omitempty"`
+}
+
+// UpdateWebhookRequest represents the request payload for updating a webhook.
+// Omitted fields are left unchanged.
+type UpdateWebhookRequest struct {
+	URL         *string  `json:"url,omitempty"`
+	Events      []string `json:"events,omitempty"`
+	Description *string  `json:"description,omitempty"`
+	Active      *bool    `json:"active,omitempty"`
+	// RotateSecret replaces the signing secret; the new one is returned once
+	RotateSecret bool `json:"rotate_secret,omitempty"`
+}
+
+// WebhookSecretResponse is a webhook together with its signing secret, which
+// is only returned when it is created or rotated
+type WebhookSecretResponse struct {
+	*model.WebhookSubscription
+	Secret string `json:"secret"`
+}
+
+// WebhookHandler handles an organization's webhook subscriptions and their
+// delivery log
+type WebhookHandler struct {
+	organizationRepo *repository.OrganizationRepository
+	webhookRepo      *repository.WebhookRepository
+	dispatcher       *webhook.Dispatcher
+	audit            *audit.Service
+	logger           logger.Logger
+}
+
+// NewWebhookHandler creates a new WebhookHandler instance
+func NewWebhookHandler(
+	organizationRepo *repository.OrganizationRepository,
+	webhookRepo *repository.WebhookRepository,
+	dispatcher *webhook.Dispatcher,
+	audit *audit.Service,
+	logger logger.Logger,
+) *WebhookHandler {
+	return &WebhookHandler{
+		organizationRepo: organizationRepo,
+		webhookRepo:      webhookRepo,
+		dispatcher:       dispatcher,
+		audit:            audit,
+		logger:           logger,
+	}
+}
+
+// HandleListWebhooks handles listing an organization's webhooks
+func (h *WebhookHandler) HandleListWebhooks(w http.ResponseWriter, r *http.Request) {
+	org, ok := loadManagedOrganization(w, r, h.organizationRepo, h.logger)
+	if !ok {
+		return
+	}
+
+	subscriptions, err := h.webhookRepo.ListSubscriptions(r.Context(), org.ID)
+	if err != nil {
+		h.logger.Error("Failed to list webhooks", err, map[string]interface{}{
+			"organization_id": org.ID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to list webhooks", "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, subscriptions)
+}
+
+// HandleCreateWebhook handles registering a webhook. The response contains
+// the signing secret, which is not shown again.
+func (h *WebhookHandler) HandleCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	org, ok := loadManagedOrganization(w, r, h.organizationRepo, h.logger)
+	if !ok {
+		return
+	}
+
+	var req CreateWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.logger.Error("Failed to decode create webhook request", err, nil)
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload", err.Error())
+		return
+	}
+
+	subscription := &model.WebhookSubscription{
+		OrganizationID: org.ID,
+		URL:            strings.TrimSpace(req.URL),
+		Events:         req.Events,
+		Description:    strings.TrimSpace(req.Description),
+		Active:         req.Active == nil || *req.Active,
+		CreatedBy:      middleware.GetUserID(r),
+	}
+	if validationErrors := subscription.Validate(); len(validationErrors) > 0 {
+		respondWithJSON(w, http.StatusBadRequest, map[string]interface{}{
+			"error":  "Invalid webhook",
+			"fields": validationErrors,
+		})
+		return
+	}
+
+	secret, err := webhook.GenerateSecret()
+	if err != nil {
+		h.logger.Error("Failed to generate webhook secret", err, nil)
+		respondWithError(w, http.StatusInternalServerError, "Failed to create webhook", "")
+		return
+	}
+	subscription.Secret = secret
+
+	if err := h.webhookRepo.CreateSubscription(r.Context(), subscription); err != nil {
+		h.logger.Error("Failed to create webhook", err, map[string]interface{}{
+			"organization_id": org.ID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to create webhook", "")
+		return
+	}
+	h.audit.RecordChange(r.Context(), webhookEvent(r, model.AuditWebhookCreated, subscription), nil, subscription)
+
+	respondWithJSON(w, http.StatusCreated, WebhookSecretResponse{
+		WebhookSubscription: subscription,
+		Secret:              subscription.Secret,
+	})
+}
+
+// HandleGetWebhook handles getting one of an organization's webhooks
+func (h *WebhookHandler) HandleGetWebhook(w http.ResponseWriter, r *http.Request) {
+	org, ok := loadManagedOrganization(w, r, h.organizationRepo, h.logger)
+	if !ok {
+		return
+	}
+
+	subscription, ok := h.loadSubscription(w, r, org.ID)
+	if !ok {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, subscription)
+}
+
+// HandleUpdateWebhook handles updating a webhook's endpoint, events or
+// state, and rotating its signing secret
+func (h *WebhookHandler) HandleUpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	org, ok := loadManagedOrganization(w, r, h.organizationRepo, h.logger)
+	if !ok {
+		return
+	}
+
+	var req UpdateWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.logger.Error("Failed to decode update webhook request", err, nil)
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload", err.Error())
+		return
+	}
+
+	subscription, ok := h.loadSubscription(w, r, org.ID)
+	if !ok {
+		return
+	}
+	before := *subscription
+
+	if req.URL != nil {
+		subscription.URL = strings.TrimSpace(*req.URL)
+	}
+	if req.Events != nil {
+		subscription.Events = req.Events
+	}
+	if req.Description != nil {
+		subscription.Description = strings.TrimSpace(*req.Description)
+	}
+	if req.Active != nil {
+		subscription.Active = *req.Active
+	}
+	if validationErrors := subscription.Validate(); len(validationErrors) > 0 {
+		respondWithJSON(w, http.StatusBadRequest, map[string]interface{}{
+			"error":  "Invalid webhook",
+			"fields": validationErrors,
+		})
+		return
+	}
+
+	if req.RotateSecret {
+		secret, err := webhook.GenerateSecret()
+		if err != nil {
+			h.logger.Error("Failed to generate webhook secret", err, nil)
+			respondWithError(w, http.StatusInternalServerError, "Failed to update webhook", "")
+			return
+		}
+		subscription.Secret = secret
+	}
+
+	if err := h.webhookRepo.UpdateSubscription(r.Context(), subscription); err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			respondWithError(w, http.StatusNotFound, "Webhook not found", "")
+			return
+		}
+		h.logger.Error("Failed to update webhook", err, map[string]interface{}{
+			"organization_id": org.ID,
+			"webhook_id":      subscription.ID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to update webhook", "")
+		return
+	}
+	h.audit.RecordChange(r.Context(), webhookEvent(r, model.AuditWebhookUpdated, subscription), &before, subscription)
+
+	if req.RotateSecret {
+		// The secret is left out of the diff, so its rotation has its own event
+		h.audit.Record(r.Context(), webhookEvent(r, model.AuditWebhookSecretRotated, subscription))
+		respondWithJSON(w, http.StatusOK, WebhookSecretResponse{
+			WebhookSubscription: subscription,
+			Secret:              subscription.Secret,
+		})
+		return
+	}
+	respondWithJSON(w, http.StatusOK, subscription)
+}
+
+// HandleDeleteWebhook handles deleting a webhook together with its delivery log
+func (h *WebhookHandler) HandleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	org, ok := loadManagedOrganization(w, r, h.organizationRepo, h.logger)
+	if !ok {
+		return
+	}
+
+	id := chi.URLParam(r, "webhook_id")
+	if err := h.webhookRepo.DeleteSubscription(r.Context(), org.ID, id); err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			respondWithError(w, http.StatusNotFound, "Webhook not found", "")
+			return
+		}
+		h.logger.Error("Failed to delete webhook", err, map[string]interface{}{
+			"organization_id": org.ID,
+			"webhook_id":      id,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to delete webhook", "")
+		return
+	}
+	e := auditEvent(r, model.AuditWebhookDeleted, model.AuditTargetWebhook, id)
+	e.OrganizationID = org.ID
+	h.audit.Record(r.Context(), e)
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
+
+// HandleListDeliveries handles listing an organization's webhook deliveries,
+// newest first, optionally only those of the webhook given by ?webhook_id=
+func (h *WebhookHandler) HandleListDeliveries(w http.ResponseWriter, r *http.Request) {
+	org, ok := loadManagedOrganization(w, r, h.organizationRepo, h.logger)
+	if !ok {
+		return
+	}
+
+	response, err := h.webhookRepo.ListDeliveries(r.Context(), org.ID, r.URL.Query().Get("webhook_id"), getPaginationParams(r, 20, 100))
+	if err != nil {
+		h.logger.Error("Failed to list webhook deliveries", err, map[string]interface{}{
+			"organization_id": org.ID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to list webhook deliveries", "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// HandleGetDelivery handles getting a webhook delivery with its attempt log
+func (h *WebhookHandler) HandleGetDelivery(w http.ResponseWriter, r *http.Request) {
+	org, ok := loadManagedOrganization(w, r, h.organizationRepo, h.logger)
+	if !ok {
+		return
+	}
+
+	delivery, ok := h.loadDelivery(w, r, org.ID)
+	if !ok {
+		return
+	}
+
+	attempts, err := h.webhookRepo.ListAttempts(r.Context(), delivery.ID)
+	if err != nil {
+		h.logger.Error("Failed to list webhook delivery attempts", err, map[string]interface{}{
+			"organization_id": org.ID,
+			"delivery_id":     delivery.ID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to get webhook delivery", "")
+		return
+	}
+	delivery.AttemptLog = attempts
+
+	respondWithJSON(w, http.StatusOK, delivery)
+}
+
+// HandleRedeliver handles sending a webhook delivery again, for instance
+// after it failed permanently while the endpoint was down
+func (h *WebhookHandler) HandleRedeliver(w http.ResponseWriter, r *http.Request) {
+	org, ok := loadManagedOrganization(w, r, h.organizationRepo, h.logger)
+	if !ok {
+		return
+	}
+
+	delivery, ok := h.loadDelivery(w, r, org.ID)
+	if !ok {
+		return
+	}
+	if delivery.Status == model.WebhookDeliveryPending || delivery.Status == model.WebhookDeliveryRetrying {
+		respondWithError(w, http.StatusConflict, "Webhook delivery is still in progress", "")
+		return
+	}
+
+	if err := h.dispatcher.Redeliver(r.Context(), delivery); err != nil {
+		h.logger.Error("Failed to redeliver webhook", err, map[string]interface{}{
+			"organization_id": org.ID,
+			"delivery_id":     delivery.ID,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to redeliver webhook", "")
+		return
+	}
+	e := auditEvent(r, model.AuditWebhookRedelivered, model.AuditTargetWebhookDelivery, delivery.ID)
+	e.OrganizationID = org.ID
+	h.audit.Record(r.Context(), e)
+
+	respondWithJSON(w, http.StatusAccepted, delivery)
+}
+
+// webhookEvent starts an audit event of a change to a webhook
+func webhookEvent(r *http.Request, action string, subscription *model.WebhookSubscription) *model.AuditEvent {
+	e := auditEvent(r, action, model.AuditTargetWebhook, subscription.ID)
+	e.OrganizationID = subscription.OrganizationID
+	return e
+}
+
+// loadSubscription loads the webhook named by the webhook_id URL parameter,
+// writing the error response if it fails
+func (h *WebhookHandler) loadSubscription(w http.ResponseWriter, r *http.Request, organizationID string) (*model.WebhookSubscription, bool) {
+	id := chi.URLParam(r, "webhook_id")
+	subscription, err := h.webhookRepo.GetSubscription(r.Context(), organizationID, id)
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			respondWithError(w, http.StatusNotFound, "Webhook not found", "")
+			return nil, false
+		}
+		h.logger.Error("Failed to get webhook", err, map[string]interface{}{
+			"organization_id": organizationID,
+			"webhook_id":      id,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to get webhook", "")
+		return nil, false
+	}
+	return subscription, true
+}
+
+// loadDelivery loads the delivery named by the delivery_id URL parameter,
+// writing the error response if it fails
+func (h *WebhookHandler) loadDelivery(w http.ResponseWriter, r *http.Request, organizationID string) (*model.WebhookDelivery, bool) {
+	id := chi.URLParam(r, "delivery_id")
+	delivery, err := h.webhookRepo.GetDelivery(r.Context(), organizationID, id)
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			respondWithError(w, http.StatusNotFound, "Webhook delivery not found", "")
+			return nil, false
+		}
+		h.logger.Error("Failed to get webhook delivery", err, map[string]interface{}{
+			"organization_id": organizationID,
+			"delivery_id":     id,
+		})
+		respondWithError(w, http.StatusInternalServerError, "Failed to get webhook delivery", "")
+		return nil, false
+	}
+	return delivery, true
+}
@@ -0,0 +1,215 @@
+package invoice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/blob"
+	"github.com/cstanislawski/qualifyd/pkg/config"
+	"github.com/cstanislawski/qualifyd/pkg/database"
+	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/cstanislawski/qualifyd/pkg/repository"
+)
+
+// Statement formats
+const (
+	FormatPDF  = "pdf"
+	FormatHTML = "html"
+)
+
+// ErrPeriodNotEnded is returned when asking for the statement of a billing
+// period that has not ended yet
+var ErrPeriodNotEnded = errors.New("billing period has not ended")
+
+// Period is a billing period [Start, End)
+type Period struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// Generator builds statements and keeps them in the blob store
+type Generator struct {
+	organizationRepo *repository.OrganizationRepository
+	billingRepo      *repository.BillingRepository
+	usageRepo        *repository.UsageRepository
+	quotaRepo        *repository.QuotaRepository
+	store            blob.Store
+	cfg              *config.BillingConfig
+	log              logger.Logger
+}
+
+// NewGenerator creates a new statement Generator
+func NewGenerator(
+	organizationRepo *repository.OrganizationRepository,
+	billingRepo *repository.BillingRepository,
+	usageRepo *repository.UsageRepository,
+	quotaRepo *repository.QuotaRepository,
+	store blob.Store,
+	cfg *config.BillingConfig,
+	log logger.Logger,
+) *Generator {
+	return &Generator{
+		organizationRepo: organizationRepo,
+		billingRepo:      billingRepo,
+		usageRepo:        usageRepo,
+		quotaRepo:        quotaRepo,
+		store:            store,
+		cfg:              cfg,
+		log:              log,
+	}
+}
+
+// Generate builds the statement of a billing period, stores it in every
+// format and links the period's billing records to it
+func (g *Generator) Generate(ctx context.Context, org *model.Organization, period Period) (*Statement, error) {
+	records, err := g.billingRepo.ListByPeriod(ctx, org.ID, period.Start, period.End)
+	if err != nil {
+		return nil, err
+	}
+	usage, err := g.usageRepo.SumByResourceType(ctx, org.ID, period.Start, period.End)
+	if err != nil {
+		return nil, err
+	}
+
+	includedMinutes := 0
+	quota, err := g.quotaRepo.GetByOrganization(ctx, org.ID)
+	switch {
+	case err == nil:
+		includedMinutes = quota.IncludedEnvironmentMinutes
+	case !errors.Is(err, database.ErrRecordNotFound):
+		return nil, err
+	}
+
+	statement := Build(Input{
+		Organization:    org,
+		PeriodStart:     period.Start,
+		PeriodEnd:       period.End,
+		Records:         records,
+		Usage:           usage,
+		IncludedMinutes: includedMinutes,
+		Pricing: Pricing{
+			Currency:              g.cfg.Currency,
+			PlanPrice:             g.cfg.PlanPrice(org.SubscriptionPlan),
+			OveragePricePerMinute: g.cfg.OveragePricePerMinute,
+			Issuer:                g.cfg.StatementIssuer,
+			IssuerAddress:         g.cfg.StatementIssuerAddress,
+		},
+		IssuedAt: time.Now().UTC(),
+	})
+
+	html, err := RenderHTML(statement)
+	if err != nil {
+		return nil, err
+	}
+	if err := g.store.Put(ctx, Key(org.ID, period.Start, FormatHTML), html); err != nil {
+		return nil, err
+	}
+	// The PDF is written last: its presence marks the statement as generated
+	if err := g.store.Put(ctx, Key(org.ID, period.Start, FormatPDF), RenderPDF(statement)); err != nil {
+		return nil, err
+	}
+
+	if err := g.billingRepo.SetInvoiceURL(ctx, org.ID, period.Start, period.End, URL(org.ID, period.Start, FormatPDF)); err != nil {
+		return nil, err
+	}
+
+	g.log.Info("Statement generated", map[string]interface{}{
+		"organizationID": org.ID,
+		"number":         statement.Number,
+		"periodStart":    period.Start,
+		"total":          statement.Total,
+	})
+	return statement, nil
+}
+
+// Get returns a stored statement, generating it first if needed. Only
+// statements of ended periods are available.
+func (g *Generator) Get(ctx context.Context, org *model.Organization, period Period, format string) ([]byte, error) {
+	if period.End.After(time.Now().UTC()) {
+		return nil, ErrPeriodNotEnded
+	}
+
+	key := Key(org.ID, period.Start, format)
+	exists, err := g.store.Exists(ctx, Key(org.ID, period.Start, FormatPDF))
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		if _, err := g.Generate(ctx, org, period); err != nil {
+			return nil, err
+		}
+	}
+
+	return g.store.Get(ctx, key)
+}
+
+// GenerateDue generates the statement of the last ended billing period of
+// every organization that does not have one yet. It is run by the scheduler.
+func (g *Generator) GenerateDue(ctx context.Context) error {
+	orgs, err := g.organizationRepo.ListBySubscriptionStatus(ctx,
+		model.SubscriptionStatusActive, model.SubscriptionStatusPastDue, model.SubscriptionStatusSuspended,
+		model.SubscriptionStatusInactive, model.SubscriptionStatusCanceled)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	for _, org := range orgs {
+		periods := Periods(org, now, 1)
+		if len(periods) == 0 {
+			continue
+		}
+
+		exists, err := g.store.Exists(ctx, Key(org.ID, periods[0].Start, FormatPDF))
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+
+		if _, err := g.Generate(ctx, org, periods[0]); err != nil {
+			g.log.Error("Failed to generate statement", err, map[string]interface{}{
+				"organizationID": org.ID,
+				"periodStart":    periods[0].Start,
+			})
+		}
+	}
+
+	return nil
+}
+
+// Periods returns up to limit of the organization's ended billing periods, newest first
+func Periods(org *model.Organization, now time.Time, limit int) []Period {
+	anchor := org.BillingAnchor()
+	end, _ := model.BillingPeriod(anchor, now)
+
+	var periods []Period
+	for len(periods) < limit && end.After(anchor) {
+		start, _ := model.BillingPeriod(anchor, end.Add(-time.Nanosecond))
+		periods = append(periods, Period{Start: start, End: end})
+		end = start
+	}
+	return periods
+}
+
+// PeriodOn returns the organization's billing period that includes the given
+// calendar day's end, so that a period's start date names that period
+func PeriodOn(org *model.Organization, day time.Time) Period {
+	start, end := model.BillingPeriod(org.BillingAnchor(), day.AddDate(0, 0, 1).Add(-time.Nanosecond))
+	return Period{Start: start, End: end}
+}
+
+// Key returns the blob key of a statement
+func Key(organizationID string, periodStart time.Time, format string) string {
+	return fmt.Sprintf("statements/%s/%s.%s", organizationID, periodStart.UTC().Format("20060102T150405Z"), format)
+}
+
+// URL returns the API path a statement is downloaded from
+func URL(organizationID string, periodStart time.Time, format string) string {
+	return fmt.Sprintf("/api/admin/organizations/%s/billing/statements/%s?format=%s",
+		organizationID, periodStart.UTC().Format("2006-01-02"), format)
+}
@@ -0,0 +1,75 @@
+package invoice
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"time"
+)
+
+// statementTemplate renders a Statement as a standalone HTML page
+var statementTemplate = template.Must(template.New("statement").Funcs(template.FuncMap{
+	"amount":   func(s *Statement, v float64) string { return formatAmount(s.Currency, v) },
+	"quantity": formatQuantity,
+	"date":     func(t time.Time) string { return t.Format("January 2, 2006") },
+	"plan":     planName,
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Statement {{.Number}}</title>
+<style>
+body { font-family: Helvetica, Arial, sans-serif; color: #1f2933; margin: 40px; }
+h1 { font-size: 24px; margin-bottom: 4px; }
+table { width: 100%; border-collapse: collapse; margin-top: 24px; }
+th, td { padding: 8px; border-bottom: 1px solid #e4e7eb; text-align: left; }
+td.num, th.num { text-align: right; }
+.meta { color: #52606d; }
+.totals td { border: none; }
+</style>
+</head>
+<body>
+<h1>Statement {{.Number}}</h1>
+<p class="meta">{{.Issuer}}{{if .IssuerAddress}}<br>{{.IssuerAddress}}{{end}}</p>
+<p>
+<strong>{{.OrganizationName}}</strong>
+{{- if .BillingAddress}}<br>{{.BillingAddress}}{{end}}
+{{- if .BillingEmail}}<br>{{.BillingEmail}}{{end}}
+</p>
+<p class="meta">
+{{plan .Plan}} plan<br>
+Billing period: {{date .PeriodStart}} to {{date .PeriodEnd}}<br>
+Issued: {{date .IssuedAt}}
+</p>
+<table>
+<thead><tr><th>Description</th><th class="num">Quantity</th><th class="num">Unit price</th><th class="num">Amount</th></tr></thead>
+<tbody>
+{{- range .Lines}}
+<tr><td>{{.Description}}</td><td class="num">{{quantity .Quantity}}{{if .Unit}} {{.Unit}}{{end}}</td><td class="num">{{amount $ .UnitPrice}}</td><td class="num">{{amount $ .Amount}}</td></tr>
+{{- end}}
+</tbody>
+<tfoot class="totals">
+<tr><td colspan="3" class="num">Total</td><td class="num">{{amount . .Total}}</td></tr>
+<tr><td colspan="3" class="num">Paid</td><td class="num">{{amount . .Paid}}</td></tr>
+<tr><td colspan="3" class="num"><strong>Amount due</strong></td><td class="num"><strong>{{amount . .AmountDue}}</strong></td></tr>
+</tfoot>
+</table>
+<h2>Usage</h2>
+<table>
+<tr><td>Environment minutes used</td><td class="num">{{.Usage.EnvironmentMinutes}}</td></tr>
+<tr><td>Environment minutes included</td><td class="num">{{.Usage.IncludedMinutes}}</td></tr>
+<tr><td>Environment minutes over the included amount</td><td class="num">{{.Usage.OverageMinutes}}</td></tr>
+<tr><td>Assessments created</td><td class="num">{{.Usage.Assessments}}</td></tr>
+</table>
+</body>
+</html>
+`))
+
+// RenderHTML renders a statement as an HTML document
+func RenderHTML(statement *Statement) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := statementTemplate.Execute(&buf, statement); err != nil {
+		return nil, fmt.Errorf("failed to render statement HTML: %w", err)
+	}
+	return buf.Bytes(), nil
+}
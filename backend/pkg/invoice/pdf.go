@@ -0,0 +1,211 @@
+package invoice
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Page layout of rendered PDFs, in points: A4 with 50pt margins
+const (
+	pdfPageWidth  = 595.0
+	pdfPageHeight = 842.0
+	pdfMargin     = 50.0
+	pdfLineHeight = 16.0
+)
+
+// pdfDocument is a minimal PDF writer for text-only documents using the
+// standard Helvetica fonts, which every PDF reader provides
+type pdfDocument struct {
+	pages []*bytes.Buffer
+	y     float64
+}
+
+// newPDFDocument creates a document with one empty page
+func newPDFDocument() *pdfDocument {
+	d := &pdfDocument{}
+	d.addPage()
+	return d
+}
+
+// addPage starts a new page and moves to its top
+func (d *pdfDocument) addPage() {
+	d.pages = append(d.pages, &bytes.Buffer{})
+	d.y = pdfPageHeight - pdfMargin
+}
+
+// page returns the content stream of the current page
+func (d *pdfDocument) page() *bytes.Buffer {
+	return d.pages[len(d.pages)-1]
+}
+
+// newline moves down by height, starting a new page when the bottom margin is reached
+func (d *pdfDocument) newline(height float64) {
+	d.y -= height
+	if d.y < pdfMargin {
+		d.addPage()
+	}
+}
+
+// text writes s at x on the current line. Right-aligned text ends at x; its
+// width is estimated from the average Helvetica glyph width.
+func (d *pdfDocument) text(x float64, s string, size float64, bold, alignRight bool) {
+	if alignRight {
+		x -= float64(len(s)) * size * 0.5
+	}
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(d.page(), "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, d.y, pdfEscape(s))
+}
+
+// rule draws a horizontal line across the page below the current line
+func (d *pdfDocument) rule() {
+	y := d.y - 4
+	fmt.Fprintf(d.page(), "0.8 G 0.5 w %.2f %.2f m %.2f %.2f l S 0 G\n", pdfMargin, y, pdfPageWidth-pdfMargin, y)
+}
+
+// bytes serializes the document
+func (d *pdfDocument) bytes() []byte {
+	var out bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// Objects 1-4 are the catalog, the page tree and the two fonts; each
+	// page then takes two objects, the page and its content stream
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	for i, content := range d.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] "+
+			"/Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, 6+2*i))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	return out.Bytes()
+}
+
+// pdfEscape escapes a string for a PDF literal string. Characters outside
+// Latin-1 cannot be shown with the standard fonts and are replaced.
+func pdfEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x20:
+			b.WriteByte(' ')
+		case r < 0x80:
+			b.WriteRune(r)
+		case r <= 0xff:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// RenderPDF renders a statement as a PDF document
+func RenderPDF(statement *Statement) []byte {
+	d := newPDFDocument()
+	right := pdfPageWidth - pdfMargin
+	amount := func(v float64) string { return formatAmount(statement.Currency, v) }
+
+	d.text(pdfMargin, "Statement "+statement.Number, 20, true, false)
+	d.newline(24)
+	d.text(pdfMargin, statement.Issuer, 10, false, false)
+	if statement.IssuerAddress != "" {
+		d.newline(pdfLineHeight)
+		d.text(pdfMargin, statement.IssuerAddress, 10, false, false)
+	}
+	d.newline(2 * pdfLineHeight)
+
+	d.text(pdfMargin, statement.OrganizationName, 11, true, false)
+	for _, line := range []string{statement.BillingAddress, statement.BillingEmail} {
+		if line != "" {
+			d.newline(pdfLineHeight)
+			d.text(pdfMargin, line, 10, false, false)
+		}
+	}
+	d.newline(2 * pdfLineHeight)
+
+	d.text(pdfMargin, planName(statement.Plan)+" plan", 10, false, false)
+	d.newline(pdfLineHeight)
+	d.text(pdfMargin, fmt.Sprintf("Billing period: %s to %s",
+		statement.PeriodStart.Format("January 2, 2006"), statement.PeriodEnd.Format("January 2, 2006")), 10, false, false)
+	d.newline(pdfLineHeight)
+	d.text(pdfMargin, "Issued: "+statement.IssuedAt.Format("January 2, 2006"), 10, false, false)
+	d.newline(2 * pdfLineHeight)
+
+	d.text(pdfMargin, "Description", 10, true, false)
+	d.text(right-190, "Quantity", 10, true, true)
+	d.text(right-95, "Unit price", 10, true, true)
+	d.text(right, "Amount", 10, true, true)
+	d.rule()
+	for _, line := range statement.Lines {
+		d.newline(pdfLineHeight + 4)
+		description := line.Description
+		if runes := []rune(description); len(runes) > 55 {
+			description = string(runes[:52]) + "..."
+		}
+		quantity := formatQuantity(line.Quantity)
+		if line.Unit != "" {
+			quantity += " " + line.Unit
+		}
+		d.text(pdfMargin, description, 10, false, false)
+		d.text(right-190, quantity, 10, false, true)
+		d.text(right-95, amount(line.UnitPrice), 10, false, true)
+		d.text(right, amount(line.Amount), 10, false, true)
+		d.rule()
+	}
+
+	d.newline(pdfLineHeight + 8)
+	d.text(right-95, "Total", 10, false, true)
+	d.text(right, amount(statement.Total), 10, false, true)
+	d.newline(pdfLineHeight)
+	d.text(right-95, "Paid", 10, false, true)
+	d.text(right, amount(statement.Paid), 10, false, true)
+	d.newline(pdfLineHeight)
+	d.text(right-95, "Amount due", 10, true, true)
+	d.text(right, amount(statement.AmountDue), 10, true, true)
+
+	d.newline(3 * pdfLineHeight)
+	d.text(pdfMargin, "Usage", 14, true, false)
+	usage := []struct {
+		label string
+		value int
+	}{
+		{"Environment minutes used", statement.Usage.EnvironmentMinutes},
+		{"Environment minutes included", statement.Usage.IncludedMinutes},
+		{"Environment minutes over the included amount", statement.Usage.OverageMinutes},
+		{"Assessments created", statement.Usage.Assessments},
+	}
+	for _, row := range usage {
+		d.newline(pdfLineHeight + 4)
+		d.text(pdfMargin, row.label, 10, false, false)
+		d.text(right, fmt.Sprintf("%d", row.value), 10, false, true)
+	}
+
+	return d.bytes()
+}
@@ -0,0 +1,225 @@
+// Package invoice builds monthly statements from billing and usage records and
+// renders them as HTML and PDF.
+package invoice
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/model"
+)
+
+// Line is a statement line item
+type Line struct {
+	Description string  `json:"description"`
+	Quantity    float64 `json:"quantity"`
+	Unit        string  `json:"unit,omitempty"`
+	UnitPrice   float64 `json:"unit_price"`
+	Amount      float64 `json:"amount"`
+}
+
+// Usage summarizes what the organization consumed during the period
+type Usage struct {
+	EnvironmentMinutes int `json:"environment_minutes"`
+	IncludedMinutes    int `json:"included_minutes"`
+	OverageMinutes     int `json:"overage_minutes"`
+	Assessments        int `json:"assessments"`
+}
+
+// Statement is an organization's invoice and usage statement for one billing period
+type Statement struct {
+	Number           string    `json:"number"`
+	Issuer           string    `json:"issuer"`
+	IssuerAddress    string    `json:"issuer_address,omitempty"`
+	OrganizationID   string    `json:"organization_id"`
+	OrganizationName string    `json:"organization_name"`
+	BillingEmail     string    `json:"billing_email,omitempty"`
+	BillingAddress   string    `json:"billing_address,omitempty"`
+	Plan             string    `json:"plan"`
+	PeriodStart      time.Time `json:"period_start"`
+	PeriodEnd        time.Time `json:"period_end"`
+	IssuedAt         time.Time `json:"issued_at"`
+	Currency         string    `json:"currency"`
+	Lines            []Line    `json:"lines"`
+	Usage            Usage     `json:"usage"`
+	Total            float64   `json:"total"`
+	Paid             float64   `json:"paid"`
+	AmountDue        float64   `json:"amount_due"`
+}
+
+// Pricing holds the prices a statement is computed with
+type Pricing struct {
+	Currency string
+	// PlanPrice is the monthly fee of the organization's plan, used for
+	// periods that were not charged through the payment provider
+	PlanPrice float64
+	// OveragePricePerMinute is charged for environment minutes beyond those
+	// included in the plan
+	OveragePricePerMinute float64
+	Issuer                string
+	IssuerAddress         string
+}
+
+// Input is everything a statement is built from
+type Input struct {
+	Organization *model.Organization
+	PeriodStart  time.Time
+	PeriodEnd    time.Time
+	// Records are the billing records whose period starts within the period
+	Records []*model.BillingRecord
+	// Usage totals the period's usage records by resource type
+	Usage map[string]float64
+	// IncludedMinutes is the number of environment minutes the plan includes
+	IncludedMinutes int
+	Pricing         Pricing
+	IssuedAt        time.Time
+}
+
+// Build builds the statement for a billing period. Charges come from the
+// period's billing records: failed charges are left out and refunds are shown
+// as a negative line. A period without a renewal charge, e.g. for an
+// organization invoiced outside the payment provider, gets a plan fee line at
+// the current plan price unless the organization is on a trial.
+func Build(in Input) *Statement {
+	org := in.Organization
+	statement := &Statement{
+		Number:           Number(org.ID, in.PeriodStart),
+		Issuer:           in.Pricing.Issuer,
+		IssuerAddress:    in.Pricing.IssuerAddress,
+		OrganizationID:   org.ID,
+		OrganizationName: org.Name,
+		BillingEmail:     org.BillingEmail,
+		BillingAddress:   org.BillingAddress,
+		Plan:             org.SubscriptionPlan,
+		PeriodStart:      in.PeriodStart,
+		PeriodEnd:        in.PeriodEnd,
+		IssuedAt:         in.IssuedAt,
+		Currency:         in.Pricing.Currency,
+	}
+	if statement.BillingEmail == "" {
+		statement.BillingEmail = org.ContactEmail
+	}
+
+	renewed := false
+	for _, record := range in.Records {
+		if record.Status == model.BillingStatusFailed {
+			continue
+		}
+		if record.BillingPeriodStart.Equal(in.PeriodStart) {
+			renewed = true
+		}
+
+		statement.Lines = append(statement.Lines, Line{
+			Description: record.Description,
+			Quantity:    1,
+			UnitPrice:   record.Amount,
+			Amount:      record.Amount,
+		})
+
+		switch {
+		case record.Status == model.BillingStatusRefunded:
+			statement.Lines = append(statement.Lines, Line{
+				Description: "Refund: " + record.Description,
+				Quantity:    1,
+				UnitPrice:   -record.Amount,
+				Amount:      -record.Amount,
+			})
+		case record.Status == model.BillingStatusSuccessful && record.Amount > 0:
+			statement.Paid += record.Amount
+		}
+	}
+
+	if !renewed && org.SubscriptionStatus != model.SubscriptionStatusTrial && in.Pricing.PlanPrice > 0 {
+		statement.Lines = append([]Line{{
+			Description: fmt.Sprintf("%s plan, %s to %s", planName(org.SubscriptionPlan),
+				in.PeriodStart.Format("2006-01-02"), in.PeriodEnd.Format("2006-01-02")),
+			Quantity:  1,
+			Unit:      "month",
+			UnitPrice: in.Pricing.PlanPrice,
+			Amount:    in.Pricing.PlanPrice,
+		}}, statement.Lines...)
+	}
+
+	statement.Usage = Usage{
+		EnvironmentMinutes: int(math.Round(in.Usage[model.ResourceTypeEnvironmentMinutes])),
+		IncludedMinutes:    in.IncludedMinutes,
+		Assessments:        int(math.Round(in.Usage[model.ResourceTypeAssessmentCreated])),
+	}
+	if overage := statement.Usage.EnvironmentMinutes - in.IncludedMinutes; overage > 0 {
+		statement.Usage.OverageMinutes = overage
+		statement.Lines = append(statement.Lines, Line{
+			Description: fmt.Sprintf("Environment minutes beyond the %d included", in.IncludedMinutes),
+			Quantity:    float64(overage),
+			Unit:        "minute",
+			UnitPrice:   in.Pricing.OveragePricePerMinute,
+			Amount:      roundCents(float64(overage) * in.Pricing.OveragePricePerMinute),
+		})
+	}
+	statement.Lines = append(statement.Lines, Line{
+		Description: "Assessments created (included in plan)",
+		Quantity:    float64(statement.Usage.Assessments),
+		Unit:        "assessment",
+	})
+
+	for _, line := range statement.Lines {
+		statement.Total += line.Amount
+	}
+	statement.Total = roundCents(statement.Total)
+	statement.Paid = roundCents(statement.Paid)
+	statement.AmountDue = roundCents(statement.Total - statement.Paid)
+
+	return statement
+}
+
+// Number returns the statement number of an organization's billing period
+func Number(organizationID string, periodStart time.Time) string {
+	prefix := strings.ReplaceAll(organizationID, "-", "")
+	if len(prefix) > 8 {
+		prefix = prefix[:8]
+	}
+	return fmt.Sprintf("QD-%s-%s", strings.ToUpper(prefix), periodStart.UTC().Format("20060102"))
+}
+
+// roundCents rounds an amount to cents
+func roundCents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// planName returns the display name of a plan
+func planName(plan string) string {
+	if plan == "" {
+		return plan
+	}
+	return strings.ToUpper(plan[:1]) + plan[1:]
+}
+
+// formatAmount formats an amount with its currency, e.g. "USD 1,234.50"
+func formatAmount(currency string, amount float64) string {
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	cents := int64(math.Round(amount * 100))
+	whole := fmt.Sprintf("%d", cents/100)
+	var grouped strings.Builder
+	for i, digit := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			grouped.WriteByte(',')
+		}
+		grouped.WriteRune(digit)
+	}
+
+	return fmt.Sprintf("%s%s %s.%02d", sign, currency, grouped.String(), cents%100)
+}
+
+// formatQuantity formats a line quantity without trailing zeros
+func formatQuantity(quantity float64) string {
+	if quantity == math.Trunc(quantity) {
+		return fmt.Sprintf("%d", int64(quantity))
+	}
+	return fmt.Sprintf("%.2f", quantity)
+}
@@ -0,0 +1,147 @@
+package invoice
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/model"
+)
+
+func testOrganization() *model.Organization {
+	return &model.Organization{
+		ID:                    "3f2a9c1e-0000-4000-8000-000000000001",
+		Name:                  "Acme",
+		ContactEmail:          "billing@acme.test",
+		SubscriptionPlan:      model.OrgPlanTeam,
+		SubscriptionStatus:    model.SubscriptionStatusActive,
+		SubscriptionStartDate: time.Date(2026, 1, 15, 10, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestBuild(t *testing.T) {
+	org := testOrganization()
+	start := time.Date(2026, 3, 15, 10, 0, 0, 0, time.UTC)
+	end := time.Date(2026, 4, 15, 10, 0, 0, 0, time.UTC)
+	pricing := Pricing{Currency: "USD", PlanPrice: 199, OveragePricePerMinute: 0.02}
+	usage := map[string]float64{
+		model.ResourceTypeEnvironmentMinutes: 1250,
+		model.ResourceTypeAssessmentCreated:  7,
+	}
+
+	t.Run("without renewal charge", func(t *testing.T) {
+		s := Build(Input{Organization: org, PeriodStart: start, PeriodEnd: end, Usage: usage, IncludedMinutes: 1000, Pricing: pricing})
+
+		if s.Lines[0].Amount != 199 || s.Lines[0].Unit != "month" {
+			t.Errorf("first line = %+v, want the plan fee", s.Lines[0])
+		}
+		if s.Usage.OverageMinutes != 250 {
+			t.Errorf("OverageMinutes = %d, want 250", s.Usage.OverageMinutes)
+		}
+		if s.Usage.Assessments != 7 {
+			t.Errorf("Assessments = %d, want 7", s.Usage.Assessments)
+		}
+		if s.Total != 204 || s.Paid != 0 || s.AmountDue != 204 {
+			t.Errorf("Total, Paid, AmountDue = %v, %v, %v, want 204, 0, 204", s.Total, s.Paid, s.AmountDue)
+		}
+		if s.BillingEmail != org.ContactEmail {
+			t.Errorf("BillingEmail = %q, want the contact email", s.BillingEmail)
+		}
+	})
+
+	t.Run("with billing records", func(t *testing.T) {
+		records := []*model.BillingRecord{
+			{Amount: 199, Status: model.BillingStatusFailed, BillingPeriodStart: start, Description: "declined"},
+			{Amount: 199, Status: model.BillingStatusSuccessful, BillingPeriodStart: start, Description: "Team plan"},
+			{Amount: 50, Status: model.BillingStatusRefunded, BillingPeriodStart: start.AddDate(0, 0, 3), Description: "upgrade"},
+		}
+		s := Build(Input{Organization: org, PeriodStart: start, PeriodEnd: end, Records: records, IncludedMinutes: 1000, Pricing: pricing})
+
+		// Renewal, refunded charge, its refund and the assessments line
+		if len(s.Lines) != 4 {
+			t.Fatalf("got %d lines, want 4: %+v", len(s.Lines), s.Lines)
+		}
+		if s.Lines[2].Amount != -50 {
+			t.Errorf("refund line amount = %v, want -50", s.Lines[2].Amount)
+		}
+		if s.Total != 199 || s.Paid != 199 || s.AmountDue != 0 {
+			t.Errorf("Total, Paid, AmountDue = %v, %v, %v, want 199, 199, 0", s.Total, s.Paid, s.AmountDue)
+		}
+	})
+
+	t.Run("trial", func(t *testing.T) {
+		trial := *org
+		trial.SubscriptionStatus = model.SubscriptionStatusTrial
+		s := Build(Input{Organization: &trial, PeriodStart: start, PeriodEnd: end, IncludedMinutes: 1000, Pricing: pricing})
+
+		if s.Total != 0 || len(s.Lines) != 1 {
+			t.Errorf("trial statement has total %v and lines %+v, want only the assessments line", s.Total, s.Lines)
+		}
+	})
+}
+
+func TestRenderPDF(t *testing.T) {
+	org := testOrganization()
+	org.Name = "Acme (Europe) Société"
+	s := Build(Input{
+		Organization: org,
+		PeriodStart:  time.Date(2026, 3, 15, 10, 0, 0, 0, time.UTC),
+		PeriodEnd:    time.Date(2026, 4, 15, 10, 0, 0, 0, time.UTC),
+		Pricing:      Pricing{Currency: "USD", PlanPrice: 199},
+	})
+
+	pdf := RenderPDF(s)
+	if !bytes.HasPrefix(pdf, []byte("%PDF-1.4")) || !bytes.HasSuffix(pdf, []byte("%%EOF\n")) {
+		t.Fatal("output is not a complete PDF document")
+	}
+	if !bytes.Contains(pdf, []byte(`Acme \(Europe\) Soci\351t\351`)) {
+		t.Error("organization name is not escaped")
+	}
+
+	html, err := RenderHTML(s)
+	if err != nil {
+		t.Fatalf("RenderHTML failed: %v", err)
+	}
+	if !bytes.Contains(html, []byte("USD 199.00")) {
+		t.Error("HTML statement does not show the plan fee")
+	}
+}
+
+func TestPeriods(t *testing.T) {
+	org := testOrganization()
+	now := time.Date(2026, 4, 20, 0, 0, 0, 0, time.UTC)
+
+	periods := Periods(org, now, 24)
+	if len(periods) != 3 {
+		t.Fatalf("got %d periods, want 3", len(periods))
+	}
+	if want := time.Date(2026, 3, 15, 10, 0, 0, 0, time.UTC); !periods[0].Start.Equal(want) {
+		t.Errorf("newest period starts %v, want %v", periods[0].Start, want)
+	}
+	if !periods[2].Start.Equal(org.SubscriptionStartDate) {
+		t.Errorf("oldest period starts %v, want the subscription start", periods[2].Start)
+	}
+
+	period := PeriodOn(org, time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC))
+	if period != periods[0] {
+		t.Errorf("PeriodOn(2026-03-15) = %+v, want %+v", period, periods[0])
+	}
+}
+
+func TestFormatAmount(t *testing.T) {
+	tests := []struct {
+		amount float64
+		want   string
+	}{
+		{0, "USD 0.00"},
+		{1234.5, "USD 1,234.50"},
+		{-50, "-USD 50.00"},
+		{1000000.005, "USD 1,000,000.01"},
+	}
+
+	for _, tt := range tests {
+		if got := formatAmount("USD", tt.amount); got != tt.want {
+			t.Errorf("formatAmount(%v) = %q, want %q", tt.amount, got, tt.want)
+		}
+	}
+}
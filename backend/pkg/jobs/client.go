@@ -0,0 +1,191 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+)
+
+// staleBatchSize bounds how many lost jobs RequeueStale republishes per run
+const staleBatchSize = 100
+
+// message is the body of the RabbitMQ messages referring to a job
+type message struct {
+	JobID string `json:"job_id"`
+	Type  string `json:"type"`
+}
+
+// EnqueueOptions holds the optional attributes of an enqueued job
+type EnqueueOptions struct {
+	OrganizationID string
+	CreatedBy      string
+	// IdempotencyKey, if set, makes Enqueue return the unfinished job with
+	// the same key instead of creating another one
+	IdempotencyKey string
+}
+
+// Client enqueues jobs and follows their progress
+type Client struct {
+	store      Store
+	registry   *Registry
+	queues     *Queues
+	staleAfter time.Duration
+	log        logger.Logger
+}
+
+// NewClient creates a new Client. Queued jobs that have not been picked up
+// within staleAfter are republished by RequeueStale.
+func NewClient(store Store, registry *Registry, queues *Queues, staleAfter time.Duration, log logger.Logger) *Client {
+	return &Client{
+		store:      store,
+		registry:   registry,
+		queues:     queues,
+		staleAfter: staleAfter,
+		log:        log,
+	}
+}
+
+// Enqueue stores a job of the given type and queues it for the worker. If
+// publishing fails the job stays queued and RequeueStale publishes it later,
+// so the error is only logged.
+func (c *Client) Enqueue(ctx context.Context, jobType string, payload interface{}, opts EnqueueOptions) (*model.Job, error) {
+	definition, ok := c.registry.Definition(jobType)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownJobType, jobType)
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s payload: %w", jobType, err)
+	}
+
+	job := &model.Job{
+		Type:           jobType,
+		OrganizationID: opts.OrganizationID,
+		IdempotencyKey: opts.IdempotencyKey,
+		Payload:        body,
+		MaxAttempts:    definition.Retry.MaxAttempts,
+		CreatedBy:      opts.CreatedBy,
+	}
+	created, err := c.store.Create(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+	if !created {
+		return job, nil
+	}
+
+	if err := publishJob(ctx, c.queues.Jobs, job); err != nil {
+		c.log.Error("Failed to queue job", err, map[string]interface{}{
+			"jobID": job.ID,
+			"type":  job.Type,
+		})
+	}
+	return job, nil
+}
+
+// Wait polls a job every interval until it is finished and returns it.
+// onProgress, if not nil, is called whenever the job's progress changes.
+func (c *Client) Wait(ctx context.Context, id string, interval time.Duration, onProgress func(job *model.Job)) (*model.Job, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	lastProgress, lastMessage := -1, ""
+	for {
+		job, err := c.store.GetByID(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if job.IsFinished() {
+			return job, nil
+		}
+		if onProgress != nil && (job.Progress != lastProgress || job.ProgressMessage != lastMessage) {
+			lastProgress, lastMessage = job.Progress, job.ProgressMessage
+			onProgress(job)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// RequeueStale republishes jobs whose messages were lost: queued jobs that no
+// worker picked up, retrying jobs whose retry is overdue, and running jobs
+// whose worker died, once the longest job timeout has passed as well.
+// Republishing a job that is in fact still queued is harmless: the worker
+// only runs jobs it can claim.
+func (c *Client) RequeueStale(ctx context.Context) error {
+	var maxTimeout time.Duration
+	for _, definition := range c.registry.Definitions() {
+		if definition.Timeout > maxTimeout {
+			maxTimeout = definition.Timeout
+		}
+	}
+
+	before := time.Now().UTC().Add(-c.staleAfter)
+	stale, err := c.store.ListStale(ctx, before, before.Add(-maxTimeout), staleBatchSize)
+	if err != nil {
+		return err
+	}
+
+	for _, job := range stale {
+		if err := publishJob(ctx, c.queues.Jobs, job); err != nil {
+			return err
+		}
+		// Touching a running job would make it look alive to the worker
+		if job.Status == model.JobStatusRunning {
+			continue
+		}
+		if err := c.store.Touch(ctx, job.ID); err != nil {
+			return err
+		}
+	}
+
+	if len(stale) > 0 {
+		c.log.Warn("Requeued stale jobs", map[string]interface{}{
+			"count": len(stale),
+		})
+	}
+	return nil
+}
+
+// publishJob sends a job's message to a queue
+func publishJob(ctx context.Context, queue Publisher, job *model.Job) error {
+	body, err := json.Marshal(message{JobID: job.ID, Type: job.Type})
+	if err != nil {
+		return fmt.Errorf("failed to encode job message: %w", err)
+	}
+	if err := queue.Publish(ctx, body, "application/json", nil); err != nil {
+		return fmt.Errorf("failed to publish job: %w", err)
+	}
+	return nil
+}
+
+// ErrJobFailed is returned by Result when a job failed for good
+var ErrJobFailed = errors.New("job failed")
+
+// Result decodes a succeeded job's result into v. It returns ErrJobFailed,
+// wrapping the job's last error, if the job failed.
+func Result(job *model.Job, v interface{}) error {
+	if job.Status == model.JobStatusFailed {
+		return fmt.Errorf("%w: %s", ErrJobFailed, job.LastError)
+	}
+	if job.Status != model.JobStatusSucceeded {
+		return fmt.Errorf("job %s is %s", job.ID, job.Status)
+	}
+	if len(job.Result) == 0 || v == nil {
+		return nil
+	}
+	if err := json.Unmarshal(job.Result, v); err != nil {
+		return fmt.Errorf("failed to decode job result: %w", err)
+	}
+	return nil
+}
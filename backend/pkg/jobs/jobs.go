@@ -0,0 +1,177 @@
+// Package jobs runs background work outside the API server. The API enqueues
+// a job, which stores a row in the jobs table and publishes its ID to the
+// jobs queue. The worker (cmd/worker) consumes the queue, runs the job's
+// handler with its typed payload and records progress and the outcome on the
+// row. Failed attempts are retried according to the job type's retry policy
+// through TTL retry queues; jobs that run out of attempts end up in the
+// dead-letter queue.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+)
+
+// ErrUnknownJobType is returned when enqueueing or running a job of an
+// undefined type
+var ErrUnknownJobType = errors.New("unknown job type")
+
+// Store persists jobs; *repository.JobRepository implements it
+type Store interface {
+	Create(ctx context.Context, job *model.Job) (bool, error)
+	GetByID(ctx context.Context, id string) (*model.Job, error)
+	Claim(ctx context.Context, id string, staleBefore time.Time) (*model.Job, bool, error)
+	UpdateProgress(ctx context.Context, id string, progress int, message string) error
+	Succeed(ctx context.Context, job *model.Job, result json.RawMessage) error
+	Fail(ctx context.Context, job *model.Job, cause string, nextAttemptAt time.Time) error
+	ListStale(ctx context.Context, before, runningBefore time.Time, limit int) ([]*model.Job, error)
+	Touch(ctx context.Context, id string) error
+}
+
+// RetryPolicy decides how often and when a failed job is retried
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts, including the first one
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry; it doubles with every
+	// further attempt, up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// Delay returns the delay before retrying after the attempt-th failed attempt
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	delay := p.BaseDelay
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if p.MaxDelay > 0 && delay >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		return p.MaxDelay
+	}
+	return delay
+}
+
+// Delays returns every distinct retry delay of the policy
+func (p RetryPolicy) Delays() []time.Duration {
+	var delays []time.Duration
+	seen := make(map[time.Duration]bool)
+	for attempt := 1; attempt < p.MaxAttempts; attempt++ {
+		if d := p.Delay(attempt); !seen[d] {
+			seen[d] = true
+			delays = append(delays, d)
+		}
+	}
+	return delays
+}
+
+// Definition describes a job type
+type Definition struct {
+	Type  string
+	Retry RetryPolicy
+	// Timeout bounds one attempt
+	Timeout time.Duration
+}
+
+// permanentError marks an error that retrying cannot fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so that the job fails without further attempts
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent returns true if err was wrapped with Permanent
+func IsPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
+
+// Progress reports how far a running job got
+type Progress struct {
+	jobID string
+	store Store
+	log   logger.Logger
+}
+
+// Report stores the job's progress, a percentage, with a message shown to
+// the user. Failures are only logged; progress is informational.
+func (p *Progress) Report(ctx context.Context, percent int, message string) {
+	if p == nil || p.store == nil {
+		return
+	}
+	if err := p.store.UpdateProgress(ctx, p.jobID, percent, message); err != nil {
+		p.log.Error("Failed to report job progress", err, map[string]interface{}{
+			"jobID": p.jobID,
+		})
+	}
+}
+
+// handlerFunc runs a job with its encoded payload and returns its result
+type handlerFunc func(ctx context.Context, progress *Progress, payload json.RawMessage) (interface{}, error)
+
+// Registry holds the job type definitions and, in the worker, their handlers
+type Registry struct {
+	definitions map[string]Definition
+	handlers    map[string]handlerFunc
+}
+
+// NewRegistry creates a registry with the given job type definitions
+func NewRegistry(definitions ...Definition) *Registry {
+	r := &Registry{
+		definitions: make(map[string]Definition),
+		handlers:    make(map[string]handlerFunc),
+	}
+	for _, d := range definitions {
+		r.definitions[d.Type] = d
+	}
+	return r
+}
+
+// Definition returns the definition of a job type
+func (r *Registry) Definition(jobType string) (Definition, bool) {
+	d, ok := r.definitions[jobType]
+	return d, ok
+}
+
+// Definitions returns every job type definition
+func (r *Registry) Definitions() []Definition {
+	definitions := make([]Definition, 0, len(r.definitions))
+	for _, d := range r.definitions {
+		definitions = append(definitions, d)
+	}
+	return definitions
+}
+
+// Handle registers the handler of a job type whose payload is a P. It panics
+// if the job type is not defined, like registering a route twice would.
+func Handle[P any](r *Registry, jobType string, handle func(ctx context.Context, progress *Progress, payload P) (interface{}, error)) {
+	if _, ok := r.definitions[jobType]; !ok {
+		panic(fmt.Sprintf("jobs: handler for undefined job type %q", jobType))
+	}
+	r.handlers[jobType] = func(ctx context.Context, progress *Progress, raw json.RawMessage) (interface{}, error) {
+		var payload P
+		if err := json.Unmarshal(raw, &payload); err != nil {
+			return nil, Permanent(fmt.Errorf("invalid %s payload: %w", jobType, err))
+		}
+		return handle(ctx, progress, payload)
+	}
+}
@@ -0,0 +1,205 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/rs/zerolog"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 5, BaseDelay: 5 * time.Second, MaxDelay: 30 * time.Second}
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{0, 5 * time.Second},
+		{1, 5 * time.Second},
+		{2, 10 * time.Second},
+		{3, 20 * time.Second},
+		{4, 30 * time.Second},
+		{10, 30 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := policy.Delay(tt.attempt); got != tt.want {
+			t.Errorf("Delay(%d) = %v, want %v", tt.attempt, got, tt.want)
+		}
+	}
+
+	delays := policy.Delays()
+	if len(delays) != 4 || delays[3] != 30*time.Second {
+		t.Errorf("Delays() = %v, want [5s 10s 20s 30s]", delays)
+	}
+	if name := retryQueueName(30 * time.Second); name != "jobs.retry.30s" {
+		t.Errorf("retryQueueName() = %q", name)
+	}
+}
+
+type fakeStore struct {
+	jobs map[string]*model.Job
+}
+
+func (f *fakeStore) Create(ctx context.Context, job *model.Job) (bool, error) {
+	job.ID = "job-1"
+	job.Status = model.JobStatusQueued
+	f.jobs[job.ID] = job
+	return true, nil
+}
+
+func (f *fakeStore) GetByID(ctx context.Context, id string) (*model.Job, error) {
+	job := *f.jobs[id]
+	return &job, nil
+}
+
+func (f *fakeStore) Claim(ctx context.Context, id string, staleBefore time.Time) (*model.Job, bool, error) {
+	job := f.jobs[id]
+	if job.Status != model.JobStatusQueued && job.Status != model.JobStatusRetrying {
+		return nil, false, nil
+	}
+	job.Status = model.JobStatusRunning
+	job.Attempts++
+	claimed := *job
+	return &claimed, true, nil
+}
+
+func (f *fakeStore) UpdateProgress(ctx context.Context, id string, progress int, message string) error {
+	f.jobs[id].Progress = progress
+	f.jobs[id].ProgressMessage = message
+	return nil
+}
+
+func (f *fakeStore) Succeed(ctx context.Context, job *model.Job, result json.RawMessage) error {
+	job.Status = model.JobStatusSucceeded
+	job.Result = result
+	f.jobs[job.ID] = job
+	return nil
+}
+
+func (f *fakeStore) Fail(ctx context.Context, job *model.Job, cause string, nextAttemptAt time.Time) error {
+	job.Status = model.JobStatusRetrying
+	if nextAttemptAt.IsZero() {
+		job.Status = model.JobStatusFailed
+	}
+	job.LastError = cause
+	f.jobs[job.ID] = job
+	return nil
+}
+
+func (f *fakeStore) ListStale(ctx context.Context, before, runningBefore time.Time, limit int) ([]*model.Job, error) {
+	return nil, nil
+}
+
+func (f *fakeStore) Touch(ctx context.Context, id string) error {
+	return nil
+}
+
+type fakePublisher struct {
+	messages [][]byte
+}
+
+func (f *fakePublisher) Publish(ctx context.Context, body []byte, contentType string, headers amqp.Table) error {
+	f.messages = append(f.messages, body)
+	return nil
+}
+
+type echoPayload struct {
+	Text string `json:"text"`
+}
+
+func TestWorkerRetriesThenSucceeds(t *testing.T) {
+	definition := Definition{
+		Type:    "test.echo",
+		Retry:   RetryPolicy{MaxAttempts: 2, BaseDelay: time.Second},
+		Timeout: time.Second,
+	}
+	registry := NewRegistry(definition)
+	calls := 0
+	Handle(registry, "test.echo", func(ctx context.Context, progress *Progress, p echoPayload) (interface{}, error) {
+		calls++
+		progress.Report(ctx, 50, "halfway")
+		if calls == 1 {
+			return nil, errors.New("temporary failure")
+		}
+		return map[string]string{"echo": p.Text}, nil
+	})
+
+	store := &fakeStore{jobs: make(map[string]*model.Job)}
+	jobQueue, deadLetter, retry := &fakePublisher{}, &fakePublisher{}, &fakePublisher{}
+	queues := &Queues{Jobs: jobQueue, DeadLetter: deadLetter, Retry: map[time.Duration]Publisher{time.Second: retry}}
+	log := logger.NewLogger(zerolog.Nop())
+
+	client := NewClient(store, registry, queues, time.Minute, log)
+	job, err := client.Enqueue(context.Background(), "test.echo", echoPayload{Text: "hi"}, EnqueueOptions{})
+	if err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	if job.MaxAttempts != 2 || len(jobQueue.messages) != 1 {
+		t.Fatalf("Enqueue() job = %+v, %d messages", job, len(jobQueue.messages))
+	}
+
+	worker := NewWorker(store, registry, queues, time.Minute, log)
+	msg := amqp.Delivery{Body: jobQueue.messages[0]}
+	if err := worker.Handle(context.Background(), msg); err != nil {
+		t.Fatalf("Handle() error = %v", err)
+	}
+	if store.jobs[job.ID].Status != model.JobStatusRetrying || len(retry.messages) != 1 {
+		t.Fatalf("after first attempt: status = %s, %d retries", store.jobs[job.ID].Status, len(retry.messages))
+	}
+
+	if err := worker.Handle(context.Background(), msg); err != nil {
+		t.Fatalf("Handle() error = %v", err)
+	}
+	var result map[string]string
+	if err := Result(store.jobs[job.ID], &result); err != nil || result["echo"] != "hi" {
+		t.Fatalf("Result() = %v, %v", result, err)
+	}
+
+	// A duplicate message of a finished job is dropped
+	if err := worker.Handle(context.Background(), msg); err != nil || calls != 2 {
+		t.Errorf("Handle() of duplicate = %v, %d calls", err, calls)
+	}
+	if len(deadLetter.messages) != 0 {
+		t.Errorf("dead letters = %d, want 0", len(deadLetter.messages))
+	}
+}
+
+func TestWorkerDeadLettersPermanentFailures(t *testing.T) {
+	definition := Definition{Type: "test.fail", Retry: RetryPolicy{MaxAttempts: 5, BaseDelay: time.Second}}
+	registry := NewRegistry(definition)
+	Handle(registry, "test.fail", func(ctx context.Context, progress *Progress, p echoPayload) (interface{}, error) {
+		panic("boom")
+	})
+
+	store := &fakeStore{jobs: map[string]*model.Job{
+		"job-1": {ID: "job-1", Type: "test.fail", Status: model.JobStatusQueued, Payload: json.RawMessage(`{}`), MaxAttempts: 5},
+		"job-2": {ID: "job-2", Type: "test.fail", Status: model.JobStatusQueued, Payload: json.RawMessage(`"not an object"`), MaxAttempts: 5},
+	}}
+	deadLetter, retry := &fakePublisher{}, &fakePublisher{}
+	queues := &Queues{DeadLetter: deadLetter, Retry: map[time.Duration]Publisher{time.Second: retry}}
+	worker := NewWorker(store, registry, queues, time.Minute, logger.NewLogger(zerolog.Nop()))
+
+	// A panic is an ordinary failure and is retried
+	if err := worker.Handle(context.Background(), amqp.Delivery{Body: []byte(`{"job_id":"job-1","type":"test.fail"}`)}); err != nil {
+		t.Fatalf("Handle() error = %v", err)
+	}
+	if store.jobs["job-1"].Status != model.JobStatusRetrying || len(retry.messages) != 1 {
+		t.Errorf("panicking job status = %s, %d retries", store.jobs["job-1"].Status, len(retry.messages))
+	}
+
+	// An undecodable payload is not
+	if err := worker.Handle(context.Background(), amqp.Delivery{Body: []byte(`{"job_id":"job-2","type":"test.fail"}`)}); err != nil {
+		t.Fatalf("Handle() error = %v", err)
+	}
+	if store.jobs["job-2"].Status != model.JobStatusFailed || len(deadLetter.messages) != 1 {
+		t.Errorf("invalid payload job status = %s, %d dead letters", store.jobs["job-2"].Status, len(deadLetter.messages))
+	}
+	if err := Result(store.jobs["job-2"], nil); !errors.Is(err, ErrJobFailed) {
+		t.Errorf("Result() error = %v, want %v", err, ErrJobFailed)
+	}
+}
@@ -0,0 +1,147 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/k8s"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+)
+
+// TypeTerminalProvision creates the terminal pod of an assessment session
+const TypeTerminalProvision = "terminal.provision"
+
+// TerminalProvision is the definition of the terminal provisioning job.
+// Candidates wait for it, so it is retried quickly and only a few times.
+var TerminalProvision = Definition{
+	Type:    TypeTerminalProvision,
+	Retry:   RetryPolicy{MaxAttempts: 3, BaseDelay: 5 * time.Second, MaxDelay: 30 * time.Second},
+	Timeout: 2 * time.Minute,
+}
+
+// Definitions returns the definitions of every job type
+func Definitions() []Definition {
+	return []Definition{
+		TerminalProvision,
+	}
+}
+
+// terminalWaitTimeout bounds how long a terminal waits for its provisioning
+// job, in case no worker is running
+const terminalWaitTimeout = 5 * time.Minute
+
+// terminalPollInterval is how often a terminal checks its provisioning job
+const terminalPollInterval = 500 * time.Millisecond
+
+// TerminalProvisionPayload is the payload of the terminal provisioning job
+type TerminalProvisionPayload struct {
+	AssessmentID string `json:"assessment_id"`
+	SessionID    string `json:"session_id"`
+	TemplateType string `json:"template_type,omitempty"`
+	Image        string `json:"image,omitempty"`
+	CPU          string `json:"cpu,omitempty"`
+	Memory       string `json:"memory,omitempty"`
+}
+
+// TerminalProvisionResult is the result of the terminal provisioning job
+type TerminalProvisionResult struct {
+	PodName string `json:"pod_name"`
+}
+
+// HandleTerminalProvision registers the terminal provisioning handler. A
+// retried job reuses the pod created by an earlier attempt.
+func HandleTerminalProvision(registry *Registry, k8sClient *k8s.Client) {
+	Handle(registry, TypeTerminalProvision, func(ctx context.Context, progress *Progress, p TerminalProvisionPayload) (interface{}, error) {
+		if p.AssessmentID == "" || p.SessionID == "" {
+			return nil, Permanent(errors.New("assessment_id and session_id are required"))
+		}
+
+		if pod, err := k8sClient.GetTerminalPod(ctx, p.AssessmentID, p.SessionID); err == nil {
+			return &TerminalProvisionResult{PodName: pod.Name}, nil
+		}
+
+		progress.Report(ctx, 10, "Creating terminal pod...")
+		pod, err := k8sClient.CreateTerminalPod(ctx, &k8s.TerminalPodConfig{
+			AssessmentID: p.AssessmentID,
+			SessionID:    p.SessionID,
+			TemplateType: p.TemplateType,
+			Image:        p.Image,
+			CPU:          p.CPU,
+			Memory:       p.Memory,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create terminal pod: %w", err)
+		}
+
+		return &TerminalProvisionResult{PodName: pod.Name}, nil
+	})
+}
+
+// OrganizationResolver returns the organization an assessment belongs to;
+// *repository.AssessmentRepository implements it
+type OrganizationResolver interface {
+	ResolveOrganization(ctx context.Context, id string) (organizationID, status string, err error)
+}
+
+// TerminalProvisioner provisions terminal pods through the job queue, so
+// that they are created by the worker
+type TerminalProvisioner struct {
+	client      *Client
+	assessments OrganizationResolver
+}
+
+// NewTerminalProvisioner creates a new TerminalProvisioner
+func NewTerminalProvisioner(client *Client, assessments OrganizationResolver) *TerminalProvisioner {
+	return &TerminalProvisioner{
+		client:      client,
+		assessments: assessments,
+	}
+}
+
+// ProvisionTerminalPod enqueues a provisioning job for the pod described by
+// config and waits for it, calling onProgress with its progress messages. A
+// terminal reconnecting while its pod is provisioned joins the running job.
+func (p *TerminalProvisioner) ProvisionTerminalPod(ctx context.Context, config *k8s.TerminalPodConfig, onProgress func(message string)) (string, error) {
+	organizationID, _, err := p.assessments.ResolveOrganization(ctx, config.AssessmentID)
+	if err != nil {
+		return "", err
+	}
+
+	job, err := p.client.Enqueue(ctx, TypeTerminalProvision, &TerminalProvisionPayload{
+		AssessmentID: config.AssessmentID,
+		SessionID:    config.SessionID,
+		TemplateType: config.TemplateType,
+		Image:        config.Image,
+		CPU:          config.CPU,
+		Memory:       config.Memory,
+	}, EnqueueOptions{
+		OrganizationID: organizationID,
+		IdempotencyKey: fmt.Sprintf("%s:%s:%s", TypeTerminalProvision, config.AssessmentID, config.SessionID),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, terminalWaitTimeout)
+	defer cancel()
+
+	job, err = p.client.Wait(ctx, job.ID, terminalPollInterval, func(job *model.Job) {
+		if onProgress != nil && job.ProgressMessage != "" {
+			onProgress(job.ProgressMessage)
+		}
+	})
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return "", fmt.Errorf("terminal provisioning did not finish within %s", terminalWaitTimeout)
+		}
+		return "", err
+	}
+
+	var result TerminalProvisionResult
+	if err := Result(job, &result); err != nil {
+		return "", err
+	}
+	return result.PodName, nil
+}
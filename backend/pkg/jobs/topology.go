@@ -0,0 +1,96 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/rabbitmq"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// RabbitMQ topology of the job queue. Jobs are published to the jobs queue.
+// A failed attempt is parked in the retry queue of its delay, whose messages
+// expire back into the jobs queue. Jobs that failed for good end up in the
+// dead-letter queue.
+const (
+	Exchange        = "jobs"
+	Queue           = "jobs"
+	DeadLetterQueue = "jobs.dead"
+
+	runRoutingKey  = "run"
+	deadRoutingKey = "dead"
+)
+
+// Publisher publishes a message to RabbitMQ; *rabbitmq.Publisher implements it
+type Publisher interface {
+	Publish(ctx context.Context, body []byte, contentType string, headers amqp.Table) error
+}
+
+// Queues holds the publishers of the job queues
+type Queues struct {
+	Jobs       Publisher
+	DeadLetter Publisher
+	// Retry holds the publisher of each retry queue, keyed by its delay
+	Retry map[time.Duration]Publisher
+}
+
+// retryQueueName returns the name of the retry queue of delay
+func retryQueueName(delay time.Duration) string {
+	return fmt.Sprintf("jobs.retry.%ds", int64(delay/time.Second))
+}
+
+// Setup declares the job exchange and queues on ch, including a retry queue
+// for every delay of the registry's retry policies, and returns their
+// publishers
+func Setup(ch *rabbitmq.Channel, registry *Registry) (*Queues, error) {
+	if err := ch.DeclareExchange(Exchange, amqp.ExchangeDirect, true, false, false, false, nil); err != nil {
+		return nil, err
+	}
+
+	// Messages rejected by the worker are dead-lettered as well
+	if _, err := ch.DeclareQueue(Queue, true, false, false, false, amqp.Table{
+		"x-dead-letter-exchange":    Exchange,
+		"x-dead-letter-routing-key": deadRoutingKey,
+	}); err != nil {
+		return nil, err
+	}
+	if err := ch.BindQueue(Queue, runRoutingKey, Exchange, false, nil); err != nil {
+		return nil, err
+	}
+
+	if _, err := ch.DeclareQueue(DeadLetterQueue, true, false, false, false, nil); err != nil {
+		return nil, err
+	}
+	if err := ch.BindQueue(DeadLetterQueue, deadRoutingKey, Exchange, false, nil); err != nil {
+		return nil, err
+	}
+
+	queues := &Queues{
+		Jobs:       ch.NewPublisher(Exchange, runRoutingKey),
+		DeadLetter: ch.NewPublisher(Exchange, deadRoutingKey),
+		Retry:      make(map[time.Duration]Publisher),
+	}
+
+	for _, definition := range registry.Definitions() {
+		for _, delay := range definition.Retry.Delays() {
+			if _, ok := queues.Retry[delay]; ok {
+				continue
+			}
+			name := retryQueueName(delay)
+			if _, err := ch.DeclareQueue(name, true, false, false, false, amqp.Table{
+				"x-message-ttl":             delay.Milliseconds(),
+				"x-dead-letter-exchange":    Exchange,
+				"x-dead-letter-routing-key": runRoutingKey,
+			}); err != nil {
+				return nil, err
+			}
+			if err := ch.BindQueue(name, name, Exchange, false, nil); err != nil {
+				return nil, err
+			}
+			queues.Retry[delay] = ch.NewPublisher(Exchange, name)
+		}
+	}
+
+	return queues, nil
+}
@@ -0,0 +1,146 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Worker consumes the jobs queue and runs each job with its type's handler
+type Worker struct {
+	store    Store
+	registry *Registry
+	queues   *Queues
+	// grace is added to a job's timeout before a running job whose worker
+	// went silent may be claimed by another one
+	grace time.Duration
+	log   logger.Logger
+}
+
+// NewWorker creates a new Worker running the handlers of registry
+func NewWorker(store Store, registry *Registry, queues *Queues, grace time.Duration, log logger.Logger) *Worker {
+	return &Worker{
+		store:    store,
+		registry: registry,
+		queues:   queues,
+		grace:    grace,
+		log:      log,
+	}
+}
+
+// Handle runs one attempt of a queued job and schedules what comes next:
+// nothing on success, a retry after the policy's delay, or the dead-letter
+// queue once the attempts are exhausted or the error is permanent. It only
+// returns an error, making the consumer requeue the message, if the outcome
+// could not be stored.
+func (w *Worker) Handle(ctx context.Context, msg amqp.Delivery) error {
+	var m message
+	if err := json.Unmarshal(msg.Body, &m); err != nil || m.JobID == "" {
+		w.log.Error("Dropping malformed job message", err, map[string]interface{}{
+			"messageId": msg.MessageId,
+		})
+		return w.queues.DeadLetter.Publish(ctx, msg.Body, msg.ContentType, nil)
+	}
+
+	definition, ok := w.registry.Definition(m.Type)
+	handle := w.registry.handlers[m.Type]
+	staleBefore := time.Now().UTC().Add(-(definition.Timeout + w.grace))
+
+	job, claimed, err := w.store.Claim(ctx, m.JobID, staleBefore)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		// Finished, or running on another worker; the message is a duplicate
+		return nil
+	}
+
+	var result interface{}
+	if !ok || handle == nil {
+		err = Permanent(fmt.Errorf("%w: %s", ErrUnknownJobType, job.Type))
+	} else {
+		result, err = w.run(ctx, definition, handle, job)
+	}
+
+	if err == nil {
+		var body json.RawMessage
+		if result != nil {
+			if body, err = json.Marshal(result); err != nil {
+				err = Permanent(fmt.Errorf("failed to encode job result: %w", err))
+			}
+		}
+		if err == nil {
+			return w.store.Succeed(ctx, job, body)
+		}
+	}
+
+	return w.fail(ctx, definition, job, msg.Body, err)
+}
+
+// run calls a job's handler with the type's timeout, turning panics into
+// errors
+func (w *Worker) run(ctx context.Context, definition Definition, handle handlerFunc, job *model.Job) (result interface{}, err error) {
+	if definition.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, definition.Timeout)
+		defer cancel()
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			w.log.Error("Job panicked", fmt.Errorf("%v", r), map[string]interface{}{
+				"jobID": job.ID,
+				"type":  job.Type,
+				"stack": string(debug.Stack()),
+			})
+			result, err = nil, fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+
+	return handle(ctx, &Progress{jobID: job.ID, store: w.store, log: w.log}, job.Payload)
+}
+
+// fail records a failed attempt and queues the job's retry, or dead-letters
+// it if it may not be retried
+func (w *Worker) fail(ctx context.Context, definition Definition, job *model.Job, body []byte, cause error) error {
+	delay := definition.Retry.Delay(job.Attempts)
+	retry := w.queues.Retry[delay]
+	if IsPermanent(cause) || job.Attempts >= job.MaxAttempts || retry == nil {
+		if err := w.store.Fail(ctx, job, cause.Error(), time.Time{}); err != nil {
+			return err
+		}
+		w.log.Warn("Job failed permanently", map[string]interface{}{
+			"jobID":          job.ID,
+			"type":           job.Type,
+			"organizationID": job.OrganizationID,
+			"attempts":       job.Attempts,
+			"lastError":      job.LastError,
+		})
+		// The job row already records the failure, so a lost dead letter is
+		// only logged
+		if err := w.queues.DeadLetter.Publish(ctx, body, "application/json", nil); err != nil {
+			w.log.Error("Failed to dead-letter job", err, map[string]interface{}{
+				"jobID": job.ID,
+			})
+		}
+		return nil
+	}
+
+	if err := w.store.Fail(ctx, job, cause.Error(), time.Now().UTC().Add(delay)); err != nil {
+		return err
+	}
+	// Requeueing the message would run the job again right away. It is left
+	// retrying instead, and RequeueStale picks it up once it is overdue.
+	if err := retry.Publish(ctx, body, "application/json", nil); err != nil {
+		w.log.Error("Failed to schedule job retry", err, map[string]interface{}{
+			"jobID": job.ID,
+		})
+	}
+	return nil
+}
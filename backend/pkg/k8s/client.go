@@ -7,6 +7,10 @@ import (
 	"path/filepath"
 
 	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -18,8 +22,14 @@ type Client struct {
 	clientset *kubernetes.Clientset
 	namespace string
 	log       logger.Logger
+
+	onPodDeleted []PodDeletedFunc
 }
 
+// PodDeletedFunc is called after the client deletes a terminal pod, with the
+// pod as it was just before deletion
+type PodDeletedFunc func(ctx context.Context, pod *corev1.Pod)
+
 // ClientOption is a functional option for configuring the Kubernetes client
 type ClientOption func(*Client)
 
@@ -37,6 +47,19 @@ func WithLogger(log logger.Logger) ClientOption {
 	}
 }
 
+// OnTerminalPodDeleted registers fn to be called whenever the client deletes a
+// terminal pod. It must be called before the client is shared between goroutines.
+func (c *Client) OnTerminalPodDeleted(fn PodDeletedFunc) {
+	c.onPodDeleted = append(c.onPodDeleted, fn)
+}
+
+// notifyPodDeleted calls the registered PodDeletedFuncs
+func (c *Client) notifyPodDeleted(ctx context.Context, pod *corev1.Pod) {
+	for _, fn := range c.onPodDeleted {
+		fn(ctx, pod)
+	}
+}
+
 // NewClient creates a new Kubernetes client
 func NewClient(log logger.Logger, namespace string) (*Client, error) {
 	client := &Client{
@@ -108,3 +131,18 @@ func getEnvOrDefault(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// startSpan starts a client span for a Kubernetes API operation
+func (c *Client) startSpan(ctx context.Context, operation string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs, attribute.String("k8s.namespace.name", c.namespace))
+	return tracing.Tracer("k8s").Start(ctx, "k8s."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+// endSpan records err on the span and ends it
+func endSpan(span trace.Span, err error) {
+	tracing.RecordError(span, err)
+	span.End()
+}
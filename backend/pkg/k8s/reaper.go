@@ -0,0 +1,123 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/metrics"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RunPodReaper periodically deletes idle terminal pods until ctx is cancelled
+func (c *Client) RunPodReaper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	c.log.Info("Terminal pod reaper started", map[string]interface{}{
+		"namespace": c.namespace,
+		"interval":  interval.String(),
+	})
+
+	for {
+		select {
+		case <-ctx.Done():
+			c.log.Info("Terminal pod reaper stopped", map[string]interface{}{
+				"namespace": c.namespace,
+			})
+			return
+		case <-ticker.C:
+			if _, err := c.ReapIdleTerminalPods(ctx); err != nil {
+				c.log.Error("Failed to reap idle terminal pods", err, map[string]interface{}{
+					"namespace": c.namespace,
+				})
+			}
+		}
+	}
+}
+
+// ReapIdleTerminalPods deletes terminal pods whose last activity is older than
+// their TTL annotation and returns the number of pods deleted
+func (c *Client) ReapIdleTerminalPods(ctx context.Context) (_ int, err error) {
+	ctx, span := c.startSpan(ctx, "ReapIdleTerminalPods")
+	defer func() { endSpan(span, err) }()
+
+	pods, err := c.ListAllTerminalPods(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	deleted := 0
+	for i := range pods {
+		pod := &pods[i]
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+
+		idleSince, ttl, err := podIdleState(pod)
+		if err != nil {
+			metrics.PodReaperActions.WithLabelValues(metrics.ReaperInvalid).Inc()
+			c.log.Warn("Skipping terminal pod with invalid activity annotations", map[string]interface{}{
+				"podName": pod.Name,
+				"error":   err.Error(),
+			})
+			continue
+		}
+
+		if now.Sub(idleSince) < ttl {
+			continue
+		}
+
+		gracePeriodSeconds := int64(0)
+		err = c.clientset.CoreV1().Pods(c.namespace).Delete(ctx, pod.Name, metav1.DeleteOptions{
+			GracePeriodSeconds: &gracePeriodSeconds,
+		})
+		if err != nil && !apierrors.IsNotFound(err) {
+			metrics.PodReaperActions.WithLabelValues(metrics.ReaperDeleteFailed).Inc()
+			c.log.Error("Failed to delete idle terminal pod", err, map[string]interface{}{
+				"podName": pod.Name,
+			})
+			continue
+		}
+		if err == nil {
+			c.notifyPodDeleted(ctx, pod)
+		}
+
+		deleted++
+		metrics.PodReaperActions.WithLabelValues(metrics.ReaperDeleted).Inc()
+		c.log.Info("Deleted idle terminal pod", map[string]interface{}{
+			"podName":      pod.Name,
+			"assessmentID": pod.Labels[AssessmentIDLabelKey],
+			"sessionID":    pod.Labels[SessionIDLabelKey],
+			"idleFor":      now.Sub(idleSince).Round(time.Second).String(),
+		})
+	}
+
+	return deleted, nil
+}
+
+// podIdleState returns when the pod was last active and how long it may stay idle
+func podIdleState(pod *corev1.Pod) (time.Time, time.Duration, error) {
+	idleSince := pod.CreationTimestamp.Time
+	if value, ok := pod.Annotations["qualifyd.io/"+LastActivityAnnotationKey]; ok {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return time.Time{}, 0, fmt.Errorf("invalid last-activity annotation %q: %w", value, err)
+		}
+		idleSince = parsed
+	}
+
+	ttl := PodTTL
+	if value, ok := pod.Annotations["qualifyd.io/"+TTLAnnotationKey]; ok {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return time.Time{}, 0, fmt.Errorf("invalid ttl annotation %q: %w", value, err)
+		}
+		ttl = parsed
+	}
+
+	return idleSince, ttl, nil
+}
@@ -7,6 +7,8 @@ import (
 	"path/filepath"
 	"time"
 
+	"github.com/cstanislawski/qualifyd/pkg/metrics"
+	"go.opentelemetry.io/otel/attribute"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -45,6 +47,9 @@ const (
 	LastActivityAnnotationKey = "last-activity"
 	// TTLAnnotationKey is the key for the TTL annotation
 	TTLAnnotationKey = "ttl"
+	// ClaimedAtAnnotationKey is the key for the claimed-at annotation, set when
+	// a pre-started pod from a warm pool is assigned to an assessment
+	ClaimedAtAnnotationKey = "claimed-at"
 )
 
 // TerminalPodConfig contains configuration for creating a terminal pod
@@ -60,7 +65,10 @@ type TerminalPodConfig struct {
 }
 
 // GetTerminalPod retrieves a terminal pod by assessment ID and session ID
-func (c *Client) GetTerminalPod(ctx context.Context, assessmentID, sessionID string) (*corev1.Pod, error) {
+func (c *Client) GetTerminalPod(ctx context.Context, assessmentID, sessionID string) (_ *corev1.Pod, err error) {
+	ctx, span := c.startSpan(ctx, "GetTerminalPod", attribute.String("assessment.id", assessmentID), attribute.String("session.id", sessionID))
+	defer func() { endSpan(span, err) }()
+
 	// Define label selector to find the terminal pod for this assessment and session
 	labelSelector := fmt.Sprintf("%s=%s,%s=%s,%s=%s",
 		TerminalLabelKey, TerminalLabelValue,
@@ -104,7 +112,10 @@ func (c *Client) loadPodTemplate(templateType string) (*corev1.Pod, error) {
 }
 
 // CreateTerminalPod creates a new terminal pod
-func (c *Client) CreateTerminalPod(ctx context.Context, config *TerminalPodConfig) (*corev1.Pod, error) {
+func (c *Client) CreateTerminalPod(ctx context.Context, config *TerminalPodConfig) (_ *corev1.Pod, err error) {
+	ctx, span := c.startSpan(ctx, "CreateTerminalPod", attribute.String("assessment.id", config.AssessmentID), attribute.String("session.id", config.SessionID), attribute.String("template.type", config.TemplateType))
+	defer func() { endSpan(span, err) }()
+
 	if config.AssessmentID == "" {
 		return nil, fmt.Errorf("assessment ID is required")
 	}
@@ -182,7 +193,10 @@ func (c *Client) CreateTerminalPod(ctx context.Context, config *TerminalPodConfi
 	}
 
 	// Create the pod
+	createStart := time.Now()
 	created, err := c.clientset.CoreV1().Pods(c.namespace).Create(ctx, pod, metav1.CreateOptions{})
+	metrics.TerminalProvisioningDuration.WithLabelValues(metrics.StagePodCreate, metrics.Result(err)).
+		Observe(time.Since(createStart).Seconds())
 	if err != nil {
 		return nil, fmt.Errorf("failed to create terminal pod: %w", err)
 	}
@@ -195,7 +209,11 @@ func (c *Client) CreateTerminalPod(ctx context.Context, config *TerminalPodConfi
 	})
 
 	// Wait for the pod to be ready
-	if err := c.WaitForPodReady(ctx, created.Name); err != nil {
+	readyStart := time.Now()
+	err = c.WaitForPodReady(ctx, created.Name)
+	metrics.TerminalProvisioningDuration.WithLabelValues(metrics.StagePodReady, metrics.Result(err)).
+		Observe(time.Since(readyStart).Seconds())
+	if err != nil {
 		// Don't delete the pod if it's not ready; let it be investigated
 		return nil, fmt.Errorf("terminal pod not ready: %w", err)
 	}
@@ -204,7 +222,10 @@ func (c *Client) CreateTerminalPod(ctx context.Context, config *TerminalPodConfi
 }
 
 // UpdatePodActivity updates the last activity timestamp for a pod
-func (c *Client) UpdatePodActivity(ctx context.Context, podName string) error {
+func (c *Client) UpdatePodActivity(ctx context.Context, podName string) (err error) {
+	ctx, span := c.startSpan(ctx, "UpdatePodActivity", attribute.String("k8s.pod.name", podName))
+	defer func() { endSpan(span, err) }()
+
 	pod, err := c.clientset.CoreV1().Pods(c.namespace).Get(ctx, podName, metav1.GetOptions{})
 	if err != nil {
 		return fmt.Errorf("failed to get pod: %w", err)
@@ -225,7 +246,10 @@ func (c *Client) UpdatePodActivity(ctx context.Context, podName string) error {
 }
 
 // DeleteTerminalPod deletes a terminal pod by assessment ID and session ID
-func (c *Client) DeleteTerminalPod(ctx context.Context, assessmentID, sessionID string) error {
+func (c *Client) DeleteTerminalPod(ctx context.Context, assessmentID, sessionID string) (err error) {
+	ctx, span := c.startSpan(ctx, "DeleteTerminalPod", attribute.String("assessment.id", assessmentID), attribute.String("session.id", sessionID))
+	defer func() { endSpan(span, err) }()
+
 	// Get the pod first
 	pod, err := c.GetTerminalPod(ctx, assessmentID, sessionID)
 	if err != nil {
@@ -251,6 +275,7 @@ func (c *Client) DeleteTerminalPod(ctx context.Context, assessmentID, sessionID
 	if err != nil {
 		return fmt.Errorf("failed to delete terminal pod: %w", err)
 	}
+	c.notifyPodDeleted(ctx, pod)
 
 	c.log.Info("Terminal pod deleted", map[string]interface{}{
 		"assessmentID": assessmentID,
@@ -263,7 +288,10 @@ func (c *Client) DeleteTerminalPod(ctx context.Context, assessmentID, sessionID
 }
 
 // GetPodIP returns the pod IP address
-func (c *Client) GetPodIP(ctx context.Context, podName string) (string, error) {
+func (c *Client) GetPodIP(ctx context.Context, podName string) (_ string, err error) {
+	ctx, span := c.startSpan(ctx, "GetPodIP", attribute.String("k8s.pod.name", podName))
+	defer func() { endSpan(span, err) }()
+
 	pod, err := c.clientset.CoreV1().Pods(c.namespace).Get(ctx, podName, metav1.GetOptions{})
 	if err != nil {
 		return "", fmt.Errorf("failed to get pod: %w", err)
@@ -277,7 +305,10 @@ func (c *Client) GetPodIP(ctx context.Context, podName string) (string, error) {
 }
 
 // WaitForPodReady waits for a pod to be in the ready state
-func (c *Client) WaitForPodReady(ctx context.Context, podName string) error {
+func (c *Client) WaitForPodReady(ctx context.Context, podName string) (err error) {
+	ctx, span := c.startSpan(ctx, "WaitForPodReady", attribute.String("k8s.pod.name", podName))
+	defer func() { endSpan(span, err) }()
+
 	c.log.Info("Waiting for pod to be ready", map[string]interface{}{
 		"podName":   podName,
 		"namespace": c.namespace,
@@ -312,7 +343,10 @@ func (c *Client) WaitForPodReady(ctx context.Context, podName string) error {
 }
 
 // ListTerminalPods retrieves all terminal pods for a given assessment ID
-func (c *Client) ListTerminalPods(ctx context.Context, assessmentID string) ([]corev1.Pod, error) {
+func (c *Client) ListTerminalPods(ctx context.Context, assessmentID string) (_ []corev1.Pod, err error) {
+	ctx, span := c.startSpan(ctx, "ListTerminalPods", attribute.String("assessment.id", assessmentID))
+	defer func() { endSpan(span, err) }()
+
 	// Define label selector to find terminal pods for this assessment
 	labelSelector := fmt.Sprintf("%s=%s,%s=%s",
 		TerminalLabelKey, TerminalLabelValue,
@@ -327,3 +361,18 @@ func (c *Client) ListTerminalPods(ctx context.Context, assessmentID string) ([]c
 
 	return pods.Items, nil
 }
+
+// ListAllTerminalPods retrieves every terminal pod in the namespace
+func (c *Client) ListAllTerminalPods(ctx context.Context) (_ []corev1.Pod, err error) {
+	ctx, span := c.startSpan(ctx, "ListAllTerminalPods")
+	defer func() { endSpan(span, err) }()
+
+	pods, err := c.clientset.CoreV1().Pods(c.namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", TerminalLabelKey, TerminalLabelValue),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list terminal pods: %w", err)
+	}
+
+	return pods.Items, nil
+}
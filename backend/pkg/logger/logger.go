@@ -46,6 +46,8 @@ func standardFields() []string {
 		"proto",     // HTTP protocol
 		"bytes",     // response size
 		"userAgent", // user agent
+		"traceID",   // OpenTelemetry trace ID
+		"spanID",    // OpenTelemetry span ID
 	}
 }
 
@@ -0,0 +1,23 @@
+package logger
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// WithTraceContext adds the trace and span IDs of the span in ctx to fields so
+// log lines can be correlated with traces. fields may be nil.
+func WithTraceContext(ctx context.Context, fields map[string]interface{}) map[string]interface{} {
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.IsValid() {
+		return fields
+	}
+
+	if fields == nil {
+		fields = make(map[string]interface{}, 2)
+	}
+	fields["traceID"] = spanContext.TraceID().String()
+	fields["spanID"] = spanContext.SpanID().String()
+	return fields
+}
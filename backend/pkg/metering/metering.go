@@ -0,0 +1,347 @@
+// Package metering bills environment minutes from the real lifetime of
+// terminal pods.
+//
+// Every terminal pod gets an environment_sessions row the first time the meter
+// sees it. Each tick bills the whole minutes the pod has run since the
+// session's metered_until, writing a usage record and adding the minutes to
+// the organization's quota in one transaction. When the pod is deleted the
+// remaining partial minute is billed and the session is stopped. Advancing
+// metered_until is a compare-and-set, so a tick racing a teardown never bills
+// the same interval twice.
+package metering
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/config"
+	"github.com/cstanislawski/qualifyd/pkg/database"
+	"github.com/cstanislawski/qualifyd/pkg/k8s"
+	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/metrics"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/cstanislawski/qualifyd/pkg/repository"
+	"github.com/jackc/pgx/v5"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Meter bills environment minutes and stops environments that exceed the
+// organization's maximum runtime
+type Meter struct {
+	db             *database.Database
+	k8sClient      *k8s.Client
+	usageRepo      *repository.UsageRepository
+	quotaRepo      *repository.QuotaRepository
+	assessmentRepo *repository.AssessmentRepository
+	cfg            *config.MeteringConfig
+	log            logger.Logger
+}
+
+// NewMeter creates a new meter and registers it to bill terminal pods deleted
+// through k8sClient
+func NewMeter(
+	db *database.Database,
+	k8sClient *k8s.Client,
+	usageRepo *repository.UsageRepository,
+	quotaRepo *repository.QuotaRepository,
+	assessmentRepo *repository.AssessmentRepository,
+	cfg *config.MeteringConfig,
+	log logger.Logger,
+) *Meter {
+	m := &Meter{
+		db:             db,
+		k8sClient:      k8sClient,
+		usageRepo:      usageRepo,
+		quotaRepo:      quotaRepo,
+		assessmentRepo: assessmentRepo,
+		cfg:            cfg,
+		log:            log,
+	}
+	k8sClient.OnTerminalPodDeleted(m.podDeleted)
+	return m
+}
+
+// Run meters running terminal pods every interval until ctx is cancelled
+func (m *Meter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	m.log.Info("Environment meter started", map[string]interface{}{
+		"interval":     interval.String(),
+		"billWarmPool": m.cfg.BillWarmPool,
+	})
+
+	for {
+		select {
+		case <-ctx.Done():
+			m.log.Info("Environment meter stopped", nil)
+			return
+		case <-ticker.C:
+			if err := m.MeterPods(ctx); err != nil {
+				m.log.Error("Failed to meter terminal pods", err, nil)
+			}
+		}
+	}
+}
+
+// MeterPods bills every running terminal pod up to now, stops pods that have
+// exceeded their organization's maximum runtime and closes the sessions of
+// pods that disappeared without going through the client
+func (m *Meter) MeterPods(ctx context.Context) error {
+	pods, err := m.k8sClient.ListAllTerminalPods(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	running := make(map[string]bool, len(pods))
+	quotas := make(map[string]*model.OrganizationQuota)
+
+	for i := range pods {
+		pod := &pods[i]
+		running[pod.Name] = true
+
+		// Pods waiting in a warm pool have no assessment and belong to nobody yet
+		if pod.Labels[k8s.AssessmentIDLabelKey] == "" {
+			continue
+		}
+
+		if err := m.meterPod(ctx, pod, now, quotas); err != nil {
+			m.log.Error("Failed to meter terminal pod", err, map[string]interface{}{
+				"podName":      pod.Name,
+				"assessmentID": pod.Labels[k8s.AssessmentIDLabelKey],
+			})
+		}
+	}
+
+	sessions, err := m.usageRepo.ListOpenSessions(ctx)
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if running[session.PodName] {
+			continue
+		}
+		// The pod is gone but was never settled, e.g. it was deleted with
+		// kubectl or by another replica's client. Bill up to when it was last seen.
+		if err := m.bill(ctx, session, session.LastSeenAt, session.LastSeenAt, model.EnvironmentStopPodDisappeared); err != nil {
+			m.log.Error("Failed to settle environment session", err, map[string]interface{}{
+				"podName": session.PodName,
+			})
+		}
+	}
+
+	return nil
+}
+
+// meterPod bills a running pod and hard-stops it once it exceeds the
+// organization's maximum environment runtime
+func (m *Meter) meterPod(ctx context.Context, pod *corev1.Pod, now time.Time, quotas map[string]*model.OrganizationQuota) error {
+	session, err := m.session(ctx, pod)
+	if err != nil {
+		return err
+	}
+
+	if session.StoppedAt != nil {
+		// A previous hard stop was billed but the pod could not be deleted
+		if session.StopReason == model.EnvironmentStopRuntimeLimit {
+			return m.deletePod(ctx, pod)
+		}
+		return nil
+	}
+
+	quota, ok := quotas[session.OrganizationID]
+	if !ok {
+		quota, err = m.quotaRepo.GetByOrganization(ctx, session.OrganizationID)
+		if err != nil {
+			return fmt.Errorf("failed to get organization quota: %w", err)
+		}
+		quotas[session.OrganizationID] = quota
+	}
+
+	if !session.HasExceededRuntime(quota.MaxEnvironmentRuntimeMinutes, now) {
+		return m.bill(ctx, session, now, now, "")
+	}
+
+	// Bill up to the limit, not to now, so the customer is never charged for
+	// time past their plan's maximum runtime
+	limit := session.StartedAt.Add(time.Duration(quota.MaxEnvironmentRuntimeMinutes) * time.Minute)
+	if err := m.bill(ctx, session, limit, now, model.EnvironmentStopRuntimeLimit); err != nil {
+		return err
+	}
+
+	m.log.Warn("Stopping environment that exceeded its maximum runtime", map[string]interface{}{
+		"podName":           pod.Name,
+		"organizationID":    session.OrganizationID,
+		"assessmentID":      session.AssessmentID,
+		"maxRuntimeMinutes": quota.MaxEnvironmentRuntimeMinutes,
+	})
+	return m.deletePod(ctx, pod)
+}
+
+// podDeleted bills the final partial minute of a pod deleted through the client
+func (m *Meter) podDeleted(ctx context.Context, pod *corev1.Pod) {
+	if pod.Labels[k8s.AssessmentIDLabelKey] == "" {
+		return
+	}
+
+	session, err := m.session(ctx, pod)
+	if err == nil && session.StoppedAt == nil {
+		now := time.Now().UTC()
+		err = m.bill(ctx, session, now, now, model.EnvironmentStopDeleted)
+	}
+	if err != nil {
+		m.log.Error("Failed to bill deleted terminal pod", err, map[string]interface{}{
+			"podName":      pod.Name,
+			"assessmentID": pod.Labels[k8s.AssessmentIDLabelKey],
+		})
+	}
+}
+
+// deletePod deletes a terminal pod; the client's deletion hook is a no-op
+// because the session has already been stopped
+func (m *Meter) deletePod(ctx context.Context, pod *corev1.Pod) error {
+	return m.k8sClient.DeleteTerminalPod(ctx, pod.Labels[k8s.AssessmentIDLabelKey], pod.Labels[k8s.SessionIDLabelKey])
+}
+
+// session returns the pod's environment session, starting one if the pod has
+// not been metered before
+func (m *Meter) session(ctx context.Context, pod *corev1.Pod) (*model.EnvironmentSession, error) {
+	session, err := m.usageRepo.GetSessionByPod(ctx, pod.Name)
+	if err == nil {
+		return session, nil
+	}
+	if !errors.Is(err, database.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	assessmentID := pod.Labels[k8s.AssessmentIDLabelKey]
+	organizationID, _, err := m.assessmentRepo.ResolveOrganization(ctx, assessmentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve organization for assessment %s: %w", assessmentID, err)
+	}
+
+	startedAt := podStartedAt(pod, m.cfg.BillWarmPool)
+	cpu, memory := podResources(pod)
+	return m.usageRepo.CreateSession(ctx, &model.EnvironmentSession{
+		PodName:        pod.Name,
+		OrganizationID: organizationID,
+		AssessmentID:   assessmentID,
+		SessionID:      pod.Labels[k8s.SessionIDLabelKey],
+		CPU:            cpu,
+		Memory:         memory,
+		StartedAt:      startedAt,
+		MeteredUntil:   startedAt,
+		LastSeenAt:     startedAt,
+	})
+}
+
+// bill charges the session's minutes up to until. A non-empty stopReason
+// stops the session and bills the remaining partial minute; otherwise only
+// whole minutes are billed and the remainder carries over to the next tick.
+func (m *Meter) bill(ctx context.Context, session *model.EnvironmentSession, until, seenAt time.Time, stopReason string) error {
+	final := stopReason != ""
+	minutes := session.BillableMinutes(until, final)
+	if minutes == 0 && !final {
+		return m.usageRepo.TouchSession(ctx, session.ID, seenAt)
+	}
+
+	meteredUntil := session.MeteredUntil.Add(time.Duration(minutes) * time.Minute)
+	var stoppedAt *time.Time
+	if final {
+		meteredUntil = until
+		stoppedAt = &seenAt
+	}
+
+	var advanced bool
+	err := m.db.WithTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		usageRepo := m.usageRepo.WithTx(tx)
+		advanced, err = usageRepo.AdvanceSession(ctx, session, meteredUntil, seenAt, stoppedAt, stopReason)
+		if err != nil || !advanced || minutes == 0 {
+			return err
+		}
+
+		record := model.NewUsageRecord(session.OrganizationID, model.ResourceTypeEnvironmentMinutes, float64(minutes)).
+			WithAssessment(session.AssessmentID).
+			WithDetails(map[string]interface{}{
+				"pod_name":    session.PodName,
+				"session_id":  session.SessionID,
+				"cpu":         session.CPU,
+				"memory":      session.Memory,
+				"from":        session.MeteredUntil,
+				"to":          meteredUntil,
+				"stop_reason": stopReason,
+			})
+		if err := usageRepo.CreateRecord(ctx, record); err != nil {
+			return err
+		}
+
+		return m.quotaRepo.WithTx(tx).AddEnvironmentMinutes(ctx, session.OrganizationID, minutes)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to bill environment session %s: %w", session.ID, err)
+	}
+	if !advanced {
+		// Another tick or teardown billed this interval first
+		return nil
+	}
+
+	metrics.EnvironmentMinutes.Add(float64(minutes))
+	if final {
+		metrics.EnvironmentStops.WithLabelValues(stopReason).Inc()
+		m.log.Info("Environment session stopped", map[string]interface{}{
+			"podName":        session.PodName,
+			"organizationID": session.OrganizationID,
+			"assessmentID":   session.AssessmentID,
+			"reason":         stopReason,
+			"runtime":        meteredUntil.Sub(session.StartedAt).Round(time.Second).String(),
+		})
+	}
+
+	return nil
+}
+
+// podStartedAt returns when billing starts for a pod. Pods claimed from a warm
+// pool are billed from the claim unless billWarmPool is set.
+func podStartedAt(pod *corev1.Pod, billWarmPool bool) time.Time {
+	startedAt := pod.CreationTimestamp.Time.UTC()
+	if billWarmPool {
+		return startedAt
+	}
+
+	if value, ok := pod.Annotations["qualifyd.io/"+k8s.ClaimedAtAnnotationKey]; ok {
+		if claimedAt, err := time.Parse(time.RFC3339, value); err == nil && claimedAt.After(startedAt) {
+			return claimedAt.UTC()
+		}
+	}
+
+	return startedAt
+}
+
+// podResources returns the CPU and memory size of the pod's terminal
+// container, preferring limits over requests
+func podResources(pod *corev1.Pod) (string, string) {
+	if len(pod.Spec.Containers) == 0 {
+		return "", ""
+	}
+
+	resources := pod.Spec.Containers[0].Resources
+	var cpu, memory string
+	if q, ok := resources.Requests[corev1.ResourceCPU]; ok {
+		cpu = q.String()
+	}
+	if q, ok := resources.Limits[corev1.ResourceCPU]; ok {
+		cpu = q.String()
+	}
+	if q, ok := resources.Requests[corev1.ResourceMemory]; ok {
+		memory = q.String()
+	}
+	if q, ok := resources.Limits[corev1.ResourceMemory]; ok {
+		memory = q.String()
+	}
+
+	return cpu, memory
+}
@@ -0,0 +1,82 @@
+package metering
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/k8s"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPodStartedAt(t *testing.T) {
+	created := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	claimed := created.Add(15 * time.Minute)
+
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		CreationTimestamp: metav1.NewTime(created),
+		Annotations: map[string]string{
+			"qualifyd.io/" + k8s.ClaimedAtAnnotationKey: claimed.Format(time.RFC3339),
+		},
+	}}
+
+	if got := podStartedAt(pod, false); !got.Equal(claimed) {
+		t.Errorf("Expected warm pool time to be excluded, got start %v", got)
+	}
+	if got := podStartedAt(pod, true); !got.Equal(created) {
+		t.Errorf("Expected warm pool time to be billed, got start %v", got)
+	}
+
+	delete(pod.Annotations, "qualifyd.io/"+k8s.ClaimedAtAnnotationKey)
+	if got := podStartedAt(pod, false); !got.Equal(created) {
+		t.Errorf("Expected unclaimed pod to start at creation, got %v", got)
+	}
+}
+
+func TestPodResources(t *testing.T) {
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("250m"),
+				corev1.ResourceMemory: resource.MustParse("256Mi"),
+			},
+			Limits: corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("1"),
+			},
+		},
+	}}}}
+
+	cpu, memory := podResources(pod)
+	if cpu != "1" || memory != "256Mi" {
+		t.Errorf("Expected cpu 1 and memory 256Mi, got %q and %q", cpu, memory)
+	}
+}
+
+func TestBillableMinutes(t *testing.T) {
+	start := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	session := &model.EnvironmentSession{StartedAt: start, MeteredUntil: start}
+
+	tests := []struct {
+		name    string
+		elapsed time.Duration
+		final   bool
+		want    int
+	}{
+		{"nothing elapsed", 0, false, 0},
+		{"partial minute while running", 50 * time.Second, false, 0},
+		{"partial minute at teardown", 50 * time.Second, true, 1},
+		{"whole minutes while running", 5*time.Minute + 30*time.Second, false, 5},
+		{"whole minutes at teardown", 5*time.Minute + 30*time.Second, true, 6},
+		{"exact minutes at teardown", 5 * time.Minute, true, 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := session.BillableMinutes(start.Add(tt.elapsed), tt.final); got != tt.want {
+				t.Errorf("Expected %d minutes, got %d", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,220 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "qualifyd"
+
+// Provisioning stages observed by TerminalProvisioningDuration
+const (
+	StagePodCreate  = "pod_create"
+	StagePodReady   = "pod_ready"
+	StageSSHConnect = "ssh_connect"
+	StageTotal      = "total"
+)
+
+// Results used as the "result" label
+const (
+	ResultSuccess = "success"
+	ResultError   = "error"
+)
+
+// Directions used by TerminalBytes
+const (
+	DirectionIn  = "in"
+	DirectionOut = "out"
+)
+
+// Disconnect reasons used by TerminalDisconnects
+const (
+	DisconnectClientClosed    = "client_closed"
+	DisconnectReadError       = "read_error"
+	DisconnectWriteError      = "write_error"
+	DisconnectBufferFull      = "buffer_full"
+	DisconnectProvisionFailed = "provision_failed"
+	DisconnectQuotaExceeded   = "quota_exceeded"
+	DisconnectReadyTimeout    = "ready_timeout"
+	DisconnectSSHFailed       = "ssh_failed"
+	DisconnectServerShutdown  = "server_shutdown"
+	DisconnectUnknown         = "unknown"
+)
+
+// Scheduler job results used by SchedulerJobRuns, in addition to ResultSuccess and ResultError
+const (
+	ResultSkipped = "skipped"
+)
+
+// Pod reaper actions used by PodReaperActions
+const (
+	ReaperDeleted      = "deleted"
+	ReaperDeleteFailed = "delete_failed"
+	ReaperInvalid      = "invalid_annotations"
+)
+
+var (
+	// Registry holds every collector exposed on /metrics
+	Registry = prometheus.NewRegistry()
+
+	// TerminalSessionsActive is the number of terminals with an established SSH session
+	TerminalSessionsActive = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "terminal",
+		Name:      "sessions_active",
+		Help:      "Number of terminal sessions with an established SSH connection.",
+	})
+
+	// TerminalProvisioningDuration tracks how long each provisioning stage takes
+	TerminalProvisioningDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "terminal",
+		Name:      "provisioning_duration_seconds",
+		Help:      "Duration of terminal provisioning stages (pod_create, pod_ready, ssh_connect, total).",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 60, 120},
+	}, []string{"stage", "result"})
+
+	// TerminalSSHRetries counts SSH dial attempts beyond the first one
+	TerminalSSHRetries = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "terminal",
+		Name:      "ssh_connect_retries_total",
+		Help:      "Number of SSH dial retries made while connecting to terminal pods.",
+	})
+
+	// TerminalBytes counts terminal traffic; "in" is client to pod, "out" is pod to client
+	TerminalBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "terminal",
+		Name:      "bytes_total",
+		Help:      "Bytes relayed between WebSocket clients and terminal pods.",
+	}, []string{"direction"})
+
+	// TerminalDisconnects counts closed terminal WebSockets by reason
+	TerminalDisconnects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "terminal",
+		Name:      "disconnects_total",
+		Help:      "Number of terminal WebSocket disconnects by reason.",
+	}, []string{"reason"})
+
+	// PodReaperActions counts actions taken by the idle terminal pod reaper
+	PodReaperActions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "terminal",
+		Name:      "pod_reaper_actions_total",
+		Help:      "Actions taken by the idle terminal pod reaper.",
+	}, []string{"action"})
+
+	// QuotaRefusals counts requests refused by the quota service
+	QuotaRefusals = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "quota",
+		Name:      "refusals_total",
+		Help:      "Requests refused by the quota service by resource and reason.",
+	}, []string{"resource", "reason"})
+
+	// EnvironmentMinutes counts environment minutes billed to organizations
+	EnvironmentMinutes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "metering",
+		Name:      "environment_minutes_total",
+		Help:      "Environment minutes billed from terminal pod lifetimes.",
+	})
+
+	// EnvironmentStops counts metered environment sessions that ended, by reason
+	EnvironmentStops = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "metering",
+		Name:      "environment_stops_total",
+		Help:      "Metered environment sessions that ended by reason (deleted, runtime_limit, pod_disappeared).",
+	}, []string{"reason"})
+
+	// SchedulerJobRuns counts scheduled job runs by job and result
+	SchedulerJobRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "scheduler",
+		Name:      "job_runs_total",
+		Help:      "Scheduled job runs by job and result; skipped runs were held by another replica.",
+	}, []string{"job", "result"})
+
+	// SchedulerJobDuration tracks how long scheduled jobs take
+	SchedulerJobDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "scheduler",
+		Name:      "job_duration_seconds",
+		Help:      "Duration of scheduled job runs.",
+		Buckets:   []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 300},
+	}, []string{"job"})
+
+	// BillingCharges counts payment provider charges by the status they settled with
+	BillingCharges = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "billing",
+		Name:      "charges_total",
+		Help:      "Payment provider charges by provider and resulting status.",
+	}, []string{"provider", "status"})
+
+	// BillingWebhookEvents counts payment provider webhook deliveries by event type and result
+	BillingWebhookEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "billing",
+		Name:      "webhook_events_total",
+		Help:      "Payment provider webhook deliveries by event type and result.",
+	}, []string{"type", "result"})
+
+	// HTTPRequests counts HTTP requests by route pattern
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "Number of HTTP requests by method, route pattern and status code.",
+	}, []string{"method", "route", "status"})
+
+	// HTTPRequestDuration tracks HTTP request latency by route pattern
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "HTTP request latency by method and route pattern.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		TerminalSessionsActive,
+		TerminalProvisioningDuration,
+		TerminalSSHRetries,
+		TerminalBytes,
+		TerminalDisconnects,
+		PodReaperActions,
+		QuotaRefusals,
+		EnvironmentMinutes,
+		EnvironmentStops,
+		SchedulerJobRuns,
+		SchedulerJobDuration,
+		BillingCharges,
+		BillingWebhookEvents,
+		HTTPRequests,
+		HTTPRequestDuration,
+	)
+}
+
+// Handler returns the HTTP handler serving the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// Result maps an error to the "result" label value
+func Result(err error) string {
+	if err != nil {
+		return ResultError
+	}
+	return ResultSuccess
+}
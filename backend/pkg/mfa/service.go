@@ -0,0 +1,380 @@
+// Package mfa implements TOTP multi-factor authentication: enrolling
+// authenticators, recovery codes, the challenges sign-ins wait on for the
+// second factor and organizations' policies requiring it.
+package mfa
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/auth"
+	"github.com/cstanislawski/qualifyd/pkg/config"
+	"github.com/cstanislawski/qualifyd/pkg/database"
+	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/cstanislawski/qualifyd/pkg/repository"
+	"github.com/google/uuid"
+	"github.com/skip2/go-qrcode"
+)
+
+// Common errors
+var (
+	ErrInvalidCode = errors.New("invalid authentication code")
+	ErrNotEnrolled = errors.New("no authenticator is enrolled")
+	// ErrRequired is returned when disabling MFA the user's organization
+	// requires for their role
+	ErrRequired = errors.New("multi-factor authentication is required for this role")
+)
+
+// qrCodeSize is the width and height of provisioning QR codes, in pixels
+const qrCodeSize = 256
+
+// Enrollment is a new authenticator for the user to add to their app, by
+// scanning the QR code or typing the secret
+type Enrollment struct {
+	Secret          string
+	ProvisioningURI string
+	// QRCode is a PNG data URI of the provisioning URI
+	QRCode string
+}
+
+// Challenge is a sign-in waiting for the user's second factor
+type Challenge struct {
+	Token     string
+	ExpiresAt time.Time
+	// EnrollmentRequired is set when the user's organization requires MFA
+	// they have not set up yet; they enroll before signing in
+	EnrollmentRequired bool
+}
+
+// Status is a user's MFA setup
+type Status struct {
+	Enabled bool
+	// Required is set when the user's organization requires MFA for
+	// their role
+	Required               bool
+	RecoveryCodesRemaining int
+}
+
+// Service enrolls and verifies users' second factors
+type Service struct {
+	repo *repository.MFARepository
+	auth *auth.Auth
+	cfg  *config.MFAConfig
+	log  logger.Logger
+}
+
+// NewService creates a new Service
+func NewService(
+	repo *repository.MFARepository,
+	authService *auth.Auth,
+	cfg *config.MFAConfig,
+	log logger.Logger,
+) *Service {
+	return &Service{
+		repo: repo,
+		auth: authService,
+		cfg:  cfg,
+		log:  log,
+	}
+}
+
+// Check returns whether user must give a second factor to sign in, and
+// whether they have an authenticator to give it with. Users whose
+// organization requires MFA for their role but who have none must enroll.
+func (s *Service) Check(ctx context.Context, user *model.User) (required, enrolled bool, err error) {
+	enrollment, err := s.repo.GetEnrollment(ctx, user.ID)
+	if err != nil && !errors.Is(err, database.ErrRecordNotFound) {
+		return false, false, err
+	}
+	if enrollment != nil && enrollment.IsConfirmed() {
+		return true, true, nil
+	}
+
+	policy, err := s.repo.GetPolicy(ctx, user.OrganizationID)
+	if err != nil {
+		return false, false, err
+	}
+	return policy.Requires(user.Role), false, nil
+}
+
+// StartChallenge starts a challenge for a user who passed the password check
+func (s *Service) StartChallenge(ctx context.Context, user *model.User, enrollmentRequired bool) (*Challenge, error) {
+	c := &model.MFAChallenge{
+		ID:        uuid.New().String(),
+		UserID:    user.ID,
+		ExpiresAt: time.Now().UTC().Add(s.cfg.ChallengeTTL),
+	}
+	token, err := s.auth.GenerateMFAChallengeToken(c.ID, c.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.CreateChallenge(ctx, c); err != nil {
+		return nil, err
+	}
+
+	return &Challenge{
+		Token:              token,
+		ExpiresAt:          c.ExpiresAt,
+		EnrollmentRequired: enrollmentRequired,
+	}, nil
+}
+
+// ChallengeUser returns the ID of the user an open challenge belongs to,
+// without counting an attempt. It returns
+// repository.ErrMFAChallengeInvalid if the token is invalid or the
+// challenge cannot be completed.
+func (s *Service) ChallengeUser(ctx context.Context, token string) (string, error) {
+	id, err := s.auth.ValidateMFAChallengeToken(token)
+	if err != nil {
+		return "", repository.ErrMFAChallengeInvalid
+	}
+	c, err := s.repo.GetChallenge(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	return c.UserID, nil
+}
+
+// VerifyChallenge checks a code, or else a recovery code, against an open
+// challenge and completes it, returning the ID of the user who signs in.
+// Every try counts towards the challenge's attempts. A wrong code returns
+// the user's ID together with ErrInvalidCode.
+func (s *Service) VerifyChallenge(ctx context.Context, token, code, recoveryCode string) (string, error) {
+	c, err := s.attempt(ctx, token)
+	if err != nil {
+		return "", err
+	}
+	if err := s.Verify(ctx, c.UserID, code, recoveryCode); err != nil {
+		return c.UserID, err
+	}
+	if err := s.repo.CompleteChallenge(ctx, c.ID); err != nil {
+		return "", err
+	}
+
+	return c.UserID, nil
+}
+
+// ConfirmChallengeEnrollment confirms the enrollment of a user who had to
+// enroll before signing in and completes their challenge. It returns the
+// user's ID and recovery codes.
+func (s *Service) ConfirmChallengeEnrollment(ctx context.Context, token, code string) (string, []string, error) {
+	c, err := s.attempt(ctx, token)
+	if err != nil {
+		return "", nil, err
+	}
+	codes, err := s.ConfirmEnrollment(ctx, c.UserID, code)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := s.repo.CompleteChallenge(ctx, c.ID); err != nil {
+		return "", nil, err
+	}
+
+	return c.UserID, codes, nil
+}
+
+// attempt counts an attempt at the challenge a token identifies
+func (s *Service) attempt(ctx context.Context, token string) (*model.MFAChallenge, error) {
+	id, err := s.auth.ValidateMFAChallengeToken(token)
+	if err != nil {
+		return nil, repository.ErrMFAChallengeInvalid
+	}
+	return s.repo.RecordChallengeAttempt(ctx, id)
+}
+
+// Verify checks a user's code, or else one of their unused recovery codes,
+// which is used up. Each code is accepted once.
+func (s *Service) Verify(ctx context.Context, userID, code, recoveryCode string) error {
+	enrollment, err := s.repo.GetEnrollment(ctx, userID)
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			return ErrNotEnrolled
+		}
+		return err
+	}
+	if !enrollment.IsConfirmed() {
+		return ErrNotEnrolled
+	}
+
+	if code == "" && recoveryCode != "" {
+		used, err := s.repo.UseRecoveryCode(ctx, userID, auth.HashToken(auth.NormalizeRecoveryCode(recoveryCode)))
+		if err != nil {
+			return err
+		}
+		if !used {
+			return ErrInvalidCode
+		}
+		s.log.Info("Recovery code used", map[string]interface{}{"user_id": userID})
+		return nil
+	}
+
+	step, ok := auth.ValidateTOTP(enrollment.Secret, code, time.Now(), enrollment.LastUsedStep)
+	if !ok {
+		return ErrInvalidCode
+	}
+	used, err := s.repo.UseStep(ctx, userID, step)
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidCode
+	}
+
+	return nil
+}
+
+// BeginEnrollment creates a new authenticator for a user, replacing one they
+// have not confirmed. It returns repository.ErrMFAAlreadyEnabled if the user
+// has a confirmed one.
+func (s *Service) BeginEnrollment(ctx context.Context, user *model.User) (*Enrollment, error) {
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.StartEnrollment(ctx, user.ID, secret); err != nil {
+		return nil, err
+	}
+
+	uri := auth.TOTPProvisioningURI(s.cfg.Issuer, user.Email, secret)
+	png, err := qrcode.Encode(uri, qrcode.Medium, qrCodeSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate QR code: %w", err)
+	}
+
+	return &Enrollment{
+		Secret:          secret,
+		ProvisioningURI: uri,
+		QRCode:          "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+	}, nil
+}
+
+// ConfirmEnrollment confirms a user's new authenticator with a code from it
+// and returns their recovery codes, which are shown once
+func (s *Service) ConfirmEnrollment(ctx context.Context, userID, code string) ([]string, error) {
+	enrollment, err := s.repo.GetEnrollment(ctx, userID)
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			return nil, ErrNotEnrolled
+		}
+		return nil, err
+	}
+	if enrollment.IsConfirmed() {
+		return nil, repository.ErrMFAAlreadyEnabled
+	}
+
+	step, ok := auth.ValidateTOTP(enrollment.Secret, code, time.Now(), enrollment.LastUsedStep)
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.ConfirmEnrollment(ctx, userID, step, hashes); err != nil {
+		return nil, err
+	}
+
+	s.log.Info("MFA enabled", map[string]interface{}{"user_id": userID})
+	return codes, nil
+}
+
+// RegenerateRecoveryCodes replaces a user's recovery codes and returns the
+// new ones
+func (s *Service) RegenerateRecoveryCodes(ctx context.Context, userID string) ([]string, error) {
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.ReplaceRecoveryCodes(ctx, userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Disable removes a user's authenticator. It returns ErrRequired if their
+// organization requires MFA for their role.
+func (s *Service) Disable(ctx context.Context, user *model.User) error {
+	policy, err := s.repo.GetPolicy(ctx, user.OrganizationID)
+	if err != nil {
+		return err
+	}
+	if policy.Requires(user.Role) {
+		return ErrRequired
+	}
+
+	return s.Reset(ctx, user.ID)
+}
+
+// Reset removes a user's authenticator regardless of their organization's
+// policy, for users who lost it and their recovery codes. Users whose role
+// requires MFA enroll again at their next sign-in.
+func (s *Service) Reset(ctx context.Context, userID string) error {
+	if err := s.repo.DeleteEnrollment(ctx, userID); err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			return ErrNotEnrolled
+		}
+		return err
+	}
+
+	s.log.Info("MFA disabled", map[string]interface{}{"user_id": userID})
+	return nil
+}
+
+// Status returns a user's MFA setup
+func (s *Service) Status(ctx context.Context, user *model.User) (*Status, error) {
+	required, enrolled, err := s.Check(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &Status{Enabled: enrolled}
+	if enrolled {
+		// Check only looks up the policy for users without MFA
+		policy, err := s.repo.GetPolicy(ctx, user.OrganizationID)
+		if err != nil {
+			return nil, err
+		}
+		status.Required = policy.Requires(user.Role)
+		if status.RecoveryCodesRemaining, err = s.repo.CountRecoveryCodes(ctx, user.ID); err != nil {
+			return nil, err
+		}
+	} else {
+		status.Required = required
+	}
+
+	return status, nil
+}
+
+// Policy returns an organization's MFA policy
+func (s *Service) Policy(ctx context.Context, organizationID string) (*model.MFAPolicy, error) {
+	return s.repo.GetPolicy(ctx, organizationID)
+}
+
+// SavePolicy creates or replaces an organization's MFA policy
+func (s *Service) SavePolicy(ctx context.Context, policy *model.MFAPolicy) error {
+	return s.repo.SavePolicy(ctx, policy)
+}
+
+// DeleteExpiredChallenges deletes the challenges that expired before before
+func (s *Service) DeleteExpiredChallenges(ctx context.Context, before time.Time) error {
+	return s.repo.DeleteExpiredChallenges(ctx, before)
+}
+
+// newRecoveryCodes returns new recovery codes and the hashes they are stored
+// under
+func newRecoveryCodes() ([]string, []string, error) {
+	codes, err := auth.GenerateRecoveryCodes(model.RecoveryCodeCount)
+	if err != nil {
+		return nil, nil, err
+	}
+	hashes := make([]string, len(codes))
+	for i, c := range codes {
+		hashes[i] = auth.HashToken(auth.NormalizeRecoveryCode(c))
+	}
+	return codes, hashes, nil
+}
@@ -7,6 +7,8 @@ import (
 
 	"github.com/cstanislawski/qualifyd/pkg/auth"
 	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/cstanislawski/qualifyd/pkg/repository"
 )
 
 // contextKey is a custom type to avoid collisions in the context
@@ -19,12 +21,47 @@ const (
 	UserRoleKey        contextKey = "user_role"
 	OrganizationIDKey  contextKey = "organization_id"
 	IsAuthenticatedKey contextKey = "is_authenticated"
+	AssessmentScopeKey contextKey = "assessment_scope"
+	SessionIDKey       contextKey = "session_id"
+	APIKeyKey          contextKey = "api_key"
 )
 
-// AuthMiddleware creates a middleware that validates JWT tokens and sets user info in context
-func AuthMiddleware(authService *auth.Auth) func(http.Handler) http.Handler {
+// SessionChecker reports whether the session an access token belongs to is
+// still active
+type SessionChecker interface {
+	IsSessionActive(ctx context.Context, sessionID string) (bool, error)
+}
+
+// APIKeyAuthenticator returns the active API key a secret is
+type APIKeyAuthenticator interface {
+	AuthenticateAPIKey(ctx context.Context, secret string) (*model.APIKey, error)
+}
+
+// AuthMiddleware creates a middleware that validates JWT tokens and sets user info in context.
+// Tokens whose session was revoked or expired, or whose user is no longer
+// active, are treated as missing.
+//
+// Requests may instead carry an API key in an "Authorization: ApiKey" header.
+// They stay unauthenticated except on routes whose permissions API keys can
+// be granted as scopes (see Authorizer).
+func AuthMiddleware(authService *auth.Auth, sessions SessionChecker, apiKeys APIKeyAuthenticator) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if secret := extractAPIKeyFromHeader(r); secret != "" {
+				key, err := apiKeys.AuthenticateAPIKey(r.Context(), secret)
+				r = setUnauthenticatedContext(r)
+				if err != nil {
+					logger.Warn("Invalid API key", map[string]interface{}{
+						"error": err.Error(),
+						"path":  r.URL.Path,
+					})
+				} else {
+					r = r.WithContext(context.WithValue(r.Context(), APIKeyKey, key))
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
 			tokenString := extractTokenFromHeader(r)
 			if tokenString == "" {
 				// No token found, proceed as unauthenticated
@@ -45,6 +82,19 @@ func AuthMiddleware(authService *auth.Auth) func(http.Handler) http.Handler {
 				return
 			}
 
+			active, err := sessions.IsSessionActive(r.Context(), claims.SessionID)
+			if err != nil {
+				logger.Error("Failed to check session", err, map[string]interface{}{
+					"session_id": claims.SessionID,
+					"path":       r.URL.Path,
+				})
+			}
+			if !active {
+				r = setUnauthenticatedContext(r)
+				next.ServeHTTP(w, r)
+				return
+			}
+
 			// Set authenticated user info in context
 			r = setAuthenticatedContext(r, claims)
 			next.ServeHTTP(w, r)
@@ -52,6 +102,28 @@ func AuthMiddleware(authService *auth.Auth) func(http.Handler) http.Handler {
 	}
 }
 
+// TokenFromQuery creates a middleware that moves an access token from the
+// param query parameter into the Authorization header, for clients such as
+// browsers opening WebSockets that cannot set headers. It runs before
+// AuthMiddleware, and the parameter is removed so the token is not logged
+// with the URL.
+func TokenFromQuery(param string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			query := r.URL.Query()
+			if token := query.Get(param); token != "" {
+				query.Del(param)
+				r = r.Clone(r.Context())
+				r.URL.RawQuery = query.Encode()
+				if r.Header.Get("Authorization") == "" {
+					r.Header.Set("Authorization", "Bearer "+token)
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 // RequireAuth creates a middleware that requires authentication
 func RequireAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -67,10 +139,19 @@ func RequireAuth(next http.Handler) http.Handler {
 	})
 }
 
-// RequireRole creates a middleware that requires a specific role
+// RequireRole creates a middleware that requires a specific role. Super admins
+// manage the whole platform and are always allowed through.
 func RequireRole(roles ...string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// API keys only reach routes that grant scopes
+			if GetAPIKey(r) != nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte(`{"error":"Forbidden"}`))
+				return
+			}
+
 			// Check if user is authenticated
 			isAuthenticated := r.Context().Value(IsAuthenticatedKey)
 			if isAuthenticated == nil || isAuthenticated.(bool) == false {
@@ -89,7 +170,7 @@ func RequireRole(roles ...string) func(http.Handler) http.Handler {
 				return
 			}
 
-			hasRole := false
+			hasRole := userRole.(string) == model.RoleSuperAdmin
 			for _, role := range roles {
 				if userRole.(string) == role {
 					hasRole = true
@@ -136,6 +217,11 @@ func GetUserRole(r *http.Request) string {
 	return role.(string)
 }
 
+// IsSuperAdmin returns true if the user is a platform super admin
+func IsSuperAdmin(r *http.Request) bool {
+	return GetUserRole(r) == model.RoleSuperAdmin
+}
+
 // GetOrganizationID returns the organization ID from the request context
 func GetOrganizationID(r *http.Request) string {
 	orgID := r.Context().Value(OrganizationIDKey)
@@ -145,6 +231,35 @@ func GetOrganizationID(r *http.Request) string {
 	return orgID.(string)
 }
 
+// GetAssessmentScope returns the one assessment the caller's token is limited
+// to, or an empty string if it is not limited
+func GetAssessmentScope(r *http.Request) string {
+	assessmentID := r.Context().Value(AssessmentScopeKey)
+	if assessmentID == nil {
+		return ""
+	}
+	return assessmentID.(string)
+}
+
+// GetSessionID returns the ID of the session the caller's token belongs to
+func GetSessionID(r *http.Request) string {
+	sessionID := r.Context().Value(SessionIDKey)
+	if sessionID == nil {
+		return ""
+	}
+	return sessionID.(string)
+}
+
+// GetAPIKey returns the API key the request was made with, or nil if it was
+// not made with one
+func GetAPIKey(r *http.Request) *model.APIKey {
+	key := r.Context().Value(APIKeyKey)
+	if key == nil {
+		return nil
+	}
+	return key.(*model.APIKey)
+}
+
 // IsAuthenticated returns true if the user is authenticated
 func IsAuthenticated(r *http.Request) bool {
 	isAuth := r.Context().Value(IsAuthenticatedKey)
@@ -173,6 +288,17 @@ func extractTokenFromHeader(r *http.Request) string {
 	return parts[1]
 }
 
+// extractAPIKeyFromHeader extracts the API key from an "ApiKey" Authorization
+// header
+func extractAPIKeyFromHeader(r *http.Request) string {
+	parts := strings.Split(r.Header.Get("Authorization"), " ")
+	if len(parts) != 2 || parts[0] != "ApiKey" {
+		return ""
+	}
+
+	return parts[1]
+}
+
 // setUnauthenticatedContext sets context values for an unauthenticated request
 func setUnauthenticatedContext(r *http.Request) *http.Request {
 	ctx := r.Context()
@@ -187,6 +313,12 @@ func setAuthenticatedContext(r *http.Request, claims *auth.Claims) *http.Request
 	ctx = context.WithValue(ctx, UserEmailKey, claims.Email)
 	ctx = context.WithValue(ctx, UserRoleKey, claims.Role)
 	ctx = context.WithValue(ctx, OrganizationIDKey, claims.OrganizationID)
+	ctx = context.WithValue(ctx, SessionIDKey, claims.SessionID)
 	ctx = context.WithValue(ctx, IsAuthenticatedKey, true)
+	if claims.AssessmentID != "" {
+		ctx = context.WithValue(ctx, AssessmentScopeKey, claims.AssessmentID)
+	}
+	// Bind the caller's organization so repositories can be scoped with ForTenant
+	ctx = repository.WithTenant(ctx, claims.OrganizationID)
 	return r.WithContext(ctx)
 }
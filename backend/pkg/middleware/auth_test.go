@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cstanislawski/qualifyd/pkg/auth"
+	"github.com/cstanislawski/qualifyd/pkg/config"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+)
+
+func TestRequireRole(t *testing.T) {
+	handler := RequireRole(model.RoleAdmin)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name          string
+		authenticated bool
+		role          string
+		want          int
+	}{
+		{"unauthenticated", false, "", http.StatusUnauthorized},
+		{"matching role", true, model.RoleAdmin, http.StatusOK},
+		{"other role", true, model.RoleRecruiter, http.StatusForbidden},
+		{"super admin", true, model.RoleSuperAdmin, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.WithValue(context.Background(), IsAuthenticatedKey, tt.authenticated)
+			if tt.role != "" {
+				ctx = context.WithValue(ctx, UserRoleKey, tt.role)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, rec.Code)
+			}
+		})
+	}
+}
+
+// fakeSessions maps session IDs to whether they are active
+type fakeSessions map[string]bool
+
+func (f fakeSessions) IsSessionActive(ctx context.Context, sessionID string) (bool, error) {
+	return f[sessionID], nil
+}
+
+func TestAuthMiddlewareSessions(t *testing.T) {
+	a := auth.New(&config.JWTConfig{Secret: "secret", ExpirationHours: 1})
+	user := &model.User{ID: "user-1", Role: model.RoleAdmin, OrganizationID: "org-1"}
+
+	var gotSessionID string
+	handler := AuthMiddleware(a, fakeSessions{"active": true}, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotSessionID = GetSessionID(r)
+		if !IsAuthenticated(r) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name      string
+		sessionID string
+		want      int
+	}{
+		{"active session", "active", http.StatusOK},
+		{"revoked session", "revoked", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := a.GenerateAccessToken(user, tt.sessionID)
+			if err != nil {
+				t.Fatalf("GenerateAccessToken() error = %v", err)
+			}
+			gotSessionID = ""
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, rec.Code)
+			}
+			if tt.want == http.StatusOK && gotSessionID != tt.sessionID {
+				t.Errorf("GetSessionID() = %q, want %q", gotSessionID, tt.sessionID)
+			}
+		})
+	}
+}
+
+func TestTokenFromQuery(t *testing.T) {
+	var gotAuth, gotQuery string
+	handler := TokenFromQuery("access_token")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		gotQuery = r.URL.RawQuery
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/ws/terminal/1?access_token=secret&sessionId=abc", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if gotAuth != "Bearer secret" {
+		t.Errorf("Authorization = %q, want %q", gotAuth, "Bearer secret")
+	}
+	if gotQuery != "sessionId=abc" {
+		t.Errorf("query = %q, want the token removed", gotQuery)
+	}
+
+	// A header the client did set wins
+	req = httptest.NewRequest(http.MethodGet, "/ws/terminal/1?access_token=secret", nil)
+	req.Header.Set("Authorization", "Bearer header")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if gotAuth != "Bearer header" {
+		t.Errorf("Authorization = %q, want %q", gotAuth, "Bearer header")
+	}
+}
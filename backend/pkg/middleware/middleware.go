@@ -2,19 +2,23 @@ package middleware
 
 import (
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/metrics"
+	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 )
 
 // List of paths to exclude from logging
 var skipLogPaths = map[string]bool{
-	"/health": true,
-	// "/metrics":   true, # TODO once metrics are implemented
+	"/health":  true,
+	"/metrics": true,
 }
 
 // HTTPMiddleware returns a middleware that logs HTTP requests using the configured zerolog logger
+// and records request metrics labeled by chi route pattern
 func HTTPMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Check if this path should be excluded from logging
@@ -26,16 +30,18 @@ func HTTPMiddleware(next http.Handler) http.Handler {
 		// Call the next handler
 		next.ServeHTTP(ww, r)
 
+		duration := time.Since(start)
+		recordRequestMetrics(r, ww.Status(), duration)
+
 		// Skip logging for excluded paths
 		if skipLogging {
 			return
 		}
 
 		// Log the request
-		duration := time.Since(start)
 
 		// Create fields for the log entry
-		fields := map[string]interface{}{
+		fields := logger.WithTraceContext(r.Context(), map[string]interface{}{
 			"method":    r.Method,
 			"path":      r.URL.Path,
 			"status":    ww.Status(),
@@ -44,7 +50,7 @@ func HTTPMiddleware(next http.Handler) http.Handler {
 			"ip":        r.RemoteAddr,
 			"proto":     r.Proto,
 			"userAgent": r.UserAgent(),
-		}
+		})
 
 		// Log with appropriate level based on status code
 		statusCode := ww.Status()
@@ -58,3 +64,22 @@ func HTTPMiddleware(next http.Handler) http.Handler {
 		}
 	})
 }
+
+// recordRequestMetrics records request count and latency. The route pattern is
+// used instead of the raw path to keep label cardinality bounded.
+func recordRequestMetrics(r *http.Request, status int, duration time.Duration) {
+	route := "unmatched"
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		if pattern := rctx.RoutePattern(); pattern != "" {
+			route = pattern
+		}
+	}
+
+	// net/http replies 200 when the handler never writes a header
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	metrics.HTTPRequests.WithLabelValues(r.Method, route, strconv.Itoa(status)).Inc()
+	metrics.HTTPRequestDuration.WithLabelValues(r.Method, route).Observe(duration.Seconds())
+}
@@ -0,0 +1,109 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/cstanislawski/qualifyd/pkg/repository"
+	"github.com/go-chi/chi/v5"
+)
+
+// PermissionResolver returns the permissions of a user whose own role is role
+type PermissionResolver interface {
+	Grants(ctx context.Context, userID, role string) (*model.Grants, error)
+}
+
+// Authorizer creates middlewares that let through the callers holding the
+// permissions routes declare. API keys are let through with the scopes they
+// were granted instead, and act as a service principal of their
+// organization.
+type Authorizer struct {
+	resolver PermissionResolver
+}
+
+// NewAuthorizer creates a new Authorizer
+func NewAuthorizer(resolver PermissionResolver) *Authorizer {
+	return &Authorizer{
+		resolver: resolver,
+	}
+}
+
+// Require creates a middleware that requires permission across the caller's
+// organization
+func (a *Authorizer) Require(permission string) func(http.Handler) http.Handler {
+	return a.require(permission, func(r *http.Request, g *model.Grants) bool {
+		return g.Allows(permission)
+	})
+}
+
+// RequireOn creates a middleware that requires permission across the
+// caller's organization or on the resource of resourceType whose ID is the
+// param URL parameter. The route must declare param itself, as URL
+// parameters are only known once it is matched.
+func (a *Authorizer) RequireOn(permission, resourceType, param string) func(http.Handler) http.Handler {
+	return a.require(permission, func(r *http.Request, g *model.Grants) bool {
+		return g.AllowsOn(permission, resourceType, chi.URLParam(r, param))
+	})
+}
+
+// require creates a middleware that lets through API keys with permission as
+// a scope and users whose grants allowed says are enough
+func (a *Authorizer) require(permission string, allowed func(*http.Request, *model.Grants) bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if key := GetAPIKey(r); key != nil {
+				if !key.HasScope(permission) {
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusForbidden)
+					w.Write([]byte(`{"error":"API key lacks the required scope"}`))
+					return
+				}
+				next.ServeHTTP(w, setAPIKeyContext(r, key))
+				return
+			}
+
+			if !IsAuthenticated(r) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"error":"Unauthorized"}`))
+				return
+			}
+
+			grants, err := a.resolver.Grants(r.Context(), GetUserID(r), GetUserRole(r))
+			if err != nil {
+				logger.Error("Failed to resolve permissions", err, map[string]interface{}{
+					"user_id": GetUserID(r),
+					"path":    r.URL.Path,
+				})
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(`{"error":"Failed to check permissions"}`))
+				return
+			}
+
+			if !allowed(r, grants) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte(`{"error":"Forbidden"}`))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// setAPIKeyContext sets context values for a request made with an API key.
+// The service principal has no user of its own; what it creates is
+// attributed to the admin who created the key.
+func setAPIKeyContext(r *http.Request, key *model.APIKey) *http.Request {
+	ctx := r.Context()
+	ctx = context.WithValue(ctx, UserIDKey, key.CreatedBy)
+	ctx = context.WithValue(ctx, UserRoleKey, model.RoleService)
+	ctx = context.WithValue(ctx, OrganizationIDKey, key.OrganizationID)
+	ctx = context.WithValue(ctx, IsAuthenticatedKey, true)
+	ctx = repository.WithTenant(ctx, key.OrganizationID)
+	return r.WithContext(ctx)
+}
@@ -0,0 +1,133 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cstanislawski/qualifyd/pkg/auth"
+	"github.com/cstanislawski/qualifyd/pkg/config"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/go-chi/chi/v5"
+)
+
+// fakeAPIKeys maps API key secrets to their keys
+type fakeAPIKeys map[string]*model.APIKey
+
+func (f fakeAPIKeys) AuthenticateAPIKey(ctx context.Context, secret string) (*model.APIKey, error) {
+	if key, ok := f[secret]; ok {
+		return key, nil
+	}
+	return nil, errors.New("invalid API key")
+}
+
+// fakeResolver maps user IDs to their role assignments
+type fakeResolver map[string][]*model.RoleAssignment
+
+func (f fakeResolver) Grants(ctx context.Context, userID, role string) (*model.Grants, error) {
+	return model.NewGrants(role, f[userID]), nil
+}
+
+func TestAuthorizer(t *testing.T) {
+	authz := NewAuthorizer(fakeResolver{
+		// A reviewer who also recruits, and reviews one template's assessments
+		"user-1": {
+			{Role: model.RoleRecruiter},
+			{Role: model.RoleTemplateEditor, ResourceType: model.ResourceAssessmentTemplate, ResourceID: "template-1"},
+		},
+	})
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	router := chi.NewRouter()
+	router.With(authz.Require(model.PermissionAssessmentsWrite)).Post("/assessments", ok)
+	router.With(authz.Require(model.PermissionUsersManage)).Post("/users", ok)
+	router.With(authz.RequireOn(model.PermissionTemplatesWrite, model.ResourceAssessmentTemplate, "id")).Put("/templates/{id}", ok)
+
+	tests := []struct {
+		name          string
+		authenticated bool
+		userID        string
+		role          string
+		method        string
+		path          string
+		want          int
+	}{
+		{"unauthenticated", false, "", "", http.MethodPost, "/assessments", http.StatusUnauthorized},
+		{"own role", true, "user-2", model.RoleRecruiter, http.MethodPost, "/assessments", http.StatusOK},
+		{"own role lacks permission", true, "user-2", model.RoleReviewer, http.MethodPost, "/assessments", http.StatusForbidden},
+		{"assigned role", true, "user-1", model.RoleReviewer, http.MethodPost, "/assessments", http.StatusOK},
+		{"no role grants permission", true, "user-1", model.RoleReviewer, http.MethodPost, "/users", http.StatusForbidden},
+		{"granted resource", true, "user-1", model.RoleReviewer, http.MethodPut, "/templates/template-1", http.StatusOK},
+		{"other resource", true, "user-1", model.RoleReviewer, http.MethodPut, "/templates/template-2", http.StatusForbidden},
+		{"super admin", true, "user-3", model.RoleSuperAdmin, http.MethodPost, "/users", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.WithValue(context.Background(), IsAuthenticatedKey, tt.authenticated)
+			ctx = context.WithValue(ctx, UserIDKey, tt.userID)
+			ctx = context.WithValue(ctx, UserRoleKey, tt.role)
+			req := httptest.NewRequest(tt.method, tt.path, nil).WithContext(ctx)
+			rec := httptest.NewRecorder()
+
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, rec.Code)
+			}
+		})
+	}
+}
+
+func TestAuthorizerAPIKeys(t *testing.T) {
+	a := auth.New(&config.JWTConfig{Secret: "secret", ExpirationHours: 1})
+	keys := fakeAPIKeys{
+		"reader": {ID: "key-1", OrganizationID: "org-1", CreatedBy: "user-1", Scopes: []string{model.PermissionTemplatesRead}},
+		"writer": {ID: "key-2", OrganizationID: "org-1", CreatedBy: "user-1", Scopes: []string{model.PermissionTemplatesWrite}},
+	}
+	authz := NewAuthorizer(fakeResolver{})
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if GetOrganizationID(r) != "org-1" || GetUserRole(r) != model.RoleService {
+			w.WriteHeader(http.StatusTeapot)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	router := chi.NewRouter()
+	router.Use(AuthMiddleware(a, fakeSessions{}, keys))
+	router.With(authz.Require(model.PermissionTemplatesRead)).Get("/templates", ok)
+	router.With(authz.Require(model.PermissionTemplatesWrite)).Post("/templates", ok)
+	router.With(RequireRole(model.RoleAdmin)).Get("/admin", ok)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		key    string
+		want   int
+	}{
+		{"read scope reads", http.MethodGet, "/templates", "reader", http.StatusOK},
+		{"read scope cannot write", http.MethodPost, "/templates", "reader", http.StatusForbidden},
+		{"write scope writes", http.MethodPost, "/templates", "writer", http.StatusOK},
+		{"write scope reads", http.MethodGet, "/templates", "writer", http.StatusOK},
+		{"unknown key", http.MethodGet, "/templates", "unknown", http.StatusUnauthorized},
+		{"role-only route", http.MethodGet, "/admin", "writer", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("Authorization", "ApiKey "+tt.key)
+			rec := httptest.NewRecorder()
+
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, rec.Code)
+			}
+		})
+	}
+}
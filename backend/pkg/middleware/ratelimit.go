@@ -0,0 +1,164 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/config"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/cstanislawski/qualifyd/pkg/ratelimit"
+	"github.com/go-chi/chi/v5"
+)
+
+// maxPeekedBody bounds how much of a request body is read to find the
+// account it is for
+const maxPeekedBody = 1 << 20
+
+// RateLimiter takes tokens from rate limit buckets; *ratelimit.Limiter
+// implements it
+type RateLimiter interface {
+	Allow(ctx context.Context, key string, limit config.RateLimit) (bool, time.Duration)
+}
+
+// AuditRecorder records audit events; *audit.Service implements it
+type AuditRecorder interface {
+	Record(ctx context.Context, e *model.AuditEvent)
+}
+
+// AccountFunc returns the account a request is for, or "" if it names none
+type AccountFunc func(r *http.Request) string
+
+// RateLimits creates middlewares that limit how often routes are called.
+// Rejected requests are answered with 429 and a Retry-After header, and
+// audited at most once per client or account per limit period.
+type RateLimits struct {
+	limiter RateLimiter
+	audit   AuditRecorder
+	cfg     *config.RateLimitConfig
+}
+
+// NewRateLimits creates a new RateLimits
+func NewRateLimits(limiter RateLimiter, audit AuditRecorder, cfg *config.RateLimitConfig) *RateLimits {
+	return &RateLimits{
+		limiter: limiter,
+		audit:   audit,
+		cfg:     cfg,
+	}
+}
+
+// Limit creates a middleware that limits the requests to route from each
+// client IP, for each account account returns, and from all clients
+func (l *RateLimits) Limit(route string, account AccountFunc) func(http.Handler) http.Handler {
+	return l.limit(route, l.cfg.PerIP, l.cfg.PerAccount, l.cfg.PerRoute, account)
+}
+
+// LimitTerminal creates a middleware that limits the connection attempts to
+// terminals from each client IP and to the terminal of each assessment,
+// whose ID is the param URL parameter. The route must declare param itself.
+func (l *RateLimits) LimitTerminal(route, param string) func(http.Handler) http.Handler {
+	return l.limit(route, l.cfg.Terminal, l.cfg.Terminal, config.RateLimit{}, func(r *http.Request) string {
+		return chi.URLParam(r, param)
+	})
+}
+
+// limit creates a middleware taking a token from the client's, the
+// account's and the route's buckets, in that order, so that a client over
+// its own limit does not use up everyone else's
+func (l *RateLimits) limit(route string, perIP, perAccount, perRoute config.RateLimit, account AccountFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !l.allow(w, r, route, "ip", "ip:"+route+":"+remoteIP(r), perIP) {
+				return
+			}
+
+			if account != nil {
+				if name := account(r); name != "" {
+					key := "account:" + route + ":" + ratelimit.AccountKey(name)
+					if !l.allow(w, r, route, "account", key, perAccount) {
+						return
+					}
+				}
+			}
+
+			if !l.allow(w, r, route, "route", "route:"+route, perRoute) {
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// allow takes a token from key's bucket, or rejects the request if it has
+// none. The first rejection by each bucket in a period is audited, naming
+// the scope of the limit hit; the audit bucket keeps a flood of rejected
+// requests from flooding the audit log too.
+func (l *RateLimits) allow(w http.ResponseWriter, r *http.Request, route, scope, key string, limit config.RateLimit) bool {
+	ok, retryAfter := l.limiter.Allow(r.Context(), key, limit)
+	if ok {
+		return true
+	}
+
+	if audit, _ := l.limiter.Allow(r.Context(), "audit:"+key, config.RateLimit{Requests: 1, Period: limit.Period}); audit {
+		diff, _ := json.Marshal(map[string]string{"limit": scope})
+		l.audit.Record(r.Context(), &model.AuditEvent{
+			ActorType:  model.AuditActorAnonymous,
+			Action:     model.AuditRateLimited,
+			TargetType: model.AuditTargetRoute,
+			TargetID:   route,
+			IPAddress:  remoteIP(r),
+			UserAgent:  r.UserAgent(),
+			Diff:       diff,
+		})
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(ratelimit.RetryAfterSeconds(retryAfter)))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+	w.Write([]byte(`{"error":"Too many requests"}`))
+	return false
+}
+
+// JSONField returns an AccountFunc reading the account from the string field
+// of a JSON request body. What it reads of the body is put back for the
+// handler.
+func JSONField(field string) AccountFunc {
+	return func(r *http.Request) string {
+		if r.Body == nil {
+			return ""
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxPeekedBody))
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		if err != nil {
+			return ""
+		}
+
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(body, &fields); err != nil {
+			return ""
+		}
+		var value string
+		if err := json.Unmarshal(fields[field], &value); err != nil {
+			return ""
+		}
+		return value
+	}
+}
+
+// remoteIP returns the IP address of the client that sent r
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
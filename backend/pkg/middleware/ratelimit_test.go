@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/config"
+	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/cstanislawski/qualifyd/pkg/ratelimit"
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog"
+)
+
+// fakeAudit collects the events recorded
+type fakeAudit []*model.AuditEvent
+
+func (f *fakeAudit) Record(ctx context.Context, e *model.AuditEvent) {
+	*f = append(*f, e)
+}
+
+func TestRateLimits(t *testing.T) {
+	cfg := &config.RateLimitConfig{
+		PerIP:      config.RateLimit{Requests: 3, Period: time.Minute},
+		PerAccount: config.RateLimit{Requests: 2, Period: time.Minute},
+		PerRoute:   config.RateLimit{Requests: 100, Period: time.Minute},
+		Terminal:   config.RateLimit{Requests: 1, Period: time.Minute},
+	}
+	limiter := ratelimit.NewLimiter(ratelimit.NewMemoryStore(), cfg, logger.NewLogger(zerolog.Nop()))
+	audit := &fakeAudit{}
+	limits := NewRateLimits(limiter, audit, cfg)
+
+	var bodies []string
+	router := chi.NewRouter()
+	router.With(limits.Limit("login", JSONField("email"))).Post("/login", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		w.WriteHeader(http.StatusOK)
+	})
+	router.With(limits.LimitTerminal("terminal", "id")).Get("/terminal/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	request := func(method, path, ip, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.RemoteAddr = ip + ":1234"
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		ip         string
+		body       string
+		wantStatus int
+	}{
+		{"first sign-in to an account", http.MethodPost, "/login", "10.0.0.1", `{"email":"jane@example.com"}`, http.StatusOK},
+		{"same account, other case", http.MethodPost, "/login", "10.0.0.2", `{"email":"JANE@example.com"}`, http.StatusOK},
+		{"account over its limit", http.MethodPost, "/login", "10.0.0.3", `{"email":"jane@example.com"}`, http.StatusTooManyRequests},
+		{"other account from the first client", http.MethodPost, "/login", "10.0.0.1", `{"email":"john@example.com"}`, http.StatusOK},
+		{"third request from the first client", http.MethodPost, "/login", "10.0.0.1", `{"email":"joe@example.com"}`, http.StatusOK},
+		{"client over its limit", http.MethodPost, "/login", "10.0.0.1", `{"email":"jim@example.com"}`, http.StatusTooManyRequests},
+		{"body without an account", http.MethodPost, "/login", "10.0.0.4", `not json`, http.StatusOK},
+		{"first terminal connection", http.MethodGet, "/terminal/assessment-1", "10.0.0.5", "", http.StatusOK},
+		{"terminal over its limit", http.MethodGet, "/terminal/assessment-1", "10.0.0.6", "", http.StatusTooManyRequests},
+		{"other terminal, same client", http.MethodGet, "/terminal/assessment-2", "10.0.0.5", "", http.StatusTooManyRequests},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := request(tt.method, tt.path, tt.ip, tt.body)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if retryAfter := rec.Header().Get("Retry-After"); (tt.wantStatus == http.StatusTooManyRequests) != (retryAfter != "") {
+				t.Errorf("Retry-After = %q with status %d", retryAfter, rec.Code)
+			}
+		})
+	}
+
+	// Handlers still read the bodies the middleware peeked at
+	if len(bodies) == 0 || bodies[0] != `{"email":"jane@example.com"}` {
+		t.Errorf("handler read bodies %q", bodies)
+	}
+
+	// Each rejection was by a bucket that had not rejected before
+	if len(*audit) != 4 {
+		t.Fatalf("recorded %d audit events, want 4", len(*audit))
+	}
+	if e := (*audit)[0]; e.Action != model.AuditRateLimited || e.TargetID != "login" || e.IPAddress != "10.0.0.3" {
+		t.Errorf("audit event = %+v", e)
+	}
+
+	// Further rejections by the same bucket are not audited again
+	request(http.MethodPost, "/login", "10.0.0.1", `{"email":"jim@example.com"}`)
+	if len(*audit) != 4 {
+		t.Errorf("recorded %d audit events after a repeated rejection, want 4", len(*audit))
+	}
+}
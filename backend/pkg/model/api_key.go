@@ -0,0 +1,92 @@
+package model
+
+import (
+	"strings"
+	"time"
+)
+
+// APIKeyScopes lists the permissions an API key can be granted as scopes.
+// Write scopes imply the read scope of the same resource.
+var APIKeyScopes = []string{
+	PermissionAssessmentsRead,
+	PermissionAssessmentsWrite,
+	PermissionTemplatesRead,
+	PermissionTemplatesWrite,
+}
+
+// IsValidAPIKeyScope returns true if scope is a known API key scope
+func IsValidAPIKeyScope(scope string) bool {
+	for _, s := range APIKeyScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKey is a credential an organization's scripts and integrations call the
+// API with. Requests made with it act as a service principal of the
+// organization, limited to the key's scopes.
+type APIKey struct {
+	ID             string `json:"id"`
+	OrganizationID string `json:"organization_id"`
+	Name           string `json:"name"`
+	// Prefix is the start of the key, shown so that keys can be told apart;
+	// only a hash of the whole key is stored
+	Prefix     string     `json:"prefix"`
+	KeyHash    string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	CreatedBy  string     `json:"created_by"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// IsActive returns true if the key is neither revoked nor expired at now
+func (k *APIKey) IsActive(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+// HasScope returns true if the key was granted scope, or the write scope of
+// the same resource if scope is a read scope
+func (k *APIKey) HasScope(scope string) bool {
+	write := scope
+	if resource, ok := strings.CutSuffix(scope, ":read"); ok {
+		write = resource + ":write"
+	}
+	for _, s := range k.Scopes {
+		if s == scope || s == write {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate performs basic validation on a new key
+func (k *APIKey) Validate() map[string]string {
+	errors := make(map[string]string)
+
+	name := strings.TrimSpace(k.Name)
+	if name == "" {
+		errors["name"] = "Name is required"
+	} else if len(name) > 255 {
+		errors["name"] = "Name must be at most 255 characters"
+	}
+
+	if len(k.Scopes) == 0 {
+		errors["scopes"] = "At least one scope is required"
+	}
+	for _, s := range k.Scopes {
+		if !IsValidAPIKeyScope(s) {
+			errors["scopes"] = "Unknown scope: " + s
+			break
+		}
+	}
+
+	if k.ExpiresAt != nil && !k.ExpiresAt.After(time.Now()) {
+		errors["expires_at"] = "Expiry must be in the future"
+	}
+
+	return errors
+}
@@ -0,0 +1,48 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAPIKeyHasScope(t *testing.T) {
+	key := &APIKey{Scopes: []string{PermissionAssessmentsWrite, PermissionTemplatesRead}}
+
+	tests := []struct {
+		scope string
+		want  bool
+	}{
+		{PermissionAssessmentsRead, true},
+		{PermissionAssessmentsWrite, true},
+		{PermissionTemplatesRead, true},
+		{PermissionTemplatesWrite, false},
+	}
+
+	for _, tt := range tests {
+		if got := key.HasScope(tt.scope); got != tt.want {
+			t.Errorf("HasScope(%q) = %v, want %v", tt.scope, got, tt.want)
+		}
+	}
+}
+
+func TestAPIKeyIsActive(t *testing.T) {
+	now := time.Now()
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+
+	tests := []struct {
+		name string
+		key  APIKey
+		want bool
+	}{
+		{"no expiry", APIKey{}, true},
+		{"not yet expired", APIKey{ExpiresAt: &future}, true},
+		{"expired", APIKey{ExpiresAt: &past}, false},
+		{"revoked", APIKey{RevokedAt: &past}, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.key.IsActive(now); got != tt.want {
+			t.Errorf("%s: IsActive() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
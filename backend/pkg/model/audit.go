@@ -0,0 +1,201 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Actor types of audit events
+const (
+	AuditActorUser   = "user"
+	AuditActorAPIKey = "api_key"
+	// AuditActorAnonymous is a caller who is not signed in, such as someone
+	// failing to sign in
+	AuditActorAnonymous = "anonymous"
+	AuditActorSystem    = "system"
+)
+
+// Audit event actions, named target.action
+const (
+	AuditLogin                      = "auth.login"
+	AuditLoginFailed                = "auth.login_failed"
+	AuditRegistered                 = "auth.registered"
+	AuditInvitationAccepted         = "auth.invitation_accepted"
+	AuditRateLimited                = "auth.rate_limited"
+	AuditAccountLocked              = "auth.account_locked"
+	AuditLogout                     = "auth.logout"
+	AuditPasswordReset              = "auth.password_reset"
+	AuditUserCreated                = "user.created"
+	AuditUserUpdated                = "user.updated"
+	AuditUserDeleted                = "user.deleted"
+	AuditTemplateCreated            = "assessment_template.created"
+	AuditTemplateUpdated            = "assessment_template.updated"
+	AuditTemplateDeleted            = "assessment_template.deleted"
+	AuditTaskTemplateCreated        = "task_template.created"
+	AuditTaskTemplateUpdated        = "task_template.updated"
+	AuditTaskTemplateDeleted        = "task_template.deleted"
+	AuditEnvironmentTemplateCreated = "environment_template.created"
+	AuditEnvironmentTemplateUpdated = "environment_template.updated"
+	AuditEnvironmentTemplateDeleted = "environment_template.deleted"
+	AuditAssessmentViewed           = "assessment.viewed"
+	AuditRoleCreated                = "role.created"
+	AuditRoleUpdated                = "role.updated"
+	AuditRoleDeleted                = "role.deleted"
+	AuditRoleAssigned               = "role.assigned"
+	AuditRoleUnassigned             = "role.unassigned"
+	AuditAPIKeyCreated              = "api_key.created"
+	AuditAPIKeyRevoked              = "api_key.revoked"
+	AuditMFADisabled                = "mfa.disabled"
+	AuditMFAReset                   = "mfa.reset"
+	AuditEventsExported             = "audit.exported"
+	AuditOrganizationUpdated        = "organization.updated"
+	AuditOrganizationDeleted        = "organization.deleted"
+	AuditOwnershipTransferred       = "organization.ownership_transferred"
+	AuditPasswordLoginDisabled      = "organization.password_login_disabled"
+	AuditPasswordLoginEnabled       = "organization.password_login_enabled"
+	AuditSSOProviderCreated         = "sso_provider.created"
+	AuditSSOProviderUpdated         = "sso_provider.updated"
+	AuditSSOProviderDeleted         = "sso_provider.deleted"
+	AuditSessionRevoked             = "session.revoked"
+	AuditSessionsRevoked            = "session.revoked_all"
+	AuditWebhookCreated             = "webhook.created"
+	AuditWebhookUpdated             = "webhook.updated"
+	AuditWebhookDeleted             = "webhook.deleted"
+	AuditWebhookSecretRotated       = "webhook.secret_rotated"
+	AuditWebhookRedelivered         = "webhook.redelivered"
+)
+
+// Target types of audit events besides the resource types roles can be
+// granted on
+const (
+	AuditTargetUser                = "user"
+	AuditTargetOrganization        = "organization"
+	AuditTargetCustomRole          = "custom_role"
+	AuditTargetRoleAssignment      = "role_assignment"
+	AuditTargetAPIKey              = "api_key"
+	AuditTargetTaskTemplate        = "task_template"
+	AuditTargetEnvironmentTemplate = "environment_template"
+	AuditTargetOIDCProvider        = "oidc_provider"
+	AuditTargetSAMLProvider        = "saml_provider"
+	AuditTargetSession             = "session"
+	AuditTargetWebhook             = "webhook"
+	AuditTargetWebhookDelivery     = "webhook_delivery"
+	// AuditTargetRoute is a rate-limited API route
+	AuditTargetRoute = "route"
+)
+
+// AuditGenesisHash is the previous hash of the first event of a chain
+var AuditGenesisHash = strings.Repeat("0", 64)
+
+// AuditEvent is an entry of the audit log: who did what to which target,
+// from where. Events are never changed once recorded.
+type AuditEvent struct {
+	// Sequence orders events across the log
+	Sequence       int64  `json:"sequence"`
+	ID             string `json:"id"`
+	OrganizationID string `json:"organization_id,omitempty"`
+	ActorType      string `json:"actor_type"`
+	ActorID        string `json:"actor_id,omitempty"`
+	Action         string `json:"action"`
+	TargetType     string `json:"target_type,omitempty"`
+	TargetID       string `json:"target_id,omitempty"`
+	IPAddress      string `json:"ip_address,omitempty"`
+	UserAgent      string `json:"user_agent,omitempty"`
+	// Diff holds the target's fields the event changed, see NewAuditDiff
+	Diff       json.RawMessage `json:"diff,omitempty"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	PrevHash   string          `json:"prev_hash"`
+	Hash       string          `json:"hash"`
+}
+
+// ComputeHash returns the hash of the event chained to prevHash, the hash of
+// the previous event of its organization
+func (e *AuditEvent) ComputeHash(prevHash string) string {
+	// Encoding the fields as a JSON array keeps them apart whatever they
+	// contain
+	content, _ := json.Marshal([]string{
+		prevHash,
+		e.ID,
+		e.OrganizationID,
+		e.ActorType,
+		e.ActorID,
+		e.Action,
+		e.TargetType,
+		e.TargetID,
+		e.IPAddress,
+		e.UserAgent,
+		string(e.Diff),
+		e.OccurredAt.UTC().Format(time.RFC3339Nano),
+	})
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// AuditFieldChange is a field's value before and after an audited change
+type AuditFieldChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// NewAuditDiff returns the top-level fields whose JSON encodings differ
+// between before and after, with their old and new values. Either may be
+// nil, for targets that were created or deleted. Fields hidden from JSON,
+// such as secrets, are left out.
+func NewAuditDiff(before, after interface{}) (json.RawMessage, error) {
+	b, err := auditFields(before)
+	if err != nil {
+		return nil, err
+	}
+	a, err := auditFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := make(map[string]AuditFieldChange)
+	for field, value := range b {
+		if !reflect.DeepEqual(value, a[field]) {
+			diff[field] = AuditFieldChange{Before: value, After: a[field]}
+		}
+	}
+	for field, value := range a {
+		if _, ok := b[field]; !ok {
+			diff[field] = AuditFieldChange{After: value}
+		}
+	}
+	if len(diff) == 0 {
+		return nil, nil
+	}
+
+	return json.Marshal(diff)
+}
+
+// auditFields decodes the JSON encoding of v into its fields
+func auditFields(v interface{}) (map[string]interface{}, error) {
+	if v == nil || (reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil()) {
+		return nil, nil
+	}
+	content, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(content, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// AuditFilter selects audit events. Empty fields match every event.
+type AuditFilter struct {
+	OrganizationID string
+	ActorID        string
+	Action         string
+	TargetType     string
+	TargetID       string
+	From           *time.Time
+	To             *time.Time
+}
@@ -0,0 +1,92 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestAuditEventComputeHash(t *testing.T) {
+	e := &AuditEvent{
+		ID:             "5f0c6f4e-3c1e-4d0b-9a51-2f7c8f1d2e3a",
+		OrganizationID: "org-1",
+		ActorType:      AuditActorUser,
+		ActorID:        "user-1",
+		Action:         AuditUserDeleted,
+		TargetType:     AuditTargetUser,
+		TargetID:       "user-2",
+		OccurredAt:     time.Date(2026, 1, 2, 3, 4, 5, 6000, time.UTC),
+	}
+	hash := e.ComputeHash(AuditGenesisHash)
+
+	if len(hash) != 64 {
+		t.Fatalf("ComputeHash() = %q, want a hex SHA-256", hash)
+	}
+	if got := e.ComputeHash(AuditGenesisHash); got != hash {
+		t.Error("ComputeHash() is not deterministic")
+	}
+	if got := e.ComputeHash(hash); got == hash {
+		t.Error("ComputeHash() ignores the previous hash")
+	}
+
+	local := *e
+	local.OccurredAt = e.OccurredAt.In(time.FixedZone("CET", 3600))
+	if got := local.ComputeHash(AuditGenesisHash); got != hash {
+		t.Error("ComputeHash() depends on the time zone of the event time")
+	}
+
+	changed := *e
+	changed.TargetID = "user-3"
+	if got := changed.ComputeHash(AuditGenesisHash); got == hash {
+		t.Error("ComputeHash() ignores the target")
+	}
+
+	// Moving text between adjacent fields changes the hash
+	shifted := *e
+	shifted.ActorType, shifted.ActorID = AuditActorUser+"user", "-1"
+	if got := shifted.ComputeHash(AuditGenesisHash); got == hash {
+		t.Error("ComputeHash() does not keep fields apart")
+	}
+}
+
+func TestNewAuditDiff(t *testing.T) {
+	before := &User{ID: "user-1", FirstName: "Ada", Role: RoleRecruiter, Status: StatusActive, PasswordHash: "old"}
+	after := *before
+	after.Role = RoleAdmin
+	after.PasswordHash = "new"
+
+	diff, err := NewAuditDiff(before, &after)
+	if err != nil {
+		t.Fatalf("NewAuditDiff() error = %v", err)
+	}
+	var changes map[string]AuditFieldChange
+	if err := json.Unmarshal(diff, &changes); err != nil {
+		t.Fatalf("Failed to decode diff: %v", err)
+	}
+	if len(changes) != 1 {
+		t.Fatalf("NewAuditDiff() = %s, want only the role", diff)
+	}
+	if c := changes["role"]; c.Before != RoleRecruiter || c.After != RoleAdmin {
+		t.Errorf("NewAuditDiff() role = %+v", c)
+	}
+
+	created, err := NewAuditDiff(nil, before)
+	if err != nil {
+		t.Fatalf("NewAuditDiff() error = %v", err)
+	}
+	if err := json.Unmarshal(created, &changes); err != nil {
+		t.Fatalf("Failed to decode diff: %v", err)
+	}
+	if c := changes["first_name"]; c.Before != nil || c.After != "Ada" {
+		t.Errorf("NewAuditDiff() of a created target first_name = %+v", c)
+	}
+
+	if unchanged, err := NewAuditDiff(before, before); err != nil || unchanged != nil {
+		t.Errorf("NewAuditDiff() of an unchanged target = %s, %v, want nil", unchanged, err)
+	}
+
+	var none *User
+	if deleted, err := NewAuditDiff(before, none); err != nil || deleted == nil {
+		t.Errorf("NewAuditDiff() of a deleted target = %s, %v", deleted, err)
+	}
+}
@@ -0,0 +1,27 @@
+package model
+
+import "time"
+
+// EmailTemplate is an organization's override of a built-in email template
+// for one kind of email and locale
+type EmailTemplate struct {
+	OrganizationID string    `json:"organization_id"`
+	Kind           string    `json:"kind"`
+	Locale         string    `json:"locale"`
+	Subject        string    `json:"subject"`
+	Text           string    `json:"text"`
+	HTML           string    `json:"html"`
+	UpdatedBy      string    `json:"updated_by,omitempty"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// EmailNotification records an email queued for sending
+type EmailNotification struct {
+	ID             string    `json:"id"`
+	OrganizationID string    `json:"organization_id,omitempty"`
+	Kind           string    `json:"kind"`
+	Recipient      string    `json:"recipient"`
+	DedupeKey      string    `json:"-"`
+	JobID          string    `json:"job_id,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
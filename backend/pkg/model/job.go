@@ -0,0 +1,44 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Job status constants
+const (
+	JobStatusQueued    = "queued"
+	JobStatusRunning   = "running"
+	JobStatusRetrying  = "retrying"
+	JobStatusSucceeded = "succeeded"
+	JobStatusFailed    = "failed"
+)
+
+// Job is a unit of background work run by the worker
+type Job struct {
+	ID             string `json:"id"`
+	Type           string `json:"type"`
+	OrganizationID string `json:"organization_id,omitempty"`
+	// IdempotencyKey, if set, makes enqueueing return the unfinished job
+	// with the same key instead of creating another one
+	IdempotencyKey  string          `json:"idempotency_key,omitempty"`
+	Status          string          `json:"status"`
+	Payload         json.RawMessage `json:"payload"`
+	Result          json.RawMessage `json:"result,omitempty"`
+	Attempts        int             `json:"attempts"`
+	MaxAttempts     int             `json:"max_attempts"`
+	Progress        int             `json:"progress"`
+	ProgressMessage string          `json:"progress_message,omitempty"`
+	LastError       string          `json:"last_error,omitempty"`
+	CreatedBy       string          `json:"created_by,omitempty"`
+	NextAttemptAt   time.Time       `json:"next_attempt_at,omitempty"`
+	StartedAt       time.Time       `json:"started_at,omitempty"`
+	FinishedAt      time.Time       `json:"finished_at,omitempty"`
+	CreatedAt       time.Time       `json:"created_at"`
+	UpdatedAt       time.Time       `json:"updated_at"`
+}
+
+// IsFinished returns true if the job succeeded or failed for good
+func (j *Job) IsFinished() bool {
+	return j.Status == JobStatusSucceeded || j.Status == JobStatusFailed
+}
@@ -0,0 +1,15 @@
+package model
+
+import "time"
+
+// MagicLink is a one-time link signing a candidate into one assessment
+type MagicLink struct {
+	ID             string     `json:"id"`
+	OrganizationID string     `json:"organization_id"`
+	AssessmentID   string     `json:"assessment_id"`
+	CandidateID    string     `json:"candidate_id"`
+	CreatedBy      string     `json:"created_by,omitempty"`
+	ExpiresAt      time.Time  `json:"expires_at"`
+	UsedAt         *time.Time `json:"used_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
@@ -0,0 +1,82 @@
+package model
+
+import "time"
+
+// MaxMFAChallengeAttempts is how many codes may be tried against one MFA
+// challenge before the user has to sign in again
+const MaxMFAChallengeAttempts = 5
+
+// RecoveryCodeCount is how many recovery codes a user is given at a time
+const RecoveryCodeCount = 10
+
+// mfaPolicyRoles are the roles an organization can require MFA for.
+// Candidates sign in with links.
+var mfaPolicyRoles = []string{RoleAdmin, RoleTemplateEditor, RoleRecruiter, RoleReviewer}
+
+// MFAEnrollment is a user's TOTP authenticator. It is pending until the user
+// confirms it with a code.
+type MFAEnrollment struct {
+	UserID string
+	Secret string
+	// LastUsedStep is the time step of the last code accepted, so that no
+	// code is accepted twice
+	LastUsedStep int64
+	ConfirmedAt  *time.Time
+	CreatedAt    time.Time
+}
+
+// IsConfirmed returns true if the enrollment was confirmed and codes are
+// asked for at sign-in
+func (e *MFAEnrollment) IsConfirmed() bool {
+	return e.ConfirmedAt != nil
+}
+
+// MFAChallenge is a sign-in that passed the password check and waits for the
+// user's second factor
+type MFAChallenge struct {
+	ID     string
+	UserID string
+	// Attempts counts the codes tried
+	Attempts    int
+	ExpiresAt   time.Time
+	CompletedAt *time.Time
+	CreatedAt   time.Time
+}
+
+// MFAPolicy is which of an organization's roles must sign in with MFA
+type MFAPolicy struct {
+	OrganizationID string    `json:"organization_id"`
+	RequiredRoles  []string  `json:"required_roles"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// Validate performs basic validation on the policy
+func (p *MFAPolicy) Validate() map[string]string {
+	errors := make(map[string]string)
+
+	for _, role := range p.RequiredRoles {
+		allowed := false
+		for _, r := range mfaPolicyRoles {
+			if r == role {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			errors["required_roles"] = "Invalid role: " + role
+			break
+		}
+	}
+
+	return errors
+}
+
+// Requires returns true if users with role must sign in with MFA
+func (p *MFAPolicy) Requires(role string) bool {
+	for _, r := range p.RequiredRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
@@ -25,6 +25,7 @@ type Organization struct {
 	BillingAddress     string `json:"billing_address,omitempty"`
 	LogoURL            string `json:"logo_url,omitempty"`
 	WebsiteURL         string `json:"website_url,omitempty"`
+	OwnerID            string `json:"owner_id,omitempty"`
 	// Subscription fields
 	SubscriptionStartDate time.Time       `json:"subscription_start_date"`
 	SubscriptionEndDate   time.Time       `json:"subscription_end_date"`
@@ -34,6 +35,10 @@ type Organization struct {
 	PaymentMethodDetails  json.RawMessage `json:"payment_method_details,omitempty"`
 	AutoRenew             bool            `json:"auto_renew"`
 	EmailReminders        bool            `json:"email_reminders"`
+	// EmailLocale is the language of the emails sent on the organization's behalf
+	EmailLocale string `json:"email_locale"`
+	// BillingCustomerID is the organization's customer ID at the payment provider
+	BillingCustomerID string `json:"-"`
 	// Legacy fields removed - now handled by OrganizationQuota
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
@@ -55,11 +60,26 @@ func NewOrganization(id, name, contactEmail string) *Organization {
 		PaymentDueDate:        thirtyDaysLater,
 		AutoRenew:             true,
 		EmailReminders:        true,
+		EmailLocale:           "en",
 		CreatedAt:             now,
 		UpdatedAt:             now,
 	}
 }
 
+// BillingAnchor returns the time the organization's monthly billing periods
+// are counted from
+func (o *Organization) BillingAnchor() time.Time {
+	if o.SubscriptionStartDate.Year() < 2000 {
+		return o.CreatedAt
+	}
+	return o.SubscriptionStartDate
+}
+
+// IsOwnedBy returns true if the given user owns the organization
+func (o *Organization) IsOwnedBy(userID string) bool {
+	return o.OwnerID != "" && o.OwnerID == userID
+}
+
 // IsEnterprise returns true if the organization has an enterprise subscription
 func (o *Organization) IsEnterprise() bool {
 	return o.SubscriptionPlan == OrgPlanEnterprise
@@ -90,6 +110,28 @@ func (o *Organization) IsSubscriptionExpired() bool {
 	return time.Now().UTC().After(o.SubscriptionEndDate)
 }
 
+// SubscriptionError returns why the organization may not consume new resources,
+// or nil if its subscription allows it. Active subscriptions past their end date
+// are still allowed while AutoRenew is on, as renewal is pending.
+func (o *Organization) SubscriptionError() error {
+	switch o.SubscriptionStatus {
+	case SubscriptionStatusActive:
+		if !o.AutoRenew && !o.SubscriptionEndDate.IsZero() && o.IsSubscriptionExpired() {
+			return ErrSubscriptionExpired
+		}
+		return nil
+	case SubscriptionStatusTrial:
+		if !o.IsTrialActive() {
+			return ErrTrialExpired
+		}
+		return nil
+	case SubscriptionStatusPastDue:
+		return ErrSubscriptionPastDue
+	default:
+		return ErrSubscriptionInactive
+	}
+}
+
 // DaysUntilExpiration returns the number of days until the subscription expires
 func (o *Organization) DaysUntilExpiration() int {
 	now := time.Now().UTC()
@@ -135,10 +177,7 @@ func (o *Organization) Validate() map[string]string {
 	}
 
 	// Plan validation
-	switch o.SubscriptionPlan {
-	case OrgPlanStarter, OrgPlanTeam, OrgPlanEnterprise:
-		// Valid plan
-	default:
+	if !IsValidPlan(o.SubscriptionPlan) {
 		errors["subscription_plan"] = "Invalid subscription plan"
 	}
 
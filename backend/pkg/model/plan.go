@@ -0,0 +1,234 @@
+package model
+
+import "time"
+
+// Quota change types recorded in the quota change audit trail
+const (
+	QuotaChangePlan     = "plan_change"
+	QuotaChangeOverride = "override"
+)
+
+// QuotaLimits are the limits of an organization quota, without its usage counters
+type QuotaLimits struct {
+	MaxUsers                     int `json:"max_users"`
+	MaxTaskTemplates             int `json:"max_task_templates"`
+	MaxEnvironmentTemplates      int `json:"max_environment_templates"`
+	MaxAssessmentTemplates       int `json:"max_assessment_templates"`
+	MaxAssessmentsPerMonth       int `json:"max_assessments_per_month"`
+	MaxConcurrentEnvironments    int `json:"max_concurrent_environments"`
+	IncludedEnvironmentMinutes   int `json:"included_environment_minutes"`
+	MaxEnvironmentRuntimeMinutes int `json:"max_environment_runtime_minutes"`
+	MaxSnapshotRetentionDays     int `json:"max_snapshot_retention_days"`
+}
+
+// planLimits holds the default limits of each plan
+var planLimits = map[string]QuotaLimits{
+	OrgPlanStarter: {
+		MaxUsers:                     10,
+		MaxTaskTemplates:             5,
+		MaxEnvironmentTemplates:      5,
+		MaxAssessmentTemplates:       5,
+		MaxAssessmentsPerMonth:       10,
+		MaxConcurrentEnvironments:    2,
+		IncludedEnvironmentMinutes:   900,
+		MaxEnvironmentRuntimeMinutes: 120,
+		MaxSnapshotRetentionDays:     7,
+	},
+	OrgPlanTeam: {
+		MaxUsers:                     100, // Unlimited but set a high value
+		MaxTaskTemplates:             20,
+		MaxEnvironmentTemplates:      20,
+		MaxAssessmentTemplates:       20,
+		MaxAssessmentsPerMonth:       40,
+		MaxConcurrentEnvironments:    10,
+		IncludedEnvironmentMinutes:   3600,
+		MaxEnvironmentRuntimeMinutes: 480, // 8 hours
+		MaxSnapshotRetentionDays:     30,
+	},
+	OrgPlanEnterprise: {
+		MaxUsers:                     1000, // Unlimited but set a very high value
+		MaxTaskTemplates:             100,
+		MaxEnvironmentTemplates:      100,
+		MaxAssessmentTemplates:       100,
+		MaxAssessmentsPerMonth:       1000,
+		MaxConcurrentEnvironments:    50,
+		IncludedEnvironmentMinutes:   10000,
+		MaxEnvironmentRuntimeMinutes: 1440, // 24 hours
+		MaxSnapshotRetentionDays:     90,
+	},
+}
+
+// IsValidPlan returns true if plan is a known subscription plan
+func IsValidPlan(plan string) bool {
+	_, ok := planLimits[plan]
+	return ok
+}
+
+// PlanLimits returns the default limits of a plan. Unknown plans get the
+// starter plan limits.
+func PlanLimits(plan string) QuotaLimits {
+	if limits, ok := planLimits[plan]; ok {
+		return limits
+	}
+	return planLimits[OrgPlanStarter]
+}
+
+// EffectiveLimits returns the limits an organization on plan gets. Custom
+// overrides are part of enterprise contracts and only apply on that plan.
+func EffectiveLimits(plan string, overrides *QuotaOverrides) QuotaLimits {
+	limits := PlanLimits(plan)
+	if plan == OrgPlanEnterprise && overrides != nil {
+		limits = overrides.Apply(limits)
+	}
+	return limits
+}
+
+// QuotaOverrides are an organization's custom quota limits. Nil fields keep
+// the plan default.
+type QuotaOverrides struct {
+	OrganizationID               string    `json:"organization_id"`
+	MaxUsers                     *int      `json:"max_users,omitempty"`
+	MaxTaskTemplates             *int      `json:"max_task_templates,omitempty"`
+	MaxEnvironmentTemplates      *int      `json:"max_environment_templates,omitempty"`
+	MaxAssessmentTemplates       *int      `json:"max_assessment_templates,omitempty"`
+	MaxAssessmentsPerMonth       *int      `json:"max_assessments_per_month,omitempty"`
+	MaxConcurrentEnvironments    *int      `json:"max_concurrent_environments,omitempty"`
+	IncludedEnvironmentMinutes   *int      `json:"included_environment_minutes,omitempty"`
+	MaxEnvironmentRuntimeMinutes *int      `json:"max_environment_runtime_minutes,omitempty"`
+	MaxSnapshotRetentionDays     *int      `json:"max_snapshot_retention_days,omitempty"`
+	Note                         string    `json:"note,omitempty"`
+	UpdatedBy                    string    `json:"updated_by,omitempty"`
+	UpdatedAt                    time.Time `json:"updated_at"`
+}
+
+// fields returns the override fields by the name of the limit they override
+func (o *QuotaOverrides) fields() map[string]*int {
+	return map[string]*int{
+		"max_users":                       o.MaxUsers,
+		"max_task_templates":              o.MaxTaskTemplates,
+		"max_environment_templates":       o.MaxEnvironmentTemplates,
+		"max_assessment_templates":        o.MaxAssessmentTemplates,
+		"max_assessments_per_month":       o.MaxAssessmentsPerMonth,
+		"max_concurrent_environments":     o.MaxConcurrentEnvironments,
+		"included_environment_minutes":    o.IncludedEnvironmentMinutes,
+		"max_environment_runtime_minutes": o.MaxEnvironmentRuntimeMinutes,
+		"max_snapshot_retention_days":     o.MaxSnapshotRetentionDays,
+	}
+}
+
+// Apply returns limits with the overridden fields replaced
+func (o *QuotaOverrides) Apply(limits QuotaLimits) QuotaLimits {
+	override := func(limit *int, value *int) {
+		if value != nil {
+			*limit = *value
+		}
+	}
+	override(&limits.MaxUsers, o.MaxUsers)
+	override(&limits.MaxTaskTemplates, o.MaxTaskTemplates)
+	override(&limits.MaxEnvironmentTemplates, o.MaxEnvironmentTemplates)
+	override(&limits.MaxAssessmentTemplates, o.MaxAssessmentTemplates)
+	override(&limits.MaxAssessmentsPerMonth, o.MaxAssessmentsPerMonth)
+	override(&limits.MaxConcurrentEnvironments, o.MaxConcurrentEnvironments)
+	override(&limits.IncludedEnvironmentMinutes, o.IncludedEnvironmentMinutes)
+	override(&limits.MaxEnvironmentRuntimeMinutes, o.MaxEnvironmentRuntimeMinutes)
+	override(&limits.MaxSnapshotRetentionDays, o.MaxSnapshotRetentionDays)
+	return limits
+}
+
+// IsEmpty returns true if no limit is overridden
+func (o *QuotaOverrides) IsEmpty() bool {
+	for _, value := range o.fields() {
+		if value != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Validate performs basic validation on the overridden limits
+func (o *QuotaOverrides) Validate() map[string]string {
+	errors := make(map[string]string)
+	for field, value := range o.fields() {
+		if value != nil && *value < 0 {
+			errors[field] = "Limit must not be negative"
+		}
+	}
+	return errors
+}
+
+// QuotaOverage is a resource whose current usage is above its limit
+type QuotaOverage struct {
+	Resource string `json:"resource"`
+	Limit    int    `json:"limit"`
+	Current  int    `json:"current"`
+}
+
+// Limits returns the quota's limits
+func (q *OrganizationQuota) Limits() QuotaLimits {
+	return QuotaLimits{
+		MaxUsers:                     q.MaxUsers,
+		MaxTaskTemplates:             q.MaxTaskTemplates,
+		MaxEnvironmentTemplates:      q.MaxEnvironmentTemplates,
+		MaxAssessmentTemplates:       q.MaxAssessmentTemplates,
+		MaxAssessmentsPerMonth:       q.MaxAssessmentsPerMonth,
+		MaxConcurrentEnvironments:    q.MaxConcurrentEnvironments,
+		IncludedEnvironmentMinutes:   q.IncludedEnvironmentMinutes,
+		MaxEnvironmentRuntimeMinutes: q.MaxEnvironmentRuntimeMinutes,
+		MaxSnapshotRetentionDays:     q.MaxSnapshotRetentionDays,
+	}
+}
+
+// SetLimits replaces the quota's limits, keeping its usage counters
+func (q *OrganizationQuota) SetLimits(limits QuotaLimits) {
+	q.MaxUsers = limits.MaxUsers
+	q.MaxTaskTemplates = limits.MaxTaskTemplates
+	q.MaxEnvironmentTemplates = limits.MaxEnvironmentTemplates
+	q.MaxAssessmentTemplates = limits.MaxAssessmentTemplates
+	q.MaxAssessmentsPerMonth = limits.MaxAssessmentsPerMonth
+	q.MaxConcurrentEnvironments = limits.MaxConcurrentEnvironments
+	q.IncludedEnvironmentMinutes = limits.IncludedEnvironmentMinutes
+	q.MaxEnvironmentRuntimeMinutes = limits.MaxEnvironmentRuntimeMinutes
+	q.MaxSnapshotRetentionDays = limits.MaxSnapshotRetentionDays
+}
+
+// Overages returns the resources whose current usage is above limits. The
+// resource names match QuotaResources.
+func (q *OrganizationQuota) Overages(limits QuotaLimits) []QuotaOverage {
+	usage := []QuotaOverage{
+		{"users", limits.MaxUsers, q.CurrentUsers},
+		{"task_templates", limits.MaxTaskTemplates, q.CurrentTaskTemplates},
+		{"environment_templates", limits.MaxEnvironmentTemplates, q.CurrentEnvironmentTemplates},
+		{"assessment_templates", limits.MaxAssessmentTemplates, q.CurrentAssessmentTemplates},
+		{"assessments", limits.MaxAssessmentsPerMonth, q.AssessmentsThisMonth},
+		{"environments", limits.MaxConcurrentEnvironments, q.CurrentActiveEnvironments},
+		{"environment_minutes", limits.IncludedEnvironmentMinutes, q.UsedEnvironmentMinutes},
+	}
+
+	var overages []QuotaOverage
+	for _, u := range usage {
+		if u.Current > u.Limit {
+			overages = append(overages, u)
+		}
+	}
+	return overages
+}
+
+// QuotaChange is an entry of the audit trail of changes to an organization's
+// plan or quota limits
+type QuotaChange struct {
+	ID             string      `json:"id"`
+	OrganizationID string      `json:"organization_id"`
+	Type           string      `json:"type"`
+	FromPlan       string      `json:"from_plan"`
+	ToPlan         string      `json:"to_plan"`
+	PreviousLimits QuotaLimits `json:"previous_limits"`
+	NewLimits      QuotaLimits `json:"new_limits"`
+	// Overages are the resources already above the new limits when the
+	// change was made. Existing resources are kept; new ones are refused
+	// until usage drops below the limit.
+	Overages        []QuotaOverage `json:"overages"`
+	BillingRecordID string         `json:"billing_record_id,omitempty"`
+	ChangedBy       string         `json:"changed_by,omitempty"`
+	Note            string         `json:"note,omitempty"`
+	CreatedAt       time.Time      `json:"created_at"`
+}
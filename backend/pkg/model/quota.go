@@ -30,6 +30,17 @@ const (
 	ResourceTypeEnvironmentMinutes = "environment_minutes"
 )
 
+// QuotaResources lists the resource names understood by UsagePercentage
+var QuotaResources = []string{
+	"users",
+	"task_templates",
+	"environment_templates",
+	"assessment_templates",
+	"assessments",
+	"environments",
+	"environment_minutes",
+}
+
 // Subscription-related errors
 var (
 	ErrSubscriptionInactive      = errors.New("subscription is inactive")
@@ -37,6 +48,8 @@ var (
 	ErrTrialExpired              = errors.New("trial period has expired")
 	ErrSubscriptionLimitExceeded = errors.New("subscription limit exceeded")
 	ErrQuotaLimitExceeded        = errors.New("organization quota limit exceeded")
+	ErrSubscriptionPastDue       = errors.New("subscription payment is past due")
+	ErrInvalidBillingTransition  = errors.New("invalid billing record status transition")
 )
 
 // OrganizationQuota represents the usage limits and current usage for an organization
@@ -68,65 +81,26 @@ type OrganizationQuota struct {
 	MaxEnvironmentRuntimeMinutes int `json:"max_environment_runtime_minutes"`
 	MaxSnapshotRetentionDays     int `json:"max_snapshot_retention_days"`
 
+	// Billing period the monthly counters apply to
+	PeriodStart time.Time `json:"period_start"`
+	PeriodEnd   time.Time `json:"period_end"`
+
 	// Tracking fields
 	UpdatedAt time.Time `json:"updated_at"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
-// NewOrganizationQuota creates a new organization quota with default values based on plan type
+// NewOrganizationQuota creates a new organization quota with the default limits of the plan
 func NewOrganizationQuota(organizationID, planType string) *OrganizationQuota {
 	now := time.Now().UTC()
 	quota := &OrganizationQuota{
 		OrganizationID: organizationID,
+		PeriodStart:    now,
+		PeriodEnd:      AddMonths(now, 1),
 		UpdatedAt:      now,
 		CreatedAt:      now,
 	}
-
-	// Set default values based on plan type
-	switch planType {
-	case OrgPlanStarter:
-		quota.MaxUsers = 10
-		quota.MaxTaskTemplates = 5
-		quota.MaxEnvironmentTemplates = 5
-		quota.MaxAssessmentTemplates = 5
-		quota.MaxAssessmentsPerMonth = 10
-		quota.MaxConcurrentEnvironments = 2
-		quota.IncludedEnvironmentMinutes = 900
-		quota.MaxEnvironmentRuntimeMinutes = 120
-		quota.MaxSnapshotRetentionDays = 7
-	case OrgPlanTeam:
-		quota.MaxUsers = 100 // Unlimited but set a high value
-		quota.MaxTaskTemplates = 20
-		quota.MaxEnvironmentTemplates = 20
-		quota.MaxAssessmentTemplates = 20
-		quota.MaxAssessmentsPerMonth = 40
-		quota.MaxConcurrentEnvironments = 10
-		quota.IncludedEnvironmentMinutes = 3600
-		quota.MaxEnvironmentRuntimeMinutes = 480 // 8 hours
-		quota.MaxSnapshotRetentionDays = 30
-	case OrgPlanEnterprise:
-		quota.MaxUsers = 1000 // Unlimited but set a very high value
-		quota.MaxTaskTemplates = 100
-		quota.MaxEnvironmentTemplates = 100
-		quota.MaxAssessmentTemplates = 100
-		quota.MaxAssessmentsPerMonth = 1000
-		quota.MaxConcurrentEnvironments = 50
-		quota.IncludedEnvironmentMinutes = 10000
-		quota.MaxEnvironmentRuntimeMinutes = 1440 // 24 hours
-		quota.MaxSnapshotRetentionDays = 90
-	default:
-		// Use starter plan limits as default
-		quota.MaxUsers = 10
-		quota.MaxTaskTemplates = 5
-		quota.MaxEnvironmentTemplates = 5
-		quota.MaxAssessmentTemplates = 5
-		quota.MaxAssessmentsPerMonth = 10
-		quota.MaxConcurrentEnvironments = 2
-		quota.IncludedEnvironmentMinutes = 900
-		quota.MaxEnvironmentRuntimeMinutes = 120
-		quota.MaxSnapshotRetentionDays = 7
-	}
-
+	quota.SetLimits(PlanLimits(planType))
 	return quota
 }
 
@@ -169,6 +143,42 @@ func (q *OrganizationQuota) RemainingEnvironmentMinutes() int {
 	return remaining
 }
 
+// IsPeriodOver returns true if the quota's billing period has ended and its
+// monthly counters are due to be reset
+func (q *OrganizationQuota) IsPeriodOver(now time.Time) bool {
+	return !now.Before(q.PeriodEnd)
+}
+
+// AddMonths adds n months to t, clamping the day to the length of the target
+// month so that a subscription started on the 31st renews on the last day of
+// shorter months instead of spilling into the next one
+func AddMonths(t time.Time, n int) time.Time {
+	year, month, day := t.Date()
+	firstOfTarget := time.Date(year, month+time.Month(n), 1, 0, 0, 0, 0, t.Location())
+	lastDay := firstOfTarget.AddDate(0, 1, -1).Day()
+	if day > lastDay {
+		day = lastDay
+	}
+	return time.Date(firstOfTarget.Year(), firstOfTarget.Month(), day, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+}
+
+// BillingPeriod returns the monthly billing period containing now for a
+// subscription anchored at anchor. Periods start on each monthly anniversary
+// of anchor.
+func BillingPeriod(anchor, now time.Time) (time.Time, time.Time) {
+	if now.Before(anchor) {
+		return anchor, AddMonths(anchor, 1)
+	}
+
+	months := (now.Year()-anchor.Year())*12 + int(now.Month()-anchor.Month())
+	start := AddMonths(anchor, months)
+	if start.After(now) {
+		months--
+		start = AddMonths(anchor, months)
+	}
+	return start, AddMonths(anchor, months+1)
+}
+
 // UsagePercentage returns the percentage of usage for a specific resource
 func (q *OrganizationQuota) UsagePercentage(resourceType string) float64 {
 	switch resourceType {
@@ -212,6 +222,15 @@ func (q *OrganizationQuota) UsagePercentage(resourceType string) float64 {
 	}
 }
 
+// UsageSummary returns the usage percentage of every resource in QuotaResources
+func (q *OrganizationQuota) UsageSummary() map[string]float64 {
+	summary := make(map[string]float64, len(QuotaResources))
+	for _, resource := range QuotaResources {
+		summary[resource] = q.UsagePercentage(resource)
+	}
+	return summary
+}
+
 // BillingRecord represents a payment transaction for an organization
 type BillingRecord struct {
 	ID                 string    `json:"id"`
@@ -227,7 +246,12 @@ type BillingRecord struct {
 	PaymentDate        time.Time `json:"payment_date,omitempty"`
 	BillingPeriodStart time.Time `json:"billing_period_start,omitempty"`
 	BillingPeriodEnd   time.Time `json:"billing_period_end,omitempty"`
-	CreatedAt          time.Time `json:"created_at"`
+	FailureReason      string    `json:"failure_reason,omitempty"`
+	// IdempotencyKey identifies the charge at the payment provider, so that
+	// retrying it never charges twice
+	IdempotencyKey string    `json:"-"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
 }
 
 // NewBillingRecord creates a new billing record
@@ -239,6 +263,24 @@ func NewBillingRecord(organizationID string, amount float64, status string, desc
 		Status:         status,
 		Description:    description,
 		CreatedAt:      time.Now().UTC(),
+		UpdatedAt:      time.Now().UTC(),
+	}
+}
+
+// CanTransitionTo reports whether the record may move from its current status
+// to status. Pending charges settle as successful or failed, a failed charge
+// may still succeed when the provider retries it, and only successful charges
+// can be refunded.
+func (b *BillingRecord) CanTransitionTo(status string) bool {
+	switch b.Status {
+	case BillingStatusPending:
+		return status == BillingStatusSuccessful || status == BillingStatusFailed
+	case BillingStatusFailed:
+		return status == BillingStatusSuccessful
+	case BillingStatusSuccessful:
+		return status == BillingStatusRefunded
+	default:
+		return false
 	}
 }
 
@@ -294,3 +336,52 @@ func (u *UsageRecord) WithDetails(details map[string]interface{}) *UsageRecord {
 	}
 	return u
 }
+
+// Environment session stop reasons
+const (
+	EnvironmentStopDeleted        = "deleted"
+	EnvironmentStopRuntimeLimit   = "runtime_limit"
+	EnvironmentStopPodDisappeared = "pod_disappeared"
+)
+
+// EnvironmentSession tracks the lifetime of one terminal pod for metering
+type EnvironmentSession struct {
+	ID             string     `json:"id"`
+	PodName        string     `json:"pod_name"`
+	OrganizationID string     `json:"organization_id"`
+	AssessmentID   string     `json:"assessment_id,omitempty"`
+	SessionID      string     `json:"session_id,omitempty"`
+	CPU            string     `json:"cpu,omitempty"`
+	Memory         string     `json:"memory,omitempty"`
+	StartedAt      time.Time  `json:"started_at"`
+	MeteredUntil   time.Time  `json:"metered_until"`
+	LastSeenAt     time.Time  `json:"last_seen_at"`
+	StoppedAt      *time.Time `json:"stopped_at,omitempty"`
+	StopReason     string     `json:"stop_reason,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// BillableMinutes returns the minutes between MeteredUntil and until that are
+// due. Running sessions are billed in whole minutes only; the final bill of a
+// stopped session rounds the remaining partial minute up.
+func (s *EnvironmentSession) BillableMinutes(until time.Time, final bool) int {
+	elapsed := until.Sub(s.MeteredUntil)
+	if elapsed <= 0 {
+		return 0
+	}
+
+	minutes := int(elapsed / time.Minute)
+	if final && elapsed%time.Minute > 0 {
+		minutes++
+	}
+	return minutes
+}
+
+// HasExceededRuntime checks if the session has run longer than maxMinutes.
+// A limit of zero or less means no limit.
+func (s *EnvironmentSession) HasExceededRuntime(maxMinutes int, now time.Time) bool {
+	if maxMinutes <= 0 {
+		return false
+	}
+	return now.Sub(s.StartedAt) >= time.Duration(maxMinutes)*time.Minute
+}
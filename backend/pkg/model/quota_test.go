@@ -0,0 +1,111 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAddMonths(t *testing.T) {
+	tests := []struct {
+		from time.Time
+		n    int
+		want time.Time
+	}{
+		{time.Date(2025, 1, 15, 8, 0, 0, 0, time.UTC), 1, time.Date(2025, 2, 15, 8, 0, 0, 0, time.UTC)},
+		{time.Date(2025, 1, 31, 8, 0, 0, 0, time.UTC), 1, time.Date(2025, 2, 28, 8, 0, 0, 0, time.UTC)},
+		{time.Date(2024, 1, 31, 8, 0, 0, 0, time.UTC), 1, time.Date(2024, 2, 29, 8, 0, 0, 0, time.UTC)},
+		{time.Date(2025, 1, 31, 8, 0, 0, 0, time.UTC), 2, time.Date(2025, 3, 31, 8, 0, 0, 0, time.UTC)},
+		{time.Date(2025, 11, 30, 8, 0, 0, 0, time.UTC), 3, time.Date(2026, 2, 28, 8, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		if got := AddMonths(tt.from, tt.n); !got.Equal(tt.want) {
+			t.Errorf("AddMonths(%v, %d): expected %v, got %v", tt.from, tt.n, tt.want, got)
+		}
+	}
+}
+
+func TestBillingPeriod(t *testing.T) {
+	anchor := time.Date(2025, 1, 31, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		now       time.Time
+		wantStart time.Time
+		wantEnd   time.Time
+	}{
+		{anchor.Add(time.Hour), anchor, time.Date(2025, 2, 28, 12, 0, 0, 0, time.UTC)},
+		{time.Date(2025, 3, 15, 0, 0, 0, 0, time.UTC), time.Date(2025, 2, 28, 12, 0, 0, 0, time.UTC), time.Date(2025, 3, 31, 12, 0, 0, 0, time.UTC)},
+		{time.Date(2025, 3, 31, 11, 0, 0, 0, time.UTC), time.Date(2025, 2, 28, 12, 0, 0, 0, time.UTC), time.Date(2025, 3, 31, 12, 0, 0, 0, time.UTC)},
+		{time.Date(2025, 3, 31, 12, 0, 0, 0, time.UTC), time.Date(2025, 3, 31, 12, 0, 0, 0, time.UTC), time.Date(2025, 4, 30, 12, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		start, end := BillingPeriod(anchor, tt.now)
+		if !start.Equal(tt.wantStart) || !end.Equal(tt.wantEnd) {
+			t.Errorf("BillingPeriod at %v: expected [%v, %v), got [%v, %v)", tt.now, tt.wantStart, tt.wantEnd, start, end)
+		}
+	}
+}
+
+func TestBillingRecordCanTransitionTo(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     bool
+	}{
+		{BillingStatusPending, BillingStatusSuccessful, true},
+		{BillingStatusPending, BillingStatusFailed, true},
+		{BillingStatusPending, BillingStatusRefunded, false},
+		{BillingStatusFailed, BillingStatusSuccessful, true},
+		{BillingStatusFailed, BillingStatusRefunded, false},
+		{BillingStatusSuccessful, BillingStatusRefunded, true},
+		{BillingStatusSuccessful, BillingStatusFailed, false},
+		{BillingStatusRefunded, BillingStatusSuccessful, false},
+	}
+
+	for _, tt := range tests {
+		record := BillingRecord{Status: tt.from}
+		if got := record.CanTransitionTo(tt.to); got != tt.want {
+			t.Errorf("%s -> %s: expected %v, got %v", tt.from, tt.to, tt.want, got)
+		}
+	}
+}
+
+func TestEffectiveLimits(t *testing.T) {
+	users := 250
+	overrides := &QuotaOverrides{MaxUsers: &users}
+
+	if got := EffectiveLimits(OrgPlanEnterprise, overrides); got.MaxUsers != 250 || got.MaxTaskTemplates != 100 {
+		t.Errorf("Expected enterprise limits with 250 users, got %+v", got)
+	}
+	if got := EffectiveLimits(OrgPlanTeam, overrides); got != PlanLimits(OrgPlanTeam) {
+		t.Errorf("Expected overrides to be ignored on the team plan, got %+v", got)
+	}
+	if got := PlanLimits("unknown"); got != PlanLimits(OrgPlanStarter) {
+		t.Errorf("Expected starter limits for an unknown plan, got %+v", got)
+	}
+}
+
+func TestOrganizationQuotaOverages(t *testing.T) {
+	quota := NewOrganizationQuota("org", OrgPlanTeam)
+	quota.CurrentUsers = 12
+	quota.CurrentTaskTemplates = 5
+	quota.CurrentEnvironmentTemplates = 6
+
+	overages := quota.Overages(PlanLimits(OrgPlanStarter))
+	want := []QuotaOverage{
+		{Resource: "users", Limit: 10, Current: 12},
+		{Resource: "environment_templates", Limit: 5, Current: 6},
+	}
+	if len(overages) != len(want) {
+		t.Fatalf("Expected overages %+v, got %+v", want, overages)
+	}
+	for i := range want {
+		if overages[i] != want[i] {
+			t.Errorf("Expected overage %+v, got %+v", want[i], overages[i])
+		}
+	}
+
+	if overages := quota.Overages(PlanLimits(OrgPlanTeam)); len(overages) != 0 {
+		t.Errorf("Expected no overages on the current plan, got %+v", overages)
+	}
+}
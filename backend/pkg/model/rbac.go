@@ -0,0 +1,258 @@
+package model
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Permissions, named resource:action. Routes declare the permissions they
+// need; users get them from their roles.
+const (
+	PermissionOrganizationRead   = "organization:read"
+	PermissionOrganizationManage = "organization:manage"
+	PermissionUsersRead          = "users:read"
+	PermissionUsersManage        = "users:manage"
+	PermissionRolesManage        = "roles:manage"
+	PermissionTemplatesRead      = "templates:read"
+	PermissionTemplatesWrite     = "templates:write"
+	PermissionAssessmentsRead    = "assessments:read"
+	PermissionAssessmentsWrite   = "assessments:write"
+	PermissionAssessmentsTake    = "assessments:take"
+	PermissionReviewsRead        = "reviews:read"
+	PermissionAuditRead          = "audit:read"
+)
+
+// Permissions lists every permission
+var Permissions = []string{
+	PermissionOrganizationRead,
+	PermissionOrganizationManage,
+	PermissionUsersRead,
+	PermissionUsersManage,
+	PermissionRolesManage,
+	PermissionTemplatesRead,
+	PermissionTemplatesWrite,
+	PermissionAssessmentsRead,
+	PermissionAssessmentsWrite,
+	PermissionAssessmentsTake,
+	PermissionReviewsRead,
+	PermissionAuditRead,
+}
+
+// IsValidPermission returns true if permission is a known permission
+func IsValidPermission(permission string) bool {
+	for _, p := range Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// BuiltInRolePermissions are the permissions of the built-in roles, as laid
+// out in rbac-design.md. Super admins are allowed everything and are not
+// listed.
+var BuiltInRolePermissions = map[string][]string{
+	RoleAdmin: {
+		PermissionOrganizationRead,
+		PermissionOrganizationManage,
+		PermissionUsersRead,
+		PermissionUsersManage,
+		PermissionRolesManage,
+		PermissionTemplatesRead,
+		PermissionTemplatesWrite,
+		PermissionAssessmentsRead,
+		PermissionAssessmentsWrite,
+		PermissionReviewsRead,
+		PermissionAuditRead,
+	},
+	RoleTemplateEditor: {
+		PermissionOrganizationRead,
+		PermissionTemplatesRead,
+		PermissionTemplatesWrite,
+	},
+	RoleRecruiter: {
+		PermissionOrganizationRead,
+		PermissionTemplatesRead,
+		PermissionAssessmentsRead,
+		PermissionAssessmentsWrite,
+	},
+	RoleReviewer: {
+		PermissionOrganizationRead,
+		PermissionTemplatesRead,
+		PermissionReviewsRead,
+	},
+	RoleCandidate: {
+		PermissionAssessmentsTake,
+	},
+}
+
+// Resource types roles can be granted on
+const (
+	ResourceAssessmentTemplate = "assessment_template"
+	ResourceAssessment         = "assessment"
+)
+
+// IsValidResourceType returns true if roles can be granted on resourceType
+func IsValidResourceType(resourceType string) bool {
+	return resourceType == ResourceAssessmentTemplate || resourceType == ResourceAssessment
+}
+
+// CustomRole is a role an organization defines with its own set of
+// permissions
+type CustomRole struct {
+	ID             string    `json:"id"`
+	OrganizationID string    `json:"organization_id"`
+	Name           string    `json:"name"`
+	Description    string    `json:"description,omitempty"`
+	Permissions    []string  `json:"permissions"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// Validate performs basic validation on the role
+func (r *CustomRole) Validate() map[string]string {
+	errors := make(map[string]string)
+
+	name := strings.TrimSpace(r.Name)
+	switch {
+	case name == "":
+		errors["name"] = "Name is required"
+	case len(name) > 100:
+		errors["name"] = "Name must be at most 100 characters"
+	case BuiltInRolePermissions[name] != nil || name == RoleSuperAdmin || name == RoleService:
+		errors["name"] = "Name is taken by a built-in role"
+	}
+
+	if len(r.Permissions) == 0 {
+		errors["permissions"] = "At least one permission is required"
+	}
+	for _, p := range r.Permissions {
+		if !IsValidPermission(p) {
+			errors["permissions"] = "Unknown permission: " + p
+			break
+		}
+		// Taking assessments is for candidates, who are not given roles
+		if p == PermissionAssessmentsTake {
+			errors["permissions"] = "Permission cannot be granted by a custom role: " + p
+			break
+		}
+	}
+
+	if len(r.Description) > 255 {
+		errors["description"] = "Description must be at most 255 characters"
+	}
+
+	return errors
+}
+
+// RoleAssignment gives a user a role on top of the one they were created
+// with: a built-in role or one of their organization's custom roles. An
+// assignment with a resource grants the role's permissions on that resource
+// only.
+type RoleAssignment struct {
+	ID           string `json:"id"`
+	UserID       string `json:"user_id"`
+	Role         string `json:"role,omitempty"`
+	CustomRoleID string `json:"custom_role_id,omitempty"`
+	ResourceType string `json:"resource_type,omitempty"`
+	ResourceID   string `json:"resource_id,omitempty"`
+	// Permissions are the permissions of the custom role, loaded with
+	// assignments that are resolved
+	Permissions []string  `json:"-"`
+	CreatedBy   string    `json:"created_by,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Validate performs basic validation on the assignment
+func (a *RoleAssignment) Validate() map[string]string {
+	errors := make(map[string]string)
+
+	switch {
+	case (a.Role == "") == (a.CustomRoleID == ""):
+		errors["role"] = "Exactly one of role and custom role is required"
+	case a.Role == RoleCandidate || a.Role == RoleSuperAdmin:
+		errors["role"] = "Role cannot be assigned: " + a.Role
+	case a.Role != "" && BuiltInRolePermissions[a.Role] == nil:
+		errors["role"] = "Unknown role: " + a.Role
+	}
+
+	if a.ResourceType != "" || a.ResourceID != "" {
+		if !IsValidResourceType(a.ResourceType) {
+			errors["resource_type"] = "Unknown resource type: " + a.ResourceType
+		}
+		if _, err := uuid.Parse(a.ResourceID); err != nil {
+			errors["resource_id"] = "Resource ID must be a UUID"
+		}
+	}
+
+	return errors
+}
+
+// permissions returns the permissions the assignment grants
+func (a *RoleAssignment) permissions() []string {
+	if a.Role != "" {
+		return BuiltInRolePermissions[a.Role]
+	}
+	return a.Permissions
+}
+
+// Grants are the permissions a user holds, across the organization and on
+// single resources
+type Grants struct {
+	// All is set for super admins, who are allowed everything
+	All       bool
+	Global    map[string]bool
+	Resources map[string]map[string]bool
+}
+
+// NewGrants resolves the permissions of a user with role and assignments
+func NewGrants(role string, assignments []*RoleAssignment) *Grants {
+	g := &Grants{
+		All:       role == RoleSuperAdmin,
+		Global:    make(map[string]bool),
+		Resources: make(map[string]map[string]bool),
+	}
+	for _, p := range BuiltInRolePermissions[role] {
+		g.Global[p] = true
+	}
+
+	for _, a := range assignments {
+		perms := g.Global
+		if a.ResourceType != "" {
+			key := a.ResourceType + ":" + a.ResourceID
+			if g.Resources[key] == nil {
+				g.Resources[key] = make(map[string]bool)
+			}
+			perms = g.Resources[key]
+		}
+		for _, p := range a.permissions() {
+			perms[p] = true
+		}
+	}
+
+	return g
+}
+
+// Allows returns true if the user holds permission across the organization
+func (g *Grants) Allows(permission string) bool {
+	return g.All || g.Global[permission]
+}
+
+// AllowsOn returns true if the user holds permission across the
+// organization or on the given resource
+func (g *Grants) AllowsOn(permission, resourceType, resourceID string) bool {
+	return g.Allows(permission) || g.Resources[resourceType+":"+resourceID][permission]
+}
+
+// List returns the permissions the user holds across the organization
+func (g *Grants) List() []string {
+	list := make([]string, 0, len(g.Global))
+	for _, p := range Permissions {
+		if g.All || g.Global[p] {
+			list = append(list, p)
+		}
+	}
+	return list
+}
@@ -0,0 +1,51 @@
+package model
+
+import "testing"
+
+func TestNewGrants(t *testing.T) {
+	g := NewGrants(RoleReviewer, []*RoleAssignment{
+		{Role: RoleRecruiter},
+		{CustomRoleID: "role-1", Permissions: []string{PermissionTemplatesWrite}, ResourceType: ResourceAssessmentTemplate, ResourceID: "template-1"},
+	})
+
+	if !g.Allows(PermissionReviewsRead) {
+		t.Error("Allows() refused a permission of the user's own role")
+	}
+	if !g.Allows(PermissionAssessmentsWrite) {
+		t.Error("Allows() refused a permission of an assigned role")
+	}
+	if g.Allows(PermissionTemplatesWrite) {
+		t.Error("Allows() granted a resource-level permission across the organization")
+	}
+	if !g.AllowsOn(PermissionTemplatesWrite, ResourceAssessmentTemplate, "template-1") {
+		t.Error("AllowsOn() refused a permission granted on the resource")
+	}
+	if g.AllowsOn(PermissionTemplatesWrite, ResourceAssessmentTemplate, "template-2") {
+		t.Error("AllowsOn() granted a permission on another resource")
+	}
+
+	if !NewGrants(RoleSuperAdmin, nil).Allows(PermissionRolesManage) {
+		t.Error("Allows() refused a super admin")
+	}
+}
+
+func TestRoleAssignmentValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		a     RoleAssignment
+		valid bool
+	}{
+		{"built-in role", RoleAssignment{Role: RoleRecruiter}, true},
+		{"custom role on a resource", RoleAssignment{CustomRoleID: "role-1", ResourceType: ResourceAssessment, ResourceID: "5f0c6f4e-3c1e-4d0b-9a51-2f7c8f1d2e3a"}, true},
+		{"both roles", RoleAssignment{Role: RoleRecruiter, CustomRoleID: "role-1"}, false},
+		{"candidate role", RoleAssignment{Role: RoleCandidate}, false},
+		{"unknown role", RoleAssignment{Role: "owner"}, false},
+		{"unknown resource type", RoleAssignment{Role: RoleReviewer, ResourceType: "team", ResourceID: "5f0c6f4e-3c1e-4d0b-9a51-2f7c8f1d2e3a"}, false},
+	}
+
+	for _, tt := range tests {
+		if got := len(tt.a.Validate()) == 0; got != tt.valid {
+			t.Errorf("%s: Validate() valid = %v, want %v", tt.name, got, tt.valid)
+		}
+	}
+}
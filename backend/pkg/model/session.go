@@ -0,0 +1,42 @@
+package model
+
+import "time"
+
+// Reasons a session was revoked
+const (
+	SessionRevokedLogout         = "logout"
+	SessionRevokedByUser         = "revoked"
+	SessionRevokedByAdmin        = "admin"
+	SessionRevokedTokenReuse     = "refresh_token_reuse"
+	SessionRevokedPasswordReset  = "password_reset"
+	SessionRevokedPasswordChange = "password_change"
+	SessionRevokedInactiveUser   = "inactive_user"
+)
+
+// Session is a user's signed-in session on one device. Access tokens name
+// their session, and refresh tokens are rotated within it.
+type Session struct {
+	ID     string `json:"id"`
+	UserID string `json:"user_id"`
+	// AssessmentID is set for candidate sessions opened with a sign-in link
+	AssessmentID  string     `json:"assessment_id,omitempty"`
+	UserAgent     string     `json:"user_agent"`
+	IPAddress     string     `json:"ip_address"`
+	CreatedAt     time.Time  `json:"created_at"`
+	LastUsedAt    time.Time  `json:"last_used_at"`
+	ExpiresAt     time.Time  `json:"expires_at"`
+	RevokedAt     *time.Time `json:"revoked_at,omitempty"`
+	RevokedReason string     `json:"revoked_reason,omitempty"`
+	// Current is set on the session of the caller when listing sessions
+	Current bool `json:"current"`
+}
+
+// RefreshToken is a refresh token of a session. Only its hash is stored.
+type RefreshToken struct {
+	ID        string     `json:"id"`
+	SessionID string     `json:"session_id"`
+	TokenHash string     `json:"-"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
@@ -0,0 +1,185 @@
+package model
+
+import (
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ssoRolePrecedence orders the roles an identity provider's groups can map
+// to, most privileged first. A user in several mapped groups gets the first.
+var ssoRolePrecedence = []string{RoleAdmin, RoleTemplateEditor, RoleRecruiter, RoleReviewer}
+
+// IsSSORole returns true if SSO may provision users with role. Candidates
+// sign in with links and super admins are not tied to an organization.
+func IsSSORole(role string) bool {
+	for _, r := range ssoRolePrecedence {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// SSO protocols a sign-in can be started with
+const (
+	SSOProtocolOIDC = "oidc"
+	SSOProtocolSAML = "saml"
+)
+
+// SSOPolicy is who may sign in through an organization's identity provider
+// and which role they are given
+type SSOPolicy struct {
+	// AllowedDomains are the email domains users may sign in with
+	AllowedDomains []string `json:"allowed_domains"`
+	// RoleMappings maps the provider's groups to roles
+	RoleMappings map[string]string `json:"role_mappings"`
+	// DefaultRole is given to new users in no mapped group; if empty, they
+	// are refused
+	DefaultRole string `json:"default_role,omitempty"`
+	Enabled     bool   `json:"enabled"`
+	// PasswordLoginDisabled makes the organization's users sign in through
+	// the provider only
+	PasswordLoginDisabled bool `json:"password_login_disabled"`
+}
+
+// OIDCProvider is an organization's OpenID Connect identity provider, which
+// its staff sign in with
+type OIDCProvider struct {
+	OrganizationID string `json:"organization_id"`
+	Issuer         string `json:"issuer"`
+	ClientID       string `json:"client_id"`
+	// ClientSecret is never returned once set
+	ClientSecret string `json:"-"`
+	// GroupsClaim is the ID token claim listing the user's groups
+	GroupsClaim string `json:"groups_claim"`
+	SSOPolicy
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Validate performs basic validation on the provider
+func (p *OIDCProvider) Validate() map[string]string {
+	errors := make(map[string]string)
+
+	u, err := url.Parse(p.Issuer)
+	switch {
+	case p.Issuer == "":
+		errors["issuer"] = "Issuer is required"
+	case err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http"):
+		errors["issuer"] = "Issuer must be an absolute http or https URL"
+	}
+
+	if p.ClientID == "" {
+		errors["client_id"] = "Client ID is required"
+	}
+	if p.ClientSecret == "" {
+		errors["client_secret"] = "Client secret is required"
+	}
+
+	p.SSOPolicy.validate(errors)
+	return errors
+}
+
+// SAMLProvider is an organization's SAML 2.0 identity provider, described by
+// the metadata its admins upload
+type SAMLProvider struct {
+	OrganizationID string `json:"organization_id"`
+	// Metadata is the identity provider's metadata document
+	Metadata    string `json:"-"`
+	IdPEntityID string `json:"idp_entity_id"`
+	IdPSSOURL   string `json:"idp_sso_url"`
+	// The assertion attributes carrying the user's email, name and groups.
+	// The email falls back to the subject's name ID.
+	EmailAttribute     string `json:"email_attribute"`
+	FirstNameAttribute string `json:"first_name_attribute"`
+	LastNameAttribute  string `json:"last_name_attribute"`
+	GroupsAttribute    string `json:"groups_attribute"`
+	SSOPolicy
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Validate performs basic validation on the provider
+func (p *SAMLProvider) Validate() map[string]string {
+	errors := make(map[string]string)
+
+	if p.Metadata == "" {
+		errors["metadata"] = "Identity provider metadata is required"
+	}
+
+	p.SSOPolicy.validate(errors)
+	return errors
+}
+
+// validate adds the policy's validation errors to errors
+func (p *SSOPolicy) validate(errors map[string]string) {
+	if len(p.AllowedDomains) == 0 {
+		errors["allowed_domains"] = "At least one email domain is required"
+	}
+	for _, d := range p.AllowedDomains {
+		if d == "" || strings.ContainsAny(d, "@/ ") {
+			errors["allowed_domains"] = "Invalid email domain: " + d
+			break
+		}
+	}
+
+	for group, role := range p.RoleMappings {
+		if !IsSSORole(role) {
+			errors["role_mappings"] = "Invalid role for group " + group + ": " + role
+			break
+		}
+	}
+	if p.DefaultRole != "" && !IsSSORole(p.DefaultRole) {
+		errors["default_role"] = "Invalid role: " + p.DefaultRole
+	}
+}
+
+// AllowsEmail returns true if email belongs to one of the allowed domains
+func (p *SSOPolicy) AllowsEmail(email string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := strings.ToLower(email[at+1:])
+	for _, d := range p.AllowedDomains {
+		if strings.ToLower(d) == domain {
+			return true
+		}
+	}
+	return false
+}
+
+// RoleFor returns the role the user in groups is given, the most privileged
+// of those their groups map to. It returns an empty string if none of the
+// groups is mapped.
+func (p *SSOPolicy) RoleFor(groups []string) string {
+	mapped := make(map[string]bool)
+	for _, g := range groups {
+		if role, ok := p.RoleMappings[g]; ok {
+			mapped[role] = true
+		}
+	}
+	for _, role := range ssoRolePrecedence {
+		if mapped[role] {
+			return role
+		}
+	}
+	return ""
+}
+
+// SSOLoginState is a sign-in started at an organization's identity provider,
+// kept until the provider redirects back. Only the hash of its state
+// parameter is stored.
+type SSOLoginState struct {
+	StateHash      string
+	OrganizationID string
+	Protocol       string
+	// CodeVerifier is the PKCE code verifier of OIDC sign-ins
+	CodeVerifier string
+	// Nonce is the ID token nonce of OIDC sign-ins, and the authentication
+	// request ID of SAML sign-ins
+	Nonce     string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
@@ -0,0 +1,73 @@
+package model
+
+import "testing"
+
+func TestSSOPolicyRoleFor(t *testing.T) {
+	p := &SSOPolicy{
+		RoleMappings: map[string]string{
+			"qualifyd-admins":    RoleAdmin,
+			"qualifyd-reviewers": RoleReviewer,
+			"recruiting":         RoleRecruiter,
+		},
+	}
+
+	tests := []struct {
+		groups []string
+		want   string
+	}{
+		{nil, ""},
+		{[]string{"engineering"}, ""},
+		{[]string{"qualifyd-reviewers"}, RoleReviewer},
+		{[]string{"qualifyd-reviewers", "recruiting"}, RoleRecruiter},
+		{[]string{"recruiting", "qualifyd-admins"}, RoleAdmin},
+	}
+
+	for _, tt := range tests {
+		if got := p.RoleFor(tt.groups); got != tt.want {
+			t.Errorf("RoleFor(%v): expected %q, got %q", tt.groups, tt.want, got)
+		}
+	}
+}
+
+func TestSSOPolicyAllowsEmail(t *testing.T) {
+	p := &SSOPolicy{AllowedDomains: []string{"example.com"}}
+
+	tests := []struct {
+		email string
+		want  bool
+	}{
+		{"jane@example.com", true},
+		{"jane@EXAMPLE.com", true},
+		{"jane@sub.example.com", false},
+		{"jane@example.com.evil.io", false},
+		{"example.com", false},
+	}
+
+	for _, tt := range tests {
+		if got := p.AllowsEmail(tt.email); got != tt.want {
+			t.Errorf("AllowsEmail(%q): expected %v, got %v", tt.email, tt.want, got)
+		}
+	}
+}
+
+func TestOIDCProviderValidate(t *testing.T) {
+	p := &OIDCProvider{
+		Issuer:       "https://idp.example.com",
+		ClientID:     "client",
+		ClientSecret: "secret",
+		SSOPolicy: SSOPolicy{
+			AllowedDomains: []string{"example.com"},
+			RoleMappings:   map[string]string{"admins": RoleAdmin},
+		},
+	}
+	if errs := p.Validate(); len(errs) != 0 {
+		t.Errorf("Validate(): expected no errors, got %v", errs)
+	}
+
+	p.RoleMappings["everyone"] = RoleSuperAdmin
+	p.DefaultRole = RoleCandidate
+	errs := p.Validate()
+	if errs["role_mappings"] == "" || errs["default_role"] == "" {
+		t.Errorf("Validate(): expected role errors, got %v", errs)
+	}
+}
@@ -7,11 +7,16 @@ import (
 
 // User roles
 const (
+	// RoleSuperAdmin is a platform operator who can manage every organization
+	RoleSuperAdmin     = "super_admin"
 	RoleAdmin          = "admin"
 	RoleTemplateEditor = "template_editor"
 	RoleRecruiter      = "recruiter"
 	RoleCandidate      = "candidate"
 	RoleReviewer       = "reviewer"
+	// RoleService is the role of requests made with an API key. No user
+	// has it.
+	RoleService = "service"
 )
 
 // User status
@@ -36,6 +41,7 @@ type User struct {
 	UpdatedAt           time.Time  `json:"updated_at"`
 	InvitationToken     *string    `json:"-"` // Pointer to handle nullable
 	InvitationExpiresAt *time.Time `json:"-"` // Pointer to handle nullable
+	PasswordChangedAt   *time.Time `json:"-"`
 }
 
 // NewUser creates a new user with the provided information
@@ -64,6 +70,11 @@ func (u *User) IsAdmin() bool {
 	return u.Role == RoleAdmin
 }
 
+// IsSuperAdmin returns true if the user is a platform super admin
+func (u *User) IsSuperAdmin() bool {
+	return u.Role == RoleSuperAdmin
+}
+
 // IsTemplateEditor returns true if the user has template editor role
 func (u *User) IsTemplateEditor() bool {
 	return u.Role == RoleTemplateEditor
@@ -136,7 +147,7 @@ func (u *User) Validate() map[string]string {
 
 	// Role validation
 	switch u.Role {
-	case RoleAdmin, RoleTemplateEditor, RoleRecruiter, RoleCandidate, RoleReviewer:
+	case RoleSuperAdmin, RoleAdmin, RoleTemplateEditor, RoleRecruiter, RoleCandidate, RoleReviewer:
 		// Valid role
 	default:
 		errors["role"] = "Invalid role"
@@ -152,3 +163,14 @@ func (u *User) Validate() map[string]string {
 
 	return errors
 }
+
+// PasswordResetToken is a single-use token letting a user set a new password.
+// Only the token's hash is stored.
+type PasswordResetToken struct {
+	ID        string     `json:"id"`
+	UserID    string     `json:"user_id"`
+	TokenHash string     `json:"-"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
@@ -0,0 +1,166 @@
+package model
+
+import (
+	"encoding/json"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Webhook event types sent to organization webhook subscriptions
+const (
+	WebhookEventAssessmentCreated   = "assessment.created"
+	WebhookEventAssessmentStarted   = "assessment.started"
+	WebhookEventTaskCompleted       = "task.completed"
+	WebhookEventAssessmentCompleted = "assessment.completed"
+	WebhookEventAssessmentExpired   = "assessment.expired"
+	WebhookEventReviewSubmitted     = "review.submitted"
+)
+
+// WebhookEventTypes lists the event types a webhook can subscribe to
+var WebhookEventTypes = []string{
+	WebhookEventAssessmentCreated,
+	WebhookEventAssessmentStarted,
+	WebhookEventTaskCompleted,
+	WebhookEventAssessmentCompleted,
+	WebhookEventAssessmentExpired,
+	WebhookEventReviewSubmitted,
+}
+
+// Webhook delivery status constants
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliveryRetrying  = "retrying"
+	WebhookDeliverySucceeded = "succeeded"
+	WebhookDeliveryFailed    = "failed"
+)
+
+// IsValidWebhookEventType returns true if eventType is a known webhook event type
+func IsValidWebhookEventType(eventType string) bool {
+	for _, t := range WebhookEventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookSubscription is an organization's endpoint that receives the events
+// it subscribed to
+type WebhookSubscription struct {
+	ID             string `json:"id"`
+	OrganizationID string `json:"organization_id"`
+	URL            string `json:"url"`
+	// Secret signs every delivery; it is only shown when the subscription is
+	// created or its secret is rotated
+	Secret      string    `json:"-"`
+	Events      []string  `json:"events"`
+	Description string    `json:"description,omitempty"`
+	Active      bool      `json:"active"`
+	CreatedBy   string    `json:"created_by,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Subscribes returns true if the subscription is active and receives eventType
+func (s *WebhookSubscription) Subscribes(eventType string) bool {
+	if !s.Active {
+		return false
+	}
+	for _, e := range s.Events {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate performs basic validation on the subscription
+func (s *WebhookSubscription) Validate() map[string]string {
+	errors := make(map[string]string)
+
+	u, err := url.Parse(s.URL)
+	switch {
+	case s.URL == "":
+		errors["url"] = "URL is required"
+	case err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http"):
+		errors["url"] = "URL must be an absolute http or https URL"
+	case isInternalHost(u.Hostname()):
+		errors["url"] = "URL must not point to a private, loopback or link-local address"
+	}
+
+	if len(s.Events) == 0 {
+		errors["events"] = "At least one event type is required"
+	}
+	for _, e := range s.Events {
+		if !IsValidWebhookEventType(e) {
+			errors["events"] = "Unknown event type: " + e
+			break
+		}
+	}
+
+	if len(strings.TrimSpace(s.Description)) > 255 {
+		errors["description"] = "Description must be at most 255 characters"
+	}
+
+	return errors
+}
+
+// cgnatPrefix is the shared address space carrier-grade NATs use
+var cgnatPrefix = netip.MustParsePrefix("100.64.0.0/10")
+
+// IsInternalAddress returns true if addr is not a public unicast address:
+// private, loopback, link-local (which includes cloud metadata endpoints),
+// shared or unspecified addresses, and multicast. Webhooks are never sent
+// to such addresses.
+func IsInternalAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return !addr.IsGlobalUnicast() || addr.IsPrivate() || cgnatPrefix.Contains(addr)
+}
+
+// isInternalHost returns true if host is an internal address or a name of
+// the local host. Other names are checked once resolved, when connecting.
+func isInternalHost(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	addr, err := netip.ParseAddr(host)
+	return err == nil && IsInternalAddress(addr)
+}
+
+// WebhookDelivery is one event sent to one webhook subscription, with the
+// outcome of its latest attempt
+type WebhookDelivery struct {
+	ID             string          `json:"id"`
+	SubscriptionID string          `json:"subscription_id"`
+	OrganizationID string          `json:"organization_id"`
+	EventID        string          `json:"event_id"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	LastStatusCode int             `json:"last_status_code,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at,omitempty"`
+	DeliveredAt    time.Time       `json:"delivered_at,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+	// AttemptLog is only loaded when a single delivery is retrieved
+	AttemptLog []*WebhookAttempt `json:"attempt_log,omitempty"`
+}
+
+// WebhookAttempt is the outcome of one HTTP request made for a delivery
+type WebhookAttempt struct {
+	Attempt    int       `json:"attempt"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Succeeded returns true if the endpoint accepted the attempt with a 2xx response
+func (a *WebhookAttempt) Succeeded() bool {
+	return a.Error == "" && a.StatusCode >= 200 && a.StatusCode < 300
+}
@@ -0,0 +1,33 @@
+package model
+
+import "testing"
+
+func TestWebhookSubscriptionValidateURL(t *testing.T) {
+	tests := []struct {
+		url   string
+		valid bool
+	}{
+		{"https://hooks.example.com/qualifyd", true},
+		{"http://203.0.113.10:8080/hook", true},
+		{"ftp://hooks.example.com", false},
+		{"/hook", false},
+		{"http://localhost:8080/hook", false},
+		{"http://api.localhost/hook", false},
+		{"http://127.0.0.1/hook", false},
+		{"http://10.0.0.5/hook", false},
+		{"http://169.254.169.254/latest/meta-data", false},
+		{"http://100.64.0.1/hook", false},
+		{"http://[::1]/hook", false},
+		{"http://[fd00::1]/hook", false},
+		{"http://[::ffff:192.168.0.1]/hook", false},
+		{"http://0.0.0.0/hook", false},
+	}
+
+	for _, tt := range tests {
+		s := &WebhookSubscription{URL: tt.url, Events: []string{WebhookEventAssessmentCompleted}}
+		_, invalid := s.Validate()["url"]
+		if invalid == tt.valid {
+			t.Errorf("Validate(%q): expected valid %v, got errors %v", tt.url, tt.valid, s.Validate())
+		}
+	}
+}
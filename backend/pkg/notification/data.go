@@ -0,0 +1,156 @@
+package notification
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/model"
+)
+
+// UserInvitationData is the data of the user invitation email
+type UserInvitationData struct {
+	InvitedBy string
+	Role      string
+	AcceptURL string
+	ExpiresAt time.Time
+}
+
+// AssessmentInvitationData is the data of the email inviting a candidate to
+// an assessment
+type AssessmentInvitationData struct {
+	AssessmentName string
+	ScheduledStart time.Time
+	// TimeLimitMinutes is zero if the assessment has no time limit
+	TimeLimitMinutes int
+	// URL is the candidate's one-time sign-in link, expiring at LinkExpiresAt
+	URL           string
+	LinkExpiresAt time.Time
+}
+
+// AssessmentReminderData is the data of the email reminding a candidate of
+// an upcoming assessment
+type AssessmentReminderData struct {
+	AssessmentName string
+	ScheduledStart time.Time
+	// URL is the candidate's one-time sign-in link, expiring at LinkExpiresAt
+	URL           string
+	LinkExpiresAt time.Time
+}
+
+// AssessmentCompletedData is the data of the receipt a candidate gets after
+// completing an assessment
+type AssessmentCompletedData struct {
+	AssessmentName string
+	CompletedAt    time.Time
+}
+
+// ReviewerAssignedData is the data of the email telling a reviewer about an
+// assessment to review
+type ReviewerAssignedData struct {
+	AssessmentName string
+	CandidateName  string
+	AssignedBy     string
+	URL            string
+}
+
+// SubscriptionNoticeData is the data of a subscription notice. Notice is
+// one of the subscription.Notice* kinds.
+type SubscriptionNoticeData struct {
+	Notice        string
+	DaysLeft      int
+	ReferenceDate time.Time
+	Plan          string
+	URL           string
+}
+
+// PasswordResetData is the data of the email with a password reset link
+type PasswordResetData struct {
+	ResetURL  string
+	ExpiresAt time.Time
+}
+
+// SampleContext returns a context with example data for kind, used for
+// previews and to check that custom templates render
+func SampleContext(kind string) (*Context, error) {
+	start := time.Date(2025, time.March, 14, 9, 30, 0, 0, time.UTC)
+
+	var data interface{}
+	switch kind {
+	case KindUserInvitation:
+		data = &UserInvitationData{
+			InvitedBy: "Jane Doe",
+			Role:      "reviewer",
+			AcceptURL: "https://app.example.com/accept-invitation?token=sample",
+			ExpiresAt: start.Add(72 * time.Hour),
+		}
+	case KindAssessmentInvitation:
+		data = &AssessmentInvitationData{
+			AssessmentName:   "Kubernetes Troubleshooting",
+			ScheduledStart:   start,
+			TimeLimitMinutes: 90,
+			URL:              "https://app.example.com/candidate/sign-in?token=sample",
+			LinkExpiresAt:    start.Add(72 * time.Hour),
+		}
+	case KindAssessmentReminder:
+		data = &AssessmentReminderData{
+			AssessmentName: "Kubernetes Troubleshooting",
+			ScheduledStart: start,
+			URL:            "https://app.example.com/candidate/sign-in?token=sample",
+			LinkExpiresAt:  start.Add(72 * time.Hour),
+		}
+	case KindAssessmentCompleted:
+		data = &AssessmentCompletedData{
+			AssessmentName: "Kubernetes Troubleshooting",
+			CompletedAt:    start.Add(85 * time.Minute),
+		}
+	case KindReviewerAssigned:
+		data = &ReviewerAssignedData{
+			AssessmentName: "Kubernetes Troubleshooting",
+			CandidateName:  "John Smith",
+			AssignedBy:     "Jane Doe",
+			URL:            "https://app.example.com/assessments/sample",
+		}
+	case KindSubscriptionNotice:
+		data = &SubscriptionNoticeData{
+			Notice:        "trial_ending",
+			DaysLeft:      3,
+			ReferenceDate: start,
+			Plan:          "starter",
+			URL:           "https://app.example.com/settings/billing",
+		}
+	case KindPasswordReset:
+		data = &PasswordResetData{
+			ResetURL:  "https://app.example.com/reset-password?token=sample",
+			ExpiresAt: start.Add(time.Hour),
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKind, kind)
+	}
+
+	return &Context{
+		Organization: Branding{
+			Name:       "Example Inc.",
+			LogoURL:    "https://www.example.com/logo.png",
+			WebsiteURL: "https://www.example.com",
+		},
+		Recipient: Recipient{Name: "John Smith", Email: "john.smith@example.com"},
+		Data:      data,
+	}, nil
+}
+
+// Preview renders src, a template of kind, in locale with sample data and
+// org's branding. It fails if the template does not render, e.g. because it
+// refers to data the kind of email does not have.
+func Preview(org *model.Organization, kind, locale string, src *Source) (*Message, error) {
+	ctx, err := SampleContext(kind)
+	if err != nil {
+		return nil, err
+	}
+	ctx.Organization = BrandingOf(org)
+
+	tmpl, err := Compile(src, locale)
+	if err != nil {
+		return nil, err
+	}
+	return tmpl.Render(ctx)
+}
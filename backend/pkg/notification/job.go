@@ -0,0 +1,34 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/jobs"
+)
+
+// JobTypeEmail sends a rendered email
+const JobTypeEmail = "email.send"
+
+// EmailJob is the definition of the email job. Mail servers may be
+// unavailable for a while, so it is retried for about an hour.
+var EmailJob = jobs.Definition{
+	Type:    JobTypeEmail,
+	Retry:   jobs.RetryPolicy{MaxAttempts: 6, BaseDelay: 30 * time.Second, MaxDelay: 30 * time.Minute},
+	Timeout: time.Minute,
+}
+
+// HandleEmailJobs registers the email job handler, which sends the message
+// in the job's payload through transport
+func HandleEmailJobs(registry *jobs.Registry, transport Transport) {
+	jobs.Handle(registry, JobTypeEmail, func(ctx context.Context, progress *jobs.Progress, msg Message) (interface{}, error) {
+		if msg.To == "" || msg.From == "" {
+			return nil, jobs.Permanent(errors.New("from and to are required"))
+		}
+		if err := transport.Send(ctx, &msg); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	})
+}
@@ -0,0 +1,107 @@
+package notification
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// Message is a rendered email, ready to be sent
+type Message struct {
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+	HTML    string `json:"html,omitempty"`
+}
+
+// Recipients returns the bare address of the message's recipient
+func (m *Message) Recipients() ([]string, error) {
+	addr, err := mail.ParseAddress(m.To)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipient %q: %w", m.To, err)
+	}
+	return []string{addr.Address}, nil
+}
+
+// Sender returns the bare address of the message's sender
+func (m *Message) Sender() (string, error) {
+	addr, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return "", fmt.Errorf("invalid sender %q: %w", m.From, err)
+	}
+	return addr.Address, nil
+}
+
+// Bytes encodes the message as MIME: a multipart/alternative message with a
+// plain text and an HTML part, or a plain text message if it has no HTML
+func (m *Message) Bytes(now time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	header := func(key, value string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", key, value)
+	}
+
+	header("From", m.From)
+	header("To", m.To)
+	header("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	header("Date", now.Format(time.RFC1123Z))
+	header("Message-ID", fmt.Sprintf("<%s@qualifyd>", randomID()))
+	header("MIME-Version", "1.0")
+
+	if m.HTML == "" {
+		header("Content-Type", `text/plain; charset="utf-8"`)
+		header("Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+		return buf.Bytes(), writeQuotedPrintable(&buf, m.Text)
+	}
+
+	boundary := "qualifyd-" + randomID()
+	header("Content-Type", fmt.Sprintf(`multipart/alternative; boundary="%s"`, boundary))
+	buf.WriteString("\r\n")
+
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain", m.Text},
+		{"text/html", m.HTML},
+	} {
+		fmt.Fprintf(&buf, "--%s\r\n", boundary)
+		fmt.Fprintf(&buf, "Content-Type: %s; charset=\"utf-8\"\r\n", part.contentType)
+		buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		if err := writeQuotedPrintable(&buf, part.body); err != nil {
+			return nil, err
+		}
+		buf.WriteString("\r\n")
+	}
+	fmt.Fprintf(&buf, "--%s--\r\n", boundary)
+
+	return buf.Bytes(), nil
+}
+
+// writeQuotedPrintable writes body to buf with CRLF line endings, quoted-printable encoded
+func writeQuotedPrintable(buf *bytes.Buffer, body string) error {
+	w := quotedprintable.NewWriter(buf)
+	body = strings.ReplaceAll(body, "\r\n", "\n")
+	if _, err := w.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n"))); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// formatAddress formats a recipient as an email address with a display name
+func formatAddress(r Recipient) string {
+	return (&mail.Address{Name: r.Name, Address: r.Email}).String()
+}
+
+// randomID returns a random hex string for message IDs and MIME boundaries
+func randomID() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
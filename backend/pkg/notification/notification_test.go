@@ -0,0 +1,124 @@
+package notification
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/model"
+)
+
+func TestDefaultTemplatesRender(t *testing.T) {
+	org := &model.Organization{ID: "org-1", Name: "Acme & Co", LogoURL: "https://acme.example.com/logo.png"}
+
+	for _, locale := range Locales {
+		for _, kind := range Kinds {
+			src, err := DefaultSource(kind, locale)
+			if err != nil {
+				t.Fatalf("DefaultSource(%s, %s) error = %v", kind, locale, err)
+			}
+			msg, err := Preview(org, kind, locale, src)
+			if err != nil {
+				t.Fatalf("Preview(%s, %s) error = %v", kind, locale, err)
+			}
+
+			if msg.Subject == "" || strings.Contains(msg.Subject, "\n") {
+				t.Errorf("%s/%s subject = %q", locale, kind, msg.Subject)
+			}
+			if strings.Contains(msg.Text, "<no value>") || strings.Contains(msg.HTML, "<no value>") {
+				t.Errorf("%s/%s renders missing values", locale, kind)
+			}
+			if !strings.Contains(msg.HTML, `src="https://acme.example.com/logo.png"`) {
+				t.Errorf("%s/%s HTML lacks the organization's logo", locale, kind)
+			}
+			if !strings.Contains(msg.HTML, "Acme &amp; Co") {
+				t.Errorf("%s/%s HTML does not escape the organization's name", locale, kind)
+			}
+		}
+	}
+}
+
+func TestCustomTemplate(t *testing.T) {
+	org := &model.Organization{ID: "org-1", Name: "Acme"}
+
+	msg, err := Preview(org, KindAssessmentReminder, "pl", &Source{
+		Subject: "{{.Data.AssessmentName}} o {{datetime .Data.ScheduledStart}}",
+		Text:    "Cześć {{.Recipient.Name}}",
+		HTML:    "<p>Cześć {{.Recipient.Name}}</p>",
+	})
+	if err != nil {
+		t.Fatalf("Preview() error = %v", err)
+	}
+	if msg.Subject != "Kubernetes Troubleshooting o 14.03.2025, 09:30 UTC" {
+		t.Errorf("Subject = %q", msg.Subject)
+	}
+	if msg.To != `"John Smith" <john.smith@example.com>` {
+		t.Errorf("To = %q", msg.To)
+	}
+
+	// Fields the kind of email does not have are rejected
+	_, err = Preview(org, KindAssessmentReminder, "en", &Source{
+		Subject: "{{.Data.Score}}",
+		Text:    "text",
+		HTML:    "html",
+	})
+	if err == nil {
+		t.Error("Preview() with an unknown field succeeded")
+	}
+
+	if _, err := DefaultSource("unknown", "en"); err == nil {
+		t.Error("DefaultSource() with an unknown kind succeeded")
+	}
+}
+
+func TestMessageBytes(t *testing.T) {
+	msg := &Message{
+		From:    "Qualifyd <no-reply@qualifyd.io>",
+		To:      `"Zoë" <zoe@example.com>`,
+		Subject: "Zaproszenie do testu",
+		Text:    "Dzień dobry\nline two",
+		HTML:    "<p>Dzień dobry</p>",
+	}
+
+	body, err := msg.Bytes(time.Date(2025, time.March, 14, 9, 30, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Bytes() error = %v", err)
+	}
+	s := string(body)
+	for _, want := range []string{
+		"Date: Fri, 14 Mar 2025 09:30:00 +0000\r\n",
+		"Content-Type: multipart/alternative; boundary=",
+		"Content-Type: text/plain; charset=\"utf-8\"\r\n",
+		"Content-Type: text/html; charset=\"utf-8\"\r\n",
+		"Dzie=C5=84 dobry\r\nline two",
+	} {
+		if !strings.Contains(s, want) {
+			t.Errorf("Bytes() lacks %q:\n%s", want, s)
+		}
+	}
+
+	to, err := msg.Recipients()
+	if err != nil || len(to) != 1 || to[0] != "zoe@example.com" {
+		t.Errorf("Recipients() = %v, %v", to, err)
+	}
+}
+
+func TestFileTransport(t *testing.T) {
+	dir := t.TempDir()
+	transport, err := NewFileTransport(dir)
+	if err != nil {
+		t.Fatalf("NewFileTransport() error = %v", err)
+	}
+
+	msg := &Message{From: "a@example.com", To: "b@example.com", Subject: "Hi", Text: "Hello"}
+	if err := transport.Send(context.Background(), msg); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 1 || !strings.HasSuffix(entries[0].Name(), ".eml") {
+		t.Fatalf("ReadDir() = %v, %v, want one .eml file", entries, err)
+	}
+}
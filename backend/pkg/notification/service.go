@@ -0,0 +1,544 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/auth"
+	"github.com/cstanislawski/qualifyd/pkg/config"
+	"github.com/cstanislawski/qualifyd/pkg/database"
+	"github.com/cstanislawski/qualifyd/pkg/events"
+	"github.com/cstanislawski/qualifyd/pkg/jobs"
+	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/cstanislawski/qualifyd/pkg/repository"
+	"github.com/cstanislawski/qualifyd/pkg/subscription"
+	"github.com/google/uuid"
+)
+
+// EventsQueue is the queue of domain events that trigger emails, bound to
+// the events exchange
+const EventsQueue = "notifications.events"
+
+// EventTypes are the domain events that trigger emails
+var EventTypes = []string{
+	events.TypeAssessmentCreated,
+	events.TypeAssessmentCompleted,
+	events.TypeReviewerAssigned,
+}
+
+// Email is an email to send on behalf of an organization
+type Email struct {
+	Kind         string
+	Organization *model.Organization
+	Recipient    Recipient
+	Data         interface{}
+	// DedupeKey identifies the email; an email whose key was sent before is
+	// not sent again
+	DedupeKey string
+	// Prepare, if set, is called once the email is certain to be sent, right
+	// before it is rendered. It fills in data that must not be created for
+	// emails that are never sent, such as sign-in links.
+	Prepare func(ctx context.Context) error
+}
+
+// Service renders emails and queues them for sending by the email job
+type Service struct {
+	organizationRepo *repository.OrganizationRepository
+	userRepo         *repository.UserRepository
+	assessmentRepo   *repository.AssessmentRepository
+	emailRepo        *repository.EmailRepository
+	magicLinkRepo    *repository.MagicLinkRepository
+	auth             *auth.Auth
+	jobs             *jobs.Client
+	cfg              *config.EmailConfig
+	log              logger.Logger
+}
+
+// NewService creates a new Service
+func NewService(
+	organizationRepo *repository.OrganizationRepository,
+	userRepo *repository.UserRepository,
+	assessmentRepo *repository.AssessmentRepository,
+	emailRepo *repository.EmailRepository,
+	magicLinkRepo *repository.MagicLinkRepository,
+	authService *auth.Auth,
+	jobClient *jobs.Client,
+	cfg *config.EmailConfig,
+	log logger.Logger,
+) *Service {
+	return &Service{
+		organizationRepo: organizationRepo,
+		userRepo:         userRepo,
+		assessmentRepo:   assessmentRepo,
+		emailRepo:        emailRepo,
+		magicLinkRepo:    magicLinkRepo,
+		auth:             authService,
+		jobs:             jobClient,
+		cfg:              cfg,
+		log:              log,
+	}
+}
+
+// Source returns the template of kind an organization uses in locale, and
+// whether it is the organization's own. Locales without built-in templates
+// fall back to DefaultLocale.
+func (s *Service) Source(ctx context.Context, organizationID, kind, locale string) (*Source, bool, error) {
+	if !IsLocale(locale) {
+		locale = DefaultLocale
+	}
+
+	// Users outside any organization, such as super admins, get the
+	// built-in templates
+	if organizationID == "" {
+		src, err := DefaultSource(kind, locale)
+		return src, false, err
+	}
+
+	custom, err := s.emailRepo.GetTemplate(ctx, organizationID, kind, locale)
+	if err == nil {
+		return &Source{Subject: custom.Subject, Text: custom.Text, HTML: custom.HTML}, true, nil
+	}
+	if !errors.Is(err, database.ErrRecordNotFound) {
+		return nil, false, err
+	}
+
+	src, err := DefaultSource(kind, locale)
+	return src, false, err
+}
+
+// Render renders an email with the template its organization uses
+func (s *Service) Render(ctx context.Context, email *Email) (*Message, error) {
+	locale := email.Organization.EmailLocale
+	if !IsLocale(locale) {
+		locale = DefaultLocale
+	}
+
+	src, _, err := s.Source(ctx, email.Organization.ID, email.Kind, locale)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := Compile(src, locale)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s template: %w", email.Kind, err)
+	}
+
+	msg, err := tmpl.Render(&Context{
+		Organization: BrandingOf(email.Organization),
+		Recipient:    email.Recipient,
+		Data:         email.Data,
+	})
+	if err != nil {
+		return nil, err
+	}
+	msg.From = s.cfg.From
+	return msg, nil
+}
+
+// Send renders an email and queues it for sending, unless an email with its
+// dedupe key was sent before
+func (s *Service) Send(ctx context.Context, email *Email) error {
+	if email.Recipient.Email == "" {
+		return fmt.Errorf("%s email has no recipient", email.Kind)
+	}
+
+	notification := &model.EmailNotification{
+		OrganizationID: email.Organization.ID,
+		Kind:           email.Kind,
+		Recipient:      email.Recipient.Email,
+		DedupeKey:      email.DedupeKey,
+	}
+	claimed, err := s.emailRepo.ClaimNotification(ctx, notification)
+	if err != nil || !claimed {
+		return err
+	}
+
+	job, err := s.enqueue(ctx, email)
+	if err != nil {
+		if releaseErr := s.emailRepo.ReleaseNotification(ctx, notification.ID); releaseErr != nil {
+			s.log.Error("Failed to release email notification", releaseErr, map[string]interface{}{
+				"notification_id": notification.ID,
+			})
+		}
+		return err
+	}
+
+	if err := s.emailRepo.SetNotificationJob(ctx, notification.ID, job.ID); err != nil {
+		s.log.Warn("Failed to link email notification to its job", map[string]interface{}{
+			"notification_id": notification.ID,
+			"job_id":          job.ID,
+			"error":           err.Error(),
+		})
+	}
+	return nil
+}
+
+// enqueue prepares and renders an email and enqueues the job sending it
+func (s *Service) enqueue(ctx context.Context, email *Email) (*model.Job, error) {
+	if email.Prepare != nil {
+		if err := email.Prepare(ctx); err != nil {
+			return nil, err
+		}
+	}
+	msg, err := s.Render(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	return s.jobs.Enqueue(ctx, JobTypeEmail, msg, jobs.EnqueueOptions{
+		OrganizationID: email.Organization.ID,
+		IdempotencyKey: JobTypeEmail + ":" + email.DedupeKey,
+	})
+}
+
+// InviteUser sends the invitation of a user created by inviter, who may be
+// nil. The user must have a pending invitation.
+func (s *Service) InviteUser(ctx context.Context, org *model.Organization, user *model.User, inviter *model.User) error {
+	if user.InvitationToken == nil || user.InvitationExpiresAt == nil {
+		return fmt.Errorf("user %s has no pending invitation", user.ID)
+	}
+
+	data := &UserInvitationData{
+		Role:      user.Role,
+		AcceptURL: s.link("/accept-invitation?token=" + *user.InvitationToken),
+		ExpiresAt: *user.InvitationExpiresAt,
+	}
+	if inviter != nil {
+		data.InvitedBy = inviter.FullName()
+	}
+
+	return s.Send(ctx, &Email{
+		Kind:         KindUserInvitation,
+		Organization: org,
+		Recipient:    recipientOf(user),
+		Data:         data,
+		DedupeKey:    fmt.Sprintf("%s:%s:%d", KindUserInvitation, user.ID, user.InvitationExpiresAt.Unix()),
+	})
+}
+
+// SendPasswordReset sends a user the link to reset their password with token,
+// the reset token with the given ID. The email is branded with the user's
+// organization, if they have one.
+func (s *Service) SendPasswordReset(ctx context.Context, user *model.User, tokenID, token string, expiresAt time.Time) error {
+	org := &model.Organization{Name: "Qualifyd", EmailLocale: DefaultLocale}
+	if user.OrganizationID != "" {
+		var err error
+		if org, err = s.organizationRepo.GetByID(ctx, user.OrganizationID); err != nil {
+			return err
+		}
+	}
+
+	return s.Send(ctx, &Email{
+		Kind:         KindPasswordReset,
+		Organization: org,
+		Recipient:    recipientOf(user),
+		Data: &PasswordResetData{
+			ResetURL:  s.link("/reset-password?token=" + token),
+			ExpiresAt: expiresAt,
+		},
+		DedupeKey: KindPasswordReset + ":" + tokenID,
+	})
+}
+
+// HandleEvent sends the emails triggered by a domain event. It is the
+// handler of the notifications event subscriber.
+func (s *Service) HandleEvent(ctx context.Context, envelope *events.Envelope, event events.Event) error {
+	if envelope.OrganizationID == "" {
+		return nil
+	}
+
+	var err error
+	switch e := event.(type) {
+	case *events.AssessmentCreated:
+		err = s.sendAssessmentInvitation(ctx, envelope.OrganizationID, e)
+	case *events.AssessmentCompleted:
+		err = s.sendAssessmentCompleted(ctx, envelope.OrganizationID, e)
+	case *events.ReviewerAssigned:
+		err = s.sendReviewerAssigned(ctx, envelope.OrganizationID, e)
+	default:
+		return nil
+	}
+
+	// Whatever was deleted since the event occurred is not coming back, so
+	// retrying would not help
+	if isNotFound(err) {
+		s.log.Warn("Skipping email about a deleted record", map[string]interface{}{
+			"event_id":   envelope.ID,
+			"event_type": envelope.Type,
+			"error":      err.Error(),
+		})
+		return nil
+	}
+	return err
+}
+
+// sendAssessmentInvitation invites a candidate to a newly scheduled assessment
+func (s *Service) sendAssessmentInvitation(ctx context.Context, organizationID string, e *events.AssessmentCreated) error {
+	link := &model.MagicLink{
+		ID:             uuid.New().String(),
+		OrganizationID: organizationID,
+		AssessmentID:   e.AssessmentID,
+		CandidateID:    e.CandidateID,
+		CreatedBy:      e.CreatedBy,
+	}
+	return s.inviteCandidate(ctx, link, e.ScheduledStartTime, KindAssessmentInvitation+":"+e.AssessmentID)
+}
+
+// ResendAssessmentInvitation sends the candidate of an assessment a new
+// invitation, with a new sign-in link, on behalf of requestedBy. Links sent
+// before stay valid until used or expired.
+func (s *Service) ResendAssessmentInvitation(ctx context.Context, organizationID string, assessment *model.Assessment, requestedBy string) error {
+	link := &model.MagicLink{
+		ID:             uuid.New().String(),
+		OrganizationID: organizationID,
+		AssessmentID:   assessment.ID,
+		CandidateID:    assessment.CandidateID,
+		CreatedBy:      requestedBy,
+	}
+	dedupeKey := fmt.Sprintf("%s:%s:%s", KindAssessmentInvitation, assessment.ID, link.ID)
+	return s.inviteCandidate(ctx, link, assessment.ScheduledStartTime, dedupeKey)
+}
+
+// inviteCandidate sends the candidate of link's assessment an invitation
+// carrying link
+func (s *Service) inviteCandidate(ctx context.Context, link *model.MagicLink, scheduledStart time.Time, dedupeKey string) error {
+	org, candidate, template, err := s.loadAssessment(ctx, link.OrganizationID, link.AssessmentID, link.CandidateID)
+	if err != nil {
+		return err
+	}
+
+	data := &AssessmentInvitationData{
+		AssessmentName: template.Name,
+		ScheduledStart: scheduledStart,
+	}
+	if template.TotalTimeLimit != nil {
+		data.TimeLimitMinutes = *template.TotalTimeLimit / 60
+	}
+
+	return s.Send(ctx, &Email{
+		Kind:         KindAssessmentInvitation,
+		Organization: org,
+		Recipient:    recipientOf(candidate),
+		Data:         data,
+		DedupeKey:    dedupeKey,
+		Prepare: func(ctx context.Context) (err error) {
+			data.URL, data.LinkExpiresAt, err = s.issueSignInLink(ctx, link, scheduledStart)
+			return err
+		},
+	})
+}
+
+// issueSignInLink records link, expiring a while after the assessment's
+// scheduled start, and returns its URL
+func (s *Service) issueSignInLink(ctx context.Context, link *model.MagicLink, scheduledStart time.Time) (string, time.Time, error) {
+	link.ExpiresAt = s.auth.MagicLinkExpiresAt(scheduledStart)
+	if err := s.magicLinkRepo.Create(ctx, link); err != nil {
+		return "", time.Time{}, err
+	}
+	token, err := s.auth.GenerateMagicLinkToken(link.ID, link.ExpiresAt)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return s.link("/candidate/sign-in?token=" + token), link.ExpiresAt, nil
+}
+
+// sendAssessmentCompleted sends a candidate the receipt of a completed assessment
+func (s *Service) sendAssessmentCompleted(ctx context.Context, organizationID string, e *events.AssessmentCompleted) error {
+	org, candidate, template, err := s.loadAssessment(ctx, organizationID, e.AssessmentID, e.CandidateID)
+	if err != nil {
+		return err
+	}
+
+	return s.Send(ctx, &Email{
+		Kind:         KindAssessmentCompleted,
+		Organization: org,
+		Recipient:    recipientOf(candidate),
+		Data: &AssessmentCompletedData{
+			AssessmentName: template.Name,
+			CompletedAt:    e.CompletedAt,
+		},
+		DedupeKey: KindAssessmentCompleted + ":" + e.AssessmentID,
+	})
+}
+
+// sendReviewerAssigned tells a reviewer about the assessment they were assigned
+func (s *Service) sendReviewerAssigned(ctx context.Context, organizationID string, e *events.ReviewerAssigned) error {
+	assessment, err := s.assessmentRepo.ForOrganization(organizationID).GetByID(ctx, e.AssessmentID)
+	if err != nil {
+		return err
+	}
+	org, candidate, template, err := s.loadAssessment(ctx, organizationID, e.AssessmentID, assessment.CandidateID)
+	if err != nil {
+		return err
+	}
+	reviewer, err := s.userRepo.GetByID(ctx, e.ReviewerID)
+	if err != nil {
+		return err
+	}
+
+	data := &ReviewerAssignedData{
+		AssessmentName: template.Name,
+		CandidateName:  displayName(candidate),
+		URL:            s.link("/assessments/" + e.AssessmentID),
+	}
+	if e.AssignedBy != "" && e.AssignedBy != e.ReviewerID {
+		if assigner, err := s.userRepo.GetByID(ctx, e.AssignedBy); err == nil {
+			data.AssignedBy = assigner.FullName()
+		}
+	}
+
+	return s.Send(ctx, &Email{
+		Kind:         KindReviewerAssigned,
+		Organization: org,
+		Recipient:    recipientOf(reviewer),
+		Data:         data,
+		DedupeKey:    fmt.Sprintf("%s:%s:%s", KindReviewerAssigned, e.AssessmentID, e.ReviewerID),
+	})
+}
+
+// loadAssessment loads the organization, candidate and template an email
+// about an assessment needs
+func (s *Service) loadAssessment(ctx context.Context, organizationID, assessmentID, candidateID string) (*model.Organization, *model.User, *model.AssessmentTemplate, error) {
+	org, err := s.organizationRepo.GetByID(ctx, organizationID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	assessments := s.assessmentRepo.ForOrganization(organizationID)
+	assessment, err := assessments.GetByID(ctx, assessmentID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	template, err := assessments.GetTemplateByID(ctx, assessment.AssessmentTemplateID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	candidate, err := s.userRepo.GetByID(ctx, candidateID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return org, candidate, template, nil
+}
+
+// SendReminders reminds candidates of the assessments starting within the
+// configured lead time. Each assessment's reminder is sent once per
+// scheduled start, so rescheduling an assessment sends a new one.
+func (s *Service) SendReminders(ctx context.Context) error {
+	now := time.Now().UTC()
+	assessments, err := s.assessmentRepo.ListStartingBetween(ctx, now, now.Add(s.cfg.ReminderLead))
+	if err != nil {
+		return err
+	}
+
+	organizations := map[string]*model.Organization{}
+	for _, assessment := range assessments {
+		err := s.sendReminder(ctx, organizations, assessment)
+		if err != nil && !isNotFound(err) {
+			s.log.Error("Failed to send assessment reminder", err, map[string]interface{}{
+				"assessment_id": assessment.ID,
+			})
+		}
+	}
+
+	return nil
+}
+
+// sendReminder reminds the candidate of an upcoming assessment. Organizations
+// are cached in organizations.
+func (s *Service) sendReminder(ctx context.Context, organizations map[string]*model.Organization, assessment *model.Assessment) error {
+	organizationID := assessment.Template.OrganizationID
+	org, ok := organizations[organizationID]
+	if !ok {
+		var err error
+		if org, err = s.organizationRepo.GetByID(ctx, organizationID); err != nil {
+			return err
+		}
+		organizations[organizationID] = org
+	}
+
+	candidate, err := s.userRepo.GetByID(ctx, assessment.CandidateID)
+	if err != nil {
+		return err
+	}
+
+	data := &AssessmentReminderData{
+		AssessmentName: assessment.Template.Name,
+		ScheduledStart: assessment.ScheduledStartTime,
+	}
+	link := &model.MagicLink{
+		ID:             uuid.New().String(),
+		OrganizationID: organizationID,
+		AssessmentID:   assessment.ID,
+		CandidateID:    assessment.CandidateID,
+	}
+
+	return s.Send(ctx, &Email{
+		Kind:         KindAssessmentReminder,
+		Organization: org,
+		Recipient:    recipientOf(candidate),
+		Data:         data,
+		DedupeKey:    fmt.Sprintf("%s:%s:%d", KindAssessmentReminder, assessment.ID, assessment.ScheduledStartTime.Unix()),
+		Prepare: func(ctx context.Context) (err error) {
+			data.URL, data.LinkExpiresAt, err = s.issueSignInLink(ctx, link, assessment.ScheduledStartTime)
+			return err
+		},
+	})
+}
+
+// NotifySubscription implements subscription.Notifier. Notices go to the
+// organization's billing email, or its contact email if it has none.
+func (s *Service) NotifySubscription(ctx context.Context, org *model.Organization, notice *subscription.Notice) error {
+	to := org.BillingEmail
+	if to == "" {
+		to = org.ContactEmail
+	}
+
+	return s.Send(ctx, &Email{
+		Kind:         KindSubscriptionNotice,
+		Organization: org,
+		Recipient:    Recipient{Name: org.Name, Email: to},
+		Data: &SubscriptionNoticeData{
+			Notice:        notice.Kind,
+			DaysLeft:      notice.DaysLeft,
+			ReferenceDate: notice.ReferenceDate,
+			Plan:          org.SubscriptionPlan,
+			URL:           s.link("/settings"),
+		},
+		DedupeKey: fmt.Sprintf("%s:%s:%s:%d:%s", KindSubscriptionNotice, org.ID, notice.Kind, notice.DaysLeft, notice.ReferenceDate.Format("2006-01-02")),
+	})
+}
+
+// link returns the absolute URL of a frontend path
+func (s *Service) link(path string) string {
+	return strings.TrimRight(s.cfg.AppURL, "/") + path
+}
+
+// BrandingOf returns the branding of an organization's emails
+func BrandingOf(org *model.Organization) Branding {
+	return Branding{
+		Name:       org.Name,
+		LogoURL:    org.LogoURL,
+		WebsiteURL: org.WebsiteURL,
+	}
+}
+
+// recipientOf returns a user as an email recipient
+func recipientOf(user *model.User) Recipient {
+	return Recipient{Name: user.FullName(), Email: user.Email}
+}
+
+// displayName returns a user's full name, or their email if they have none
+func displayName(user *model.User) string {
+	if name := user.FullName(); name != "" {
+		return name
+	}
+	return user.Email
+}
+
+// isNotFound reports whether err is one of the repositories' not-found errors
+func isNotFound(err error) bool {
+	return errors.Is(err, database.ErrRecordNotFound) ||
+		errors.Is(err, repository.ErrAssessmentNotFound) ||
+		errors.Is(err, repository.ErrAssessmentTemplateNotFound)
+}
@@ -0,0 +1,263 @@
+package notification
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"path"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// Kinds of email
+const (
+	KindUserInvitation       = "user_invitation"
+	KindAssessmentInvitation = "assessment_invitation"
+	KindAssessmentReminder   = "assessment_reminder"
+	KindAssessmentCompleted  = "assessment_completed"
+	KindReviewerAssigned     = "reviewer_assigned"
+	KindSubscriptionNotice   = "subscription_notice"
+	KindPasswordReset        = "password_reset"
+)
+
+// Kinds lists every kind of email
+var Kinds = []string{
+	KindUserInvitation,
+	KindAssessmentInvitation,
+	KindAssessmentReminder,
+	KindAssessmentCompleted,
+	KindReviewerAssigned,
+	KindSubscriptionNotice,
+	KindPasswordReset,
+}
+
+// DefaultLocale is the locale used when an organization's locale has no
+// built-in template
+const DefaultLocale = "en"
+
+// Locales lists the locales with built-in templates
+var Locales = []string{"en", "pl"}
+
+// ErrUnknownKind is returned for a kind of email that does not exist
+var ErrUnknownKind = errors.New("unknown email kind")
+
+// ErrUnknownLocale is returned for a locale without built-in templates
+var ErrUnknownLocale = errors.New("unknown email locale")
+
+//go:embed templates
+var templateFS embed.FS
+
+// Section markers of a template file
+const (
+	sectionSubject = "-- subject --"
+	sectionText    = "-- text --"
+	sectionHTML    = "-- html --"
+)
+
+// dateFormats are the date and date-time layouts of every locale
+var dateFormats = map[string][2]string{
+	"en": {"January 2, 2006", "January 2, 2006 at 15:04 MST"},
+	"pl": {"02.01.2006", "02.01.2006, 15:04 MST"},
+}
+
+// Source is the source of an email template. Subject and Text are
+// text/template templates, HTML is an html/template template rendered into
+// the organization's branded layout.
+type Source struct {
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+	HTML    string `json:"html"`
+}
+
+// Validate checks that every part of the source is present
+func (s *Source) Validate() error {
+	switch {
+	case strings.TrimSpace(s.Subject) == "":
+		return errors.New("subject is required")
+	case strings.TrimSpace(s.Text) == "":
+		return errors.New("text is required")
+	case strings.TrimSpace(s.HTML) == "":
+		return errors.New("html is required")
+	}
+	return nil
+}
+
+// IsKind reports whether kind is a kind of email
+func IsKind(kind string) bool {
+	for _, k := range Kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// IsLocale reports whether locale has built-in templates
+func IsLocale(locale string) bool {
+	_, ok := dateFormats[locale]
+	return ok
+}
+
+// DefaultSource returns the built-in template of kind in locale
+func DefaultSource(kind, locale string) (*Source, error) {
+	if !IsKind(kind) {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKind, kind)
+	}
+	if !IsLocale(locale) {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownLocale, locale)
+	}
+
+	data, err := templateFS.ReadFile(path.Join("templates", locale, kind+".tmpl"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s/%s template: %w", locale, kind, err)
+	}
+	return parseSource(string(data))
+}
+
+// parseSource splits a template file into its subject, text and HTML sections
+func parseSource(data string) (*Source, error) {
+	var src Source
+	var current *string
+	var lines []string
+	flush := func() {
+		if current != nil {
+			*current = strings.TrimSpace(strings.Join(lines, "\n"))
+		}
+		lines = nil
+	}
+
+	for _, line := range strings.Split(data, "\n") {
+		switch strings.TrimSpace(line) {
+		case sectionSubject:
+			flush()
+			current = &src.Subject
+		case sectionText:
+			flush()
+			current = &src.Text
+		case sectionHTML:
+			flush()
+			current = &src.HTML
+		default:
+			lines = append(lines, line)
+		}
+	}
+	flush()
+
+	if err := src.Validate(); err != nil {
+		return nil, err
+	}
+	return &src, nil
+}
+
+// Branding is the organization an email is sent on behalf of
+type Branding struct {
+	Name       string
+	LogoURL    string
+	WebsiteURL string
+}
+
+// Recipient is the person an email is sent to
+type Recipient struct {
+	Name  string
+	Email string
+}
+
+// Context is what templates are rendered with. Data is the data of the kind
+// of email, e.g. *UserInvitationData.
+type Context struct {
+	Organization Branding
+	Recipient    Recipient
+	Data         interface{}
+}
+
+// Template is a compiled email template
+type Template struct {
+	locale  string
+	subject *texttemplate.Template
+	text    *texttemplate.Template
+	html    *htmltemplate.Template
+	layout  *htmltemplate.Template
+}
+
+// Compile compiles src for locale, whose date formats and layout it uses
+func Compile(src *Source, locale string) (*Template, error) {
+	if !IsLocale(locale) {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownLocale, locale)
+	}
+	if err := src.Validate(); err != nil {
+		return nil, err
+	}
+
+	funcs := templateFuncs(locale)
+	subject, err := texttemplate.New("subject").Funcs(funcs).Parse(src.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("invalid subject: %w", err)
+	}
+	text, err := texttemplate.New("text").Funcs(funcs).Parse(src.Text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid text: %w", err)
+	}
+	html, err := htmltemplate.New("html").Funcs(funcs).Parse(src.HTML)
+	if err != nil {
+		return nil, fmt.Errorf("invalid html: %w", err)
+	}
+
+	layoutSource, err := templateFS.ReadFile(path.Join("templates", locale, "layout.html"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s layout: %w", locale, err)
+	}
+	layout, err := htmltemplate.New("layout").Funcs(funcs).Parse(string(layoutSource))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s layout: %w", locale, err)
+	}
+
+	return &Template{
+		locale:  locale,
+		subject: subject,
+		text:    text,
+		html:    html,
+		layout:  layout,
+	}, nil
+}
+
+// Render renders the template into a message to the context's recipient
+func (t *Template) Render(ctx *Context) (*Message, error) {
+	var subject, text, body, html bytes.Buffer
+	if err := t.subject.Execute(&subject, ctx); err != nil {
+		return nil, fmt.Errorf("failed to render subject: %w", err)
+	}
+	if err := t.text.Execute(&text, ctx); err != nil {
+		return nil, fmt.Errorf("failed to render text: %w", err)
+	}
+	if err := t.html.Execute(&body, ctx); err != nil {
+		return nil, fmt.Errorf("failed to render html: %w", err)
+	}
+
+	err := t.layout.Execute(&html, struct {
+		*Context
+		Subject string
+		Body    htmltemplate.HTML
+	}{ctx, subject.String(), htmltemplate.HTML(body.String())})
+	if err != nil {
+		return nil, fmt.Errorf("failed to render layout: %w", err)
+	}
+
+	return &Message{
+		To:      formatAddress(ctx.Recipient),
+		Subject: strings.Join(strings.Fields(subject.String()), " "),
+		Text:    strings.TrimSpace(text.String()) + "\n",
+		HTML:    html.String(),
+	}, nil
+}
+
+// templateFuncs returns the functions available to the templates of locale
+func templateFuncs(locale string) map[string]interface{} {
+	formats := dateFormats[locale]
+	return map[string]interface{}{
+		"date":     func(t time.Time) string { return t.UTC().Format(formats[0]) },
+		"datetime": func(t time.Time) string { return t.UTC().Format(formats[1]) },
+	}
+}
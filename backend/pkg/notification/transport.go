@@ -0,0 +1,143 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/config"
+	"github.com/cstanislawski/qualifyd/pkg/jobs"
+)
+
+// Transport sends rendered emails
+type Transport interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+// NewTransport creates the transport selected by cfg.Transport
+func NewTransport(cfg *config.EmailConfig) (Transport, error) {
+	switch cfg.Transport {
+	case "smtp":
+		return NewSMTPTransport(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword), nil
+	case "file":
+		return NewFileTransport(cfg.FileDir)
+	default:
+		return nil, fmt.Errorf("unknown email transport %q", cfg.Transport)
+	}
+}
+
+// SMTPTransport sends emails through an SMTP server, using STARTTLS when the
+// server offers it
+type SMTPTransport struct {
+	addr string
+	auth smtp.Auth
+}
+
+// NewSMTPTransport creates a new SMTPTransport. Authentication is skipped if
+// username is empty.
+func NewSMTPTransport(host string, port int, username, password string) *SMTPTransport {
+	t := &SMTPTransport{
+		addr: net.JoinHostPort(host, strconv.Itoa(port)),
+	}
+	if username != "" {
+		t.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return t
+}
+
+// Send implements Transport. Errors the server reports as permanent (5xx)
+// are marked permanent, so that the job sending the email is not retried.
+func (t *SMTPTransport) Send(ctx context.Context, msg *Message) error {
+	from, err := msg.Sender()
+	if err != nil {
+		return jobs.Permanent(err)
+	}
+	to, err := msg.Recipients()
+	if err != nil {
+		return jobs.Permanent(err)
+	}
+	body, err := msg.Bytes(time.Now())
+	if err != nil {
+		return err
+	}
+
+	// smtp.SendMail takes no context; if the job times out the send is
+	// abandoned rather than interrupted
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(t.addr, t.auth, from, to, body)
+	}()
+
+	select {
+	case err := <-done:
+		var protoErr *textproto.Error
+		if errors.As(err, &protoErr) && protoErr.Code >= 500 {
+			return jobs.Permanent(err)
+		}
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// FileTransport writes every email as an .eml file to a directory, for local
+// development
+type FileTransport struct {
+	dir string
+}
+
+// NewFileTransport creates a new FileTransport writing to dir
+func NewFileTransport(dir string) (*FileTransport, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create email directory: %w", err)
+	}
+	return &FileTransport{dir: dir}, nil
+}
+
+// Send implements Transport
+func (t *FileTransport) Send(ctx context.Context, msg *Message) error {
+	now := time.Now().UTC()
+	body, err := msg.Bytes(now)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", now.Format("20060102T150405.000000000"), randomID()[:8])
+	if err := os.WriteFile(filepath.Join(t.dir, name), body, 0o644); err != nil {
+		return fmt.Errorf("failed to write email: %w", err)
+	}
+	return nil
+}
+
+// MemoryTransport keeps sent emails in memory, for tests
+type MemoryTransport struct {
+	mu       sync.Mutex
+	messages []*Message
+}
+
+// NewMemoryTransport creates a new MemoryTransport
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{}
+}
+
+// Send implements Transport
+func (t *MemoryTransport) Send(ctx context.Context, msg *Message) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.messages = append(t.messages, msg)
+	return nil
+}
+
+// Messages returns the emails sent so far
+func (t *MemoryTransport) Messages() []*Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]*Message(nil), t.messages...)
+}
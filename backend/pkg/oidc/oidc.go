@@ -0,0 +1,382 @@
+// Package oidc implements the relying party side of OpenID Connect single
+// sign-on: discovering providers, sending users to them with the
+// authorization code flow and PKCE, and exchanging the codes they return for
+// verified ID tokens and the identities they carry.
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+// Common errors
+var (
+	ErrInvalidIDToken = errors.New("invalid ID token")
+	ErrNonceMismatch  = errors.New("ID token nonce does not match")
+)
+
+// discoveryTTL is how long a provider's discovery document and signing keys
+// are cached
+const discoveryTTL = time.Hour
+
+// keyRefetchInterval is the least time between fetches of a provider's
+// signing keys for ID tokens signed with unknown keys, so that such tokens
+// cannot make the client hammer the provider
+const keyRefetchInterval = time.Minute
+
+// Config identifies a relying party at an OpenID provider
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	// GroupsClaim is the ID token claim listing the user's groups
+	GroupsClaim string
+}
+
+// Identity is the user an ID token was issued for
+type Identity struct {
+	Subject   string
+	Email     string
+	FirstName string
+	LastName  string
+	Groups    []string
+}
+
+// provider is the part of a provider's discovery document the client uses
+type provider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+
+	keys      map[string]interface{}
+	fetchedAt time.Time
+	// keysFetchedAt is when keys were last fetched, or a refetch started
+	keysFetchedAt time.Time
+}
+
+// Client runs the authorization code flow with PKCE against OpenID providers
+// and verifies the ID tokens they issue. Discovery documents and signing
+// keys are cached per issuer.
+type Client struct {
+	client *http.Client
+
+	mu        sync.Mutex
+	providers map[string]*provider
+}
+
+// NewClient creates a new Client whose requests to providers time out after
+// timeout
+func NewClient(timeout time.Duration) *Client {
+	return &Client{
+		client:    &http.Client{Timeout: timeout},
+		providers: make(map[string]*provider),
+	}
+}
+
+// GenerateVerifier returns a new PKCE code verifier
+func GenerateVerifier() string {
+	return oauth2.GenerateVerifier()
+}
+
+// GenerateNonce returns a random value for the state and nonce parameters
+func GenerateNonce() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// AuthCodeURL returns the URL of the provider's authorization endpoint the
+// user is sent to, carrying state, nonce and the challenge of verifier
+func (c *Client) AuthCodeURL(ctx context.Context, cfg Config, state, nonce, verifier string) (string, error) {
+	p, err := c.provider(ctx, cfg.Issuer)
+	if err != nil {
+		return "", err
+	}
+
+	return c.oauth2Config(p, cfg).AuthCodeURL(state,
+		oauth2.S256ChallengeOption(verifier),
+		oauth2.SetAuthURLParam("nonce", nonce),
+	), nil
+}
+
+// Exchange exchanges an authorization code for tokens and returns the
+// identity in the verified ID token, which must carry nonce
+func (c *Client) Exchange(ctx context.Context, cfg Config, code, verifier, nonce string) (*Identity, error) {
+	p, err := c.provider(ctx, cfg.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := c.oauth2Config(p, cfg).Exchange(context.WithValue(ctx, oauth2.HTTPClient, c.client), code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange authorization code: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return nil, fmt.Errorf("%w: token response has no ID token", ErrInvalidIDToken)
+	}
+
+	return c.verify(ctx, p, cfg, rawIDToken, nonce)
+}
+
+// oauth2Config returns the OAuth 2.0 configuration of cfg at p
+func (c *Client) oauth2Config(p *provider, cfg Config) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     cfg.ClientID,
+		ClientSecret: cfg.ClientSecret,
+		RedirectURL:  cfg.RedirectURL,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  p.AuthorizationEndpoint,
+			TokenURL: p.TokenEndpoint,
+		},
+		Scopes: []string{"openid", "email", "profile"},
+	}
+}
+
+// verify verifies an ID token's signature, issuer, audience, expiry and nonce
+// and returns its identity
+func (c *Client) verify(ctx context.Context, p *provider, cfg Config, rawIDToken, nonce string) (*Identity, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return c.key(ctx, p, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}),
+		jwt.WithIssuer(p.Issuer),
+		jwt.WithAudience(cfg.ClientID),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return nil, ErrNonceMismatch
+	}
+
+	identity := &Identity{}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Email, _ = claims["email"].(string)
+	identity.FirstName, _ = claims["given_name"].(string)
+	identity.LastName, _ = claims["family_name"].(string)
+	identity.Email = strings.ToLower(strings.TrimSpace(identity.Email))
+	if identity.Subject == "" || identity.Email == "" {
+		return nil, fmt.Errorf("%w: subject or email missing", ErrInvalidIDToken)
+	}
+	// Providers that say whether the email is verified must have verified it
+	if verified, ok := claims["email_verified"].(bool); ok && !verified {
+		return nil, fmt.Errorf("%w: email not verified", ErrInvalidIDToken)
+	}
+
+	if cfg.GroupsClaim != "" {
+		switch groups := claims[cfg.GroupsClaim].(type) {
+		case []interface{}:
+			for _, g := range groups {
+				if s, ok := g.(string); ok {
+					identity.Groups = append(identity.Groups, s)
+				}
+			}
+		case string:
+			identity.Groups = []string{groups}
+		}
+	}
+
+	return identity, nil
+}
+
+// provider returns the discovery document of issuer, fetching it if it is
+// not cached or is stale
+func (c *Client) provider(ctx context.Context, issuer string) (*provider, error) {
+	issuer = strings.TrimRight(issuer, "/")
+
+	c.mu.Lock()
+	p, ok := c.providers[issuer]
+	c.mu.Unlock()
+	if ok && time.Since(p.fetchedAt) < discoveryTTL {
+		return p, nil
+	}
+
+	p = &provider{}
+	if err := c.getJSON(ctx, issuer+"/.well-known/openid-configuration", p); err != nil {
+		return nil, fmt.Errorf("failed to discover OpenID provider: %w", err)
+	}
+	if strings.TrimRight(p.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("discovery document is for issuer %q, not %q", p.Issuer, issuer)
+	}
+	if p.AuthorizationEndpoint == "" || p.TokenEndpoint == "" || p.JWKSURI == "" {
+		return nil, errors.New("discovery document lacks required endpoints")
+	}
+	if err := c.fetchKeys(ctx, p); err != nil {
+		return nil, err
+	}
+	p.fetchedAt = time.Now()
+
+	c.mu.Lock()
+	c.providers[issuer] = p
+	c.mu.Unlock()
+	return p, nil
+}
+
+// key returns the signing key of p with the given ID. Unknown keys are
+// fetched again once, since providers rotate their keys, but no sooner than
+// keyRefetchInterval after the last fetch.
+func (c *Client) key(ctx context.Context, p *provider, kid string) (interface{}, error) {
+	c.mu.Lock()
+	key, ok := p.keys[kid]
+	refetch := !ok && time.Since(p.keysFetchedAt) >= keyRefetchInterval
+	if refetch {
+		p.keysFetchedAt = time.Now()
+	}
+	c.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	if !refetch {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	if err := c.fetchKeys(ctx, p); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	key, ok = p.keys[kid]
+	c.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// jwk is a JSON Web Key as published in a provider's key set
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// fetchKeys fetches p's signing keys. Keys of unsupported types are skipped.
+func (c *Client) fetchKeys(ctx context.Context, p *provider) error {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := c.getJSON(ctx, p.JWKSURI, &set); err != nil {
+		return fmt.Errorf("failed to fetch signing keys: %w", err)
+	}
+
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = key
+	}
+
+	c.mu.Lock()
+	p.keys = keys
+	p.keysFetchedAt = time.Now()
+	c.mu.Unlock()
+	return nil
+}
+
+// publicKey decodes an RSA or EC public key
+func (k jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// decodeBigInt decodes a base64url-encoded unsigned integer
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// getJSON fetches url and decodes its JSON body into v
+func (c *Client) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Discover fetches the discovery document and signing keys of issuer, to
+// check that it is a working OpenID provider
+func (c *Client) Discover(ctx context.Context, issuer string) error {
+	_, err := c.provider(ctx, issuer)
+	return err
+}
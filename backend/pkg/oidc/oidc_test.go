@@ -0,0 +1,249 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockProvider is a local OpenID provider that issues an ID token for one
+// authorization code
+type mockProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	// claims are put into the ID token issued for the code; iss, aud, exp
+	// and nonce are filled in unless set
+	claims jwt.MapClaims
+	// challenge and nonce are taken from the authorization request
+	challenge string
+	nonce     string
+	// jwksRequests counts the requests for the signing keys
+	jwksRequests int
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	m := &mockProvider{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.server.URL,
+			"authorization_endpoint": m.server.URL + "/authorize",
+			"token_endpoint":         m.server.URL + "/token",
+			"jwks_uri":               m.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		m.jwksRequests++
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "key-1",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if r.PostForm.Get("code") != "code-1" || base64.RawURLEncoding.EncodeToString(sum[:]) != m.challenge {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+
+		claims := jwt.MapClaims{
+			"iss":   m.server.URL,
+			"aud":   "client-1",
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"nonce": m.nonce,
+		}
+		for k, v := range m.claims {
+			claims[k] = v
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "key-1"
+		idToken, err := token.SignedString(key)
+		if err != nil {
+			t.Errorf("SignedString() error = %v", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"id_token":     idToken,
+		})
+	})
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+// authorize takes the challenge and nonce from an authorization URL, as the
+// provider would before redirecting back with code-1
+func (m *mockProvider) authorize(t *testing.T, authURL string) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("url.Parse() error = %v", err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" {
+		t.Errorf("code_challenge_method = %q, want S256", q.Get("code_challenge_method"))
+	}
+	m.challenge = q.Get("code_challenge")
+	m.nonce = q.Get("nonce")
+}
+
+func TestExchange(t *testing.T) {
+	m := newMockProvider(t)
+	client := NewClient(5 * time.Second)
+	cfg := Config{
+		Issuer:      m.server.URL,
+		ClientID:    "client-1",
+		RedirectURL: "http://localhost:3000/sso/callback",
+		GroupsClaim: "groups",
+	}
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		claims   jwt.MapClaims
+		verifier string
+		nonce    string
+		wantErr  bool
+		// errIs, if set, is the error the failure must wrap
+		errIs error
+	}{
+		{
+			name: "valid",
+			claims: jwt.MapClaims{
+				"sub": "subject-1", "email": "Jane@Example.com", "email_verified": true,
+				"given_name": "Jane", "family_name": "Doe", "groups": []string{"eng", "hiring"},
+			},
+		},
+		{
+			name:    "nonce mismatch",
+			claims:  jwt.MapClaims{"sub": "subject-1", "email": "jane@example.com"},
+			nonce:   "other",
+			wantErr: true,
+			errIs:   ErrNonceMismatch,
+		},
+		{
+			name:    "wrong audience",
+			claims:  jwt.MapClaims{"sub": "subject-1", "email": "jane@example.com", "aud": "client-2"},
+			wantErr: true,
+			errIs:   ErrInvalidIDToken,
+		},
+		{
+			name:    "unverified email",
+			claims:  jwt.MapClaims{"sub": "subject-1", "email": "jane@example.com", "email_verified": false},
+			wantErr: true,
+			errIs:   ErrInvalidIDToken,
+		},
+		{
+			name:     "wrong verifier",
+			claims:   jwt.MapClaims{"sub": "subject-1", "email": "jane@example.com"},
+			verifier: "wrong-verifier-wrong-verifier-wrong-verifier",
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verifier := GenerateVerifier()
+			nonce, err := GenerateNonce()
+			if err != nil {
+				t.Fatalf("GenerateNonce() error = %v", err)
+			}
+			authURL, err := client.AuthCodeURL(ctx, cfg, "state-1", nonce, verifier)
+			if err != nil {
+				t.Fatalf("AuthCodeURL() error = %v", err)
+			}
+			m.authorize(t, authURL)
+			m.claims = tt.claims
+
+			if tt.verifier != "" {
+				verifier = tt.verifier
+			}
+			if tt.nonce != "" {
+				nonce = tt.nonce
+			}
+			identity, err := client.Exchange(ctx, cfg, "code-1", verifier, nonce)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Exchange() succeeded, want error")
+				}
+				if tt.errIs != nil && !errors.Is(err, tt.errIs) {
+					t.Errorf("Exchange() error = %v, want %v", err, tt.errIs)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Exchange() error = %v", err)
+			}
+
+			if identity.Subject != "subject-1" || identity.Email != "jane@example.com" ||
+				identity.FirstName != "Jane" || identity.LastName != "Doe" {
+				t.Errorf("Exchange() identity = %+v", identity)
+			}
+			if len(identity.Groups) != 2 || identity.Groups[0] != "eng" || identity.Groups[1] != "hiring" {
+				t.Errorf("Exchange() groups = %v, want [eng hiring]", identity.Groups)
+			}
+		})
+	}
+}
+
+func TestUnknownKeyRefetchInterval(t *testing.T) {
+	m := newMockProvider(t)
+	client := NewClient(5 * time.Second)
+	ctx := context.Background()
+
+	p, err := client.provider(ctx, m.server.URL)
+	if err != nil {
+		t.Fatalf("provider() error = %v", err)
+	}
+
+	// The keys were just fetched with the discovery document
+	if _, err := client.key(ctx, p, "key-2"); err == nil {
+		t.Fatal("key() of an unknown key succeeded")
+	}
+	if m.jwksRequests != 1 {
+		t.Errorf("signing keys fetched %d times, want 1", m.jwksRequests)
+	}
+
+	client.mu.Lock()
+	p.keysFetchedAt = time.Now().Add(-keyRefetchInterval)
+	client.mu.Unlock()
+	for i := 0; i < 3; i++ {
+		if _, err := client.key(ctx, p, "key-2"); err == nil {
+			t.Fatal("key() of an unknown key succeeded")
+		}
+	}
+	if m.jwksRequests != 2 {
+		t.Errorf("signing keys fetched %d times, want 2", m.jwksRequests)
+	}
+
+	if _, err := client.key(ctx, p, "key-1"); err != nil {
+		t.Errorf("key() of a known key error = %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+package quota
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/model"
+)
+
+// Refusal codes returned in Refusal.Error and used as the metrics reason label
+const (
+	CodeQuotaExceeded        = "quota_exceeded"
+	CodeSubscriptionInactive = "subscription_inactive"
+	CodeSubscriptionExpired  = "subscription_expired"
+	CodeTrialExpired         = "trial_expired"
+	CodePaymentPastDue       = "payment_past_due"
+)
+
+// ErrAssessmentClosed is returned when a terminal environment is requested for
+// an assessment that is no longer scheduled or in progress
+var ErrAssessmentClosed = errors.New("assessment is closed")
+
+// LimitError is returned when a reservation would exceed an organization's quota
+type LimitError struct {
+	OrganizationID string
+	Resource       Resource
+	Limit          int
+	Current        int
+	// ResetsAt is when the limit frees up on its own, for monthly limits
+	ResetsAt time.Time
+}
+
+// Error implements the error interface
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s: %s limit of %d reached", model.ErrQuotaLimitExceeded, e.Resource, e.Limit)
+}
+
+// Unwrap makes errors.Is(err, model.ErrQuotaLimitExceeded) hold
+func (e *LimitError) Unwrap() error {
+	return model.ErrQuotaLimitExceeded
+}
+
+// Refusal is the structured body returned to clients when the quota service
+// refuses a request
+type Refusal struct {
+	Error             string   `json:"error"`
+	Message           string   `json:"message"`
+	Resource          Resource `json:"resource,omitempty"`
+	Limit             int      `json:"limit,omitempty"`
+	Current           int      `json:"current,omitempty"`
+	RetryAfterSeconds int      `json:"retry_after_seconds,omitempty"`
+}
+
+// NewRefusal converts a quota service error into an HTTP status and refusal
+// body. It returns false for errors that are not quota refusals.
+//
+// Limits that free up on their own (concurrent environments, the monthly
+// assessment allowance) are reported as 429 Too Many Requests; plan limits
+// and subscription problems that need the customer to pay are reported as
+// 402 Payment Required.
+func NewRefusal(err error) (int, Refusal, bool) {
+	var limitErr *LimitError
+	if errors.As(err, &limitErr) {
+		refusal := Refusal{
+			Error:    CodeQuotaExceeded,
+			Message:  fmt.Sprintf("The organization has reached its %s limit of %d", limitErr.Resource.label(), limitErr.Limit),
+			Resource: limitErr.Resource,
+			Limit:    limitErr.Limit,
+			Current:  limitErr.Current,
+		}
+		switch limitErr.Resource {
+		case ResourceEnvironments:
+			return http.StatusTooManyRequests, refusal, true
+		case ResourceAssessments:
+			if !limitErr.ResetsAt.IsZero() {
+				refusal.RetryAfterSeconds = int(time.Until(limitErr.ResetsAt).Seconds())
+			}
+			return http.StatusTooManyRequests, refusal, true
+		default:
+			return http.StatusPaymentRequired, refusal, true
+		}
+	}
+
+	code, message := subscriptionRefusal(err)
+	if code == "" {
+		return 0, Refusal{}, false
+	}
+	return http.StatusPaymentRequired, Refusal{Error: code, Message: message}, true
+}
+
+// subscriptionRefusal returns the refusal code and message for a subscription error
+func subscriptionRefusal(err error) (string, string) {
+	switch {
+	case errors.Is(err, model.ErrTrialExpired):
+		return CodeTrialExpired, "The organization's trial has expired"
+	case errors.Is(err, model.ErrSubscriptionExpired):
+		return CodeSubscriptionExpired, "The organization's subscription has expired"
+	case errors.Is(err, model.ErrSubscriptionPastDue):
+		return CodePaymentPastDue, "The organization's subscription payment is past due"
+	case errors.Is(err, model.ErrSubscriptionInactive):
+		return CodeSubscriptionInactive, "The organization's subscription is not active"
+	default:
+		return "", ""
+	}
+}
@@ -0,0 +1,51 @@
+package quota
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/cstanislawski/qualifyd/pkg/model"
+)
+
+func TestNewRefusal(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantOK     bool
+		wantStatus int
+		wantCode   string
+	}{
+		{"concurrent environments", &LimitError{Resource: ResourceEnvironments, Limit: 2}, true, http.StatusTooManyRequests, CodeQuotaExceeded},
+		{"monthly assessments", &LimitError{Resource: ResourceAssessments, Limit: 10}, true, http.StatusTooManyRequests, CodeQuotaExceeded},
+		{"plan limit", &LimitError{Resource: ResourceUsers, Limit: 5}, true, http.StatusPaymentRequired, CodeQuotaExceeded},
+		{"wrapped limit", fmt.Errorf("create: %w", &LimitError{Resource: ResourceTaskTemplates}), true, http.StatusPaymentRequired, CodeQuotaExceeded},
+		{"trial expired", fmt.Errorf("%w: organization x", model.ErrTrialExpired), true, http.StatusPaymentRequired, CodeTrialExpired},
+		{"past due", model.ErrSubscriptionPastDue, true, http.StatusPaymentRequired, CodePaymentPastDue},
+		{"inactive", model.ErrSubscriptionInactive, true, http.StatusPaymentRequired, CodeSubscriptionInactive},
+		{"internal error", errors.New("connection refused"), false, 0, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, refusal, ok := NewRefusal(tt.err)
+			if ok != tt.wantOK {
+				t.Fatalf("Expected ok %v, got %v", tt.wantOK, ok)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d", tt.wantStatus, status)
+			}
+			if refusal.Error != tt.wantCode {
+				t.Errorf("Expected code %q, got %q", tt.wantCode, refusal.Error)
+			}
+		})
+	}
+}
+
+func TestLimitErrorIsQuotaExceeded(t *testing.T) {
+	err := fmt.Errorf("reserve: %w", &LimitError{Resource: ResourceUsers, Limit: 5})
+	if !errors.Is(err, model.ErrQuotaLimitExceeded) {
+		t.Error("Expected LimitError to match model.ErrQuotaLimitExceeded")
+	}
+}
@@ -0,0 +1,228 @@
+// Package quota enforces the per-organization limits stored in organization_quotas.
+//
+// The counters themselves are maintained by database triggers (see migration
+// 003). The service only decides whether a new row may be created: it locks the
+// organization's quota row, checks the limit and runs the insert in the same
+// transaction, so two concurrent requests can never both take the last slot.
+package quota
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cstanislawski/qualifyd/pkg/database"
+	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/metrics"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/cstanislawski/qualifyd/pkg/repository"
+	"github.com/jackc/pgx/v5"
+)
+
+// Resource names a quota-limited resource. The values match model.QuotaResources.
+type Resource string
+
+// Quota-limited resources
+const (
+	ResourceUsers                Resource = "users"
+	ResourceTaskTemplates        Resource = "task_templates"
+	ResourceEnvironmentTemplates Resource = "environment_templates"
+	ResourceAssessmentTemplates  Resource = "assessment_templates"
+	ResourceAssessments          Resource = "assessments"
+	ResourceEnvironments         Resource = "environments"
+	ResourceEnvironmentMinutes   Resource = "environment_minutes"
+)
+
+// label returns a human readable name for the resource
+func (r Resource) label() string {
+	switch r {
+	case ResourceUsers:
+		return "user"
+	case ResourceTaskTemplates:
+		return "task template"
+	case ResourceEnvironmentTemplates:
+		return "environment template"
+	case ResourceAssessmentTemplates:
+		return "assessment template"
+	case ResourceAssessments:
+		return "monthly assessment"
+	case ResourceEnvironments:
+		return "concurrent environment"
+	case ResourceEnvironmentMinutes:
+		return "environment minute"
+	default:
+		return string(r)
+	}
+}
+
+// Service checks and reserves organization quota
+type Service struct {
+	db               *database.Database
+	organizationRepo *repository.OrganizationRepository
+	quotaRepo        *repository.QuotaRepository
+	assessmentRepo   *repository.AssessmentRepository
+	log              logger.Logger
+}
+
+// NewService creates a new quota service
+func NewService(
+	db *database.Database,
+	organizationRepo *repository.OrganizationRepository,
+	quotaRepo *repository.QuotaRepository,
+	assessmentRepo *repository.AssessmentRepository,
+	log logger.Logger,
+) *Service {
+	return &Service{
+		db:               db,
+		organizationRepo: organizationRepo,
+		quotaRepo:        quotaRepo,
+		assessmentRepo:   assessmentRepo,
+		log:              log,
+	}
+}
+
+// Reserve checks that the organization's subscription is in good standing and
+// that it has capacity for one more resource, then calls create inside the
+// transaction holding the quota row lock. create must perform the insert or
+// status change that the quota triggers count, using tx.
+func (s *Service) Reserve(ctx context.Context, organizationID string, resource Resource, create func(tx pgx.Tx) error) error {
+	if err := s.CheckSubscription(ctx, organizationID, resource); err != nil {
+		return err
+	}
+
+	return s.db.WithTx(ctx, func(tx pgx.Tx) error {
+		quota, err := s.quotaRepo.WithTx(tx).GetForUpdate(ctx, organizationID)
+		if err != nil {
+			return fmt.Errorf("failed to reserve %s quota: %w", resource, err)
+		}
+
+		if err := s.checkLimit(quota, resource); err != nil {
+			return err
+		}
+
+		return create(tx)
+	})
+}
+
+// CheckSubscription returns an error wrapping one of the model subscription
+// errors if the organization may not consume new resources
+func (s *Service) CheckSubscription(ctx context.Context, organizationID string, resource Resource) error {
+	org, err := s.organizationRepo.GetByID(ctx, organizationID)
+	if err != nil {
+		return fmt.Errorf("failed to get organization: %w", err)
+	}
+
+	if err := org.SubscriptionError(); err != nil {
+		code, _ := subscriptionRefusal(err)
+		s.refused(organizationID, resource, code)
+		return fmt.Errorf("%w: organization %s", err, organizationID)
+	}
+
+	return nil
+}
+
+// ReserveEnvironment checks that a terminal environment may be provisioned for
+// an assessment and takes its concurrent environment slot. Assessments in
+// progress already hold a slot; a scheduled assessment is started, which takes
+// one, while the quota row is locked, so concurrent connects to different
+// assessments cannot both take the last slot. Every environment also needs
+// environment minutes left in the organization's quota.
+func (s *Service) ReserveEnvironment(ctx context.Context, assessmentID string) error {
+	organizationID, _, err := s.assessmentRepo.ResolveOrganization(ctx, assessmentID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.CheckSubscription(ctx, organizationID, ResourceEnvironments); err != nil {
+		return err
+	}
+
+	return s.db.WithTx(ctx, func(tx pgx.Tx) error {
+		quota, err := s.quotaRepo.WithTx(tx).GetForUpdate(ctx, organizationID)
+		if err != nil {
+			return fmt.Errorf("failed to reserve %s quota: %w", ResourceEnvironments, err)
+		}
+
+		if err := s.checkLimit(quota, ResourceEnvironmentMinutes); err != nil {
+			return err
+		}
+
+		// Read the status under the lock: a concurrent connect to the same
+		// assessment may have started it in the meantime
+		assessments := s.assessmentRepo.ForOrganization(organizationID).WithTx(tx)
+		assessment, err := assessments.GetByID(ctx, assessmentID)
+		if err != nil {
+			return err
+		}
+
+		switch assessment.Status {
+		case model.AssessmentStatusInProgress:
+			return nil
+		case model.AssessmentStatusScheduled:
+			if err := s.checkLimit(quota, ResourceEnvironments); err != nil {
+				return err
+			}
+			assessment.Start()
+			return assessments.Update(ctx, assessment)
+		default:
+			return fmt.Errorf("%w: %s", ErrAssessmentClosed, assessmentID)
+		}
+	})
+}
+
+// checkLimit returns a *LimitError if the quota has no capacity left for the resource
+func (s *Service) checkLimit(quota *model.OrganizationQuota, resource Resource) error {
+	var reached bool
+	var limit, current int
+
+	switch resource {
+	case ResourceUsers:
+		reached, limit, current = quota.HasReachedUserLimit(), quota.MaxUsers, quota.CurrentUsers
+	case ResourceTaskTemplates:
+		reached, limit, current = quota.HasReachedTaskTemplateLimit(), quota.MaxTaskTemplates, quota.CurrentTaskTemplates
+	case ResourceEnvironmentTemplates:
+		reached, limit, current = quota.HasReachedEnvironmentTemplateLimit(), quota.MaxEnvironmentTemplates, quota.CurrentEnvironmentTemplates
+	case ResourceAssessmentTemplates:
+		reached, limit, current = quota.HasReachedAssessmentTemplateLimit(), quota.MaxAssessmentTemplates, quota.CurrentAssessmentTemplates
+	case ResourceAssessments:
+		reached, limit, current = quota.HasReachedAssessmentLimit(), quota.MaxAssessmentsPerMonth, quota.AssessmentsThisMonth
+	case ResourceEnvironments:
+		reached, limit, current = quota.HasReachedEnvironmentLimit(), quota.MaxConcurrentEnvironments, quota.CurrentActiveEnvironments
+	case ResourceEnvironmentMinutes:
+		reached, limit, current = quota.RemainingEnvironmentMinutes() == 0, quota.IncludedEnvironmentMinutes, quota.UsedEnvironmentMinutes
+	default:
+		return fmt.Errorf("unknown quota resource %q", resource)
+	}
+
+	if !reached {
+		return nil
+	}
+
+	s.refused(quota.OrganizationID, resource, CodeQuotaExceeded)
+	limitErr := &LimitError{
+		OrganizationID: quota.OrganizationID,
+		Resource:       resource,
+		Limit:          limit,
+		Current:        current,
+	}
+	if resource == ResourceAssessments || resource == ResourceEnvironmentMinutes {
+		limitErr.ResetsAt = quota.PeriodEnd
+	}
+	return limitErr
+}
+
+// refused records a quota refusal
+func (s *Service) refused(organizationID string, resource Resource, code string) {
+	metrics.QuotaRefusals.WithLabelValues(string(resource), code).Inc()
+	s.log.Warn("Quota refused request", map[string]interface{}{
+		"organization_id": organizationID,
+		"resource":        string(resource),
+		"reason":          code,
+	})
+}
+
+// IsRefusal reports whether err is a quota or subscription refusal rather than
+// an internal failure
+func IsRefusal(err error) bool {
+	_, _, ok := NewRefusal(err)
+	return ok
+}
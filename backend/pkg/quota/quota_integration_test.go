@@ -0,0 +1,159 @@
+//go:build integration
+
+package quota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/config"
+	"github.com/cstanislawski/qualifyd/pkg/database"
+	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/cstanislawski/qualifyd/pkg/repository"
+	"github.com/rs/zerolog"
+)
+
+// Quota reservation tests. They need a disposable PostgreSQL database
+// configured through the usual DATABASE_* environment variables:
+//
+//	go test -tags integration ./pkg/quota/...
+
+func setupQuotaDatabase(t *testing.T) *database.Database {
+	t.Helper()
+	ctx := context.Background()
+
+	cfg := config.Load()
+	db, err := database.New(ctx, &cfg.Database)
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	t.Cleanup(db.Close)
+
+	migrations := database.NewMigrationService(db, "../../migrations", logger.NewLogger(zerolog.Nop()))
+	if err := migrations.MigrateUp(ctx); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+
+	return db
+}
+
+// createScheduledAssessments creates an organization allowed one concurrent
+// environment, with n scheduled assessments
+func createScheduledAssessments(t *testing.T, db *database.Database, n int) (string, []string) {
+	t.Helper()
+	ctx := context.Background()
+	suffix := fmt.Sprintf("%d", time.Now().UnixNano())
+
+	var orgID, candidateID string
+	err := db.QueryRow(ctx, `
+		INSERT INTO organizations (name, subscription_status, auto_renew)
+		VALUES ($1, $2, TRUE)
+		RETURNING id
+	`, "org-"+suffix, model.SubscriptionStatusActive).Scan(&orgID)
+	if err != nil {
+		t.Fatalf("Failed to create organization: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Exec(context.Background(), `DELETE FROM assessments WHERE candidate_id = $1`, candidateID)
+		db.Exec(context.Background(), `DELETE FROM users WHERE organization_id = $1`, orgID)
+		db.Exec(context.Background(), `DELETE FROM organizations WHERE id = $1`, orgID)
+	})
+
+	_, err = db.Exec(ctx, `INSERT INTO organization_quotas (organization_id, max_concurrent_environments) VALUES ($1, 1)`, orgID)
+	if err != nil {
+		t.Fatalf("Failed to create quota: %v", err)
+	}
+
+	err = db.QueryRow(ctx, `
+		INSERT INTO users (email, password_hash, role, status, organization_id)
+		VALUES ($1, 'x', $2, 'active', $3)
+		RETURNING id
+	`, "candidate-"+suffix+"@example.com", model.RoleCandidate, orgID).Scan(&candidateID)
+	if err != nil {
+		t.Fatalf("Failed to create candidate: %v", err)
+	}
+
+	envs := repository.NewEnvironmentRepository(db.Pool()).ForOrganization(orgID)
+	assessments := repository.NewAssessmentRepository(db.Pool()).ForOrganization(orgID)
+
+	envTemplate := model.NewEnvironmentTemplate(orgID, "env-"+suffix, model.EnvironmentTypeLinux, model.EnvironmentSpecs{CPU: "1", Memory: "1Gi", Storage: "1Gi"})
+	envTemplate.Configuration = []byte(`{}`)
+	if err := envs.Create(ctx, envTemplate); err != nil {
+		t.Fatalf("Failed to create environment template: %v", err)
+	}
+
+	template := model.NewAssessmentTemplate(orgID, "assessment-"+suffix, envTemplate.ID, nil)
+	if err := assessments.CreateTemplate(ctx, template); err != nil {
+		t.Fatalf("Failed to create assessment template: %v", err)
+	}
+
+	ids := make([]string, n)
+	for i := range ids {
+		assessment := model.NewAssessment(template.ID, candidateID, candidateID, time.Now().UTC())
+		if err := assessments.Create(ctx, assessment); err != nil {
+			t.Fatalf("Failed to create assessment: %v", err)
+		}
+		ids[i] = assessment.ID
+	}
+
+	return orgID, ids
+}
+
+func TestReserveEnvironmentConcurrentConnects(t *testing.T) {
+	db := setupQuotaDatabase(t)
+	ctx := context.Background()
+	orgID, assessmentIDs := createScheduledAssessments(t, db, 5)
+
+	quotaRepo := repository.NewQuotaRepository(db)
+	service := NewService(
+		db,
+		repository.NewOrganizationRepository(db),
+		quotaRepo,
+		repository.NewAssessmentRepository(db.Pool()),
+		logger.NewLogger(zerolog.Nop()),
+	)
+
+	// Connect to every assessment at once, twice each
+	errs := make(chan error, 2*len(assessmentIDs))
+	var wg sync.WaitGroup
+	for _, id := range append(assessmentIDs, assessmentIDs...) {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			errs <- service.ReserveEnvironment(ctx, id)
+		}(id)
+	}
+	wg.Wait()
+	close(errs)
+
+	var reserved, refused int
+	for err := range errs {
+		var limitErr *LimitError
+		switch {
+		case err == nil:
+			reserved++
+		case errors.As(err, &limitErr) && limitErr.Resource == ResourceEnvironments:
+			refused++
+		default:
+			t.Fatalf("ReserveEnvironment: %v", err)
+		}
+	}
+
+	// Both connects to the started assessment succeed; all others are refused
+	if reserved != 2 || refused != 2*len(assessmentIDs)-2 {
+		t.Errorf("reserved %d and refused %d environments, want 2 and %d", reserved, refused, 2*len(assessmentIDs)-2)
+	}
+
+	quota, err := quotaRepo.GetByOrganization(ctx, orgID)
+	if err != nil {
+		t.Fatalf("Failed to get quota: %v", err)
+	}
+	if quota.CurrentActiveEnvironments != 1 {
+		t.Errorf("current active environments = %d, want 1", quota.CurrentActiveEnvironments)
+	}
+}
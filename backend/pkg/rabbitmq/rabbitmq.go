@@ -4,12 +4,17 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/url"
 	"sync"
 	"time"
 
 	"github.com/cstanislawski/qualifyd/pkg/config"
 	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/tracing"
 	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -84,7 +89,7 @@ type Consumer struct {
 	qos          int
 	isConsuming  bool
 	mu           sync.Mutex
-	handleFunc   func(delivery amqp.Delivery) error
+	handleFunc   func(ctx context.Context, delivery amqp.Delivery) error
 	stopHandling chan struct{}
 }
 
@@ -282,8 +287,24 @@ func (c *Channel) NewPublisher(exchange, routingKey string) *Publisher {
 	}
 }
 
-// Publish publishes a message
-func (p *Publisher) Publish(ctx context.Context, body []byte, contentType string, headers amqp.Table) error {
+// Publish publishes a message. The current trace context is injected into the message headers.
+func (p *Publisher) Publish(ctx context.Context, body []byte, contentType string, headers amqp.Table) (err error) {
+	ctx, span := tracing.Tracer("rabbitmq").Start(ctx, p.exchange+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "rabbitmq"),
+			attribute.String("messaging.destination.name", p.exchange),
+			attribute.String("messaging.rabbitmq.destination.routing_key", p.routingKey),
+			attribute.Int("messaging.message.body.size", len(body)),
+		),
+	)
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	traced := withTraceContext(ctx, headers)
+
 	p.channel.mu.Lock()
 	defer p.channel.mu.Unlock()
 
@@ -296,14 +317,14 @@ func (p *Publisher) Publish(ctx context.Context, body []byte, contentType string
 		Body:         body,
 		DeliveryMode: amqp.Persistent,
 		Timestamp:    time.Now(),
-		Headers:      headers,
+		Headers:      traced,
 	}
 
 	// Use context with timeout for publish
 	ctx, cancel := context.WithTimeout(ctx, p.publishTimeout)
 	defer cancel()
 
-	err := p.channel.ch.PublishWithContext(
+	err = p.channel.ch.PublishWithContext(
 		ctx,
 		p.exchange,   // exchange
 		p.routingKey, // routing key
@@ -381,6 +402,14 @@ func (c *Consumer) Consume() error {
 
 // Handle starts handling messages with the provided function
 func (c *Consumer) Handle(handleFunc func(delivery amqp.Delivery) error) {
+	c.HandleWithContext(func(_ context.Context, delivery amqp.Delivery) error {
+		return handleFunc(delivery)
+	})
+}
+
+// HandleWithContext starts handling messages with the provided function. The
+// context carries a consumer span linked to the trace found in the message headers.
+func (c *Consumer) HandleWithContext(handleFunc func(ctx context.Context, delivery amqp.Delivery) error) {
 	c.mu.Lock()
 	if !c.isConsuming {
 		c.mu.Unlock()
@@ -407,15 +436,18 @@ func (c *Consumer) Handle(handleFunc func(delivery amqp.Delivery) error) {
 					return
 				}
 
-				err := handleFunc(delivery)
+				ctx, span := c.startDeliverySpan(delivery)
+				err := handleFunc(ctx, delivery)
+				tracing.RecordError(span, err)
+				span.End()
 				if err != nil {
-					logger.Error("Error handling message", err, map[string]interface{}{
+					logger.Error("Error handling message", err, logger.WithTraceContext(ctx, map[string]interface{}{
 						"queue":         c.queue,
 						"consumerTag":   c.consumerTag,
 						"deliveryTag":   delivery.DeliveryTag,
 						"messageId":     delivery.MessageId,
 						"correlationId": delivery.CorrelationId,
-					})
+					}))
 
 					// If auto-ack is false, we need to nack the message
 					if !c.autoAck {
@@ -464,10 +496,35 @@ func (c *Consumer) StopConsuming() error {
 
 // Private helper methods
 
-// buildConnectionString builds a RabbitMQ connection string
+// startDeliverySpan starts a consumer span continuing the trace carried in the delivery headers
+func (c *Consumer) startDeliverySpan(delivery amqp.Delivery) (context.Context, trace.Span) {
+	ctx := context.Background()
+	if delivery.Headers != nil {
+		ctx = otel.GetTextMapPropagator().Extract(ctx, headerCarrier(delivery.Headers))
+	}
+
+	return tracing.Tracer("rabbitmq").Start(ctx, c.queue+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "rabbitmq"),
+			attribute.String("messaging.destination.name", delivery.Exchange),
+			attribute.String("messaging.rabbitmq.destination.routing_key", delivery.RoutingKey),
+			attribute.String("messaging.consumer.group.name", c.queue),
+			attribute.String("messaging.message.id", delivery.MessageId),
+			attribute.Int("messaging.message.body.size", len(delivery.Body)),
+		),
+	)
+}
+
+// buildConnectionString builds a RabbitMQ connection string. The default vhost
+// "/" is expressed as an empty path, other vhosts are path-escaped.
 func (r *RabbitMQ) buildConnectionString() string {
+	vhost := r.config.VHost
+	if vhost == "/" {
+		vhost = ""
+	}
 	return fmt.Sprintf("amqp://%s:%s@%s:%d/%s",
-		r.config.User, r.config.Password, r.config.Host, r.config.Port, r.config.VHost)
+		r.config.User, r.config.Password, r.config.Host, r.config.Port, url.PathEscape(vhost))
 }
 
 // monitorConnection monitors the connection and reconnects if needed
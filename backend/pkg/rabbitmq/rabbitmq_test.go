@@ -1,9 +1,14 @@
 package rabbitmq
 
 import (
+	"context"
 	"testing"
 
 	"github.com/cstanislawski/qualifyd/pkg/config"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func TestBuildConnectionString(t *testing.T) {
@@ -42,5 +47,60 @@ func TestBuildConnectionStringDefaultVHost(t *testing.T) {
 	}
 }
 
+func TestHeaderCarrierPropagatesTraceContext(t *testing.T) {
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	parent := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+		Remote:     true,
+	})
+
+	propagator := propagation.TraceContext{}
+	headers := amqp.Table{"x-existing": int32(1)}
+	propagator.Inject(trace.ContextWithSpanContext(context.Background(), parent), headerCarrier(headers))
+
+	if _, ok := headers["traceparent"]; !ok {
+		t.Fatalf("Expected traceparent header to be set, got: %v", headers)
+	}
+
+	extracted := trace.SpanContextFromContext(propagator.Extract(context.Background(), headerCarrier(headers)))
+	if extracted.TraceID() != traceID || extracted.SpanID() != spanID {
+		t.Errorf("Expected span context %s/%s, got: %s/%s", traceID, spanID, extracted.TraceID(), extracted.SpanID())
+	}
+}
+
+func TestWithTraceContextLeavesHeadersAlone(t *testing.T) {
+	previous := otel.GetTextMapPropagator()
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTextMapPropagator(previous) })
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	}))
+
+	headers := amqp.Table{"x-existing": int32(1)}
+	traced := withTraceContext(ctx, headers)
+
+	if _, ok := traced["traceparent"]; !ok {
+		t.Errorf("Expected traceparent header to be set, got: %v", traced)
+	}
+	if traced["x-existing"] != int32(1) {
+		t.Errorf("Expected existing headers to be kept, got: %v", traced)
+	}
+	if len(headers) != 1 {
+		t.Errorf("Expected the caller's headers to be left alone, got: %v", headers)
+	}
+
+	if traced := withTraceContext(ctx, nil); traced["traceparent"] == nil {
+		t.Errorf("Expected traceparent header for nil headers, got: %v", traced)
+	}
+}
+
 // Note: Integration tests that require a real RabbitMQ connection should be in a separate file
 // with a _integration_test.go suffix and use a tag to conditionally run them.
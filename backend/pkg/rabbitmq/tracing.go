@@ -0,0 +1,50 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+)
+
+// headerCarrier adapts amqp.Table to propagation.TextMapCarrier so trace
+// context can travel in message headers
+type headerCarrier amqp.Table
+
+// Get returns the header value for key as a string
+func (c headerCarrier) Get(key string) string {
+	value, ok := c[key]
+	if !ok {
+		return ""
+	}
+	if s, ok := value.(string); ok {
+		return s
+	}
+	return fmt.Sprint(value)
+}
+
+// Set stores a header value
+func (c headerCarrier) Set(key, value string) {
+	c[key] = value
+}
+
+// Keys lists the header keys
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// withTraceContext returns a copy of headers carrying the trace context of
+// ctx, leaving the caller's table as it was
+func withTraceContext(ctx context.Context, headers amqp.Table) amqp.Table {
+	traced := make(amqp.Table, len(headers)+1)
+	for key, value := range headers {
+		traced[key] = value
+	}
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier(traced))
+	return traced
+}
@@ -0,0 +1,197 @@
+// Package ratelimit limits how often clients may call sensitive routes, with
+// token buckets kept in memory or in Postgres, and locks accounts out for a
+// while after repeated failed sign-ins.
+package ratelimit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/config"
+	"github.com/cstanislawski/qualifyd/pkg/logger"
+)
+
+// Bucket is a token bucket: each request takes a token, and tokens are
+// refilled evenly over the limit's period up to its number of requests
+type Bucket struct {
+	Tokens    float64
+	UpdatedAt time.Time
+}
+
+// NewBucket returns a full bucket for limit
+func NewBucket(limit config.RateLimit, now time.Time) Bucket {
+	return Bucket{Tokens: float64(limit.Requests), UpdatedAt: now}
+}
+
+// Take refills the bucket for the time since it was last updated and takes
+// a token from it. If it has none, it returns false and how long until it
+// has one.
+func (b *Bucket) Take(limit config.RateLimit, now time.Time) (bool, time.Duration) {
+	capacity := float64(limit.Requests)
+	perSecond := capacity / limit.Period.Seconds()
+
+	if elapsed := now.Sub(b.UpdatedAt).Seconds(); elapsed > 0 {
+		b.Tokens = math.Min(capacity, b.Tokens+elapsed*perSecond)
+	}
+	b.UpdatedAt = now
+
+	if b.Tokens >= 1 {
+		b.Tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.Tokens) / perSecond * float64(time.Second))
+}
+
+// Store keeps the state of the buckets and of failed sign-ins;
+// *MemoryStore and *repository.RateLimitRepository implement it
+type Store interface {
+	// Take takes a token from key's bucket, see Bucket.Take. A bucket that
+	// does not exist yet starts full.
+	Take(ctx context.Context, key string, limit config.RateLimit, now time.Time) (bool, time.Duration, error)
+	// AddFailure counts a failed sign-in to account at now and returns how
+	// many there have been. Failures before since are forgotten first.
+	AddFailure(ctx context.Context, account string, since, now time.Time) (int, error)
+	// Failures returns how many failed sign-ins to account there have been
+	// since since, and when the last one was
+	Failures(ctx context.Context, account string, since time.Time) (int, time.Time, error)
+	// ResetFailures forgets the failed sign-ins to account
+	ResetFailures(ctx context.Context, account string) error
+	// DeleteExpired deletes the buckets last used and the failures last
+	// counted before the given time
+	DeleteExpired(ctx context.Context, before time.Time) error
+}
+
+// Limiter takes tokens from buckets and tracks failed sign-ins. Store
+// failures are logged and let requests through, so that an outage of the
+// store does not lock everyone out.
+type Limiter struct {
+	store Store
+	cfg   *config.RateLimitConfig
+	log   logger.Logger
+}
+
+// NewLimiter creates a new Limiter
+func NewLimiter(store Store, cfg *config.RateLimitConfig, log logger.Logger) *Limiter {
+	return &Limiter{
+		store: store,
+		cfg:   cfg,
+		log:   log,
+	}
+}
+
+// Allow takes a token from key's bucket for limit. If there is none, it
+// returns false and how long until there is.
+func (l *Limiter) Allow(ctx context.Context, key string, limit config.RateLimit) (bool, time.Duration) {
+	if limit.Requests <= 0 || limit.Period <= 0 {
+		return true, 0
+	}
+
+	ok, retryAfter, err := l.store.Take(ctx, key, limit, time.Now())
+	if err != nil {
+		l.log.Error("Failed to take rate limit token", err, map[string]interface{}{
+			"key": key,
+		})
+		return true, 0
+	}
+	return ok, retryAfter
+}
+
+// LockedFor returns how much longer an account is locked out for after its
+// failed sign-ins, or zero if it is not
+func (l *Limiter) LockedFor(ctx context.Context, account string) time.Duration {
+	if l.cfg.LockoutThreshold <= 0 {
+		return 0
+	}
+
+	now := time.Now()
+	failures, last, err := l.store.Failures(ctx, AccountKey(account), now.Add(-l.cfg.FailureWindow))
+	if err != nil {
+		l.log.Error("Failed to get failed sign-ins", err, nil)
+		return 0
+	}
+
+	if remaining := last.Add(l.lockout(failures)).Sub(now); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// LoginFailed counts a failed sign-in to an account and returns how long the
+// account is locked out for as a result, or zero if it is not
+func (l *Limiter) LoginFailed(ctx context.Context, account string) time.Duration {
+	if l.cfg.LockoutThreshold <= 0 {
+		return 0
+	}
+
+	now := time.Now()
+	failures, err := l.store.AddFailure(ctx, AccountKey(account), now.Add(-l.cfg.FailureWindow), now)
+	if err != nil {
+		l.log.Error("Failed to count failed sign-in", err, nil)
+		return 0
+	}
+	return l.lockout(failures)
+}
+
+// LoginSucceeded forgets an account's failed sign-ins
+func (l *Limiter) LoginSucceeded(ctx context.Context, account string) {
+	if l.cfg.LockoutThreshold <= 0 {
+		return
+	}
+
+	if err := l.store.ResetFailures(ctx, AccountKey(account)); err != nil {
+		l.log.Error("Failed to reset failed sign-ins", err, nil)
+	}
+}
+
+// Cleanup deletes the buckets and failures that no longer limit anything: a
+// bucket left alone for its period is full again, and failures are forgotten
+// after the failure window
+func (l *Limiter) Cleanup(ctx context.Context) error {
+	keep := l.cfg.FailureWindow
+	for _, limit := range []config.RateLimit{l.cfg.PerIP, l.cfg.PerAccount, l.cfg.PerRoute, l.cfg.Terminal} {
+		if limit.Period > keep {
+			keep = limit.Period
+		}
+	}
+	return l.store.DeleteExpired(ctx, time.Now().Add(-keep))
+}
+
+// lockout returns how long failures failed sign-ins lock an account out for:
+// the lockout duration once they reach the threshold, doubled by each one
+// after that up to the maximum
+func (l *Limiter) lockout(failures int) time.Duration {
+	if failures < l.cfg.LockoutThreshold {
+		return 0
+	}
+
+	lockout := l.cfg.LockoutDuration
+	for i := l.cfg.LockoutThreshold; i < failures && lockout < l.cfg.MaxLockoutDuration; i++ {
+		lockout *= 2
+	}
+	if lockout > l.cfg.MaxLockoutDuration {
+		lockout = l.cfg.MaxLockoutDuration
+	}
+	return lockout
+}
+
+// AccountKey returns the key an account's buckets and failures are kept
+// under. Differently written forms of an email address share a key, and
+// stores never hold the address itself.
+func AccountKey(account string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(account))))
+	return hex.EncodeToString(sum[:])
+}
+
+// RetryAfterSeconds returns the value of a Retry-After header asking clients
+// to wait d: whole seconds, rounded up, and at least one
+func RetryAfterSeconds(d time.Duration) int {
+	seconds := int(math.Ceil(d.Seconds()))
+	if seconds < 1 {
+		return 1
+	}
+	return seconds
+}
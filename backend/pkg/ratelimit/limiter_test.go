@@ -0,0 +1,107 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/config"
+	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/rs/zerolog"
+)
+
+func TestBucketTake(t *testing.T) {
+	limit := config.RateLimit{Requests: 2, Period: time.Minute}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := NewBucket(limit, now)
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := b.Take(limit, now); !ok {
+			t.Fatalf("Take() %d of a full bucket = false, want true", i+1)
+		}
+	}
+
+	ok, retryAfter := b.Take(limit, now)
+	if ok {
+		t.Fatal("Take() of an empty bucket = true, want false")
+	}
+	if retryAfter != 30*time.Second {
+		t.Errorf("retry after = %v, want 30s", retryAfter)
+	}
+
+	// Half the period refills one of the two tokens
+	if ok, _ := b.Take(limit, now.Add(30*time.Second)); !ok {
+		t.Error("Take() after refilling a token = false, want true")
+	}
+	if ok, _ := b.Take(limit, now.Add(30*time.Second)); ok {
+		t.Error("Take() after using the refilled token = true, want false")
+	}
+
+	// A bucket left alone refills no further than full
+	later := now.Add(time.Hour)
+	for i := 0; i < 2; i++ {
+		if ok, _ := b.Take(limit, later); !ok {
+			t.Fatalf("Take() %d of a refilled bucket = false, want true", i+1)
+		}
+	}
+	if ok, _ := b.Take(limit, later); ok {
+		t.Error("Take() past the capacity = true, want false")
+	}
+}
+
+func TestLimiterLockout(t *testing.T) {
+	cfg := &config.RateLimitConfig{
+		LockoutThreshold:   3,
+		LockoutDuration:    time.Minute,
+		MaxLockoutDuration: 5 * time.Minute,
+		FailureWindow:      time.Hour,
+	}
+	l := NewLimiter(NewMemoryStore(), cfg, logger.NewLogger(zerolog.Nop()))
+	ctx := context.Background()
+
+	// Lockouts start at the threshold and double up to the maximum
+	want := []time.Duration{0, 0, time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute}
+	for i, lockout := range want {
+		if got := l.LoginFailed(ctx, "Jane@Example.com"); got != lockout {
+			t.Errorf("LoginFailed() %d = %v, want %v", i+1, got, lockout)
+		}
+	}
+
+	// Accounts are matched whatever the case of their email
+	if got := l.LockedFor(ctx, " jane@example.com"); got <= 4*time.Minute || got > 5*time.Minute {
+		t.Errorf("LockedFor() = %v, want just under 5m", got)
+	}
+	if got := l.LockedFor(ctx, "john@example.com"); got != 0 {
+		t.Errorf("LockedFor() of another account = %v, want 0", got)
+	}
+
+	l.LoginSucceeded(ctx, "jane@example.com")
+	if got := l.LockedFor(ctx, "jane@example.com"); got != 0 {
+		t.Errorf("LockedFor() after signing in = %v, want 0", got)
+	}
+	if got := l.LoginFailed(ctx, "jane@example.com"); got != 0 {
+		t.Errorf("LoginFailed() after signing in = %v, want 0", got)
+	}
+}
+
+func TestMemoryStoreForgetsOldFailures(t *testing.T) {
+	s := NewMemoryStore()
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	s.AddFailure(ctx, "jane@example.com", now.Add(-time.Hour), now)
+	s.AddFailure(ctx, "jane@example.com", now.Add(-time.Hour), now)
+
+	later := now.Add(2 * time.Hour)
+	if count, _, _ := s.Failures(ctx, "jane@example.com", later.Add(-time.Hour)); count != 0 {
+		t.Errorf("Failures() outside the window = %d, want 0", count)
+	}
+	if count, _ := s.AddFailure(ctx, "jane@example.com", later.Add(-time.Hour), later); count != 1 {
+		t.Errorf("AddFailure() outside the window = %d, want 1", count)
+	}
+
+	s.DeleteExpired(ctx, later.Add(time.Second))
+	if count, _, _ := s.Failures(ctx, "jane@example.com", time.Time{}); count != 0 {
+		t.Errorf("Failures() after DeleteExpired() = %d, want 0", count)
+	}
+}
@@ -0,0 +1,102 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/config"
+)
+
+// failures is the count of an account's failed sign-ins
+type failures struct {
+	count int
+	last  time.Time
+}
+
+// MemoryStore keeps buckets and failed sign-ins in memory. Each instance has
+// its own, so it only suits a single instance.
+type MemoryStore struct {
+	mu       sync.Mutex
+	buckets  map[string]*Bucket
+	failures map[string]*failures
+}
+
+// NewMemoryStore creates a new MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets:  make(map[string]*Bucket),
+		failures: make(map[string]*failures),
+	}
+}
+
+// Take implements Store
+func (s *MemoryStore) Take(ctx context.Context, key string, limit config.RateLimit, now time.Time) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.buckets[key]
+	if !ok {
+		bucket := NewBucket(limit, now)
+		b = &bucket
+		s.buckets[key] = b
+	}
+
+	allowed, retryAfter := b.Take(limit, now)
+	return allowed, retryAfter, nil
+}
+
+// AddFailure implements Store
+func (s *MemoryStore) AddFailure(ctx context.Context, account string, since, now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, ok := s.failures[account]
+	if !ok || f.last.Before(since) {
+		f = &failures{}
+		s.failures[account] = f
+	}
+	f.count++
+	f.last = now
+
+	return f.count, nil
+}
+
+// Failures implements Store
+func (s *MemoryStore) Failures(ctx context.Context, account string, since time.Time) (int, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, ok := s.failures[account]
+	if !ok || f.last.Before(since) {
+		return 0, time.Time{}, nil
+	}
+	return f.count, f.last, nil
+}
+
+// ResetFailures implements Store
+func (s *MemoryStore) ResetFailures(ctx context.Context, account string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.failures, account)
+	return nil
+}
+
+// DeleteExpired implements Store
+func (s *MemoryStore) DeleteExpired(ctx context.Context, before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, b := range s.buckets {
+		if b.UpdatedAt.Before(before) {
+			delete(s.buckets, key)
+		}
+	}
+	for account, f := range s.failures {
+		if f.last.Before(before) {
+			delete(s.failures, account)
+		}
+	}
+	return nil
+}
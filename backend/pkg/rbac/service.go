@@ -0,0 +1,170 @@
+// Package rbac resolves the permissions users hold through their roles and
+// manages organizations' custom roles and users' role assignments.
+package rbac
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/config"
+	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/cstanislawski/qualifyd/pkg/repository"
+)
+
+// maxCached is how many users' permissions are cached before expired entries
+// are swept
+const maxCached = 10000
+
+// cached is a user's resolved permissions
+type cached struct {
+	role      string
+	grants    *model.Grants
+	expiresAt time.Time
+}
+
+// Service resolves users' permissions, caching them for a while, and
+// manages the roles they come from. Changes made through the service apply
+// right away on this instance.
+type Service struct {
+	repo *repository.RoleRepository
+	cfg  *config.RBACConfig
+	log  logger.Logger
+
+	mu    sync.Mutex
+	cache map[string]cached
+}
+
+// NewService creates a new Service
+func NewService(repo *repository.RoleRepository, cfg *config.RBACConfig, log logger.Logger) *Service {
+	return &Service{
+		repo:  repo,
+		cfg:   cfg,
+		log:   log,
+		cache: make(map[string]cached),
+	}
+}
+
+// Grants returns the permissions of a user whose own role is role
+func (s *Service) Grants(ctx context.Context, userID, role string) (*model.Grants, error) {
+	// Candidates and super admins hold no assigned roles
+	if role == model.RoleCandidate || role == model.RoleSuperAdmin {
+		return model.NewGrants(role, nil), nil
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	c, ok := s.cache[userID]
+	s.mu.Unlock()
+	// A user's own role is part of the token, so changes to it apply
+	// without waiting for the cache
+	if ok && c.role == role && now.Before(c.expiresAt) {
+		return c.grants, nil
+	}
+
+	assignments, err := s.repo.ListAssignments(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	grants := model.NewGrants(role, assignments)
+
+	s.mu.Lock()
+	if len(s.cache) >= maxCached {
+		for id, c := range s.cache {
+			if !now.Before(c.expiresAt) {
+				delete(s.cache, id)
+			}
+		}
+	}
+	s.cache[userID] = cached{role: role, grants: grants, expiresAt: now.Add(s.cfg.CacheTTL)}
+	s.mu.Unlock()
+	return grants, nil
+}
+
+// ListCustomRoles returns an organization's custom roles
+func (s *Service) ListCustomRoles(ctx context.Context, organizationID string) ([]*model.CustomRole, error) {
+	return s.repo.ListCustomRoles(ctx, organizationID)
+}
+
+// GetCustomRole returns one of an organization's custom roles
+func (s *Service) GetCustomRole(ctx context.Context, organizationID, id string) (*model.CustomRole, error) {
+	return s.repo.GetCustomRole(ctx, organizationID, id)
+}
+
+// CreateCustomRole creates a custom role
+func (s *Service) CreateCustomRole(ctx context.Context, role *model.CustomRole) error {
+	return s.repo.CreateCustomRole(ctx, role)
+}
+
+// UpdateCustomRole updates a custom role. Its holders get its new
+// permissions.
+func (s *Service) UpdateCustomRole(ctx context.Context, role *model.CustomRole) error {
+	if err := s.repo.UpdateCustomRole(ctx, role); err != nil {
+		return err
+	}
+	s.invalidateAll()
+	return nil
+}
+
+// DeleteCustomRole deletes a custom role, taking it from its holders
+func (s *Service) DeleteCustomRole(ctx context.Context, organizationID, id string) error {
+	if err := s.repo.DeleteCustomRole(ctx, organizationID, id); err != nil {
+		return err
+	}
+	s.invalidateAll()
+	return nil
+}
+
+// ListAssignments returns the roles assigned to a user
+func (s *Service) ListAssignments(ctx context.Context, userID string) ([]*model.RoleAssignment, error) {
+	return s.repo.ListAssignments(ctx, userID)
+}
+
+// Assign assigns a user a role
+func (s *Service) Assign(ctx context.Context, a *model.RoleAssignment) error {
+	if err := s.repo.CreateAssignment(ctx, a); err != nil {
+		return err
+	}
+	s.invalidate(a.UserID)
+
+	s.log.Info("Role assigned", map[string]interface{}{
+		"user_id":        a.UserID,
+		"role":           a.Role,
+		"custom_role_id": a.CustomRoleID,
+		"resource_type":  a.ResourceType,
+		"resource_id":    a.ResourceID,
+		"assigned_by":    a.CreatedBy,
+	})
+	return nil
+}
+
+// Unassign removes one of a user's role assignments
+func (s *Service) Unassign(ctx context.Context, userID, id, removedBy string) error {
+	if err := s.repo.DeleteAssignment(ctx, userID, id); err != nil {
+		return err
+	}
+	s.invalidate(userID)
+
+	s.log.Info("Role unassigned", map[string]interface{}{
+		"user_id":       userID,
+		"assignment_id": id,
+		"removed_by":    removedBy,
+	})
+	return nil
+}
+
+// invalidate drops a user's cached permissions
+func (s *Service) invalidate(userID string) {
+	s.mu.Lock()
+	delete(s.cache, userID)
+	s.mu.Unlock()
+}
+
+// invalidateAll drops every cached permission, for changes to roles many
+// users may hold
+func (s *Service) invalidateAll() {
+	s.mu.Lock()
+	s.cache = make(map[string]cached)
+	s.mu.Unlock()
+}
@@ -0,0 +1,151 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/database"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// apiKeyColumns is the column list shared by every api_keys query
+const apiKeyColumns = `
+	id, organization_id, name, prefix, key_hash, scopes, created_by::text,
+	expires_at, last_used_at, revoked_at, created_at
+`
+
+// APIKeyRepository handles database operations for organizations' API keys
+type APIKeyRepository struct {
+	db dbtx
+}
+
+// NewAPIKeyRepository creates a new APIKeyRepository instance
+func NewAPIKeyRepository(db *database.Database) *APIKeyRepository {
+	return &APIKeyRepository{
+		db: db,
+	}
+}
+
+// Create inserts an API key
+func (r *APIKeyRepository) Create(ctx context.Context, k *model.APIKey) error {
+	query := `
+		INSERT INTO api_keys (id, organization_id, name, prefix, key_hash, scopes, created_by, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`
+
+	if k.ID == "" {
+		k.ID = uuid.New().String()
+	}
+	k.CreatedAt = time.Now().UTC()
+
+	_, err := r.db.Exec(ctx, query,
+		k.ID,
+		k.OrganizationID,
+		k.Name,
+		k.Prefix,
+		k.KeyHash,
+		k.Scopes,
+		k.CreatedBy,
+		k.ExpiresAt,
+		k.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create API key: %w", err)
+	}
+
+	return nil
+}
+
+// GetByPrefix retrieves the API key issued with a prefix, revoked and
+// expired ones included
+func (r *APIKeyRepository) GetByPrefix(ctx context.Context, prefix string) (*model.APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE prefix = $1`
+
+	k, err := scanAPIKey(r.db.QueryRow(ctx, query, prefix))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, database.ErrRecordNotFound
+		}
+		return nil, fmt.Errorf("failed to get API key: %w", err)
+	}
+
+	return k, nil
+}
+
+// ListByOrganization retrieves an organization's API keys, newest first
+func (r *APIKeyRepository) ListByOrganization(ctx context.Context, organizationID string) ([]*model.APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE organization_id = $1 ORDER BY created_at DESC`
+
+	rows, err := r.db.Query(ctx, query, organizationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
+	}
+
+	return database.ScanRowsIntoSlice(ctx, rows, func(rows pgx.Rows) (*model.APIKey, error) {
+		return scanAPIKey(rows)
+	})
+}
+
+// Revoke revokes one of an organization's API keys. It returns
+// database.ErrRecordNotFound if the key does not exist or was already
+// revoked.
+func (r *APIKeyRepository) Revoke(ctx context.Context, organizationID, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return database.ErrRecordNotFound
+	}
+
+	tag, err := r.db.Exec(ctx, `
+		UPDATE api_keys SET revoked_at = $3
+		WHERE id = $1 AND organization_id = $2 AND revoked_at IS NULL
+	`, id, organizationID, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to revoke API key: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return database.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// TouchLastUsed records that a key was used at, unless its last use was
+// recorded less than interval before, so that busy keys do not write on
+// every request
+func (r *APIKeyRepository) TouchLastUsed(ctx context.Context, id string, at time.Time, interval time.Duration) error {
+	_, err := r.db.Exec(ctx, `
+		UPDATE api_keys SET last_used_at = $2
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < $3)
+	`, id, at, at.Add(-interval))
+	if err != nil {
+		return fmt.Errorf("failed to record API key use: %w", err)
+	}
+
+	return nil
+}
+
+// scanAPIKey scans a row selected with apiKeyColumns
+func scanAPIKey(row pgx.Row) (*model.APIKey, error) {
+	var k model.APIKey
+
+	err := row.Scan(
+		&k.ID,
+		&k.OrganizationID,
+		&k.Name,
+		&k.Prefix,
+		&k.KeyHash,
+		&k.Scopes,
+		&k.CreatedBy,
+		&k.ExpiresAt,
+		&k.LastUsedAt,
+		&k.RevokedAt,
+		&k.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &k, nil
+}
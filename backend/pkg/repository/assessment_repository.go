@@ -2,17 +2,24 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
+	"github.com/cstanislawski/qualifyd/pkg/events"
 	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-// AssessmentRepository handles database operations for assessments
+// AssessmentRepository handles database operations for assessments and
+// assessment templates. Every query is filtered by the organization the
+// repository is scoped to, see ForTenant. Assessments have no organization
+// column of their own and are scoped through their template.
 type AssessmentRepository struct {
-	db *pgxpool.Pool
+	db             dbtx
+	organizationID string
 }
 
 // NewAssessmentRepository creates a new assessment repository
@@ -22,8 +29,67 @@ func NewAssessmentRepository(db *pgxpool.Pool) *AssessmentRepository {
 	}
 }
 
-// Create inserts a new assessment
+// ForTenant returns a copy of the repository scoped to the organization bound to ctx
+func (r *AssessmentRepository) ForTenant(ctx context.Context) (*AssessmentRepository, error) {
+	organizationID, err := TenantFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.ForOrganization(organizationID), nil
+}
+
+// ForOrganization returns a copy of the repository scoped to the given organization
+func (r *AssessmentRepository) ForOrganization(organizationID string) *AssessmentRepository {
+	return &AssessmentRepository{
+		db:             r.db,
+		organizationID: organizationID,
+	}
+}
+
+// WithTx returns a copy of the repository that runs its queries inside tx
+func (r *AssessmentRepository) WithTx(tx pgx.Tx) *AssessmentRepository {
+	return &AssessmentRepository{
+		db:             tx,
+		organizationID: r.organizationID,
+	}
+}
+
+// tenant returns the organization the repository is scoped to
+func (r *AssessmentRepository) tenant() (string, error) {
+	if r.organizationID == "" {
+		return "", ErrTenantRequired
+	}
+	return r.organizationID, nil
+}
+
+// requireOwned returns notFound unless the row with the given ID in table
+// belongs to the repository's organization
+func (r *AssessmentRepository) requireOwned(ctx context.Context, q rowQuerier, table, id string, notFound error) error {
+	organizationID, err := r.tenant()
+	if err != nil {
+		return err
+	}
+	owned, err := ownedBy(ctx, q, table, id, organizationID)
+	if err != nil {
+		return err
+	}
+	if !owned {
+		return fmt.Errorf("%w: %s", notFound, id)
+	}
+	return nil
+}
+
+// Create inserts a new assessment and stores its assessment.created event.
+// Both the template and the candidate must belong to the repository's
+// organization.
 func (r *AssessmentRepository) Create(ctx context.Context, assessment *model.Assessment) error {
+	if err := r.requireOwned(ctx, r.db, "assessment_templates", assessment.AssessmentTemplateID, ErrAssessmentTemplateNotFound); err != nil {
+		return err
+	}
+	if err := r.requireOwned(ctx, r.db, "users", assessment.CandidateID, ErrCandidateNotFound); err != nil {
+		return err
+	}
+
 	query := `
 		INSERT INTO assessments (
 			assessment_template_id, candidate_id, status, scheduled_start_time,
@@ -38,33 +104,61 @@ func (r *AssessmentRepository) Create(ctx context.Context, assessment *model.Ass
 	assessment.CreatedAt = now
 	assessment.UpdatedAt = now
 
-	return r.db.QueryRow(ctx, query,
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx, query,
 		assessment.AssessmentTemplateID, assessment.CandidateID, assessment.Status, assessment.ScheduledStartTime,
 		assessment.ActualStartTime, assessment.CompletionTime, assessment.TotalScore, assessment.EnvironmentID,
 		assessment.Feedback, assessment.CreatedBy, assessment.CreatedAt, assessment.UpdatedAt,
 	).Scan(&assessment.ID)
+	if err != nil {
+		return err
+	}
+
+	err = addOutboxEvent(ctx, tx, r.organizationID, &events.AssessmentCreated{
+		AssessmentID:         assessment.ID,
+		AssessmentTemplateID: assessment.AssessmentTemplateID,
+		CandidateID:          assessment.CandidateID,
+		CreatedBy:            assessment.CreatedBy,
+		ScheduledStartTime:   assessment.ScheduledStartTime,
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
 }
 
 // GetByID retrieves an assessment by ID
 func (r *AssessmentRepository) GetByID(ctx context.Context, id string) (*model.Assessment, error) {
+	organizationID, err := r.tenant()
+	if err != nil {
+		return nil, err
+	}
+
 	query := `
 		SELECT
-			id, assessment_template_id, candidate_id, status, scheduled_start_time,
-			actual_start_time, completion_time, total_score, environment_id,
-			feedback, created_by, created_at, updated_at
-		FROM assessments
-		WHERE id = $1
+			a.id, a.assessment_template_id, a.candidate_id, a.status, a.scheduled_start_time,
+			a.actual_start_time, a.completion_time, a.total_score, a.environment_id,
+			a.feedback, a.created_by, a.created_at, a.updated_at
+		FROM assessments a
+		JOIN assessment_templates at ON a.assessment_template_id = at.id
+		WHERE a.id = $1 AND at.organization_id = $2
 	`
 
 	assessment := &model.Assessment{}
-	err := r.db.QueryRow(ctx, query, id).Scan(
+	err = r.db.QueryRow(ctx, query, id, organizationID).Scan(
 		&assessment.ID, &assessment.AssessmentTemplateID, &assessment.CandidateID, &assessment.Status, &assessment.ScheduledStartTime,
 		&assessment.ActualStartTime, &assessment.CompletionTime, &assessment.TotalScore, &assessment.EnvironmentID,
 		&assessment.Feedback, &assessment.CreatedBy, &assessment.CreatedAt, &assessment.UpdatedAt,
 	)
 	if err != nil {
-		if err == pgx.ErrNoRows {
-			return nil, fmt.Errorf("assessment not found: %s", id)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %s", ErrAssessmentNotFound, id)
 		}
 		return nil, err
 	}
@@ -72,10 +166,61 @@ func (r *AssessmentRepository) GetByID(ctx context.Context, id string) (*model.A
 	return assessment, nil
 }
 
-// Update updates an existing assessment
+// GetForTenant retrieves an assessment of the organization bound to ctx, see
+// ForTenant
+func (r *AssessmentRepository) GetForTenant(ctx context.Context, id string) (*model.Assessment, error) {
+	scoped, err := r.ForTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return scoped.GetByID(ctx, id)
+}
+
+// ResolveOrganization returns the organization and status of an assessment.
+// Unlike the other methods it does not require a tenant: it attributes
+// terminal sessions, which only pass the assessment ID on, to the
+// organization whose quota they consume.
+func (r *AssessmentRepository) ResolveOrganization(ctx context.Context, id string) (organizationID, status string, err error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return "", "", fmt.Errorf("%w: %s", ErrAssessmentNotFound, id)
+	}
+
+	query := `
+		SELECT at.organization_id, a.status
+		FROM assessments a
+		JOIN assessment_templates at ON a.assessment_template_id = at.id
+		WHERE a.id = $1
+	`
+
+	err = r.db.QueryRow(ctx, query, id).Scan(&organizationID, &status)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", "", fmt.Errorf("%w: %s", ErrAssessmentNotFound, id)
+		}
+		return "", "", fmt.Errorf("failed to resolve assessment organization: %w", err)
+	}
+
+	return organizationID, status, nil
+}
+
+// Update updates an existing assessment. If its status changed, the event
+// describing the transition is stored along with it, see
+// events.ForAssessmentStatus.
 func (r *AssessmentRepository) Update(ctx context.Context, assessment *model.Assessment) error {
+	organizationID, err := r.tenant()
+	if err != nil {
+		return err
+	}
+
 	query := `
-		UPDATE assessments
+		WITH previous AS (
+			SELECT a.id, a.status
+			FROM assessments a
+			JOIN assessment_templates at ON a.assessment_template_id = at.id
+			WHERE a.id = $9 AND at.organization_id = $10
+			FOR UPDATE OF a
+		)
+		UPDATE assessments a
 		SET
 			status = $1,
 			scheduled_start_time = $2,
@@ -85,50 +230,90 @@ func (r *AssessmentRepository) Update(ctx context.Context, assessment *model.Ass
 			environment_id = $6,
 			feedback = $7,
 			updated_at = $8
-		WHERE id = $9
+		FROM previous
+		WHERE a.id = previous.id
+		RETURNING previous.status
 	`
 
 	assessment.UpdatedAt = time.Now().UTC()
 
-	_, err := r.db.Exec(ctx, query,
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var previousStatus string
+	err = tx.QueryRow(ctx, query,
 		assessment.Status, assessment.ScheduledStartTime, assessment.ActualStartTime, assessment.CompletionTime,
 		assessment.TotalScore, assessment.EnvironmentID, assessment.Feedback, assessment.UpdatedAt, assessment.ID,
-	)
-	return err
+		organizationID,
+	).Scan(&previousStatus)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("%w: %s", ErrAssessmentNotFound, assessment.ID)
+		}
+		return err
+	}
+
+	if event := events.ForAssessmentStatus(previousStatus, assessment); event != nil {
+		if err := addOutboxEvent(ctx, tx, organizationID, event); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
 }
 
 // ListByCandidate lists all assessments for a candidate
 func (r *AssessmentRepository) ListByCandidate(ctx context.Context, candidateID string) ([]*model.Assessment, error) {
+	organizationID, err := r.tenant()
+	if err != nil {
+		return nil, err
+	}
+
 	query := `
 		SELECT
-			id, assessment_template_id, candidate_id, status, scheduled_start_time,
-			actual_start_time, completion_time, total_score, environment_id,
-			feedback, created_by, created_at, updated_at
-		FROM assessments
-		WHERE candidate_id = $1
-		ORDER BY scheduled_start_time DESC
+			a.id, a.assessment_template_id, a.candidate_id, a.status, a.scheduled_start_time,
+			a.actual_start_time, a.completion_time, a.total_score, a.environment_id,
+			a.feedback, a.created_by, a.created_at, a.updated_at
+		FROM assessments a
+		JOIN assessment_templates at ON a.assessment_template_id = at.id
+		WHERE a.candidate_id = $1 AND at.organization_id = $2
+		ORDER BY a.scheduled_start_time DESC
 	`
 
-	return r.queryAssessments(ctx, query, candidateID)
+	return r.queryAssessments(ctx, query, candidateID, organizationID)
 }
 
 // ListByTemplate lists all assessments for a template
 func (r *AssessmentRepository) ListByTemplate(ctx context.Context, templateID string) ([]*model.Assessment, error) {
+	organizationID, err := r.tenant()
+	if err != nil {
+		return nil, err
+	}
+
 	query := `
 		SELECT
-			id, assessment_template_id, candidate_id, status, scheduled_start_time,
-			actual_start_time, completion_time, total_score, environment_id,
-			feedback, created_by, created_at, updated_at
-		FROM assessments
-		WHERE assessment_template_id = $1
-		ORDER BY scheduled_start_time DESC
+			a.id, a.assessment_template_id, a.candidate_id, a.status, a.scheduled_start_time,
+			a.actual_start_time, a.completion_time, a.total_score, a.environment_id,
+			a.feedback, a.created_by, a.created_at, a.updated_at
+		FROM assessments a
+		JOIN assessment_templates at ON a.assessment_template_id = at.id
+		WHERE a.assessment_template_id = $1 AND at.organization_id = $2
+		ORDER BY a.scheduled_start_time DESC
 	`
 
-	return r.queryAssessments(ctx, query, templateID)
+	return r.queryAssessments(ctx, query, templateID, organizationID)
 }
 
-// ListActiveByOrganization lists all active assessments for an organization
-func (r *AssessmentRepository) ListActiveByOrganization(ctx context.Context, organizationID string) ([]*model.Assessment, error) {
+// ListActive lists all active assessments of the repository's organization
+func (r *AssessmentRepository) ListActive(ctx context.Context) ([]*model.Assessment, error) {
+	organizationID, err := r.tenant()
+	if err != nil {
+		return nil, err
+	}
+
 	query := `
 		SELECT
 			a.id, a.assessment_template_id, a.candidate_id, a.status, a.scheduled_start_time,
@@ -143,6 +328,122 @@ func (r *AssessmentRepository) ListActiveByOrganization(ctx context.Context, org
 	return r.queryAssessments(ctx, query, organizationID)
 }
 
+// ListStartingBetween lists the scheduled assessments of every organization
+// whose scheduled start time is in [from, to), with their template's ID,
+// organization and name. Like ResolveOrganization it does not require a
+// tenant; it serves the reminder job.
+func (r *AssessmentRepository) ListStartingBetween(ctx context.Context, from, to time.Time) ([]*model.Assessment, error) {
+	query := `
+		SELECT
+			a.id, a.assessment_template_id, a.candidate_id, a.status, a.scheduled_start_time,
+			a.actual_start_time, a.completion_time, a.total_score, a.environment_id,
+			a.feedback, a.created_by, a.created_at, a.updated_at,
+			at.organization_id, at.name
+		FROM assessments a
+		JOIN assessment_templates at ON a.assessment_template_id = at.id
+		WHERE a.status = 'scheduled' AND a.scheduled_start_time >= $1 AND a.scheduled_start_time < $2
+		ORDER BY a.scheduled_start_time
+	`
+
+	rows, err := r.db.Query(ctx, query, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list upcoming assessments: %w", err)
+	}
+	defer rows.Close()
+
+	assessments := []*model.Assessment{}
+	for rows.Next() {
+		assessment := &model.Assessment{Template: &model.AssessmentTemplate{}}
+		err := rows.Scan(
+			&assessment.ID, &assessment.AssessmentTemplateID, &assessment.CandidateID, &assessment.Status, &assessment.ScheduledStartTime,
+			&assessment.ActualStartTime, &assessment.CompletionTime, &assessment.TotalScore, &assessment.EnvironmentID,
+			&assessment.Feedback, &assessment.CreatedBy, &assessment.CreatedAt, &assessment.UpdatedAt,
+			&assessment.Template.OrganizationID, &assessment.Template.Name,
+		)
+		if err != nil {
+			return nil, err
+		}
+		assessment.Template.ID = assessment.AssessmentTemplateID
+		assessments = append(assessments, assessment)
+	}
+
+	return assessments, rows.Err()
+}
+
+// AssignReviewer assigns a reviewer to an assessment and stores its
+// review.assigned event. The reviewer must be an admin or reviewer of the
+// repository's organization. It returns false if the reviewer was already
+// assigned.
+func (r *AssessmentRepository) AssignReviewer(ctx context.Context, assessmentID, reviewerID, assignedBy string) (bool, error) {
+	organizationID, err := r.tenant()
+	if err != nil {
+		return false, err
+	}
+	if _, err := uuid.Parse(assessmentID); err != nil {
+		return false, fmt.Errorf("%w: %s", ErrAssessmentNotFound, assessmentID)
+	}
+	if _, err := uuid.Parse(reviewerID); err != nil {
+		return false, fmt.Errorf("%w: %s", ErrReviewerNotFound, reviewerID)
+	}
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var exists bool
+	err = tx.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM assessments a
+			JOIN assessment_templates at ON a.assessment_template_id = at.id
+			WHERE a.id = $1 AND at.organization_id = $2
+		)
+	`, assessmentID, organizationID).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+	if !exists {
+		return false, fmt.Errorf("%w: %s", ErrAssessmentNotFound, assessmentID)
+	}
+
+	err = tx.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM users
+			WHERE id = $1 AND organization_id = $2 AND role IN ('admin', 'reviewer') AND status = 'active'
+		)
+	`, reviewerID, organizationID).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+	if !exists {
+		return false, fmt.Errorf("%w: %s", ErrReviewerNotFound, reviewerID)
+	}
+
+	tag, err := tx.Exec(ctx, `
+		INSERT INTO assessment_reviewers (assessment_id, reviewer_id, assigned_by, assigned_at)
+		VALUES ($1, $2, NULLIF($3, '')::uuid, $4)
+		ON CONFLICT (assessment_id, reviewer_id) DO NOTHING
+	`, assessmentID, reviewerID, assignedBy, time.Now().UTC())
+	if err != nil {
+		return false, err
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+
+	err = addOutboxEvent(ctx, tx, organizationID, &events.ReviewerAssigned{
+		AssessmentID: assessmentID,
+		ReviewerID:   reviewerID,
+		AssignedBy:   assignedBy,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit(ctx)
+}
+
 // queryAssessments is a helper function for running assessment queries
 func (r *AssessmentRepository) queryAssessments(ctx context.Context, query string, args ...interface{}) ([]*model.Assessment, error) {
 	rows, err := r.db.Query(ctx, query, args...)
@@ -186,11 +487,11 @@ func (r *AssessmentRepository) GetAssessmentWithTasksAndTemplate(ctx context.Con
 			id, organization_id, name, description, environment_template_id,
 			total_time_limit, passing_score, internet_access, created_by, created_at, updated_at
 		FROM assessment_templates
-		WHERE id = $1
+		WHERE id = $1 AND organization_id = $2
 	`
 
 	template := &model.AssessmentTemplate{}
-	err = r.db.QueryRow(ctx, query, assessment.AssessmentTemplateID).Scan(
+	err = r.db.QueryRow(ctx, query, assessment.AssessmentTemplateID, r.organizationID).Scan(
 		&template.ID, &template.OrganizationID, &template.Name, &template.Description, &template.EnvironmentTemplateID,
 		&template.TotalTimeLimit, &template.PassingScore, &template.InternetAccess, &template.CreatedBy, &template.CreatedAt, &template.UpdatedAt,
 	)
@@ -267,12 +568,34 @@ func (r *AssessmentRepository) GetAssessmentWithTasksAndTemplate(ctx context.Con
 
 // CreateAssessmentTasks creates tasks for an assessment based on a template
 func (r *AssessmentRepository) CreateAssessmentTasks(ctx context.Context, assessmentID string, templateID string) error {
+	organizationID, err := r.tenant()
+	if err != nil {
+		return err
+	}
+
 	tx, err := r.db.Begin(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
 	defer tx.Rollback(ctx)
 
+	// Make sure the assessment was created from this template within the organization
+	var owned bool
+	ownedQuery := `
+		SELECT EXISTS (
+			SELECT 1
+			FROM assessments a
+			JOIN assessment_templates at ON a.assessment_template_id = at.id
+			WHERE a.id = $1 AND at.id = $2 AND at.organization_id = $3
+		)
+	`
+	if err := tx.QueryRow(ctx, ownedQuery, assessmentID, templateID, organizationID).Scan(&owned); err != nil {
+		return fmt.Errorf("failed to check assessment ownership: %w", err)
+	}
+	if !owned {
+		return fmt.Errorf("%w: %s", ErrAssessmentNotFound, assessmentID)
+	}
+
 	// Get tasks from the template
 	query := `
 		SELECT task_template_id, order_index, dependencies
@@ -322,8 +645,17 @@ func (r *AssessmentRepository) CreateAssessmentTasks(ctx context.Context, assess
 	return nil
 }
 
-// CreateTemplate inserts a new assessment template
+// CreateTemplate inserts a new assessment template into the repository's
+// organization. The environment template must belong to the same organization.
 func (r *AssessmentRepository) CreateTemplate(ctx context.Context, template *model.AssessmentTemplate) error {
+	organizationID, err := r.tenant()
+	if err != nil {
+		return err
+	}
+	if err := r.requireOwned(ctx, r.db, "environment_templates", template.EnvironmentTemplateID, ErrEnvironmentTemplateNotFound); err != nil {
+		return err
+	}
+
 	query := `
 		INSERT INTO assessment_templates (
 			organization_id, name, description, environment_template_id,
@@ -334,6 +666,7 @@ func (r *AssessmentRepository) CreateTemplate(ctx context.Context, template *mod
 	`
 
 	now := time.Now().UTC()
+	template.OrganizationID = organizationID
 	template.CreatedAt = now
 	template.UpdatedAt = now
 
@@ -344,8 +677,16 @@ func (r *AssessmentRepository) CreateTemplate(ctx context.Context, template *mod
 	).Scan(&template.ID)
 }
 
-// AddTaskToTemplate adds a task to an assessment template
+// AddTaskToTemplate adds a task to an assessment template. Both must belong
+// to the repository's organization.
 func (r *AssessmentRepository) AddTaskToTemplate(ctx context.Context, templateID, taskID string, orderIndex int, weight float64) error {
+	if err := r.requireOwned(ctx, r.db, "assessment_templates", templateID, ErrAssessmentTemplateNotFound); err != nil {
+		return err
+	}
+	if err := r.requireOwned(ctx, r.db, "task_templates", taskID, ErrTaskTemplateNotFound); err != nil {
+		return err
+	}
+
 	query := `
 		INSERT INTO assessment_template_tasks (
 			assessment_template_id, task_template_id, order_index, weight
@@ -359,22 +700,27 @@ func (r *AssessmentRepository) AddTaskToTemplate(ctx context.Context, templateID
 
 // GetTemplateByID retrieves an assessment template by ID
 func (r *AssessmentRepository) GetTemplateByID(ctx context.Context, id string) (*model.AssessmentTemplate, error) {
+	organizationID, err := r.tenant()
+	if err != nil {
+		return nil, err
+	}
+
 	query := `
 		SELECT
 			id, organization_id, name, description, environment_template_id,
 			total_time_limit, passing_score, internet_access, created_by, created_at, updated_at
 		FROM assessment_templates
-		WHERE id = $1
+		WHERE id = $1 AND organization_id = $2
 	`
 
 	template := &model.AssessmentTemplate{}
-	err := r.db.QueryRow(ctx, query, id).Scan(
+	err = r.db.QueryRow(ctx, query, id, organizationID).Scan(
 		&template.ID, &template.OrganizationID, &template.Name, &template.Description, &template.EnvironmentTemplateID,
 		&template.TotalTimeLimit, &template.PassingScore, &template.InternetAccess, &template.CreatedBy, &template.CreatedAt, &template.UpdatedAt,
 	)
 	if err != nil {
-		if err == pgx.ErrNoRows {
-			return nil, fmt.Errorf("assessment template not found: %s", id)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %s", ErrAssessmentTemplateNotFound, id)
 		}
 		return nil, err
 	}
@@ -407,6 +753,11 @@ func (r *AssessmentRepository) GetTemplateByID(ctx context.Context, id string) (
 
 // GetTemplateTasks retrieves all tasks for an assessment template
 func (r *AssessmentRepository) GetTemplateTasks(ctx context.Context, templateID string) ([]*model.TaskTemplate, error) {
+	organizationID, err := r.tenant()
+	if err != nil {
+		return nil, err
+	}
+
 	query := `
 		SELECT
 			tt.id, tt.organization_id, tt.name, tt.description, tt.instructions,
@@ -414,11 +765,12 @@ func (r *AssessmentRepository) GetTemplateTasks(ctx context.Context, templateID
 			tt.environment_setup_script, tt.created_by, tt.created_at, tt.updated_at
 		FROM task_templates tt
 		JOIN assessment_template_tasks att ON tt.id = att.task_template_id
-		WHERE att.assessment_template_id = $1
+		JOIN assessment_templates at ON att.assessment_template_id = at.id
+		WHERE att.assessment_template_id = $1 AND at.organization_id = $2
 		ORDER BY att.order_index
 	`
 
-	rows, err := r.db.Query(ctx, query, templateID)
+	rows, err := r.db.Query(ctx, query, templateID, organizationID)
 	if err != nil {
 		return nil, err
 	}
@@ -447,6 +799,14 @@ func (r *AssessmentRepository) GetTemplateTasks(ctx context.Context, templateID
 
 // UpdateTemplate updates an existing assessment template
 func (r *AssessmentRepository) UpdateTemplate(ctx context.Context, template *model.AssessmentTemplate) error {
+	organizationID, err := r.tenant()
+	if err != nil {
+		return err
+	}
+	if err := r.requireOwned(ctx, r.db, "environment_templates", template.EnvironmentTemplateID, ErrEnvironmentTemplateNotFound); err != nil {
+		return err
+	}
+
 	query := `
 		UPDATE assessment_templates
 		SET
@@ -457,36 +817,63 @@ func (r *AssessmentRepository) UpdateTemplate(ctx context.Context, template *mod
 			passing_score = $5,
 			internet_access = $6,
 			updated_at = $7
-		WHERE id = $8
+		WHERE id = $8 AND organization_id = $9
 	`
 
 	template.UpdatedAt = time.Now().UTC()
 
-	_, err := r.db.Exec(ctx, query,
+	tag, err := r.db.Exec(ctx, query,
 		template.Name, template.Description, template.EnvironmentTemplateID,
 		template.TotalTimeLimit, template.PassingScore, template.InternetAccess,
-		template.UpdatedAt, template.ID,
+		template.UpdatedAt, template.ID, organizationID,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%w: %s", ErrAssessmentTemplateNotFound, template.ID)
+	}
+	return nil
 }
 
 // DeleteTemplate deletes an assessment template
 func (r *AssessmentRepository) DeleteTemplate(ctx context.Context, id string) error {
+	organizationID, err := r.tenant()
+	if err != nil {
+		return err
+	}
+
 	// Delete the tasks association first
-	taskQuery := `DELETE FROM assessment_template_tasks WHERE assessment_template_id = $1`
-	_, err := r.db.Exec(ctx, taskQuery, id)
+	taskQuery := `
+		DELETE FROM assessment_template_tasks
+		WHERE assessment_template_id IN (
+			SELECT id FROM assessment_templates WHERE id = $1 AND organization_id = $2
+		)
+	`
+	_, err = r.db.Exec(ctx, taskQuery, id, organizationID)
 	if err != nil {
 		return err
 	}
 
 	// Then delete the template
-	query := `DELETE FROM assessment_templates WHERE id = $1`
-	_, err = r.db.Exec(ctx, query, id)
-	return err
+	query := `DELETE FROM assessment_templates WHERE id = $1 AND organization_id = $2`
+	tag, err := r.db.Exec(ctx, query, id, organizationID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%w: %s", ErrAssessmentTemplateNotFound, id)
+	}
+	return nil
 }
 
-// ListTemplatesByOrganization lists all assessment templates for an organization
-func (r *AssessmentRepository) ListTemplatesByOrganization(ctx context.Context, organizationID string) ([]*model.AssessmentTemplate, error) {
+// ListTemplates lists all assessment templates of the repository's organization
+func (r *AssessmentRepository) ListTemplates(ctx context.Context) ([]*model.AssessmentTemplate, error) {
+	organizationID, err := r.tenant()
+	if err != nil {
+		return nil, err
+	}
+
 	query := `
 		SELECT
 			id, organization_id, name, description, environment_template_id,
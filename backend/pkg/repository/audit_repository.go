@@ -0,0 +1,246 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/database"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// auditEventColumns is the column list shared by every audit_events query
+const auditEventColumns = `
+	sequence, id, COALESCE(organization_id::text, ''), actor_type, COALESCE(actor_id, ''), action,
+	COALESCE(target_type, ''), COALESCE(target_id, ''), COALESCE(ip_address, ''), COALESCE(user_agent, ''),
+	diff, occurred_at, prev_hash, hash
+`
+
+// AuditRepository handles database operations for the audit log. Events are
+// only ever appended.
+type AuditRepository struct {
+	db dbtx
+}
+
+// NewAuditRepository creates a new AuditRepository instance
+func NewAuditRepository(db *database.Database) *AuditRepository {
+	return &AuditRepository{
+		db: db,
+	}
+}
+
+// WithTx returns a copy of the repository that runs its queries inside tx,
+// so that an event is only recorded if the change it describes is
+func (r *AuditRepository) WithTx(tx pgx.Tx) *AuditRepository {
+	return &AuditRepository{
+		db: tx,
+	}
+}
+
+// Append records an event at the end of its organization's hash chain,
+// setting its ID, time, sequence and hashes
+func (r *AuditRepository) Append(ctx context.Context, e *model.AuditEvent) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Appends to the same chain take turns, so that each links to the one
+	// before it
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext('audit_events:' || $1::text))`, e.OrganizationID); err != nil {
+		return fmt.Errorf("failed to lock audit chain: %w", err)
+	}
+
+	prevHash := model.AuditGenesisHash
+	err = tx.QueryRow(ctx, `
+		SELECT hash FROM audit_events
+		WHERE organization_id IS NOT DISTINCT FROM NULLIF($1, '')::uuid
+		ORDER BY sequence DESC
+		LIMIT 1
+	`, e.OrganizationID).Scan(&prevHash)
+	if err != nil && err != pgx.ErrNoRows {
+		return fmt.Errorf("failed to get last audit event: %w", err)
+	}
+
+	if e.ID == "" {
+		e.ID = uuid.New().String()
+	}
+	// Stored times have microsecond precision, and the hash has to match
+	// what is read back
+	e.OccurredAt = time.Now().UTC().Truncate(time.Microsecond)
+	e.PrevHash = prevHash
+	e.Hash = e.ComputeHash(prevHash)
+
+	err = tx.QueryRow(ctx, `
+		INSERT INTO audit_events (id, organization_id, actor_type, actor_id, action, target_type, target_id,
+		                          ip_address, user_agent, diff, occurred_at, prev_hash, hash)
+		VALUES ($1, NULLIF($2, '')::uuid, $3, NULLIF($4, ''), $5, NULLIF($6, ''), NULLIF($7, ''),
+		        NULLIF($8, ''), NULLIF($9, ''), $10, $11, $12, $13)
+		RETURNING sequence
+	`,
+		e.ID,
+		e.OrganizationID,
+		e.ActorType,
+		e.ActorID,
+		e.Action,
+		e.TargetType,
+		e.TargetID,
+		e.IPAddress,
+		e.UserAgent,
+		e.Diff,
+		e.OccurredAt,
+		e.PrevHash,
+		e.Hash,
+	).Scan(&e.Sequence)
+	if err != nil {
+		return fmt.Errorf("failed to create audit event: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit audit event: %w", err)
+	}
+
+	return nil
+}
+
+// List retrieves the events matching filter, newest first
+func (r *AuditRepository) List(ctx context.Context, filter model.AuditFilter, params database.PaginationParams) (database.PaginatedResponse, error) {
+	where, args := auditFilterWhere(filter)
+
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM audit_events
+		WHERE %s
+		ORDER BY sequence DESC
+		LIMIT $%d OFFSET $%d
+	`, auditEventColumns, where, len(args)+1, len(args)+2)
+
+	rows, err := r.db.Query(ctx, query, append(args, params.Limit(), params.Offset())...)
+	if err != nil {
+		return database.PaginatedResponse{}, fmt.Errorf("failed to list audit events: %w", err)
+	}
+
+	auditEvents, err := database.ScanRowsIntoSlice(ctx, rows, func(rows pgx.Rows) (*model.AuditEvent, error) {
+		return scanAuditEvent(rows)
+	})
+	if err != nil {
+		return database.PaginatedResponse{}, fmt.Errorf("failed to scan audit events: %w", err)
+	}
+
+	var count int
+	if err := r.db.QueryRow(ctx, `SELECT COUNT(*) FROM audit_events WHERE `+where, args...).Scan(&count); err != nil {
+		return database.PaginatedResponse{}, fmt.Errorf("failed to count audit events: %w", err)
+	}
+
+	return database.NewPaginatedResponse(auditEvents, params, count), nil
+}
+
+// Each calls fn with every event matching filter, oldest first, without
+// loading them all at once
+func (r *AuditRepository) Each(ctx context.Context, filter model.AuditFilter, fn func(e *model.AuditEvent) error) error {
+	where, args := auditFilterWhere(filter)
+	return r.each(ctx, `SELECT `+auditEventColumns+` FROM audit_events WHERE `+where+` ORDER BY sequence`, args, fn)
+}
+
+// EachInChain calls fn with the events of an organization's hash chain in
+// order, or of the platform's chain if organizationID is empty
+func (r *AuditRepository) EachInChain(ctx context.Context, organizationID string, fn func(e *model.AuditEvent) error) error {
+	query := `
+		SELECT ` + auditEventColumns + `
+		FROM audit_events
+		WHERE organization_id IS NOT DISTINCT FROM NULLIF($1, '')::uuid
+		ORDER BY sequence
+	`
+	return r.each(ctx, query, []interface{}{organizationID}, fn)
+}
+
+// each calls fn with every event query selects
+func (r *AuditRepository) each(ctx context.Context, query string, args []interface{}, fn func(e *model.AuditEvent) error) error {
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to list audit events: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e, err := scanAuditEvent(rows)
+		if err != nil {
+			return fmt.Errorf("failed to scan audit event: %w", err)
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to list audit events: %w", err)
+	}
+	return nil
+}
+
+// auditFilterWhere returns the WHERE clause and arguments selecting the
+// events matching filter
+func auditFilterWhere(filter model.AuditFilter) (string, []interface{}) {
+	where := `TRUE`
+	var args []interface{}
+	add := func(condition string, value interface{}) {
+		args = append(args, value)
+		where += fmt.Sprintf(` AND `+condition, len(args))
+	}
+
+	if filter.OrganizationID != "" {
+		add(`organization_id = $%d::uuid`, filter.OrganizationID)
+	}
+	if filter.ActorID != "" {
+		add(`actor_id = $%d`, filter.ActorID)
+	}
+	if filter.Action != "" {
+		add(`action = $%d`, filter.Action)
+	}
+	if filter.TargetType != "" {
+		add(`target_type = $%d`, filter.TargetType)
+	}
+	if filter.TargetID != "" {
+		add(`target_id = $%d`, filter.TargetID)
+	}
+	if filter.From != nil {
+		add(`occurred_at >= $%d`, *filter.From)
+	}
+	if filter.To != nil {
+		add(`occurred_at < $%d`, *filter.To)
+	}
+
+	return where, args
+}
+
+// scanAuditEvent scans a row selected with auditEventColumns
+func scanAuditEvent(row pgx.Row) (*model.AuditEvent, error) {
+	var e model.AuditEvent
+	var diff []byte
+
+	err := row.Scan(
+		&e.Sequence,
+		&e.ID,
+		&e.OrganizationID,
+		&e.ActorType,
+		&e.ActorID,
+		&e.Action,
+		&e.TargetType,
+		&e.TargetID,
+		&e.IPAddress,
+		&e.UserAgent,
+		&diff,
+		&e.OccurredAt,
+		&e.PrevHash,
+		&e.Hash,
+	)
+	if err != nil {
+		return nil, err
+	}
+	e.Diff = diff
+
+	return &e, nil
+}
@@ -0,0 +1,325 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/database"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// billingRecordColumns is the column list shared by every billing_records query
+const billingRecordColumns = `
+	id, organization_id, amount, currency, COALESCE(transaction_id, ''), COALESCE(payment_method, ''),
+	status, COALESCE(description, ''), COALESCE(invoice_url, ''), COALESCE(receipt_url, ''),
+	payment_date, billing_period_start, billing_period_end, COALESCE(failure_reason, ''),
+	COALESCE(idempotency_key, ''), created_at, COALESCE(updated_at, created_at)
+`
+
+// BillingRepository handles database operations for billing records
+type BillingRepository struct {
+	db dbtx
+}
+
+// NewBillingRepository creates a new BillingRepository instance
+func NewBillingRepository(db *database.Database) *BillingRepository {
+	return &BillingRepository{
+		db: db,
+	}
+}
+
+// WithTx returns a copy of the repository that runs its queries inside tx
+func (r *BillingRepository) WithTx(tx pgx.Tx) *BillingRepository {
+	return &BillingRepository{
+		db: tx,
+	}
+}
+
+// Create inserts a billing record. If a record with the same idempotency key
+// already exists, that record is returned instead and created is false.
+func (r *BillingRepository) Create(ctx context.Context, record *model.BillingRecord) (_ *model.BillingRecord, created bool, err error) {
+	query := `
+		INSERT INTO billing_records (id, organization_id, amount, currency, transaction_id, payment_method, status,
+		                             description, invoice_url, receipt_url, payment_date, billing_period_start,
+		                             billing_period_end, failure_reason, idempotency_key, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), $7, NULLIF($8, ''), NULLIF($9, ''), NULLIF($10, ''),
+		        $11, $12, $13, NULLIF($14, ''), NULLIF($15, ''), $16, $17)
+		ON CONFLICT (idempotency_key) WHERE idempotency_key IS NOT NULL DO NOTHING
+	`
+
+	if record.ID == "" {
+		record.ID = uuid.New().String()
+	}
+
+	tag, err := r.db.Exec(ctx, query,
+		record.ID,
+		record.OrganizationID,
+		record.Amount,
+		record.Currency,
+		record.TransactionID,
+		record.PaymentMethod,
+		record.Status,
+		record.Description,
+		record.InvoiceURL,
+		record.ReceiptURL,
+		nullTime(record.PaymentDate),
+		nullTime(record.BillingPeriodStart),
+		nullTime(record.BillingPeriodEnd),
+		record.FailureReason,
+		record.IdempotencyKey,
+		record.CreatedAt,
+		record.UpdatedAt,
+	)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create billing record: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		existing, err := r.GetByIdempotencyKey(ctx, record.IdempotencyKey)
+		return existing, false, err
+	}
+
+	return record, true, nil
+}
+
+// GetByID retrieves a billing record by ID
+func (r *BillingRepository) GetByID(ctx context.Context, id string) (*model.BillingRecord, error) {
+	return r.getBy(ctx, "id", id)
+}
+
+// GetByTransactionID retrieves a billing record by its payment provider transaction ID
+func (r *BillingRepository) GetByTransactionID(ctx context.Context, transactionID string) (*model.BillingRecord, error) {
+	return r.getBy(ctx, "transaction_id", transactionID)
+}
+
+// GetByIdempotencyKey retrieves a billing record by its idempotency key
+func (r *BillingRepository) GetByIdempotencyKey(ctx context.Context, key string) (*model.BillingRecord, error) {
+	return r.getBy(ctx, "idempotency_key", key)
+}
+
+// getBy retrieves a billing record by a unique column
+func (r *BillingRepository) getBy(ctx context.Context, column, value string) (*model.BillingRecord, error) {
+	query := `SELECT ` + billingRecordColumns + ` FROM billing_records WHERE ` + column + ` = $1`
+	if column == "id" {
+		if _, err := uuid.Parse(value); err != nil {
+			return nil, database.ErrRecordNotFound
+		}
+	}
+
+	record, err := scanBillingRecord(r.db.QueryRow(ctx, query, value))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, database.ErrRecordNotFound
+		}
+		return nil, fmt.Errorf("failed to get billing record: %w", err)
+	}
+
+	return record, nil
+}
+
+// SetTransactionID records the payment provider's ID for a charge
+func (r *BillingRepository) SetTransactionID(ctx context.Context, id, transactionID string) error {
+	query := `UPDATE billing_records SET transaction_id = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $1`
+
+	if _, err := r.db.Exec(ctx, query, id, transactionID); err != nil {
+		return fmt.Errorf("failed to set billing record transaction: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateStatus moves a billing record from record.Status to the status set on
+// update. It returns model.ErrInvalidBillingTransition if the transition is
+// not allowed, and false if the record's status changed since it was read.
+func (r *BillingRepository) UpdateStatus(ctx context.Context, record *model.BillingRecord, update *model.BillingRecord) (bool, error) {
+	if !record.CanTransitionTo(update.Status) {
+		return false, fmt.Errorf("%w: %s to %s", model.ErrInvalidBillingTransition, record.Status, update.Status)
+	}
+
+	query := `
+		UPDATE billing_records
+		SET status = $3,
+		    payment_date = COALESCE($4, payment_date),
+		    receipt_url = COALESCE(NULLIF($5, ''), receipt_url),
+		    failure_reason = NULLIF($6, ''),
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND status = $2
+	`
+
+	tag, err := r.db.Exec(ctx, query,
+		record.ID,
+		record.Status,
+		update.Status,
+		nullTime(update.PaymentDate),
+		update.ReceiptURL,
+		update.FailureReason,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to update billing record status: %w", err)
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+// SetReceiptURL records the receipt of a charge
+func (r *BillingRepository) SetReceiptURL(ctx context.Context, id, receiptURL string) error {
+	query := `UPDATE billing_records SET receipt_url = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $1`
+
+	if _, err := r.db.Exec(ctx, query, id, receiptURL); err != nil {
+		return fmt.Errorf("failed to set billing record receipt: %w", err)
+	}
+
+	return nil
+}
+
+// ListByOrganization retrieves a page of an organization's billing records, newest first
+func (r *BillingRepository) ListByOrganization(ctx context.Context, organizationID string, params database.PaginationParams) (database.PaginatedResponse, error) {
+	query := `
+		SELECT ` + billingRecordColumns + `
+		FROM billing_records
+		WHERE organization_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2 OFFSET $3
+	`
+
+	rows, err := r.db.Query(ctx, query, organizationID, params.Limit(), params.Offset())
+	if err != nil {
+		return database.PaginatedResponse{}, fmt.Errorf("failed to list billing records: %w", err)
+	}
+
+	records, err := database.ScanRowsIntoSlice(ctx, rows, func(rows pgx.Rows) (*model.BillingRecord, error) {
+		return scanBillingRecord(rows)
+	})
+	if err != nil {
+		return database.PaginatedResponse{}, fmt.Errorf("failed to scan billing records: %w", err)
+	}
+
+	var count int
+	if err := r.db.QueryRow(ctx, `SELECT COUNT(*) FROM billing_records WHERE organization_id = $1`, organizationID).Scan(&count); err != nil {
+		return database.PaginatedResponse{}, fmt.Errorf("failed to count billing records: %w", err)
+	}
+
+	return database.NewPaginatedResponse(records, params, count), nil
+}
+
+// ListByPeriod retrieves an organization's billing records for a billing
+// period, i.e. those whose period starts within [periodStart, periodEnd), oldest first
+func (r *BillingRepository) ListByPeriod(ctx context.Context, organizationID string, periodStart, periodEnd time.Time) ([]*model.BillingRecord, error) {
+	query := `
+		SELECT ` + billingRecordColumns + `
+		FROM billing_records
+		WHERE organization_id = $1 AND billing_period_start >= $2 AND billing_period_start < $3
+		ORDER BY billing_period_start, created_at
+	`
+
+	rows, err := r.db.Query(ctx, query, organizationID, periodStart, periodEnd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list billing records for period: %w", err)
+	}
+
+	return database.ScanRowsIntoSlice(ctx, rows, func(rows pgx.Rows) (*model.BillingRecord, error) {
+		return scanBillingRecord(rows)
+	})
+}
+
+// SetInvoiceURL links the billing records of a billing period to their statement
+func (r *BillingRepository) SetInvoiceURL(ctx context.Context, organizationID string, periodStart, periodEnd time.Time, invoiceURL string) error {
+	query := `
+		UPDATE billing_records
+		SET invoice_url = $4, updated_at = CURRENT_TIMESTAMP
+		WHERE organization_id = $1 AND billing_period_start >= $2 AND billing_period_start < $3
+	`
+
+	if _, err := r.db.Exec(ctx, query, organizationID, periodStart, periodEnd, invoiceURL); err != nil {
+		return fmt.Errorf("failed to set invoice URL: %w", err)
+	}
+
+	return nil
+}
+
+// ClaimWebhookEvent records that a payment provider webhook event is being
+// processed. It returns false if it was already claimed, so that providers
+// retrying a delivery do not apply the same event twice.
+func (r *BillingRepository) ClaimWebhookEvent(ctx context.Context, provider, eventID, eventType string) (bool, error) {
+	query := `
+		INSERT INTO billing_webhook_events (id, provider, type)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (id) DO NOTHING
+	`
+
+	tag, err := r.db.Exec(ctx, query, webhookEventKey(provider, eventID), provider, eventType)
+	if err != nil {
+		return false, fmt.Errorf("failed to claim webhook event: %w", err)
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+// ReleaseWebhookEvent removes a claim made by ClaimWebhookEvent so that an
+// event that could not be processed is applied when the provider retries it
+func (r *BillingRepository) ReleaseWebhookEvent(ctx context.Context, provider, eventID string) error {
+	query := `DELETE FROM billing_webhook_events WHERE id = $1`
+
+	if _, err := r.db.Exec(ctx, query, webhookEventKey(provider, eventID)); err != nil {
+		return fmt.Errorf("failed to release webhook event: %w", err)
+	}
+
+	return nil
+}
+
+// webhookEventKey namespaces event IDs by provider
+func webhookEventKey(provider, eventID string) string {
+	return provider + ":" + eventID
+}
+
+// scanBillingRecord scans a row selected with billingRecordColumns
+func scanBillingRecord(row pgx.Row) (*model.BillingRecord, error) {
+	var record model.BillingRecord
+	var paymentDate, periodStart, periodEnd *time.Time
+
+	err := row.Scan(
+		&record.ID,
+		&record.OrganizationID,
+		&record.Amount,
+		&record.Currency,
+		&record.TransactionID,
+		&record.PaymentMethod,
+		&record.Status,
+		&record.Description,
+		&record.InvoiceURL,
+		&record.ReceiptURL,
+		&paymentDate,
+		&periodStart,
+		&periodEnd,
+		&record.FailureReason,
+		&record.IdempotencyKey,
+		&record.CreatedAt,
+		&record.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if paymentDate != nil {
+		record.PaymentDate = *paymentDate
+	}
+	if periodStart != nil {
+		record.BillingPeriodStart = *periodStart
+	}
+	if periodEnd != nil {
+		record.BillingPeriodEnd = *periodEnd
+	}
+
+	return &record, nil
+}
+
+// nullTime maps the zero time to NULL
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
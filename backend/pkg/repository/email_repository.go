@@ -0,0 +1,139 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/database"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// EmailRepository handles database operations for email templates and the
+// record of queued emails
+type EmailRepository struct {
+	db dbtx
+}
+
+// NewEmailRepository creates a new EmailRepository instance
+func NewEmailRepository(db *database.Database) *EmailRepository {
+	return &EmailRepository{
+		db: db,
+	}
+}
+
+// GetTemplate retrieves an organization's template for a kind of email and
+// locale. It returns database.ErrRecordNotFound if the organization uses the
+// built-in one.
+func (r *EmailRepository) GetTemplate(ctx context.Context, organizationID, kind, locale string) (*model.EmailTemplate, error) {
+	query := `
+		SELECT organization_id, kind, locale, subject, text_body, html_body, COALESCE(updated_by::text, ''), updated_at
+		FROM email_templates
+		WHERE organization_id = $1 AND kind = $2 AND locale = $3
+	`
+
+	t, err := scanEmailTemplate(r.db.QueryRow(ctx, query, organizationID, kind, locale))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, database.ErrRecordNotFound
+		}
+		return nil, fmt.Errorf("failed to get email template: %w", err)
+	}
+
+	return t, nil
+}
+
+// ListTemplates retrieves every template an organization customized
+func (r *EmailRepository) ListTemplates(ctx context.Context, organizationID string) ([]*model.EmailTemplate, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT organization_id, kind, locale, subject, text_body, html_body, COALESCE(updated_by::text, ''), updated_at
+		FROM email_templates
+		WHERE organization_id = $1
+		ORDER BY kind, locale
+	`, organizationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list email templates: %w", err)
+	}
+
+	return database.ScanRowsIntoSlice(ctx, rows, func(rows pgx.Rows) (*model.EmailTemplate, error) {
+		return scanEmailTemplate(rows)
+	})
+}
+
+// SaveTemplate creates or replaces an organization's template
+func (r *EmailRepository) SaveTemplate(ctx context.Context, t *model.EmailTemplate) error {
+	t.UpdatedAt = time.Now().UTC()
+	_, err := r.db.Exec(ctx, `
+		INSERT INTO email_templates (organization_id, kind, locale, subject, text_body, html_body, updated_by, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, '')::uuid, $8)
+		ON CONFLICT (organization_id, kind, locale)
+		DO UPDATE SET subject = EXCLUDED.subject, text_body = EXCLUDED.text_body, html_body = EXCLUDED.html_body,
+		              updated_by = EXCLUDED.updated_by, updated_at = EXCLUDED.updated_at
+	`, t.OrganizationID, t.Kind, t.Locale, t.Subject, t.Text, t.HTML, t.UpdatedBy, t.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save email template: %w", err)
+	}
+	return nil
+}
+
+// DeleteTemplate removes an organization's template, restoring the built-in one
+func (r *EmailRepository) DeleteTemplate(ctx context.Context, organizationID, kind, locale string) error {
+	tag, err := r.db.Exec(ctx, `
+		DELETE FROM email_templates WHERE organization_id = $1 AND kind = $2 AND locale = $3
+	`, organizationID, kind, locale)
+	if err != nil {
+		return fmt.Errorf("failed to delete email template: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return database.ErrRecordNotFound
+	}
+	return nil
+}
+
+// ClaimNotification records that the email with n's dedupe key is being
+// sent. It returns false if it was sent before.
+func (r *EmailRepository) ClaimNotification(ctx context.Context, n *model.EmailNotification) (bool, error) {
+	if n.ID == "" {
+		n.ID = uuid.New().String()
+	}
+	n.CreatedAt = time.Now().UTC()
+
+	tag, err := r.db.Exec(ctx, `
+		INSERT INTO email_notifications (id, organization_id, kind, recipient, dedupe_key, created_at)
+		VALUES ($1, NULLIF($2, '')::uuid, $3, $4, $5, $6)
+		ON CONFLICT (dedupe_key) DO NOTHING
+	`, n.ID, n.OrganizationID, n.Kind, n.Recipient, n.DedupeKey, n.CreatedAt)
+	if err != nil {
+		return false, fmt.Errorf("failed to claim email notification: %w", err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// SetNotificationJob links a claimed notification to the job sending it
+func (r *EmailRepository) SetNotificationJob(ctx context.Context, id, jobID string) error {
+	if _, err := r.db.Exec(ctx, `UPDATE email_notifications SET job_id = $2 WHERE id = $1`, id, jobID); err != nil {
+		return fmt.Errorf("failed to set email notification job: %w", err)
+	}
+	return nil
+}
+
+// ReleaseNotification forgets a claimed notification whose email could not
+// be queued, so that it is sent on the next try
+func (r *EmailRepository) ReleaseNotification(ctx context.Context, id string) error {
+	if _, err := r.db.Exec(ctx, `DELETE FROM email_notifications WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("failed to release email notification: %w", err)
+	}
+	return nil
+}
+
+// scanEmailTemplate scans an email_templates row
+func scanEmailTemplate(row pgx.Row) (*model.EmailTemplate, error) {
+	var t model.EmailTemplate
+	err := row.Scan(&t.OrganizationID, &t.Kind, &t.Locale, &t.Subject, &t.Text, &t.HTML, &t.UpdatedBy, &t.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
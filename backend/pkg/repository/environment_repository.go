@@ -3,6 +3,7 @@ package repository
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
@@ -11,9 +12,12 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-// EnvironmentRepository handles database operations for environment templates
+// EnvironmentRepository handles database operations for environment templates.
+// Every query is filtered by the organization the repository is scoped to,
+// see ForTenant.
 type EnvironmentRepository struct {
-	db *pgxpool.Pool
+	db             dbtx
+	organizationID string
 }
 
 // NewEnvironmentRepository creates a new environment repository
@@ -23,8 +27,46 @@ func NewEnvironmentRepository(db *pgxpool.Pool) *EnvironmentRepository {
 	}
 }
 
-// Create inserts a new environment template
+// ForTenant returns a copy of the repository scoped to the organization bound to ctx
+func (r *EnvironmentRepository) ForTenant(ctx context.Context) (*EnvironmentRepository, error) {
+	organizationID, err := TenantFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.ForOrganization(organizationID), nil
+}
+
+// ForOrganization returns a copy of the repository scoped to the given organization
+func (r *EnvironmentRepository) ForOrganization(organizationID string) *EnvironmentRepository {
+	return &EnvironmentRepository{
+		db:             r.db,
+		organizationID: organizationID,
+	}
+}
+
+// WithTx returns a copy of the repository that runs its queries inside tx
+func (r *EnvironmentRepository) WithTx(tx pgx.Tx) *EnvironmentRepository {
+	return &EnvironmentRepository{
+		db:             tx,
+		organizationID: r.organizationID,
+	}
+}
+
+// tenant returns the organization the repository is scoped to
+func (r *EnvironmentRepository) tenant() (string, error) {
+	if r.organizationID == "" {
+		return "", ErrTenantRequired
+	}
+	return r.organizationID, nil
+}
+
+// Create inserts a new environment template into the repository's organization
 func (r *EnvironmentRepository) Create(ctx context.Context, env *model.EnvironmentTemplate) error {
+	organizationID, err := r.tenant()
+	if err != nil {
+		return err
+	}
+
 	query := `
 		INSERT INTO environment_templates (
 			organization_id, name, description, type, specs, configuration,
@@ -35,6 +77,7 @@ func (r *EnvironmentRepository) Create(ctx context.Context, env *model.Environme
 	`
 
 	now := time.Now().UTC()
+	env.OrganizationID = organizationID
 	env.CreatedAt = now
 	env.UpdatedAt = now
 
@@ -52,24 +95,29 @@ func (r *EnvironmentRepository) Create(ctx context.Context, env *model.Environme
 
 // GetByID retrieves an environment template by ID
 func (r *EnvironmentRepository) GetByID(ctx context.Context, id string) (*model.EnvironmentTemplate, error) {
+	organizationID, err := r.tenant()
+	if err != nil {
+		return nil, err
+	}
+
 	query := `
 		SELECT
 			id, organization_id, name, description, type, specs, configuration,
 			created_by, created_at, updated_at
 		FROM environment_templates
-		WHERE id = $1
+		WHERE id = $1 AND organization_id = $2
 	`
 
 	env := &model.EnvironmentTemplate{}
 	var specsJSON []byte
 
-	err := r.db.QueryRow(ctx, query, id).Scan(
+	err = r.db.QueryRow(ctx, query, id, organizationID).Scan(
 		&env.ID, &env.OrganizationID, &env.Name, &env.Description, &env.Type, &specsJSON, &env.Configuration,
 		&env.CreatedBy, &env.CreatedAt, &env.UpdatedAt,
 	)
 	if err != nil {
-		if err == pgx.ErrNoRows {
-			return nil, fmt.Errorf("environment template not found: %s", id)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %s", ErrEnvironmentTemplateNotFound, id)
 		}
 		return nil, err
 	}
@@ -85,6 +133,11 @@ func (r *EnvironmentRepository) GetByID(ctx context.Context, id string) (*model.
 
 // Update updates an existing environment template
 func (r *EnvironmentRepository) Update(ctx context.Context, env *model.EnvironmentTemplate) error {
+	organizationID, err := r.tenant()
+	if err != nil {
+		return err
+	}
+
 	query := `
 		UPDATE environment_templates
 		SET
@@ -94,7 +147,7 @@ func (r *EnvironmentRepository) Update(ctx context.Context, env *model.Environme
 			specs = $4,
 			configuration = $5,
 			updated_at = $6
-		WHERE id = $7
+		WHERE id = $7 AND organization_id = $8
 	`
 
 	env.UpdatedAt = time.Now().UTC()
@@ -105,22 +158,44 @@ func (r *EnvironmentRepository) Update(ctx context.Context, env *model.Environme
 		return fmt.Errorf("failed to encode specs: %w", err)
 	}
 
-	_, err = r.db.Exec(ctx, query,
+	tag, err := r.db.Exec(ctx, query,
 		env.Name, env.Description, env.Type, specsJSON, env.Configuration,
-		env.UpdatedAt, env.ID,
+		env.UpdatedAt, env.ID, organizationID,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%w: %s", ErrEnvironmentTemplateNotFound, env.ID)
+	}
+	return nil
 }
 
 // Delete deletes an environment template
 func (r *EnvironmentRepository) Delete(ctx context.Context, id string) error {
-	query := `DELETE FROM environment_templates WHERE id = $1`
-	_, err := r.db.Exec(ctx, query, id)
-	return err
+	organizationID, err := r.tenant()
+	if err != nil {
+		return err
+	}
+
+	query := `DELETE FROM environment_templates WHERE id = $1 AND organization_id = $2`
+	tag, err := r.db.Exec(ctx, query, id, organizationID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%w: %s", ErrEnvironmentTemplateNotFound, id)
+	}
+	return nil
 }
 
-// ListByOrganization lists all environment templates for an organization
-func (r *EnvironmentRepository) ListByOrganization(ctx context.Context, organizationID string) ([]*model.EnvironmentTemplate, error) {
+// List lists all environment templates of the repository's organization
+func (r *EnvironmentRepository) List(ctx context.Context) ([]*model.EnvironmentTemplate, error) {
+	organizationID, err := r.tenant()
+	if err != nil {
+		return nil, err
+	}
+
 	query := `
 		SELECT
 			id, organization_id, name, description, type, specs, configuration,
@@ -165,8 +240,13 @@ func (r *EnvironmentRepository) ListByOrganization(ctx context.Context, organiza
 	return envs, nil
 }
 
-// ListByType lists all environment templates for an organization with a specific type
-func (r *EnvironmentRepository) ListByType(ctx context.Context, organizationID, envType string) ([]*model.EnvironmentTemplate, error) {
+// ListByType lists all environment templates of the repository's organization with a specific type
+func (r *EnvironmentRepository) ListByType(ctx context.Context, envType string) ([]*model.EnvironmentTemplate, error) {
+	organizationID, err := r.tenant()
+	if err != nil {
+		return nil, err
+	}
+
 	query := `
 		SELECT
 			id, organization_id, name, description, type, specs, configuration,
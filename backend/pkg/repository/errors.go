@@ -21,4 +21,77 @@ var (
 
 	// ErrDatabaseOperation is returned when a database operation fails
 	ErrDatabaseOperation = errors.New("database operation failed")
+
+	// ErrTenantRequired is returned when a tenant-scoped repository is used without an organization
+	ErrTenantRequired = errors.New("organization scope required")
+
+	// ErrTaskTemplateNotFound is returned when a task template is not found in the caller's organization
+	ErrTaskTemplateNotFound = errors.New("task template not found")
+
+	// ErrEnvironmentTemplateNotFound is returned when an environment template is not found in the caller's organization
+	ErrEnvironmentTemplateNotFound = errors.New("environment template not found")
+
+	// ErrAssessmentTemplateNotFound is returned when an assessment template is not found in the caller's organization
+	ErrAssessmentTemplateNotFound = errors.New("assessment template not found")
+
+	// ErrAssessmentNotFound is returned when an assessment is not found in the caller's organization
+	ErrAssessmentNotFound = errors.New("assessment not found")
+
+	// ErrInvalidOwner is returned when ownership is transferred to a user who cannot own the organization
+	ErrInvalidOwner = errors.New("new owner must be an active staff member of the organization")
+
+	// ErrCandidateNotFound is returned when a candidate does not belong to the caller's organization
+	ErrCandidateNotFound = errors.New("candidate not found")
+
+	// ErrReviewerNotFound is returned when a reviewer is not an admin or
+	// reviewer of the caller's organization
+	ErrReviewerNotFound = errors.New("reviewer not found")
+
+	// ErrMagicLinkInvalid is returned when a sign-in link does not exist, was
+	// already used or expired
+	ErrMagicLinkInvalid = errors.New("sign-in link is invalid or expired")
+
+	// ErrResetTokenInvalid is returned when a password reset token does not
+	// exist, was already used or expired, or its user is no longer active
+	ErrResetTokenInvalid = errors.New("password reset token is invalid or expired")
+
+	// ErrSessionNotFound is returned when a session does not exist, belongs to
+	// another user or was already revoked
+	ErrSessionNotFound = errors.New("session not found")
+
+	// ErrRefreshTokenInvalid is returned when a refresh token is unknown,
+	// expired or belongs to a revoked session
+	ErrRefreshTokenInvalid = errors.New("refresh token is invalid or expired")
+
+	// ErrRefreshTokenReused is returned when a refresh token that was already
+	// exchanged is used again; its session is revoked
+	ErrRefreshTokenReused = errors.New("refresh token was already used")
+
+	// ErrSSOStateInvalid is returned when a single sign-on callback carries a
+	// state that was never issued, was already used or expired
+	ErrSSOStateInvalid = errors.New("sign-in state is invalid or expired")
+
+	// ErrSSOCodeInvalid is returned when a single sign-on code is unknown,
+	// was already exchanged or expired
+	ErrSSOCodeInvalid = errors.New("sign-in code is invalid or expired")
+
+	// ErrSSODomainClaimed is returned when a single sign-on provider allows
+	// an email domain another organization claimed
+	ErrSSODomainClaimed = errors.New("email domain is claimed by another organization")
+
+	// ErrMFAAlreadyEnabled is returned when enrolling a user whose MFA is
+	// already confirmed
+	ErrMFAAlreadyEnabled = errors.New("multi-factor authentication is already enabled")
+
+	// ErrMFAChallengeInvalid is returned when an MFA challenge is unknown,
+	// completed, expired or out of attempts
+	ErrMFAChallengeInvalid = errors.New("MFA challenge is invalid or expired")
+
+	// ErrDuplicateRole is returned when a custom role is named like another
+	// role of the organization
+	ErrDuplicateRole = errors.New("role name already exists")
+
+	// ErrRoleAlreadyAssigned is returned when assigning a user a role they
+	// already hold on the same resource
+	ErrRoleAlreadyAssigned = errors.New("role is already assigned")
 )
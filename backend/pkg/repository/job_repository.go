@@ -0,0 +1,297 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/database"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// jobColumns is the column list shared by every jobs query
+const jobColumns = `
+	id, type, COALESCE(organization_id::text, ''), COALESCE(idempotency_key, ''), status, payload,
+	result, attempts, max_attempts, progress, COALESCE(progress_message, ''), COALESCE(last_error, ''),
+	COALESCE(created_by::text, ''), next_attempt_at, started_at, finished_at, created_at, updated_at
+`
+
+// JobRepository handles database operations for background jobs
+type JobRepository struct {
+	db dbtx
+}
+
+// NewJobRepository creates a new JobRepository instance
+func NewJobRepository(db *database.Database) *JobRepository {
+	return &JobRepository{
+		db: db,
+	}
+}
+
+// WithTx returns a copy of the repository that runs its queries inside tx
+func (r *JobRepository) WithTx(tx pgx.Tx) *JobRepository {
+	return &JobRepository{
+		db: tx,
+	}
+}
+
+// Create inserts a queued job. If the job has an idempotency key and an
+// unfinished job with the same key exists, job is loaded with that job and
+// false is returned.
+func (r *JobRepository) Create(ctx context.Context, job *model.Job) (bool, error) {
+	query := `
+		INSERT INTO jobs (id, type, organization_id, idempotency_key, status, payload, max_attempts,
+		                  created_by, created_at, updated_at)
+		VALUES ($1, $2, NULLIF($3, '')::uuid, NULLIF($4, ''), $5, $6, $7, NULLIF($8, '')::uuid, $9, $9)
+		ON CONFLICT (idempotency_key) WHERE idempotency_key IS NOT NULL AND status IN ('queued', 'running', 'retrying')
+		DO NOTHING
+	`
+
+	if job.ID == "" {
+		job.ID = uuid.New().String()
+	}
+	job.Status = model.JobStatusQueued
+	job.CreatedAt = time.Now().UTC()
+	job.UpdatedAt = job.CreatedAt
+
+	tag, err := r.db.Exec(ctx, query, job.ID, job.Type, job.OrganizationID, job.IdempotencyKey, job.Status,
+		job.Payload, job.MaxAttempts, job.CreatedBy, job.CreatedAt)
+	if err != nil {
+		return false, fmt.Errorf("failed to create job: %w", err)
+	}
+	if tag.RowsAffected() == 1 {
+		return true, nil
+	}
+
+	existing, err := scanJob(r.db.QueryRow(ctx, `
+		SELECT `+jobColumns+`
+		FROM jobs
+		WHERE idempotency_key = $1 AND status IN ('queued', 'running', 'retrying')
+	`, job.IdempotencyKey))
+	if err != nil {
+		return false, fmt.Errorf("failed to get existing job: %w", err)
+	}
+	*job = *existing
+	return false, nil
+}
+
+// GetByID retrieves a job by ID
+func (r *JobRepository) GetByID(ctx context.Context, id string) (*model.Job, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, database.ErrRecordNotFound
+	}
+
+	job, err := scanJob(r.db.QueryRow(ctx, `SELECT `+jobColumns+` FROM jobs WHERE id = $1`, id))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, database.ErrRecordNotFound
+		}
+		return nil, fmt.Errorf("failed to get job: %w", err)
+	}
+
+	return job, nil
+}
+
+// Claim marks a queued or retrying job as running and counts the attempt. A
+// running job whose last update is older than staleBefore is claimed as
+// well, since its worker is gone. It returns false if the job is not
+// claimable, for instance because another worker runs it.
+func (r *JobRepository) Claim(ctx context.Context, id string, staleBefore time.Time) (*model.Job, bool, error) {
+	query := `
+		UPDATE jobs
+		SET status = 'running', attempts = attempts + 1, next_attempt_at = NULL,
+		    started_at = COALESCE(started_at, $2), updated_at = $2
+		WHERE id = $1 AND (status IN ('queued', 'retrying') OR (status = 'running' AND updated_at < $3))
+		RETURNING ` + jobColumns
+
+	job, err := scanJob(r.db.QueryRow(ctx, query, id, time.Now().UTC(), staleBefore))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("failed to claim job: %w", err)
+	}
+
+	return job, true, nil
+}
+
+// UpdateProgress stores how far a running job got. It also serves as the
+// job's heartbeat, see Claim.
+func (r *JobRepository) UpdateProgress(ctx context.Context, id string, progress int, message string) error {
+	_, err := r.db.Exec(ctx, `
+		UPDATE jobs SET progress = $2, progress_message = NULLIF($3, ''), updated_at = $4
+		WHERE id = $1 AND status = 'running'
+	`, id, progress, message, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to update job progress: %w", err)
+	}
+	return nil
+}
+
+// Succeed marks a job as succeeded with its result
+func (r *JobRepository) Succeed(ctx context.Context, job *model.Job, result json.RawMessage) error {
+	now := time.Now().UTC()
+	_, err := r.db.Exec(ctx, `
+		UPDATE jobs
+		SET status = 'succeeded', result = $2, progress = 100, last_error = NULL, finished_at = $3, updated_at = $3
+		WHERE id = $1
+	`, job.ID, result, now)
+	if err != nil {
+		return fmt.Errorf("failed to mark job succeeded: %w", err)
+	}
+
+	job.Status = model.JobStatusSucceeded
+	job.Result = result
+	job.Progress = 100
+	job.LastError = ""
+	job.FinishedAt = now
+	job.UpdatedAt = now
+	return nil
+}
+
+// Fail stores a failed attempt. The job is retried at nextAttemptAt, or
+// failed for good if nextAttemptAt is zero.
+func (r *JobRepository) Fail(ctx context.Context, job *model.Job, cause string, nextAttemptAt time.Time) error {
+	now := time.Now().UTC()
+	job.Status = model.JobStatusRetrying
+	job.FinishedAt = time.Time{}
+	if nextAttemptAt.IsZero() {
+		job.Status = model.JobStatusFailed
+		job.FinishedAt = now
+	}
+	job.LastError = cause
+	job.NextAttemptAt = nextAttemptAt
+	job.UpdatedAt = now
+
+	_, err := r.db.Exec(ctx, `
+		UPDATE jobs
+		SET status = $2, last_error = $3, next_attempt_at = $4, finished_at = $5, updated_at = $6
+		WHERE id = $1
+	`, job.ID, job.Status, cause, nullTime(nextAttemptAt), nullTime(job.FinishedAt), now)
+	if err != nil {
+		return fmt.Errorf("failed to mark job failed: %w", err)
+	}
+	return nil
+}
+
+// ListStale retrieves up to limit jobs whose messages were lost, for instance
+// because publishing failed or a worker died: queued jobs not updated since
+// before, retrying jobs due by then, and running jobs not updated since
+// runningBefore.
+func (r *JobRepository) ListStale(ctx context.Context, before, runningBefore time.Time, limit int) ([]*model.Job, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT `+jobColumns+`
+		FROM jobs
+		WHERE (status = 'queued' AND updated_at < $1)
+		   OR (status = 'retrying' AND next_attempt_at < $1)
+		   OR (status = 'running' AND updated_at < $2)
+		ORDER BY created_at
+		LIMIT $3
+	`, before, runningBefore, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list stale jobs: %w", err)
+	}
+
+	return database.ScanRowsIntoSlice(ctx, rows, func(rows pgx.Rows) (*model.Job, error) {
+		return scanJob(rows)
+	})
+}
+
+// Touch bumps a job's update time so it is not considered stale again right away
+func (r *JobRepository) Touch(ctx context.Context, id string) error {
+	if _, err := r.db.Exec(ctx, `UPDATE jobs SET updated_at = $2 WHERE id = $1`, id, time.Now().UTC()); err != nil {
+		return fmt.Errorf("failed to touch job: %w", err)
+	}
+	return nil
+}
+
+// ListByOrganization retrieves a page of an organization's jobs, newest
+// first, optionally filtered by type and status
+func (r *JobRepository) ListByOrganization(ctx context.Context, organizationID, jobType, status string, params database.PaginationParams) (database.PaginatedResponse, error) {
+	where := `organization_id = $1`
+	args := []interface{}{organizationID}
+	if jobType != "" {
+		args = append(args, jobType)
+		where += fmt.Sprintf(` AND type = $%d`, len(args))
+	}
+	if status != "" {
+		args = append(args, status)
+		where += fmt.Sprintf(` AND status = $%d`, len(args))
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM jobs
+		WHERE %s
+		ORDER BY created_at DESC
+		LIMIT $%d OFFSET $%d
+	`, jobColumns, where, len(args)+1, len(args)+2)
+
+	rows, err := r.db.Query(ctx, query, append(args, params.Limit(), params.Offset())...)
+	if err != nil {
+		return database.PaginatedResponse{}, fmt.Errorf("failed to list jobs: %w", err)
+	}
+
+	jobs, err := database.ScanRowsIntoSlice(ctx, rows, func(rows pgx.Rows) (*model.Job, error) {
+		return scanJob(rows)
+	})
+	if err != nil {
+		return database.PaginatedResponse{}, fmt.Errorf("failed to scan jobs: %w", err)
+	}
+
+	var count int
+	if err := r.db.QueryRow(ctx, `SELECT COUNT(*) FROM jobs WHERE `+where, args...).Scan(&count); err != nil {
+		return database.PaginatedResponse{}, fmt.Errorf("failed to count jobs: %w", err)
+	}
+
+	return database.NewPaginatedResponse(jobs, params, count), nil
+}
+
+// scanJob scans a row selected with jobColumns
+func scanJob(row pgx.Row) (*model.Job, error) {
+	var j model.Job
+	var result []byte
+	var nextAttemptAt, startedAt, finishedAt *time.Time
+
+	err := row.Scan(
+		&j.ID,
+		&j.Type,
+		&j.OrganizationID,
+		&j.IdempotencyKey,
+		&j.Status,
+		&j.Payload,
+		&result,
+		&j.Attempts,
+		&j.MaxAttempts,
+		&j.Progress,
+		&j.ProgressMessage,
+		&j.LastError,
+		&j.CreatedBy,
+		&nextAttemptAt,
+		&startedAt,
+		&finishedAt,
+		&j.CreatedAt,
+		&j.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(result) > 0 {
+		j.Result = result
+	}
+	if nextAttemptAt != nil {
+		j.NextAttemptAt = *nextAttemptAt
+	}
+	if startedAt != nil {
+		j.StartedAt = *startedAt
+	}
+	if finishedAt != nil {
+		j.FinishedAt = *finishedAt
+	}
+
+	return &j, nil
+}
@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/database"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// MagicLinkRepository handles database operations for candidates' sign-in
+// links
+type MagicLinkRepository struct {
+	db dbtx
+}
+
+// NewMagicLinkRepository creates a new MagicLinkRepository instance
+func NewMagicLinkRepository(db *database.Database) *MagicLinkRepository {
+	return &MagicLinkRepository{
+		db: db,
+	}
+}
+
+// Create records a new sign-in link
+func (r *MagicLinkRepository) Create(ctx context.Context, link *model.MagicLink) error {
+	if link.ID == "" {
+		link.ID = uuid.New().String()
+	}
+	link.CreatedAt = time.Now().UTC()
+
+	_, err := r.db.Exec(ctx, `
+		INSERT INTO magic_links (id, organization_id, assessment_id, candidate_id, created_by, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, '')::uuid, $6, $7)
+	`, link.ID, link.OrganizationID, link.AssessmentID, link.CandidateID, link.CreatedBy, link.ExpiresAt, link.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create magic link: %w", err)
+	}
+	return nil
+}
+
+// Consume marks a sign-in link used and returns it. It returns
+// ErrMagicLinkInvalid if the link does not exist, was already used or
+// expired, so that a link signs in at most once.
+func (r *MagicLinkRepository) Consume(ctx context.Context, id string) (*model.MagicLink, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrMagicLinkInvalid
+	}
+
+	var link model.MagicLink
+	err := r.db.QueryRow(ctx, `
+		UPDATE magic_links
+		SET used_at = $2
+		WHERE id = $1 AND used_at IS NULL AND expires_at > $2
+		RETURNING id, organization_id, assessment_id, candidate_id, COALESCE(created_by::text, ''),
+		          expires_at, used_at, created_at
+	`, id, time.Now().UTC()).Scan(
+		&link.ID,
+		&link.OrganizationID,
+		&link.AssessmentID,
+		&link.CandidateID,
+		&link.CreatedBy,
+		&link.ExpiresAt,
+		&link.UsedAt,
+		&link.CreatedAt,
+	)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrMagicLinkInvalid
+		}
+		return nil, fmt.Errorf("failed to consume magic link: %w", err)
+	}
+
+	return &link, nil
+}
@@ -0,0 +1,317 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/database"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/jackc/pgx/v5"
+)
+
+// MFARepository handles database operations for users' second factors, the
+// sign-ins waiting for them and organizations' MFA policies
+type MFARepository struct {
+	db dbtx
+}
+
+// NewMFARepository creates a new MFARepository instance
+func NewMFARepository(db *database.Database) *MFARepository {
+	return &MFARepository{
+		db: db,
+	}
+}
+
+// GetEnrollment retrieves a user's TOTP enrollment, pending or confirmed
+func (r *MFARepository) GetEnrollment(ctx context.Context, userID string) (*model.MFAEnrollment, error) {
+	var e model.MFAEnrollment
+	err := r.db.QueryRow(ctx, `
+		SELECT user_id, secret, last_used_step, confirmed_at, created_at
+		FROM user_mfa
+		WHERE user_id = $1
+	`, userID).Scan(&e.UserID, &e.Secret, &e.LastUsedStep, &e.ConfirmedAt, &e.CreatedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, database.ErrRecordNotFound
+		}
+		return nil, fmt.Errorf("failed to get MFA enrollment: %w", err)
+	}
+
+	return &e, nil
+}
+
+// StartEnrollment creates a pending enrollment for a user with a new secret,
+// replacing a pending one. It returns ErrMFAAlreadyEnabled if the user's MFA
+// is confirmed.
+func (r *MFARepository) StartEnrollment(ctx context.Context, userID, secret string) error {
+	tag, err := r.db.Exec(ctx, `
+		INSERT INTO user_mfa (user_id, secret, created_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, last_used_step = 0, created_at = EXCLUDED.created_at
+		WHERE user_mfa.confirmed_at IS NULL
+	`, userID, secret, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to start MFA enrollment: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrMFAAlreadyEnabled
+	}
+
+	return nil
+}
+
+// ConfirmEnrollment confirms a user's pending enrollment with the code of
+// time step step and gives them new recovery codes, by their hashes. It
+// returns ErrMFAAlreadyEnabled if the enrollment is confirmed and
+// database.ErrRecordNotFound if there is none.
+func (r *MFARepository) ConfirmEnrollment(ctx context.Context, userID string, step int64, recoveryCodeHashes []string) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var confirmedAt *time.Time
+	err = tx.QueryRow(ctx, `SELECT confirmed_at FROM user_mfa WHERE user_id = $1 FOR UPDATE`, userID).Scan(&confirmedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return database.ErrRecordNotFound
+		}
+		return fmt.Errorf("failed to get MFA enrollment: %w", err)
+	}
+	if confirmedAt != nil {
+		return ErrMFAAlreadyEnabled
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE user_mfa SET confirmed_at = $2, last_used_step = $3 WHERE user_id = $1
+	`, userID, time.Now().UTC(), step)
+	if err != nil {
+		return fmt.Errorf("failed to confirm MFA enrollment: %w", err)
+	}
+	if err := replaceRecoveryCodes(ctx, tx, userID, recoveryCodeHashes); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// UseStep records that a user's code of time step step was accepted. It
+// returns false if a code of that step or a later one was already accepted,
+// so that concurrent sign-ins cannot use the same code.
+func (r *MFARepository) UseStep(ctx context.Context, userID string, step int64) (bool, error) {
+	tag, err := r.db.Exec(ctx, `
+		UPDATE user_mfa SET last_used_step = $2
+		WHERE user_id = $1 AND confirmed_at IS NOT NULL AND last_used_step < $2
+	`, userID, step)
+	if err != nil {
+		return false, fmt.Errorf("failed to record MFA code: %w", err)
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+// UseRecoveryCode marks a user's unused recovery code with the given hash as
+// used. It returns false if there is no such code.
+func (r *MFARepository) UseRecoveryCode(ctx context.Context, userID, codeHash string) (bool, error) {
+	tag, err := r.db.Exec(ctx, `
+		UPDATE mfa_recovery_codes SET used_at = $3
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`, userID, codeHash, time.Now().UTC())
+	if err != nil {
+		return false, fmt.Errorf("failed to use recovery code: %w", err)
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+// ReplaceRecoveryCodes replaces a user's recovery codes with new ones, by
+// their hashes
+func (r *MFARepository) ReplaceRecoveryCodes(ctx context.Context, userID string, codeHashes []string) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := replaceRecoveryCodes(ctx, tx, userID, codeHashes); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// CountRecoveryCodes returns how many unused recovery codes a user has
+func (r *MFARepository) CountRecoveryCodes(ctx context.Context, userID string) (int, error) {
+	var count int
+	err := r.db.QueryRow(ctx, `
+		SELECT COUNT(*) FROM mfa_recovery_codes WHERE user_id = $1 AND used_at IS NULL
+	`, userID).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count recovery codes: %w", err)
+	}
+
+	return count, nil
+}
+
+// DeleteEnrollment removes a user's enrollment and recovery codes. It returns
+// database.ErrRecordNotFound if the user has no enrollment.
+func (r *MFARepository) DeleteEnrollment(ctx context.Context, userID string) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `DELETE FROM user_mfa WHERE user_id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete MFA enrollment: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return database.ErrRecordNotFound
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	return tx.Commit(ctx)
+}
+
+// CreateChallenge records a sign-in waiting for the user's second factor
+func (r *MFARepository) CreateChallenge(ctx context.Context, c *model.MFAChallenge) error {
+	c.CreatedAt = time.Now().UTC()
+
+	_, err := r.db.Exec(ctx, `
+		INSERT INTO mfa_challenges (id, user_id, expires_at, created_at)
+		VALUES ($1, $2, $3, $4)
+	`, c.ID, c.UserID, c.ExpiresAt, c.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create MFA challenge: %w", err)
+	}
+
+	return nil
+}
+
+// GetChallenge retrieves a challenge that can still be completed. It returns
+// ErrMFAChallengeInvalid if it is completed, expired or out of attempts.
+func (r *MFARepository) GetChallenge(ctx context.Context, id string) (*model.MFAChallenge, error) {
+	var c model.MFAChallenge
+	err := r.db.QueryRow(ctx, `
+		SELECT id, user_id, attempts, expires_at, completed_at, created_at
+		FROM mfa_challenges
+		WHERE id = $1 AND completed_at IS NULL AND expires_at > $2 AND attempts < $3
+	`, id, time.Now().UTC(), model.MaxMFAChallengeAttempts).Scan(&c.ID, &c.UserID, &c.Attempts, &c.ExpiresAt, &c.CompletedAt, &c.CreatedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrMFAChallengeInvalid
+		}
+		return nil, fmt.Errorf("failed to get MFA challenge: %w", err)
+	}
+
+	return &c, nil
+}
+
+// RecordChallengeAttempt counts a code tried against a challenge and returns
+// the challenge. It returns ErrMFAChallengeInvalid if the challenge cannot
+// be completed any more.
+func (r *MFARepository) RecordChallengeAttempt(ctx context.Context, id string) (*model.MFAChallenge, error) {
+	var c model.MFAChallenge
+	err := r.db.QueryRow(ctx, `
+		UPDATE mfa_challenges SET attempts = attempts + 1
+		WHERE id = $1 AND completed_at IS NULL AND expires_at > $2 AND attempts < $3
+		RETURNING id, user_id, attempts, expires_at, completed_at, created_at
+	`, id, time.Now().UTC(), model.MaxMFAChallengeAttempts).Scan(&c.ID, &c.UserID, &c.Attempts, &c.ExpiresAt, &c.CompletedAt, &c.CreatedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrMFAChallengeInvalid
+		}
+		return nil, fmt.Errorf("failed to record MFA challenge attempt: %w", err)
+	}
+
+	return &c, nil
+}
+
+// CompleteChallenge marks a challenge completed. It returns
+// ErrMFAChallengeInvalid if it already was.
+func (r *MFARepository) CompleteChallenge(ctx context.Context, id string) error {
+	tag, err := r.db.Exec(ctx, `
+		UPDATE mfa_challenges SET completed_at = $2 WHERE id = $1 AND completed_at IS NULL
+	`, id, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to complete MFA challenge: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrMFAChallengeInvalid
+	}
+
+	return nil
+}
+
+// DeleteExpiredChallenges deletes the challenges that expired before before
+func (r *MFARepository) DeleteExpiredChallenges(ctx context.Context, before time.Time) error {
+	_, err := r.db.Exec(ctx, `DELETE FROM mfa_challenges WHERE expires_at < $1`, before)
+	if err != nil {
+		return fmt.Errorf("failed to delete expired MFA challenges: %w", err)
+	}
+	return nil
+}
+
+// GetPolicy retrieves an organization's MFA policy. Organizations without
+// one require MFA for no role.
+func (r *MFARepository) GetPolicy(ctx context.Context, organizationID string) (*model.MFAPolicy, error) {
+	p := model.MFAPolicy{OrganizationID: organizationID, RequiredRoles: []string{}}
+	if organizationID == "" {
+		return &p, nil
+	}
+
+	err := r.db.QueryRow(ctx, `
+		SELECT required_roles, updated_at FROM mfa_policies WHERE organization_id = $1
+	`, organizationID).Scan(&p.RequiredRoles, &p.UpdatedAt)
+	if err != nil && err != pgx.ErrNoRows {
+		return nil, fmt.Errorf("failed to get MFA policy: %w", err)
+	}
+
+	return &p, nil
+}
+
+// SavePolicy creates or replaces an organization's MFA policy
+func (r *MFARepository) SavePolicy(ctx context.Context, p *model.MFAPolicy) error {
+	if p.RequiredRoles == nil {
+		p.RequiredRoles = []string{}
+	}
+	p.UpdatedAt = time.Now().UTC()
+
+	_, err := r.db.Exec(ctx, `
+		INSERT INTO mfa_policies (organization_id, required_roles, updated_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (organization_id) DO UPDATE
+		SET required_roles = EXCLUDED.required_roles, updated_at = EXCLUDED.updated_at
+	`, p.OrganizationID, p.RequiredRoles, p.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save MFA policy: %w", err)
+	}
+
+	return nil
+}
+
+// replaceRecoveryCodes replaces a user's recovery codes within tx
+func replaceRecoveryCodes(ctx context.Context, tx pgx.Tx, userID string, codeHashes []string) error {
+	if _, err := tx.Exec(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	now := time.Now().UTC()
+	for _, hash := range codeHashes {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO mfa_recovery_codes (user_id, code_hash, created_at)
+			VALUES ($1, $2, $3)
+		`, userID, hash, now)
+		if err != nil {
+			return fmt.Errorf("failed to create recovery code: %w", err)
+		}
+	}
+
+	return nil
+}
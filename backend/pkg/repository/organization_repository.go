@@ -2,15 +2,26 @@ package repository
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"time"
 
 	"github.com/cstanislawski/qualifyd/pkg/database"
+	"github.com/cstanislawski/qualifyd/pkg/events"
 	"github.com/cstanislawski/qualifyd/pkg/model"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 )
 
+// organizationColumns is the column list shared by every organizations query
+const organizationColumns = `
+	id, name, subscription_plan, subscription_status, contact_email, contact_phone, billing_email,
+	billing_address, logo_url, website_url, COALESCE(owner_id::text, ''),
+	subscription_start_date, subscription_end_date, payment_due_date,
+	last_payment_date, payment_method, payment_method_details, auto_renew, email_reminders, email_locale,
+	COALESCE(billing_customer_id, ''), created_at, updated_at
+`
+
 // OrganizationRepository handles database operations for organizations
 type OrganizationRepository struct {
 	db *database.Database
@@ -26,40 +37,12 @@ func NewOrganizationRepository(db *database.Database) *OrganizationRepository {
 // GetByID retrieves an organization by ID
 func (r *OrganizationRepository) GetByID(ctx context.Context, id string) (*model.Organization, error) {
 	query := `
-		SELECT id, name, subscription_plan, subscription_status, contact_email, contact_phone, billing_email,
-		       billing_address, logo_url, website_url,
-		       subscription_start_date, subscription_end_date, payment_due_date,
-		       last_payment_date, payment_method, payment_method_details, auto_renew, email_reminders,
-		       created_at, updated_at
+		SELECT ` + organizationColumns + `
 		FROM organizations
 		WHERE id = $1
 	`
 
-	var org model.Organization
-
-	err := r.db.QueryRow(ctx, query, id).Scan(
-		&org.ID,
-		&org.Name,
-		&org.SubscriptionPlan,
-		&org.SubscriptionStatus,
-		&org.ContactEmail,
-		&org.ContactPhone,
-		&org.BillingEmail,
-		&org.BillingAddress,
-		&org.LogoURL,
-		&org.WebsiteURL,
-		&org.SubscriptionStartDate,
-		&org.SubscriptionEndDate,
-		&org.PaymentDueDate,
-		&org.LastPaymentDate,
-		&org.PaymentMethod,
-		&org.PaymentMethodDetails,
-		&org.AutoRenew,
-		&org.EmailReminders,
-		&org.CreatedAt,
-		&org.UpdatedAt,
-	)
-
+	org, err := scanOrganizationRow(r.db.QueryRow(ctx, query, id))
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			return nil, database.ErrRecordNotFound
@@ -67,18 +50,18 @@ func (r *OrganizationRepository) GetByID(ctx context.Context, id string) (*model
 		return nil, fmt.Errorf("failed to get organization by ID: %w", err)
 	}
 
-	return &org, nil
+	return org, nil
 }
 
-// Create creates a new organization
+// Create creates a new organization together with the quota for its plan
 func (r *OrganizationRepository) Create(ctx context.Context, org *model.Organization) error {
 	query := `
 		INSERT INTO organizations (id, name, subscription_plan, subscription_status, contact_email, contact_phone, billing_email,
-		                         billing_address, logo_url, website_url,
+		                         billing_address, logo_url, website_url, owner_id,
 		                         subscription_start_date, subscription_end_date, payment_due_date,
 		                         last_payment_date, payment_method, payment_method_details, auto_renew, email_reminders,
-		                         created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20)
+		                         email_locale, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NULLIF($11, '')::uuid, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22)
 	`
 
 	// Generate a new UUID if not provided
@@ -94,38 +77,49 @@ func (r *OrganizationRepository) Create(ctx context.Context, org *model.Organiza
 	if org.UpdatedAt.IsZero() {
 		org.UpdatedAt = now
 	}
+	if org.EmailLocale == "" {
+		org.EmailLocale = "en"
+	}
 
-	_, err := r.db.Exec(ctx, query,
-		org.ID,
-		org.Name,
-		org.SubscriptionPlan,
-		org.SubscriptionStatus,
-		org.ContactEmail,
-		org.ContactPhone,
-		org.BillingEmail,
-		org.BillingAddress,
-		org.LogoURL,
-		org.WebsiteURL,
-		org.SubscriptionStartDate,
-		org.SubscriptionEndDate,
-		org.PaymentDueDate,
-		org.LastPaymentDate,
-		org.PaymentMethod,
-		org.PaymentMethodDetails,
-		org.AutoRenew,
-		org.EmailReminders,
-		org.CreatedAt,
-		org.UpdatedAt,
-	)
+	return r.db.WithTx(ctx, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, query,
+			org.ID,
+			org.Name,
+			org.SubscriptionPlan,
+			org.SubscriptionStatus,
+			org.ContactEmail,
+			org.ContactPhone,
+			org.BillingEmail,
+			org.BillingAddress,
+			org.LogoURL,
+			org.WebsiteURL,
+			org.OwnerID,
+			org.SubscriptionStartDate,
+			org.SubscriptionEndDate,
+			org.PaymentDueDate,
+			org.LastPaymentDate,
+			org.PaymentMethod,
+			org.PaymentMethodDetails,
+			org.AutoRenew,
+			org.EmailReminders,
+			org.EmailLocale,
+			org.CreatedAt,
+			org.UpdatedAt,
+		)
 
-	if err != nil {
-		if database.IsUniqueViolation(err) {
-			return fmt.Errorf("organization with this name already exists: %w", database.ErrDuplicateKey)
+		if err != nil {
+			if database.IsUniqueViolation(err) {
+				return fmt.Errorf("organization with this name already exists: %w", database.ErrDuplicateKey)
+			}
+			return fmt.Errorf("failed to create organization: %w", err)
 		}
-		return fmt.Errorf("failed to create organization: %w", err)
-	}
 
-	return nil
+		// The quota counter triggers only update existing rows, so the quota
+		// must exist before the first user or template is created
+		quota := model.NewOrganizationQuota(org.ID, org.SubscriptionPlan)
+		quota.PeriodStart, quota.PeriodEnd = model.BillingPeriod(org.BillingAnchor(), now)
+		return createQuota(ctx, tx, quota)
+	})
 }
 
 // Update updates an existing organization
@@ -136,8 +130,8 @@ func (r *OrganizationRepository) Update(ctx context.Context, org *model.Organiza
 		    billing_email = $6, billing_address = $7, logo_url = $8, website_url = $9,
 		    subscription_start_date = $10, subscription_end_date = $11, payment_due_date = $12,
 		    last_payment_date = $13, payment_method = $14, payment_method_details = $15, auto_renew = $16, email_reminders = $17,
-		    updated_at = $18
-		WHERE id = $19
+		    email_locale = $18, updated_at = $19
+		WHERE id = $20
 	`
 
 	// Ensure updated_at is set
@@ -161,6 +155,7 @@ func (r *OrganizationRepository) Update(ctx context.Context, org *model.Organiza
 		org.PaymentMethodDetails,
 		org.AutoRenew,
 		org.EmailReminders,
+		org.EmailLocale,
 		org.UpdatedAt,
 		org.ID,
 	)
@@ -178,6 +173,262 @@ func (r *OrganizationRepository) Update(ctx context.Context, org *model.Organiza
 	return nil
 }
 
+// ListBySubscriptionStatus retrieves every organization whose subscription is in one of statuses
+func (r *OrganizationRepository) ListBySubscriptionStatus(ctx context.Context, statuses ...string) ([]*model.Organization, error) {
+	query := `
+		SELECT ` + organizationColumns + `
+		FROM organizations
+		WHERE subscription_status = ANY($1)
+		ORDER BY created_at ASC
+	`
+
+	rows, err := r.db.Query(ctx, query, statuses)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list organizations by subscription status: %w", err)
+	}
+
+	return database.ScanRowsIntoSlice(ctx, rows, r.scanOrganization)
+}
+
+// UpdateSubscription saves the subscription status and dates of an
+// organization. It returns false without changing anything if the status or
+// end date no longer match previousStatus and previousEndDate, i.e. another
+// writer changed the subscription since it was read.
+func (r *OrganizationRepository) UpdateSubscription(ctx context.Context, org *model.Organization, previousStatus string, previousEndDate time.Time) (bool, error) {
+	query := `
+		UPDATE organizations
+		SET subscription_status = $1, subscription_start_date = $2, subscription_end_date = $3,
+		    payment_due_date = $4, updated_at = $5
+		WHERE id = $6 AND subscription_status = $7 AND subscription_end_date = $8
+	`
+
+	org.UpdateTimestamp()
+
+	tag, err := r.db.Exec(ctx, query,
+		org.SubscriptionStatus,
+		org.SubscriptionStartDate,
+		org.SubscriptionEndDate,
+		org.PaymentDueDate,
+		org.UpdatedAt,
+		org.ID,
+		previousStatus,
+		previousEndDate,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to update organization subscription: %w", err)
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+// ClaimNotification records that a subscription notification of kind is being
+// sent for the period identified by referenceDate. It returns false if it was
+// already claimed, so that each notification is sent at most once.
+func (r *OrganizationRepository) ClaimNotification(ctx context.Context, organizationID, kind string, referenceDate time.Time) (bool, error) {
+	query := `
+		INSERT INTO subscription_notifications (organization_id, kind, reference_date)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (organization_id, kind, reference_date) DO NOTHING
+	`
+
+	tag, err := r.db.Exec(ctx, query, organizationID, kind, referenceDate)
+	if err != nil {
+		return false, fmt.Errorf("failed to claim subscription notification: %w", err)
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+// ReleaseNotification removes a claim made by ClaimNotification so that a
+// notification that could not be delivered is retried
+func (r *OrganizationRepository) ReleaseNotification(ctx context.Context, organizationID, kind string, referenceDate time.Time) error {
+	query := `DELETE FROM subscription_notifications WHERE organization_id = $1 AND kind = $2 AND reference_date = $3`
+
+	if _, err := r.db.Exec(ctx, query, organizationID, kind, referenceDate); err != nil {
+		return fmt.Errorf("failed to release subscription notification: %w", err)
+	}
+
+	return nil
+}
+
+// SetBillingCustomer records the organization's customer ID at the payment provider
+func (r *OrganizationRepository) SetBillingCustomer(ctx context.Context, organizationID, customerID string) error {
+	query := `UPDATE organizations SET billing_customer_id = $1, updated_at = $2 WHERE id = $3`
+
+	tag, err := r.db.Exec(ctx, query, customerID, time.Now().UTC(), organizationID)
+	if err != nil {
+		if database.IsUniqueViolation(err) {
+			return fmt.Errorf("billing customer already belongs to another organization: %w", database.ErrDuplicateKey)
+		}
+		return fmt.Errorf("failed to set billing customer: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return database.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// UpdatePaymentMethod records the organization's default payment method
+func (r *OrganizationRepository) UpdatePaymentMethod(ctx context.Context, organizationID, method string, details json.RawMessage) error {
+	query := `UPDATE organizations SET payment_method = $1, payment_method_details = $2, updated_at = $3 WHERE id = $4`
+
+	tag, err := r.db.Exec(ctx, query, method, details, time.Now().UTC(), organizationID)
+	if err != nil {
+		return fmt.Errorf("failed to update payment method: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return database.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// RecordPayment records a payment received at paidAt. Payments settle out of
+// order, so the last payment date only ever moves forward.
+func (r *OrganizationRepository) RecordPayment(ctx context.Context, organizationID string, paidAt time.Time) error {
+	query := `
+		UPDATE organizations
+		SET last_payment_date = GREATEST(COALESCE(last_payment_date, $1), $1), updated_at = $2
+		WHERE id = $3
+	`
+
+	if _, err := r.db.Exec(ctx, query, paidAt, time.Now().UTC(), organizationID); err != nil {
+		return fmt.Errorf("failed to record payment: %w", err)
+	}
+
+	return nil
+}
+
+// MarkPastDue moves an active subscription to past due with payment due at
+// dueDate. It returns false if the subscription was not active.
+func (r *OrganizationRepository) MarkPastDue(ctx context.Context, organizationID string, dueDate time.Time) (bool, error) {
+	query := `
+		UPDATE organizations
+		SET subscription_status = $1, payment_due_date = $2, updated_at = $3
+		WHERE id = $4 AND subscription_status = $5
+	`
+
+	tag, err := r.db.Exec(ctx, query,
+		model.SubscriptionStatusPastDue,
+		dueDate,
+		time.Now().UTC(),
+		organizationID,
+		model.SubscriptionStatusActive,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to mark subscription past due: %w", err)
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+// ChangePlan moves the organization to change.ToPlan, applies change.NewLimits
+// to its quota, records change in the audit trail and stores the plan.changed
+// event, in one transaction. Usage counters are left as they are.
+func (r *OrganizationRepository) ChangePlan(ctx context.Context, change *model.QuotaChange) error {
+	return r.db.WithTx(ctx, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx,
+			`UPDATE organizations SET subscription_plan = $1, updated_at = $2 WHERE id = $3`,
+			change.ToPlan, time.Now().UTC(), change.OrganizationID)
+		if err != nil {
+			return fmt.Errorf("failed to change organization plan: %w", err)
+		}
+		if tag.RowsAffected() == 0 {
+			return database.ErrRecordNotFound
+		}
+
+		if err := applyQuotaLimits(ctx, tx, change.OrganizationID, change.NewLimits); err != nil {
+			return err
+		}
+		if err := createQuotaChange(ctx, tx, change); err != nil {
+			return err
+		}
+		return addOutboxEvent(ctx, tx, change.OrganizationID, &events.PlanChanged{
+			OrganizationID: change.OrganizationID,
+			ChangeID:       change.ID,
+			FromPlan:       change.FromPlan,
+			ToPlan:         change.ToPlan,
+			ChangedBy:      change.ChangedBy,
+		})
+	})
+}
+
+// SetOwner records the owner of a newly created organization
+func (r *OrganizationRepository) SetOwner(ctx context.Context, organizationID, userID string) error {
+	query := `UPDATE organizations SET owner_id = $1, updated_at = $2 WHERE id = $3`
+
+	tag, err := r.db.Exec(ctx, query, userID, time.Now().UTC(), organizationID)
+	if err != nil {
+		return fmt.Errorf("failed to set organization owner: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return database.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// TransferOwnership makes another member the owner of the organization. The
+// new owner must be an active, non-candidate member and is promoted to admin
+// so they can manage the organization; the previous owner keeps their role.
+func (r *OrganizationRepository) TransferOwnership(ctx context.Context, organizationID, newOwnerID string) error {
+	return r.db.WithTx(ctx, func(tx pgx.Tx) error {
+		// Lock the organization so concurrent transfers are serialized
+		var currentOwnerID string
+		err := tx.QueryRow(ctx,
+			`SELECT COALESCE(owner_id::text, '') FROM organizations WHERE id = $1 FOR UPDATE`,
+			organizationID,
+		).Scan(&currentOwnerID)
+		if err != nil {
+			if err == pgx.ErrNoRows {
+				return database.ErrRecordNotFound
+			}
+			return fmt.Errorf("failed to lock organization: %w", err)
+		}
+
+		if currentOwnerID == newOwnerID {
+			return nil
+		}
+
+		var role, status string
+		err = tx.QueryRow(ctx,
+			`SELECT role, status FROM users WHERE id = $1 AND organization_id = $2 FOR UPDATE`,
+			newOwnerID, organizationID,
+		).Scan(&role, &status)
+		if err != nil {
+			if err == pgx.ErrNoRows {
+				return fmt.Errorf("%w: %s", ErrInvalidOwner, newOwnerID)
+			}
+			return fmt.Errorf("failed to get new owner: %w", err)
+		}
+		if status != model.StatusActive || role == model.RoleCandidate {
+			return fmt.Errorf("%w: %s", ErrInvalidOwner, newOwnerID)
+		}
+
+		now := time.Now().UTC()
+		if role != model.RoleAdmin && role != model.RoleSuperAdmin {
+			_, err = tx.Exec(ctx,
+				`UPDATE users SET role = $1, updated_at = $2 WHERE id = $3`,
+				model.RoleAdmin, now, newOwnerID,
+			)
+			if err != nil {
+				return fmt.Errorf("failed to promote new owner: %w", err)
+			}
+		}
+
+		_, err = tx.Exec(ctx,
+			`UPDATE organizations SET owner_id = $1, updated_at = $2 WHERE id = $3`,
+			newOwnerID, now, organizationID,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to transfer organization ownership: %w", err)
+		}
+
+		return nil
+	})
+}
+
 // Delete deletes an organization
 func (r *OrganizationRepository) Delete(ctx context.Context, id string) error {
 	// Start a transaction to ensure atomicity
@@ -194,13 +445,13 @@ func (r *OrganizationRepository) Delete(ctx context.Context, id string) error {
 		}
 
 		// Delete the organization
-		_, err = tx.Exec(ctx, "DELETE FROM organizations WHERE id = $1", id)
+		tag, err := tx.Exec(ctx, "DELETE FROM organizations WHERE id = $1", id)
 		if err != nil {
-			if err == pgx.ErrNoRows {
-				return database.ErrRecordNotFound
-			}
 			return fmt.Errorf("failed to delete organization: %w", err)
 		}
+		if tag.RowsAffected() == 0 {
+			return database.ErrRecordNotFound
+		}
 
 		return nil
 	})
@@ -209,11 +460,7 @@ func (r *OrganizationRepository) Delete(ctx context.Context, id string) error {
 // List retrieves a list of organizations with pagination
 func (r *OrganizationRepository) List(ctx context.Context, limit, offset int) ([]*model.Organization, error) {
 	query := `
-		SELECT id, name, subscription_plan, subscription_status, contact_email, contact_phone, billing_email,
-		       billing_address, logo_url, website_url,
-		       subscription_start_date, subscription_end_date, payment_due_date,
-		       last_payment_date, payment_method, payment_method_details, auto_renew, email_reminders,
-		       created_at, updated_at
+		SELECT ` + organizationColumns + `
 		FROM organizations
 		ORDER BY name ASC
 		LIMIT $1 OFFSET $2
@@ -277,6 +524,11 @@ func (r *OrganizationRepository) GetUserCount(ctx context.Context, organizationI
 
 // scanOrganization is a helper function to scan a row into an Organization struct
 func (r *OrganizationRepository) scanOrganization(row pgx.Rows) (*model.Organization, error) {
+	return scanOrganizationRow(row)
+}
+
+// scanOrganizationRow scans a row selected with organizationColumns
+func scanOrganizationRow(row pgx.Row) (*model.Organization, error) {
 	var org model.Organization
 
 	err := row.Scan(
@@ -290,6 +542,7 @@ func (r *OrganizationRepository) scanOrganization(row pgx.Rows) (*model.Organiza
 		&org.BillingAddress,
 		&org.LogoURL,
 		&org.WebsiteURL,
+		&org.OwnerID,
 		&org.SubscriptionStartDate,
 		&org.SubscriptionEndDate,
 		&org.PaymentDueDate,
@@ -298,6 +551,8 @@ func (r *OrganizationRepository) scanOrganization(row pgx.Rows) (*model.Organiza
 		&org.PaymentMethodDetails,
 		&org.AutoRenew,
 		&org.EmailReminders,
+		&org.EmailLocale,
+		&org.BillingCustomerID,
 		&org.CreatedAt,
 		&org.UpdatedAt,
 	)
@@ -0,0 +1,160 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/database"
+	"github.com/cstanislawski/qualifyd/pkg/events"
+	"github.com/jackc/pgx/v5"
+)
+
+// OutboxRepository handles database operations for the domain event outbox
+// and the consumers' processed event log
+type OutboxRepository struct {
+	db dbtx
+}
+
+// NewOutboxRepository creates a new OutboxRepository instance
+func NewOutboxRepository(db *database.Database) *OutboxRepository {
+	return &OutboxRepository{
+		db: db,
+	}
+}
+
+// WithTx returns a copy of the repository that runs its queries inside tx
+func (r *OutboxRepository) WithTx(tx pgx.Tx) *OutboxRepository {
+	return &OutboxRepository{
+		db: tx,
+	}
+}
+
+// Add stores an event of organizationID in the outbox. Run it inside the
+// transaction of the change the event describes, see WithTx.
+func (r *OutboxRepository) Add(ctx context.Context, organizationID string, e events.Event) error {
+	return addOutboxEvent(ctx, r.db, organizationID, e)
+}
+
+// PublishPending implements events.OutboxStore
+func (r *OutboxRepository) PublishPending(ctx context.Context, limit int, publish func(ctx context.Context, e *events.Envelope) error) (int, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		SELECT id, idempotency_key, event_type, aggregate_type, aggregate_id,
+		       COALESCE(organization_id::text, ''), payload, occurred_at
+		FROM outbox_events
+		WHERE published_at IS NULL
+		ORDER BY occurred_at
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	`, limit)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list pending outbox events: %w", err)
+	}
+	pending, err := database.ScanRowsIntoSlice(ctx, rows, func(rows pgx.Rows) (*events.Envelope, error) {
+		var e events.Envelope
+		err := rows.Scan(&e.ID, &e.IdempotencyKey, &e.Type, &e.AggregateType, &e.AggregateID,
+			&e.OrganizationID, &e.Payload, &e.OccurredAt)
+		return &e, err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to scan pending outbox events: %w", err)
+	}
+
+	published := 0
+	var publishErr error
+	for _, e := range pending {
+		if publishErr = publish(ctx, e); publishErr != nil {
+			_, err := tx.Exec(ctx,
+				`UPDATE outbox_events SET attempts = attempts + 1, last_error = $2 WHERE id = $1`,
+				e.ID, publishErr.Error())
+			if err != nil {
+				return published, fmt.Errorf("failed to record outbox publish failure: %w", err)
+			}
+			break
+		}
+
+		_, err := tx.Exec(ctx,
+			`UPDATE outbox_events SET attempts = attempts + 1, last_error = NULL, published_at = $2 WHERE id = $1`,
+			e.ID, time.Now().UTC())
+		if err != nil {
+			return published, fmt.Errorf("failed to mark outbox event published: %w", err)
+		}
+		published++
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	if publishErr != nil {
+		return published, fmt.Errorf("failed to publish outbox event: %w", publishErr)
+	}
+	return published, nil
+}
+
+// DeletePublished implements events.OutboxStore. It also forgets events the
+// consumers processed before the given time, which bounds how long a
+// duplicate is recognized.
+func (r *OutboxRepository) DeletePublished(ctx context.Context, before time.Time) (int64, error) {
+	tag, err := r.db.Exec(ctx, `DELETE FROM outbox_events WHERE published_at < $1`, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete published outbox events: %w", err)
+	}
+	if _, err := r.db.Exec(ctx, `DELETE FROM processed_events WHERE processed_at < $1`, before); err != nil {
+		return 0, fmt.Errorf("failed to delete processed events: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// IsProcessed implements events.ProcessedStore
+func (r *OutboxRepository) IsProcessed(ctx context.Context, consumer, idempotencyKey string) (bool, error) {
+	var exists bool
+	err := r.db.QueryRow(ctx,
+		`SELECT EXISTS(SELECT 1 FROM processed_events WHERE consumer = $1 AND idempotency_key = $2)`,
+		consumer, idempotencyKey,
+	).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check processed event: %w", err)
+	}
+	return exists, nil
+}
+
+// MarkProcessed implements events.ProcessedStore
+func (r *OutboxRepository) MarkProcessed(ctx context.Context, consumer, idempotencyKey string) error {
+	_, err := r.db.Exec(ctx, `
+		INSERT INTO processed_events (consumer, idempotency_key, processed_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (consumer, idempotency_key) DO NOTHING
+	`, consumer, idempotencyKey, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to mark event processed: %w", err)
+	}
+	return nil
+}
+
+// addOutboxEvent stores an event in the outbox with db, which should be the
+// transaction of the change the event describes. An event whose idempotency
+// key is already stored is ignored.
+func addOutboxEvent(ctx context.Context, db dbtx, organizationID string, e events.Event) error {
+	envelope, err := events.NewEnvelope(organizationID, e)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(ctx, `
+		INSERT INTO outbox_events (id, idempotency_key, event_type, aggregate_type, aggregate_id,
+		                           organization_id, payload, occurred_at)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, '')::uuid, $7, $8)
+		ON CONFLICT (idempotency_key) DO NOTHING
+	`, envelope.ID, envelope.IdempotencyKey, envelope.Type, envelope.AggregateType, envelope.AggregateID,
+		envelope.OrganizationID, envelope.Payload, envelope.OccurredAt)
+	if err != nil {
+		return fmt.Errorf("failed to store %s event: %w", envelope.Type, err)
+	}
+	return nil
+}
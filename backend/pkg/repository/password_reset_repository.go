@@ -0,0 +1,135 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/database"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// PasswordResetRepository handles database operations for password reset
+// tokens and the requests for them
+type PasswordResetRepository struct {
+	db dbtx
+}
+
+// NewPasswordResetRepository creates a new PasswordResetRepository instance
+func NewPasswordResetRepository(db *database.Database) *PasswordResetRepository {
+	return &PasswordResetRepository{
+		db: db,
+	}
+}
+
+// CountRequests counts the reset requests made since since for email and
+// from ip
+func (r *PasswordResetRepository) CountRequests(ctx context.Context, email, ip string, since time.Time) (byEmail, byIP int, err error) {
+	err = r.db.QueryRow(ctx, `
+		SELECT COUNT(*) FILTER (WHERE email = $1), COUNT(*) FILTER (WHERE ip_address = $2)
+		FROM password_reset_requests
+		WHERE created_at > $3 AND (email = $1 OR ip_address = $2)
+	`, email, ip, since).Scan(&byEmail, &byIP)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to count password reset requests: %w", err)
+	}
+	return byEmail, byIP, nil
+}
+
+// RecordRequest records a reset request for email from ip
+func (r *PasswordResetRepository) RecordRequest(ctx context.Context, email, ip string) error {
+	_, err := r.db.Exec(ctx, `
+		INSERT INTO password_reset_requests (email, ip_address, created_at)
+		VALUES ($1, $2, $3)
+	`, email, ip, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to record password reset request: %w", err)
+	}
+	return nil
+}
+
+// CreateToken stores a new reset token
+func (r *PasswordResetRepository) CreateToken(ctx context.Context, token *model.PasswordResetToken) error {
+	if token.ID == "" {
+		token.ID = uuid.New().String()
+	}
+	token.CreatedAt = time.Now().UTC()
+
+	_, err := r.db.Exec(ctx, `
+		INSERT INTO password_reset_tokens (id, user_id, token_hash, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, token.ID, token.UserID, token.TokenHash, token.ExpiresAt, token.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create password reset token: %w", err)
+	}
+	return nil
+}
+
+// ResetPassword uses the reset token with tokenHash, which must belong to
+// userID, to set the user's password. The user's other unused tokens are
+// used up with it, and the user is signed out of every session. It returns ErrResetTokenInvalid if
+// the token was already used, expired, or its user is not active.
+func (r *PasswordResetRepository) ResetPassword(ctx context.Context, userID, tokenHash, passwordHash string) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	now := time.Now().UTC()
+
+	var tokenID string
+	err = tx.QueryRow(ctx, `
+		UPDATE password_reset_tokens t
+		SET used_at = $3
+		FROM users u
+		WHERE t.user_id = u.id AND t.user_id = $1 AND t.token_hash = $2
+		  AND t.used_at IS NULL AND t.expires_at > $3 AND u.status = $4
+		RETURNING t.id
+	`, userID, tokenHash, now, model.StatusActive).Scan(&tokenID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return ErrResetTokenInvalid
+		}
+		return fmt.Errorf("failed to use password reset token: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE users
+		SET password_hash = $2, password_changed_at = $3, updated_at = $3
+		WHERE id = $1
+	`, userID, passwordHash, now)
+	if err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE password_reset_tokens SET used_at = $2 WHERE user_id = $1 AND used_at IS NULL
+	`, userID, now)
+	if err != nil {
+		return fmt.Errorf("failed to invalidate password reset tokens: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE sessions SET revoked_at = $2, revoked_reason = $3 WHERE user_id = $1 AND revoked_at IS NULL
+	`, userID, now, model.SessionRevokedPasswordReset)
+	if err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+
+	return tx.Commit(ctx)
+}
+
+// DeleteBefore deletes the reset requests made before before and the tokens
+// that expired before it
+func (r *PasswordResetRepository) DeleteBefore(ctx context.Context, before time.Time) error {
+	if _, err := r.db.Exec(ctx, `DELETE FROM password_reset_requests WHERE created_at < $1`, before); err != nil {
+		return fmt.Errorf("failed to delete password reset requests: %w", err)
+	}
+	if _, err := r.db.Exec(ctx, `DELETE FROM password_reset_tokens WHERE expires_at < $1`, before); err != nil {
+		return fmt.Errorf("failed to delete password reset tokens: %w", err)
+	}
+	return nil
+}
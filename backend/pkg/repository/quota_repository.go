@@ -0,0 +1,461 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/database"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// quotaColumns is the column list shared by every organization_quotas query
+const quotaColumns = `
+	id, organization_id,
+	max_users, current_users,
+	max_task_templates, current_task_templates,
+	max_environment_templates, current_environment_templates,
+	max_assessment_templates, current_assessment_templates,
+	max_assessments_per_month, assessments_this_month,
+	max_concurrent_environments, current_active_environments,
+	included_environment_minutes, used_environment_minutes,
+	max_environment_runtime_minutes, max_snapshot_retention_days,
+	period_start, period_end,
+	updated_at, created_at
+`
+
+// QuotaRepository handles database operations for organization quotas
+type QuotaRepository struct {
+	db dbtx
+}
+
+// NewQuotaRepository creates a new QuotaRepository instance
+func NewQuotaRepository(db *database.Database) *QuotaRepository {
+	return &QuotaRepository{
+		db: db,
+	}
+}
+
+// WithTx returns a copy of the repository that runs its queries inside tx
+func (r *QuotaRepository) WithTx(tx pgx.Tx) *QuotaRepository {
+	return &QuotaRepository{
+		db: tx,
+	}
+}
+
+// GetByOrganization retrieves the quota of an organization
+func (r *QuotaRepository) GetByOrganization(ctx context.Context, organizationID string) (*model.OrganizationQuota, error) {
+	query := `SELECT ` + quotaColumns + ` FROM organization_quotas WHERE organization_id = $1`
+
+	quota, err := scanQuota(r.db.QueryRow(ctx, query, organizationID))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, database.ErrRecordNotFound
+		}
+		return nil, fmt.Errorf("failed to get organization quota: %w", err)
+	}
+
+	return quota, nil
+}
+
+// GetForUpdate retrieves the quota of an organization and locks the row until
+// the transaction ends. It must be called on a repository bound with WithTx;
+// concurrent reservations for the same organization then run one at a time.
+func (r *QuotaRepository) GetForUpdate(ctx context.Context, organizationID string) (*model.OrganizationQuota, error) {
+	query := `SELECT ` + quotaColumns + ` FROM organization_quotas WHERE organization_id = $1 FOR UPDATE`
+
+	quota, err := scanQuota(r.db.QueryRow(ctx, query, organizationID))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, database.ErrRecordNotFound
+		}
+		return nil, fmt.Errorf("failed to lock organization quota: %w", err)
+	}
+
+	return quota, nil
+}
+
+// AddEnvironmentMinutes adds metered environment minutes to the organization's usage
+func (r *QuotaRepository) AddEnvironmentMinutes(ctx context.Context, organizationID string, minutes int) error {
+	query := `
+		UPDATE organization_quotas
+		SET used_environment_minutes = used_environment_minutes + $2,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE organization_id = $1
+	`
+
+	if _, err := r.db.Exec(ctx, query, organizationID, minutes); err != nil {
+		return fmt.Errorf("failed to add environment minutes: %w", err)
+	}
+
+	return nil
+}
+
+// ListDueForReset retrieves the quotas whose billing period ended before now
+func (r *QuotaRepository) ListDueForReset(ctx context.Context, now time.Time) ([]*model.OrganizationQuota, error) {
+	query := `SELECT ` + quotaColumns + ` FROM organization_quotas WHERE period_end <= $1 ORDER BY period_end`
+
+	rows, err := r.db.Query(ctx, query, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list quotas due for reset: %w", err)
+	}
+	defer rows.Close()
+
+	var quotas []*model.OrganizationQuota
+	for rows.Next() {
+		quota, err := scanQuota(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan organization quota: %w", err)
+		}
+		quotas = append(quotas, quota)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating organization quotas: %w", err)
+	}
+
+	return quotas, nil
+}
+
+// ResetPeriod starts a new billing period and zeroes the monthly counters. It
+// returns false without changing anything if the period was already reset
+// since quota was read.
+func (r *QuotaRepository) ResetPeriod(ctx context.Context, quota *model.OrganizationQuota, periodStart, periodEnd time.Time) (bool, error) {
+	query := `
+		UPDATE organization_quotas
+		SET assessments_this_month = 0,
+		    used_environment_minutes = 0,
+		    period_start = $3,
+		    period_end = $4,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE organization_id = $1 AND period_end = $2
+	`
+
+	tag, err := r.db.Exec(ctx, query, quota.OrganizationID, quota.PeriodEnd, periodStart, periodEnd)
+	if err != nil {
+		return false, fmt.Errorf("failed to reset organization quota: %w", err)
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+// createQuota inserts a quota row inside an existing transaction
+func createQuota(ctx context.Context, tx pgx.Tx, quota *model.OrganizationQuota) error {
+	query := `
+		INSERT INTO organization_quotas (` + quotaColumns + `)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22)
+	`
+
+	if quota.ID == "" {
+		quota.ID = uuid.New().String()
+	}
+	now := time.Now().UTC()
+	if quota.CreatedAt.IsZero() {
+		quota.CreatedAt = now
+	}
+	if quota.UpdatedAt.IsZero() {
+		quota.UpdatedAt = now
+	}
+
+	_, err := tx.Exec(ctx, query,
+		quota.ID,
+		quota.OrganizationID,
+		quota.MaxUsers,
+		quota.CurrentUsers,
+		quota.MaxTaskTemplates,
+		quota.CurrentTaskTemplates,
+		quota.MaxEnvironmentTemplates,
+		quota.CurrentEnvironmentTemplates,
+		quota.MaxAssessmentTemplates,
+		quota.CurrentAssessmentTemplates,
+		quota.MaxAssessmentsPerMonth,
+		quota.AssessmentsThisMonth,
+		quota.MaxConcurrentEnvironments,
+		quota.CurrentActiveEnvironments,
+		quota.IncludedEnvironmentMinutes,
+		quota.UsedEnvironmentMinutes,
+		quota.MaxEnvironmentRuntimeMinutes,
+		quota.MaxSnapshotRetentionDays,
+		quota.PeriodStart,
+		quota.PeriodEnd,
+		quota.UpdatedAt,
+		quota.CreatedAt,
+	)
+	if err != nil {
+		if database.IsUniqueViolation(err) {
+			return fmt.Errorf("organization already has a quota: %w", database.ErrDuplicateKey)
+		}
+		return fmt.Errorf("failed to create organization quota: %w", err)
+	}
+
+	return nil
+}
+
+// ApplyLimits sets the limits of an organization's quota, keeping its usage counters
+func (r *QuotaRepository) ApplyLimits(ctx context.Context, organizationID string, limits model.QuotaLimits) error {
+	return applyQuotaLimits(ctx, r.db, organizationID, limits)
+}
+
+// GetOverrides retrieves an organization's custom quota limits
+func (r *QuotaRepository) GetOverrides(ctx context.Context, organizationID string) (*model.QuotaOverrides, error) {
+	query := `
+		SELECT organization_id, max_users, max_task_templates, max_environment_templates, max_assessment_templates,
+		       max_assessments_per_month, max_concurrent_environments, included_environment_minutes,
+		       max_environment_runtime_minutes, max_snapshot_retention_days, COALESCE(note, ''),
+		       COALESCE(updated_by::text, ''), updated_at
+		FROM organization_quota_overrides
+		WHERE organization_id = $1
+	`
+
+	var o model.QuotaOverrides
+	err := r.db.QueryRow(ctx, query, organizationID).Scan(
+		&o.OrganizationID,
+		&o.MaxUsers,
+		&o.MaxTaskTemplates,
+		&o.MaxEnvironmentTemplates,
+		&o.MaxAssessmentTemplates,
+		&o.MaxAssessmentsPerMonth,
+		&o.MaxConcurrentEnvironments,
+		&o.IncludedEnvironmentMinutes,
+		&o.MaxEnvironmentRuntimeMinutes,
+		&o.MaxSnapshotRetentionDays,
+		&o.Note,
+		&o.UpdatedBy,
+		&o.UpdatedAt,
+	)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, database.ErrRecordNotFound
+		}
+		return nil, fmt.Errorf("failed to get quota overrides: %w", err)
+	}
+
+	return &o, nil
+}
+
+// SaveOverrides creates or replaces an organization's custom quota limits
+func (r *QuotaRepository) SaveOverrides(ctx context.Context, o *model.QuotaOverrides) error {
+	query := `
+		INSERT INTO organization_quota_overrides (organization_id, max_users, max_task_templates,
+		                                          max_environment_templates, max_assessment_templates,
+		                                          max_assessments_per_month, max_concurrent_environments,
+		                                          included_environment_minutes, max_environment_runtime_minutes,
+		                                          max_snapshot_retention_days, note, updated_by, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NULLIF($11, ''), NULLIF($12, '')::uuid, $13)
+		ON CONFLICT (organization_id) DO UPDATE
+		SET max_users = EXCLUDED.max_users,
+		    max_task_templates = EXCLUDED.max_task_templates,
+		    max_environment_templates = EXCLUDED.max_environment_templates,
+		    max_assessment_templates = EXCLUDED.max_assessment_templates,
+		    max_assessments_per_month = EXCLUDED.max_assessments_per_month,
+		    max_concurrent_environments = EXCLUDED.max_concurrent_environments,
+		    included_environment_minutes = EXCLUDED.included_environment_minutes,
+		    max_environment_runtime_minutes = EXCLUDED.max_environment_runtime_minutes,
+		    max_snapshot_retention_days = EXCLUDED.max_snapshot_retention_days,
+		    note = EXCLUDED.note,
+		    updated_by = EXCLUDED.updated_by,
+		    updated_at = EXCLUDED.updated_at
+	`
+
+	if o.UpdatedAt.IsZero() {
+		o.UpdatedAt = time.Now().UTC()
+	}
+
+	_, err := r.db.Exec(ctx, query,
+		o.OrganizationID,
+		o.MaxUsers,
+		o.MaxTaskTemplates,
+		o.MaxEnvironmentTemplates,
+		o.MaxAssessmentTemplates,
+		o.MaxAssessmentsPerMonth,
+		o.MaxConcurrentEnvironments,
+		o.IncludedEnvironmentMinutes,
+		o.MaxEnvironmentRuntimeMinutes,
+		o.MaxSnapshotRetentionDays,
+		o.Note,
+		o.UpdatedBy,
+		o.UpdatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save quota overrides: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteOverrides removes an organization's custom quota limits
+func (r *QuotaRepository) DeleteOverrides(ctx context.Context, organizationID string) error {
+	query := `DELETE FROM organization_quota_overrides WHERE organization_id = $1`
+
+	if _, err := r.db.Exec(ctx, query, organizationID); err != nil {
+		return fmt.Errorf("failed to delete quota overrides: %w", err)
+	}
+
+	return nil
+}
+
+// CreateChange records an entry in the quota change audit trail
+func (r *QuotaRepository) CreateChange(ctx context.Context, change *model.QuotaChange) error {
+	return createQuotaChange(ctx, r.db, change)
+}
+
+// ListChanges retrieves a page of an organization's quota change audit trail, newest first
+func (r *QuotaRepository) ListChanges(ctx context.Context, organizationID string, params database.PaginationParams) (database.PaginatedResponse, error) {
+	query := `
+		SELECT id, organization_id, type, from_plan, to_plan, previous_limits, new_limits, overages,
+		       COALESCE(billing_record_id::text, ''), COALESCE(changed_by::text, ''), COALESCE(note, ''), created_at
+		FROM quota_changes
+		WHERE organization_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2 OFFSET $3
+	`
+
+	rows, err := r.db.Query(ctx, query, organizationID, params.Limit(), params.Offset())
+	if err != nil {
+		return database.PaginatedResponse{}, fmt.Errorf("failed to list quota changes: %w", err)
+	}
+
+	changes, err := database.ScanRowsIntoSlice(ctx, rows, func(rows pgx.Rows) (*model.QuotaChange, error) {
+		var change model.QuotaChange
+		err := rows.Scan(
+			&change.ID,
+			&change.OrganizationID,
+			&change.Type,
+			&change.FromPlan,
+			&change.ToPlan,
+			&change.PreviousLimits,
+			&change.NewLimits,
+			&change.Overages,
+			&change.BillingRecordID,
+			&change.ChangedBy,
+			&change.Note,
+			&change.CreatedAt,
+		)
+		return &change, err
+	})
+	if err != nil {
+		return database.PaginatedResponse{}, fmt.Errorf("failed to scan quota changes: %w", err)
+	}
+
+	var count int
+	if err := r.db.QueryRow(ctx, `SELECT COUNT(*) FROM quota_changes WHERE organization_id = $1`, organizationID).Scan(&count); err != nil {
+		return database.PaginatedResponse{}, fmt.Errorf("failed to count quota changes: %w", err)
+	}
+
+	return database.NewPaginatedResponse(changes, params, count), nil
+}
+
+// applyQuotaLimits sets the limits of an organization's quota, keeping its usage counters
+func applyQuotaLimits(ctx context.Context, db dbtx, organizationID string, limits model.QuotaLimits) error {
+	query := `
+		UPDATE organization_quotas
+		SET max_users = $2,
+		    max_task_templates = $3,
+		    max_environment_templates = $4,
+		    max_assessment_templates = $5,
+		    max_assessments_per_month = $6,
+		    max_concurrent_environments = $7,
+		    included_environment_minutes = $8,
+		    max_environment_runtime_minutes = $9,
+		    max_snapshot_retention_days = $10,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE organization_id = $1
+	`
+
+	tag, err := db.Exec(ctx, query,
+		organizationID,
+		limits.MaxUsers,
+		limits.MaxTaskTemplates,
+		limits.MaxEnvironmentTemplates,
+		limits.MaxAssessmentTemplates,
+		limits.MaxAssessmentsPerMonth,
+		limits.MaxConcurrentEnvironments,
+		limits.IncludedEnvironmentMinutes,
+		limits.MaxEnvironmentRuntimeMinutes,
+		limits.MaxSnapshotRetentionDays,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to apply quota limits: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return database.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// createQuotaChange inserts a quota change audit entry
+func createQuotaChange(ctx context.Context, db dbtx, change *model.QuotaChange) error {
+	query := `
+		INSERT INTO quota_changes (id, organization_id, type, from_plan, to_plan, previous_limits, new_limits,
+		                           overages, billing_record_id, changed_by, note, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, '')::uuid, NULLIF($10, '')::uuid, NULLIF($11, ''), $12)
+	`
+
+	if change.ID == "" {
+		change.ID = uuid.New().String()
+	}
+	if change.CreatedAt.IsZero() {
+		change.CreatedAt = time.Now().UTC()
+	}
+	if change.Overages == nil {
+		change.Overages = []model.QuotaOverage{}
+	}
+
+	_, err := db.Exec(ctx, query,
+		change.ID,
+		change.OrganizationID,
+		change.Type,
+		change.FromPlan,
+		change.ToPlan,
+		change.PreviousLimits,
+		change.NewLimits,
+		change.Overages,
+		change.BillingRecordID,
+		change.ChangedBy,
+		change.Note,
+		change.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record quota change: %w", err)
+	}
+
+	return nil
+}
+
+// scanQuota scans a row selected with quotaColumns into an OrganizationQuota
+func scanQuota(row pgx.Row) (*model.OrganizationQuota, error) {
+	var quota model.OrganizationQuota
+
+	err := row.Scan(
+		&quota.ID,
+		&quota.OrganizationID,
+		&quota.MaxUsers,
+		&quota.CurrentUsers,
+		&quota.MaxTaskTemplates,
+		&quota.CurrentTaskTemplates,
+		&quota.MaxEnvironmentTemplates,
+		&quota.CurrentEnvironmentTemplates,
+		&quota.MaxAssessmentTemplates,
+		&quota.CurrentAssessmentTemplates,
+		&quota.MaxAssessmentsPerMonth,
+		&quota.AssessmentsThisMonth,
+		&quota.MaxConcurrentEnvironments,
+		&quota.CurrentActiveEnvironments,
+		&quota.IncludedEnvironmentMinutes,
+		&quota.UsedEnvironmentMinutes,
+		&quota.MaxEnvironmentRuntimeMinutes,
+		&quota.MaxSnapshotRetentionDays,
+		&quota.PeriodStart,
+		&quota.PeriodEnd,
+		&quota.UpdatedAt,
+		&quota.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &quota, nil
+}
@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/config"
+	"github.com/cstanislawski/qualifyd/pkg/database"
+	"github.com/cstanislawski/qualifyd/pkg/ratelimit"
+	"github.com/jackc/pgx/v5"
+)
+
+// RateLimitRepository keeps rate limit buckets and failed sign-ins in
+// Postgres, so that every replica enforces the same limits
+type RateLimitRepository struct {
+	db dbtx
+}
+
+// NewRateLimitRepository creates a new RateLimitRepository instance
+func NewRateLimitRepository(db *database.Database) *RateLimitRepository {
+	return &RateLimitRepository{
+		db: db,
+	}
+}
+
+// Take implements ratelimit.Store
+func (r *RateLimitRepository) Take(ctx context.Context, key string, limit config.RateLimit, now time.Time) (bool, time.Duration, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Creating a missing bucket first leaves concurrent requests something
+	// to lock, so that they take turns with it
+	_, err = tx.Exec(ctx, `
+		INSERT INTO rate_limit_buckets (key, tokens, updated_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (key) DO NOTHING
+	`, key, float64(limit.Requests), now)
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to create rate limit bucket: %w", err)
+	}
+
+	var b ratelimit.Bucket
+	err = tx.QueryRow(ctx, `SELECT tokens, updated_at FROM rate_limit_buckets WHERE key = $1 FOR UPDATE`, key).
+		Scan(&b.Tokens, &b.UpdatedAt)
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to get rate limit bucket: %w", err)
+	}
+
+	ok, retryAfter := b.Take(limit, now)
+
+	_, err = tx.Exec(ctx, `UPDATE rate_limit_buckets SET tokens = $2, updated_at = $3 WHERE key = $1`,
+		key, b.Tokens, b.UpdatedAt)
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to update rate limit bucket: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return false, 0, fmt.Errorf("failed to commit rate limit bucket: %w", err)
+	}
+
+	return ok, retryAfter, nil
+}
+
+// AddFailure implements ratelimit.Store
+func (r *RateLimitRepository) AddFailure(ctx context.Context, account string, since, now time.Time) (int, error) {
+	var failures int
+	err := r.db.QueryRow(ctx, `
+		INSERT INTO login_failures (account, failures, last_failure_at)
+		VALUES ($1, 1, $3)
+		ON CONFLICT (account) DO UPDATE SET
+			failures = CASE WHEN login_failures.last_failure_at < $2 THEN 1 ELSE login_failures.failures + 1 END,
+			last_failure_at = $3
+		RETURNING failures
+	`, account, since, now).Scan(&failures)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count failed sign-in: %w", err)
+	}
+
+	return failures, nil
+}
+
+// Failures implements ratelimit.Store
+func (r *RateLimitRepository) Failures(ctx context.Context, account string, since time.Time) (int, time.Time, error) {
+	var failures int
+	var last time.Time
+	err := r.db.QueryRow(ctx, `
+		SELECT failures, last_failure_at FROM login_failures
+		WHERE account = $1 AND last_failure_at >= $2
+	`, account, since).Scan(&failures, &last)
+	if err == pgx.ErrNoRows {
+		return 0, time.Time{}, nil
+	}
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("failed to get failed sign-ins: %w", err)
+	}
+
+	return failures, last, nil
+}
+
+// ResetFailures implements ratelimit.Store
+func (r *RateLimitRepository) ResetFailures(ctx context.Context, account string) error {
+	if _, err := r.db.Exec(ctx, `DELETE FROM login_failures WHERE account = $1`, account); err != nil {
+		return fmt.Errorf("failed to reset failed sign-ins: %w", err)
+	}
+	return nil
+}
+
+// DeleteExpired implements ratelimit.Store
+func (r *RateLimitRepository) DeleteExpired(ctx context.Context, before time.Time) error {
+	if _, err := r.db.Exec(ctx, `DELETE FROM rate_limit_buckets WHERE updated_at < $1`, before); err != nil {
+		return fmt.Errorf("failed to delete expired rate limit buckets: %w", err)
+	}
+	if _, err := r.db.Exec(ctx, `DELETE FROM login_failures WHERE last_failure_at < $1`, before); err != nil {
+		return fmt.Errorf("failed to delete expired failed sign-ins: %w", err)
+	}
+	return nil
+}
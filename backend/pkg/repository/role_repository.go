@@ -0,0 +1,268 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/database"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// customRoleColumns is the column list shared by every custom_roles query
+const customRoleColumns = `
+	id, organization_id, name, COALESCE(description, ''), permissions, created_at, updated_at
+`
+
+// roleAssignmentColumns is the column list shared by every role_assignments
+// query, joined with custom_roles as c for the permissions of custom roles
+const roleAssignmentColumns = `
+	a.id, a.user_id, COALESCE(a.role, ''), COALESCE(a.custom_role_id::text, ''),
+	COALESCE(a.resource_type, ''), COALESCE(a.resource_id::text, ''),
+	COALESCE(c.permissions, '{}'), COALESCE(a.created_by::text, ''), a.created_at
+`
+
+// RoleRepository handles database operations for organizations' custom roles
+// and the roles assigned to users
+type RoleRepository struct {
+	db dbtx
+}
+
+// NewRoleRepository creates a new RoleRepository instance
+func NewRoleRepository(db *database.Database) *RoleRepository {
+	return &RoleRepository{
+		db: db,
+	}
+}
+
+// ListCustomRoles retrieves an organization's custom roles by name
+func (r *RoleRepository) ListCustomRoles(ctx context.Context, organizationID string) ([]*model.CustomRole, error) {
+	query := `SELECT ` + customRoleColumns + ` FROM custom_roles WHERE organization_id = $1 ORDER BY name`
+
+	rows, err := r.db.Query(ctx, query, organizationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list custom roles: %w", err)
+	}
+
+	return database.ScanRowsIntoSlice(ctx, rows, func(rows pgx.Rows) (*model.CustomRole, error) {
+		return scanCustomRole(rows)
+	})
+}
+
+// GetCustomRole retrieves one of an organization's custom roles
+func (r *RoleRepository) GetCustomRole(ctx context.Context, organizationID, id string) (*model.CustomRole, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, database.ErrRecordNotFound
+	}
+
+	query := `SELECT ` + customRoleColumns + ` FROM custom_roles WHERE id = $1 AND organization_id = $2`
+
+	role, err := scanCustomRole(r.db.QueryRow(ctx, query, id, organizationID))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, database.ErrRecordNotFound
+		}
+		return nil, fmt.Errorf("failed to get custom role: %w", err)
+	}
+
+	return role, nil
+}
+
+// CreateCustomRole inserts a custom role. It returns ErrDuplicateRole if the
+// organization has a role with the same name.
+func (r *RoleRepository) CreateCustomRole(ctx context.Context, role *model.CustomRole) error {
+	query := `
+		INSERT INTO custom_roles (id, organization_id, name, description, permissions, created_at, updated_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7)
+	`
+
+	if role.ID == "" {
+		role.ID = uuid.New().String()
+	}
+	now := time.Now().UTC()
+	role.CreatedAt = now
+	role.UpdatedAt = now
+
+	_, err := r.db.Exec(ctx, query,
+		role.ID,
+		role.OrganizationID,
+		role.Name,
+		role.Description,
+		role.Permissions,
+		role.CreatedAt,
+		role.UpdatedAt,
+	)
+	if err != nil {
+		if database.IsUniqueViolation(err) {
+			return ErrDuplicateRole
+		}
+		return fmt.Errorf("failed to create custom role: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateCustomRole updates a custom role's name, description and
+// permissions. It returns ErrDuplicateRole if the organization has another
+// role with the new name.
+func (r *RoleRepository) UpdateCustomRole(ctx context.Context, role *model.CustomRole) error {
+	query := `
+		UPDATE custom_roles SET name = $3, description = NULLIF($4, ''), permissions = $5, updated_at = $6
+		WHERE id = $1 AND organization_id = $2
+		RETURNING created_at
+	`
+
+	role.UpdatedAt = time.Now().UTC()
+
+	err := r.db.QueryRow(ctx, query,
+		role.ID,
+		role.OrganizationID,
+		role.Name,
+		role.Description,
+		role.Permissions,
+		role.UpdatedAt,
+	).Scan(&role.CreatedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return database.ErrRecordNotFound
+		}
+		if database.IsUniqueViolation(err) {
+			return ErrDuplicateRole
+		}
+		return fmt.Errorf("failed to update custom role: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteCustomRole deletes a custom role together with its assignments
+func (r *RoleRepository) DeleteCustomRole(ctx context.Context, organizationID, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return database.ErrRecordNotFound
+	}
+
+	tag, err := r.db.Exec(ctx, `DELETE FROM custom_roles WHERE id = $1 AND organization_id = $2`, id, organizationID)
+	if err != nil {
+		return fmt.Errorf("failed to delete custom role: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return database.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// ListAssignments retrieves the roles assigned to a user, with the
+// permissions of their custom roles
+func (r *RoleRepository) ListAssignments(ctx context.Context, userID string) ([]*model.RoleAssignment, error) {
+	query := `
+		SELECT ` + roleAssignmentColumns + `
+		FROM role_assignments a
+		LEFT JOIN custom_roles c ON c.id = a.custom_role_id
+		WHERE a.user_id = $1
+		ORDER BY a.created_at
+	`
+
+	rows, err := r.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list role assignments: %w", err)
+	}
+
+	return database.ScanRowsIntoSlice(ctx, rows, func(rows pgx.Rows) (*model.RoleAssignment, error) {
+		return scanRoleAssignment(rows)
+	})
+}
+
+// CreateAssignment assigns a user a role. It returns ErrRoleAlreadyAssigned
+// if they already hold it on the same resource.
+func (r *RoleRepository) CreateAssignment(ctx context.Context, a *model.RoleAssignment) error {
+	query := `
+		INSERT INTO role_assignments (id, user_id, role, custom_role_id, resource_type, resource_id, created_by, created_at)
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, '')::uuid, NULLIF($5, ''), NULLIF($6, '')::uuid, NULLIF($7, '')::uuid, $8)
+	`
+
+	if a.ID == "" {
+		a.ID = uuid.New().String()
+	}
+	a.CreatedAt = time.Now().UTC()
+
+	_, err := r.db.Exec(ctx, query,
+		a.ID,
+		a.UserID,
+		a.Role,
+		a.CustomRoleID,
+		a.ResourceType,
+		a.ResourceID,
+		a.CreatedBy,
+		a.CreatedAt,
+	)
+	if err != nil {
+		if database.IsUniqueViolation(err) {
+			return ErrRoleAlreadyAssigned
+		}
+		return fmt.Errorf("failed to create role assignment: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteAssignment removes one of a user's role assignments
+func (r *RoleRepository) DeleteAssignment(ctx context.Context, userID, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return database.ErrRecordNotFound
+	}
+
+	tag, err := r.db.Exec(ctx, `DELETE FROM role_assignments WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete role assignment: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return database.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// scanCustomRole scans a row selected with customRoleColumns
+func scanCustomRole(row pgx.Row) (*model.CustomRole, error) {
+	var role model.CustomRole
+
+	err := row.Scan(
+		&role.ID,
+		&role.OrganizationID,
+		&role.Name,
+		&role.Description,
+		&role.Permissions,
+		&role.CreatedAt,
+		&role.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &role, nil
+}
+
+// scanRoleAssignment scans a row selected with roleAssignmentColumns
+func scanRoleAssignment(row pgx.Row) (*model.RoleAssignment, error) {
+	var a model.RoleAssignment
+
+	err := row.Scan(
+		&a.ID,
+		&a.UserID,
+		&a.Role,
+		&a.CustomRoleID,
+		&a.ResourceType,
+		&a.ResourceID,
+		&a.Permissions,
+		&a.CreatedBy,
+		&a.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &a, nil
+}
@@ -0,0 +1,270 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/database"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// SessionRepository handles database operations for sessions and their
+// refresh tokens
+type SessionRepository struct {
+	db dbtx
+}
+
+// NewSessionRepository creates a new SessionRepository instance
+func NewSessionRepository(db *database.Database) *SessionRepository {
+	return &SessionRepository{
+		db: db,
+	}
+}
+
+// Create inserts a new session with its first refresh token, if it has one
+func (r *SessionRepository) Create(ctx context.Context, session *model.Session, token *model.RefreshToken) error {
+	if session.ID == "" {
+		session.ID = uuid.New().String()
+	}
+	now := time.Now().UTC()
+	session.CreatedAt = now
+	session.LastUsedAt = now
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO sessions (id, user_id, assessment_id, user_agent, ip_address, created_at, last_used_at, expires_at)
+		VALUES ($1, $2, NULLIF($3, '')::uuid, $4, $5, $6, $6, $7)
+	`, session.ID, session.UserID, session.AssessmentID, session.UserAgent, session.IPAddress, now, session.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+
+	if token != nil {
+		token.SessionID = session.ID
+		if err := createRefreshToken(ctx, tx, token); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// Rotate exchanges the refresh token with tokenHash, which must belong to
+// sessionID, for next. Using a token that was already exchanged revokes the
+// session, since either the token or its successor was stolen, and returns
+// ErrRefreshTokenReused. It returns ErrRefreshTokenInvalid if the token is
+// unknown or expired or the session is revoked.
+func (r *SessionRepository) Rotate(ctx context.Context, sessionID, tokenHash string, next *model.RefreshToken) (*model.Session, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	now := time.Now().UTC()
+
+	var tokenID string
+	var usedAt *time.Time
+	var expiresAt time.Time
+	err = tx.QueryRow(ctx, `
+		SELECT id, used_at, expires_at
+		FROM refresh_tokens
+		WHERE token_hash = $1 AND session_id = $2
+		FOR UPDATE
+	`, tokenHash, sessionID).Scan(&tokenID, &usedAt, &expiresAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrRefreshTokenInvalid
+		}
+		return nil, fmt.Errorf("failed to get refresh token: %w", err)
+	}
+
+	session, err := scanSession(tx.QueryRow(ctx, `
+		SELECT `+sessionColumns+`
+		FROM sessions
+		WHERE id = $1
+		FOR UPDATE
+	`, sessionID))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrRefreshTokenInvalid
+		}
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+	if session.RevokedAt != nil {
+		return nil, ErrRefreshTokenInvalid
+	}
+
+	if usedAt != nil {
+		_, err := tx.Exec(ctx, `
+			UPDATE sessions SET revoked_at = $2, revoked_reason = $3 WHERE id = $1
+		`, sessionID, now, model.SessionRevokedTokenReuse)
+		if err != nil {
+			return nil, fmt.Errorf("failed to revoke session: %w", err)
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return nil, fmt.Errorf("failed to commit transaction: %w", err)
+		}
+		return nil, ErrRefreshTokenReused
+	}
+	if !expiresAt.After(now) || !session.ExpiresAt.After(now) {
+		return nil, ErrRefreshTokenInvalid
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE refresh_tokens SET used_at = $2 WHERE id = $1`, tokenID, now); err != nil {
+		return nil, fmt.Errorf("failed to use refresh token: %w", err)
+	}
+	next.SessionID = sessionID
+	if err := createRefreshToken(ctx, tx, next); err != nil {
+		return nil, err
+	}
+
+	session.LastUsedAt = now
+	session.ExpiresAt = next.ExpiresAt
+	_, err = tx.Exec(ctx, `
+		UPDATE sessions SET last_used_at = $2, expires_at = $3 WHERE id = $1
+	`, sessionID, session.LastUsedAt, session.ExpiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update session: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return session, nil
+}
+
+// IsActive reports whether a session is neither revoked nor expired and its
+// user is active
+func (r *SessionRepository) IsActive(ctx context.Context, sessionID string) (bool, error) {
+	if _, err := uuid.Parse(sessionID); err != nil {
+		return false, nil
+	}
+
+	var active bool
+	err := r.db.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1
+			FROM sessions s
+			JOIN users u ON u.id = s.user_id
+			WHERE s.id = $1 AND s.revoked_at IS NULL AND s.expires_at > $2 AND u.status = $3
+		)
+	`, sessionID, time.Now().UTC(), model.StatusActive).Scan(&active)
+	if err != nil {
+		return false, fmt.Errorf("failed to check session: %w", err)
+	}
+	return active, nil
+}
+
+// ListActive retrieves a user's sessions that are neither revoked nor
+// expired, most recently used first
+func (r *SessionRepository) ListActive(ctx context.Context, userID string) ([]*model.Session, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT `+sessionColumns+`
+		FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > $2
+		ORDER BY last_used_at DESC
+	`, userID, time.Now().UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	return database.ScanRowsIntoSlice(ctx, rows, func(rows pgx.Rows) (*model.Session, error) {
+		return scanSession(rows)
+	})
+}
+
+// Revoke revokes one of a user's sessions. It returns ErrSessionNotFound if
+// the user has no such session that is not revoked yet.
+func (r *SessionRepository) Revoke(ctx context.Context, userID, sessionID, reason string) error {
+	if _, err := uuid.Parse(sessionID); err != nil {
+		return ErrSessionNotFound
+	}
+
+	tag, err := r.db.Exec(ctx, `
+		UPDATE sessions SET revoked_at = $3, revoked_reason = $4
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+	`, sessionID, userID, time.Now().UTC(), reason)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// RevokeAll revokes every session of a user except exceptSessionID, which
+// may be empty, and returns how many were revoked
+func (r *SessionRepository) RevokeAll(ctx context.Context, userID, reason, exceptSessionID string) (int64, error) {
+	tag, err := r.db.Exec(ctx, `
+		UPDATE sessions SET revoked_at = $2, revoked_reason = $3
+		WHERE user_id = $1 AND revoked_at IS NULL AND id::text <> $4
+	`, userID, time.Now().UTC(), reason, exceptSessionID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// DeleteExpired deletes the sessions that expired or were revoked before
+// before, with their refresh tokens
+func (r *SessionRepository) DeleteExpired(ctx context.Context, before time.Time) error {
+	_, err := r.db.Exec(ctx, `
+		DELETE FROM sessions WHERE expires_at < $1 OR revoked_at < $1
+	`, before)
+	if err != nil {
+		return fmt.Errorf("failed to delete expired sessions: %w", err)
+	}
+	return nil
+}
+
+// sessionColumns are the columns scanSession expects
+const sessionColumns = `id, user_id, COALESCE(assessment_id::text, ''), user_agent, ip_address,
+		       created_at, last_used_at, expires_at, revoked_at, COALESCE(revoked_reason, '')`
+
+// scanSession scans a row of sessionColumns into a Session
+func scanSession(row pgx.Row) (*model.Session, error) {
+	var s model.Session
+	err := row.Scan(
+		&s.ID,
+		&s.UserID,
+		&s.AssessmentID,
+		&s.UserAgent,
+		&s.IPAddress,
+		&s.CreatedAt,
+		&s.LastUsedAt,
+		&s.ExpiresAt,
+		&s.RevokedAt,
+		&s.RevokedReason,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// createRefreshToken inserts a refresh token within tx
+func createRefreshToken(ctx context.Context, tx pgx.Tx, token *model.RefreshToken) error {
+	if token.ID == "" {
+		token.ID = uuid.New().String()
+	}
+	token.CreatedAt = time.Now().UTC()
+
+	_, err := tx.Exec(ctx, `
+		INSERT INTO refresh_tokens (id, session_id, token_hash, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, token.ID, token.SessionID, token.TokenHash, token.ExpiresAt, token.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create refresh token: %w", err)
+	}
+	return nil
+}
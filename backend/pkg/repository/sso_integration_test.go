@@ -0,0 +1,78 @@
+//go:build integration
+
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/model"
+)
+
+func TestSSODomainClaims(t *testing.T) {
+	db := setupTenantDatabase(t)
+	ctx := context.Background()
+	owner := createTenantFixture(t, db, "sso-owner")
+	other := createTenantFixture(t, db, "sso-other")
+	repo := NewSSORepository(db)
+	domain := fmt.Sprintf("claimed-%d.example.com", time.Now().UnixNano())
+
+	oidcProvider := func(orgID string) *model.OIDCProvider {
+		return &model.OIDCProvider{
+			OrganizationID: orgID,
+			Issuer:         "https://idp.example.com",
+			ClientID:       "qualifyd",
+			ClientSecret:   "secret",
+			GroupsClaim:    "groups",
+			SSOPolicy:      model.SSOPolicy{AllowedDomains: []string{domain}, Enabled: true},
+		}
+	}
+
+	if err := repo.SaveOIDCProvider(ctx, oidcProvider(owner.orgID)); err != nil {
+		t.Fatalf("SaveOIDCProvider: %v", err)
+	}
+	// The owner may use the domain for its SAML provider too
+	samlProvider := &model.SAMLProvider{
+		OrganizationID: owner.orgID,
+		Metadata:       "<EntityDescriptor/>",
+		IdPEntityID:    "https://idp.example.com",
+		IdPSSOURL:      "https://idp.example.com/sso",
+		SSOPolicy:      model.SSOPolicy{AllowedDomains: []string{domain}, Enabled: true},
+	}
+	if err := repo.SaveSAMLProvider(ctx, samlProvider); err != nil {
+		t.Fatalf("SaveSAMLProvider: %v", err)
+	}
+
+	if err := repo.SaveOIDCProvider(ctx, oidcProvider(other.orgID)); !errors.Is(err, ErrSSODomainClaimed) {
+		t.Fatalf("SaveOIDCProvider of a claimed domain: error = %v, want %v", err, ErrSSODomainClaimed)
+	}
+	if _, err := repo.GetOIDCProvider(ctx, other.orgID); err == nil {
+		t.Error("provider claiming another organization's domain was saved")
+	}
+
+	providers, err := repo.ListOIDCProvidersByDomain(ctx, domain)
+	if err != nil {
+		t.Fatalf("ListOIDCProvidersByDomain: %v", err)
+	}
+	if len(providers) != 1 || providers[0].OrganizationID != owner.orgID {
+		t.Errorf("providers for %s = %v, want the owner's", domain, providers)
+	}
+
+	// The claim is kept while one of the owner's providers allows the domain
+	if err := repo.DeleteOIDCProvider(ctx, owner.orgID); err != nil {
+		t.Fatalf("DeleteOIDCProvider: %v", err)
+	}
+	if err := repo.SaveOIDCProvider(ctx, oidcProvider(other.orgID)); !errors.Is(err, ErrSSODomainClaimed) {
+		t.Fatalf("SaveOIDCProvider of a domain still claimed: error = %v, want %v", err, ErrSSODomainClaimed)
+	}
+
+	if err := repo.DeleteSAMLProvider(ctx, owner.orgID); err != nil {
+		t.Fatalf("DeleteSAMLProvider: %v", err)
+	}
+	if err := repo.SaveOIDCProvider(ctx, oidcProvider(other.orgID)); err != nil {
+		t.Fatalf("SaveOIDCProvider of a released domain: %v", err)
+	}
+}
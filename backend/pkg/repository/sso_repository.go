@@ -0,0 +1,479 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/database"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/jackc/pgx/v5"
+)
+
+// oidcProviderColumns is the column list shared by every oidc_providers query
+const oidcProviderColumns = `
+	organization_id, issuer, client_id, client_secret, allowed_domains, groups_claim, role_mappings,
+	COALESCE(default_role, ''), enabled, password_login_disabled, created_at, updated_at
+`
+
+// samlProviderColumns is the column list shared by every saml_providers query
+const samlProviderColumns = `
+	organization_id, metadata_xml, idp_entity_id, idp_sso_url, email_attribute, first_name_attribute,
+	last_name_attribute, groups_attribute, allowed_domains, role_mappings, COALESCE(default_role, ''), enabled,
+	password_login_disabled, created_at, updated_at
+`
+
+// providerDomainsQuery selects the email domains the single sign-on
+// providers of organization $1 allow
+const providerDomainsQuery = `
+	SELECT unnest(allowed_domains) FROM oidc_providers WHERE organization_id = $1
+	UNION
+	SELECT unnest(allowed_domains) FROM saml_providers WHERE organization_id = $1
+`
+
+// SSORepository handles database operations for organizations' single
+// sign-on providers and the sign-ins started with them
+type SSORepository struct {
+	db dbtx
+}
+
+// NewSSORepository creates a new SSORepository instance
+func NewSSORepository(db *database.Database) *SSORepository {
+	return &SSORepository{
+		db: db,
+	}
+}
+
+// GetOIDCProvider retrieves an organization's OpenID Connect provider
+func (r *SSORepository) GetOIDCProvider(ctx context.Context, organizationID string) (*model.OIDCProvider, error) {
+	query := `SELECT ` + oidcProviderColumns + ` FROM oidc_providers WHERE organization_id = $1`
+
+	p, err := scanOIDCProvider(r.db.QueryRow(ctx, query, organizationID))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, database.ErrRecordNotFound
+		}
+		return nil, fmt.Errorf("failed to get OIDC provider: %w", err)
+	}
+
+	return p, nil
+}
+
+// ListOIDCProvidersByDomain retrieves the enabled OpenID Connect provider
+// that allows an email domain, of the organization that claimed it
+func (r *SSORepository) ListOIDCProvidersByDomain(ctx context.Context, domain string) ([]*model.OIDCProvider, error) {
+	query := `SELECT ` + oidcProviderColumns + ` FROM oidc_providers
+		WHERE enabled AND allowed_domains @> ARRAY[$1]
+		  AND organization_id = (SELECT organization_id FROM sso_domains WHERE domain = $1)
+	`
+
+	rows, err := r.db.Query(ctx, query, strings.ToLower(domain))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list OIDC providers: %w", err)
+	}
+
+	return database.ScanRowsIntoSlice(ctx, rows, func(rows pgx.Rows) (*model.OIDCProvider, error) {
+		return scanOIDCProvider(rows)
+	})
+}
+
+// SaveOIDCProvider creates or replaces an organization's OpenID Connect
+// provider
+func (r *SSORepository) SaveOIDCProvider(ctx context.Context, p *model.OIDCProvider) error {
+	query := `
+		INSERT INTO oidc_providers (organization_id, issuer, client_id, client_secret, allowed_domains, groups_claim,
+		                            role_mappings, default_role, enabled, password_login_disabled, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''), $9, $10, $11, $11)
+		ON CONFLICT (organization_id) DO UPDATE
+		SET issuer = EXCLUDED.issuer, client_id = EXCLUDED.client_id, client_secret = EXCLUDED.client_secret,
+		    allowed_domains = EXCLUDED.allowed_domains, groups_claim = EXCLUDED.groups_claim,
+		    role_mappings = EXCLUDED.role_mappings, default_role = EXCLUDED.default_role,
+		    enabled = EXCLUDED.enabled, password_login_disabled = EXCLUDED.password_login_disabled,
+		    updated_at = EXCLUDED.updated_at
+		RETURNING created_at
+	`
+
+	for i, d := range p.AllowedDomains {
+		p.AllowedDomains[i] = strings.ToLower(strings.TrimSpace(d))
+	}
+	if p.RoleMappings == nil {
+		p.RoleMappings = map[string]string{}
+	}
+	p.UpdatedAt = time.Now().UTC()
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx, query,
+		p.OrganizationID,
+		p.Issuer,
+		p.ClientID,
+		p.ClientSecret,
+		p.AllowedDomains,
+		p.GroupsClaim,
+		p.RoleMappings,
+		p.DefaultRole,
+		p.Enabled,
+		p.PasswordLoginDisabled,
+		p.UpdatedAt,
+	).Scan(&p.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save OIDC provider: %w", err)
+	}
+	if err := claimDomains(ctx, tx, p.OrganizationID); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// DeleteOIDCProvider deletes an organization's OpenID Connect provider
+func (r *SSORepository) DeleteOIDCProvider(ctx context.Context, organizationID string) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `DELETE FROM oidc_providers WHERE organization_id = $1`, organizationID)
+	if err != nil {
+		return fmt.Errorf("failed to delete OIDC provider: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return database.ErrRecordNotFound
+	}
+	if err := releaseDomains(ctx, tx, organizationID); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// GetSAMLProvider retrieves an organization's SAML identity provider
+func (r *SSORepository) GetSAMLProvider(ctx context.Context, organizationID string) (*model.SAMLProvider, error) {
+	query := `SELECT ` + samlProviderColumns + ` FROM saml_providers WHERE organization_id = $1`
+
+	p, err := scanSAMLProvider(r.db.QueryRow(ctx, query, organizationID))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, database.ErrRecordNotFound
+		}
+		return nil, fmt.Errorf("failed to get SAML provider: %w", err)
+	}
+
+	return p, nil
+}
+
+// ListSAMLProvidersByDomain retrieves the enabled SAML identity provider
+// that allows an email domain, of the organization that claimed it
+func (r *SSORepository) ListSAMLProvidersByDomain(ctx context.Context, domain string) ([]*model.SAMLProvider, error) {
+	query := `SELECT ` + samlProviderColumns + ` FROM saml_providers
+		WHERE enabled AND allowed_domains @> ARRAY[$1]
+		  AND organization_id = (SELECT organization_id FROM sso_domains WHERE domain = $1)
+	`
+
+	rows, err := r.db.Query(ctx, query, strings.ToLower(domain))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list SAML providers: %w", err)
+	}
+
+	return database.ScanRowsIntoSlice(ctx, rows, func(rows pgx.Rows) (*model.SAMLProvider, error) {
+		return scanSAMLProvider(rows)
+	})
+}
+
+// SaveSAMLProvider creates or replaces an organization's SAML identity
+// provider
+func (r *SSORepository) SaveSAMLProvider(ctx context.Context, p *model.SAMLProvider) error {
+	query := `
+		INSERT INTO saml_providers (organization_id, metadata_xml, idp_entity_id, idp_sso_url, email_attribute,
+		                            first_name_attribute, last_name_attribute, groups_attribute, allowed_domains,
+		                            role_mappings, default_role, enabled, password_login_disabled, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NULLIF($11, ''), $12, $13, $14, $14)
+		ON CONFLICT (organization_id) DO UPDATE
+		SET metadata_xml = EXCLUDED.metadata_xml, idp_entity_id = EXCLUDED.idp_entity_id,
+		    idp_sso_url = EXCLUDED.idp_sso_url, email_attribute = EXCLUDED.email_attribute,
+		    first_name_attribute = EXCLUDED.first_name_attribute, last_name_attribute = EXCLUDED.last_name_attribute,
+		    groups_attribute = EXCLUDED.groups_attribute, allowed_domains = EXCLUDED.allowed_domains,
+		    role_mappings = EXCLUDED.role_mappings, default_role = EXCLUDED.default_role,
+		    enabled = EXCLUDED.enabled, password_login_disabled = EXCLUDED.password_login_disabled,
+		    updated_at = EXCLUDED.updated_at
+		RETURNING created_at
+	`
+
+	for i, d := range p.AllowedDomains {
+		p.AllowedDomains[i] = strings.ToLower(strings.TrimSpace(d))
+	}
+	if p.RoleMappings == nil {
+		p.RoleMappings = map[string]string{}
+	}
+	p.UpdatedAt = time.Now().UTC()
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx, query,
+		p.OrganizationID,
+		p.Metadata,
+		p.IdPEntityID,
+		p.IdPSSOURL,
+		p.EmailAttribute,
+		p.FirstNameAttribute,
+		p.LastNameAttribute,
+		p.GroupsAttribute,
+		p.AllowedDomains,
+		p.RoleMappings,
+		p.DefaultRole,
+		p.Enabled,
+		p.PasswordLoginDisabled,
+		p.UpdatedAt,
+	).Scan(&p.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save SAML provider: %w", err)
+	}
+	if err := claimDomains(ctx, tx, p.OrganizationID); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// DeleteSAMLProvider deletes an organization's SAML identity provider
+func (r *SSORepository) DeleteSAMLProvider(ctx context.Context, organizationID string) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `DELETE FROM saml_providers WHERE organization_id = $1`, organizationID)
+	if err != nil {
+		return fmt.Errorf("failed to delete SAML provider: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return database.ErrRecordNotFound
+	}
+	if err := releaseDomains(ctx, tx, organizationID); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// releaseDomains releases the email domains an organization claimed that
+// its single sign-on providers no longer allow
+func releaseDomains(ctx context.Context, tx pgx.Tx, organizationID string) error {
+	_, err := tx.Exec(ctx, `
+		DELETE FROM sso_domains
+		WHERE organization_id = $1 AND domain NOT IN (`+providerDomainsQuery+`)
+	`, organizationID)
+	if err != nil {
+		return fmt.Errorf("failed to release SSO domains: %w", err)
+	}
+	return nil
+}
+
+// claimDomains makes the email domains an organization's single sign-on
+// providers allow its own, and releases those they no longer allow. It
+// returns ErrSSODomainClaimed, naming the domain, if another organization
+// claimed one first.
+func claimDomains(ctx context.Context, tx pgx.Tx, organizationID string) error {
+	if err := releaseDomains(ctx, tx, organizationID); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(ctx, `
+		INSERT INTO sso_domains (domain, organization_id)
+		SELECT domain, $1 FROM (`+providerDomainsQuery+`) AS provider_domains(domain)
+		ON CONFLICT (domain) DO NOTHING
+	`, organizationID)
+	if err != nil {
+		return fmt.Errorf("failed to claim SSO domains: %w", err)
+	}
+
+	// Claims that lost to another organization's were left out above
+	var domain string
+	err = tx.QueryRow(ctx, `
+		SELECT domain FROM sso_domains
+		WHERE domain IN (`+providerDomainsQuery+`) AND organization_id <> $1
+		ORDER BY domain
+		LIMIT 1
+	`, organizationID).Scan(&domain)
+	switch {
+	case err == nil:
+		return fmt.Errorf("%w: %s", ErrSSODomainClaimed, domain)
+	case errors.Is(err, pgx.ErrNoRows):
+		return nil
+	default:
+		return fmt.Errorf("failed to check SSO domains: %w", err)
+	}
+}
+
+// PasswordLoginDisabled reports whether an organization's users must sign in
+// through its identity provider
+func (r *SSORepository) PasswordLoginDisabled(ctx context.Context, organizationID string) (bool, error) {
+	if organizationID == "" {
+		return false, nil
+	}
+
+	var disabled bool
+	err := r.db.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM oidc_providers WHERE organization_id = $1 AND enabled AND password_login_disabled
+		) OR EXISTS (
+			SELECT 1 FROM saml_providers WHERE organization_id = $1 AND enabled AND password_login_disabled
+		)
+	`, organizationID).Scan(&disabled)
+	if err != nil {
+		return false, fmt.Errorf("failed to check password login: %w", err)
+	}
+
+	return disabled, nil
+}
+
+// CreateLoginState records a sign-in started at an identity provider
+func (r *SSORepository) CreateLoginState(ctx context.Context, s *model.SSOLoginState) error {
+	s.CreatedAt = time.Now().UTC()
+
+	_, err := r.db.Exec(ctx, `
+		INSERT INTO sso_login_states (state_hash, organization_id, protocol, code_verifier, nonce, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, s.StateHash, s.OrganizationID, s.Protocol, s.CodeVerifier, s.Nonce, s.ExpiresAt, s.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create SSO login state: %w", err)
+	}
+
+	return nil
+}
+
+// ConsumeLoginState removes and returns the sign-in started with protocol
+// with the given state hash. It returns ErrSSOStateInvalid if there is no
+// such sign-in or it expired.
+func (r *SSORepository) ConsumeLoginState(ctx context.Context, stateHash, protocol string) (*model.SSOLoginState, error) {
+	var s model.SSOLoginState
+	err := r.db.QueryRow(ctx, `
+		DELETE FROM sso_login_states
+		WHERE state_hash = $1 AND protocol = $2
+		RETURNING state_hash, organization_id, protocol, code_verifier, nonce, expires_at, created_at
+	`, stateHash, protocol).Scan(&s.StateHash, &s.OrganizationID, &s.Protocol, &s.CodeVerifier, &s.Nonce, &s.ExpiresAt, &s.CreatedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrSSOStateInvalid
+		}
+		return nil, fmt.Errorf("failed to consume SSO login state: %w", err)
+	}
+	if !s.ExpiresAt.After(time.Now().UTC()) {
+		return nil, ErrSSOStateInvalid
+	}
+
+	return &s, nil
+}
+
+// DeleteExpiredLoginStates deletes the sign-ins and sign-in codes that
+// expired before before
+func (r *SSORepository) DeleteExpiredLoginStates(ctx context.Context, before time.Time) error {
+	_, err := r.db.Exec(ctx, `DELETE FROM sso_login_states WHERE expires_at < $1`, before)
+	if err != nil {
+		return fmt.Errorf("failed to delete expired SSO login states: %w", err)
+	}
+	_, err = r.db.Exec(ctx, `DELETE FROM sso_sign_in_codes WHERE expires_at < $1`, before)
+	if err != nil {
+		return fmt.Errorf("failed to delete expired SSO sign-in codes: %w", err)
+	}
+	return nil
+}
+
+// CreateSignInCode records a one-time code, by its hash, that signs userID in
+// until expiresAt
+func (r *SSORepository) CreateSignInCode(ctx context.Context, codeHash, userID string, expiresAt time.Time) error {
+	_, err := r.db.Exec(ctx, `
+		INSERT INTO sso_sign_in_codes (code_hash, user_id, expires_at, created_at)
+		VALUES ($1, $2, $3, $4)
+	`, codeHash, userID, expiresAt, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to create SSO sign-in code: %w", err)
+	}
+
+	return nil
+}
+
+// ConsumeSignInCode removes the sign-in code with the given hash and returns
+// the ID of the user it signs in. It returns ErrSSOCodeInvalid if there is no
+// such code or it expired.
+func (r *SSORepository) ConsumeSignInCode(ctx context.Context, codeHash string) (string, error) {
+	var userID string
+	var expiresAt time.Time
+	err := r.db.QueryRow(ctx, `
+		DELETE FROM sso_sign_in_codes
+		WHERE code_hash = $1
+		RETURNING user_id, expires_at
+	`, codeHash).Scan(&userID, &expiresAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return "", ErrSSOCodeInvalid
+		}
+		return "", fmt.Errorf("failed to consume SSO sign-in code: %w", err)
+	}
+	if !expiresAt.After(time.Now().UTC()) {
+		return "", ErrSSOCodeInvalid
+	}
+
+	return userID, nil
+}
+
+// scanOIDCProvider scans a row of oidcProviderColumns into an OIDCProvider
+func scanOIDCProvider(row pgx.Row) (*model.OIDCProvider, error) {
+	var p model.OIDCProvider
+	err := row.Scan(
+		&p.OrganizationID,
+		&p.Issuer,
+		&p.ClientID,
+		&p.ClientSecret,
+		&p.AllowedDomains,
+		&p.GroupsClaim,
+		&p.RoleMappings,
+		&p.DefaultRole,
+		&p.Enabled,
+		&p.PasswordLoginDisabled,
+		&p.CreatedAt,
+		&p.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// scanSAMLProvider scans a row of samlProviderColumns into a SAMLProvider
+func scanSAMLProvider(row pgx.Row) (*model.SAMLProvider, error) {
+	var p model.SAMLProvider
+	err := row.Scan(
+		&p.OrganizationID,
+		&p.Metadata,
+		&p.IdPEntityID,
+		&p.IdPSSOURL,
+		&p.EmailAttribute,
+		&p.FirstNameAttribute,
+		&p.LastNameAttribute,
+		&p.GroupsAttribute,
+		&p.AllowedDomains,
+		&p.RoleMappings,
+		&p.DefaultRole,
+		&p.Enabled,
+		&p.PasswordLoginDisabled,
+		&p.CreatedAt,
+		&p.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
@@ -2,6 +2,7 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -10,9 +11,11 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-// TaskRepository handles database operations for task templates
+// TaskRepository handles database operations for task templates. Every query
+// is filtered by the organization the repository is scoped to, see ForTenant.
 type TaskRepository struct {
-	db *pgxpool.Pool
+	db             dbtx
+	organizationID string
 }
 
 // NewTaskRepository creates a new task repository
@@ -22,8 +25,46 @@ func NewTaskRepository(db *pgxpool.Pool) *TaskRepository {
 	}
 }
 
-// Create inserts a new task template
+// ForTenant returns a copy of the repository scoped to the organization bound to ctx
+func (r *TaskRepository) ForTenant(ctx context.Context) (*TaskRepository, error) {
+	organizationID, err := TenantFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.ForOrganization(organizationID), nil
+}
+
+// ForOrganization returns a copy of the repository scoped to the given organization
+func (r *TaskRepository) ForOrganization(organizationID string) *TaskRepository {
+	return &TaskRepository{
+		db:             r.db,
+		organizationID: organizationID,
+	}
+}
+
+// WithTx returns a copy of the repository that runs its queries inside tx
+func (r *TaskRepository) WithTx(tx pgx.Tx) *TaskRepository {
+	return &TaskRepository{
+		db:             tx,
+		organizationID: r.organizationID,
+	}
+}
+
+// tenant returns the organization the repository is scoped to
+func (r *TaskRepository) tenant() (string, error) {
+	if r.organizationID == "" {
+		return "", ErrTenantRequired
+	}
+	return r.organizationID, nil
+}
+
+// Create inserts a new task template into the repository's organization
 func (r *TaskRepository) Create(ctx context.Context, task *model.TaskTemplate) error {
+	organizationID, err := r.tenant()
+	if err != nil {
+		return err
+	}
+
 	query := `
 		INSERT INTO task_templates (
 			organization_id, name, description, instructions,
@@ -35,6 +76,7 @@ func (r *TaskRepository) Create(ctx context.Context, task *model.TaskTemplate) e
 	`
 
 	now := time.Now().UTC()
+	task.OrganizationID = organizationID
 	task.CreatedAt = now
 	task.UpdatedAt = now
 
@@ -47,24 +89,29 @@ func (r *TaskRepository) Create(ctx context.Context, task *model.TaskTemplate) e
 
 // GetByID retrieves a task template by ID
 func (r *TaskRepository) GetByID(ctx context.Context, id string) (*model.TaskTemplate, error) {
+	organizationID, err := r.tenant()
+	if err != nil {
+		return nil, err
+	}
+
 	query := `
 		SELECT
 			id, organization_id, name, description, instructions,
 			time_limit, points, validation_script, readiness_script,
 			environment_setup_script, created_by, created_at, updated_at
 		FROM task_templates
-		WHERE id = $1
+		WHERE id = $1 AND organization_id = $2
 	`
 
 	task := &model.TaskTemplate{}
-	err := r.db.QueryRow(ctx, query, id).Scan(
+	err = r.db.QueryRow(ctx, query, id, organizationID).Scan(
 		&task.ID, &task.OrganizationID, &task.Name, &task.Description, &task.Instructions,
 		&task.TimeLimit, &task.Points, &task.ValidationScript, &task.ReadinessScript,
 		&task.EnvironmentSetupScript, &task.CreatedBy, &task.CreatedAt, &task.UpdatedAt,
 	)
 	if err != nil {
-		if err == pgx.ErrNoRows {
-			return nil, fmt.Errorf("task template not found: %s", id)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %s", ErrTaskTemplateNotFound, id)
 		}
 		return nil, err
 	}
@@ -74,6 +121,11 @@ func (r *TaskRepository) GetByID(ctx context.Context, id string) (*model.TaskTem
 
 // Update updates an existing task template
 func (r *TaskRepository) Update(ctx context.Context, task *model.TaskTemplate) error {
+	organizationID, err := r.tenant()
+	if err != nil {
+		return err
+	}
+
 	query := `
 		UPDATE task_templates
 		SET
@@ -86,28 +138,50 @@ func (r *TaskRepository) Update(ctx context.Context, task *model.TaskTemplate) e
 			readiness_script = $7,
 			environment_setup_script = $8,
 			updated_at = $9
-		WHERE id = $10
+		WHERE id = $10 AND organization_id = $11
 	`
 
 	task.UpdatedAt = time.Now().UTC()
 
-	_, err := r.db.Exec(ctx, query,
+	tag, err := r.db.Exec(ctx, query,
 		task.Name, task.Description, task.Instructions,
 		task.TimeLimit, task.Points, task.ValidationScript, task.ReadinessScript,
-		task.EnvironmentSetupScript, task.UpdatedAt, task.ID,
+		task.EnvironmentSetupScript, task.UpdatedAt, task.ID, organizationID,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%w: %s", ErrTaskTemplateNotFound, task.ID)
+	}
+	return nil
 }
 
 // Delete deletes a task template
 func (r *TaskRepository) Delete(ctx context.Context, id string) error {
-	query := `DELETE FROM task_templates WHERE id = $1`
-	_, err := r.db.Exec(ctx, query, id)
-	return err
+	organizationID, err := r.tenant()
+	if err != nil {
+		return err
+	}
+
+	query := `DELETE FROM task_templates WHERE id = $1 AND organization_id = $2`
+	tag, err := r.db.Exec(ctx, query, id, organizationID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%w: %s", ErrTaskTemplateNotFound, id)
+	}
+	return nil
 }
 
-// ListByOrganization lists all task templates for an organization
-func (r *TaskRepository) ListByOrganization(ctx context.Context, organizationID string) ([]*model.TaskTemplate, error) {
+// List lists all task templates of the repository's organization
+func (r *TaskRepository) List(ctx context.Context) ([]*model.TaskTemplate, error) {
+	organizationID, err := r.tenant()
+	if err != nil {
+		return nil, err
+	}
+
 	query := `
 		SELECT
 			id, organization_id, name, description, instructions,
@@ -145,8 +219,13 @@ func (r *TaskRepository) ListByOrganization(ctx context.Context, organizationID
 	return tasks, nil
 }
 
-// Search searches for task templates by name or description
-func (r *TaskRepository) Search(ctx context.Context, organizationID, query string) ([]*model.TaskTemplate, error) {
+// Search searches the repository's organization for task templates by name or description
+func (r *TaskRepository) Search(ctx context.Context, query string) ([]*model.TaskTemplate, error) {
+	organizationID, err := r.tenant()
+	if err != nil {
+		return nil, err
+	}
+
 	sqlQuery := `
 		SELECT
 			id, organization_id, name, description, instructions,
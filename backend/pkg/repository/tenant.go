@@ -0,0 +1,42 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// tenantKey is the context key holding the caller's organization ID
+type tenantKey struct{}
+
+// WithTenant returns a copy of ctx bound to the given organization. The
+// authentication middleware calls this for every authenticated request so
+// that repositories can be scoped with ForTenant.
+func WithTenant(ctx context.Context, organizationID string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, organizationID)
+}
+
+// TenantFromContext returns the organization ID bound to ctx by WithTenant
+func TenantFromContext(ctx context.Context) (string, error) {
+	organizationID, _ := ctx.Value(tenantKey{}).(string)
+	if organizationID == "" {
+		return "", ErrTenantRequired
+	}
+	return organizationID, nil
+}
+
+// rowQuerier is implemented by both *pgxpool.Pool and pgx.Tx
+type rowQuerier interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// ownedBy reports whether the row with the given ID in table belongs to the
+// organization. table must be a constant, never user input.
+func ownedBy(ctx context.Context, q rowQuerier, table, id, organizationID string) (bool, error) {
+	var exists bool
+	query := `SELECT EXISTS (SELECT 1 FROM ` + table + ` WHERE id = $1 AND organization_id = $2)`
+	if err := q.QueryRow(ctx, query, id, organizationID).Scan(&exists); err != nil {
+		return false, err
+	}
+	return exists, nil
+}
@@ -0,0 +1,282 @@
+//go:build integration
+
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/config"
+	"github.com/cstanislawski/qualifyd/pkg/database"
+	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/rs/zerolog"
+)
+
+// Cross-tenant isolation tests. They need a disposable PostgreSQL database
+// configured through the usual DATABASE_* environment variables:
+//
+//	go test -tags integration ./pkg/repository/...
+
+// tenantFixture holds the rows created for one organization
+type tenantFixture struct {
+	orgID          string
+	candidateID    string
+	envTemplate    *model.EnvironmentTemplate
+	taskTemplate   *model.TaskTemplate
+	assessmentTmpl *model.AssessmentTemplate
+	assessment     *model.Assessment
+}
+
+func setupTenantDatabase(t *testing.T) *database.Database {
+	t.Helper()
+	ctx := context.Background()
+
+	cfg := config.Load()
+	db, err := database.New(ctx, &cfg.Database)
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	t.Cleanup(db.Close)
+
+	migrations := database.NewMigrationService(db, "../../migrations", logger.NewLogger(zerolog.Nop()))
+	if err := migrations.MigrateUp(ctx); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+
+	return db
+}
+
+func createTenantFixture(t *testing.T, db *database.Database, name string) *tenantFixture {
+	t.Helper()
+	ctx := context.Background()
+	suffix := fmt.Sprintf("%s-%d", name, time.Now().UnixNano())
+	f := &tenantFixture{}
+
+	err := db.QueryRow(ctx, `INSERT INTO organizations (name) VALUES ($1) RETURNING id`, "org-"+suffix).Scan(&f.orgID)
+	if err != nil {
+		t.Fatalf("Failed to create organization: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Exec(context.Background(), `DELETE FROM assessments WHERE candidate_id = $1`, f.candidateID)
+		db.Exec(context.Background(), `DELETE FROM users WHERE organization_id = $1`, f.orgID)
+		db.Exec(context.Background(), `DELETE FROM organizations WHERE id = $1`, f.orgID)
+	})
+
+	err = db.QueryRow(ctx, `
+		INSERT INTO users (email, password_hash, role, status, organization_id)
+		VALUES ($1, 'x', $2, 'active', $3)
+		RETURNING id
+	`, "candidate-"+suffix+"@example.com", model.RoleCandidate, f.orgID).Scan(&f.candidateID)
+	if err != nil {
+		t.Fatalf("Failed to create candidate: %v", err)
+	}
+
+	envs := NewEnvironmentRepository(db.Pool()).ForOrganization(f.orgID)
+	tasks := NewTaskRepository(db.Pool()).ForOrganization(f.orgID)
+	assessments := NewAssessmentRepository(db.Pool()).ForOrganization(f.orgID)
+
+	f.envTemplate = model.NewEnvironmentTemplate(f.orgID, "env-"+suffix, model.EnvironmentTypeLinux, model.EnvironmentSpecs{CPU: "1", Memory: "1Gi", Storage: "1Gi"})
+	f.envTemplate.Configuration = []byte(`{}`)
+	if err := envs.Create(ctx, f.envTemplate); err != nil {
+		t.Fatalf("Failed to create environment template: %v", err)
+	}
+
+	f.taskTemplate = model.NewTaskTemplate(f.orgID, "task-"+suffix, "do the thing", nil)
+	if err := tasks.Create(ctx, f.taskTemplate); err != nil {
+		t.Fatalf("Failed to create task template: %v", err)
+	}
+
+	f.assessmentTmpl = model.NewAssessmentTemplate(f.orgID, "assessment-"+suffix, f.envTemplate.ID, nil)
+	if err := assessments.CreateTemplate(ctx, f.assessmentTmpl); err != nil {
+		t.Fatalf("Failed to create assessment template: %v", err)
+	}
+	if err := assessments.AddTaskToTemplate(ctx, f.assessmentTmpl.ID, f.taskTemplate.ID, 0, 1); err != nil {
+		t.Fatalf("Failed to add task to assessment template: %v", err)
+	}
+
+	f.assessment = model.NewAssessment(f.assessmentTmpl.ID, f.candidateID, f.candidateID, time.Now().UTC())
+	if err := assessments.Create(ctx, f.assessment); err != nil {
+		t.Fatalf("Failed to create assessment: %v", err)
+	}
+	if err := assessments.CreateAssessmentTasks(ctx, f.assessment.ID, f.assessmentTmpl.ID); err != nil {
+		t.Fatalf("Failed to create assessment tasks: %v", err)
+	}
+
+	return f
+}
+
+func expectErr(t *testing.T, op string, err, want error) {
+	t.Helper()
+	if !errors.Is(err, want) {
+		t.Errorf("%s: expected %v, got: %v", op, want, err)
+	}
+}
+
+func TestCrossTenantReadsFail(t *testing.T) {
+	db := setupTenantDatabase(t)
+	ctx := context.Background()
+	a := createTenantFixture(t, db, "a")
+	b := createTenantFixture(t, db, "b")
+
+	// Repositories scoped to organization A, probing organization B's rows
+	envs := NewEnvironmentRepository(db.Pool()).ForOrganization(a.orgID)
+	tasks := NewTaskRepository(db.Pool()).ForOrganization(a.orgID)
+	assessments := NewAssessmentRepository(db.Pool()).ForOrganization(a.orgID)
+
+	_, err := envs.GetByID(ctx, b.envTemplate.ID)
+	expectErr(t, "EnvironmentRepository.GetByID", err, ErrEnvironmentTemplateNotFound)
+
+	_, err = tasks.GetByID(ctx, b.taskTemplate.ID)
+	expectErr(t, "TaskRepository.GetByID", err, ErrTaskTemplateNotFound)
+
+	_, err = assessments.GetTemplateByID(ctx, b.assessmentTmpl.ID)
+	expectErr(t, "AssessmentRepository.GetTemplateByID", err, ErrAssessmentTemplateNotFound)
+
+	_, err = assessments.GetByID(ctx, b.assessment.ID)
+	expectErr(t, "AssessmentRepository.GetByID", err, ErrAssessmentNotFound)
+
+	_, err = assessments.GetAssessmentWithTasksAndTemplate(ctx, b.assessment.ID)
+	expectErr(t, "AssessmentRepository.GetAssessmentWithTasksAndTemplate", err, ErrAssessmentNotFound)
+
+	templateTasks, err := assessments.GetTemplateTasks(ctx, b.assessmentTmpl.ID)
+	if err != nil || len(templateTasks) != 0 {
+		t.Errorf("GetTemplateTasks: expected no tasks from another tenant, got %d (err: %v)", len(templateTasks), err)
+	}
+
+	byCandidate, err := assessments.ListByCandidate(ctx, b.candidateID)
+	if err != nil || len(byCandidate) != 0 {
+		t.Errorf("ListByCandidate: expected no assessments from another tenant, got %d (err: %v)", len(byCandidate), err)
+	}
+
+	byTemplate, err := assessments.ListByTemplate(ctx, b.assessmentTmpl.ID)
+	if err != nil || len(byTemplate) != 0 {
+		t.Errorf("ListByTemplate: expected no assessments from another tenant, got %d (err: %v)", len(byTemplate), err)
+	}
+
+	// Lists only ever return the scoped organization's rows
+	envList, err := envs.List(ctx)
+	if err != nil {
+		t.Fatalf("EnvironmentRepository.List: %v", err)
+	}
+	for _, env := range envList {
+		if env.OrganizationID != a.orgID {
+			t.Errorf("EnvironmentRepository.List returned template %s of organization %s", env.ID, env.OrganizationID)
+		}
+	}
+
+	taskList, err := tasks.List(ctx)
+	if err != nil {
+		t.Fatalf("TaskRepository.List: %v", err)
+	}
+	for _, task := range taskList {
+		if task.OrganizationID != a.orgID {
+			t.Errorf("TaskRepository.List returned template %s of organization %s", task.ID, task.OrganizationID)
+		}
+	}
+
+	templateList, err := assessments.ListTemplates(ctx)
+	if err != nil {
+		t.Fatalf("AssessmentRepository.ListTemplates: %v", err)
+	}
+	for _, tmpl := range templateList {
+		if tmpl.OrganizationID != a.orgID {
+			t.Errorf("AssessmentRepository.ListTemplates returned template %s of organization %s", tmpl.ID, tmpl.OrganizationID)
+		}
+	}
+
+	active, err := assessments.ListActive(ctx)
+	if err != nil {
+		t.Fatalf("AssessmentRepository.ListActive: %v", err)
+	}
+	for _, assessment := range active {
+		if assessment.ID == b.assessment.ID {
+			t.Errorf("AssessmentRepository.ListActive returned assessment %s of another tenant", assessment.ID)
+		}
+	}
+}
+
+func TestCrossTenantWritesFail(t *testing.T) {
+	db := setupTenantDatabase(t)
+	ctx := context.Background()
+	a := createTenantFixture(t, db, "a")
+	b := createTenantFixture(t, db, "b")
+
+	envs := NewEnvironmentRepository(db.Pool()).ForOrganization(a.orgID)
+	tasks := NewTaskRepository(db.Pool()).ForOrganization(a.orgID)
+	assessments := NewAssessmentRepository(db.Pool()).ForOrganization(a.orgID)
+
+	// Updates and deletes of another tenant's rows
+	foreignEnv := *b.envTemplate
+	foreignEnv.Name = "hijacked"
+	expectErr(t, "EnvironmentRepository.Update", envs.Update(ctx, &foreignEnv), ErrEnvironmentTemplateNotFound)
+	expectErr(t, "EnvironmentRepository.Delete", envs.Delete(ctx, b.envTemplate.ID), ErrEnvironmentTemplateNotFound)
+
+	foreignTask := *b.taskTemplate
+	foreignTask.Name = "hijacked"
+	expectErr(t, "TaskRepository.Update", tasks.Update(ctx, &foreignTask), ErrTaskTemplateNotFound)
+	expectErr(t, "TaskRepository.Delete", tasks.Delete(ctx, b.taskTemplate.ID), ErrTaskTemplateNotFound)
+
+	foreignTmpl := *b.assessmentTmpl
+	foreignTmpl.EnvironmentTemplateID = a.envTemplate.ID
+	foreignTmpl.Name = "hijacked"
+	expectErr(t, "AssessmentRepository.UpdateTemplate", assessments.UpdateTemplate(ctx, &foreignTmpl), ErrAssessmentTemplateNotFound)
+	expectErr(t, "AssessmentRepository.DeleteTemplate", assessments.DeleteTemplate(ctx, b.assessmentTmpl.ID), ErrAssessmentTemplateNotFound)
+
+	foreignAssessment := *b.assessment
+	foreignAssessment.Status = model.AssessmentStatusCompleted
+	expectErr(t, "AssessmentRepository.Update", assessments.Update(ctx, &foreignAssessment), ErrAssessmentNotFound)
+
+	// Creating rows in the own tenant that reference another tenant's rows
+	ownTmpl := *a.assessmentTmpl
+	ownTmpl.EnvironmentTemplateID = b.envTemplate.ID
+	expectErr(t, "AssessmentRepository.UpdateTemplate with foreign environment", assessments.UpdateTemplate(ctx, &ownTmpl), ErrEnvironmentTemplateNotFound)
+
+	withForeignEnv := model.NewAssessmentTemplate(a.orgID, "foreign-env", b.envTemplate.ID, nil)
+	expectErr(t, "AssessmentRepository.CreateTemplate with foreign environment", assessments.CreateTemplate(ctx, withForeignEnv), ErrEnvironmentTemplateNotFound)
+
+	expectErr(t, "AssessmentRepository.AddTaskToTemplate with foreign task",
+		assessments.AddTaskToTemplate(ctx, a.assessmentTmpl.ID, b.taskTemplate.ID, 1, 1), ErrTaskTemplateNotFound)
+	expectErr(t, "AssessmentRepository.AddTaskToTemplate to foreign template",
+		assessments.AddTaskToTemplate(ctx, b.assessmentTmpl.ID, a.taskTemplate.ID, 1, 1), ErrAssessmentTemplateNotFound)
+
+	fromForeignTemplate := model.NewAssessment(b.assessmentTmpl.ID, a.candidateID, a.candidateID, time.Now().UTC())
+	expectErr(t, "AssessmentRepository.Create from foreign template", assessments.Create(ctx, fromForeignTemplate), ErrAssessmentTemplateNotFound)
+
+	forForeignCandidate := model.NewAssessment(a.assessmentTmpl.ID, b.candidateID, a.candidateID, time.Now().UTC())
+	expectErr(t, "AssessmentRepository.Create for foreign candidate", assessments.Create(ctx, forForeignCandidate), ErrCandidateNotFound)
+
+	expectErr(t, "AssessmentRepository.CreateAssessmentTasks for foreign assessment",
+		assessments.CreateAssessmentTasks(ctx, b.assessment.ID, b.assessmentTmpl.ID), ErrAssessmentNotFound)
+
+	// Creates ignore any organization set on the model and use the scoped one
+	spoofed := model.NewTaskTemplate(b.orgID, "spoofed", "instructions", nil)
+	if err := tasks.Create(ctx, spoofed); err != nil {
+		t.Fatalf("TaskRepository.Create: %v", err)
+	}
+	if spoofed.OrganizationID != a.orgID {
+		t.Errorf("TaskRepository.Create: expected organization %s, got: %s", a.orgID, spoofed.OrganizationID)
+	}
+
+	// Organization B's rows are untouched
+	bTasks := NewTaskRepository(db.Pool()).ForOrganization(b.orgID)
+	task, err := bTasks.GetByID(ctx, b.taskTemplate.ID)
+	if err != nil {
+		t.Fatalf("Organization B lost its task template: %v", err)
+	}
+	if task.Name != b.taskTemplate.Name {
+		t.Errorf("Organization B's task template was modified: %s", task.Name)
+	}
+
+	bAssessments := NewAssessmentRepository(db.Pool()).ForOrganization(b.orgID)
+	assessment, err := bAssessments.GetByID(ctx, b.assessment.ID)
+	if err != nil {
+		t.Fatalf("Organization B lost its assessment: %v", err)
+	}
+	if assessment.Status != b.assessment.Status {
+		t.Errorf("Organization B's assessment was modified: %s", assessment.Status)
+	}
+}
@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cstanislawski/qualifyd/pkg/model"
+)
+
+func TestTenantFromContext(t *testing.T) {
+	if _, err := TenantFromContext(context.Background()); !errors.Is(err, ErrTenantRequired) {
+		t.Errorf("Expected ErrTenantRequired for a context without tenant, got: %v", err)
+	}
+
+	if _, err := TenantFromContext(WithTenant(context.Background(), "")); !errors.Is(err, ErrTenantRequired) {
+		t.Errorf("Expected ErrTenantRequired for an empty tenant, got: %v", err)
+	}
+
+	orgID, err := TenantFromContext(WithTenant(context.Background(), "org-1"))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if orgID != "org-1" {
+		t.Errorf("Expected organization org-1, got: %s", orgID)
+	}
+}
+
+func TestForTenantRequiresOrganization(t *testing.T) {
+	ctx := context.Background()
+
+	if _, err := NewTaskRepository(nil).ForTenant(ctx); !errors.Is(err, ErrTenantRequired) {
+		t.Errorf("Expected ErrTenantRequired from TaskRepository.ForTenant, got: %v", err)
+	}
+	if _, err := NewEnvironmentRepository(nil).ForTenant(ctx); !errors.Is(err, ErrTenantRequired) {
+		t.Errorf("Expected ErrTenantRequired from EnvironmentRepository.ForTenant, got: %v", err)
+	}
+	if _, err := NewAssessmentRepository(nil).ForTenant(ctx); !errors.Is(err, ErrTenantRequired) {
+		t.Errorf("Expected ErrTenantRequired from AssessmentRepository.ForTenant, got: %v", err)
+	}
+
+	repo, err := NewTaskRepository(nil).ForTenant(WithTenant(ctx, "org-1"))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if repo.organizationID != "org-1" {
+		t.Errorf("Expected repository scoped to org-1, got: %s", repo.organizationID)
+	}
+}
+
+// Unscoped repositories must refuse to query rather than fall back to an unfiltered query.
+// The nil pool would panic if any of these reached the database.
+func TestUnscopedRepositoriesRefuseQueries(t *testing.T) {
+	ctx := context.Background()
+	tasks := NewTaskRepository(nil)
+	envs := NewEnvironmentRepository(nil)
+	assessments := NewAssessmentRepository(nil)
+
+	checks := map[string]error{
+		"TaskRepository.Create":                      tasks.Create(ctx, &model.TaskTemplate{}),
+		"TaskRepository.Delete":                      tasks.Delete(ctx, "id"),
+		"EnvironmentRepository.Update":               envs.Update(ctx, &model.EnvironmentTemplate{}),
+		"AssessmentRepository.Update":                assessments.Update(ctx, &model.Assessment{}),
+		"AssessmentRepository.CreateTemplate":        assessments.CreateTemplate(ctx, &model.AssessmentTemplate{}),
+		"AssessmentRepository.DeleteTemplate":        assessments.DeleteTemplate(ctx, "id"),
+		"AssessmentRepository.AddTaskToTemplate":     assessments.AddTaskToTemplate(ctx, "template", "task", 0, 1),
+		"AssessmentRepository.CreateAssessmentTasks": assessments.CreateAssessmentTasks(ctx, "assessment", "template"),
+	}
+	for name, err := range checks {
+		if !errors.Is(err, ErrTenantRequired) {
+			t.Errorf("%s: expected ErrTenantRequired, got: %v", name, err)
+		}
+	}
+
+	if _, err := tasks.GetByID(ctx, "id"); !errors.Is(err, ErrTenantRequired) {
+		t.Errorf("TaskRepository.GetByID: expected ErrTenantRequired, got: %v", err)
+	}
+	if _, err := envs.List(ctx); !errors.Is(err, ErrTenantRequired) {
+		t.Errorf("EnvironmentRepository.List: expected ErrTenantRequired, got: %v", err)
+	}
+	if _, err := assessments.GetByID(ctx, "id"); !errors.Is(err, ErrTenantRequired) {
+		t.Errorf("AssessmentRepository.GetByID: expected ErrTenantRequired, got: %v", err)
+	}
+	if _, err := assessments.ListByCandidate(ctx, "candidate"); !errors.Is(err, ErrTenantRequired) {
+		t.Errorf("AssessmentRepository.ListByCandidate: expected ErrTenantRequired, got: %v", err)
+	}
+}
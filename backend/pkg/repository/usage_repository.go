@@ -0,0 +1,243 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/database"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// environmentSessionColumns is the column list shared by every environment_sessions query
+const environmentSessionColumns = `
+	id, pod_name, organization_id, COALESCE(assessment_id::text, ''), COALESCE(session_id, ''),
+	COALESCE(cpu, ''), COALESCE(memory, ''), started_at, metered_until, last_seen_at,
+	stopped_at, COALESCE(stop_reason, ''), created_at
+`
+
+// UsageRepository handles database operations for usage records and metered
+// environment sessions
+type UsageRepository struct {
+	db dbtx
+}
+
+// NewUsageRepository creates a new UsageRepository instance
+func NewUsageRepository(db *database.Database) *UsageRepository {
+	return &UsageRepository{
+		db: db,
+	}
+}
+
+// WithTx returns a copy of the repository that runs its queries inside tx
+func (r *UsageRepository) WithTx(tx pgx.Tx) *UsageRepository {
+	return &UsageRepository{
+		db: tx,
+	}
+}
+
+// CreateRecord inserts a usage record
+func (r *UsageRepository) CreateRecord(ctx context.Context, record *model.UsageRecord) error {
+	query := `
+		INSERT INTO usage_records (id, organization_id, resource_type, quantity, usage_date,
+		                           assessment_id, environment_template_id, user_id, details, created_at)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, '')::uuid, NULLIF($7, '')::uuid, NULLIF($8, '')::uuid, $9, $10)
+	`
+
+	if record.ID == "" {
+		record.ID = uuid.New().String()
+	}
+
+	_, err := r.db.Exec(ctx, query,
+		record.ID,
+		record.OrganizationID,
+		record.ResourceType,
+		record.Quantity,
+		record.UsageDate,
+		record.AssessmentID,
+		record.EnvironmentTemplateID,
+		record.UserID,
+		record.Details,
+		record.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create usage record: %w", err)
+	}
+
+	return nil
+}
+
+// SumByResourceType totals an organization's usage records in [from, to) by resource type
+func (r *UsageRepository) SumByResourceType(ctx context.Context, organizationID string, from, to time.Time) (map[string]float64, error) {
+	query := `
+		SELECT resource_type, SUM(quantity)::float8
+		FROM usage_records
+		WHERE organization_id = $1 AND usage_date >= $2 AND usage_date < $3
+		GROUP BY resource_type
+	`
+
+	rows, err := r.db.Query(ctx, query, organizationID, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sum usage records: %w", err)
+	}
+	defer rows.Close()
+
+	totals := make(map[string]float64)
+	for rows.Next() {
+		var resourceType string
+		var total float64
+		if err := rows.Scan(&resourceType, &total); err != nil {
+			return nil, fmt.Errorf("failed to scan usage total: %w", err)
+		}
+		totals[resourceType] = total
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating usage totals: %w", err)
+	}
+
+	return totals, nil
+}
+
+// GetSessionByPod retrieves the environment session of a terminal pod
+func (r *UsageRepository) GetSessionByPod(ctx context.Context, podName string) (*model.EnvironmentSession, error) {
+	query := `SELECT ` + environmentSessionColumns + ` FROM environment_sessions WHERE pod_name = $1`
+
+	session, err := scanEnvironmentSession(r.db.QueryRow(ctx, query, podName))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, database.ErrRecordNotFound
+		}
+		return nil, fmt.Errorf("failed to get environment session: %w", err)
+	}
+
+	return session, nil
+}
+
+// CreateSession starts metering a terminal pod. If the pod is already being
+// metered the existing session is returned instead.
+func (r *UsageRepository) CreateSession(ctx context.Context, session *model.EnvironmentSession) (*model.EnvironmentSession, error) {
+	query := `
+		INSERT INTO environment_sessions (id, pod_name, organization_id, assessment_id, session_id, cpu, memory,
+		                                  started_at, metered_until, last_seen_at, created_at)
+		VALUES ($1, $2, $3, NULLIF($4, '')::uuid, NULLIF($5, ''), NULLIF($6, ''), NULLIF($7, ''), $8, $9, $10, $11)
+		ON CONFLICT (pod_name) DO NOTHING
+	`
+
+	if session.ID == "" {
+		session.ID = uuid.New().String()
+	}
+	if session.CreatedAt.IsZero() {
+		session.CreatedAt = time.Now().UTC()
+	}
+
+	tag, err := r.db.Exec(ctx, query,
+		session.ID,
+		session.PodName,
+		session.OrganizationID,
+		session.AssessmentID,
+		session.SessionID,
+		session.CPU,
+		session.Memory,
+		session.StartedAt,
+		session.MeteredUntil,
+		session.LastSeenAt,
+		session.CreatedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create environment session: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return r.GetSessionByPod(ctx, session.PodName)
+	}
+
+	return session, nil
+}
+
+// ListOpenSessions retrieves every environment session that has not stopped
+func (r *UsageRepository) ListOpenSessions(ctx context.Context) ([]*model.EnvironmentSession, error) {
+	query := `SELECT ` + environmentSessionColumns + ` FROM environment_sessions WHERE stopped_at IS NULL ORDER BY started_at`
+
+	rows, err := r.db.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list open environment sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []*model.EnvironmentSession
+	for rows.Next() {
+		session, err := scanEnvironmentSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan environment session: %w", err)
+		}
+		sessions = append(sessions, session)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating environment sessions: %w", err)
+	}
+
+	return sessions, nil
+}
+
+// AdvanceSession moves the session's metered_until from the value the caller
+// read to until, and stops the session if stoppedAt is set. It returns false
+// without changing anything if another caller advanced or stopped the session
+// first, so the same interval is never billed twice.
+func (r *UsageRepository) AdvanceSession(ctx context.Context, session *model.EnvironmentSession, until, seenAt time.Time, stoppedAt *time.Time, stopReason string) (bool, error) {
+	query := `
+		UPDATE environment_sessions
+		SET metered_until = $3,
+		    last_seen_at = GREATEST(last_seen_at, $4),
+		    stopped_at = $5,
+		    stop_reason = NULLIF($6, '')
+		WHERE id = $1 AND metered_until = $2 AND stopped_at IS NULL
+	`
+
+	tag, err := r.db.Exec(ctx, query, session.ID, session.MeteredUntil, until, seenAt, stoppedAt, stopReason)
+	if err != nil {
+		return false, fmt.Errorf("failed to advance environment session: %w", err)
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+// TouchSession records that the session's pod was still running at seenAt
+func (r *UsageRepository) TouchSession(ctx context.Context, id string, seenAt time.Time) error {
+	query := `UPDATE environment_sessions SET last_seen_at = GREATEST(last_seen_at, $2) WHERE id = $1`
+
+	if _, err := r.db.Exec(ctx, query, id, seenAt); err != nil {
+		return fmt.Errorf("failed to touch environment session: %w", err)
+	}
+
+	return nil
+}
+
+// scanEnvironmentSession scans a row selected with environmentSessionColumns
+func scanEnvironmentSession(row pgx.Row) (*model.EnvironmentSession, error) {
+	var session model.EnvironmentSession
+
+	err := row.Scan(
+		&session.ID,
+		&session.PodName,
+		&session.OrganizationID,
+		&session.AssessmentID,
+		&session.SessionID,
+		&session.CPU,
+		&session.Memory,
+		&session.StartedAt,
+		&session.MeteredUntil,
+		&session.LastSeenAt,
+		&session.StoppedAt,
+		&session.StopReason,
+		&session.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
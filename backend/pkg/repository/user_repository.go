@@ -13,7 +13,7 @@ import (
 
 // UserRepository handles database operations for users
 type UserRepository struct {
-	db *database.Database
+	db dbtx
 }
 
 // NewUserRepository creates a new UserRepository instance
@@ -23,12 +23,19 @@ func NewUserRepository(db *database.Database) *UserRepository {
 	}
 }
 
+// WithTx returns a copy of the repository that runs its queries inside tx
+func (r *UserRepository) WithTx(tx pgx.Tx) *UserRepository {
+	return &UserRepository{
+		db: tx,
+	}
+}
+
 // GetByID retrieves a user by ID
 func (r *UserRepository) GetByID(ctx context.Context, id string) (*model.User, error) {
 	query := `
 		SELECT id, email, password_hash, first_name, last_name, role, status,
 		       organization_id, last_login_at, created_at, updated_at,
-		       invitation_token, invitation_expires_at
+		       invitation_token, invitation_expires_at, password_changed_at
 		FROM users
 		WHERE id = $1
 	`
@@ -50,6 +57,7 @@ func (r *UserRepository) GetByID(ctx context.Context, id string) (*model.User, e
 		&user.UpdatedAt,
 		&user.InvitationToken,
 		&user.InvitationExpiresAt,
+		&user.PasswordChangedAt,
 	)
 
 	if err != nil {
@@ -72,7 +80,7 @@ func (r *UserRepository) GetByEmail(ctx context.Context, email string) (*model.U
 	query := `
 		SELECT id, email, password_hash, first_name, last_name, role, status,
 		       organization_id, last_login_at, created_at, updated_at,
-		       invitation_token, invitation_expires_at
+		       invitation_token, invitation_expires_at, password_changed_at
 		FROM users
 		WHERE email = $1
 	`
@@ -94,6 +102,7 @@ func (r *UserRepository) GetByEmail(ctx context.Context, email string) (*model.U
 		&user.UpdatedAt,
 		&user.InvitationToken,
 		&user.InvitationExpiresAt,
+		&user.PasswordChangedAt,
 	)
 
 	if err != nil {
@@ -264,7 +273,8 @@ func (r *UserRepository) List(ctx context.Context, limit, offset int, organizati
 	if organizationID != nil && *organizationID != "" {
 		query = `
 			SELECT id, email, password_hash, first_name, last_name, role, status,
-			       organization_id, last_login_at, created_at, updated_at
+			       organization_id, last_login_at, created_at, updated_at,
+			       invitation_token, invitation_expires_at, password_changed_at
 			FROM users
 			WHERE organization_id = $1
 			ORDER BY created_at DESC
@@ -274,7 +284,8 @@ func (r *UserRepository) List(ctx context.Context, limit, offset int, organizati
 	} else {
 		query = `
 			SELECT id, email, password_hash, first_name, last_name, role, status,
-			       organization_id, last_login_at, created_at, updated_at
+			       organization_id, last_login_at, created_at, updated_at,
+			       invitation_token, invitation_expires_at, password_changed_at
 			FROM users
 			ORDER BY created_at DESC
 			LIMIT $1 OFFSET $2
@@ -331,6 +342,7 @@ func (r *UserRepository) scanUser(row pgx.Rows) (*model.User, error) {
 		&user.UpdatedAt,
 		&user.InvitationToken,
 		&user.InvitationExpiresAt,
+		&user.PasswordChangedAt,
 	)
 
 	if err != nil {
@@ -370,7 +382,7 @@ func (r *UserRepository) GetByInvitationToken(ctx context.Context, token string)
 	query := `
 		SELECT id, email, password_hash, first_name, last_name, role, status,
 		       organization_id, last_login_at, created_at, updated_at,
-		       invitation_token, invitation_expires_at
+		       invitation_token, invitation_expires_at, password_changed_at
 		FROM users
 		WHERE invitation_token = $1
 	`
@@ -392,6 +404,7 @@ func (r *UserRepository) GetByInvitationToken(ctx context.Context, token string)
 		&user.UpdatedAt,
 		&user.InvitationToken,
 		&user.InvitationExpiresAt,
+		&user.PasswordChangedAt,
 	)
 
 	if err != nil {
@@ -0,0 +1,403 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/database"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// webhookSubscriptionColumns is the column list shared by every webhook_subscriptions query
+const webhookSubscriptionColumns = `
+	id, organization_id, url, secret, events, COALESCE(description, ''), active,
+	COALESCE(created_by::text, ''), created_at, updated_at
+`
+
+// webhookDeliveryColumns is the column list shared by every webhook_deliveries query
+const webhookDeliveryColumns = `
+	id, subscription_id, organization_id, event_id, event_type, payload, status, attempts,
+	COALESCE(last_status_code, 0), COALESCE(last_error, ''), next_attempt_at, delivered_at,
+	created_at, updated_at
+`
+
+// WebhookRepository handles database operations for webhook subscriptions and deliveries
+type WebhookRepository struct {
+	db dbtx
+}
+
+// NewWebhookRepository creates a new WebhookRepository instance
+func NewWebhookRepository(db *database.Database) *WebhookRepository {
+	return &WebhookRepository{
+		db: db,
+	}
+}
+
+// WithTx returns a copy of the repository that runs its queries inside tx
+func (r *WebhookRepository) WithTx(tx pgx.Tx) *WebhookRepository {
+	return &WebhookRepository{
+		db: tx,
+	}
+}
+
+// CreateSubscription inserts a webhook subscription
+func (r *WebhookRepository) CreateSubscription(ctx context.Context, s *model.WebhookSubscription) error {
+	query := `
+		INSERT INTO webhook_subscriptions (id, organization_id, url, secret, events, description, active,
+		                                   created_by, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7, NULLIF($8, '')::uuid, $9, $10)
+	`
+
+	if s.ID == "" {
+		s.ID = uuid.New().String()
+	}
+	now := time.Now().UTC()
+	if s.CreatedAt.IsZero() {
+		s.CreatedAt = now
+	}
+	s.UpdatedAt = now
+
+	_, err := r.db.Exec(ctx, query,
+		s.ID,
+		s.OrganizationID,
+		s.URL,
+		s.Secret,
+		s.Events,
+		s.Description,
+		s.Active,
+		s.CreatedBy,
+		s.CreatedAt,
+		s.UpdatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create webhook subscription: %w", err)
+	}
+
+	return nil
+}
+
+// GetSubscription retrieves one of an organization's webhook subscriptions
+func (r *WebhookRepository) GetSubscription(ctx context.Context, organizationID, id string) (*model.WebhookSubscription, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, database.ErrRecordNotFound
+	}
+
+	query := `SELECT ` + webhookSubscriptionColumns + ` FROM webhook_subscriptions WHERE id = $1 AND organization_id = $2`
+
+	s, err := scanWebhookSubscription(r.db.QueryRow(ctx, query, id, organizationID))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, database.ErrRecordNotFound
+		}
+		return nil, fmt.Errorf("failed to get webhook subscription: %w", err)
+	}
+
+	return s, nil
+}
+
+// ListSubscriptions retrieves an organization's webhook subscriptions, oldest first
+func (r *WebhookRepository) ListSubscriptions(ctx context.Context, organizationID string) ([]*model.WebhookSubscription, error) {
+	query := `SELECT ` + webhookSubscriptionColumns + ` FROM webhook_subscriptions WHERE organization_id = $1 ORDER BY created_at`
+
+	rows, err := r.db.Query(ctx, query, organizationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhook subscriptions: %w", err)
+	}
+
+	return database.ScanRowsIntoSlice(ctx, rows, func(rows pgx.Rows) (*model.WebhookSubscription, error) {
+		return scanWebhookSubscription(rows)
+	})
+}
+
+// ListSubscribed retrieves an organization's active webhook subscriptions for an event type
+func (r *WebhookRepository) ListSubscribed(ctx context.Context, organizationID, eventType string) ([]*model.WebhookSubscription, error) {
+	query := `
+		SELECT ` + webhookSubscriptionColumns + `
+		FROM webhook_subscriptions
+		WHERE organization_id = $1 AND active AND $2 = ANY(events)
+	`
+
+	rows, err := r.db.Query(ctx, query, organizationID, eventType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list subscribed webhooks: %w", err)
+	}
+
+	return database.ScanRowsIntoSlice(ctx, rows, func(rows pgx.Rows) (*model.WebhookSubscription, error) {
+		return scanWebhookSubscription(rows)
+	})
+}
+
+// UpdateSubscription updates a webhook subscription's endpoint, events, state and secret
+func (r *WebhookRepository) UpdateSubscription(ctx context.Context, s *model.WebhookSubscription) error {
+	query := `
+		UPDATE webhook_subscriptions
+		SET url = $3, secret = $4, events = $5, description = NULLIF($6, ''), active = $7, updated_at = $8
+		WHERE id = $1 AND organization_id = $2
+	`
+
+	s.UpdatedAt = time.Now().UTC()
+	tag, err := r.db.Exec(ctx, query, s.ID, s.OrganizationID, s.URL, s.Secret, s.Events, s.Description, s.Active, s.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to update webhook subscription: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return database.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// DeleteSubscription deletes a webhook subscription together with its delivery log
+func (r *WebhookRepository) DeleteSubscription(ctx context.Context, organizationID, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return database.ErrRecordNotFound
+	}
+
+	tag, err := r.db.Exec(ctx, `DELETE FROM webhook_subscriptions WHERE id = $1 AND organization_id = $2`, id, organizationID)
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook subscription: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return database.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// CreateDelivery inserts a pending delivery. If the event was already
+// recorded for the subscription, d is loaded with the existing delivery and
+// false is returned.
+func (r *WebhookRepository) CreateDelivery(ctx context.Context, d *model.WebhookDelivery) (bool, error) {
+	query := `
+		INSERT INTO webhook_deliveries (id, subscription_id, organization_id, event_id, event_type, payload, status,
+		                                created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $8)
+		ON CONFLICT (subscription_id, event_id) DO NOTHING
+	`
+
+	if d.ID == "" {
+		d.ID = uuid.New().String()
+	}
+	if d.Status == "" {
+		d.Status = model.WebhookDeliveryPending
+	}
+	d.CreatedAt = time.Now().UTC()
+	d.UpdatedAt = d.CreatedAt
+
+	tag, err := r.db.Exec(ctx, query, d.ID, d.SubscriptionID, d.OrganizationID, d.EventID, d.EventType, d.Payload, d.Status, d.CreatedAt)
+	if err != nil {
+		return false, fmt.Errorf("failed to create webhook delivery: %w", err)
+	}
+	if tag.RowsAffected() == 1 {
+		return true, nil
+	}
+
+	existing, err := scanWebhookDelivery(r.db.QueryRow(ctx,
+		`SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries WHERE subscription_id = $1 AND event_id = $2`,
+		d.SubscriptionID, d.EventID))
+	if err != nil {
+		return false, fmt.Errorf("failed to get existing webhook delivery: %w", err)
+	}
+	*d = *existing
+	return false, nil
+}
+
+// GetDelivery retrieves one of an organization's webhook deliveries
+func (r *WebhookRepository) GetDelivery(ctx context.Context, organizationID, id string) (*model.WebhookDelivery, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, database.ErrRecordNotFound
+	}
+
+	query := `SELECT ` + webhookDeliveryColumns + ` FROM webhook_deliveries WHERE id = $1 AND organization_id = $2`
+
+	d, err := scanWebhookDelivery(r.db.QueryRow(ctx, query, id, organizationID))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, database.ErrRecordNotFound
+		}
+		return nil, fmt.Errorf("failed to get webhook delivery: %w", err)
+	}
+
+	return d, nil
+}
+
+// ListDeliveries retrieves a page of an organization's webhook deliveries,
+// newest first, optionally only those of one subscription
+func (r *WebhookRepository) ListDeliveries(ctx context.Context, organizationID, subscriptionID string, params database.PaginationParams) (database.PaginatedResponse, error) {
+	where := `organization_id = $1`
+	args := []interface{}{organizationID}
+	if subscriptionID != "" {
+		if _, err := uuid.Parse(subscriptionID); err != nil {
+			return database.NewPaginatedResponse([]*model.WebhookDelivery{}, params, 0), nil
+		}
+		where += ` AND subscription_id = $2`
+		args = append(args, subscriptionID)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM webhook_deliveries
+		WHERE %s
+		ORDER BY created_at DESC
+		LIMIT $%d OFFSET $%d
+	`, webhookDeliveryColumns, where, len(args)+1, len(args)+2)
+
+	rows, err := r.db.Query(ctx, query, append(args, params.Limit(), params.Offset())...)
+	if err != nil {
+		return database.PaginatedResponse{}, fmt.Errorf("failed to list webhook deliveries: %w", err)
+	}
+
+	deliveries, err := database.ScanRowsIntoSlice(ctx, rows, func(rows pgx.Rows) (*model.WebhookDelivery, error) {
+		return scanWebhookDelivery(rows)
+	})
+	if err != nil {
+		return database.PaginatedResponse{}, fmt.Errorf("failed to scan webhook deliveries: %w", err)
+	}
+
+	var count int
+	if err := r.db.QueryRow(ctx, `SELECT COUNT(*) FROM webhook_deliveries WHERE `+where, args...).Scan(&count); err != nil {
+		return database.PaginatedResponse{}, fmt.Errorf("failed to count webhook deliveries: %w", err)
+	}
+
+	return database.NewPaginatedResponse(deliveries, params, count), nil
+}
+
+// ListAttempts retrieves the attempt log of a delivery, oldest first
+func (r *WebhookRepository) ListAttempts(ctx context.Context, deliveryID string) ([]*model.WebhookAttempt, error) {
+	query := `
+		SELECT attempt, COALESCE(status_code, 0), COALESCE(error, ''), duration_ms, created_at
+		FROM webhook_delivery_attempts
+		WHERE delivery_id = $1
+		ORDER BY attempt, created_at
+	`
+
+	rows, err := r.db.Query(ctx, query, deliveryID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhook delivery attempts: %w", err)
+	}
+
+	return database.ScanRowsIntoSlice(ctx, rows, func(rows pgx.Rows) (*model.WebhookAttempt, error) {
+		var a model.WebhookAttempt
+		err := rows.Scan(&a.Attempt, &a.StatusCode, &a.Error, &a.DurationMs, &a.CreatedAt)
+		return &a, err
+	})
+}
+
+// RecordAttempt logs an attempt and stores its outcome, together with the
+// delivery's new status, on the delivery
+func (r *WebhookRepository) RecordAttempt(ctx context.Context, d *model.WebhookDelivery, attempt *model.WebhookAttempt) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO webhook_delivery_attempts (id, delivery_id, attempt, status_code, error, duration_ms, created_at)
+		VALUES ($1, $2, $3, NULLIF($4, 0), NULLIF($5, ''), $6, $7)
+	`, uuid.New().String(), d.ID, attempt.Attempt, attempt.StatusCode, attempt.Error, attempt.DurationMs, attempt.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to record webhook delivery attempt: %w", err)
+	}
+
+	d.Attempts = attempt.Attempt
+	d.LastStatusCode = attempt.StatusCode
+	d.LastError = attempt.Error
+	d.UpdatedAt = attempt.CreatedAt
+	_, err = tx.Exec(ctx, `
+		UPDATE webhook_deliveries
+		SET status = $2, attempts = $3, last_status_code = NULLIF($4, 0), last_error = NULLIF($5, ''),
+		    next_attempt_at = $6, delivered_at = $7, updated_at = $8
+		WHERE id = $1
+	`, d.ID, d.Status, d.Attempts, d.LastStatusCode, d.LastError, nullTime(d.NextAttemptAt), nullTime(d.DeliveredAt), d.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to update webhook delivery: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// ResetDelivery makes a delivery pending again for a manual redelivery. Its
+// attempt count restarts so that it gets the full retry schedule.
+func (r *WebhookRepository) ResetDelivery(ctx context.Context, d *model.WebhookDelivery) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET status = $2, attempts = 0, next_attempt_at = NULL, updated_at = $3
+		WHERE id = $1
+	`
+
+	d.Status = model.WebhookDeliveryPending
+	d.Attempts = 0
+	d.NextAttemptAt = time.Time{}
+	d.UpdatedAt = time.Now().UTC()
+	if _, err := r.db.Exec(ctx, query, d.ID, d.Status, d.UpdatedAt); err != nil {
+		return fmt.Errorf("failed to reset webhook delivery: %w", err)
+	}
+
+	return nil
+}
+
+// scanWebhookSubscription scans a row selected with webhookSubscriptionColumns
+func scanWebhookSubscription(row pgx.Row) (*model.WebhookSubscription, error) {
+	var s model.WebhookSubscription
+
+	err := row.Scan(
+		&s.ID,
+		&s.OrganizationID,
+		&s.URL,
+		&s.Secret,
+		&s.Events,
+		&s.Description,
+		&s.Active,
+		&s.CreatedBy,
+		&s.CreatedAt,
+		&s.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+// scanWebhookDelivery scans a row selected with webhookDeliveryColumns
+func scanWebhookDelivery(row pgx.Row) (*model.WebhookDelivery, error) {
+	var d model.WebhookDelivery
+	var nextAttemptAt, deliveredAt *time.Time
+
+	err := row.Scan(
+		&d.ID,
+		&d.SubscriptionID,
+		&d.OrganizationID,
+		&d.EventID,
+		&d.EventType,
+		&d.Payload,
+		&d.Status,
+		&d.Attempts,
+		&d.LastStatusCode,
+		&d.LastError,
+		&nextAttemptAt,
+		&deliveredAt,
+		&d.CreatedAt,
+		&d.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if nextAttemptAt != nil {
+		d.NextAttemptAt = *nextAttemptAt
+	}
+	if deliveredAt != nil {
+		d.DeliveredAt = *deliveredAt
+	}
+
+	return &d, nil
+}
@@ -0,0 +1,449 @@
+// Package saml implements the parts of a SAML 2.0 service provider Qualifyd
+// needs for SP-initiated single sign-on: reading identity provider metadata,
+// publishing service provider metadata, sending authentication requests with
+// the HTTP-Redirect binding and verifying the signed responses identity
+// providers post back.
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+// Common errors
+var (
+	ErrInvalidMetadata = errors.New("invalid identity provider metadata")
+	ErrInvalidResponse = errors.New("invalid SAML response")
+	// ErrAuthnFailed is returned when the identity provider did not
+	// authenticate the user
+	ErrAuthnFailed = errors.New("identity provider did not authenticate the user")
+)
+
+// SAML namespaces, bindings and formats
+const (
+	nsProtocol  = "urn:oasis:names:tc:SAML:2.0:protocol"
+	nsAssertion = "urn:oasis:names:tc:SAML:2.0:assertion"
+
+	bindingRedirect = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect"
+	bindingPOST     = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+
+	nameIDFormatEmail  = "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress"
+	confirmationBearer = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+	statusSuccess      = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	issueInstantLayout = "2006-01-02T15:04:05Z"
+)
+
+// clockSkew is how far the identity provider's clock may be off from ours
+const clockSkew = 3 * time.Minute
+
+// IdentityProvider is an identity provider as described by its metadata
+type IdentityProvider struct {
+	EntityID string
+	// SSOURL is where authentication requests are sent with the
+	// HTTP-Redirect binding
+	SSOURL string
+	// Certificates are the certificates the provider signs with
+	Certificates []*x509.Certificate
+}
+
+// ServiceProvider is Qualifyd as a service provider to one identity provider
+type ServiceProvider struct {
+	EntityID string
+	// ACSURL is the assertion consumer service URL responses are posted to
+	ACSURL string
+}
+
+// Assertion is what an identity provider asserted about the user it
+// authenticated
+type Assertion struct {
+	NameID string
+	// Attributes are the assertion's attributes by name
+	Attributes map[string][]string
+}
+
+// Attribute returns the first value of the named attribute, or an empty
+// string if there is none
+func (a *Assertion) Attribute(name string) string {
+	if values := a.Attributes[name]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// entityDescriptor is the part of an identity provider's metadata that is
+// read
+type entityDescriptor struct {
+	XMLName          xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:metadata EntityDescriptor"`
+	EntityID         string   `xml:"entityID,attr"`
+	IDPSSODescriptor *struct {
+		KeyDescriptors []struct {
+			Use          string   `xml:"use,attr"`
+			Certificates []string `xml:"KeyInfo>X509Data>X509Certificate"`
+		} `xml:"KeyDescriptor"`
+		SingleSignOnServices []struct {
+			Binding  string `xml:"Binding,attr"`
+			Location string `xml:"Location,attr"`
+		} `xml:"SingleSignOnService"`
+	} `xml:"IDPSSODescriptor"`
+}
+
+// ParseMetadata parses an identity provider's metadata document. The
+// provider must accept requests with the HTTP-Redirect binding and publish
+// at least one signing certificate.
+func ParseMetadata(data []byte) (*IdentityProvider, error) {
+	var ed entityDescriptor
+	if err := xml.Unmarshal(data, &ed); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMetadata, err)
+	}
+	if ed.EntityID == "" {
+		return nil, fmt.Errorf("%w: entity ID is missing", ErrInvalidMetadata)
+	}
+	if ed.IDPSSODescriptor == nil {
+		return nil, fmt.Errorf("%w: not an identity provider", ErrInvalidMetadata)
+	}
+
+	idp := &IdentityProvider{EntityID: ed.EntityID}
+	for _, s := range ed.IDPSSODescriptor.SingleSignOnServices {
+		if s.Binding == bindingRedirect {
+			idp.SSOURL = s.Location
+			break
+		}
+	}
+	u, err := url.Parse(idp.SSOURL)
+	if idp.SSOURL == "" || err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+		return nil, fmt.Errorf("%w: no HTTP-Redirect single sign-on service", ErrInvalidMetadata)
+	}
+
+	for _, kd := range ed.IDPSSODescriptor.KeyDescriptors {
+		if kd.Use != "" && kd.Use != "signing" {
+			continue
+		}
+		for _, c := range kd.Certificates {
+			der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(c), ""))
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid certificate: %v", ErrInvalidMetadata, err)
+			}
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid certificate: %v", ErrInvalidMetadata, err)
+			}
+			idp.Certificates = append(idp.Certificates, cert)
+		}
+	}
+	if len(idp.Certificates) == 0 {
+		return nil, fmt.Errorf("%w: no signing certificate", ErrInvalidMetadata)
+	}
+
+	return idp, nil
+}
+
+// spMetadata is a service provider's metadata document
+type spMetadata struct {
+	XMLName         xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:metadata EntityDescriptor"`
+	EntityID        string   `xml:"entityID,attr"`
+	SPSSODescriptor struct {
+		AuthnRequestsSigned        bool   `xml:"AuthnRequestsSigned,attr"`
+		WantAssertionsSigned       bool   `xml:"WantAssertionsSigned,attr"`
+		ProtocolSupportEnumeration string `xml:"protocolSupportEnumeration,attr"`
+		NameIDFormat               string `xml:"NameIDFormat"`
+		AssertionConsumerService   struct {
+			Binding   string `xml:"Binding,attr"`
+			Location  string `xml:"Location,attr"`
+			Index     int    `xml:"index,attr"`
+			IsDefault bool   `xml:"isDefault,attr"`
+		} `xml:"AssertionConsumerService"`
+	} `xml:"SPSSODescriptor"`
+}
+
+// Metadata returns the service provider's metadata document, which
+// organizations give their identity provider
+func (sp *ServiceProvider) Metadata() ([]byte, error) {
+	md := spMetadata{EntityID: sp.EntityID}
+	md.SPSSODescriptor.WantAssertionsSigned = true
+	md.SPSSODescriptor.ProtocolSupportEnumeration = nsProtocol
+	md.SPSSODescriptor.NameIDFormat = nameIDFormatEmail
+	md.SPSSODescriptor.AssertionConsumerService.Binding = bindingPOST
+	md.SPSSODescriptor.AssertionConsumerService.Location = sp.ACSURL
+	md.SPSSODescriptor.AssertionConsumerService.IsDefault = true
+
+	out, err := xml.MarshalIndent(md, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal service provider metadata: %w", err)
+	}
+	return append([]byte(xml.Header), out...), nil
+}
+
+// NewRequestID returns a new authentication request ID. IDs must not start
+// with a digit.
+func NewRequestID() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate request ID: %w", err)
+	}
+	return "id-" + hex.EncodeToString(b), nil
+}
+
+// AuthnRequestURL returns the URL the user is sent to to sign in at idp,
+// carrying an authentication request with the given ID and relayState
+func (sp *ServiceProvider) AuthnRequestURL(idp *IdentityProvider, requestID, relayState string) (string, error) {
+	req := etree.NewElement("samlp:AuthnRequest")
+	req.CreateAttr("xmlns:samlp", nsProtocol)
+	req.CreateAttr("xmlns:saml", nsAssertion)
+	req.CreateAttr("ID", requestID)
+	req.CreateAttr("Version", "2.0")
+	req.CreateAttr("IssueInstant", time.Now().UTC().Format(issueInstantLayout))
+	req.CreateAttr("Destination", idp.SSOURL)
+	req.CreateAttr("AssertionConsumerServiceURL", sp.ACSURL)
+	req.CreateAttr("ProtocolBinding", bindingPOST)
+	req.CreateElement("saml:Issuer").SetText(sp.EntityID)
+	policy := req.CreateElement("samlp:NameIDPolicy")
+	policy.CreateAttr("Format", nameIDFormatEmail)
+	policy.CreateAttr("AllowCreate", "true")
+
+	doc := etree.NewDocument()
+	doc.SetRoot(req)
+	raw, err := doc.WriteToBytes()
+	if err != nil {
+		return "", fmt.Errorf("failed to write authentication request: %w", err)
+	}
+
+	var deflated bytes.Buffer
+	fw, err := flate.NewWriter(&deflated, flate.DefaultCompression)
+	if err != nil {
+		return "", err
+	}
+	if _, err := fw.Write(raw); err != nil {
+		return "", err
+	}
+	if err := fw.Close(); err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(idp.SSOURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid single sign-on URL: %w", err)
+	}
+	q := u.Query()
+	q.Set("SAMLRequest", base64.StdEncoding.EncodeToString(deflated.Bytes()))
+	if relayState != "" {
+		q.Set("RelayState", relayState)
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// ParseResponse verifies a base64-encoded response idp posted to the
+// assertion consumer service in answer to the authentication request with
+// the given ID, and returns its assertion.
+//
+// Either the response or its assertion must be signed with one of idp's
+// certificates. Only the signed element is read after verifying it, so
+// unsigned content wrapped around it is ignored. Encrypted assertions are
+// not supported.
+func (sp *ServiceProvider) ParseResponse(idp *IdentityProvider, encoded, requestID string, now time.Time) (*Assertion, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(encoded), ""))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidResponse, err)
+	}
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(raw); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidResponse, err)
+	}
+	response := doc.Root()
+	if response == nil || !is(response, nsProtocol, "Response") {
+		return nil, fmt.Errorf("%w: not a SAML response", ErrInvalidResponse)
+	}
+
+	validator := dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{Roots: idp.Certificates})
+	validator.Clock = dsig.NewFakeClockAt(now)
+
+	responseSigned := child(response, dsig.Namespace, "Signature") != nil
+	if responseSigned {
+		response, err = validator.Validate(response)
+		if err != nil {
+			return nil, fmt.Errorf("%w: response signature: %v", ErrInvalidResponse, err)
+		}
+	}
+
+	if dest := response.SelectAttrValue("Destination", ""); dest != "" && dest != sp.ACSURL {
+		return nil, fmt.Errorf("%w: destination is %q", ErrInvalidResponse, dest)
+	}
+	if got := response.SelectAttrValue("InResponseTo", ""); got != requestID {
+		return nil, fmt.Errorf("%w: response is not to the authentication request", ErrInvalidResponse)
+	}
+	if issuer := child(response, nsAssertion, "Issuer"); issuer != nil && strings.TrimSpace(issuer.Text()) != idp.EntityID {
+		return nil, fmt.Errorf("%w: issuer is %q", ErrInvalidResponse, strings.TrimSpace(issuer.Text()))
+	}
+	status := child(child(response, nsProtocol, "Status"), nsProtocol, "StatusCode")
+	if status == nil || status.SelectAttrValue("Value", "") != statusSuccess {
+		return nil, ErrAuthnFailed
+	}
+
+	if len(children(response, nsAssertion, "EncryptedAssertion")) > 0 {
+		return nil, fmt.Errorf("%w: encrypted assertions are not supported", ErrInvalidResponse)
+	}
+	assertions := children(response, nsAssertion, "Assertion")
+	if len(assertions) != 1 {
+		return nil, fmt.Errorf("%w: expected one assertion, got %d", ErrInvalidResponse, len(assertions))
+	}
+	assertion := assertions[0]
+	if !responseSigned || child(assertion, dsig.Namespace, "Signature") != nil {
+		assertion, err = validator.Validate(detach(assertion))
+		if err != nil {
+			return nil, fmt.Errorf("%w: assertion signature: %v", ErrInvalidResponse, err)
+		}
+	}
+
+	return sp.readAssertion(idp, assertion, requestID, now)
+}
+
+// readAssertion checks a verified assertion's issuer, subject and conditions
+// and returns what it asserts
+func (sp *ServiceProvider) readAssertion(idp *IdentityProvider, assertion *etree.Element, requestID string, now time.Time) (*Assertion, error) {
+	issuer := child(assertion, nsAssertion, "Issuer")
+	if issuer == nil || strings.TrimSpace(issuer.Text()) != idp.EntityID {
+		return nil, fmt.Errorf("%w: assertion is not issued by the identity provider", ErrInvalidResponse)
+	}
+
+	subject := child(assertion, nsAssertion, "Subject")
+	nameID := child(subject, nsAssertion, "NameID")
+	if nameID == nil {
+		return nil, fmt.Errorf("%w: assertion has no subject", ErrInvalidResponse)
+	}
+	confirmed := false
+	for _, sc := range children(subject, nsAssertion, "SubjectConfirmation") {
+		data := child(sc, nsAssertion, "SubjectConfirmationData")
+		if sc.SelectAttrValue("Method", "") != confirmationBearer || data == nil {
+			continue
+		}
+		if data.SelectAttrValue("Recipient", "") != sp.ACSURL {
+			continue
+		}
+		if data.SelectAttrValue("InResponseTo", "") != requestID {
+			continue
+		}
+		notOnOrAfter, err := parseTime(data.SelectAttrValue("NotOnOrAfter", ""))
+		if err != nil || !now.Before(notOnOrAfter.Add(clockSkew)) {
+			continue
+		}
+		confirmed = true
+		break
+	}
+	if !confirmed {
+		return nil, fmt.Errorf("%w: subject is not confirmed for this service provider", ErrInvalidResponse)
+	}
+
+	conditions := child(assertion, nsAssertion, "Conditions")
+	if conditions == nil {
+		return nil, fmt.Errorf("%w: assertion has no conditions", ErrInvalidResponse)
+	}
+	if v := conditions.SelectAttrValue("NotBefore", ""); v != "" {
+		notBefore, err := parseTime(v)
+		if err != nil || now.Add(clockSkew).Before(notBefore) {
+			return nil, fmt.Errorf("%w: assertion is not yet valid", ErrInvalidResponse)
+		}
+	}
+	if v := conditions.SelectAttrValue("NotOnOrAfter", ""); v != "" {
+		notOnOrAfter, err := parseTime(v)
+		if err != nil || !now.Before(notOnOrAfter.Add(clockSkew)) {
+			return nil, fmt.Errorf("%w: assertion expired", ErrInvalidResponse)
+		}
+	}
+	audienceOK := false
+	for _, ar := range children(conditions, nsAssertion, "AudienceRestriction") {
+		for _, a := range children(ar, nsAssertion, "Audience") {
+			if strings.TrimSpace(a.Text()) == sp.EntityID {
+				audienceOK = true
+			}
+		}
+	}
+	if !audienceOK {
+		return nil, fmt.Errorf("%w: assertion is not for this service provider", ErrInvalidResponse)
+	}
+
+	result := &Assertion{
+		NameID:     strings.TrimSpace(nameID.Text()),
+		Attributes: make(map[string][]string),
+	}
+	for _, statement := range children(assertion, nsAssertion, "AttributeStatement") {
+		for _, attr := range children(statement, nsAssertion, "Attribute") {
+			var values []string
+			for _, v := range children(attr, nsAssertion, "AttributeValue") {
+				values = append(values, strings.TrimSpace(v.Text()))
+			}
+			name := attr.SelectAttrValue("Name", "")
+			result.Attributes[name] = append(result.Attributes[name], values...)
+			if friendly := attr.SelectAttrValue("FriendlyName", ""); friendly != "" && friendly != name {
+				result.Attributes[friendly] = append(result.Attributes[friendly], values...)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// is returns true if el is the element tag in namespace ns
+func is(el *etree.Element, ns, tag string) bool {
+	return el.Tag == tag && el.NamespaceURI() == ns
+}
+
+// children returns el's child elements tag in namespace ns
+func children(el *etree.Element, ns, tag string) []*etree.Element {
+	if el == nil {
+		return nil
+	}
+	var found []*etree.Element
+	for _, c := range el.ChildElements() {
+		if is(c, ns, tag) {
+			found = append(found, c)
+		}
+	}
+	return found
+}
+
+// child returns el's first child element tag in namespace ns, or nil
+func child(el *etree.Element, ns, tag string) *etree.Element {
+	if found := children(el, ns, tag); len(found) > 0 {
+		return found[0]
+	}
+	return nil
+}
+
+// detach returns a copy of el that declares the namespaces it inherits from
+// its ancestors, so that it can be verified on its own
+func detach(el *etree.Element) *etree.Element {
+	c := el.Copy()
+	declared := make(map[string]bool)
+	for _, a := range c.Attr {
+		if a.Space == "xmlns" || (a.Space == "" && a.Key == "xmlns") {
+			declared[a.FullKey()] = true
+		}
+	}
+	for p := el.Parent(); p != nil; p = p.Parent() {
+		for _, a := range p.Attr {
+			if (a.Space == "xmlns" || (a.Space == "" && a.Key == "xmlns")) && !declared[a.FullKey()] {
+				c.CreateAttr(a.FullKey(), a.Value)
+				declared[a.FullKey()] = true
+			}
+		}
+	}
+	return c
+}
+
+// parseTime parses a SAML timestamp
+func parseTime(v string) (time.Time, error) {
+	return time.Parse(time.RFC3339, v)
+}
@@ -0,0 +1,284 @@
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+const (
+	testIdPEntityID = "https://idp.example.com/metadata"
+	testSPEntityID  = "https://api.example.com/api/sso/saml/org-1/metadata"
+	testACSURL      = "https://api.example.com/api/sso/saml/org-1/acs"
+	testRequestID   = "id-request-1"
+)
+
+// testIdP signs responses with a self-signed certificate
+type testIdP struct {
+	keyStore dsig.X509KeyStore
+	idp      *IdentityProvider
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	ks := dsig.RandomKeyStoreForTest()
+	_, der, err := ks.GetKeyPair()
+	if err != nil {
+		t.Fatalf("GetKeyPair() error = %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("ParseCertificate() error = %v", err)
+	}
+	return &testIdP{
+		keyStore: ks,
+		idp: &IdentityProvider{
+			EntityID:     testIdPEntityID,
+			SSOURL:       "https://idp.example.com/sso",
+			Certificates: []*x509.Certificate{cert},
+		},
+	}
+}
+
+// responseOptions change the response a testIdP issues
+type responseOptions struct {
+	audience     string
+	inResponseTo string
+	notOnOrAfter time.Time
+	signResponse bool
+	unsigned     bool
+	// tamper edits the signed document before it is encoded
+	tamper func(response *etree.Element)
+}
+
+// response returns a base64-encoded response asserting jane@example.com
+func (p *testIdP) response(t *testing.T, now time.Time, opts responseOptions) string {
+	if opts.audience == "" {
+		opts.audience = testSPEntityID
+	}
+	if opts.inResponseTo == "" {
+		opts.inResponseTo = testRequestID
+	}
+	if opts.notOnOrAfter.IsZero() {
+		opts.notOnOrAfter = now.Add(5 * time.Minute)
+	}
+	instant := now.UTC().Format(time.RFC3339)
+	expiry := opts.notOnOrAfter.UTC().Format(time.RFC3339)
+
+	response := etree.NewElement("samlp:Response")
+	response.CreateAttr("xmlns:samlp", nsProtocol)
+	response.CreateAttr("xmlns:saml", nsAssertion)
+	response.CreateAttr("ID", "id-response-1")
+	response.CreateAttr("Version", "2.0")
+	response.CreateAttr("IssueInstant", instant)
+	response.CreateAttr("Destination", testACSURL)
+	response.CreateAttr("InResponseTo", opts.inResponseTo)
+	response.CreateElement("saml:Issuer").SetText(testIdPEntityID)
+	response.CreateElement("samlp:Status").CreateElement("samlp:StatusCode").CreateAttr("Value", statusSuccess)
+
+	assertion := etree.NewElement("saml:Assertion")
+	assertion.CreateAttr("xmlns:saml", nsAssertion)
+	assertion.CreateAttr("ID", "id-assertion-1")
+	assertion.CreateAttr("Version", "2.0")
+	assertion.CreateAttr("IssueInstant", instant)
+	assertion.CreateElement("saml:Issuer").SetText(testIdPEntityID)
+	subject := assertion.CreateElement("saml:Subject")
+	subject.CreateElement("saml:NameID").SetText("jane@example.com")
+	confirmation := subject.CreateElement("saml:SubjectConfirmation")
+	confirmation.CreateAttr("Method", confirmationBearer)
+	data := confirmation.CreateElement("saml:SubjectConfirmationData")
+	data.CreateAttr("InResponseTo", opts.inResponseTo)
+	data.CreateAttr("NotOnOrAfter", expiry)
+	data.CreateAttr("Recipient", testACSURL)
+	conditions := assertion.CreateElement("saml:Conditions")
+	conditions.CreateAttr("NotBefore", now.Add(-time.Minute).UTC().Format(time.RFC3339))
+	conditions.CreateAttr("NotOnOrAfter", expiry)
+	conditions.CreateElement("saml:AudienceRestriction").CreateElement("saml:Audience").SetText(opts.audience)
+	statement := assertion.CreateElement("saml:AttributeStatement")
+	for name, values := range map[string][]string{"firstName": {"Jane"}, "groups": {"eng", "hiring"}} {
+		attr := statement.CreateElement("saml:Attribute")
+		attr.CreateAttr("Name", name)
+		for _, v := range values {
+			attr.CreateElement("saml:AttributeValue").SetText(v)
+		}
+	}
+
+	// Identity providers sign with exclusive canonicalization, so that
+	// assertions verify on their own
+	signer := dsig.NewDefaultSigningContext(p.keyStore)
+	signer.Canonicalizer = dsig.MakeC14N10ExclusiveCanonicalizerWithPrefixList("")
+	if !opts.unsigned && !opts.signResponse {
+		signed, err := signer.SignEnveloped(assertion)
+		if err != nil {
+			t.Fatalf("SignEnveloped() error = %v", err)
+		}
+		assertion = signed
+	}
+	response.AddChild(assertion)
+	if !opts.unsigned && opts.signResponse {
+		signed, err := signer.SignEnveloped(response)
+		if err != nil {
+			t.Fatalf("SignEnveloped() error = %v", err)
+		}
+		response = signed
+	}
+
+	if opts.tamper != nil {
+		opts.tamper(response)
+	}
+
+	doc := etree.NewDocument()
+	doc.SetRoot(response)
+	raw, err := doc.WriteToBytes()
+	if err != nil {
+		t.Fatalf("WriteToBytes() error = %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(raw)
+}
+
+func TestParseResponse(t *testing.T) {
+	p := newTestIdP(t)
+	sp := &ServiceProvider{EntityID: testSPEntityID, ACSURL: testACSURL}
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		opts    responseOptions
+		wantErr bool
+	}{
+		{name: "signed assertion"},
+		{name: "signed response", opts: responseOptions{signResponse: true}},
+		{name: "unsigned", opts: responseOptions{unsigned: true}, wantErr: true},
+		{
+			name: "tampered assertion",
+			opts: responseOptions{tamper: func(response *etree.Element) {
+				response.FindElement("//NameID").SetText("admin@example.com")
+			}},
+			wantErr: true,
+		},
+		{
+			name: "wrapped assertion",
+			opts: responseOptions{signResponse: true, tamper: func(response *etree.Element) {
+				// An unsigned assertion next to the signed one
+				evil := response.FindElement("./Assertion").Copy()
+				evil.FindElement("./Subject/NameID").SetText("admin@example.com")
+				response.InsertChildAt(0, evil)
+			}},
+			wantErr: true,
+		},
+		{name: "wrong audience", opts: responseOptions{audience: "https://other.example.com"}, wantErr: true},
+		{name: "wrong request", opts: responseOptions{inResponseTo: "id-request-2"}, wantErr: true},
+		{name: "expired", opts: responseOptions{notOnOrAfter: now.Add(-time.Hour)}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertion, err := sp.ParseResponse(p.idp, p.response(t, now, tt.opts), testRequestID, now)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("ParseResponse() succeeded, want error")
+				}
+				if !errors.Is(err, ErrInvalidResponse) {
+					t.Errorf("ParseResponse() error = %v, want %v", err, ErrInvalidResponse)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseResponse() error = %v", err)
+			}
+
+			if assertion.NameID != "jane@example.com" || assertion.Attribute("firstName") != "Jane" {
+				t.Errorf("ParseResponse() assertion = %+v", assertion)
+			}
+			if groups := assertion.Attributes["groups"]; len(groups) != 2 || groups[0] != "eng" || groups[1] != "hiring" {
+				t.Errorf("ParseResponse() groups = %v, want [eng hiring]", groups)
+			}
+		})
+	}
+}
+
+func TestParseResponseUntrustedCertificate(t *testing.T) {
+	p := newTestIdP(t)
+	other := newTestIdP(t)
+	sp := &ServiceProvider{EntityID: testSPEntityID, ACSURL: testACSURL}
+	now := time.Now()
+
+	_, err := sp.ParseResponse(other.idp, p.response(t, now, responseOptions{}), testRequestID, now)
+	if !errors.Is(err, ErrInvalidResponse) {
+		t.Errorf("ParseResponse() error = %v, want %v", err, ErrInvalidResponse)
+	}
+}
+
+func TestParseMetadata(t *testing.T) {
+	p := newTestIdP(t)
+	cert := base64.StdEncoding.EncodeToString(p.idp.Certificates[0].Raw)
+	metadata := `<?xml version="1.0"?>
+<md:EntityDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata" entityID="` + testIdPEntityID + `">
+  <md:IDPSSODescriptor protocolSupportEnumeration="urn:oasis:names:tc:SAML:2.0:protocol">
+    <md:KeyDescriptor use="signing">
+      <ds:KeyInfo xmlns:ds="http://www.w3.org/2000/09/xmldsig#">
+        <ds:X509Data><ds:X509Certificate>` + cert + `</ds:X509Certificate></ds:X509Data>
+      </ds:KeyInfo>
+    </md:KeyDescriptor>
+    <md:SingleSignOnService Binding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST" Location="https://idp.example.com/sso/post"/>
+    <md:SingleSignOnService Binding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect" Location="https://idp.example.com/sso"/>
+  </md:IDPSSODescriptor>
+</md:EntityDescriptor>`
+
+	idp, err := ParseMetadata([]byte(metadata))
+	if err != nil {
+		t.Fatalf("ParseMetadata() error = %v", err)
+	}
+	if idp.EntityID != testIdPEntityID || idp.SSOURL != "https://idp.example.com/sso" || len(idp.Certificates) != 1 {
+		t.Errorf("ParseMetadata() = %+v", idp)
+	}
+
+	noCert := strings.Replace(metadata, `use="signing"`, `use="encryption"`, 1)
+	if _, err := ParseMetadata([]byte(noCert)); !errors.Is(err, ErrInvalidMetadata) {
+		t.Errorf("ParseMetadata() without signing certificate error = %v, want %v", err, ErrInvalidMetadata)
+	}
+}
+
+func TestAuthnRequestURL(t *testing.T) {
+	p := newTestIdP(t)
+	sp := &ServiceProvider{EntityID: testSPEntityID, ACSURL: testACSURL}
+
+	authURL, err := sp.AuthnRequestURL(p.idp, testRequestID, "state-1")
+	if err != nil {
+		t.Fatalf("AuthnRequestURL() error = %v", err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("url.Parse() error = %v", err)
+	}
+	if u.Query().Get("RelayState") != "state-1" {
+		t.Errorf("RelayState = %q, want state-1", u.Query().Get("RelayState"))
+	}
+
+	deflated, err := base64.StdEncoding.DecodeString(u.Query().Get("SAMLRequest"))
+	if err != nil {
+		t.Fatalf("DecodeString() error = %v", err)
+	}
+	raw, err := io.ReadAll(flate.NewReader(bytes.NewReader(deflated)))
+	if err != nil {
+		t.Fatalf("inflate error = %v", err)
+	}
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(raw); err != nil {
+		t.Fatalf("ReadFromBytes() error = %v", err)
+	}
+	req := doc.Root()
+	if req.Tag != "AuthnRequest" || req.SelectAttrValue("ID", "") != testRequestID ||
+		req.SelectAttrValue("AssertionConsumerServiceURL", "") != testACSURL {
+		t.Errorf("AuthnRequest = %s", raw)
+	}
+}
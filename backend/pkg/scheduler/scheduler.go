@@ -0,0 +1,135 @@
+// Package scheduler runs periodic background jobs inside the API server.
+//
+// Every replica runs the scheduler, but each job run first takes a Postgres
+// advisory lock named after the job, so a job runs on at most one replica at a
+// time. Jobs must therefore be safe to skip: the next tick, possibly on
+// another replica, picks up whatever this one did not do.
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/database"
+	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/metrics"
+	"github.com/jackc/pgx/v5"
+)
+
+// JobFunc is the work done by one run of a job
+type JobFunc func(ctx context.Context) error
+
+// job is a registered periodic job
+type job struct {
+	name     string
+	interval time.Duration
+	run      JobFunc
+}
+
+// Scheduler runs registered jobs at fixed intervals
+type Scheduler struct {
+	db   *database.Database
+	log  logger.Logger
+	jobs []job
+}
+
+// New creates a new scheduler
+func New(db *database.Database, log logger.Logger) *Scheduler {
+	return &Scheduler{
+		db:  db,
+		log: log,
+	}
+}
+
+// Register adds a job that runs every interval. It must be called before Run.
+func (s *Scheduler) Register(name string, interval time.Duration, run JobFunc) {
+	s.jobs = append(s.jobs, job{name: name, interval: interval, run: run})
+}
+
+// Run runs every registered job once immediately and then at its interval,
+// until ctx is cancelled. It returns after all in-flight runs have finished.
+func (s *Scheduler) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, j := range s.jobs {
+		wg.Add(1)
+		go func(j job) {
+			defer wg.Done()
+			s.loop(ctx, j)
+		}(j)
+	}
+
+	s.log.Info("Scheduler started", map[string]interface{}{
+		"jobs": len(s.jobs),
+	})
+	wg.Wait()
+	s.log.Info("Scheduler stopped", nil)
+}
+
+// loop runs a job at its interval until ctx is cancelled
+func (s *Scheduler) loop(ctx context.Context, j job) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.RunOnce(ctx, j.name); err != nil {
+			s.log.Error("Scheduled job failed", err, map[string]interface{}{
+				"job": j.name,
+			})
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce runs the named job now unless another replica is running it
+func (s *Scheduler) RunOnce(ctx context.Context, name string) error {
+	var j *job
+	for i := range s.jobs {
+		if s.jobs[i].name == name {
+			j = &s.jobs[i]
+			break
+		}
+	}
+	if j == nil {
+		return fmt.Errorf("unknown job %q", name)
+	}
+
+	start := time.Now()
+	ran := false
+	// The transaction only holds the advisory lock; the job uses its own
+	// connections so that its writes commit independently
+	err := s.db.WithTx(ctx, func(tx pgx.Tx) error {
+		if err := tx.QueryRow(ctx, `SELECT pg_try_advisory_xact_lock($1)`, lockKey(j.name)).Scan(&ran); err != nil {
+			return fmt.Errorf("failed to take job lock: %w", err)
+		}
+		if !ran {
+			return nil
+		}
+		return j.run(ctx)
+	})
+
+	result := metrics.Result(err)
+	if err == nil && !ran {
+		result = metrics.ResultSkipped
+	}
+	metrics.SchedulerJobRuns.WithLabelValues(j.name, result).Inc()
+	if ran {
+		metrics.SchedulerJobDuration.WithLabelValues(j.name).Observe(time.Since(start).Seconds())
+	}
+
+	return err
+}
+
+// lockKey maps a job name to a Postgres advisory lock key
+func lockKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte("qualifyd.scheduler." + name))
+	return int64(h.Sum64())
+}
@@ -0,0 +1,187 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/cstanislawski/qualifyd/pkg/auth"
+	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/cstanislawski/qualifyd/pkg/repository"
+	"github.com/google/uuid"
+)
+
+// Client describes the device a session is started or refreshed from
+type Client struct {
+	UserAgent string
+	IPAddress string
+}
+
+// Tokens are the tokens issued for a session. RefreshToken is empty for
+// sessions that cannot be refreshed.
+type Tokens struct {
+	SessionID    string
+	AccessToken  string
+	RefreshToken string
+}
+
+// Service starts, refreshes and revokes the server-side sessions access and
+// refresh tokens belong to
+type Service struct {
+	sessionRepo *repository.SessionRepository
+	userRepo    *repository.UserRepository
+	auth        *auth.Auth
+	log         logger.Logger
+}
+
+// NewService creates a new Service
+func NewService(
+	sessionRepo *repository.SessionRepository,
+	userRepo *repository.UserRepository,
+	authService *auth.Auth,
+	log logger.Logger,
+) *Service {
+	return &Service{
+		sessionRepo: sessionRepo,
+		userRepo:    userRepo,
+		auth:        authService,
+		log:         log,
+	}
+}
+
+// Start starts a session for a user who signed in and issues its tokens
+func (s *Service) Start(ctx context.Context, user *model.User, client Client) (*Tokens, error) {
+	sessionID := uuid.New().String()
+
+	refreshToken, expiresAt, err := s.auth.GenerateRefreshToken(user.ID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	accessToken, err := s.auth.GenerateAccessToken(user, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	session := &model.Session{
+		ID:        sessionID,
+		UserID:    user.ID,
+		UserAgent: client.UserAgent,
+		IPAddress: client.IPAddress,
+		ExpiresAt: expiresAt,
+	}
+	token := &model.RefreshToken{
+		TokenHash: auth.HashToken(refreshToken),
+		ExpiresAt: expiresAt,
+	}
+	if err := s.sessionRepo.Create(ctx, session, token); err != nil {
+		return nil, err
+	}
+
+	return &Tokens{
+		SessionID:    sessionID,
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+// StartAssessment starts a candidate's session limited to one assessment,
+// opened with a sign-in link, and returns its access token and when it
+// expires. The session cannot be refreshed.
+func (s *Service) StartAssessment(ctx context.Context, user *model.User, assessmentID string, client Client) (*Tokens, *model.Session, error) {
+	sessionID := uuid.New().String()
+
+	accessToken, expiresAt, err := s.auth.GenerateAssessmentToken(user, assessmentID, sessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	session := &model.Session{
+		ID:           sessionID,
+		UserID:       user.ID,
+		AssessmentID: assessmentID,
+		UserAgent:    client.UserAgent,
+		IPAddress:    client.IPAddress,
+		ExpiresAt:    expiresAt,
+	}
+	if err := s.sessionRepo.Create(ctx, session, nil); err != nil {
+		return nil, nil, err
+	}
+
+	return &Tokens{
+		SessionID:   sessionID,
+		AccessToken: accessToken,
+	}, session, nil
+}
+
+// Refresh exchanges a refresh token for new tokens of the same session and
+// returns them with the session's user. The refresh token can only be used
+// once; using it again revokes the session and returns
+// repository.ErrRefreshTokenReused. It returns
+// repository.ErrRefreshTokenInvalid if the token is invalid, expired or
+// revoked, or its user is no longer active.
+func (s *Service) Refresh(ctx context.Context, refreshToken string) (*Tokens, *model.User, error) {
+	userID, sessionID, err := s.auth.ValidateRefreshToken(refreshToken)
+	if err != nil {
+		return nil, nil, repository.ErrRefreshTokenInvalid
+	}
+
+	next, expiresAt, err := s.auth.GenerateRefreshToken(userID, sessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	_, err = s.sessionRepo.Rotate(ctx, sessionID, auth.HashToken(refreshToken), &model.RefreshToken{
+		TokenHash: auth.HashToken(next),
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if user.Status != model.StatusActive {
+		if err := s.sessionRepo.Revoke(ctx, userID, sessionID, model.SessionRevokedInactiveUser); err != nil && !errors.Is(err, repository.ErrSessionNotFound) {
+			s.log.Error("Failed to revoke session of inactive user", err, map[string]interface{}{
+				"user_id":    userID,
+				"session_id": sessionID,
+			})
+		}
+		return nil, nil, repository.ErrRefreshTokenInvalid
+	}
+
+	accessToken, err := s.auth.GenerateAccessToken(user, sessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &Tokens{
+		SessionID:    sessionID,
+		AccessToken:  accessToken,
+		RefreshToken: next,
+	}, user, nil
+}
+
+// IsSessionActive reports whether a session is neither revoked nor expired
+// and its user is active
+func (s *Service) IsSessionActive(ctx context.Context, sessionID string) (bool, error) {
+	return s.sessionRepo.IsActive(ctx, sessionID)
+}
+
+// List returns a user's active sessions
+func (s *Service) List(ctx context.Context, userID string) ([]*model.Session, error) {
+	return s.sessionRepo.ListActive(ctx, userID)
+}
+
+// Revoke revokes one of a user's sessions for reason
+func (s *Service) Revoke(ctx context.Context, userID, sessionID, reason string) error {
+	return s.sessionRepo.Revoke(ctx, userID, sessionID, reason)
+}
+
+// RevokeAll revokes all of a user's sessions but exceptSessionID, which may
+// be empty, for reason and returns how many were revoked
+func (s *Service) RevokeAll(ctx context.Context, userID, reason, exceptSessionID string) (int64, error) {
+	return s.sessionRepo.RevokeAll(ctx, userID, reason, exceptSessionID)
+}
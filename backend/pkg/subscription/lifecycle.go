@@ -0,0 +1,339 @@
+// Package subscription moves organizations through their subscription
+// lifecycle, resets monthly quota counters on each billing anniversary and
+// changes organization plans and quota limits.
+//
+// Status transitions, evaluated by Advance:
+//
+//	trial     -> active     trial ended with AutoRenew and a payment method
+//	trial     -> past_due   trial ended otherwise; payment is due at the trial end
+//	active    -> active     term ended with AutoRenew; renewed for the next period
+//	active    -> inactive   term ended without AutoRenew
+//	*         -> past_due   a renewal to active was declined by the payment provider
+//	past_due  -> active     a payment was received on or after the due date
+//	past_due  -> suspended  still unpaid after the grace period
+package subscription
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/config"
+	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/cstanislawski/qualifyd/pkg/repository"
+)
+
+// Notice kinds
+const (
+	NoticeTrialEnding        = "trial_ending"
+	NoticeSubscriptionEnding = "subscription_ending"
+	NoticePaymentOverdue     = "payment_overdue"
+	NoticeRenewed            = "renewed"
+	NoticeTrialExpired       = "trial_expired"
+	NoticeExpired            = "expired"
+	NoticeReactivated        = "reactivated"
+	NoticeSuspended          = "suspended"
+	NoticePaymentFailed      = "payment_failed"
+)
+
+// ReminderDays are the days before a trial or a non-renewing subscription ends
+// on which a reminder is sent
+var ReminderDays = []int{1, 3, 7}
+
+// Notice is a subscription reminder or status change to tell an organization about
+type Notice struct {
+	Kind string
+	// DaysLeft is set on reminders: the trial or subscription ends within
+	// this many days, one of ReminderDays
+	DaysLeft int
+	// ReferenceDate is the date the notice is about, e.g. the end of the trial.
+	// Together with the kind it identifies the notice for de-duplication.
+	ReferenceDate time.Time
+	// Reminder is true for notices that are only sent when EmailReminders is set
+	Reminder bool
+}
+
+// key identifies the notice among the organization's notices for the same date
+func (n *Notice) key() string {
+	if n.DaysLeft > 0 {
+		return fmt.Sprintf("%s_%dd", n.Kind, n.DaysLeft)
+	}
+	return n.Kind
+}
+
+// Notifier delivers subscription notices to an organization
+type Notifier interface {
+	NotifySubscription(ctx context.Context, org *model.Organization, notice *Notice) error
+}
+
+// LogNotifier is a Notifier that only logs notices
+type LogNotifier struct {
+	log logger.Logger
+}
+
+// NewLogNotifier creates a new LogNotifier
+func NewLogNotifier(log logger.Logger) *LogNotifier {
+	return &LogNotifier{log: log}
+}
+
+// NotifySubscription implements Notifier
+func (n *LogNotifier) NotifySubscription(ctx context.Context, org *model.Organization, notice *Notice) error {
+	n.log.Info("Subscription notice", map[string]interface{}{
+		"organizationID": org.ID,
+		"contactEmail":   org.ContactEmail,
+		"kind":           notice.Kind,
+		"daysLeft":       notice.DaysLeft,
+		"referenceDate":  notice.ReferenceDate,
+	})
+	return nil
+}
+
+// Charger charges an organization for a renewed billing period
+type Charger interface {
+	// ChargeRenewal charges the period starting at periodStart. It returns
+	// false if the payment was declined; an error means the charge could not
+	// be attempted and should be retried, which must not charge twice.
+	ChargeRenewal(ctx context.Context, org *model.Organization, periodStart, periodEnd time.Time) (bool, error)
+}
+
+// Lifecycle runs the scheduled subscription and quota jobs
+type Lifecycle struct {
+	organizationRepo *repository.OrganizationRepository
+	quotaRepo        *repository.QuotaRepository
+	notifier         Notifier
+	charger          Charger
+	cfg              *config.SubscriptionConfig
+	log              logger.Logger
+}
+
+// NewLifecycle creates a new Lifecycle
+func NewLifecycle(
+	organizationRepo *repository.OrganizationRepository,
+	quotaRepo *repository.QuotaRepository,
+	notifier Notifier,
+	charger Charger,
+	cfg *config.SubscriptionConfig,
+	log logger.Logger,
+) *Lifecycle {
+	return &Lifecycle{
+		organizationRepo: organizationRepo,
+		quotaRepo:        quotaRepo,
+		notifier:         notifier,
+		charger:          charger,
+		cfg:              cfg,
+		log:              log,
+	}
+}
+
+// ResetQuotas starts a new billing period for every quota whose period has
+// ended, zeroing assessments_this_month and used_environment_minutes
+func (l *Lifecycle) ResetQuotas(ctx context.Context) error {
+	now := time.Now().UTC()
+	quotas, err := l.quotaRepo.ListDueForReset(ctx, now)
+	if err != nil {
+		return err
+	}
+
+	for _, quota := range quotas {
+		org, err := l.organizationRepo.GetByID(ctx, quota.OrganizationID)
+		if err != nil {
+			l.log.Error("Failed to get organization for quota reset", err, map[string]interface{}{
+				"organizationID": quota.OrganizationID,
+			})
+			continue
+		}
+
+		periodStart, periodEnd := model.BillingPeriod(org.BillingAnchor(), now)
+		reset, err := l.quotaRepo.ResetPeriod(ctx, quota, periodStart, periodEnd)
+		if err != nil {
+			l.log.Error("Failed to reset organization quota", err, map[string]interface{}{
+				"organizationID": quota.OrganizationID,
+			})
+			continue
+		}
+		if reset {
+			l.log.Info("Organization quota reset for new billing period", map[string]interface{}{
+				"organizationID":       quota.OrganizationID,
+				"periodStart":          periodStart,
+				"periodEnd":            periodEnd,
+				"assessmentsLastMonth": quota.AssessmentsThisMonth,
+				"minutesLastMonth":     quota.UsedEnvironmentMinutes,
+			})
+		}
+	}
+
+	return nil
+}
+
+// AdvanceSubscriptions applies due status transitions and sends reminders
+// for every organization with a trial, active or past due subscription
+func (l *Lifecycle) AdvanceSubscriptions(ctx context.Context) error {
+	orgs, err := l.organizationRepo.ListBySubscriptionStatus(ctx,
+		model.SubscriptionStatusTrial, model.SubscriptionStatusActive, model.SubscriptionStatusPastDue)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	for _, org := range orgs {
+		if err := l.advance(ctx, org, now); err != nil {
+			l.log.Error("Failed to advance organization subscription", err, map[string]interface{}{
+				"organizationID": org.ID,
+			})
+		}
+	}
+
+	return nil
+}
+
+// advance applies one organization's due transition and sends its notice
+func (l *Lifecycle) advance(ctx context.Context, org *model.Organization, now time.Time) error {
+	previousStatus, previousEndDate := org.SubscriptionStatus, org.SubscriptionEndDate
+
+	changed, notice := Advance(org, now, l.cfg.PastDueGracePeriod)
+	if changed && notice.Kind == NoticeRenewed && l.charger != nil && org.BillingCustomerID != "" {
+		// Charge before saving the renewal, so a charge that could not be
+		// attempted is retried on the next run with the same period
+		paid, err := l.charger.ChargeRenewal(ctx, org, org.PaymentDueDate, org.SubscriptionEndDate)
+		if err != nil {
+			return fmt.Errorf("failed to charge renewal: %w", err)
+		}
+		if !paid {
+			org.SubscriptionStatus = model.SubscriptionStatusPastDue
+			notice = &Notice{Kind: NoticePaymentFailed, ReferenceDate: org.PaymentDueDate}
+		}
+	}
+	if changed {
+		updated, err := l.organizationRepo.UpdateSubscription(ctx, org, previousStatus, previousEndDate)
+		if err != nil {
+			return err
+		}
+		if !updated {
+			// Changed concurrently, e.g. by a payment webhook; re-evaluated next run
+			return nil
+		}
+
+		l.log.Info("Organization subscription advanced", map[string]interface{}{
+			"organizationID": org.ID,
+			"from":           previousStatus,
+			"to":             org.SubscriptionStatus,
+			"endDate":        org.SubscriptionEndDate,
+		})
+	}
+
+	if notice == nil || (notice.Reminder && !org.EmailReminders) {
+		return nil
+	}
+	return l.notify(ctx, org, notice)
+}
+
+// notify sends a notice unless it was already sent
+func (l *Lifecycle) notify(ctx context.Context, org *model.Organization, notice *Notice) error {
+	claimed, err := l.organizationRepo.ClaimNotification(ctx, org.ID, notice.key(), notice.ReferenceDate)
+	if err != nil || !claimed {
+		return err
+	}
+
+	if err := l.notifier.NotifySubscription(ctx, org, notice); err != nil {
+		if releaseErr := l.organizationRepo.ReleaseNotification(ctx, org.ID, notice.key(), notice.ReferenceDate); releaseErr != nil {
+			l.log.Error("Failed to release subscription notification", releaseErr, map[string]interface{}{
+				"organizationID": org.ID,
+				"kind":           notice.Kind,
+			})
+		}
+		return fmt.Errorf("failed to send %s notice: %w", notice.Kind, err)
+	}
+
+	return nil
+}
+
+// Advance applies the transition that is due for org at now, if any, and
+// returns whether the subscription changed together with the notice to send.
+// Organizations without a subscription end date have an open-ended
+// subscription and are left alone.
+func Advance(org *model.Organization, now time.Time, pastDueGrace time.Duration) (bool, *Notice) {
+	switch org.SubscriptionStatus {
+	case model.SubscriptionStatusTrial:
+		if org.SubscriptionEndDate.IsZero() {
+			return false, nil
+		}
+		if now.Before(org.SubscriptionEndDate) {
+			return false, reminder(NoticeTrialEnding, org.SubscriptionEndDate, now)
+		}
+
+		trialEnd := org.SubscriptionEndDate
+		if org.AutoRenew && org.PaymentMethod != "" {
+			org.SubscriptionStatus = model.SubscriptionStatusActive
+			org.SubscriptionStartDate = trialEnd
+			_, org.SubscriptionEndDate = model.BillingPeriod(trialEnd, now)
+			org.PaymentDueDate = trialEnd
+			return true, &Notice{Kind: NoticeRenewed, ReferenceDate: trialEnd}
+		}
+
+		org.SubscriptionStatus = model.SubscriptionStatusPastDue
+		org.PaymentDueDate = trialEnd
+		return true, &Notice{Kind: NoticeTrialExpired, ReferenceDate: trialEnd}
+
+	case model.SubscriptionStatusActive:
+		if org.SubscriptionEndDate.IsZero() {
+			return false, nil
+		}
+		if now.Before(org.SubscriptionEndDate) {
+			if org.AutoRenew {
+				return false, nil
+			}
+			return false, reminder(NoticeSubscriptionEnding, org.SubscriptionEndDate, now)
+		}
+
+		if !org.AutoRenew {
+			endDate := org.SubscriptionEndDate
+			org.SubscriptionStatus = model.SubscriptionStatusInactive
+			return true, &Notice{Kind: NoticeExpired, ReferenceDate: endDate}
+		}
+
+		periodStart, periodEnd := model.BillingPeriod(org.BillingAnchor(), now)
+		org.SubscriptionEndDate = periodEnd
+		org.PaymentDueDate = periodStart
+		return true, &Notice{Kind: NoticeRenewed, ReferenceDate: periodStart}
+
+	case model.SubscriptionStatusPastDue:
+		dueDate := org.PaymentDueDate
+		if dueDate.IsZero() {
+			dueDate = org.SubscriptionEndDate
+		}
+		if dueDate.IsZero() {
+			return false, nil
+		}
+
+		if !org.LastPaymentDate.IsZero() && !org.LastPaymentDate.Before(dueDate) {
+			org.SubscriptionStatus = model.SubscriptionStatusActive
+			if !now.Before(org.SubscriptionEndDate) {
+				_, org.SubscriptionEndDate = model.BillingPeriod(org.BillingAnchor(), now)
+			}
+			return true, &Notice{Kind: NoticeReactivated, ReferenceDate: org.LastPaymentDate}
+		}
+
+		if !now.Before(dueDate.Add(pastDueGrace)) {
+			org.SubscriptionStatus = model.SubscriptionStatusSuspended
+			return true, &Notice{Kind: NoticeSuspended, ReferenceDate: dueDate}
+		}
+
+		return false, &Notice{Kind: NoticePaymentOverdue, ReferenceDate: dueDate, Reminder: true}
+	}
+
+	return false, nil
+}
+
+// reminder returns the reminder due before endDate, if any. The reminder is
+// for the smallest of ReminderDays not below the days left, so a reminder
+// missed while the scheduler was down is still sent on the next run.
+func reminder(kind string, endDate, now time.Time) *Notice {
+	daysLeft := int(endDate.Sub(now).Hours() / 24)
+	for _, days := range ReminderDays {
+		if daysLeft < days {
+			return &Notice{Kind: kind, DaysLeft: days, ReferenceDate: endDate, Reminder: true}
+		}
+	}
+	return nil
+}
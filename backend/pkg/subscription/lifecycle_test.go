@@ -0,0 +1,160 @@
+package subscription
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/model"
+)
+
+func TestAdvance(t *testing.T) {
+	start := time.Date(2025, 1, 31, 9, 0, 0, 0, time.UTC)
+	end := time.Date(2025, 2, 28, 9, 0, 0, 0, time.UTC)
+	grace := 14 * 24 * time.Hour
+
+	tests := []struct {
+		name        string
+		org         model.Organization
+		now         time.Time
+		wantChanged bool
+		wantStatus  string
+		wantNotice  string
+		wantEnd     time.Time
+	}{
+		{
+			name:       "trial running",
+			org:        model.Organization{SubscriptionStatus: model.SubscriptionStatusTrial, SubscriptionStartDate: start, SubscriptionEndDate: end},
+			now:        end.Add(-10 * 24 * time.Hour),
+			wantStatus: model.SubscriptionStatusTrial,
+			wantEnd:    end,
+		},
+		{
+			name:       "trial ending reminder",
+			org:        model.Organization{SubscriptionStatus: model.SubscriptionStatusTrial, SubscriptionStartDate: start, SubscriptionEndDate: end},
+			now:        end.Add(-2 * 24 * time.Hour),
+			wantStatus: model.SubscriptionStatusTrial,
+			wantNotice: NoticeTrialEnding,
+			wantEnd:    end,
+		},
+		{
+			name:        "trial converts with payment method",
+			org:         model.Organization{SubscriptionStatus: model.SubscriptionStatusTrial, SubscriptionStartDate: start, SubscriptionEndDate: end, AutoRenew: true, PaymentMethod: "card"},
+			now:         end.Add(time.Hour),
+			wantChanged: true,
+			wantStatus:  model.SubscriptionStatusActive,
+			wantNotice:  NoticeRenewed,
+			wantEnd:     time.Date(2025, 3, 28, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name:        "trial expires without payment method",
+			org:         model.Organization{SubscriptionStatus: model.SubscriptionStatusTrial, SubscriptionStartDate: start, SubscriptionEndDate: end, AutoRenew: true},
+			now:         end.Add(time.Hour),
+			wantChanged: true,
+			wantStatus:  model.SubscriptionStatusPastDue,
+			wantNotice:  NoticeTrialExpired,
+			wantEnd:     end,
+		},
+		{
+			name:        "active renews on anniversary",
+			org:         model.Organization{SubscriptionStatus: model.SubscriptionStatusActive, SubscriptionStartDate: start, SubscriptionEndDate: end, AutoRenew: true},
+			now:         end.Add(time.Hour),
+			wantChanged: true,
+			wantStatus:  model.SubscriptionStatusActive,
+			wantNotice:  NoticeRenewed,
+			wantEnd:     time.Date(2025, 3, 31, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name:        "active expires without auto renew",
+			org:         model.Organization{SubscriptionStatus: model.SubscriptionStatusActive, SubscriptionStartDate: start, SubscriptionEndDate: end},
+			now:         end.Add(time.Hour),
+			wantChanged: true,
+			wantStatus:  model.SubscriptionStatusInactive,
+			wantNotice:  NoticeExpired,
+			wantEnd:     end,
+		},
+		{
+			name:       "active without end date is open ended",
+			org:        model.Organization{SubscriptionStatus: model.SubscriptionStatusActive},
+			now:        end,
+			wantStatus: model.SubscriptionStatusActive,
+		},
+		{
+			name:       "past due within grace",
+			org:        model.Organization{SubscriptionStatus: model.SubscriptionStatusPastDue, SubscriptionEndDate: end, PaymentDueDate: end},
+			now:        end.Add(24 * time.Hour),
+			wantStatus: model.SubscriptionStatusPastDue,
+			wantNotice: NoticePaymentOverdue,
+			wantEnd:    end,
+		},
+		{
+			name:        "past due suspended after grace",
+			org:         model.Organization{SubscriptionStatus: model.SubscriptionStatusPastDue, SubscriptionEndDate: end, PaymentDueDate: end},
+			now:         end.Add(grace),
+			wantChanged: true,
+			wantStatus:  model.SubscriptionStatusSuspended,
+			wantNotice:  NoticeSuspended,
+			wantEnd:     end,
+		},
+		{
+			name:        "past due paid",
+			org:         model.Organization{SubscriptionStatus: model.SubscriptionStatusPastDue, SubscriptionStartDate: start, SubscriptionEndDate: end, PaymentDueDate: end, LastPaymentDate: end.Add(time.Hour)},
+			now:         end.Add(2 * time.Hour),
+			wantChanged: true,
+			wantStatus:  model.SubscriptionStatusActive,
+			wantNotice:  NoticeReactivated,
+			wantEnd:     time.Date(2025, 3, 31, 9, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			org := tt.org
+			changed, notice := Advance(&org, tt.now, grace)
+
+			if changed != tt.wantChanged {
+				t.Errorf("Expected changed %v, got %v", tt.wantChanged, changed)
+			}
+			if org.SubscriptionStatus != tt.wantStatus {
+				t.Errorf("Expected status %q, got %q", tt.wantStatus, org.SubscriptionStatus)
+			}
+			if !org.SubscriptionEndDate.Equal(tt.wantEnd) {
+				t.Errorf("Expected end date %v, got %v", tt.wantEnd, org.SubscriptionEndDate)
+			}
+
+			gotNotice := ""
+			if notice != nil {
+				gotNotice = notice.Kind
+			}
+			if gotNotice != tt.wantNotice {
+				t.Errorf("Expected notice %q, got %q", tt.wantNotice, gotNotice)
+			}
+		})
+	}
+}
+
+func TestReminderBuckets(t *testing.T) {
+	end := time.Date(2025, 6, 30, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		daysBefore float64
+		want       int
+	}{
+		{10, 0},
+		{6.5, 7},
+		{2.5, 3},
+		{0.5, 1},
+	}
+
+	for _, tt := range tests {
+		now := end.Add(-time.Duration(tt.daysBefore * float64(24*time.Hour)))
+		notice := reminder(NoticeTrialEnding, end, now)
+
+		got := 0
+		if notice != nil {
+			got = notice.DaysLeft
+		}
+		if got != tt.want {
+			t.Errorf("%.1f days before end: expected %d-day reminder, got %d", tt.daysBefore, tt.want, got)
+		}
+	}
+}
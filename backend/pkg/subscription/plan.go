@@ -0,0 +1,220 @@
+package subscription
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/cstanislawski/qualifyd/pkg/database"
+	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/cstanislawski/qualifyd/pkg/repository"
+	"github.com/jackc/pgx/v5"
+)
+
+// Plan change errors
+var (
+	ErrInvalidPlan                = errors.New("invalid subscription plan")
+	ErrPlanUnchanged              = errors.New("organization is already on this plan")
+	ErrOverridesRequireEnterprise = errors.New("quota overrides require the enterprise plan")
+)
+
+// OverageError is returned when the organization's current usage is above the
+// limits of the plan it is moving to and the change was not confirmed
+type OverageError struct {
+	Plan     string
+	Overages []model.QuotaOverage
+}
+
+// Error implements the error interface
+func (e *OverageError) Error() string {
+	return fmt.Sprintf("usage of %d resources is above the %s plan limits", len(e.Overages), e.Plan)
+}
+
+// PlanCharger bills an organization for a plan change before it is applied
+type PlanCharger interface {
+	ChargePlanChange(ctx context.Context, org *model.Organization, plan string) (*model.BillingRecord, error)
+}
+
+// PlanChangeRequest describes a requested plan change
+type PlanChangeRequest struct {
+	Plan string
+	// AllowOverages confirms the change even if current usage is above the
+	// new limits. Existing resources are kept, but new ones are refused until
+	// usage drops below the limits.
+	AllowOverages bool
+	ChangedBy     string
+	Note          string
+}
+
+// PlanService changes organization plans and quota overrides, recomputing
+// quota limits and recording every change in the quota change audit trail
+type PlanService struct {
+	db               *database.Database
+	organizationRepo *repository.OrganizationRepository
+	quotaRepo        *repository.QuotaRepository
+	charger          PlanCharger
+	log              logger.Logger
+}
+
+// NewPlanService creates a new PlanService. charger may be nil, in which case
+// plan changes are not billed.
+func NewPlanService(
+	db *database.Database,
+	organizationRepo *repository.OrganizationRepository,
+	quotaRepo *repository.QuotaRepository,
+	charger PlanCharger,
+	log logger.Logger,
+) *PlanService {
+	return &PlanService{
+		db:               db,
+		organizationRepo: organizationRepo,
+		quotaRepo:        quotaRepo,
+		charger:          charger,
+		log:              log,
+	}
+}
+
+// Limits returns the quota limits the organization gets on plan, including
+// its overrides if plan is the enterprise plan
+func (s *PlanService) Limits(ctx context.Context, organizationID, plan string) (model.QuotaLimits, error) {
+	if plan != model.OrgPlanEnterprise {
+		return model.PlanLimits(plan), nil
+	}
+
+	overrides, err := s.quotaRepo.GetOverrides(ctx, organizationID)
+	if err != nil && !errors.Is(err, database.ErrRecordNotFound) {
+		return model.QuotaLimits{}, err
+	}
+	return model.EffectiveLimits(plan, overrides), nil
+}
+
+// ChangePlan moves the organization to another plan and applies the plan's
+// limits. It returns an *OverageError if usage is above the new limits and
+// the request does not allow it. The plan change is billed first and not
+// applied if the charge fails. The returned billing record is nil if nothing
+// was charged.
+func (s *PlanService) ChangePlan(ctx context.Context, org *model.Organization, req PlanChangeRequest) (*model.QuotaChange, *model.BillingRecord, error) {
+	if !model.IsValidPlan(req.Plan) {
+		return nil, nil, ErrInvalidPlan
+	}
+	if req.Plan == org.SubscriptionPlan {
+		return nil, nil, ErrPlanUnchanged
+	}
+
+	limits, err := s.Limits(ctx, org.ID, req.Plan)
+	if err != nil {
+		return nil, nil, err
+	}
+	quota, err := s.quotaRepo.GetByOrganization(ctx, org.ID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get organization quota: %w", err)
+	}
+
+	overages := quota.Overages(limits)
+	if len(overages) > 0 && !req.AllowOverages {
+		return nil, nil, &OverageError{Plan: req.Plan, Overages: overages}
+	}
+
+	var record *model.BillingRecord
+	if s.charger != nil {
+		if record, err = s.charger.ChargePlanChange(ctx, org, req.Plan); err != nil {
+			return nil, record, err
+		}
+	}
+
+	change := &model.QuotaChange{
+		OrganizationID: org.ID,
+		Type:           model.QuotaChangePlan,
+		FromPlan:       org.SubscriptionPlan,
+		ToPlan:         req.Plan,
+		PreviousLimits: quota.Limits(),
+		NewLimits:      limits,
+		Overages:       overages,
+		ChangedBy:      req.ChangedBy,
+		Note:           req.Note,
+	}
+	if record != nil {
+		change.BillingRecordID = record.ID
+	}
+	if err := s.organizationRepo.ChangePlan(ctx, change); err != nil {
+		return nil, record, err
+	}
+
+	s.log.Info("Organization plan changed", map[string]interface{}{
+		"organizationID": org.ID,
+		"from":           change.FromPlan,
+		"to":             change.ToPlan,
+		"overages":       len(overages),
+		"changedBy":      req.ChangedBy,
+	})
+	org.SubscriptionPlan = req.Plan
+	return change, record, nil
+}
+
+// SetOverrides replaces the custom quota limits of an enterprise
+// organization and applies them to its quota
+func (s *PlanService) SetOverrides(ctx context.Context, org *model.Organization, overrides *model.QuotaOverrides) (*model.QuotaChange, error) {
+	if !org.IsEnterprise() {
+		return nil, ErrOverridesRequireEnterprise
+	}
+	overrides.OrganizationID = org.ID
+	return s.updateOverrides(ctx, org, overrides, overrides.UpdatedBy, overrides.Note)
+}
+
+// ClearOverrides removes the organization's custom quota limits and restores
+// its plan's default limits
+func (s *PlanService) ClearOverrides(ctx context.Context, org *model.Organization, changedBy string) (*model.QuotaChange, error) {
+	return s.updateOverrides(ctx, org, nil, changedBy, "Quota overrides removed")
+}
+
+// updateOverrides saves overrides, or deletes them if nil, and applies the
+// resulting limits while holding the quota row lock
+func (s *PlanService) updateOverrides(ctx context.Context, org *model.Organization, overrides *model.QuotaOverrides, changedBy, note string) (*model.QuotaChange, error) {
+	var change *model.QuotaChange
+	err := s.db.WithTx(ctx, func(tx pgx.Tx) error {
+		quotaRepo := s.quotaRepo.WithTx(tx)
+		quota, err := quotaRepo.GetForUpdate(ctx, org.ID)
+		if err != nil {
+			return fmt.Errorf("failed to get organization quota: %w", err)
+		}
+
+		if overrides == nil {
+			err = quotaRepo.DeleteOverrides(ctx, org.ID)
+		} else {
+			err = quotaRepo.SaveOverrides(ctx, overrides)
+		}
+		if err != nil {
+			return err
+		}
+
+		limits := model.EffectiveLimits(org.SubscriptionPlan, overrides)
+		if err := quotaRepo.ApplyLimits(ctx, org.ID, limits); err != nil {
+			return err
+		}
+
+		change = &model.QuotaChange{
+			OrganizationID: org.ID,
+			Type:           model.QuotaChangeOverride,
+			FromPlan:       org.SubscriptionPlan,
+			ToPlan:         org.SubscriptionPlan,
+			PreviousLimits: quota.Limits(),
+			NewLimits:      limits,
+			Overages:       quota.Overages(limits),
+			ChangedBy:      changedBy,
+			Note:           note,
+		}
+		return quotaRepo.CreateChange(ctx, change)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.log.Info("Organization quota overrides updated", map[string]interface{}{
+		"organizationID": org.ID,
+		"cleared":        overrides == nil,
+		"overages":       len(change.Overages),
+		"changedBy":      changedBy,
+	})
+	return change, nil
+}
@@ -0,0 +1,200 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/config"
+	"github.com/cstanislawski/qualifyd/pkg/database"
+	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/cstanislawski/qualifyd/pkg/repository"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// userAgent identifies webhook requests
+const userAgent = "Qualifyd-Webhooks/1.0"
+
+// ErrInternalAddress is returned for requests to endpoints that resolve to
+// an address webhooks are not sent to
+var ErrInternalAddress = errors.New("endpoint resolves to an internal address")
+
+// Deliverer consumes the deliveries queue and sends each delivery to its
+// subscription's endpoint
+type Deliverer struct {
+	webhookRepo    *repository.WebhookRepository
+	queues         *Queues
+	client         *http.Client
+	maxAttempts    int
+	retryBaseDelay time.Duration
+	log            logger.Logger
+}
+
+// NewDeliverer creates a new Deliverer
+func NewDeliverer(webhookRepo *repository.WebhookRepository, queues *Queues, cfg *config.WebhookConfig, log logger.Logger) *Deliverer {
+	return &Deliverer{
+		webhookRepo:    webhookRepo,
+		queues:         queues,
+		client:         newClient(cfg.Timeout),
+		maxAttempts:    cfg.MaxAttempts,
+		retryBaseDelay: cfg.RetryBaseDelay,
+		log:            log,
+	}
+}
+
+// newClient creates the client webhooks are sent with. It refuses to connect
+// to internal addresses, checked once the endpoint's name is resolved so that
+// it cannot be pointed at one later, and does not follow redirects.
+func newClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil || model.IsInternalAddress(addrPort.Addr()) {
+				return fmt.Errorf("%w: %s", ErrInternalAddress, address)
+			}
+			return nil
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// A proxy would be dialed instead of the endpoint
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// Handle makes one delivery attempt for a queued message and schedules what
+// comes next: nothing on success, a retry after the backoff delay, or the
+// dead-letter queue once the attempts are exhausted. It only returns an error,
+// making the consumer requeue the message, if the outcome could not be stored.
+func (d *Deliverer) Handle(ctx context.Context, msg amqp.Delivery) error {
+	var m message
+	if err := json.Unmarshal(msg.Body, &m); err != nil || m.DeliveryID == "" {
+		d.log.Error("Dropping malformed webhook message", err, map[string]interface{}{
+			"messageId": msg.MessageId,
+		})
+		return d.queues.DeadLetter.Publish(ctx, msg.Body, msg.ContentType, nil)
+	}
+
+	delivery, err := d.webhookRepo.GetDelivery(ctx, m.OrganizationID, m.DeliveryID)
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			// The subscription, and with it the delivery, was deleted
+			return nil
+		}
+		return err
+	}
+	if delivery.Status == model.WebhookDeliverySucceeded || delivery.Status == model.WebhookDeliveryFailed {
+		// Already settled; the message was redelivered by RabbitMQ
+		return nil
+	}
+
+	subscription, err := d.webhookRepo.GetSubscription(ctx, delivery.OrganizationID, delivery.SubscriptionID)
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	attempt := &model.WebhookAttempt{Attempt: delivery.Attempts + 1}
+	if subscription.Active {
+		d.send(ctx, subscription, delivery, attempt)
+	} else {
+		attempt.Error = "subscription is inactive"
+		attempt.CreatedAt = time.Now().UTC()
+	}
+
+	var next Publisher
+	delivery.NextAttemptAt = time.Time{}
+	switch {
+	case attempt.Succeeded():
+		delivery.Status = model.WebhookDeliverySucceeded
+		delivery.DeliveredAt = attempt.CreatedAt
+	case subscription.Active && attempt.Attempt < d.maxAttempts && d.queues.Retry[Backoff(attempt.Attempt, d.retryBaseDelay)] != nil:
+		delay := Backoff(attempt.Attempt, d.retryBaseDelay)
+		delivery.Status = model.WebhookDeliveryRetrying
+		delivery.NextAttemptAt = attempt.CreatedAt.Add(delay)
+		next = d.queues.Retry[delay]
+	default:
+		delivery.Status = model.WebhookDeliveryFailed
+		next = d.queues.DeadLetter
+	}
+
+	if err := d.webhookRepo.RecordAttempt(ctx, delivery, attempt); err != nil {
+		return err
+	}
+
+	if next != nil {
+		if err := next.Publish(ctx, msg.Body, "application/json", nil); err != nil {
+			return fmt.Errorf("failed to schedule webhook delivery: %w", err)
+		}
+	}
+
+	if delivery.Status == model.WebhookDeliveryFailed {
+		d.log.Warn("Webhook delivery failed permanently", map[string]interface{}{
+			"deliveryID":     delivery.ID,
+			"subscriptionID": delivery.SubscriptionID,
+			"organizationID": delivery.OrganizationID,
+			"attempts":       attempt.Attempt,
+			"lastError":      attempt.Error,
+			"lastStatusCode": attempt.StatusCode,
+		})
+	}
+	return nil
+}
+
+// send POSTs the delivery's payload to the subscription's endpoint and stores
+// the outcome in attempt
+func (d *Deliverer) send(ctx context.Context, subscription *model.WebhookSubscription, delivery *model.WebhookDelivery, attempt *model.WebhookAttempt) {
+	start := time.Now()
+	defer func() {
+		attempt.DurationMs = time.Since(start).Milliseconds()
+		attempt.CreatedAt = start.UTC()
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		attempt.Error = err.Error()
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set(HeaderEvent, delivery.EventType)
+	req.Header.Set(HeaderEventID, delivery.EventID)
+	req.Header.Set(HeaderDelivery, delivery.ID)
+	req.Header.Set(HeaderSignature, Sign(subscription.Secret, start, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		attempt.Error = err.Error()
+		return
+	}
+	defer resp.Body.Close()
+
+	// Only the status is kept: the response is the endpoint's, and redirects
+	// are not followed
+	attempt.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		attempt.Error = fmt.Sprintf("endpoint responded with status %d", resp.StatusCode)
+	}
+	// Drain the rest so the connection can be reused
+	io.Copy(io.Discard, resp.Body)
+}
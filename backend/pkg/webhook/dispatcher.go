@@ -0,0 +1,131 @@
+// Package webhook sends organizations signed notifications of assessment
+// events. The Dispatcher receives domain events from the event bus, records
+// one delivery per subscribed endpoint and queues it on RabbitMQ; the
+// Deliverer consumes the queue, POSTs the event and schedules retries with
+// exponential backoff.
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/events"
+	"github.com/cstanislawski/qualifyd/pkg/logger"
+	"github.com/cstanislawski/qualifyd/pkg/model"
+	"github.com/cstanislawski/qualifyd/pkg/repository"
+)
+
+// Event is the JSON body POSTed to webhook endpoints
+type Event struct {
+	ID             string      `json:"id"`
+	Type           string      `json:"type"`
+	OrganizationID string      `json:"organization_id"`
+	CreatedAt      time.Time   `json:"created_at"`
+	Data           interface{} `json:"data"`
+}
+
+// message is the body of the RabbitMQ messages referring to a delivery
+type message struct {
+	DeliveryID     string `json:"delivery_id"`
+	OrganizationID string `json:"organization_id"`
+}
+
+// Dispatcher fans events out to the organization's webhook subscriptions
+type Dispatcher struct {
+	webhookRepo *repository.WebhookRepository
+	queues      *Queues
+	log         logger.Logger
+}
+
+// NewDispatcher creates a new Dispatcher
+func NewDispatcher(webhookRepo *repository.WebhookRepository, queues *Queues, log logger.Logger) *Dispatcher {
+	return &Dispatcher{
+		webhookRepo: webhookRepo,
+		queues:      queues,
+		log:         log,
+	}
+}
+
+// Emit sends an event to every active subscription of its organization that
+// subscribed to its type. Emitting an event again only queues the deliveries
+// that were recorded but never attempted, so it is safe to retry.
+func (d *Dispatcher) Emit(ctx context.Context, event Event) error {
+	subscriptions, err := d.webhookRepo.ListSubscribed(ctx, event.OrganizationID, event.Type)
+	if err != nil {
+		return err
+	}
+	if len(subscriptions) == 0 {
+		return nil
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook event: %w", err)
+	}
+
+	var firstErr error
+	for _, s := range subscriptions {
+		delivery := &model.WebhookDelivery{
+			SubscriptionID: s.ID,
+			OrganizationID: event.OrganizationID,
+			EventID:        event.ID,
+			EventType:      event.Type,
+			Payload:        payload,
+		}
+		created, err := d.webhookRepo.CreateDelivery(ctx, delivery)
+		if err == nil && (created || (delivery.Status == model.WebhookDeliveryPending && delivery.Attempts == 0)) {
+			err = d.enqueue(ctx, delivery)
+		}
+		if err != nil {
+			d.log.Error("Failed to dispatch webhook event", err, map[string]interface{}{
+				"organizationID": event.OrganizationID,
+				"subscriptionID": s.ID,
+				"eventType":      event.Type,
+				"eventID":        event.ID,
+			})
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	return firstErr
+}
+
+// HandleEvent sends a domain event to the organization's webhooks if its type
+// is a webhook event type. It is an events.HandlerFunc; the domain event ID
+// becomes the webhook event ID, so redelivered events are not sent twice.
+func (d *Dispatcher) HandleEvent(ctx context.Context, envelope *events.Envelope, event events.Event) error {
+	if !model.IsValidWebhookEventType(envelope.Type) || envelope.OrganizationID == "" {
+		return nil
+	}
+	return d.Emit(ctx, Event{
+		ID:             envelope.ID,
+		Type:           envelope.Type,
+		OrganizationID: envelope.OrganizationID,
+		CreatedAt:      envelope.OccurredAt,
+		Data:           event,
+	})
+}
+
+// Redeliver sends a delivery again with a fresh retry schedule
+func (d *Dispatcher) Redeliver(ctx context.Context, delivery *model.WebhookDelivery) error {
+	if err := d.webhookRepo.ResetDelivery(ctx, delivery); err != nil {
+		return err
+	}
+	return d.enqueue(ctx, delivery)
+}
+
+// enqueue publishes delivery to the deliveries queue
+func (d *Dispatcher) enqueue(ctx context.Context, delivery *model.WebhookDelivery) error {
+	body, err := json.Marshal(message{DeliveryID: delivery.ID, OrganizationID: delivery.OrganizationID})
+	if err != nil {
+		return err
+	}
+	if err := d.queues.Deliveries.Publish(ctx, body, "application/json", nil); err != nil {
+		return fmt.Errorf("failed to queue webhook delivery: %w", err)
+	}
+	return nil
+}
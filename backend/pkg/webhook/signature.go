@@ -0,0 +1,98 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers set on every webhook request
+const (
+	HeaderSignature = "X-Qualifyd-Signature"
+	HeaderEvent     = "X-Qualifyd-Event"
+	HeaderEventID   = "X-Qualifyd-Event-ID"
+	HeaderDelivery  = "X-Qualifyd-Delivery"
+)
+
+// secretPrefix marks webhook signing secrets so they are recognizable when leaked
+const secretPrefix = "whsec_"
+
+// Signature verification errors
+var (
+	ErrMalformedSignature = errors.New("malformed webhook signature header")
+	ErrSignatureMismatch  = errors.New("webhook signature does not match")
+	ErrSignatureExpired   = errors.New("webhook signature timestamp outside tolerance")
+)
+
+// GenerateSecret returns a new random signing secret
+func GenerateSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+	return secretPrefix + hex.EncodeToString(b), nil
+}
+
+// Sign returns the signature header value for body sent at timestamp. It has
+// the form "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<unix seconds>.<body>">",
+// so receivers can reject replayed requests by their age.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+	return "t=" + ts + ",v1=" + computeSignature(secret, ts, body)
+}
+
+// Verify checks a signature header produced by Sign. A tolerance of zero
+// skips the timestamp check.
+func Verify(secret, header string, body []byte, tolerance time.Duration, now time.Time) error {
+	var ts string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return ErrMalformedSignature
+		}
+		switch key {
+		case "t":
+			ts = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	if ts == "" || len(signatures) == 0 {
+		return ErrMalformedSignature
+	}
+
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return ErrMalformedSignature
+	}
+	if tolerance > 0 {
+		age := now.Sub(time.Unix(unix, 0))
+		if age > tolerance || age < -tolerance {
+			return ErrSignatureExpired
+		}
+	}
+
+	expected := computeSignature(secret, ts, body)
+	for _, s := range signatures {
+		if hmac.Equal([]byte(s), []byte(expected)) {
+			return nil
+		}
+	}
+	return ErrSignatureMismatch
+}
+
+// computeSignature returns the hex HMAC-SHA256 of "<ts>.<body>"
+func computeSignature(secret, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
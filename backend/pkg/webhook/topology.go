@@ -0,0 +1,112 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/rabbitmq"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// RabbitMQ topology of webhook delivery. Domain events that can be sent to
+// webhooks are consumed from the events queue, bound to the events exchange.
+// Deliveries are published to the deliveries queue. A failed attempt is
+// parked in the retry queue of its backoff delay, whose messages expire back
+// into the deliveries queue. Deliveries that ran out of attempts end up in
+// the dead-letter queue.
+const (
+	Exchange        = "webhooks"
+	EventsQueue     = "webhooks.events"
+	DeliveriesQueue = "webhooks.deliveries"
+	DeadLetterQueue = "webhooks.dead"
+
+	deliverRoutingKey = "deliver"
+	deadRoutingKey    = "dead"
+)
+
+// Publisher publishes a message to RabbitMQ; *rabbitmq.Publisher implements it
+type Publisher interface {
+	Publish(ctx context.Context, body []byte, contentType string, headers amqp.Table) error
+}
+
+// Queues holds the publishers of the webhook delivery queues
+type Queues struct {
+	Deliveries Publisher
+	DeadLetter Publisher
+	// Retry holds the publisher of each retry queue, keyed by its delay
+	Retry map[time.Duration]Publisher
+}
+
+// Backoff returns the delay before retrying a delivery after its attempt-th
+// failed attempt: base, doubling with every further attempt
+func Backoff(attempt int, base time.Duration) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	return base << (attempt - 1)
+}
+
+// RetryDelays returns the delays of the retry queues needed for maxAttempts
+// attempts
+func RetryDelays(maxAttempts int, base time.Duration) []time.Duration {
+	delays := make([]time.Duration, 0, maxAttempts)
+	for attempt := 1; attempt < maxAttempts; attempt++ {
+		delays = append(delays, Backoff(attempt, base))
+	}
+	return delays
+}
+
+// retryQueueName returns the name of the retry queue of delay
+func retryQueueName(delay time.Duration) string {
+	return fmt.Sprintf("webhooks.retry.%ds", int64(delay/time.Second))
+}
+
+// Setup declares the webhook exchange and queues on ch and returns their
+// publishers
+func Setup(ch *rabbitmq.Channel, maxAttempts int, retryBaseDelay time.Duration) (*Queues, error) {
+	if err := ch.DeclareExchange(Exchange, amqp.ExchangeDirect, true, false, false, false, nil); err != nil {
+		return nil, err
+	}
+
+	// Messages rejected by the consumer are dead-lettered as well
+	if _, err := ch.DeclareQueue(DeliveriesQueue, true, false, false, false, amqp.Table{
+		"x-dead-letter-exchange":    Exchange,
+		"x-dead-letter-routing-key": deadRoutingKey,
+	}); err != nil {
+		return nil, err
+	}
+	if err := ch.BindQueue(DeliveriesQueue, deliverRoutingKey, Exchange, false, nil); err != nil {
+		return nil, err
+	}
+
+	if _, err := ch.DeclareQueue(DeadLetterQueue, true, false, false, false, nil); err != nil {
+		return nil, err
+	}
+	if err := ch.BindQueue(DeadLetterQueue, deadRoutingKey, Exchange, false, nil); err != nil {
+		return nil, err
+	}
+
+	queues := &Queues{
+		Deliveries: ch.NewPublisher(Exchange, deliverRoutingKey),
+		DeadLetter: ch.NewPublisher(Exchange, deadRoutingKey),
+		Retry:      make(map[time.Duration]Publisher),
+	}
+
+	for _, delay := range RetryDelays(maxAttempts, retryBaseDelay) {
+		name := retryQueueName(delay)
+		if _, err := ch.DeclareQueue(name, true, false, false, false, amqp.Table{
+			"x-message-ttl":             delay.Milliseconds(),
+			"x-dead-letter-exchange":    Exchange,
+			"x-dead-letter-routing-key": deliverRoutingKey,
+		}); err != nil {
+			return nil, err
+		}
+		if err := ch.BindQueue(name, name, Exchange, false, nil); err != nil {
+			return nil, err
+		}
+		queues.Retry[delay] = ch.NewPublisher(Exchange, name)
+	}
+
+	return queues, nil
+}
@@ -0,0 +1,144 @@
+package webhook
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cstanislawski/qualifyd/pkg/model"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	body := []byte(`{"id":"evt"}`)
+	now := time.Unix(1700000000, 0)
+	header := Sign("whsec_test", now, body)
+
+	if err := Verify("whsec_test", header, body, 5*time.Minute, now.Add(time.Minute)); err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if err := Verify("whsec_other", header, body, 0, now); err != ErrSignatureMismatch {
+		t.Errorf("Verify() with wrong secret error = %v, want %v", err, ErrSignatureMismatch)
+	}
+	if err := Verify("whsec_test", header, []byte(`{"id":"evt2"}`), 0, now); err != ErrSignatureMismatch {
+		t.Errorf("Verify() with tampered body error = %v, want %v", err, ErrSignatureMismatch)
+	}
+	if err := Verify("whsec_test", header, body, 5*time.Minute, now.Add(10*time.Minute)); err != ErrSignatureExpired {
+		t.Errorf("Verify() of old signature error = %v, want %v", err, ErrSignatureExpired)
+	}
+	if err := Verify("whsec_test", "v1=abc", body, 0, now); err != ErrMalformedSignature {
+		t.Errorf("Verify() without timestamp error = %v, want %v", err, ErrMalformedSignature)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{0, time.Minute},
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{4, 8 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := Backoff(tt.attempt, time.Minute); got != tt.want {
+			t.Errorf("Backoff(%d) = %v, want %v", tt.attempt, got, tt.want)
+		}
+	}
+
+	delays := RetryDelays(4, time.Minute)
+	if len(delays) != 3 || delays[2] != 4*time.Minute {
+		t.Errorf("RetryDelays(4) = %v, want [1m 2m 4m]", delays)
+	}
+	if name := retryQueueName(2 * time.Minute); name != "webhooks.retry.120s" {
+		t.Errorf("retryQueueName() = %q", name)
+	}
+}
+
+func TestDelivererSend(t *testing.T) {
+	subscription := &model.WebhookSubscription{Secret: "whsec_test", Active: true}
+	delivery := &model.WebhookDelivery{
+		ID:        "delivery-1",
+		EventID:   "event-1",
+		EventType: model.WebhookEventAssessmentCompleted,
+		Payload:   []byte(`{"type":"assessment.completed"}`),
+	}
+
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := Verify(subscription.Secret, r.Header.Get(HeaderSignature), body, time.Minute, time.Now()); err != nil {
+			t.Errorf("request signature: %v", err)
+		}
+		if r.Header.Get(HeaderEvent) != delivery.EventType || r.Header.Get(HeaderDelivery) != delivery.ID {
+			t.Errorf("unexpected event headers: %v", r.Header)
+		}
+		w.WriteHeader(status)
+		w.Write([]byte("nope"))
+	}))
+	defer server.Close()
+	subscription.URL = server.URL
+
+	d := &Deliverer{client: server.Client()}
+
+	attempt := &model.WebhookAttempt{Attempt: 1}
+	d.send(t.Context(), subscription, delivery, attempt)
+	if !attempt.Succeeded() {
+		t.Errorf("attempt = %+v, want success", attempt)
+	}
+
+	status = http.StatusInternalServerError
+	attempt = &model.WebhookAttempt{Attempt: 2}
+	d.send(t.Context(), subscription, delivery, attempt)
+	if attempt.Succeeded() || attempt.StatusCode != http.StatusInternalServerError || attempt.Error == "" {
+		t.Errorf("attempt = %+v, want failure with status 500", attempt)
+	}
+	if strings.Contains(attempt.Error, "nope") {
+		t.Errorf("attempt error %q keeps the response body", attempt.Error)
+	}
+}
+
+func TestDelivererRefusesInternalAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request reached an endpoint on the loopback address")
+	}))
+	defer server.Close()
+
+	// Validate would refuse the URL; a name resolving to it would not be
+	subscription := &model.WebhookSubscription{URL: server.URL, Secret: "whsec_test", Active: true}
+	delivery := &model.WebhookDelivery{ID: "delivery-1", Payload: []byte(`{}`)}
+
+	d := &Deliverer{client: newClient(time.Second)}
+	attempt := &model.WebhookAttempt{Attempt: 1}
+	d.send(t.Context(), subscription, delivery, attempt)
+	if attempt.Succeeded() || !strings.Contains(attempt.Error, ErrInternalAddress.Error()) {
+		t.Errorf("attempt = %+v, want refusal", attempt)
+	}
+}
+
+func TestDelivererDoesNotFollowRedirects(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			t.Errorf("redirect to %s was followed", r.URL.Path)
+		}
+		http.Redirect(w, r, "/elsewhere", http.StatusFound)
+	}))
+	defer server.Close()
+
+	subscription := &model.WebhookSubscription{URL: server.URL, Secret: "whsec_test", Active: true}
+	delivery := &model.WebhookDelivery{ID: "delivery-1", Payload: []byte(`{}`)}
+
+	// The test server is on the loopback address, so keep the default dialer
+	client := newClient(time.Second)
+	client.Transport = server.Client().Transport
+	d := &Deliverer{client: client}
+
+	attempt := &model.WebhookAttempt{Attempt: 1}
+	d.send(t.Context(), subscription, delivery, attempt)
+	if attempt.Succeeded() || attempt.StatusCode != http.StatusFound {
+		t.Errorf("attempt = %+v, want failure with status 302", attempt)
+	}
+}